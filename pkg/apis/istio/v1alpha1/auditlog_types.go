@@ -0,0 +1,124 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AuditLogEntry records one change istiooperator.AuditReconciler observed
+// to an IstioOperator's spec.
+type AuditLogEntry struct {
+	// Timestamp is when AuditReconciler recorded this entry, not when the
+	// spec change was made to the apiserver — the two can differ by however
+	// long the change sat unreconciled.
+	Timestamp metav1.Time `json:"timestamp"`
+
+	// Generation is the IstioOperator's metadata.generation at the time of
+	// this entry, so two entries are never mistaken for describing the same
+	// change, and so AuditReconciler can tell whether it's already recorded
+	// the current generation without re-diffing the spec.
+	Generation int64 `json:"generation"`
+
+	// Diff is istiooperator.DiffSpec's output describing what changed.
+	Diff string `json:"diff"`
+
+	// User is the manager that last applied the change, read from the
+	// IstioOperator's metadata.managedFields[0].manager. Empty if the
+	// object has no managed fields entries.
+	User string `json:"user,omitempty"`
+}
+
+// AuditLogStatus mirrors the entries istiooperator.AuditReconciler keeps in
+// the "istio-operator-audit-log" ConfigMap, capped at the same
+// istiooperator.MaxAuditLogEntries.
+type AuditLogStatus struct {
+	Entries []AuditLogEntry `json:"entries,omitempty"`
+}
+
+// AuditLog is the Schema for the auditlogs API: a read-only mirror of the
+// "istio-operator-audit-log" ConfigMap istiooperator.AuditReconciler
+// maintains, so a client can be granted get/list/watch on AuditLog instead
+// of on ConfigMap generally when it only needs this one ConfigMap's
+// contents. AuditLog has no Spec: nothing creates or edits one directly, so
+// there's nothing for a user to specify — AuditReconciler is the only
+// writer, and it writes only Status.
+type AuditLog struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status AuditLogStatus `json:"status,omitempty"`
+}
+
+// AuditLogList contains a list of AuditLog.
+type AuditLogList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AuditLog `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object, letting *AuditLog be used with
+// a controller-runtime client.
+func (in *AuditLog) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopy returns a deep copy of in, or nil if in is nil.
+func (in *AuditLog) DeepCopy() *AuditLog {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditLog)
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Status.Entries != nil {
+		out.Status.Entries = make([]AuditLogEntry, len(in.Status.Entries))
+		for i := range in.Status.Entries {
+			entry := in.Status.Entries[i]
+			entry.Timestamp = *in.Status.Entries[i].Timestamp.DeepCopy()
+			out.Status.Entries[i] = entry
+		}
+	}
+	return out
+}
+
+// DeepCopyObject implements runtime.Object, letting *AuditLogList be used
+// with a controller-runtime client.
+func (in *AuditLogList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopy returns a deep copy of in, or nil if in is nil.
+func (in *AuditLogList) DeepCopy() *AuditLogList {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditLogList)
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]AuditLog, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// DeepCopyInto copies in into out.
+func (in *AuditLog) DeepCopyInto(out *AuditLog) {
+	*out = *in.DeepCopy()
+}
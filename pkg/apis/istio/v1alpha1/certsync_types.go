@@ -0,0 +1,151 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// CertSyncDestination names one namespace a CertSync projects its source
+// Secret's data into.
+type CertSyncDestination struct {
+	// Namespace to copy the source Secret into.
+	Namespace string `json:"namespace"`
+
+	// SecretName to give the copy in Namespace. Defaults to
+	// CertSyncSpec.SourceSecretName when empty, so the common case of
+	// keeping the same Secret name across namespaces needs no repetition.
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// CertSyncSpec describes a source Secret — typically an Istio CA's
+// "istio-ca-secret" or root cert bundle — and the namespaces
+// certsync.SecretProjector keeps a copy of it in.
+type CertSyncSpec struct {
+	// SourceNamespace is where SourceSecretName lives.
+	SourceNamespace string `json:"sourceNamespace"`
+
+	// SourceSecretName is the Secret SecretProjector reads from and
+	// projects into every entry of Destinations.
+	SourceSecretName string `json:"sourceSecretName"`
+
+	// Destinations lists every namespace (and, optionally, destination
+	// Secret name) the source Secret's data is copied into.
+	Destinations []CertSyncDestination `json:"destinations"`
+
+	// KeyFilter, if non-empty, limits the copy to these keys of the source
+	// Secret's data, e.g. ["root-cert.pem"] to project only the CA's public
+	// certificate without its private key. Empty copies every key.
+	KeyFilter []string `json:"keyFilter,omitempty"`
+}
+
+// CertSyncStatus reports what certsync.SecretProjector has last observed
+// and synced for a CertSync.
+type CertSyncStatus struct {
+	// ObservedNotAfter is the source Secret's certificate expiry last seen
+	// by SecretProjector, read from its "tls.crt" data key. SecretProjector
+	// records it on every reconcile but does not compare against it: it
+	// re-copies the source Secret's data on every reconcile regardless, so
+	// a rotation propagates even when Destinations hasn't changed. Zero
+	// until a source Secret carrying "tls.crt" has been read at least once.
+	ObservedNotAfter metav1.Time `json:"observedNotAfter,omitempty"`
+
+	// LastSyncTime is when SecretProjector last copied the source Secret's
+	// data to every entry in Destinations.
+	LastSyncTime metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// SyncedDestinations is how many Destinations entries were successfully
+	// copied to on the last reconcile.
+	SyncedDestinations int `json:"syncedDestinations,omitempty"`
+}
+
+// CertSync is the Schema for the certsyncs API: one instance per source
+// Secret that certsync.SecretProjector keeps projected into one or more
+// other namespaces, e.g. an Istio CA's root certificate that application
+// namespaces need for mTLS verification but that isn't itself created in
+// those namespaces.
+type CertSync struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CertSyncSpec   `json:"spec,omitempty"`
+	Status CertSyncStatus `json:"status,omitempty"`
+}
+
+// CertSyncList contains a list of CertSync.
+type CertSyncList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CertSync `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object, letting *CertSync be used with
+// a controller-runtime client.
+func (in *CertSync) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopy returns a deep copy of in, or nil if in is nil.
+func (in *CertSync) DeepCopy() *CertSync {
+	if in == nil {
+		return nil
+	}
+	out := new(CertSync)
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec.SourceNamespace = in.Spec.SourceNamespace
+	out.Spec.SourceSecretName = in.Spec.SourceSecretName
+	if in.Spec.Destinations != nil {
+		out.Spec.Destinations = make([]CertSyncDestination, len(in.Spec.Destinations))
+		copy(out.Spec.Destinations, in.Spec.Destinations)
+	}
+	if in.Spec.KeyFilter != nil {
+		out.Spec.KeyFilter = make([]string, len(in.Spec.KeyFilter))
+		copy(out.Spec.KeyFilter, in.Spec.KeyFilter)
+	}
+	out.Status.ObservedNotAfter = *in.Status.ObservedNotAfter.DeepCopy()
+	out.Status.LastSyncTime = *in.Status.LastSyncTime.DeepCopy()
+	out.Status.SyncedDestinations = in.Status.SyncedDestinations
+	return out
+}
+
+// DeepCopyObject implements runtime.Object, letting *CertSyncList be used
+// with a controller-runtime client.
+func (in *CertSyncList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopy returns a deep copy of in, or nil if in is nil.
+func (in *CertSyncList) DeepCopy() *CertSyncList {
+	if in == nil {
+		return nil
+	}
+	out := new(CertSyncList)
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]CertSync, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// DeepCopyInto copies in into out.
+func (in *CertSync) DeepCopyInto(out *CertSync) {
+	*out = *in.DeepCopy()
+}
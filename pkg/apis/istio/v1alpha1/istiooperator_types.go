@@ -0,0 +1,709 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// IstioOperatorSpec is the user-supplied configuration for an Istio control
+// plane installation.
+type IstioOperatorSpec struct {
+	// Profile selects the installation profile (e.g. "default", "minimal",
+	// "demo") the reconciler uses to pick a base configuration.
+	Profile string `json:"profile,omitempty"`
+
+	// Version is the Istio version to install, e.g. "1.18". Checked by
+	// istiooperator.RunUpgradePreflightChecks against its embedded version
+	// compatibility matrix before an upgrade is applied.
+	Version string `json:"version,omitempty"`
+
+	// ComponentResources maps a component name (e.g. "istiod",
+	// "istio-ingressgateway") to the resource requests/limits its containers
+	// run with. Components with no entry, or an entry with neither Requests
+	// nor Limits set, get webhook.IstioOperatorDefaulter's configured
+	// defaults injected by the defaulting webhook.
+	ComponentResources map[string]corev1.ResourceRequirements `json:"componentResources,omitempty"`
+
+	// Revision names this IstioOperator's control plane revision, letting
+	// more than one IstioOperator CR (e.g. "stable" and "canary") run in the
+	// same cluster — even the same namespace — without their resources
+	// colliding: istiooperator.ResourceName suffixes every Deployment,
+	// Service and ConfigMap this operator creates with "-<revision>", and
+	// istiooperator.RevisionLabels stamps istio.io/rev=<revision> on them so
+	// they can be listed back out by revision alone. Empty, the default,
+	// names resources exactly as before Revision existed, preserving
+	// upgrades from installations that never set it.
+	Revision string `json:"revision,omitempty"`
+
+	// CanaryNamespaces lists the namespaces istiooperator.CanaryUpgrade
+	// shifts onto a canary control plane's revision by labeling them
+	// istio.io/rev=<revision>, so only these namespaces' sidecars move to
+	// the new version while the rest of the mesh keeps talking to the
+	// existing control plane. Empty means CanaryUpgrade relabels nothing,
+	// leaving namespace migration to be done by hand.
+	CanaryNamespaces []string `json:"canaryNamespaces,omitempty"`
+
+	// WatchNamespaceSelector selects the namespaces this IstioOperator
+	// manages sidecars in, beyond its own Namespace. networkpolicy.Reconcile
+	// uses it to decide which namespaces need a NetworkPolicy admitting
+	// traffic from istiod; nil selects no namespaces, so a default-deny
+	// cluster keeps blocking istiod until this is set.
+	WatchNamespaceSelector *metav1.LabelSelector `json:"watchNamespaceSelector,omitempty"`
+
+	// Components lists the names of the Istio components (e.g. "istiod",
+	// "istio-ingressgateway") this IstioOperator installs. controller.Prune
+	// reads it to tell a component a user removed from this list apart from
+	// one a normal reconcile just hasn't recreated yet, so it can delete the
+	// former's leftover Deployment and Service without touching the latter.
+	Components []string `json:"components,omitempty"`
+
+	// ExternalControlPlane configures istiooperator.ExternalControlPlaneReconciler,
+	// which it enables when Profile is "external". Nil (the default) leaves
+	// the in-cluster istiod reconcile path, built around Components, in
+	// charge instead.
+	ExternalControlPlane *ExternalControlPlaneSpec `json:"externalControlPlane,omitempty"`
+
+	// ComponentPodDisruptionBudgets maps a component name (e.g. "istiod",
+	// "istio-ingressgateway") to a PodDisruptionBudget override for it,
+	// mirroring the shape ComponentResources already uses instead of a
+	// nested "components.<name>.k8s.podDisruptionBudget". Components with no
+	// entry get istiooperator.PDBReconciler's default of MinAvailable: 1.
+	ComponentPodDisruptionBudgets map[string]PodDisruptionBudgetSpec `json:"componentPodDisruptionBudgets,omitempty"`
+
+	// ComponentHPASpecs maps a gateway component name (e.g.
+	// "istio-ingressgateway", "istio-egressgateway") to a
+	// HorizontalPodAutoscalerSpec for it, mirroring the shape
+	// ComponentResources and ComponentPodDisruptionBudgets already use
+	// instead of a nested "components.<name>.k8s.hpaSpec".
+	// istiooperator.HPAReconciler creates or updates a
+	// HorizontalPodAutoscaler for a component with an entry here, and
+	// deletes the HorizontalPodAutoscaler once the entry is removed.
+	// Components with no entry get no HorizontalPodAutoscaler at all.
+	ComponentHPASpecs map[string]autoscalingv2.HorizontalPodAutoscalerSpec `json:"componentHPASpecs,omitempty"`
+
+	// ComponentPodAnnotations maps a component name (e.g. "istiod",
+	// "istio-ingressgateway") to annotations istiooperator.PodAnnotationsReconciler
+	// merges into that component's Deployment's pod template, mirroring the
+	// shape ComponentResources already uses instead of a nested
+	// "components.<name>.k8s.podAnnotations". The merge only ever adds or
+	// overwrites keys named here; any other annotation already on the pod
+	// template (e.g. one the Helm chart sets by default) is left alone, and
+	// a key named here wins if it collides with one.
+	ComponentPodAnnotations map[string]map[string]string `json:"componentPodAnnotations,omitempty"`
+
+	// ComponentTopologySpreadConstraints maps a component name (e.g.
+	// "istiod", "istio-ingressgateway") to the TopologySpreadConstraints
+	// istiooperator.TopologySpreadReconciler applies to that component's
+	// Deployment pod template, mirroring the shape ComponentResources
+	// already uses instead of a nested
+	// "components.<name>.k8s.topologySpreadConstraints". A component with
+	// no entry gets istiooperator.TopologySpreadReconciler's zone-spread
+	// default for "istiod" (see defaultTopologySpreadConstraint) if the
+	// cluster has nodes in more than one zone, or nothing otherwise.
+	ComponentTopologySpreadConstraints map[string][]corev1.TopologySpreadConstraint `json:"componentTopologySpreadConstraints,omitempty"`
+
+	// Security configures istiooperator.SecretReconciler's automatic
+	// rotation of Istio's self-signed CA certificate. Nil disables
+	// automatic rotation entirely.
+	Security *SecuritySpec `json:"security,omitempty"`
+
+	// MeshConfig holds the subset of Istio's mesh-wide configuration that
+	// this operator projects onto other resources rather than writing
+	// straight into ConfigMap/istio's "mesh" key the way
+	// ExternalControlPlaneReconciler and TrustDomainMigration do. Nil means
+	// none of that configuration is set.
+	MeshConfig *MeshConfigSpec `json:"meshConfig,omitempty"`
+
+	// WasmPlugins lists the Envoy WASM extensions
+	// istiooperator.WasmPluginReconciler projects onto
+	// extensions.istio.io/v1alpha1.WasmPlugin resources, one per entry. An
+	// entry removed from this list has its WasmPlugin resource deleted.
+	WasmPlugins []WasmPluginSpec `json:"wasmPlugins,omitempty"`
+
+	// MultiCluster configures istiooperator.RemoteClusterReconciler for a
+	// primary-primary multi-cluster mesh. Nil means this IstioOperator's
+	// control plane doesn't register any remote clusters.
+	MultiCluster *MultiClusterSpec `json:"multiCluster,omitempty"`
+
+	// Telemetry configures observability integrations that sit alongside
+	// MeshConfig's proxy-level telemetry settings. Nil disables all of
+	// them.
+	Telemetry *TelemetrySpec `json:"telemetry,omitempty"`
+
+	// SidecarInjection configures istiooperator.SidecarInjectionReconciler,
+	// which labels namespaces for automatic sidecar injection. Nil leaves
+	// every namespace's istio-injection label untouched.
+	SidecarInjection *SidecarInjectionSpec `json:"sidecarInjection,omitempty"`
+
+	// ResourceBudget configures istiooperator.BudgetReconciler, which caps
+	// the total CPU and memory requests summed across every component in
+	// Components. Nil leaves the control plane unbudgeted.
+	ResourceBudget *ResourceBudgetSpec `json:"resourceBudget,omitempty"`
+
+	// Ambient configures istiooperator.AmbientReconciler's ztunnel/waypoint
+	// dataplane, only acted on when Profile is "ambient". Nil leaves ambient
+	// mode entirely uninstalled even if Profile is "ambient".
+	Ambient *AmbientSpec `json:"ambient,omitempty"`
+
+	// MeshExpansion configures istiooperator.MeshExpansionReconciler, which
+	// provisions the Gateway, VirtualService, DestinationRule,
+	// istiocoredns Deployment, and cluster.env ConfigMap an on-premise VM
+	// needs to join the mesh. Nil leaves mesh expansion entirely
+	// uninstalled.
+	MeshExpansion *MeshExpansionSpec `json:"meshExpansion,omitempty"`
+}
+
+// MeshExpansionSpec configures istiooperator.MeshExpansionReconciler.
+type MeshExpansionSpec struct {
+	// Enabled turns mesh expansion on; false (the default) leaves every
+	// resource istiooperator.MeshExpansionReconciler would otherwise manage
+	// absent, and deletes them if they were created by a previous reconcile
+	// that had this set to true.
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// AmbientSpec configures istiooperator.AmbientReconciler.
+type AmbientSpec struct {
+	// Namespaces lists the namespaces istiooperator.AmbientReconciler labels
+	// for the ambient dataplane (ztunnel traffic redirection), mirroring the
+	// shape SidecarInjectionSpec.EnabledNamespaces already uses. A namespace
+	// listed here and in SidecarInjectionSpec.EnabledNamespaces is rejected
+	// by webhook.ValidateSpec: a namespace can run the sidecar dataplane or
+	// the ambient dataplane, not both.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// ResourceBudgetSpec caps the total resource requests
+// istiooperator.BudgetCalculator sums across IstioOperatorSpec.Components'
+// entries in ComponentResources.
+type ResourceBudgetSpec struct {
+	// MaxCPU caps the sum of every enabled component's CPU requests. The
+	// zero value disables the CPU check.
+	MaxCPU resource.Quantity `json:"maxCPU,omitempty"`
+
+	// MaxMemory caps the sum of every enabled component's memory requests.
+	// The zero value disables the memory check.
+	MaxMemory resource.Quantity `json:"maxMemory,omitempty"`
+}
+
+// SidecarInjectionSpec configures istiooperator.SidecarInjectionReconciler.
+type SidecarInjectionSpec struct {
+	// EnabledNamespaces lists namespaces SidecarInjectionReconciler labels
+	// istio-injection: enabled.
+	EnabledNamespaces []string `json:"enabledNamespaces,omitempty"`
+
+	// DisabledNamespaces lists namespaces SidecarInjectionReconciler labels
+	// istio-injection: disabled.
+	DisabledNamespaces []string `json:"disabledNamespaces,omitempty"`
+}
+
+// TelemetrySpec configures observability integrations
+// istiooperator.PrometheusMonitorReconciler and
+// istiooperator.GrafanaDashboardReconciler act on.
+type TelemetrySpec struct {
+	// PrometheusOperatorIntegration enables
+	// istiooperator.PrometheusMonitorReconciler, which creates a
+	// ServiceMonitor and PodMonitor for each component in
+	// IstioOperatorSpec.Components when the Prometheus Operator's CRDs are
+	// installed on the cluster. False (the default) creates neither.
+	PrometheusOperatorIntegration bool `json:"prometheusOperatorIntegration,omitempty"`
+
+	// Grafana configures istiooperator.GrafanaDashboardReconciler. Nil
+	// disables it.
+	Grafana *GrafanaSpec `json:"grafana,omitempty"`
+}
+
+// GrafanaSpec configures istiooperator.GrafanaDashboardReconciler.
+type GrafanaSpec struct {
+	// AutoProvision enables istiooperator.GrafanaDashboardReconciler, which
+	// creates a ConfigMap per official Istio dashboard in Namespace. False
+	// (the default) creates none.
+	AutoProvision bool `json:"autoProvision,omitempty"`
+
+	// Namespace is where the dashboard ConfigMaps are created. Defaults to
+	// "istio-system" when empty, the same default every other
+	// namespace-configurable field in this package uses.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// MultiClusterSpec configures istiooperator.RemoteClusterReconciler.
+type MultiClusterSpec struct {
+	// Remotes lists the other clusters in the mesh this control plane
+	// should register a remote secret for.
+	Remotes []RemoteClusterSpec `json:"remotes,omitempty"`
+}
+
+// RemoteClusterSpec identifies one remote cluster
+// istiooperator.RemoteClusterReconciler registers with this control plane.
+type RemoteClusterSpec struct {
+	// Name identifies this cluster among MultiClusterSpec.Remotes and
+	// IstioOperatorStatus.RemoteClusters, and names the
+	// "istio-remote-secret-<Name>" Secret RemoteClusterReconciler creates
+	// for it.
+	Name string `json:"name"`
+
+	// SecretRef names a Secret, in the IstioOperator's own namespace,
+	// holding a kubeconfig for Name's API server under a data key matching
+	// Name (falling back to its only data key if it has exactly one).
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// MeshConfigSpec is the subset of Istio's mesh config IstioOperatorSpec
+// exposes as a typed field instead of leaving entirely to ConfigMap/istio's
+// "mesh" key.
+type MeshConfigSpec struct {
+	// DefaultConfig holds the proxy configuration applied to every sidecar
+	// in the mesh that doesn't override it itself.
+	DefaultConfig *ProxyConfigSpec `json:"defaultConfig,omitempty"`
+}
+
+// ProxyConfigSpec is the subset of Istio's per-proxy configuration
+// MeshConfigSpec exposes.
+type ProxyConfigSpec struct {
+	// ProxyStatsMatcher configures istiooperator.TelemetryReconciler, which
+	// projects it onto a telemetry.istio.io/v1alpha1 Telemetry resource. Nil
+	// leaves Envoy's default stats set alone.
+	ProxyStatsMatcher *ProxyStatsMatcherSpec `json:"proxyStatsMatcher,omitempty"`
+}
+
+// ProxyStatsMatcherSpec selects which Envoy stats a proxy reports, mirroring
+// Istio's own ProxyConfig.ProxyStatsMatcher
+// (https://istio.io/latest/docs/reference/config/istio.mesh.v1alpha1/#ProxyConfig-ProxyStatsMatcher).
+type ProxyStatsMatcherSpec struct {
+	// InclusionPrefixes additionally includes Envoy stats whose name starts
+	// with one of these prefixes.
+	InclusionPrefixes []string `json:"inclusionPrefixes,omitempty"`
+	// InclusionSuffixes additionally includes Envoy stats whose name ends
+	// with one of these suffixes.
+	InclusionSuffixes []string `json:"inclusionSuffixes,omitempty"`
+	// InclusionRegexps additionally includes Envoy stats whose name matches
+	// one of these regular expressions.
+	InclusionRegexps []string `json:"inclusionRegexps,omitempty"`
+}
+
+// WasmPluginSpec configures one Envoy WASM extension
+// istiooperator.WasmPluginReconciler projects onto a WasmPlugin resource.
+type WasmPluginSpec struct {
+	// Name identifies this entry among IstioOperatorSpec.WasmPlugins and
+	// names the WasmPlugin resource WasmPluginReconciler creates for it, via
+	// istiooperator.ResourceName the same way a component name does.
+	Name string `json:"name"`
+
+	// URL is the location WasmPluginReconciler projects onto the WasmPlugin
+	// resource's spec.url, e.g.
+	// "oci://registry.example.com/plugins/my-filter:1.0".
+	URL string `json:"url"`
+
+	// PullSecret names a Secret, in the IstioOperator's own namespace,
+	// holding credentials for URL's registry, projected onto the WasmPlugin
+	// resource's spec.imagePullSecret. Empty pulls URL anonymously.
+	PullSecret corev1.LocalObjectReference `json:"pullSecret,omitempty"`
+}
+
+// CertProviderCertManager is the SecuritySpec.CertProvider value that hands
+// the Istio CA certificate's issuance and rotation over to cert-manager via
+// istiooperator.CertManagerIntegration, in place of
+// istiooperator.SecretReconciler's self-signed rotation.
+const CertProviderCertManager = "cert-manager"
+
+// CertProviderVault is the SecuritySpec.CertProvider value that hands
+// workload certificate issuance over to a HashiCorp Vault PKI secrets
+// engine via istiooperator.VaultPKIIntegration, pointing
+// spec.meshConfig.caAddress at a CitadelAgent fronting Vault instead of
+// istiod's own built-in CA.
+const CertProviderVault = "vault"
+
+// SecuritySpec configures istiooperator.SecretReconciler and, built with the
+// certmanager or vaultpki build tags, istiooperator.CertManagerIntegration or
+// istiooperator.VaultPKIIntegration respectively.
+type SecuritySpec struct {
+	// CARenewBefore is how long before istio-ca-secret's certificate
+	// expires istiooperator.SecretReconciler rotates it. Zero (the
+	// default) disables automatic rotation, the same as leaving
+	// IstioOperatorSpec.Security nil. Has no effect once CertProvider is
+	// set to CertProviderCertManager or CertProviderVault: cert-manager or
+	// Vault owns issuance then, not SecretReconciler.
+	CARenewBefore metav1.Duration `json:"caRenewBefore,omitempty"`
+
+	// CertProvider selects what issues and rotates the Istio CA
+	// certificate in istio-ca-secret. Empty (the default) keeps
+	// istiooperator.SecretReconciler's self-signed rotation. Set to
+	// CertProviderCertManager to hand this over to cert-manager instead,
+	// via istiooperator.CertManagerIntegration, or to CertProviderVault to
+	// hand workload certificate issuance to a Vault PKI secrets engine via
+	// istiooperator.VaultPKIIntegration; those reconcilers are only built
+	// into a binary compiled with the matching certmanager or vaultpki
+	// build tag, so setting this without that tag is a no-op.
+	CertProvider string `json:"certProvider,omitempty"`
+
+	// CertManagerIssuerRef names the cert-manager ClusterIssuer
+	// istiooperator.CertManagerIntegration's Certificate resource
+	// references. Required, and otherwise ignored, when CertProvider is
+	// CertProviderCertManager.
+	CertManagerIssuerRef string `json:"certManagerIssuerRef,omitempty"`
+
+	// VaultAddress is the base URL of the Vault server
+	// istiooperator.VaultPKIIntegration authenticates to and issues
+	// certificates from, e.g. "https://vault.vault.svc:8200". Required,
+	// and otherwise ignored, when CertProvider is CertProviderVault.
+	VaultAddress string `json:"vaultAddress,omitempty"`
+
+	// VaultPKIMountPath is the mount path of the Vault PKI secrets engine
+	// istiooperator.VaultPKIIntegration issues the mesh's trust domain
+	// role from, e.g. "pki/istio". Required, and otherwise ignored, when
+	// CertProvider is CertProviderVault.
+	VaultPKIMountPath string `json:"vaultPKIMountPath,omitempty"`
+
+	// VaultKubernetesAuthRole is the Vault Kubernetes auth role
+	// istiooperator.VaultPKIIntegration logs in as using the operator's
+	// own ServiceAccount JWT. Required, and otherwise ignored, when
+	// CertProvider is CertProviderVault.
+	VaultKubernetesAuthRole string `json:"vaultKubernetesAuthRole,omitempty"`
+}
+
+// PodDisruptionBudgetSpec overrides istiooperator.PDBReconciler's default
+// MinAvailable: 1 for one component.
+type PodDisruptionBudgetSpec struct {
+	// MinAvailable overrides the PodDisruptionBudget's default MinAvailable
+	// of 1. Nil keeps the default.
+	MinAvailable *int32 `json:"minAvailable,omitempty"`
+}
+
+// ExternalControlPlaneSpec locates the istiod instance running outside the
+// cluster IstioOperatorSpec's other fields configure the data plane for, per
+// Istio's external control plane topology.
+type ExternalControlPlaneSpec struct {
+	// Address is the external istiod's host:port, e.g.
+	// "istiod.external.example.com:15012". istiooperator.RunUpgradePreflightChecks
+	// dials it to confirm it's reachable before ExternalControlPlaneReconciler
+	// ever points cluster workloads at it, and it becomes caAddress in
+	// ConfigMap/istio's mesh config verbatim.
+	Address string `json:"address"`
+}
+
+// IstioOperatorStatus reports the observed state of an IstioOperator's
+// reconciliation, using the standard metav1.Condition shape so kubectl and
+// other status-aware tooling render it the same way as every other
+// condition-bearing resource. See istiooperator.UpdateOperatorStatus for the
+// condition types set here.
+type IstioOperatorStatus struct {
+	// Conditions is the set of observed condition types, each keyed by its
+	// own Type so SetStatusCondition can update one without disturbing the
+	// others.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ComponentStatus reports istiooperator.CheckComponentHealth's most
+	// recent result, refreshed every reconcile, so `kubectl get` surfaces
+	// Envoy proxy sync health without needing istioctl proxy-status. Nil
+	// until the first reconcile that runs the check completes.
+	ComponentStatus *ComponentHealth `json:"componentStatus,omitempty"`
+
+	// ComponentConditions reports the most recent manifest-rendering outcome
+	// for each component, keyed by component name (e.g. "ingressGateway"),
+	// so a failure rendering one component's manifests is visible on the CR
+	// the moment it happens instead of only in operator logs. Nil until the
+	// first reconcile that renders a component's manifests completes. See
+	// istiooperator.SetComponentCondition.
+	ComponentConditions map[string]ComponentCondition `json:"componentConditions,omitempty"`
+
+	// RemoteClusters reports istiooperator.RemoteClusterReconciler's most
+	// recent connectivity check for each entry in
+	// IstioOperatorSpec.MultiCluster.Remotes, keyed by position the same way
+	// Remotes itself is ordered (RemoteClusterReconciler rebuilds this slice
+	// from scratch every reconcile, so a removed Remotes entry doesn't
+	// linger here).
+	RemoteClusters []RemoteClusterStatus `json:"remoteClusters,omitempty"`
+
+	// GatewayAddresses reports the external address (IP or hostname) of
+	// each gateway component's Service, keyed by component name (e.g.
+	// "istio-ingressgateway"), once it has one. istiooperator.MigrateServiceType
+	// sets this once a LoadBalancer Service it migrated to has been
+	// assigned an address; nothing else in this tree writes to it today.
+	GatewayAddresses map[string]string `json:"gatewayAddresses,omitempty"`
+}
+
+// RemoteClusterStatus is one cluster's entry in
+// IstioOperatorStatus.RemoteClusters.
+type RemoteClusterStatus struct {
+	// Name is the RemoteClusterSpec.Name this status is for.
+	Name string `json:"name"`
+
+	// Connected reports whether RemoteClusterReconciler's last connectivity
+	// check against this cluster's API server succeeded.
+	Connected bool `json:"connected"`
+
+	// Message gives the reason for Connected, e.g. the error returned
+	// dialing the remote API server. Empty for a connected cluster with
+	// nothing to report.
+	Message string `json:"message,omitempty"`
+
+	// LastCheckedTime is when Connected was last determined.
+	LastCheckedTime metav1.Time `json:"lastCheckedTime,omitempty"`
+}
+
+// ComponentHealth summarizes how many Envoy proxies in the mesh have synced
+// their xDS configuration from istiod, as observed via istiod's
+// /debug/syncz endpoint. See istiooperator.CheckComponentHealth.
+type ComponentHealth struct {
+	// TotalProxies is the number of proxies istiod reported a sync status
+	// for.
+	TotalProxies int `json:"totalProxies"`
+
+	// SyncedProxies is the number of those proxies whose most recent xDS
+	// push was acknowledged.
+	SyncedProxies int `json:"syncedProxies"`
+
+	// StalledProxies is the number of those proxies whose most recent xDS
+	// push was rejected or never acknowledged.
+	StalledProxies int `json:"stalledProxies"`
+}
+
+// ComponentConditionPhase is the outcome istiooperator.SetComponentCondition
+// records for a single component's most recent manifest-rendering attempt.
+type ComponentConditionPhase string
+
+const (
+	// ComponentPhaseInstalled means the component's manifests were rendered
+	// and applied successfully.
+	ComponentPhaseInstalled ComponentConditionPhase = "Installed"
+	// ComponentPhaseFailed means rendering or applying the component's
+	// manifests returned an error.
+	ComponentPhaseFailed ComponentConditionPhase = "Failed"
+	// ComponentPhasePending means the component hasn't been reconciled yet,
+	// e.g. because it was only just added to IstioOperatorSpec.Components.
+	ComponentPhasePending ComponentConditionPhase = "Pending"
+)
+
+// ComponentCondition is a single component's entry in
+// IstioOperatorStatus.ComponentConditions.
+type ComponentCondition struct {
+	// Phase is the component's most recent manifest-rendering outcome.
+	Phase ComponentConditionPhase `json:"phase"`
+
+	// Message gives the reason for Phase, e.g. the error returned while
+	// rendering a Failed component's manifests. Empty for an Installed
+	// component with nothing to report.
+	Message string `json:"message,omitempty"`
+
+	// LastTransitionTime is when Phase last changed for this component.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// IstioOperator is the Schema for the istiooperators API.
+type IstioOperator struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IstioOperatorSpec   `json:"spec,omitempty"`
+	Status IstioOperatorStatus `json:"status,omitempty"`
+}
+
+// IstioOperatorList contains a list of IstioOperator.
+type IstioOperatorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IstioOperator `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object, letting *IstioOperator be used
+// with a controller-runtime client.
+func (in *IstioOperator) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopy returns a deep copy of in, or nil if in is nil.
+func (in *IstioOperator) DeepCopy() *IstioOperator {
+	if in == nil {
+		return nil
+	}
+	out := new(IstioOperator)
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec.Profile = in.Spec.Profile
+	out.Spec.Version = in.Spec.Version
+	out.Spec.Revision = in.Spec.Revision
+	if in.Spec.ComponentResources != nil {
+		out.Spec.ComponentResources = make(map[string]corev1.ResourceRequirements, len(in.Spec.ComponentResources))
+		for k, v := range in.Spec.ComponentResources {
+			out.Spec.ComponentResources[k] = *v.DeepCopy()
+		}
+	}
+	if in.Spec.CanaryNamespaces != nil {
+		out.Spec.CanaryNamespaces = append([]string(nil), in.Spec.CanaryNamespaces...)
+	}
+	out.Spec.WatchNamespaceSelector = in.Spec.WatchNamespaceSelector.DeepCopy()
+	if in.Spec.Components != nil {
+		out.Spec.Components = append([]string(nil), in.Spec.Components...)
+	}
+	if in.Spec.ExternalControlPlane != nil {
+		out.Spec.ExternalControlPlane = &ExternalControlPlaneSpec{Address: in.Spec.ExternalControlPlane.Address}
+	}
+	if in.Spec.ComponentPodDisruptionBudgets != nil {
+		out.Spec.ComponentPodDisruptionBudgets = make(map[string]PodDisruptionBudgetSpec, len(in.Spec.ComponentPodDisruptionBudgets))
+		for k, v := range in.Spec.ComponentPodDisruptionBudgets {
+			if v.MinAvailable != nil {
+				minAvailable := *v.MinAvailable
+				v.MinAvailable = &minAvailable
+			}
+			out.Spec.ComponentPodDisruptionBudgets[k] = v
+		}
+	}
+	if in.Spec.ComponentHPASpecs != nil {
+		out.Spec.ComponentHPASpecs = make(map[string]autoscalingv2.HorizontalPodAutoscalerSpec, len(in.Spec.ComponentHPASpecs))
+		for k, v := range in.Spec.ComponentHPASpecs {
+			out.Spec.ComponentHPASpecs[k] = *v.DeepCopy()
+		}
+	}
+	if in.Spec.ComponentPodAnnotations != nil {
+		out.Spec.ComponentPodAnnotations = make(map[string]map[string]string, len(in.Spec.ComponentPodAnnotations))
+		for k, v := range in.Spec.ComponentPodAnnotations {
+			annotations := make(map[string]string, len(v))
+			for ak, av := range v {
+				annotations[ak] = av
+			}
+			out.Spec.ComponentPodAnnotations[k] = annotations
+		}
+	}
+	if in.Spec.ComponentTopologySpreadConstraints != nil {
+		out.Spec.ComponentTopologySpreadConstraints = make(map[string][]corev1.TopologySpreadConstraint, len(in.Spec.ComponentTopologySpreadConstraints))
+		for k, v := range in.Spec.ComponentTopologySpreadConstraints {
+			constraints := make([]corev1.TopologySpreadConstraint, len(v))
+			for i := range v {
+				v[i].DeepCopyInto(&constraints[i])
+			}
+			out.Spec.ComponentTopologySpreadConstraints[k] = constraints
+		}
+	}
+	if in.Spec.Security != nil {
+		security := *in.Spec.Security
+		out.Spec.Security = &security
+	}
+	if in.Spec.MeshConfig != nil {
+		out.Spec.MeshConfig = &MeshConfigSpec{}
+		if in.Spec.MeshConfig.DefaultConfig != nil {
+			out.Spec.MeshConfig.DefaultConfig = &ProxyConfigSpec{}
+			if in.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher != nil {
+				matcher := *in.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher
+				matcher.InclusionPrefixes = append([]string(nil), in.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher.InclusionPrefixes...)
+				matcher.InclusionSuffixes = append([]string(nil), in.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher.InclusionSuffixes...)
+				matcher.InclusionRegexps = append([]string(nil), in.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher.InclusionRegexps...)
+				out.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher = &matcher
+			}
+		}
+	}
+	if in.Spec.WasmPlugins != nil {
+		out.Spec.WasmPlugins = make([]WasmPluginSpec, len(in.Spec.WasmPlugins))
+		copy(out.Spec.WasmPlugins, in.Spec.WasmPlugins)
+	}
+	if in.Spec.MultiCluster != nil {
+		out.Spec.MultiCluster = &MultiClusterSpec{}
+		if in.Spec.MultiCluster.Remotes != nil {
+			out.Spec.MultiCluster.Remotes = make([]RemoteClusterSpec, len(in.Spec.MultiCluster.Remotes))
+			copy(out.Spec.MultiCluster.Remotes, in.Spec.MultiCluster.Remotes)
+		}
+	}
+	if in.Spec.Telemetry != nil {
+		telemetry := *in.Spec.Telemetry
+		if in.Spec.Telemetry.Grafana != nil {
+			grafana := *in.Spec.Telemetry.Grafana
+			telemetry.Grafana = &grafana
+		}
+		out.Spec.Telemetry = &telemetry
+	}
+	if in.Spec.SidecarInjection != nil {
+		out.Spec.SidecarInjection = &SidecarInjectionSpec{
+			EnabledNamespaces:  append([]string(nil), in.Spec.SidecarInjection.EnabledNamespaces...),
+			DisabledNamespaces: append([]string(nil), in.Spec.SidecarInjection.DisabledNamespaces...),
+		}
+	}
+	if in.Spec.ResourceBudget != nil {
+		out.Spec.ResourceBudget = &ResourceBudgetSpec{
+			MaxCPU:    in.Spec.ResourceBudget.MaxCPU.DeepCopy(),
+			MaxMemory: in.Spec.ResourceBudget.MaxMemory.DeepCopy(),
+		}
+	}
+	if in.Spec.Ambient != nil {
+		out.Spec.Ambient = &AmbientSpec{
+			Namespaces: append([]string(nil), in.Spec.Ambient.Namespaces...),
+		}
+	}
+	if in.Spec.MeshExpansion != nil {
+		meshExpansion := *in.Spec.MeshExpansion
+		out.Spec.MeshExpansion = &meshExpansion
+	}
+	if in.Status.Conditions != nil {
+		out.Status.Conditions = make([]metav1.Condition, len(in.Status.Conditions))
+		for i := range in.Status.Conditions {
+			in.Status.Conditions[i].DeepCopyInto(&out.Status.Conditions[i])
+		}
+	}
+	if in.Status.ComponentStatus != nil {
+		health := *in.Status.ComponentStatus
+		out.Status.ComponentStatus = &health
+	}
+	if in.Status.ComponentConditions != nil {
+		out.Status.ComponentConditions = make(map[string]ComponentCondition, len(in.Status.ComponentConditions))
+		for k, v := range in.Status.ComponentConditions {
+			out.Status.ComponentConditions[k] = v
+		}
+	}
+	if in.Status.RemoteClusters != nil {
+		out.Status.RemoteClusters = make([]RemoteClusterStatus, len(in.Status.RemoteClusters))
+		for i := range in.Status.RemoteClusters {
+			out.Status.RemoteClusters[i] = in.Status.RemoteClusters[i]
+			out.Status.RemoteClusters[i].LastCheckedTime = *in.Status.RemoteClusters[i].LastCheckedTime.DeepCopy()
+		}
+	}
+	if in.Status.GatewayAddresses != nil {
+		out.Status.GatewayAddresses = make(map[string]string, len(in.Status.GatewayAddresses))
+		for k, v := range in.Status.GatewayAddresses {
+			out.Status.GatewayAddresses[k] = v
+		}
+	}
+	return out
+}
+
+// DeepCopyObject implements runtime.Object, letting *IstioOperatorList be
+// used with a controller-runtime client.
+func (in *IstioOperatorList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopy returns a deep copy of in, or nil if in is nil.
+func (in *IstioOperatorList) DeepCopy() *IstioOperatorList {
+	if in == nil {
+		return nil
+	}
+	out := new(IstioOperatorList)
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]IstioOperator, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// DeepCopyInto copies in into out.
+func (in *IstioOperator) DeepCopyInto(out *IstioOperator) {
+	*out = *in.DeepCopy()
+}
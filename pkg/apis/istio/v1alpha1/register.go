@@ -0,0 +1,34 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package v1alpha1 contains API Schema definitions for the istio v1alpha1 API group.
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+// SchemeGroupVersion is the group/version this package's types register under.
+var SchemeGroupVersion = schema.GroupVersion{Group: "istio.istio.io", Version: "v1alpha1"}
+
+// SchemeBuilder is used to add go types to the GroupVersionKind scheme.
+var SchemeBuilder = &scheme.Builder{GroupVersion: SchemeGroupVersion}
+
+func init() {
+	SchemeBuilder.Register(&IstioOperator{}, &IstioOperatorList{})
+	SchemeBuilder.Register(&VMRegistration{}, &VMRegistrationList{})
+	SchemeBuilder.Register(&CertSync{}, &CertSyncList{})
+	SchemeBuilder.Register(&AuditLog{}, &AuditLogList{})
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// VMRegistrationSpec describes a VM workload to register into the mesh as
+// an Istio WorkloadEntry.
+type VMRegistrationSpec struct {
+	// Address is the VM's IP address, copied onto the WorkloadEntry's
+	// spec.address.
+	Address string `json:"address"`
+
+	// ServiceAccount is the Kubernetes ServiceAccount the VM authenticates
+	// as, copied onto the WorkloadEntry's spec.serviceAccount.
+	ServiceAccount string `json:"serviceAccount"`
+
+	// Labels are copied onto the WorkloadEntry's spec.labels, so the VM's
+	// workload can be selected the same way an in-cluster Pod's labels
+	// select it.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// HealthCheckURL is polled by vmregistration.Reconciler to decide
+	// whether the VM is still reachable. A non-2xx response, or a request
+	// that errors outright, counts as unhealthy.
+	HealthCheckURL string `json:"healthCheckURL,omitempty"`
+
+	// UnhealthyThreshold is how long HealthCheckURL must keep failing
+	// before vmregistration.Reconciler deletes this VM's WorkloadEntry.
+	UnhealthyThreshold metav1.Duration `json:"unhealthyThreshold"`
+}
+
+// VMRegistrationStatus reports what vmregistration.Reconciler has observed
+// about a VMRegistration's VM.
+type VMRegistrationStatus struct {
+	// LastHealthyTime is the last time HealthCheckURL was observed healthy.
+	// Zero means it has never been observed healthy.
+	LastHealthyTime metav1.Time `json:"lastHealthyTime,omitempty"`
+
+	// WorkloadEntryCreated reports whether a WorkloadEntry currently exists
+	// for this VM.
+	WorkloadEntryCreated bool `json:"workloadEntryCreated,omitempty"`
+}
+
+// VMRegistration is the Schema for the vmregistrations API: one instance
+// per VM a vmregistration webhook handler has accepted a registration
+// request for.
+type VMRegistration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VMRegistrationSpec   `json:"spec,omitempty"`
+	Status VMRegistrationStatus `json:"status,omitempty"`
+}
+
+// VMRegistrationList contains a list of VMRegistration.
+type VMRegistrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VMRegistration `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object, letting *VMRegistration be used
+// with a controller-runtime client.
+func (in *VMRegistration) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopy returns a deep copy of in, or nil if in is nil.
+func (in *VMRegistration) DeepCopy() *VMRegistration {
+	if in == nil {
+		return nil
+	}
+	out := new(VMRegistration)
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec.Address = in.Spec.Address
+	out.Spec.ServiceAccount = in.Spec.ServiceAccount
+	if in.Spec.Labels != nil {
+		out.Spec.Labels = make(map[string]string, len(in.Spec.Labels))
+		for k, v := range in.Spec.Labels {
+			out.Spec.Labels[k] = v
+		}
+	}
+	out.Spec.HealthCheckURL = in.Spec.HealthCheckURL
+	out.Spec.UnhealthyThreshold = in.Spec.UnhealthyThreshold
+	out.Status.LastHealthyTime = *in.Status.LastHealthyTime.DeepCopy()
+	out.Status.WorkloadEntryCreated = in.Status.WorkloadEntryCreated
+	return out
+}
+
+// DeepCopyObject implements runtime.Object, letting *VMRegistrationList be
+// used with a controller-runtime client.
+func (in *VMRegistrationList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopy returns a deep copy of in, or nil if in is nil.
+func (in *VMRegistrationList) DeepCopy() *VMRegistrationList {
+	if in == nil {
+		return nil
+	}
+	out := new(VMRegistrationList)
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]VMRegistration, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// DeepCopyInto copies in into out.
+func (in *VMRegistration) DeepCopyInto(out *VMRegistration) {
+	*out = *in.DeepCopy()
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package certsync projects a source Secret — typically an Istio CA's
+// certificate bundle — into one or more other namespaces, per CertSync,
+// and keeps those copies in sync as the source Secret's data (and, in
+// particular, its certificate) rotates.
+package certsync
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// certSyncOwnerUIDLabel records the UID of the CertSync a projected Secret
+// was copied for. A real OwnerReference would do this natively, but a
+// CertSync's destinations are, by design, in different namespaces than the
+// CertSync itself, and Kubernetes garbage collection ignores an
+// OwnerReference that crosses namespaces — the same reasoning
+// controller.Prune's ownerUIDLabel and istiooperator's
+// serviceMeshMemberOwnerUIDLabel already rely on for cross-namespace
+// ownership tracking.
+const certSyncOwnerUIDLabel = "istiooperator.istio.io/cert-sync-owner-uid"
+
+// secretSyncLagSeconds measures how long a single SecretProjector.Reconcile
+// call takes to copy the source Secret to every entry in
+// CertSyncSpec.Destinations, so a growing lag can be alerted on before a
+// rotated certificate's propagation falls far enough behind to matter.
+var secretSyncLagSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name: "secret_sync_lag_seconds",
+	Help: "How long SecretProjector.Reconcile took to copy a CertSync's source Secret to all of its destination namespaces.",
+})
+
+func init() {
+	prometheus.MustRegister(secretSyncLagSeconds)
+}
+
+// SecretProjector copies a CertSync's source Secret into every namespace
+// listed in its Destinations, re-copying on every reconcile so a rotated
+// certificate (or any other change to the source Secret's data) propagates
+// without needing separate rotation-detection logic.
+type SecretProjector struct{}
+
+// Reconcile copies certSync's source Secret to every entry in
+// certSync.Spec.Destinations, applying CertSyncSpec.KeyFilter if set, and
+// records certSync.Status.ObservedNotAfter/LastSyncTime/SyncedDestinations.
+func (r *SecretProjector) Reconcile(ctx context.Context, c client.Client, certSync *v1alpha1.CertSync) error {
+	start := time.Now()
+
+	source := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: certSync.Spec.SourceNamespace, Name: certSync.Spec.SourceSecretName}, source); err != nil {
+		return fmt.Errorf("getting source Secret %s/%s: %w", certSync.Spec.SourceNamespace, certSync.Spec.SourceSecretName, err)
+	}
+
+	notAfter, err := certNotAfter(source)
+	if err != nil {
+		return fmt.Errorf("reading certificate expiry from Secret %s/%s: %w", certSync.Spec.SourceNamespace, certSync.Spec.SourceSecretName, err)
+	}
+
+	data := filterKeys(source.Data, certSync.Spec.KeyFilter)
+
+	synced := 0
+	for _, dest := range certSync.Spec.Destinations {
+		name := dest.SecretName
+		if name == "" {
+			name = certSync.Spec.SourceSecretName
+		}
+		secret := &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: dest.Namespace,
+				Name:      name,
+				Labels:    map[string]string{certSyncOwnerUIDLabel: string(certSync.UID)},
+			},
+			Type: source.Type,
+			Data: data,
+		}
+		if err := c.Patch(ctx, secret, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+			return fmt.Errorf("applying Secret %s/%s: %w", dest.Namespace, name, err)
+		}
+		synced++
+	}
+
+	secretSyncLagSeconds.Observe(time.Since(start).Seconds())
+
+	before := certSync.DeepCopy()
+	certSync.Status.LastSyncTime = metav1.Now()
+	certSync.Status.SyncedDestinations = synced
+	if !notAfter.IsZero() {
+		certSync.Status.ObservedNotAfter = metav1.NewTime(notAfter)
+	}
+	if err := c.Status().Patch(ctx, certSync, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("updating CertSync %s/%s status: %w", certSync.Namespace, certSync.Name, err)
+	}
+	return nil
+}
+
+// certNotAfter reads secret's "tls.crt" data key and returns its
+// certificate's NotAfter, or the zero time if secret carries no "tls.crt"
+// at all — not every projected Secret is a TLS certificate, so a source
+// Secret without one isn't an error, it just can't be tracked for rotation
+// in CertSyncStatus.ObservedNotAfter.
+func certNotAfter(secret *corev1.Secret) (time.Time, error) {
+	certPEM, ok := secret.Data["tls.crt"]
+	if !ok {
+		return time.Time{}, nil
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("no PEM block found in tls.crt")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing certificate: %w", err)
+	}
+	return cert.NotAfter, nil
+}
+
+// filterKeys returns a copy of data restricted to keys, or a full copy of
+// data when keys is empty. Always copies rather than returning data
+// itself, since the result goes straight onto a Secret.Data this
+// reconciler is about to send over the wire.
+func filterKeys(data map[string][]byte, keys []string) map[string][]byte {
+	if len(keys) == 0 {
+		out := make(map[string][]byte, len(data))
+		for k, v := range data {
+			out[k] = v
+		}
+		return out
+	}
+	out := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		if v, ok := data[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certsync
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// newFakeCertSyncClient builds on the v1alpha1 scheme plus corev1, the way
+// newFakeVMRegistrationClient does for its own package, since Secret is a
+// typed core type and needs no extra unstructured GVK registration.
+func newFakeCertSyncClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+
+	var syncs []client.Object
+	for _, o := range objs {
+		if _, ok := o.(*v1alpha1.CertSync); ok {
+			syncs = append(syncs, o)
+		}
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(syncs...).Build()
+}
+
+func TestSecretProjectorReconcileCopiesToEveryDestination(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "ca-cert"},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{"root-cert.pem": []byte("root"), "key.pem": []byte("private")},
+	}
+	certSync := &v1alpha1.CertSync{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "ca-cert", UID: "cert-sync-uid"},
+		Spec: v1alpha1.CertSyncSpec{
+			SourceNamespace:  "istio-system",
+			SourceSecretName: "ca-cert",
+			Destinations: []v1alpha1.CertSyncDestination{
+				{Namespace: "app-a"},
+				{Namespace: "app-b", SecretName: "istio-ca-root"},
+			},
+		},
+	}
+	c := newFakeCertSyncClient(t, source, certSync)
+
+	p := &SecretProjector{}
+	if err := p.Reconcile(context.Background(), c, certSync); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	for ns, name := range map[string]string{"app-a": "ca-cert", "app-b": "istio-ca-root"} {
+		got := &corev1.Secret{}
+		if err := c.Get(context.Background(), client.ObjectKey{Namespace: ns, Name: name}, got); err != nil {
+			t.Fatalf("Get() Secret %s/%s = %v", ns, name, err)
+		}
+		if string(got.Data["root-cert.pem"]) != "root" || string(got.Data["key.pem"]) != "private" {
+			t.Fatalf("Secret %s/%s Data = %v, want both source keys copied", ns, name, got.Data)
+		}
+		if got.Labels[certSyncOwnerUIDLabel] != "cert-sync-uid" {
+			t.Fatalf("Secret %s/%s Labels[%s] = %q, want cert-sync-uid", ns, name, certSyncOwnerUIDLabel, got.Labels[certSyncOwnerUIDLabel])
+		}
+	}
+}
+
+func TestSecretProjectorReconcileAppliesKeyFilter(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "ca-cert"},
+		Data:       map[string][]byte{"root-cert.pem": []byte("root"), "key.pem": []byte("private")},
+	}
+	certSync := &v1alpha1.CertSync{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "ca-cert", UID: "cert-sync-uid"},
+		Spec: v1alpha1.CertSyncSpec{
+			SourceNamespace:  "istio-system",
+			SourceSecretName: "ca-cert",
+			Destinations:     []v1alpha1.CertSyncDestination{{Namespace: "app-a"}},
+			KeyFilter:        []string{"root-cert.pem"},
+		},
+	}
+	c := newFakeCertSyncClient(t, source, certSync)
+
+	p := &SecretProjector{}
+	if err := p.Reconcile(context.Background(), c, certSync); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &corev1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "app-a", Name: "ca-cert"}, got); err != nil {
+		t.Fatalf("Get() Secret = %v", err)
+	}
+	if _, ok := got.Data["key.pem"]; ok {
+		t.Fatalf("Data = %v, want key.pem filtered out", got.Data)
+	}
+	if string(got.Data["root-cert.pem"]) != "root" {
+		t.Fatalf("Data[root-cert.pem] = %q, want root", got.Data["root-cert.pem"])
+	}
+}
+
+func TestSecretProjectorReconcileUpdatesStatus(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "ca-cert"},
+		Data:       map[string][]byte{"root-cert.pem": []byte("root")},
+	}
+	certSync := &v1alpha1.CertSync{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "ca-cert", UID: "cert-sync-uid"},
+		Spec: v1alpha1.CertSyncSpec{
+			SourceNamespace:  "istio-system",
+			SourceSecretName: "ca-cert",
+			Destinations: []v1alpha1.CertSyncDestination{
+				{Namespace: "app-a"},
+				{Namespace: "app-b"},
+			},
+		},
+	}
+	c := newFakeCertSyncClient(t, source, certSync)
+
+	p := &SecretProjector{}
+	if err := p.Reconcile(context.Background(), c, certSync); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &v1alpha1.CertSync{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "ca-cert"}, got); err != nil {
+		t.Fatalf("Get() CertSync = %v", err)
+	}
+	if got.Status.SyncedDestinations != 2 {
+		t.Fatalf("Status.SyncedDestinations = %d, want 2", got.Status.SyncedDestinations)
+	}
+	if got.Status.LastSyncTime.IsZero() {
+		t.Fatalf("Status.LastSyncTime is zero, want it set")
+	}
+	if !got.Status.ObservedNotAfter.IsZero() {
+		t.Fatalf("Status.ObservedNotAfter = %v, want zero: source Secret has no tls.crt", got.Status.ObservedNotAfter)
+	}
+}
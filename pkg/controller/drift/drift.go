@@ -0,0 +1,210 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package drift periodically compares the live resources an IstioOperator
+// owns against what its current spec would render, so a change made
+// directly against the cluster — bypassing the GitOps pipeline that's
+// supposed to be the only path to it — surfaces as a metric and an event
+// instead of silently drifting until it causes an outage.
+package drift
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+var log = logf.Log.WithName("drift")
+
+// DefaultCheckInterval is used by StartDetector in place of a zero or
+// negative interval, and is the default the --drift-check-interval flag
+// example below passes.
+const DefaultCheckInterval = 5 * time.Minute
+
+// DefaultAlertThreshold is used by StartDetector in place of a zero or
+// negative threshold: any drifted resource at all triggers a Warning
+// event. --drift-alert-threshold raises this for a fleet where a handful
+// of drifted resources is routine and only a larger divergence is worth
+// paging on.
+const DefaultAlertThreshold = 1
+
+// CheckInterval and AlertThreshold are set by the operator binary's
+// --drift-check-interval and --drift-alert-threshold flags before
+// StartDetector is called. This tree has no cmd/main.go to attach such
+// flags to; a binary that does should do roughly:
+//
+//	flag.DurationVar(&drift.CheckInterval, "drift-check-interval", drift.DefaultCheckInterval, "how often to compare live resources against what the current IstioOperator spec would render")
+//	flag.IntVar(&drift.AlertThreshold, "drift-alert-threshold", drift.DefaultAlertThreshold, "minimum number of drifted resources on an IstioOperator before a Warning event is emitted")
+var (
+	CheckInterval  time.Duration
+	AlertThreshold int
+)
+
+// driftDetectedTotal counts every resource a Detector cycle has found
+// diverging from its IstioOperator's rendered spec, labeled by the
+// resource's Kind, so a spike in one kind (e.g. every Gateway Service
+// getting hand-edited) stands out rather than being lost in an aggregate.
+// It climbs once per divergent resource on every cycle it's still
+// drifted, not just the first time it's noticed, the same way
+// hpa_reconcile_error_total counts every failing pass rather than only
+// the first.
+var driftDetectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "drift_detected_total",
+	Help: "Number of resources found diverging from their IstioOperator's rendered spec, labeled by kind.",
+}, []string{"kind"})
+
+func init() {
+	prometheus.MustRegister(driftDetectedTotal)
+}
+
+// Renderer renders the resources iop's current spec would produce, the
+// same manifests a reconcile pass applies. Detector takes this as an
+// interface rather than calling into a concrete templating engine
+// directly, since this tree's Helm-based rendering lives outside
+// pkg/controller; a binary wiring up a Detector passes whatever Renderer
+// wraps its own render step.
+type Renderer interface {
+	Render(ctx context.Context, iop *v1alpha1.IstioOperator) ([]*unstructured.Unstructured, error)
+}
+
+// Detector periodically renders every live IstioOperator's spec and
+// compares the result against the corresponding live resources, reporting
+// any divergence via driftDetectedTotal and, once an IstioOperator's
+// drifted-resource count reaches AlertThreshold, a Warning event per
+// divergent resource.
+type Detector struct {
+	client   client.Client
+	renderer Renderer
+	recorder record.EventRecorder
+}
+
+// NewDetector builds a Detector. It doesn't check anything on its own;
+// call StartDetector instead of this directly unless a caller needs to
+// run a single cycle outside of StartDetector's ticker loop.
+func NewDetector(c client.Client, renderer Renderer, recorder record.EventRecorder) *Detector {
+	return &Detector{client: c, renderer: renderer, recorder: recorder}
+}
+
+// StartDetector builds a Detector and runs one check cycle synchronously
+// before returning, then again every CheckInterval (DefaultCheckInterval
+// if CheckInterval is zero or negative) in the background until ctx is
+// cancelled — the same "check once now, then on a ticker until ctx is
+// done" shape StartMetricStoreRefresher uses for its own background
+// polling loop.
+func StartDetector(ctx context.Context, c client.Client, renderer Renderer, recorder record.EventRecorder) *Detector {
+	d := NewDetector(c, renderer, recorder)
+	d.checkAll(ctx)
+	go d.run(ctx)
+	return d
+}
+
+func (d *Detector) run(ctx context.Context) {
+	interval := CheckInterval
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.checkAll(ctx)
+		}
+	}
+}
+
+// checkAll runs one drift-detection cycle across every live IstioOperator.
+// A single IstioOperator's render or list error is logged and skipped
+// rather than aborting the whole cycle, so one misconfigured CR can't
+// blind the detector to drift on every other one.
+func (d *Detector) checkAll(ctx context.Context) {
+	var iops v1alpha1.IstioOperatorList
+	if err := d.client.List(ctx, &iops); err != nil {
+		log.Error(err, "Failed to list IstioOperators for drift detection")
+		return
+	}
+	for i := range iops.Items {
+		d.check(ctx, &iops.Items[i])
+	}
+}
+
+// check renders iop's current spec, compares the result against the
+// corresponding live resources, and reports every divergence found.
+func (d *Detector) check(ctx context.Context, iop *v1alpha1.IstioOperator) {
+	desired, err := d.renderer.Render(ctx, iop)
+	if err != nil {
+		log.Error(err, "Failed to render IstioOperator for drift detection", "namespace", iop.Namespace, "name", iop.Name)
+		return
+	}
+
+	var drifted []*unstructured.Unstructured
+	for _, want := range desired {
+		diff, err := d.diffOne(ctx, want)
+		if err != nil {
+			log.Error(err, "Failed to compare rendered resource against live cluster state", "kind", want.GetKind(), "namespace", want.GetNamespace(), "name", want.GetName())
+			continue
+		}
+		if diff == "" {
+			continue
+		}
+		driftDetectedTotal.WithLabelValues(want.GetKind()).Inc()
+		drifted = append(drifted, want)
+	}
+
+	threshold := AlertThreshold
+	if threshold <= 0 {
+		threshold = DefaultAlertThreshold
+	}
+	if len(drifted) < threshold {
+		return
+	}
+	for _, want := range drifted {
+		d.recorder.Eventf(iop, corev1.EventTypeWarning, "DriftDetected", "%s %s/%s diverges from what this IstioOperator's spec would render", want.GetKind(), want.GetNamespace(), want.GetName())
+	}
+}
+
+// diffOne fetches the live resource matching want's GVK/namespace/name and
+// returns a human-readable description of how its "spec" field differs
+// from want's, or "" if they match. A live resource that doesn't exist at
+// all counts as drift too, described as such rather than diffed field by
+// field. Compares only "spec", the same way specDiffOpts and DiffSpec
+// compare an IstioOperatorSpec rather than a whole IstioOperator, since
+// "status", "metadata.resourceVersion", and similarly server-managed
+// fields are expected to differ from what a render produces and would
+// otherwise drown out real drift.
+func (d *Detector) diffOne(ctx context.Context, want *unstructured.Unstructured) (string, error) {
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(want.GroupVersionKind())
+	key := client.ObjectKey{Namespace: want.GetNamespace(), Name: want.GetName()}
+	if err := d.client.Get(ctx, key, live); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("%s %s/%s: missing from the cluster", want.GetKind(), want.GetNamespace(), want.GetName()), nil
+		}
+		return "", fmt.Errorf("getting live %s %s/%s: %w", want.GetKind(), want.GetNamespace(), want.GetName(), err)
+	}
+	return cmp.Diff(live.Object["spec"], want.Object["spec"]), nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drift
+
+import (
+	"context"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+var gatewayGVK = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "Gateway"}
+
+func newFakeDriftClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(gatewayGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(gatewayGVK.GroupVersion().WithKind("GatewayList"), &unstructured.UnstructuredList{})
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+// stubRenderer returns a fixed set of resources regardless of which
+// IstioOperator it's asked to render, since these tests care about how
+// Detector compares desired against live, not about rendering itself.
+type stubRenderer struct {
+	resources []*unstructured.Unstructured
+}
+
+func (r *stubRenderer) Render(_ context.Context, _ *v1alpha1.IstioOperator) ([]*unstructured.Unstructured, error) {
+	return r.resources, nil
+}
+
+func gatewayObj(namespace, name string, selector string) *unstructured.Unstructured {
+	gw := &unstructured.Unstructured{}
+	gw.SetGroupVersionKind(gatewayGVK)
+	gw.SetNamespace(namespace)
+	gw.SetName(name)
+	_ = unstructured.SetNestedField(gw.Object, selector, "spec", "selector", "istio")
+	return gw
+}
+
+func TestDetectorReportsNoDriftWhenLiveMatchesRendered(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	live := gatewayObj("istio-system", "ingress", "ingressgateway")
+	c := newFakeDriftClient(t, iop, live)
+	recorder := record.NewFakeRecorder(1)
+	renderer := &stubRenderer{resources: []*unstructured.Unstructured{gatewayObj("istio-system", "ingress", "ingressgateway")}}
+	before := driftDetectedTotalValue(t, "Gateway")
+
+	d := NewDetector(c, renderer, recorder)
+	d.checkAll(context.Background())
+
+	if after := driftDetectedTotalValue(t, "Gateway"); after != before {
+		t.Fatalf("drift_detected_total{kind=Gateway} = %v, want unchanged at %v", after, before)
+	}
+	select {
+	case e := <-recorder.Events:
+		t.Fatalf("unexpected event: %s", e)
+	default:
+	}
+}
+
+func TestDetectorReportsDriftAndEmitsWarningWhenLiveDivergesFromRendered(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	live := gatewayObj("istio-system", "ingress", "hand-edited")
+	c := newFakeDriftClient(t, iop, live)
+	recorder := record.NewFakeRecorder(1)
+	renderer := &stubRenderer{resources: []*unstructured.Unstructured{gatewayObj("istio-system", "ingress", "ingressgateway")}}
+	before := driftDetectedTotalValue(t, "Gateway")
+
+	d := NewDetector(c, renderer, recorder)
+	d.checkAll(context.Background())
+
+	if after := driftDetectedTotalValue(t, "Gateway"); after != before+1 {
+		t.Fatalf("drift_detected_total{kind=Gateway} = %v, want %v", after, before+1)
+	}
+	select {
+	case e := <-recorder.Events:
+		if e == "" {
+			t.Fatal("got an empty event, want a DriftDetected Warning")
+		}
+	default:
+		t.Fatal("no event recorded, want a DriftDetected Warning once AlertThreshold is reached")
+	}
+}
+
+func TestDetectorWithholdsWarningBelowAlertThreshold(t *testing.T) {
+	AlertThreshold = 2
+	defer func() { AlertThreshold = 0 }()
+
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	live := gatewayObj("istio-system", "ingress", "hand-edited")
+	c := newFakeDriftClient(t, iop, live)
+	recorder := record.NewFakeRecorder(1)
+	renderer := &stubRenderer{resources: []*unstructured.Unstructured{gatewayObj("istio-system", "ingress", "ingressgateway")}}
+
+	d := NewDetector(c, renderer, recorder)
+	d.checkAll(context.Background())
+
+	select {
+	case e := <-recorder.Events:
+		t.Fatalf("unexpected event below AlertThreshold: %s", e)
+	default:
+	}
+}
+
+func TestDetectorReportsDriftForAMissingResource(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	c := newFakeDriftClient(t, iop)
+	recorder := record.NewFakeRecorder(1)
+	renderer := &stubRenderer{resources: []*unstructured.Unstructured{gatewayObj("istio-system", "ingress", "ingressgateway")}}
+	before := driftDetectedTotalValue(t, "Gateway")
+
+	d := NewDetector(c, renderer, recorder)
+	d.checkAll(context.Background())
+
+	if after := driftDetectedTotalValue(t, "Gateway"); after != before+1 {
+		t.Fatalf("drift_detected_total{kind=Gateway} = %v, want %v for a rendered resource missing from the cluster", after, before+1)
+	}
+}
+
+func driftDetectedTotalValue(t *testing.T, kind string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := driftDetectedTotal.WithLabelValues(kind).Write(&m); err != nil {
+		t.Fatalf("driftDetectedTotal.Write() = %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
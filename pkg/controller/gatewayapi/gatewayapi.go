@@ -0,0 +1,340 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gatewayapi translates gateway.networking.k8s.io HTTPRoutes into
+// equivalent networking.istio.io VirtualServices, for clusters migrating
+// from Istio's own APIs to the Kubernetes Gateway API without giving up
+// Istio-specific routing in the meantime.
+package gatewayapi
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+var log = logf.Log.WithName("gatewayapi")
+
+var (
+	httpRouteGVK      = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+	virtualServiceGVK = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "VirtualService"}
+)
+
+// translatedFromAnnotation records the source HTTPRoute's UID on every
+// VirtualService Reconcile creates, so GarbageCollectOrphans can tell which
+// VirtualServices it's responsible for and which HTTPRoute each belongs to,
+// without relying on an OwnerReference: a VirtualService created from an
+// HTTPRoute always lives in the HTTPRoute's own namespace, so a real
+// OwnerReference would in fact work here, but GarbageCollectOrphans reads
+// this annotation anyway rather than depending on Kubernetes GC's timing.
+const translatedFromAnnotation = "istio-operator/translated-from"
+
+// GatewayAPIReconciler creates or updates the VirtualService equivalent of
+// an HTTPRoute.
+type GatewayAPIReconciler struct{}
+
+// Reconcile builds the VirtualService equivalent of route and applies it
+// via server-side apply, named and namespaced the same as route. It
+// translates spec.rules[].matches[].path (PathPrefix and Exact only),
+// backendRefs, the RequestHeaderModifier filter, and spec.rules[].timeouts;
+// any other match type or filter is left untranslated rather than rejecting
+// the whole HTTPRoute.
+func (r *GatewayAPIReconciler) Reconcile(ctx context.Context, c client.Client, route *unstructured.Unstructured) error {
+	parentRefs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+
+	httpRoutes := make([]interface{}, 0, len(rules))
+	for _, raw := range rules {
+		rule, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		httpRoutes = append(httpRoutes, translateRule(rule, route.GetNamespace()))
+	}
+
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(virtualServiceGVK)
+	vs.SetNamespace(route.GetNamespace())
+	vs.SetName(route.GetName())
+	vs.SetAnnotations(map[string]string{translatedFromAnnotation: string(route.GetUID())})
+	vs.Object["spec"] = map[string]interface{}{
+		"hosts":    toInterfaceSlice(hostnames),
+		"gateways": gatewayNames(parentRefs),
+		"http":     httpRoutes,
+	}
+
+	if err := c.Patch(ctx, vs, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying VirtualService %s/%s: %w", route.GetNamespace(), route.GetName(), err)
+	}
+	return nil
+}
+
+// translateRule builds one VirtualService spec.http[] entry from an
+// HTTPRouteRule, resolving BackendRefs relative to routeNamespace.
+func translateRule(rule map[string]interface{}, routeNamespace string) map[string]interface{} {
+	http := map[string]interface{}{}
+
+	if matches, ok, _ := unstructured.NestedSlice(rule, "matches"); ok {
+		http["match"] = translateMatches(matches)
+	}
+	if backendRefs, ok, _ := unstructured.NestedSlice(rule, "backendRefs"); ok {
+		http["route"] = translateBackendRefs(backendRefs, routeNamespace)
+	}
+	if headers := translateRequestHeaderModifier(rule); headers != nil {
+		http["headers"] = headers
+	}
+	if timeout, ok := translateTimeouts(rule); ok {
+		http["timeout"] = timeout
+	}
+
+	return http
+}
+
+// translateMatches translates HTTPRouteMatch.Path entries of type
+// PathPrefix or Exact into VirtualService HTTPMatchRequest.Uri selectors.
+// Any other path type, or a match with no path at all, is skipped.
+func translateMatches(matches []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(matches))
+	for _, m := range matches {
+		match, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path, ok := match["path"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, _ := path["value"].(string)
+		if value == "" {
+			continue
+		}
+		switch pathType, _ := path["type"].(string); pathType {
+		case "PathPrefix":
+			out = append(out, map[string]interface{}{"uri": map[string]interface{}{"prefix": value}})
+		case "Exact":
+			out = append(out, map[string]interface{}{"uri": map[string]interface{}{"exact": value}})
+		}
+	}
+	return out
+}
+
+// translateBackendRefs translates HTTPBackendRef entries into
+// HTTPRouteDestination entries, resolving an unset Namespace to
+// routeNamespace the way Gateway API itself does.
+func translateBackendRefs(backendRefs []interface{}, routeNamespace string) []interface{} {
+	out := make([]interface{}, 0, len(backendRefs))
+	for _, b := range backendRefs {
+		backendRef, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := backendRef["name"].(string)
+		if name == "" {
+			continue
+		}
+		namespace, _ := backendRef["namespace"].(string)
+		if namespace == "" {
+			namespace = routeNamespace
+		}
+
+		destination := map[string]interface{}{"host": destinationHost(name, namespace, routeNamespace)}
+		if port, ok := backendRef["port"]; ok {
+			destination["port"] = map[string]interface{}{"number": port}
+		}
+
+		route := map[string]interface{}{"destination": destination}
+		if weight, ok := backendRef["weight"]; ok {
+			route["weight"] = weight
+		}
+		out = append(out, route)
+	}
+	return out
+}
+
+// destinationHost returns name unqualified when namespace matches
+// routeNamespace, and the namespace-qualified cluster-local FQDN otherwise,
+// the same distinction a hand-written VirtualService would have to make.
+func destinationHost(name, namespace, routeNamespace string) string {
+	if namespace == routeNamespace {
+		return name
+	}
+	return fmt.Sprintf("%s.%s.svc.cluster.local", name, namespace)
+}
+
+// translateRequestHeaderModifier collects every rule-level filter of type
+// RequestHeaderModifier into a single VirtualService Headers.Request
+// block. Gateway API allows more than one filter per rule; their Set/Add
+// entries are merged in order, and later filters win on a name conflict,
+// mirroring the order Gateway API itself documents them as applying in.
+func translateRequestHeaderModifier(rule map[string]interface{}) map[string]interface{} {
+	filters, ok, _ := unstructured.NestedSlice(rule, "filters")
+	if !ok {
+		return nil
+	}
+
+	set := map[string]interface{}{}
+	add := map[string]interface{}{}
+	var remove []interface{}
+	found := false
+
+	for _, f := range filters {
+		filter, ok := f.(map[string]interface{})
+		if !ok || filter["type"] != "RequestHeaderModifier" {
+			continue
+		}
+		modifier, ok := filter["requestHeaderModifier"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		found = true
+
+		for _, h := range headerEntries(modifier, "set") {
+			set[h.name] = h.value
+		}
+		for _, h := range headerEntries(modifier, "add") {
+			add[h.name] = h.value
+		}
+		if names, ok, _ := unstructured.NestedStringSlice(modifier, "remove"); ok {
+			for _, n := range names {
+				remove = append(remove, n)
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	request := map[string]interface{}{}
+	if len(set) > 0 {
+		request["set"] = set
+	}
+	if len(add) > 0 {
+		request["add"] = add
+	}
+	if len(remove) > 0 {
+		request["remove"] = remove
+	}
+	return map[string]interface{}{"request": request}
+}
+
+type headerNameValue struct {
+	name  string
+	value string
+}
+
+func headerEntries(modifier map[string]interface{}, field string) []headerNameValue {
+	entries, ok, _ := unstructured.NestedSlice(modifier, field)
+	if !ok {
+		return nil
+	}
+	out := make([]headerNameValue, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := entry["name"].(string)
+		value, _ := entry["value"].(string)
+		if name == "" {
+			continue
+		}
+		out = append(out, headerNameValue{name: name, value: value})
+	}
+	return out
+}
+
+// translateTimeouts translates HTTPRouteTimeouts.Request into
+// HTTPRoute.Timeout. BackendRequest has no direct VirtualService
+// equivalent (Istio only exposes a single per-route timeout) and is left
+// untranslated.
+func translateTimeouts(rule map[string]interface{}) (string, bool) {
+	timeout, ok, _ := unstructured.NestedString(rule, "timeouts", "request")
+	return timeout, ok && timeout != ""
+}
+
+func gatewayNames(parentRefs []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(parentRefs))
+	for _, p := range parentRefs {
+		parentRef, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, _ := parentRef["name"].(string); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func toInterfaceSlice(s []string) []interface{} {
+	out := make([]interface{}, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+	return out
+}
+
+// GarbageCollectOrphans deletes every VirtualService carrying
+// translatedFromAnnotation whose source HTTPRoute (identified by the
+// annotation's UID) no longer exists, across every namespace ns lists.
+func GarbageCollectOrphans(ctx context.Context, c client.Client, ns []string) error {
+	liveUIDs, err := liveHTTPRouteUIDs(ctx, c, ns)
+	if err != nil {
+		return fmt.Errorf("listing live HTTPRoutes: %w", err)
+	}
+
+	for _, namespace := range ns {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(virtualServiceGVK.GroupVersion().WithKind("VirtualServiceList"))
+		if err := c.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			return fmt.Errorf("listing VirtualServices in %s: %w", namespace, err)
+		}
+
+		for i := range list.Items {
+			vs := &list.Items[i]
+			sourceUID, ok := vs.GetAnnotations()[translatedFromAnnotation]
+			if !ok || liveUIDs[sourceUID] {
+				continue
+			}
+			if err := c.Delete(ctx, vs); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("deleting orphaned VirtualService %s/%s: %w", vs.GetNamespace(), vs.GetName(), err)
+			}
+			log.Info("Deleted orphaned VirtualService", "namespace", vs.GetNamespace(), "name", vs.GetName())
+		}
+	}
+	return nil
+}
+
+func liveHTTPRouteUIDs(ctx context.Context, c client.Client, ns []string) (map[string]bool, error) {
+	live := map[string]bool{}
+	for _, namespace := range ns {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(httpRouteGVK.GroupVersion().WithKind("HTTPRouteList"))
+		if err := c.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			live[string(list.Items[i].GetUID())] = true
+		}
+	}
+	return live, nil
+}
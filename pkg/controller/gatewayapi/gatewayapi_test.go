@@ -0,0 +1,239 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gatewayapi
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newFakeGatewayAPIClient registers httpRouteGVK and virtualServiceGVK as
+// unstructured kinds, the way istiooperator's newFakeTelemetryClient does
+// for telemetryGVK, since neither is vendored as a typed Go API here.
+func newFakeGatewayAPIClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(httpRouteGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(httpRouteGVK.GroupVersion().WithKind("HTTPRouteList"), &unstructured.UnstructuredList{})
+	scheme.AddKnownTypeWithName(virtualServiceGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(virtualServiceGVK.GroupVersion().WithKind("VirtualServiceList"), &unstructured.UnstructuredList{})
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+// nestedValue walks obj along fields the way unstructured.NestedFieldNoCopy
+// does, except that a numeric field indexes into a []interface{}, so a path
+// like spec.http[0].match[0] resolves. It returns nil for a missing path.
+func nestedValue(obj map[string]interface{}, fields ...string) interface{} {
+	var val interface{} = obj
+	for _, field := range fields {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			val = v[field]
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			val = v[i]
+		default:
+			return nil
+		}
+	}
+	return val
+}
+
+// basicHTTPRoute is a realistic HTTPRoute: one parentRef, one hostname, and
+// a single rule combining a PathPrefix match, a weighted backendRef, a
+// RequestHeaderModifier filter, and a request timeout.
+func basicHTTPRoute(namespace, name string) *unstructured.Unstructured {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	route.SetNamespace(namespace)
+	route.SetName(name)
+	route.SetUID("route-uid")
+	route.Object["spec"] = map[string]interface{}{
+		"parentRefs": []interface{}{
+			map[string]interface{}{"name": "my-gateway"},
+		},
+		"hostnames": []interface{}{"example.com"},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{"path": map[string]interface{}{"type": "PathPrefix", "value": "/checkout"}},
+				},
+				"backendRefs": []interface{}{
+					map[string]interface{}{"name": "checkout", "port": int64(8080), "weight": int64(100)},
+				},
+				"filters": []interface{}{
+					map[string]interface{}{
+						"type": "RequestHeaderModifier",
+						"requestHeaderModifier": map[string]interface{}{
+							"set":    []interface{}{map[string]interface{}{"name": "X-Env", "value": "prod"}},
+							"remove": []interface{}{"X-Debug"},
+						},
+					},
+				},
+				"timeouts": map[string]interface{}{"request": "5s"},
+			},
+		},
+	}
+	return route
+}
+
+func TestReconcileTranslatesPathMatchAndBackendRef(t *testing.T) {
+	route := basicHTTPRoute("shop", "checkout-route")
+	c := newFakeGatewayAPIClient(t, route)
+
+	r := &GatewayAPIReconciler{}
+	if err := r.Reconcile(context.Background(), c, route); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(virtualServiceGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "shop", Name: "checkout-route"}, vs); err != nil {
+		t.Fatalf("Get() VirtualService = %v", err)
+	}
+
+	if vs.GetAnnotations()[translatedFromAnnotation] != "route-uid" {
+		t.Fatalf("annotations[%s] = %q, want %q", translatedFromAnnotation, vs.GetAnnotations()[translatedFromAnnotation], "route-uid")
+	}
+
+	gateways, _, _ := unstructured.NestedStringSlice(vs.Object, "spec", "gateways")
+	if len(gateways) != 1 || gateways[0] != "my-gateway" {
+		t.Fatalf("spec.gateways = %v, want [my-gateway]", gateways)
+	}
+
+	prefix, _ := nestedValue(vs.Object, "spec", "http", "0", "match", "0", "uri", "prefix").(string)
+	if prefix != "/checkout" {
+		t.Fatalf("spec.http[0].match[0].uri.prefix = %q, want /checkout", prefix)
+	}
+
+	host, _ := nestedValue(vs.Object, "spec", "http", "0", "route", "0", "destination", "host").(string)
+	if host != "checkout" {
+		t.Fatalf("spec.http[0].route[0].destination.host = %q, want checkout (same-namespace, unqualified)", host)
+	}
+	port, _ := nestedValue(vs.Object, "spec", "http", "0", "route", "0", "destination", "port", "number").(int64)
+	if port != 8080 {
+		t.Fatalf("spec.http[0].route[0].destination.port.number = %d, want 8080", port)
+	}
+
+	setValue, _ := nestedValue(vs.Object, "spec", "http", "0", "headers", "request", "set", "X-Env").(string)
+	if setValue != "prod" {
+		t.Fatalf("spec.http[0].headers.request.set[X-Env] = %q, want prod", setValue)
+	}
+	remove, _ := nestedValue(vs.Object, "spec", "http", "0", "headers", "request", "remove").([]interface{})
+	if len(remove) != 1 || remove[0] != "X-Debug" {
+		t.Fatalf("spec.http[0].headers.request.remove = %v, want [X-Debug]", remove)
+	}
+
+	timeout, _ := nestedValue(vs.Object, "spec", "http", "0", "timeout").(string)
+	if timeout != "5s" {
+		t.Fatalf("spec.http[0].timeout = %q, want 5s", timeout)
+	}
+}
+
+func TestReconcileQualifiesCrossNamespaceBackendRef(t *testing.T) {
+	route := basicHTTPRoute("shop", "checkout-route")
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	rule := rules[0].(map[string]interface{})
+	rule["backendRefs"] = []interface{}{
+		map[string]interface{}{"name": "checkout", "namespace": "other-ns", "port": int64(8080)},
+	}
+	route.Object["spec"].(map[string]interface{})["rules"] = rules
+	c := newFakeGatewayAPIClient(t, route)
+
+	r := &GatewayAPIReconciler{}
+	if err := r.Reconcile(context.Background(), c, route); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(virtualServiceGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "shop", Name: "checkout-route"}, vs); err != nil {
+		t.Fatalf("Get() VirtualService = %v", err)
+	}
+
+	host, _ := nestedValue(vs.Object, "spec", "http", "0", "route", "0", "destination", "host").(string)
+	if host != "checkout.other-ns.svc.cluster.local" {
+		t.Fatalf("destination.host = %q, want the namespace-qualified FQDN", host)
+	}
+}
+
+func TestReconcileSkipsUnsupportedMatchType(t *testing.T) {
+	route := basicHTTPRoute("shop", "checkout-route")
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	rule := rules[0].(map[string]interface{})
+	rule["matches"] = []interface{}{
+		map[string]interface{}{"path": map[string]interface{}{"type": "RegularExpression", "value": "/.*"}},
+	}
+	route.Object["spec"].(map[string]interface{})["rules"] = rules
+	c := newFakeGatewayAPIClient(t, route)
+
+	r := &GatewayAPIReconciler{}
+	if err := r.Reconcile(context.Background(), c, route); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(virtualServiceGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "shop", Name: "checkout-route"}, vs); err != nil {
+		t.Fatalf("Get() VirtualService = %v", err)
+	}
+
+	if _, ok := nestedValue(vs.Object, "spec", "http", "0").(map[string]interface{}); !ok {
+		t.Fatalf("spec.http = %v, want one translated rule", nestedValue(vs.Object, "spec", "http"))
+	}
+	matches, _ := nestedValue(vs.Object, "spec", "http", "0", "match").([]interface{})
+	if len(matches) != 0 {
+		t.Fatalf("spec.http[0].match = %v, want empty for an unsupported path match type", matches)
+	}
+}
+
+func TestGarbageCollectOrphansDeletesVirtualServiceWithoutLiveHTTPRoute(t *testing.T) {
+	orphan := &unstructured.Unstructured{}
+	orphan.SetGroupVersionKind(virtualServiceGVK)
+	orphan.SetNamespace("shop")
+	orphan.SetName("stale-route")
+	orphan.SetAnnotations(map[string]string{translatedFromAnnotation: "deleted-route-uid"})
+
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(virtualServiceGVK)
+	live.SetNamespace("shop")
+	live.SetName("checkout-route")
+	live.SetAnnotations(map[string]string{translatedFromAnnotation: "route-uid"})
+
+	route := basicHTTPRoute("shop", "checkout-route")
+	c := newFakeGatewayAPIClient(t, orphan, live, route)
+
+	if err := GarbageCollectOrphans(context.Background(), c, []string{"shop"}); err != nil {
+		t.Fatalf("GarbageCollectOrphans() = %v", err)
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(virtualServiceGVK.GroupVersion().WithKind("VirtualServiceList"))
+	if err := c.List(context.Background(), list, client.InNamespace("shop")); err != nil {
+		t.Fatalf("List() VirtualServices = %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].GetName() != "checkout-route" {
+		t.Fatalf("remaining VirtualServices = %v, want only checkout-route", list.Items)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gatewayapi
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+var gatewayClassGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "GatewayClass"}
+
+// gatewayGVK identifies the Gateway API resource GatewayProvisionerReconciler
+// provisions a Deployment and Service for. It's handled as
+// unstructured.Unstructured for the same reason httpRouteGVK is.
+var gatewayGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "Gateway"}
+
+// istioGatewayClassName is the only GatewayClass name
+// GatewayProvisionerReconciler provisions for, matching the "istio"
+// GatewayClass istioctl's own `istioctl install` ships.
+const istioGatewayClassName = "istio"
+
+// GatewayProvisionerReconciler stands up the Deployment and Service backing
+// a Kubernetes Gateway API Gateway whose spec.gatewayClassName is
+// istioGatewayClassName, the way Istio's own built-in gateway controller
+// would if this operator weren't standing in for it. Deployment and Service
+// are owned by the Gateway, so deleting the Gateway deletes them the same
+// way Kubernetes GC handles any other owned resource in the same namespace;
+// there's nothing else for Reconcile to clean up on delete.
+type GatewayProvisionerReconciler struct{}
+
+// Reconcile provisions gw's Deployment and Service and reflects the
+// Service's LoadBalancer address(es) onto gw.Status.Addresses. gw whose
+// spec.gatewayClassName isn't istioGatewayClassName is left untouched.
+func (r *GatewayProvisionerReconciler) Reconcile(ctx context.Context, c client.Client, gw *unstructured.Unstructured) error {
+	className, _, _ := unstructured.NestedString(gw.Object, "spec", "gatewayClassName")
+	if className != istioGatewayClassName {
+		return nil
+	}
+
+	iop, err := r.resolveIstioOperator(ctx, c, className)
+	if err != nil {
+		return fmt.Errorf("resolving IstioOperator for GatewayClass %s: %w", className, err)
+	}
+
+	if err := r.applyDeployment(ctx, c, gw, iop); err != nil {
+		return fmt.Errorf("applying Deployment for Gateway %s/%s: %w", gw.GetNamespace(), gw.GetName(), err)
+	}
+	svc, err := r.applyService(ctx, c, gw)
+	if err != nil {
+		return fmt.Errorf("applying Service for Gateway %s/%s: %w", gw.GetNamespace(), gw.GetName(), err)
+	}
+
+	if err := r.updateAddresses(ctx, c, gw, svc); err != nil {
+		return fmt.Errorf("updating addresses for Gateway %s/%s: %w", gw.GetNamespace(), gw.GetName(), err)
+	}
+	return nil
+}
+
+// resolveIstioOperator follows the named GatewayClass's spec.parametersRef
+// to the IstioOperator CR that configures the gateway pod this reconciler
+// provisions.
+func (r *GatewayProvisionerReconciler) resolveIstioOperator(ctx context.Context, c client.Client, gatewayClassName string) (*v1alpha1.IstioOperator, error) {
+	gatewayClass := &unstructured.Unstructured{}
+	gatewayClass.SetGroupVersionKind(gatewayClassGVK)
+	if err := c.Get(ctx, client.ObjectKey{Name: gatewayClassName}, gatewayClass); err != nil {
+		return nil, fmt.Errorf("getting GatewayClass %s: %w", gatewayClassName, err)
+	}
+
+	name, _, _ := unstructured.NestedString(gatewayClass.Object, "spec", "parametersRef", "name")
+	namespace, _, _ := unstructured.NestedString(gatewayClass.Object, "spec", "parametersRef", "namespace")
+	if name == "" {
+		return nil, fmt.Errorf("GatewayClass %s has no spec.parametersRef.name", gatewayClassName)
+	}
+
+	iop := &v1alpha1.IstioOperator{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, iop); err != nil {
+		return nil, fmt.Errorf("getting IstioOperator %s/%s: %w", namespace, name, err)
+	}
+	return iop, nil
+}
+
+// applyDeployment creates or updates the gateway proxy Deployment backing
+// gw, running Istio's proxyv2 image at iop.Spec.Version.
+func (r *GatewayProvisionerReconciler) applyDeployment(ctx context.Context, c client.Client, gw *unstructured.Unstructured, iop *v1alpha1.IstioOperator) error {
+	replicas := int32(1)
+	labels := map[string]string{"istio.io/gateway-name": gw.GetName()}
+
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: gw.GetName(), Namespace: gw.GetNamespace(), Labels: labels, OwnerReferences: r.ownerReferences(gw)},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "istio-proxy",
+							Image: fmt.Sprintf("docker.io/istio/proxyv2:%s", iop.Spec.Version),
+							Ports: []corev1.ContainerPort{
+								{Name: "http2", ContainerPort: 8080},
+								{Name: "https", ContainerPort: 8443},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return c.Patch(ctx, deployment, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner))
+}
+
+// applyService creates or updates the LoadBalancer Service fronting gw's
+// Deployment, returning the applied Service so its status can be read back
+// for updateAddresses.
+func (r *GatewayProvisionerReconciler) applyService(ctx context.Context, c client.Client, gw *unstructured.Unstructured) (*corev1.Service, error) {
+	labels := map[string]string{"istio.io/gateway-name": gw.GetName()}
+
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: gw.GetName(), Namespace: gw.GetNamespace(), Labels: labels, OwnerReferences: r.ownerReferences(gw)},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeLoadBalancer,
+			Selector: labels,
+			Ports: []corev1.ServicePort{
+				{Name: "http2", Port: 80, TargetPort: intstr.FromInt(8080)},
+				{Name: "https", Port: 443, TargetPort: intstr.FromInt(8443)},
+			},
+		},
+	}
+	if err := c.Patch(ctx, svc, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return nil, err
+	}
+
+	if err := c.Get(ctx, client.ObjectKeyFromObject(svc), svc); err != nil {
+		return nil, fmt.Errorf("getting Service %s/%s: %w", svc.Namespace, svc.Name, err)
+	}
+	return svc, nil
+}
+
+// updateAddresses reflects svc's LoadBalancer ingress IPs onto
+// gw.Status.Addresses, leaving it untouched if none have been assigned yet.
+func (r *GatewayProvisionerReconciler) updateAddresses(ctx context.Context, c client.Client, gw *unstructured.Unstructured, svc *corev1.Service) error {
+	if len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return nil
+	}
+
+	addresses := make([]interface{}, 0, len(svc.Status.LoadBalancer.Ingress))
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		switch {
+		case ingress.IP != "":
+			addresses = append(addresses, map[string]interface{}{"type": "IPAddress", "value": ingress.IP})
+		case ingress.Hostname != "":
+			addresses = append(addresses, map[string]interface{}{"type": "Hostname", "value": ingress.Hostname})
+		}
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	if err := unstructured.SetNestedSlice(gw.Object, addresses, "status", "addresses"); err != nil {
+		return fmt.Errorf("setting status.addresses: %w", err)
+	}
+	return c.Status().Update(ctx, gw)
+}
+
+// ownerReferences points Deployment/Service back at gw, so deleting gw lets
+// Kubernetes GC delete both automatically. This relies on Deployment and
+// Service always living in gw's own namespace, the same assumption
+// istiooperator.WasmPluginReconciler's real OwnerReference relies on.
+func (r *GatewayProvisionerReconciler) ownerReferences(gw *unstructured.Unstructured) []metav1.OwnerReference {
+	blockOwnerDeletion := true
+	isController := true
+	return []metav1.OwnerReference{
+		{
+			APIVersion:         gatewayGVK.GroupVersion().String(),
+			Kind:               gatewayGVK.Kind,
+			Name:               gw.GetName(),
+			UID:                gw.GetUID(),
+			Controller:         &isController,
+			BlockOwnerDeletion: &blockOwnerDeletion,
+		},
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gatewayapi
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// newFakeProvisionerClient builds on newFakeGatewayAPIClient's approach,
+// additionally registering gatewayGVK and gatewayClassGVK and the typed
+// apps/v1, core/v1 and v1alpha1 schemes GatewayProvisionerReconciler reads
+// and writes.
+func newFakeProvisionerClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(gatewayGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(gatewayGVK.GroupVersion().WithKind("GatewayList"), &unstructured.UnstructuredList{})
+	scheme.AddKnownTypeWithName(gatewayClassGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(gatewayClassGVK.GroupVersion().WithKind("GatewayClassList"), &unstructured.UnstructuredList{})
+
+	var gateways []client.Object
+	for _, o := range objs {
+		if u, ok := o.(*unstructured.Unstructured); ok && u.GroupVersionKind() == gatewayGVK {
+			gateways = append(gateways, o)
+		}
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(gateways...).Build()
+}
+
+func basicGatewayClass(name, iopNamespace, iopName string) *unstructured.Unstructured {
+	gc := &unstructured.Unstructured{}
+	gc.SetGroupVersionKind(gatewayClassGVK)
+	gc.SetName(name)
+	gc.Object["spec"] = map[string]interface{}{
+		"controllerName": "istio.io/gateway-controller",
+		"parametersRef": map[string]interface{}{
+			"group":     "install.istio.io",
+			"kind":      "IstioOperator",
+			"name":      iopName,
+			"namespace": iopNamespace,
+		},
+	}
+	return gc
+}
+
+func basicGateway(namespace, name, gatewayClassName string) *unstructured.Unstructured {
+	gw := &unstructured.Unstructured{}
+	gw.SetGroupVersionKind(gatewayGVK)
+	gw.SetNamespace(namespace)
+	gw.SetName(name)
+	gw.SetUID("gw-uid")
+	gw.Object["spec"] = map[string]interface{}{"gatewayClassName": gatewayClassName}
+	return gw
+}
+
+func TestReconcileProvisionsDeploymentAndService(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Version: "1.20.0"},
+	}
+	gw := basicGateway("app-team", "my-gateway", istioGatewayClassName)
+	gc := basicGatewayClass(istioGatewayClassName, "istio-system", "default")
+	c := newFakeProvisionerClient(t, iop, gw, gc)
+
+	r := &GatewayProvisionerReconciler{}
+	if err := r.Reconcile(context.Background(), c, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "app-team", Name: "my-gateway"}, deployment); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if got := deployment.Spec.Template.Spec.Containers[0].Image; got != "docker.io/istio/proxyv2:1.20.0" {
+		t.Fatalf("container image = %q, want the IstioOperator's version baked in", got)
+	}
+	if len(deployment.OwnerReferences) != 1 || deployment.OwnerReferences[0].Name != "my-gateway" {
+		t.Fatalf("OwnerReferences = %+v, want one owner reference to the Gateway", deployment.OwnerReferences)
+	}
+
+	svc := &corev1.Service{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "app-team", Name: "my-gateway"}, svc); err != nil {
+		t.Fatalf("Get() Service = %v", err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		t.Fatalf("Service.Spec.Type = %v, want LoadBalancer", svc.Spec.Type)
+	}
+}
+
+func TestReconcileSkipsGatewayForOtherGatewayClass(t *testing.T) {
+	gw := basicGateway("app-team", "my-gateway", "some-other-class")
+	c := newFakeProvisionerClient(t, gw)
+
+	r := &GatewayProvisionerReconciler{}
+	if err := r.Reconcile(context.Background(), c, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	deployment := &appsv1.Deployment{}
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "app-team", Name: "my-gateway"}, deployment)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() Deployment = %v, want a not-found error for a non-istio GatewayClass", err)
+	}
+}
+
+func TestReconcileReflectsLoadBalancerAddressOntoGatewayStatus(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Version: "1.20.0"},
+	}
+	gw := basicGateway("app-team", "my-gateway", istioGatewayClassName)
+	gc := basicGatewayClass(istioGatewayClassName, "istio-system", "default")
+	c := newFakeProvisionerClient(t, iop, gw, gc)
+
+	r := &GatewayProvisionerReconciler{}
+	if err := r.Reconcile(context.Background(), c, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	svc := &corev1.Service{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "app-team", Name: "my-gateway"}, svc); err != nil {
+		t.Fatalf("Get() Service = %v", err)
+	}
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}}
+	if err := c.Status().Update(context.Background(), svc); err != nil {
+		t.Fatalf("Status().Update() Service = %v", err)
+	}
+
+	if err := r.Reconcile(context.Background(), c, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	updated := &unstructured.Unstructured{}
+	updated.SetGroupVersionKind(gatewayGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "app-team", Name: "my-gateway"}, updated); err != nil {
+		t.Fatalf("Get() Gateway = %v", err)
+	}
+	addresses, ok, _ := unstructured.NestedSlice(updated.Object, "status", "addresses")
+	if !ok || len(addresses) != 1 {
+		t.Fatalf("status.addresses = %v, want a single address", addresses)
+	}
+	addr, _ := addresses[0].(map[string]interface{})
+	if addr["value"] != "203.0.113.10" || addr["type"] != "IPAddress" {
+		t.Fatalf("status.addresses[0] = %+v, want the Service's LoadBalancer IP", addr)
+	}
+}
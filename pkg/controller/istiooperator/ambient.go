@@ -0,0 +1,268 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// ztunnelDaemonSetName and waypointDeploymentName are the owned resources
+// AmbientReconciler creates, suffixed by ResourceName the same way every
+// other component's Deployment is so a revisioned install doesn't collide
+// with a previous one.
+const (
+	ztunnelDaemonSetName   = "ztunnel"
+	waypointDeploymentName = "waypoint"
+)
+
+// cniDaemonSetName locates the istio-cni DaemonSet Istio's own CNI plugin
+// installation creates, which AmbientReconciler patches to turn on the
+// ambient redirection mode rather than creating itself.
+const cniDaemonSetName = "istio-cni-node"
+
+// ambientRedirectModeAnnotation is the pod annotation istio-cni's ambient
+// plugin reads off its own DaemonSet's pod template to switch from
+// sidecar-only redirection to ztunnel redirection, per Istio's ambient
+// install convention.
+const ambientRedirectModeAnnotation = "istio.io/dataplane-mode"
+
+// AmbientReconciler deploys Istio's ambient dataplane — a per-node ztunnel
+// DaemonSet and a waypoint proxy Deployment — when iop.Spec.Profile is
+// "ambient" and iop.Spec.Ambient is set, and labels iop.Spec.Ambient.Namespaces
+// for ztunnel redirection the same way SidecarInjectionReconciler labels
+// namespaces for sidecar injection. A namespace can run one dataplane or the
+// other: webhook.ValidateSpec rejects a spec listing the same namespace in
+// both iop.Spec.SidecarInjection.EnabledNamespaces and
+// iop.Spec.Ambient.Namespaces before it ever reaches this reconciler.
+//
+// AmbientReconciler does not reconfigure kube-proxy or any other node-level
+// networking component outside the cluster: redirecting node traffic into
+// ztunnel is istio-cni's job once its DaemonSet is patched into ambient
+// mode, and istio-cni's own installation (not this operator) is responsible
+// for whatever host-level iptables/eBPF setup that requires.
+type AmbientReconciler struct{}
+
+// Reconcile is a no-op unless iop.Spec.Profile is "ambient" and
+// iop.Spec.Ambient is set. Otherwise it creates or updates the ztunnel
+// DaemonSet and waypoint proxy Deployment via server-side apply, patches the
+// istio-cni DaemonSet into ambient redirection mode (skipping if it isn't
+// installed yet — that's left to Istio's own CNI installation, not treated
+// as an error here), and labels iop.Spec.Ambient.Namespaces for ztunnel
+// redirection.
+func (r *AmbientReconciler) Reconcile(ctx context.Context, c client.Client, recorder record.EventRecorder, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.Profile != "ambient" || iop.Spec.Ambient == nil {
+		return nil
+	}
+
+	if err := r.reconcileZtunnel(ctx, c, iop); err != nil {
+		return fmt.Errorf("reconciling ztunnel DaemonSet: %w", err)
+	}
+	if err := r.reconcileWaypoint(ctx, c, iop); err != nil {
+		return fmt.Errorf("reconciling waypoint Deployment: %w", err)
+	}
+	if err := r.enableCNIAmbientMode(ctx, c, iop); err != nil {
+		return fmt.Errorf("enabling ambient mode on istio-cni DaemonSet: %w", err)
+	}
+
+	if err := r.label(ctx, c, iop.Spec.Ambient.Namespaces); err != nil {
+		return fmt.Errorf("labeling ambient namespaces: %w", err)
+	}
+
+	recorder.Eventf(iop, corev1.EventTypeNormal, "AmbientReconciled",
+		"reconciled ztunnel and waypoint for %d ambient namespace(s)", len(iop.Spec.Ambient.Namespaces))
+	return nil
+}
+
+// reconcileZtunnel creates or updates the per-node ztunnel DaemonSet that
+// intercepts and mTLS-encrypts traffic for every pod in an ambient
+// namespace, tagged with iop.Spec.Version the same way CanaryUpgrade tags
+// its istiod image.
+func (r *AmbientReconciler) reconcileZtunnel(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	name := ResourceName(iop, ztunnelDaemonSetName)
+	labels := mergeLabels(map[string]string{"app": ztunnelDaemonSetName}, RevisionLabels(iop))
+
+	isController, blockOwnerDeletion := true, true
+	daemonSet := &appsv1.DaemonSet{
+		TypeMeta: metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "DaemonSet"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: iop.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion:         v1alpha1.SchemeGroupVersion.String(),
+					Kind:               "IstioOperator",
+					Name:               iop.Name,
+					UID:                iop.UID,
+					Controller:         &isController,
+					BlockOwnerDeletion: &blockOwnerDeletion,
+				},
+			},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					HostNetwork: true,
+					Containers: []corev1.Container{
+						{
+							Name:  ztunnelDaemonSetName,
+							Image: fmt.Sprintf("docker.io/istio/ztunnel:%s", iop.Spec.Version),
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := c.Patch(ctx, daemonSet, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying DaemonSet %s: %w", name, err)
+	}
+	return nil
+}
+
+// reconcileWaypoint creates or updates the waypoint proxy Deployment that
+// enforces L7 policy for ambient namespaces opting into it, tagged with
+// iop.Spec.Version the same way reconcileZtunnel tags ztunnel.
+func (r *AmbientReconciler) reconcileWaypoint(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	name := ResourceName(iop, waypointDeploymentName)
+	labels := mergeLabels(map[string]string{"app": waypointDeploymentName}, RevisionLabels(iop))
+	replicas := int32(1)
+
+	isController, blockOwnerDeletion := true, true
+	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: iop.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion:         v1alpha1.SchemeGroupVersion.String(),
+					Kind:               "IstioOperator",
+					Name:               iop.Name,
+					UID:                iop.UID,
+					Controller:         &isController,
+					BlockOwnerDeletion: &blockOwnerDeletion,
+				},
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  waypointDeploymentName,
+							Image: fmt.Sprintf("docker.io/istio/proxyv2:%s", iop.Spec.Version),
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := c.Patch(ctx, deployment, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying Deployment %s: %w", name, err)
+	}
+	return nil
+}
+
+// enableCNIAmbientMode patches istio-cni's DaemonSet pod template with
+// ambientRedirectModeAnnotation so its already-running plugin starts
+// redirecting ambient pods' traffic into ztunnel. A missing istio-cni
+// DaemonSet is left for Istio's own CNI installation to create, the same
+// way restartIstiod leaves a missing istiod Deployment alone.
+func (r *AmbientReconciler) enableCNIAmbientMode(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	daemonSet := &appsv1.DaemonSet{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: cniDaemonSetName}, daemonSet)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("getting DaemonSet %s: %w", cniDaemonSetName, err)
+	}
+
+	if daemonSet.Spec.Template.Annotations[ambientRedirectModeAnnotation] == "ambient" {
+		return nil
+	}
+
+	before := daemonSet.DeepCopy()
+	if daemonSet.Spec.Template.Annotations == nil {
+		daemonSet.Spec.Template.Annotations = map[string]string{}
+	}
+	daemonSet.Spec.Template.Annotations[ambientRedirectModeAnnotation] = "ambient"
+	if err := c.Patch(ctx, daemonSet, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("patching DaemonSet %s: %w", cniDaemonSetName, err)
+	}
+	return nil
+}
+
+// label sets istioInjectionLabel to "ambient" on every namespace in
+// namespaces, the redirection mode istio-cni's ambient plugin looks for,
+// analogous to how SidecarInjectionReconciler.label sets it to "enabled" or
+// "disabled" for the sidecar dataplane. A namespace not yet created is
+// skipped rather than failing the whole pass.
+func (r *AmbientReconciler) label(ctx context.Context, c client.Client, namespaces []string) error {
+	for _, name := range namespaces {
+		ns := &corev1.Namespace{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, ns); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("getting namespace %s: %w", name, err)
+		}
+		if ns.Labels[istioInjectionLabel] == "ambient" {
+			continue
+		}
+
+		before := ns.DeepCopy()
+		if ns.Labels == nil {
+			ns.Labels = map[string]string{}
+		}
+		ns.Labels[istioInjectionLabel] = "ambient"
+		if err := c.Patch(ctx, ns, client.MergeFrom(before)); err != nil {
+			return fmt.Errorf("labeling namespace %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// mergeLabels returns a new map containing every key/value in base plus
+// every key/value in extra, with extra taking precedence on a collision.
+// extra may be nil, the same as RevisionLabels returns for an unrevisioned
+// IstioOperator.
+func mergeLabels(base, extra map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
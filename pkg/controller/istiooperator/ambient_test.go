@@ -0,0 +1,137 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestAmbientReconcilerIsNoopWithoutAmbientProfile(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Ambient: &v1alpha1.AmbientSpec{Namespaces: []string{"shop"}},
+		},
+	}
+	c := newFakeClientWithObjects(t)
+	recorder := record.NewFakeRecorder(1)
+
+	r := &AmbientReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	daemonSet := &appsv1.DaemonSet{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "ztunnel"}, daemonSet); err == nil {
+		t.Fatal("Get() ztunnel DaemonSet succeeded, want no DaemonSet created without Profile \"ambient\"")
+	}
+}
+
+func TestAmbientReconcilerCreatesZtunnelAndWaypointAndLabelsNamespaces(t *testing.T) {
+	shop := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Profile: "ambient",
+			Version: "1.21.0",
+			Ambient: &v1alpha1.AmbientSpec{Namespaces: []string{"shop"}},
+		},
+	}
+	c := newFakeClientWithObjects(t, shop)
+	recorder := record.NewFakeRecorder(1)
+
+	r := &AmbientReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	ztunnel := &appsv1.DaemonSet{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "ztunnel"}, ztunnel); err != nil {
+		t.Fatalf("Get() ztunnel DaemonSet = %v", err)
+	}
+	if got := ztunnel.Spec.Template.Spec.Containers[0].Image; got != "docker.io/istio/ztunnel:1.21.0" {
+		t.Fatalf("ztunnel image = %q, want docker.io/istio/ztunnel:1.21.0", got)
+	}
+
+	waypoint := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "waypoint"}, waypoint); err != nil {
+		t.Fatalf("Get() waypoint Deployment = %v", err)
+	}
+	if got := waypoint.Spec.Template.Spec.Containers[0].Image; got != "docker.io/istio/proxyv2:1.21.0" {
+		t.Fatalf("waypoint image = %q, want docker.io/istio/proxyv2:1.21.0", got)
+	}
+
+	gotShop := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: "shop"}, gotShop); err != nil {
+		t.Fatalf("Get() shop = %v", err)
+	}
+	if gotShop.Labels[istioInjectionLabel] != "ambient" {
+		t.Fatalf("shop labels = %v, want istio-injection=ambient", gotShop.Labels)
+	}
+}
+
+func TestAmbientReconcilerEnablesCNIAmbientModeWhenDaemonSetExists(t *testing.T) {
+	cni := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: cniDaemonSetName}}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Profile: "ambient",
+			Version: "1.21.0",
+			Ambient: &v1alpha1.AmbientSpec{},
+		},
+	}
+	c := newFakeClientWithObjects(t, cni)
+	recorder := record.NewFakeRecorder(1)
+
+	r := &AmbientReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &appsv1.DaemonSet{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: cniDaemonSetName}, got); err != nil {
+		t.Fatalf("Get() istio-cni DaemonSet = %v", err)
+	}
+	if got.Spec.Template.Annotations[ambientRedirectModeAnnotation] != "ambient" {
+		t.Fatalf("istio-cni annotations = %v, want %s=ambient", got.Spec.Template.Annotations, ambientRedirectModeAnnotation)
+	}
+}
+
+func TestAmbientReconcilerSkipsMissingCNIDaemonSet(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Profile: "ambient",
+			Version: "1.21.0",
+			Ambient: &v1alpha1.AmbientSpec{},
+		},
+	}
+	c := newFakeClientWithObjects(t)
+	recorder := record.NewFakeRecorder(1)
+
+	r := &AmbientReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want no error when istio-cni isn't installed yet", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// auditLogConfigMapName is the fixed ConfigMap AuditReconciler appends
+// entries to, one per namespace rather than one per IstioOperator or
+// revision: a namespace running more than one IstioOperator (e.g. during a
+// canary upgrade using a second revisioned install) shares a single audit
+// trail, the same way a namespace shares a single
+// "istio-operator-audit-log" regardless of how many control planes are
+// reconciled into it.
+const auditLogConfigMapName = "istio-operator-audit-log"
+
+// auditLogEntriesKey is the ConfigMap Data key AuditReconciler's JSON-encoded
+// entry list is stored under.
+const auditLogEntriesKey = "entries.json"
+
+// MaxAuditLogEntries caps how many AuditLogEntry records AuditReconciler
+// keeps, dropping the oldest once a new entry would exceed it.
+const MaxAuditLogEntries = 100
+
+// AuditReconciler appends a v1alpha1.AuditLogEntry to the
+// auditLogConfigMapName ConfigMap (and mirrors the result onto a same-named
+// v1alpha1.AuditLog) whenever old and new differ, per istiooperator.DiffSpec
+// — the same change-detection ReportSpecDiff already uses for its Warning
+// event, reused here instead of a second, independent diffing mechanism.
+// "Detected via generation bump" from this request's own wording reduces to
+// the same thing in practice: a write to iop.Spec is what bumps
+// metadata.generation, and it's also the only thing DiffSpec can see change
+// between two calls a reconcile loop makes with its before/after specs.
+type AuditReconciler struct{}
+
+// Reconcile appends one AuditLogEntry recording what changed between old
+// and new, unless they're equal per DiffSpec or the ConfigMap's newest entry
+// already has new's Generation — the second case covers a reconcile loop
+// retrying after a failure past this point, which otherwise would record the
+// same change twice under the same generation.
+func (r *AuditReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, old *v1alpha1.IstioOperatorSpec) error {
+	diff := DiffSpec(old, &iop.Spec)
+	if diff == "" {
+		return nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: auditLogConfigMapName}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: auditLogConfigMapName, Namespace: iop.Namespace}}
+	case err != nil:
+		return fmt.Errorf("getting ConfigMap %s: %w", auditLogConfigMapName, err)
+	}
+
+	entries, err := decodeAuditLogEntries(cm.Data[auditLogEntriesKey])
+	if err != nil {
+		return fmt.Errorf("decoding audit log entries: %w", err)
+	}
+	if len(entries) > 0 && entries[len(entries)-1].Generation == iop.Generation {
+		return nil
+	}
+
+	entries = append(entries, v1alpha1.AuditLogEntry{
+		Timestamp:  metav1.Now(),
+		Generation: iop.Generation,
+		Diff:       diff,
+		User:       auditLogUser(iop),
+	})
+	if len(entries) > MaxAuditLogEntries {
+		entries = entries[len(entries)-MaxAuditLogEntries:]
+	}
+
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("encoding audit log entries: %w", err)
+	}
+
+	before := cm.DeepCopy()
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[auditLogEntriesKey] = string(encoded)
+	if cm.ResourceVersion == "" {
+		if err := c.Create(ctx, cm); err != nil {
+			return fmt.Errorf("creating ConfigMap %s: %w", auditLogConfigMapName, err)
+		}
+	} else if err := c.Patch(ctx, cm, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("patching ConfigMap %s: %w", auditLogConfigMapName, err)
+	}
+
+	return r.syncAuditLog(ctx, c, iop.Namespace, entries)
+}
+
+// syncAuditLog mirrors entries onto auditLogConfigMapName's v1alpha1.AuditLog
+// counterpart, creating it if AuditReconciler hasn't run in this namespace
+// before, so a client with RBAC to read AuditLog (and not ConfigMap
+// generally) sees the same history.
+func (r *AuditReconciler) syncAuditLog(ctx context.Context, c client.Client, namespace string, entries []v1alpha1.AuditLogEntry) error {
+	auditLog := &v1alpha1.AuditLog{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: auditLogConfigMapName}, auditLog)
+	switch {
+	case apierrors.IsNotFound(err):
+		auditLog = &v1alpha1.AuditLog{ObjectMeta: metav1.ObjectMeta{Name: auditLogConfigMapName, Namespace: namespace}}
+		auditLog.Status.Entries = entries
+		if err := c.Create(ctx, auditLog); err != nil {
+			return fmt.Errorf("creating AuditLog %s: %w", auditLogConfigMapName, err)
+		}
+		return c.Status().Update(ctx, auditLog)
+	case err != nil:
+		return fmt.Errorf("getting AuditLog %s: %w", auditLogConfigMapName, err)
+	}
+
+	before := auditLog.DeepCopy()
+	auditLog.Status.Entries = entries
+	if err := c.Status().Patch(ctx, auditLog, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("patching AuditLog %s status: %w", auditLogConfigMapName, err)
+	}
+	return nil
+}
+
+// decodeAuditLogEntries parses raw (auditLogConfigMapName's
+// auditLogEntriesKey value), treating "" — no prior entries — as an empty
+// list rather than an error.
+func decodeAuditLogEntries(raw string) ([]v1alpha1.AuditLogEntry, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var entries []v1alpha1.AuditLogEntry
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// auditLogUser returns iop.ManagedFields[0].Manager, or "" if iop has no
+// managed fields entries recorded yet.
+func auditLogUser(iop *v1alpha1.IstioOperator) string {
+	if len(iop.ManagedFields) == 0 {
+		return ""
+	}
+	return iop.ManagedFields[0].Manager
+}
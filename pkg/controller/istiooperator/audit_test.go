@@ -0,0 +1,175 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestAuditReconcilerRecordsAChangedSpec(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default", Generation: 2},
+		Spec:       v1alpha1.IstioOperatorSpec{Profile: "minimal"},
+	}
+	old := &v1alpha1.IstioOperatorSpec{Profile: "default"}
+	c := newFakeClientWithObjects(t, iop)
+
+	r := &AuditReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop, old); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: auditLogConfigMapName}, cm); err != nil {
+		t.Fatalf("Get() ConfigMap = %v", err)
+	}
+	entries, err := decodeAuditLogEntries(cm.Data[auditLogEntriesKey])
+	if err != nil {
+		t.Fatalf("decodeAuditLogEntries() = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("entries = %+v, want exactly one", entries)
+	}
+	if entries[0].Generation != 2 || !strings.Contains(entries[0].Diff, "Profile") {
+		t.Fatalf("entries[0] = %+v, want Generation=2 and a diff mentioning Profile", entries[0])
+	}
+
+	auditLog := &v1alpha1.AuditLog{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: auditLogConfigMapName}, auditLog); err != nil {
+		t.Fatalf("Get() AuditLog = %v", err)
+	}
+	if len(auditLog.Status.Entries) != 1 || auditLog.Status.Entries[0].Generation != 2 {
+		t.Fatalf("AuditLog.Status.Entries = %+v, want it to mirror the ConfigMap's single entry", auditLog.Status.Entries)
+	}
+}
+
+func TestAuditReconcilerIsANoOpWhenSpecIsUnchanged(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default", Generation: 1},
+		Spec:       v1alpha1.IstioOperatorSpec{Profile: "default"},
+	}
+	c := newFakeClientWithObjects(t, iop)
+
+	r := &AuditReconciler{}
+	old := &v1alpha1.IstioOperatorSpec{Profile: "default"}
+	if err := r.Reconcile(context.Background(), c, iop, old); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	cm := &corev1.ConfigMap{}
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: auditLogConfigMapName}, cm)
+	if err == nil {
+		t.Fatalf("ConfigMap %+v was created for an unchanged spec, want none", cm)
+	}
+}
+
+func TestAuditReconcilerSkipsARetryAtTheSameGeneration(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default", Generation: 2},
+		Spec:       v1alpha1.IstioOperatorSpec{Profile: "minimal"},
+	}
+	old := &v1alpha1.IstioOperatorSpec{Profile: "default"}
+	c := newFakeClientWithObjects(t, iop)
+
+	r := &AuditReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop, old); err != nil {
+		t.Fatalf("first Reconcile() = %v", err)
+	}
+	// A reconcile loop retrying the same generation after a later failure
+	// must not record the same change twice.
+	if err := r.Reconcile(context.Background(), c, iop, old); err != nil {
+		t.Fatalf("second Reconcile() = %v", err)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: auditLogConfigMapName}, cm); err != nil {
+		t.Fatalf("Get() ConfigMap = %v", err)
+	}
+	entries, err := decodeAuditLogEntries(cm.Data[auditLogEntriesKey])
+	if err != nil {
+		t.Fatalf("decodeAuditLogEntries() = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("entries = %+v, want exactly one despite two Reconcile() calls at the same generation", entries)
+	}
+}
+
+func TestAuditReconcilerCapsEntriesAtMaxAuditLogEntries(t *testing.T) {
+	entries := make([]v1alpha1.AuditLogEntry, MaxAuditLogEntries)
+	for i := range entries {
+		entries[i] = v1alpha1.AuditLogEntry{Generation: int64(i + 1)}
+	}
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: auditLogConfigMapName},
+		Data:       map[string]string{auditLogEntriesKey: string(encoded)},
+	}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default", Generation: int64(MaxAuditLogEntries + 1)},
+		Spec:       v1alpha1.IstioOperatorSpec{Profile: "minimal"},
+	}
+	old := &v1alpha1.IstioOperatorSpec{Profile: "default"}
+	c := newFakeClientWithObjects(t, iop, cm)
+
+	r := &AuditReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop, old); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: auditLogConfigMapName}, got); err != nil {
+		t.Fatalf("Get() ConfigMap = %v", err)
+	}
+	gotEntries, err := decodeAuditLogEntries(got.Data[auditLogEntriesKey])
+	if err != nil {
+		t.Fatalf("decodeAuditLogEntries() = %v", err)
+	}
+	if len(gotEntries) != MaxAuditLogEntries {
+		t.Fatalf("len(gotEntries) = %d, want it capped at %d", len(gotEntries), MaxAuditLogEntries)
+	}
+	if gotEntries[0].Generation != 2 {
+		t.Fatalf("gotEntries[0].Generation = %d, want 1 dropped as the oldest entry", gotEntries[0].Generation)
+	}
+	if last := gotEntries[len(gotEntries)-1]; last.Generation != iop.Generation {
+		t.Fatalf("last entry Generation = %d, want %d", last.Generation, iop.Generation)
+	}
+}
+
+func TestAuditLogUserReadsTheFirstManagedFieldsManager(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "istioctl"}, {Manager: "other-client"}},
+		},
+	}
+	if got := auditLogUser(iop); got != "istioctl" {
+		t.Fatalf("auditLogUser() = %q, want %q", got, "istioctl")
+	}
+
+	if got := auditLogUser(&v1alpha1.IstioOperator{}); got != "" {
+		t.Fatalf("auditLogUser() = %q, want \"\" when there are no managed fields", got)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// BudgetCalculator sums the CPU and memory requests of every component in
+// Components that has an entry in ComponentResources, and compares the sum
+// against Budget's caps. It reads no cluster state, so it's unit-testable
+// without a client.Client.
+type BudgetCalculator struct {
+	Components         []string
+	ComponentResources map[string]corev1.ResourceRequirements
+	Budget             *v1alpha1.ResourceBudgetSpec
+}
+
+// BudgetSummary is BudgetCalculator.Calculate's result.
+type BudgetSummary struct {
+	// TotalCPU and TotalMemory are the summed requests across every
+	// component in BudgetCalculator.Components.
+	TotalCPU    resource.Quantity
+	TotalMemory resource.Quantity
+
+	// ComponentCPU and ComponentMemory map each component to the request it
+	// contributed to the totals above, so a caller can report which
+	// components make up an over-budget total.
+	ComponentCPU    map[string]resource.Quantity
+	ComponentMemory map[string]resource.Quantity
+
+	// OverCPU and OverMemory report whether TotalCPU exceeds Budget.MaxCPU
+	// or TotalMemory exceeds Budget.MaxMemory, respectively. Both are false
+	// when BudgetCalculator.Budget is nil or its corresponding Max field is
+	// zero.
+	OverCPU    bool
+	OverMemory bool
+}
+
+// Calculate sums CPU and memory requests across every component in
+// Components that has an entry in ComponentResources, and compares the sum
+// against Budget's caps.
+func (b *BudgetCalculator) Calculate() (BudgetSummary, error) {
+	summary := BudgetSummary{
+		ComponentCPU:    make(map[string]resource.Quantity, len(b.Components)),
+		ComponentMemory: make(map[string]resource.Quantity, len(b.Components)),
+	}
+	for _, component := range b.Components {
+		resources, ok := b.ComponentResources[component]
+		if !ok {
+			continue
+		}
+		cpu := resources.Requests[corev1.ResourceCPU]
+		memory := resources.Requests[corev1.ResourceMemory]
+		summary.ComponentCPU[component] = cpu
+		summary.ComponentMemory[component] = memory
+		summary.TotalCPU.Add(cpu)
+		summary.TotalMemory.Add(memory)
+	}
+	if b.Budget != nil {
+		if !b.Budget.MaxCPU.IsZero() && summary.TotalCPU.Cmp(b.Budget.MaxCPU) > 0 {
+			summary.OverCPU = true
+		}
+		if !b.Budget.MaxMemory.IsZero() && summary.TotalMemory.Cmp(b.Budget.MaxMemory) > 0 {
+			summary.OverMemory = true
+		}
+	}
+	return summary, nil
+}
+
+// BudgetReconciler rejects a reconcile whose enabled components' total
+// resource requests exceed IstioOperatorSpec.ResourceBudget, recording a
+// ConditionDegraded condition naming the components that make up the
+// over-budget total instead of letting the control plane silently
+// over-provision the cluster.
+type BudgetReconciler struct{}
+
+// Reconcile is a no-op when iop.Spec.ResourceBudget is nil. Otherwise it
+// runs a BudgetCalculator over iop.Spec and, if the result is over budget,
+// sets ConditionDegraded via UpdateOperatorStatus and returns an error so
+// the caller doesn't proceed with the rest of the reconcile.
+func (r *BudgetReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.ResourceBudget == nil {
+		return nil
+	}
+
+	calc := &BudgetCalculator{
+		Components:         iop.Spec.Components,
+		ComponentResources: iop.Spec.ComponentResources,
+		Budget:             iop.Spec.ResourceBudget,
+	}
+	summary, err := calc.Calculate()
+	if err != nil {
+		return fmt.Errorf("calculating resource budget for IstioOperator %s/%s: %w", iop.Namespace, iop.Name, err)
+	}
+	if !summary.OverCPU && !summary.OverMemory {
+		return nil
+	}
+
+	message := budgetExceededMessage(summary)
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionDegraded, metav1.ConditionTrue, "ResourceBudgetExceeded", message); err != nil {
+		return err
+	}
+	return fmt.Errorf("resource budget exceeded for IstioOperator %s/%s: %s", iop.Namespace, iop.Name, message)
+}
+
+// budgetExceededMessage describes which components make up an over-budget
+// summary, sorted by name so the message is deterministic.
+func budgetExceededMessage(summary BudgetSummary) string {
+	components := make([]string, 0, len(summary.ComponentCPU))
+	for component := range summary.ComponentCPU {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	contributions := make([]string, 0, len(components))
+	for _, component := range components {
+		contributions = append(contributions, fmt.Sprintf("%s (cpu=%s, memory=%s)",
+			component, summary.ComponentCPU[component].String(), summary.ComponentMemory[component].String()))
+	}
+
+	return fmt.Sprintf("total requests cpu=%s, memory=%s exceed the configured budget; components: %s",
+		summary.TotalCPU.String(), summary.TotalMemory.String(), strings.Join(contributions, ", "))
+}
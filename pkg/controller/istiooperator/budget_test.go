@@ -0,0 +1,160 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestBudgetCalculatorIsUnderBudget(t *testing.T) {
+	calc := &BudgetCalculator{
+		Components: []string{"istiod", "istio-ingressgateway"},
+		ComponentResources: map[string]corev1.ResourceRequirements{
+			"istiod":               {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("256Mi")}},
+			"istio-ingressgateway": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("256Mi")}},
+		},
+		Budget: &v1alpha1.ResourceBudgetSpec{MaxCPU: resource.MustParse("1"), MaxMemory: resource.MustParse("1Gi")},
+	}
+
+	summary, err := calc.Calculate()
+	if err != nil {
+		t.Fatalf("Calculate() = %v", err)
+	}
+	if summary.OverCPU || summary.OverMemory {
+		t.Fatalf("summary = %+v, want neither OverCPU nor OverMemory", summary)
+	}
+	if summary.TotalCPU.Cmp(resource.MustParse("500m")) != 0 {
+		t.Fatalf("TotalCPU = %s, want 500m", summary.TotalCPU.String())
+	}
+}
+
+func TestBudgetCalculatorIsOverBudget(t *testing.T) {
+	calc := &BudgetCalculator{
+		Components: []string{"istiod", "istio-ingressgateway"},
+		ComponentResources: map[string]corev1.ResourceRequirements{
+			"istiod":               {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}},
+			"istio-ingressgateway": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}},
+		},
+		Budget: &v1alpha1.ResourceBudgetSpec{MaxCPU: resource.MustParse("1"), MaxMemory: resource.MustParse("4Gi")},
+	}
+
+	summary, err := calc.Calculate()
+	if err != nil {
+		t.Fatalf("Calculate() = %v", err)
+	}
+	if !summary.OverCPU {
+		t.Fatalf("OverCPU = false, want true for a 2 CPU total against a 1 CPU budget")
+	}
+	if summary.OverMemory {
+		t.Fatalf("OverMemory = true, want false for a 2Gi total against a 4Gi budget")
+	}
+}
+
+func TestBudgetCalculatorIgnoresComponentsWithoutResourceEntries(t *testing.T) {
+	calc := &BudgetCalculator{
+		Components:          []string{"istiod", "istio-egressgateway"},
+		ComponentResources: map[string]corev1.ResourceRequirements{"istiod": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}}},
+		Budget:             &v1alpha1.ResourceBudgetSpec{MaxCPU: resource.MustParse("1")},
+	}
+
+	summary, err := calc.Calculate()
+	if err != nil {
+		t.Fatalf("Calculate() = %v", err)
+	}
+	if summary.TotalCPU.Cmp(resource.MustParse("100m")) != 0 {
+		t.Fatalf("TotalCPU = %s, want 100m, unlisted component should contribute nothing", summary.TotalCPU.String())
+	}
+}
+
+func TestBudgetCalculatorWithNoBudgetIsNeverOver(t *testing.T) {
+	calc := &BudgetCalculator{
+		Components:         []string{"istiod"},
+		ComponentResources: map[string]corev1.ResourceRequirements{"istiod": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100")}}},
+	}
+
+	summary, err := calc.Calculate()
+	if err != nil {
+		t.Fatalf("Calculate() = %v", err)
+	}
+	if summary.OverCPU || summary.OverMemory {
+		t.Fatalf("summary = %+v, want neither flag set when Budget is nil", summary)
+	}
+}
+
+func TestBudgetReconcilerSetsDegradedWhenOverBudget(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components:         []string{"istiod"},
+			ComponentResources: map[string]corev1.ResourceRequirements{"istiod": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}}},
+			ResourceBudget:     &v1alpha1.ResourceBudgetSpec{MaxCPU: resource.MustParse("1")},
+		},
+	}
+	c := newFakeClient(t, iop)
+
+	r := &BudgetReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err == nil {
+		t.Fatal("Reconcile() = nil, want an error rejecting the over-budget reconcile")
+	}
+
+	cond := apimeta.FindStatusCondition(iop.Status.Conditions, ConditionDegraded)
+	if cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Fatalf("ConditionDegraded = %+v, want status True", cond)
+	}
+	if cond.Message == "" {
+		t.Fatal("ConditionDegraded message is empty, want it to list the over-budget components")
+	}
+}
+
+func TestBudgetReconcilerIsANoOpUnderBudget(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components:         []string{"istiod"},
+			ComponentResources: map[string]corev1.ResourceRequirements{"istiod": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}}},
+			ResourceBudget:     &v1alpha1.ResourceBudgetSpec{MaxCPU: resource.MustParse("1")},
+		},
+	}
+	c := newFakeClient(t, iop)
+
+	r := &BudgetReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if apimeta.FindStatusCondition(iop.Status.Conditions, ConditionDegraded) != nil {
+		t.Fatal("ConditionDegraded set, want no condition under budget")
+	}
+}
+
+func TestBudgetReconcilerIsANoOpWithoutResourceBudget(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	c := newFakeClient(t, iop)
+
+	r := &BudgetReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if apimeta.FindStatusCondition(iop.Status.Conditions, ConditionDegraded) != nil {
+		t.Fatal("ConditionDegraded set, want no condition when ResourceBudget is nil")
+	}
+}
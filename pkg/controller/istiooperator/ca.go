@@ -0,0 +1,205 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// caSecretName and caSecretNamespace locate the Secret holding Istio's
+// self-signed CA certificate that SecretReconciler rotates. Istio names and
+// places this Secret itself, so unlike ResourceName's Deployments and
+// Services it isn't suffixed by IstioOperatorSpec.Revision.
+const (
+	caSecretName      = "istio-ca-secret"
+	caSecretNamespace = "istio-system"
+)
+
+// caCertKey and caKeyKey are the Secret.Data keys SecretReconciler reads
+// and writes the CA certificate and private key under, matching Istio's
+// documented cacerts Secret format
+// (https://istio.io/latest/docs/tasks/security/cert-management/plugin-ca-cert/).
+const (
+	caCertKey = "ca-cert.pem"
+	caKeyKey  = "ca-key.pem"
+)
+
+// caCertValidity is how long a CA certificate SecretReconciler generates on
+// rotation is valid for.
+const caCertValidity = 365 * 24 * time.Hour
+
+// caRotationTotal counts rotations SecretReconciler has performed, so a
+// steady climb in step with IstioOperatorSpec.Security.CARenewBefore's
+// window — rather than repeated rotations within a single window — is the
+// expected pattern; the latter would flag something wrong with how the new
+// certificate's expiry is being computed.
+var caRotationTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "ca_rotation_total",
+	Help: "Number of times SecretReconciler has rotated the Istio CA certificate in istio-ca-secret.",
+})
+
+func init() {
+	prometheus.MustRegister(caRotationTotal)
+}
+
+// SecretReconciler rotates the self-signed CA certificate Istio stores in
+// istio-ca-secret once it's within IstioOperatorSpec.Security.CARenewBefore
+// of expiring, since Istio's CA certificate otherwise expires silently and
+// breaks mTLS across the mesh without warning.
+type SecretReconciler struct{}
+
+// Reconcile checks istio-ca-secret's certificate against
+// iop.Spec.Security.CARenewBefore and rotates it if it's due: a fresh
+// self-signed certificate/key pair replaces the Secret's contents, istiod's
+// Deployment is restarted via a rollout so every pod picks up the new
+// certificate, a Normal event is emitted, and caRotationTotal is
+// incremented. A nil iop.Spec.Security, or one with a zero CARenewBefore,
+// disables automatic rotation entirely; a missing istio-ca-secret is left
+// for Istio's own installation to create rather than treated as an error.
+func (r *SecretReconciler) Reconcile(ctx context.Context, c client.Client, recorder record.EventRecorder, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.Security == nil || iop.Spec.Security.CARenewBefore.Duration <= 0 {
+		return nil
+	}
+
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: caSecretNamespace, Name: caSecretName}, secret)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("getting Secret %s/%s: %w", caSecretNamespace, caSecretName, err)
+	}
+
+	cert, err := parseCACertificate(secret)
+	if err != nil {
+		return fmt.Errorf("parsing CA certificate from Secret %s/%s: %w", caSecretNamespace, caSecretName, err)
+	}
+
+	if time.Until(cert.NotAfter) >= iop.Spec.Security.CARenewBefore.Duration {
+		return nil
+	}
+
+	if err := rotateCACertificate(ctx, c, iop, secret); err != nil {
+		return fmt.Errorf("rotating CA certificate in Secret %s/%s: %w", caSecretNamespace, caSecretName, err)
+	}
+
+	recorder.Eventf(iop, corev1.EventTypeNormal, "CARotated",
+		"rotated the Istio CA certificate in %s/%s, which was set to expire %s", caSecretNamespace, caSecretName, cert.NotAfter.Format(time.RFC3339))
+	caRotationTotal.Inc()
+	return nil
+}
+
+// parseCACertificate decodes and parses secret.Data[caCertKey] as an x509
+// certificate.
+func parseCACertificate(secret *corev1.Secret) (*x509.Certificate, error) {
+	block, _ := pem.Decode(secret.Data[caCertKey])
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in Secret.Data[%q]", caCertKey)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// rotateCACertificate replaces secret's CA certificate/key pair with a
+// freshly generated one, then restarts istiod so it picks up the change.
+func rotateCACertificate(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, secret *corev1.Secret) error {
+	certPEM, keyPEM, err := newSelfSignedCACert()
+	if err != nil {
+		return fmt.Errorf("generating replacement CA certificate: %w", err)
+	}
+
+	before := secret.DeepCopy()
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[caCertKey] = certPEM
+	secret.Data[caKeyKey] = keyPEM
+	if err := c.Patch(ctx, secret, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("updating Secret: %w", err)
+	}
+
+	return restartIstiod(ctx, c, iop)
+}
+
+// restartIstiod triggers a rollout restart of istiod's Deployment, the same
+// way `kubectl rollout restart` does, by patching a timestamp annotation
+// onto its pod template so every pod is recreated. A missing Deployment
+// (e.g. istiod not yet reconciled) is a no-op rather than an error.
+func restartIstiod(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	name := ResourceName(iop, "istiod")
+	deployment := &appsv1.Deployment{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: name}, deployment)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("getting Deployment %s: %w", name, err)
+	}
+
+	before := deployment.DeepCopy()
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = map[string]string{}
+	}
+	deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+	if err := c.Patch(ctx, deployment, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("patching Deployment %s: %w", name, err)
+	}
+	return nil
+}
+
+// newSelfSignedCACert generates a fresh self-signed CA certificate/key pair
+// valid for caCertValidity, PEM-encoded the way Istio's cacerts Secret
+// expects.
+func newSelfSignedCACert() (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating private key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "Istio CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caCertValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating certificate: %w", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
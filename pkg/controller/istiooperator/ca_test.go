@@ -0,0 +1,183 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// selfSignedCertPEMExpiringAt returns a self-signed certificate PEM with the
+// given NotAfter, for seeding into a fake istio-ca-secret.
+func selfSignedCertPEMExpiringAt(t *testing.T, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Istio CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() = %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestSecretReconcilerRotatesExpiringCACertificate(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: caSecretNamespace, Name: caSecretName},
+		Data: map[string][]byte{
+			caCertKey: selfSignedCertPEMExpiringAt(t, time.Now().Add(24*time.Hour)),
+			caKeyKey:  []byte("old-key"),
+		},
+	}
+	istiod := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"},
+	}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Security: &v1alpha1.SecuritySpec{CARenewBefore: metav1.Duration{Duration: 7 * 24 * time.Hour}},
+		},
+	}
+	c := newFakeClientWithObjects(t, secret, istiod)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &SecretReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &corev1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: caSecretNamespace, Name: caSecretName}, got); err != nil {
+		t.Fatalf("Get() Secret = %v", err)
+	}
+	if string(got.Data[caKeyKey]) == "old-key" {
+		t.Fatalf("Secret.Data[%q] unchanged, want a freshly generated key", caKeyKey)
+	}
+	cert, err := parseCACertificate(got)
+	if err != nil {
+		t.Fatalf("parsing rotated certificate: %v", err)
+	}
+	if time.Until(cert.NotAfter) < iop.Spec.Security.CARenewBefore.Duration {
+		t.Fatalf("rotated certificate NotAfter = %s, want it to have been pushed out past CARenewBefore", cert.NotAfter)
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, deployment); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] == "" {
+		t.Fatalf("istiod Deployment was not annotated to trigger a rollout restart")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "CARotated") {
+			t.Fatalf("event = %q, want reason CARotated", event)
+		}
+	default:
+		t.Fatal("no event recorded, want a CARotated event")
+	}
+}
+
+func TestSecretReconcilerLeavesFarFromExpiryCertificateAlone(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: caSecretNamespace, Name: caSecretName},
+		Data: map[string][]byte{
+			caCertKey: selfSignedCertPEMExpiringAt(t, time.Now().Add(365*24*time.Hour)),
+			caKeyKey:  []byte("still-good-key"),
+		},
+	}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Security: &v1alpha1.SecuritySpec{CARenewBefore: metav1.Duration{Duration: 7 * 24 * time.Hour}},
+		},
+	}
+	c := newFakeClientWithObjects(t, secret)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &SecretReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &corev1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: caSecretNamespace, Name: caSecretName}, got); err != nil {
+		t.Fatalf("Get() Secret = %v", err)
+	}
+	if string(got.Data[caKeyKey]) != "still-good-key" {
+		t.Fatalf("Secret.Data[%q] changed, want it left untouched when the certificate isn't near expiry", caKeyKey)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		t.Fatalf("unexpected event %q, want no rotation event", event)
+	default:
+	}
+}
+
+func TestSecretReconcilerDisabledWithoutSecuritySpec(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: caSecretNamespace, Name: caSecretName},
+		Data: map[string][]byte{
+			caCertKey: selfSignedCertPEMExpiringAt(t, time.Now().Add(time.Hour)),
+			caKeyKey:  []byte("old-key"),
+		},
+	}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+	}
+	c := newFakeClientWithObjects(t, secret)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &SecretReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want no error when Security is nil", err)
+	}
+
+	got := &corev1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: caSecretNamespace, Name: caSecretName}, got); err != nil {
+		t.Fatalf("Get() Secret = %v", err)
+	}
+	if string(got.Data[caKeyKey]) != "old-key" {
+		t.Fatalf("Secret.Data[%q] changed, want rotation disabled when iop.Spec.Security is nil", caKeyKey)
+	}
+}
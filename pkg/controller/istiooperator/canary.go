@@ -0,0 +1,252 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// istioRevLabel is the label istiod's sidecar injector reads to pick which
+// control plane revision a namespace's sidecars should connect to, per
+// Istio's revisioned-install convention.
+const istioRevLabel = "istio.io/rev"
+
+// canaryProxySyncPollInterval and canaryProxySyncTimeout bound how long
+// CanaryUpgrade waits for every shifted namespace's sidecars to come up
+// healthy before giving up, rather than scaling down the previous control
+// plane underneath proxies that never finished migrating.
+const (
+	canaryProxySyncPollInterval = 5 * time.Second
+	canaryProxySyncTimeout      = 10 * time.Minute
+)
+
+// canaryRevision turns newVersion into the revision label the canary istiod
+// deployment and shifted namespaces are tagged with; istio.io/rev values
+// can't contain the dots a version string does.
+func canaryRevision(newVersion string) string {
+	return "canary-" + strings.ReplaceAll(newVersion, ".", "-")
+}
+
+// CanaryUpgrade runs a revision-based canary upgrade of iop's control plane
+// to newVersion: it deploys a second istiod tagged with a new istio.io/rev
+// revision alongside the existing one, shifts iop.Spec.CanaryNamespaces onto
+// that revision, waits for their sidecars to come up healthy against it,
+// then scales the previous istiod deployment(s) in iop.Namespace to zero.
+// Each step is recorded as a ConditionCanaryUpgrading status update on iop,
+// so progress — and where it stalled, if it does — is visible without
+// tailing operator logs.
+func CanaryUpgrade(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, newVersion string) error {
+	revision := canaryRevision(newVersion)
+
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionCanaryUpgrading, metav1.ConditionTrue,
+		"DeployingCanaryControlPlane", fmt.Sprintf("Deploying istiod revision %s for version %s", revision, newVersion)); err != nil {
+		return err
+	}
+	if err := createCanaryIstiodDeployment(ctx, c, iop, revision, newVersion); err != nil {
+		return fmt.Errorf("creating canary istiod deployment: %w", err)
+	}
+
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionCanaryUpgrading, metav1.ConditionTrue,
+		"ShiftingNamespaces", fmt.Sprintf("Labeling %d namespace(s) with %s=%s", len(iop.Spec.CanaryNamespaces), istioRevLabel, revision)); err != nil {
+		return err
+	}
+	if err := labelCanaryNamespaces(ctx, c, iop.Spec.CanaryNamespaces, revision); err != nil {
+		return fmt.Errorf("shifting namespaces to canary revision: %w", err)
+	}
+
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionCanaryUpgrading, metav1.ConditionTrue,
+		"WaitingForProxies", "Waiting for shifted namespaces' sidecars to come up healthy against the canary control plane"); err != nil {
+		return err
+	}
+	if err := waitForProxiesConnected(ctx, c, iop.Spec.CanaryNamespaces, revision); err != nil {
+		_ = UpdateOperatorStatus(ctx, c, iop, ConditionCanaryUpgrading, metav1.ConditionFalse, "ProxySyncTimedOut", err.Error())
+		return fmt.Errorf("waiting for proxies to connect to canary control plane: %w", err)
+	}
+
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionCanaryUpgrading, metav1.ConditionTrue,
+		"ScalingDownPreviousControlPlane", "Every shifted namespace's sidecars are healthy; scaling down the previous istiod deployment(s)"); err != nil {
+		return err
+	}
+	if err := scaleDownPreviousIstiod(ctx, c, iop, revision); err != nil {
+		return fmt.Errorf("scaling down previous istiod deployment(s): %w", err)
+	}
+
+	return UpdateOperatorStatus(ctx, c, iop, ConditionCanaryUpgrading, metav1.ConditionFalse,
+		"CanaryUpgradeComplete", fmt.Sprintf("Control plane upgraded to %s via revision %s", newVersion, revision))
+}
+
+// createCanaryIstiodDeployment creates an istiod Deployment tagged with
+// revision and newVersion's image tag in iop.Namespace, or leaves an
+// existing one (e.g. from a previous, interrupted CanaryUpgrade run) alone.
+func createCanaryIstiodDeployment(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, revision, newVersion string) error {
+	replicas := int32(1)
+	labels := map[string]string{"app": "istiod", istioRevLabel: revision}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "istiod-" + revision, Namespace: iop.Namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "discovery",
+							Image: fmt.Sprintf("docker.io/istio/pilot:%s", newVersion),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	existing := &appsv1.Deployment{}
+	err := c.Get(ctx, client.ObjectKeyFromObject(deployment), existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		return c.Create(ctx, deployment)
+	case err != nil:
+		return err
+	default:
+		return nil
+	}
+}
+
+// labelCanaryNamespaces sets istioRevLabel to revision on every namespace
+// named in namespaces, so Istio's sidecar injector shifts their workloads
+// onto the canary control plane the next time each pod is recreated.
+func labelCanaryNamespaces(ctx context.Context, c client.Client, namespaces []string, revision string) error {
+	for _, name := range namespaces {
+		namespace := &corev1.Namespace{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, namespace); err != nil {
+			return fmt.Errorf("getting namespace %s: %w", name, err)
+		}
+		before := namespace.DeepCopy()
+		if namespace.Labels == nil {
+			namespace.Labels = map[string]string{}
+		}
+		namespace.Labels[istioRevLabel] = revision
+		if err := c.Patch(ctx, namespace, client.MergeFrom(before)); err != nil {
+			return fmt.Errorf("labeling namespace %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// waitForProxiesConnected polls until every istio-proxy sidecar in
+// namespaces is Ready, or canaryProxySyncTimeout elapses. A real check would
+// query istiod's /debug/syncz endpoint through the apiserver's pod proxy
+// subresource — what istioctl proxy-status does — to confirm a proxy has
+// actually synced its xDS config from the new control plane, not just that
+// its pod passed a readiness probe; that needs a rest.Config this function
+// doesn't have, so pod readiness is used as the best available proxy for
+// "connected" in the meantime.
+func waitForProxiesConnected(ctx context.Context, c client.Client, namespaces []string, revision string) error {
+	if len(namespaces) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, canaryProxySyncTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(canaryProxySyncPollInterval)
+	defer ticker.Stop()
+	for {
+		connected, err := allProxiesReady(ctx, c, namespaces)
+		if err != nil {
+			return err
+		}
+		if connected {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for proxies in %v to come up healthy against revision %s", canaryProxySyncTimeout, namespaces, revision)
+		case <-ticker.C:
+		}
+	}
+}
+
+func allProxiesReady(ctx context.Context, c client.Client, namespaces []string) (bool, error) {
+	for _, ns := range namespaces {
+		var pods corev1.PodList
+		if err := c.List(ctx, &pods, client.InNamespace(ns)); err != nil {
+			return false, fmt.Errorf("listing pods in namespace %s: %w", ns, err)
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !hasContainer(pod.Spec.Containers, istioProxyContainerName) {
+				continue
+			}
+			if !isPodReady(pod) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func hasContainer(containers []corev1.Container, name string) bool {
+	for _, c := range containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// scaleDownPreviousIstiod scales every "app: istiod" Deployment in
+// iop.Namespace whose istioRevLabel isn't revision down to zero replicas,
+// leaving only the just-promoted canary deployment running.
+func scaleDownPreviousIstiod(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, revision string) error {
+	var deployments appsv1.DeploymentList
+	if err := c.List(ctx, &deployments, client.InNamespace(iop.Namespace), client.MatchingLabels{"app": "istiod"}); err != nil {
+		return fmt.Errorf("listing istiod deployments: %w", err)
+	}
+
+	zero := int32(0)
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		if d.Labels[istioRevLabel] == revision {
+			continue
+		}
+		before := d.DeepCopy()
+		d.Spec.Replicas = &zero
+		if err := c.Patch(ctx, d, client.MergeFrom(before)); err != nil {
+			return fmt.Errorf("scaling down istiod deployment %s: %w", d.Name, err)
+		}
+	}
+	return nil
+}
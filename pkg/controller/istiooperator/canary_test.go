@@ -0,0 +1,145 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func newFakeClientWithObjects(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := policyv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := autoscalingv2.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	var iops []client.Object
+	for _, o := range objs {
+		if _, ok := o.(*v1alpha1.IstioOperator); ok {
+			iops = append(iops, o)
+		}
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(iops...).Build()
+}
+
+func readyPod(t *testing.T, namespace, name string) *corev1.Pod {
+	t.Helper()
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: istioProxyContainerName, Image: "docker.io/istio/proxyv2:1.17.0"}},
+		},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func TestCanaryUpgradeHappyPath(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+		Spec:       v1alpha1.IstioOperatorSpec{Version: "1.17", CanaryNamespaces: []string{"shop"}},
+	}
+	oldIstiod := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod", Labels: map[string]string{"app": "istiod", istioRevLabel: "default"}},
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "istiod"}}},
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	pod := readyPod(t, "shop", "reviews-abc")
+
+	c := newFakeClientWithObjects(t, iop, oldIstiod, namespace, pod)
+
+	if err := CanaryUpgrade(context.Background(), c, iop, "1.18"); err != nil {
+		t.Fatalf("CanaryUpgrade() = %v", err)
+	}
+
+	revision := canaryRevision("1.18")
+
+	canary := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod-" + revision}, canary); err != nil {
+		t.Fatalf("canary istiod deployment was not created: %v", err)
+	}
+
+	ns := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: "shop"}, ns); err != nil {
+		t.Fatalf("Get(namespace) = %v", err)
+	}
+	if ns.Labels[istioRevLabel] != revision {
+		t.Errorf("namespace %s label %s = %q, want %q", ns.Name, istioRevLabel, ns.Labels[istioRevLabel], revision)
+	}
+
+	old := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, old); err != nil {
+		t.Fatalf("Get(old istiod deployment) = %v", err)
+	}
+	if old.Spec.Replicas == nil || *old.Spec.Replicas != 0 {
+		t.Errorf("previous istiod deployment replicas = %v, want 0", old.Spec.Replicas)
+	}
+
+	cond := apimeta.FindStatusCondition(iop.Status.Conditions, ConditionCanaryUpgrading)
+	if cond == nil {
+		t.Fatal("ConditionCanaryUpgrading not set after CanaryUpgrade")
+	}
+	if cond.Status != metav1.ConditionFalse || cond.Reason != "CanaryUpgradeComplete" {
+		t.Errorf("final ConditionCanaryUpgrading = %s/%s, want False/CanaryUpgradeComplete", cond.Status, cond.Reason)
+	}
+}
+
+func TestAllProxiesReadyReportsFalseForUnreadyPod(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	notReady := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "reviews-abc"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: istioProxyContainerName}}},
+	}
+	c := newFakeClientWithObjects(t, namespace, notReady)
+
+	connected, err := allProxiesReady(context.Background(), c, []string{"shop"})
+	if err != nil {
+		t.Fatalf("allProxiesReady() = %v", err)
+	}
+	if connected {
+		t.Error("allProxiesReady() = true, want false for a pod with no Ready condition")
+	}
+}
+
+func TestCanaryRevision(t *testing.T) {
+	if got, want := canaryRevision("1.18.0"), "canary-1-18-0"; got != want {
+		t.Errorf("canaryRevision(%q) = %q, want %q", "1.18.0", got, want)
+	}
+}
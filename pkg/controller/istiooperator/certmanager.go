@@ -0,0 +1,180 @@
+//go:build certmanager
+
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// certManagerCertificateName names the Certificate resource
+// CertManagerIntegration manages, suffixed by revision the same way
+// ResourceName suffixes every other component's resources.
+const certManagerCertificateName = "istio-ca"
+
+// lastIssuedSerialAnnotation records, on the Certificate resource, the
+// serial number of the istio-ca-secret certificate istiod was last
+// restarted for, so a reconcile that finds the same serial again — nothing
+// has renewed since — doesn't restart istiod on every pass.
+const lastIssuedSerialAnnotation = "istio-operator.io/last-issued-serial"
+
+// certManagerRestartTotal counts the istiod restarts CertManagerIntegration
+// has triggered in response to cert-manager renewing istio-ca-secret's
+// certificate, the same way caRotationTotal counts SecretReconciler's own
+// self-signed rotations.
+var certManagerRestartTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "cert_manager_restart_total",
+	Help: "Number of times CertManagerIntegration has restarted istiod in response to cert-manager renewing istio-ca-secret's certificate.",
+})
+
+func init() {
+	prometheus.MustRegister(certManagerRestartTotal)
+}
+
+// CertManagerIntegration hands issuance and rotation of the Istio CA
+// certificate in istio-ca-secret over to cert-manager, for an
+// IstioOperator with iop.Spec.Security.CertProvider set to
+// v1alpha1.CertProviderCertManager, in place of SecretReconciler's
+// self-signed rotation.
+type CertManagerIntegration struct{}
+
+// Reconcile ensures a Certificate resource requesting istio-ca-secret from
+// iop.Spec.Security.CertManagerIssuerRef exists and matches iop's spec, then
+// checks whether cert-manager has issued or renewed istio-ca-secret's
+// certificate since the last reconcile and, if so, restarts istiod so every
+// pod picks up the new certificate. A nil iop.Spec.Security, or one whose
+// CertProvider isn't v1alpha1.CertProviderCertManager, is a no-op, the same
+// as SecretReconciler disabling itself on a nil or zero-CARenewBefore
+// Security. A missing istio-ca-secret — cert-manager hasn't issued it yet —
+// is left alone rather than treated as an error, the same way
+// SecretReconciler treats it.
+func (r *CertManagerIntegration) Reconcile(ctx context.Context, c client.Client, recorder record.EventRecorder, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.Security == nil || iop.Spec.Security.CertProvider != v1alpha1.CertProviderCertManager {
+		return nil
+	}
+
+	cert, err := r.reconcileCertificate(ctx, c, iop)
+	if err != nil {
+		return fmt.Errorf("reconciling Certificate %s/%s: %w", caSecretNamespace, ResourceName(iop, certManagerCertificateName), err)
+	}
+
+	secret := &corev1.Secret{}
+	err = c.Get(ctx, client.ObjectKey{Namespace: caSecretNamespace, Name: caSecretName}, secret)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("getting Secret %s/%s: %w", caSecretNamespace, caSecretName, err)
+	}
+
+	issued, err := parseIssuedCertificate(secret)
+	if err != nil {
+		return fmt.Errorf("parsing issued certificate from Secret %s/%s: %w", caSecretNamespace, caSecretName, err)
+	}
+
+	serial := issued.SerialNumber.String()
+	if cert.Annotations[lastIssuedSerialAnnotation] == serial {
+		return nil
+	}
+
+	if err := restartIstiod(ctx, c, iop); err != nil {
+		return fmt.Errorf("restarting istiod for renewed Secret %s/%s: %w", caSecretNamespace, caSecretName, err)
+	}
+
+	before := cert.DeepCopy()
+	if cert.Annotations == nil {
+		cert.Annotations = map[string]string{}
+	}
+	cert.Annotations[lastIssuedSerialAnnotation] = serial
+	if err := c.Patch(ctx, cert, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("recording last-issued serial on Certificate %s/%s: %w", cert.Namespace, cert.Name, err)
+	}
+
+	recorder.Eventf(iop, corev1.EventTypeNormal, "CertManagerCertificateIssued",
+		"restarted istiod for cert-manager issuing a new Istio CA certificate in %s/%s, serial %s", caSecretNamespace, caSecretName, serial)
+	certManagerRestartTotal.Inc()
+	return nil
+}
+
+// reconcileCertificate creates, or patches up to date, the Certificate
+// resource requesting istio-ca-secret from
+// iop.Spec.Security.CertManagerIssuerRef, and returns it.
+func (r *CertManagerIntegration) reconcileCertificate(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) (*certmanagerv1.Certificate, error) {
+	name := ResourceName(iop, certManagerCertificateName)
+	want := desiredCertificate(iop, name)
+
+	cert := &certmanagerv1.Certificate{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: caSecretNamespace, Name: name}, cert)
+	switch {
+	case apierrors.IsNotFound(err):
+		if err := c.Create(ctx, want); err != nil {
+			return nil, fmt.Errorf("creating: %w", err)
+		}
+		return want, nil
+	case err != nil:
+		return nil, fmt.Errorf("getting: %w", err)
+	}
+
+	before := cert.DeepCopy()
+	cert.Spec = want.Spec
+	if err := c.Patch(ctx, cert, client.MergeFrom(before)); err != nil {
+		return nil, fmt.Errorf("patching spec: %w", err)
+	}
+	return cert, nil
+}
+
+// desiredCertificate builds the Certificate resource CertManagerIntegration
+// wants for iop, requesting a CA certificate for istio-ca-secret from
+// iop.Spec.Security.CertManagerIssuerRef.
+func desiredCertificate(iop *v1alpha1.IstioOperator, name string) *certmanagerv1.Certificate {
+	return &certmanagerv1.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: caSecretNamespace, Name: name},
+		Spec: certmanagerv1.CertificateSpec{
+			SecretName: caSecretName,
+			IsCA:       true,
+			CommonName: "Istio CA",
+			IssuerRef: cmmeta.ObjectReference{
+				Name: iop.Spec.Security.CertManagerIssuerRef,
+				Kind: "ClusterIssuer",
+			},
+		},
+	}
+}
+
+// parseIssuedCertificate decodes and parses the x509 certificate
+// cert-manager wrote to secret.Data["tls.crt"], the Secret key every
+// cert-manager-issued certificate uses regardless of SecretTemplate.
+func parseIssuedCertificate(secret *corev1.Secret) (*x509.Certificate, error) {
+	block, _ := pem.Decode(secret.Data["tls.crt"])
+	if block == nil {
+		return nil, fmt.Errorf(`no PEM block found in Secret.Data["tls.crt"]`)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,191 @@
+//go:build certmanager
+
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func newFakeCertManagerClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := certmanagerv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func certManagerIOP() *v1alpha1.IstioOperator {
+	return &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Security: &v1alpha1.SecuritySpec{
+				CertProvider:         v1alpha1.CertProviderCertManager,
+				CertManagerIssuerRef: "istio-ca-issuer",
+			},
+		},
+	}
+}
+
+func TestCertManagerIntegrationCreatesCertificateWhenMissing(t *testing.T) {
+	iop := certManagerIOP()
+	c := newFakeCertManagerClient(t, iop)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &CertManagerIntegration{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &certmanagerv1.Certificate{}
+	name := ResourceName(iop, certManagerCertificateName)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: caSecretNamespace, Name: name}, got); err != nil {
+		t.Fatalf("Get() Certificate = %v", err)
+	}
+	if got.Spec.SecretName != caSecretName {
+		t.Fatalf("Spec.SecretName = %q, want %q", got.Spec.SecretName, caSecretName)
+	}
+	if got.Spec.IssuerRef.Name != "istio-ca-issuer" {
+		t.Fatalf("Spec.IssuerRef.Name = %q, want istio-ca-issuer", got.Spec.IssuerRef.Name)
+	}
+}
+
+func TestCertManagerIntegrationSkipsWithoutCertManagerProvider(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Security: &v1alpha1.SecuritySpec{}},
+	}
+	c := newFakeCertManagerClient(t, iop)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &CertManagerIntegration{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	var certs certmanagerv1.CertificateList
+	if err := c.List(context.Background(), &certs); err != nil {
+		t.Fatalf("List() = %v", err)
+	}
+	if len(certs.Items) != 0 {
+		t.Fatalf("len(certs.Items) = %d, want 0 without CertProvider set to cert-manager", len(certs.Items))
+	}
+}
+
+func TestCertManagerIntegrationRestartsIstiodOnNewlyIssuedCertificate(t *testing.T) {
+	iop := certManagerIOP()
+	name := ResourceName(iop, certManagerCertificateName)
+	cert := &certmanagerv1.Certificate{ObjectMeta: metav1.ObjectMeta{Namespace: caSecretNamespace, Name: name}}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: caSecretNamespace, Name: caSecretName},
+		Data:       map[string][]byte{"tls.crt": selfSignedCertPEMExpiringAt(t, time.Now().Add(24*time.Hour))},
+	}
+	istiod := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"}}
+	c := newFakeCertManagerClient(t, iop, cert, secret, istiod)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &CertManagerIntegration{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	gotDeployment := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, gotDeployment); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if gotDeployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] == "" {
+		t.Fatal("istiod Deployment wasn't restarted for a newly issued certificate")
+	}
+
+	gotCert := &certmanagerv1.Certificate{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: caSecretNamespace, Name: name}, gotCert); err != nil {
+		t.Fatalf("Get() Certificate = %v", err)
+	}
+	if gotCert.Annotations[lastIssuedSerialAnnotation] == "" {
+		t.Fatal("Certificate wasn't annotated with the issued certificate's serial number")
+	}
+}
+
+func TestCertManagerIntegrationSkipsRestartWhenSerialUnchanged(t *testing.T) {
+	iop := certManagerIOP()
+	name := ResourceName(iop, certManagerCertificateName)
+	certPEM := selfSignedCertPEMExpiringAt(t, time.Now().Add(24*time.Hour))
+	serial, err := parseIssuedCertificate(&corev1.Secret{Data: map[string][]byte{"tls.crt": certPEM}})
+	if err != nil {
+		t.Fatalf("parseIssuedCertificate() = %v", err)
+	}
+	cert := &certmanagerv1.Certificate{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   caSecretNamespace,
+			Name:        name,
+			Annotations: map[string]string{lastIssuedSerialAnnotation: serial.SerialNumber.String()},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: caSecretNamespace, Name: caSecretName},
+		Data:       map[string][]byte{"tls.crt": certPEM},
+	}
+	istiod := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"}}
+	c := newFakeCertManagerClient(t, iop, cert, secret, istiod)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &CertManagerIntegration{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	gotDeployment := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, gotDeployment); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if gotDeployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] != "" {
+		t.Fatal("istiod Deployment was restarted even though the certificate's serial number hadn't changed")
+	}
+}
+
+func TestCertManagerIntegrationSkipsWithoutIssuedSecret(t *testing.T) {
+	iop := certManagerIOP()
+	c := newFakeCertManagerClient(t, iop)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &CertManagerIntegration{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want nil when cert-manager hasn't issued Secret %s/%s yet", err, caSecretNamespace, caSecretName)
+	}
+}
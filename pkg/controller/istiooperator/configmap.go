@@ -0,0 +1,33 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ShouldUpdateConfigMap reports whether desired's Data differs from
+// existing's. istiod watches ConfigMap/istio and restarts every replica on
+// any write to it, even one that leaves Data byte-for-byte identical, so
+// reconcileMeshConfig and patchMeshConfig call this before persisting a
+// mesh config change and skip the write entirely when it would be a no-op.
+// Only Data is compared: ResourceVersion and ManagedFields describe how
+// existing was last written, not what it contains, and comparing them would
+// make every reconcile look like a change even when Data hasn't moved.
+func ShouldUpdateConfigMap(existing, desired *corev1.ConfigMap) bool {
+	return !reflect.DeepEqual(existing.Data, desired.Data)
+}
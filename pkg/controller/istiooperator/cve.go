@@ -0,0 +1,109 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+//go:embed version_catalog.json
+var versionCatalogJSON []byte
+
+// CVESeverity classifies how serious a CVEEntry is, matching the severity
+// ratings Istio's own security advisories use.
+type CVESeverity string
+
+const (
+	CVESeverityCritical CVESeverity = "Critical"
+	CVESeverityHigh     CVESeverity = "High"
+	CVESeverityMedium   CVESeverity = "Medium"
+	CVESeverityLow      CVESeverity = "Low"
+)
+
+// CVEEntry is one known vulnerability affecting an Istio version, sourced
+// from the embedded version catalog.
+type CVEEntry struct {
+	// ID is the CVE identifier, e.g. "CVE-2023-44487".
+	ID string `json:"id"`
+
+	Severity CVESeverity `json:"severity"`
+
+	// Description summarizes the vulnerability, so it shows up somewhere
+	// actionable (e.g. a Warning event) rather than requiring whoever sees
+	// it to go look the CVE ID up themselves.
+	Description string `json:"description"`
+
+	// FixedIn names the earliest patch release that resolves this CVE, if
+	// one is known.
+	FixedIn string `json:"fixedIn"`
+}
+
+// DisableCVEWarnings suppresses ReportVersionCVEs's Warning events entirely,
+// leaving CheckVersionCVEs itself unaffected, for environments that already
+// track CVEs through some other channel and don't need them duplicated into
+// `kubectl get events`. This tree has no cmd/main.go to attach a flag to; a
+// binary that does should do roughly:
+//
+//	flag.BoolVar(&istiooperator.DisableCVEWarnings, "disable-cve-warnings", false, "suppress known-CVE Warning events")
+var DisableCVEWarnings bool
+
+// loadVersionCatalog parses the embedded version catalog, mapping an Istio
+// version to the CVEs known to affect it.
+func loadVersionCatalog() (map[string][]CVEEntry, error) {
+	catalog := map[string][]CVEEntry{}
+	if err := json.Unmarshal(versionCatalogJSON, &catalog); err != nil {
+		return nil, fmt.Errorf("parsing embedded version catalog: %w", err)
+	}
+	return catalog, nil
+}
+
+// CheckVersionCVEs returns the known CVEs affecting version, or nil if
+// version isn't in the embedded catalog or has none on record. A parse
+// failure in the embedded catalog is treated the same as "none on record"
+// rather than returned as an error, since the catalog is built into the
+// binary and a caller has no way to act on a malformed copy of it.
+func CheckVersionCVEs(version string) []CVEEntry {
+	catalog, err := loadVersionCatalog()
+	if err != nil {
+		return nil
+	}
+	return catalog[version]
+}
+
+// ReportVersionCVEs emits a Warning event on iop for every Critical or High
+// severity CVE known to affect version, so users watching `kubectl get
+// events` learn about a vulnerable running version without having to cross-
+// reference it against Istio's security advisories themselves. Medium and
+// Low severity CVEs are deliberately left out of events; CheckVersionCVEs
+// still reports them for callers that want the full list. A no-op when
+// DisableCVEWarnings is set.
+func ReportVersionCVEs(recorder record.EventRecorder, iop *v1alpha1.IstioOperator, version string) {
+	if DisableCVEWarnings {
+		return
+	}
+	for _, cve := range CheckVersionCVEs(version) {
+		if cve.Severity != CVESeverityCritical && cve.Severity != CVESeverityHigh {
+			continue
+		}
+		recorder.Eventf(iop, corev1.EventTypeWarning, "KnownCVE", "Istio %s is affected by %s (%s): %s", version, cve.ID, cve.Severity, cve.Description)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestCheckVersionCVEsReturnsKnownCVEs(t *testing.T) {
+	cves := CheckVersionCVEs("1.16")
+	if len(cves) != 1 || cves[0].ID != "CVE-2022-31045" {
+		t.Fatalf("CheckVersionCVEs(\"1.16\") = %+v, want exactly CVE-2022-31045", cves)
+	}
+	if cves[0].Severity != CVESeverityCritical {
+		t.Fatalf("CheckVersionCVEs(\"1.16\")[0].Severity = %q, want %q", cves[0].Severity, CVESeverityCritical)
+	}
+}
+
+func TestCheckVersionCVEsUnknownVersionReturnsNil(t *testing.T) {
+	if cves := CheckVersionCVEs("0.1"); cves != nil {
+		t.Fatalf("CheckVersionCVEs(\"0.1\") = %+v, want nil for a version not in the catalog", cves)
+	}
+}
+
+func TestReportVersionCVEsEmitsWarningForCriticalAndHighOnly(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	recorder := record.NewFakeRecorder(10)
+
+	ReportVersionCVEs(recorder, iop, "1.19")
+	close(recorder.Events)
+
+	var events []string
+	for e := range recorder.Events {
+		events = append(events, e)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want exactly 1 (the High severity CVE-2023-44487, not the Medium CVE-2023-5528): %v", len(events), events)
+	}
+	if !containsWarning(events[0]) {
+		t.Fatalf("event = %q, want a Warning event", events[0])
+	}
+}
+
+func TestReportVersionCVEsNoOpForVersionWithNoCVEs(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	recorder := record.NewFakeRecorder(1)
+
+	ReportVersionCVEs(recorder, iop, "1.21")
+
+	select {
+	case e := <-recorder.Events:
+		t.Fatalf("unexpected event %q for a version with no known CVEs", e)
+	default:
+	}
+}
+
+func TestReportVersionCVEsRespectsDisableCVEWarnings(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	recorder := record.NewFakeRecorder(1)
+
+	DisableCVEWarnings = true
+	defer func() { DisableCVEWarnings = false }()
+	ReportVersionCVEs(recorder, iop, "1.16")
+
+	select {
+	case e := <-recorder.Events:
+		t.Fatalf("unexpected event %q, want DisableCVEWarnings to suppress it", e)
+	default:
+	}
+}
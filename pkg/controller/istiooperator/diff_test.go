@@ -0,0 +1,136 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestDiffSpecReportsChangedFields(t *testing.T) {
+	old := &v1alpha1.IstioOperatorSpec{Profile: "default", Version: "1.18"}
+	new := &v1alpha1.IstioOperatorSpec{Profile: "minimal", Version: "1.18"}
+
+	diff := DiffSpec(old, new)
+	if diff == "" {
+		t.Fatal("DiffSpec() = \"\", want a non-empty diff for a changed Profile")
+	}
+	if !strings.Contains(diff, "Profile") {
+		t.Fatalf("DiffSpec() = %q, want it to mention the changed field Profile", diff)
+	}
+}
+
+func TestDiffSpecIgnoresComponentResources(t *testing.T) {
+	old := &v1alpha1.IstioOperatorSpec{Profile: "default"}
+	new := &v1alpha1.IstioOperatorSpec{
+		Profile: "default",
+		ComponentResources: map[string]corev1.ResourceRequirements{
+			"istiod": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}},
+		},
+	}
+
+	if diff := DiffSpec(old, new); diff != "" {
+		t.Fatalf("DiffSpec() = %q, want \"\": ComponentResources is set by the defaulting webhook, not the user", diff)
+	}
+}
+
+func TestDiffSpecEqualSpecsReportNoDiff(t *testing.T) {
+	old := &v1alpha1.IstioOperatorSpec{Profile: "default", Components: []string{"istiod"}}
+	new := old.DeepCopy()
+
+	if diff := DiffSpec(old, new); diff != "" {
+		t.Fatalf("DiffSpec() = %q, want \"\" for identical specs", diff)
+	}
+}
+
+func TestReportSpecDiffEmitsWarningWhenSpecChanges(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	old := &v1alpha1.IstioOperatorSpec{Profile: "default"}
+	new := &v1alpha1.IstioOperatorSpec{Profile: "minimal"}
+	recorder := record.NewFakeRecorder(1)
+
+	ReportSpecDiff(recorder, iop, old, new)
+
+	select {
+	case e := <-recorder.Events:
+		if !containsWarning(e) {
+			t.Fatalf("event = %q, want a Warning event", e)
+		}
+	default:
+		t.Fatal("no event recorded, want a Warning event for a changed spec")
+	}
+}
+
+func TestReportSpecDiffIsANoOpWhenSpecIsUnchanged(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	spec := &v1alpha1.IstioOperatorSpec{Profile: "default"}
+	recorder := record.NewFakeRecorder(1)
+
+	ReportSpecDiff(recorder, iop, spec, spec.DeepCopy())
+
+	select {
+	case e := <-recorder.Events:
+		t.Fatalf("unexpected event %q for an unchanged spec", e)
+	default:
+	}
+}
+
+func TestReportSpecDiffTruncatesLongDiffs(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	old := &v1alpha1.IstioOperatorSpec{Components: []string{}}
+	new := &v1alpha1.IstioOperatorSpec{Components: make([]string, 200)}
+	for i := range new.Components {
+		new.Components[i] = "a-fairly-long-component-name-to-pad-out-the-diff"
+	}
+	recorder := record.NewFakeRecorder(1)
+
+	ReportSpecDiff(recorder, iop, old, new)
+
+	select {
+	case e := <-recorder.Events:
+		// A fixed "Warning SpecChanged ..." prefix plus at most
+		// maxDiffEventMessage characters of diff; leave generous headroom
+		// for that prefix rather than pinning its exact length.
+		if len(e) > maxDiffEventMessage+200 {
+			t.Fatalf("event length = %d, want the diff portion capped at %d characters", len(e), maxDiffEventMessage)
+		}
+	default:
+		t.Fatal("no event recorded, want a Warning event for a changed spec")
+	}
+}
+
+func TestReportSpecDiffRespectsDisableDiffEvents(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	old := &v1alpha1.IstioOperatorSpec{Profile: "default"}
+	new := &v1alpha1.IstioOperatorSpec{Profile: "minimal"}
+	recorder := record.NewFakeRecorder(1)
+
+	DisableDiffEvents = true
+	defer func() { DisableDiffEvents = false }()
+	ReportSpecDiff(recorder, iop, old, new)
+
+	select {
+	case e := <-recorder.Events:
+		t.Fatalf("unexpected event %q, want DisableDiffEvents to suppress it", e)
+	default:
+	}
+}
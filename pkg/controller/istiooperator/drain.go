@@ -0,0 +1,173 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// DrainFinalizer, while present on an IstioOperator, holds its deletion open
+// until RunDrainFinalizer has had a chance to drain the mesh's Envoy
+// sidecars, the same way a Kubernetes-native finalizer holds a namespace
+// open until its contents are cleaned up.
+const DrainFinalizer = "operator.istio.io/drain"
+
+// drainConfigAnnotation and drainConfigValue are the pod annotation
+// RunDrainFinalizer sets on every sidecar-carrying pod before waiting for
+// proxies to disconnect, per Istio's proxy config override convention
+// (https://istio.io/latest/docs/reference/config/annotations/).
+const (
+	drainConfigAnnotation = "proxy.istio.io/config"
+	drainConfigValue      = "drainDuration: 30s"
+)
+
+// drainPollInterval and drainTimeout bound how long RunDrainFinalizer waits
+// for istiod to report every proxy disconnected before giving up and letting
+// deletion proceed anyway.
+const (
+	drainPollInterval = 5 * time.Second
+	drainTimeout      = 2 * time.Minute
+)
+
+// drainTimedOutTotal counts RunDrainFinalizer runs that removed
+// DrainFinalizer without every proxy disconnecting first, so a steady climb
+// here — rather than an occasional one during a disruptive deletion — would
+// flag sidecars that aren't draining the way drainConfigValue asks them to.
+var drainTimedOutTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "drain_timed_out_total",
+	Help: "Number of IstioOperator deletions where RunDrainFinalizer removed operator.istio.io/drain before every Envoy sidecar had disconnected from istiod.",
+})
+
+func init() {
+	prometheus.MustRegister(drainTimedOutTotal)
+}
+
+// EnsureDrainFinalizer adds DrainFinalizer to iop if it isn't already
+// present. Reconcile loops are expected to call this on every pass for an
+// IstioOperator that isn't already being deleted, so deletion always has a
+// chance to drain the mesh rather than only when the finalizer happened to
+// be added on CR creation.
+func EnsureDrainFinalizer(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if controllerutil.ContainsFinalizer(iop, DrainFinalizer) {
+		return nil
+	}
+
+	before := iop.DeepCopy()
+	controllerutil.AddFinalizer(iop, DrainFinalizer)
+	if err := c.Patch(ctx, iop, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("adding %s finalizer to IstioOperator %s/%s: %w", DrainFinalizer, iop.Namespace, iop.Name, err)
+	}
+	return nil
+}
+
+// RunDrainFinalizer is meant to run from the IstioOperator reconcile loop
+// once iop.DeletionTimestamp is set and DrainFinalizer is still present. It
+// annotates every pod in the cluster carrying an istio-proxy sidecar with
+// drainConfigAnnotation=drainConfigValue, then polls CheckComponentHealth
+// until istiod reports zero connected proxies or drainTimeout elapses. The
+// finalizer is removed either way: holding deletion open forever because a
+// handful of proxies never disconnected would be worse than letting a few
+// connections drop uncleanly, so a timeout is recorded as a Warning event
+// and in drainTimedOutTotal rather than left to block the CR's deletion
+// indefinitely.
+func RunDrainFinalizer(ctx context.Context, c client.Client, cfg *rest.Config, recorder record.EventRecorder, iop *v1alpha1.IstioOperator) error {
+	if !controllerutil.ContainsFinalizer(iop, DrainFinalizer) {
+		return nil
+	}
+
+	if err := annotateSidecarsForDrain(ctx, c); err != nil {
+		return fmt.Errorf("annotating Envoy sidecars for drain: %w", err)
+	}
+
+	if err := waitForProxiesDrained(ctx, c, cfg, iop); err != nil {
+		drainTimedOutTotal.Inc()
+		recorder.Eventf(iop, corev1.EventTypeWarning, "DrainIncomplete",
+			"Removing %s without every Envoy sidecar disconnecting from istiod: %v", DrainFinalizer, err)
+	}
+
+	before := iop.DeepCopy()
+	controllerutil.RemoveFinalizer(iop, DrainFinalizer)
+	if err := c.Patch(ctx, iop, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("removing %s finalizer from IstioOperator %s/%s: %w", DrainFinalizer, iop.Namespace, iop.Name, err)
+	}
+	return nil
+}
+
+// annotateSidecarsForDrain sets drainConfigAnnotation=drainConfigValue on
+// every pod cluster-wide carrying an istio-proxy container, skipping any
+// that already carry it so a re-run after a partial failure doesn't re-patch
+// pods it already reached.
+func annotateSidecarsForDrain(ctx context.Context, c client.Client) error {
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods); err != nil {
+		return fmt.Errorf("listing pods: %w", err)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !hasContainer(pod.Spec.Containers, istioProxyContainerName) {
+			continue
+		}
+		if pod.Annotations[drainConfigAnnotation] == drainConfigValue {
+			continue
+		}
+
+		before := pod.DeepCopy()
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[drainConfigAnnotation] = drainConfigValue
+		if err := c.Patch(ctx, pod, client.MergeFrom(before)); err != nil {
+			return fmt.Errorf("annotating pod %s/%s for drain: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// waitForProxiesDrained polls CheckComponentHealth on the interval
+// drainPollInterval until it reports zero connected proxies, or
+// drainTimeout elapses.
+func waitForProxiesDrained(ctx context.Context, c client.Client, cfg *rest.Config, iop *v1alpha1.IstioOperator) error {
+	ctx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		health, err := CheckComponentHealth(ctx, cfg, iop)
+		if err != nil {
+			return err
+		}
+		if health.TotalProxies == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s with %d proxies still connected to istiod", drainTimeout, health.TotalProxies)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func sidecarPod(t *testing.T, name string) *corev1.Pod {
+	t.Helper()
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "app"},
+				{Name: istioProxyContainerName},
+			},
+		},
+	}
+}
+
+func TestEnsureDrainFinalizerAddsFinalizerOnce(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	c := newFakeClientWithObjects(t, iop)
+
+	if err := EnsureDrainFinalizer(context.Background(), c, iop); err != nil {
+		t.Fatalf("EnsureDrainFinalizer() = %v", err)
+	}
+	if !controllerutil.ContainsFinalizer(iop, DrainFinalizer) {
+		t.Fatalf("iop.Finalizers = %v, want it to contain %s", iop.Finalizers, DrainFinalizer)
+	}
+
+	if err := EnsureDrainFinalizer(context.Background(), c, iop); err != nil {
+		t.Fatalf("second EnsureDrainFinalizer() = %v, want it to be a no-op", err)
+	}
+}
+
+func TestAnnotateSidecarsForDrainOnlyPatchesSidecarPods(t *testing.T) {
+	withSidecar := sidecarPod(t, "checkout")
+	withoutSidecar := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "shop"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "redis"}}},
+	}
+	c := newFakeClientWithObjects(t, withSidecar, withoutSidecar)
+
+	if err := annotateSidecarsForDrain(context.Background(), c); err != nil {
+		t.Fatalf("annotateSidecarsForDrain() = %v", err)
+	}
+
+	var got corev1.Pod
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(withSidecar), &got); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if got.Annotations[drainConfigAnnotation] != drainConfigValue {
+		t.Fatalf("sidecar pod annotations = %v, want %s=%s", got.Annotations, drainConfigAnnotation, drainConfigValue)
+	}
+
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(withoutSidecar), &got); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if _, ok := got.Annotations[drainConfigAnnotation]; ok {
+		t.Fatalf("non-sidecar pod annotations = %v, want it untouched", got.Annotations)
+	}
+}
+
+func TestRunDrainFinalizerIsANoOpWithoutTheFinalizer(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	c := newFakeClientWithObjects(t, iop)
+	recorder := record.NewFakeRecorder(1)
+
+	if err := RunDrainFinalizer(context.Background(), c, nil, recorder, iop); err != nil {
+		t.Fatalf("RunDrainFinalizer() = %v", err)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		t.Fatalf("unexpected event %q for an IstioOperator without %s", e, DrainFinalizer)
+	default:
+	}
+}
+
+func TestRunDrainFinalizerRemovesFinalizerAndWarnsWhenProxiesNeverDrain(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	controllerutil.AddFinalizer(iop, DrainFinalizer)
+	pod := sidecarPod(t, "checkout")
+	c := newFakeClientWithObjects(t, iop, pod)
+	recorder := record.NewFakeRecorder(1)
+	before := drainTimedOutTotalValue(t)
+
+	// An unreachable API server makes CheckComponentHealth fail on its very
+	// first call, so waitForProxiesDrained gives up well before drainTimeout
+	// rather than this test actually waiting on it.
+	cfg := &rest.Config{Host: "http://127.0.0.1:0"}
+
+	if err := RunDrainFinalizer(context.Background(), c, cfg, recorder, iop); err != nil {
+		t.Fatalf("RunDrainFinalizer() = %v, want it to remove the finalizer anyway", err)
+	}
+
+	if controllerutil.ContainsFinalizer(iop, DrainFinalizer) {
+		t.Fatalf("iop.Finalizers = %v, want %s removed", iop.Finalizers, DrainFinalizer)
+	}
+	select {
+	case e := <-recorder.Events:
+		if !containsWarning(e) {
+			t.Fatalf("event = %q, want a Warning event", e)
+		}
+	default:
+		t.Fatal("no event recorded, want a Warning event for an incomplete drain")
+	}
+	if after := drainTimedOutTotalValue(t); after != before+1 {
+		t.Fatalf("drainTimedOutTotal = %v, want %v", after, before+1)
+	}
+}
+
+func containsWarning(event string) bool {
+	return len(event) > 0 && event[0] == 'W'
+}
+
+func drainTimedOutTotalValue(t *testing.T) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := drainTimedOutTotal.Write(&m); err != nil {
+		t.Fatalf("drainTimedOutTotal.Write() = %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
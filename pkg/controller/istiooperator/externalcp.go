@@ -0,0 +1,190 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// externalIstiodBaseName is the base name ResourceName suffixes with
+// iop.Spec.Revision for the Service (and, when the external address is an
+// IP, the Endpoints) ExternalControlPlaneReconciler creates in place of an
+// in-cluster istiod.
+const externalIstiodBaseName = "istiod"
+
+// meshConfigMapName is the ConfigMap every Istio component reads its mesh
+// config from, regardless of control plane topology.
+const meshConfigMapName = "istio"
+
+// externalControlPlaneMeshConfig is the subset of Istio's mesh config
+// ExternalControlPlaneReconciler needs to set; a full render belongs to
+// istio.io/istio's own mesh config package once this operator vendors it,
+// same caveat as webhook.ValidateSpec's for the rest of mesh config.
+type externalControlPlaneMeshConfig struct {
+	CaAddress string `json:"caAddress,omitempty"`
+}
+
+// ExternalControlPlaneReconciler reconciles the in-cluster resources an
+// external control plane topology needs: rather than running istiod
+// in-cluster, it points cluster workloads at an istiod running outside the
+// cluster, at iop.Spec.ExternalControlPlane.Address.
+type ExternalControlPlaneReconciler struct{}
+
+// Reconcile creates or updates the Service (and, for an IP address, the
+// Endpoints backing it) that lets in-cluster workloads resolve istiod at
+// iop.Spec.ExternalControlPlane.Address, and updates ConfigMap/istio's mesh
+// config so its caAddress points there too. It's a no-op unless
+// iop.Spec.Profile is "external"; RunUpgradePreflightChecks should be run
+// against iop before Reconcile is ever called with it, so an unreachable
+// Address is caught before cluster workloads are pointed at it.
+func (r *ExternalControlPlaneReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.Profile != "external" {
+		return nil
+	}
+	if iop.Spec.ExternalControlPlane == nil || iop.Spec.ExternalControlPlane.Address == "" {
+		return fmt.Errorf("profile \"external\" requires spec.externalControlPlane.address")
+	}
+
+	host, port, err := net.SplitHostPort(iop.Spec.ExternalControlPlane.Address)
+	if err != nil {
+		return fmt.Errorf("parsing externalControlPlane.address %q: %w", iop.Spec.ExternalControlPlane.Address, err)
+	}
+
+	if err := r.reconcileService(ctx, c, iop, host, port); err != nil {
+		return fmt.Errorf("reconciling external control plane Service: %w", err)
+	}
+	if err := r.reconcileMeshConfig(ctx, c, iop); err != nil {
+		return fmt.Errorf("reconciling ConfigMap/%s: %w", meshConfigMapName, err)
+	}
+	return nil
+}
+
+// reconcileService server-side applies the Service in-cluster workloads
+// resolve the external istiod through. An IP address gets a headless
+// ClusterIP Service backed by Endpoints carrying that IP, the closest
+// in-cluster analog to "point this Service at an external IP" Kubernetes
+// has; a DNS name gets an ExternalName Service instead, since Endpoints
+// addresses must be IPs, not hostnames.
+func (r *ExternalControlPlaneReconciler) reconcileService(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, host, port string) error {
+	name := ResourceName(iop, externalIstiodBaseName)
+	portNum, err := parsePort(port)
+	if err != nil {
+		return fmt.Errorf("parsing port %q: %w", port, err)
+	}
+
+	labels := RevisionLabels(iop)
+	service := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: iop.Namespace, Labels: labels},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		service.Spec = corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports:     []corev1.ServicePort{{Name: "tls-istiod", Port: portNum, Protocol: corev1.ProtocolTCP}},
+		}
+		if err := c.Patch(ctx, service, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+			return fmt.Errorf("applying Service %s: %w", name, err)
+		}
+
+		endpoints := &corev1.Endpoints{
+			TypeMeta:   metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Endpoints"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: iop.Namespace, Labels: labels},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: host}},
+				Ports:     []corev1.EndpointPort{{Name: "tls-istiod", Port: portNum, Protocol: corev1.ProtocolTCP}},
+			}},
+		}
+		if err := c.Patch(ctx, endpoints, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+			return fmt.Errorf("applying Endpoints %s: %w", name, err)
+		}
+		return nil
+	}
+
+	service.Spec = corev1.ServiceSpec{
+		Type:         corev1.ServiceTypeExternalName,
+		ExternalName: host,
+		Ports:        []corev1.ServicePort{{Name: "tls-istiod", Port: portNum, Protocol: corev1.ProtocolTCP}},
+	}
+	if err := c.Patch(ctx, service, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying Service %s: %w", name, err)
+	}
+	return nil
+}
+
+// reconcileMeshConfig sets ConfigMap/istio's mesh config caAddress to
+// iop.Spec.ExternalControlPlane.Address, creating the ConfigMap if a prior
+// in-cluster install never did, and leaving every other key already in its
+// Data untouched. It skips the write entirely, per ShouldUpdateConfigMap,
+// once caAddress already matches: istiod restarts on every write to this
+// ConfigMap, and a reconcile loop calls this on every pass regardless of
+// whether anything actually changed.
+func (r *ExternalControlPlaneReconciler) reconcileMeshConfig(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: meshConfigMapName}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: meshConfigMapName, Namespace: iop.Namespace}}
+	case err != nil:
+		return fmt.Errorf("getting ConfigMap %s: %w", meshConfigMapName, err)
+	}
+
+	before := cm.DeepCopy()
+	mesh := externalControlPlaneMeshConfig{}
+	if cm.Data != nil {
+		_ = yaml.Unmarshal([]byte(cm.Data["mesh"]), &mesh)
+	}
+	mesh.CaAddress = iop.Spec.ExternalControlPlane.Address
+
+	rendered, err := yaml.Marshal(mesh)
+	if err != nil {
+		return fmt.Errorf("rendering mesh config: %w", err)
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data["mesh"] = string(rendered)
+
+	if cm.ResourceVersion == "" {
+		return c.Create(ctx, cm)
+	}
+	if !ShouldUpdateConfigMap(before, cm) {
+		return nil
+	}
+	return c.Patch(ctx, cm, client.MergeFrom(before))
+}
+
+func parsePort(s string) (int32, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range", port)
+	}
+	return int32(port), nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestExternalControlPlaneReconcilerSkipsNonExternalProfile(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	c := newFakeClientWithObjects(t, iop)
+
+	r := &ExternalControlPlaneReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	var svc corev1.Service
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, &svc); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get(Service) error = %v, want NotFound: Reconcile should be a no-op for a non-external profile", err)
+	}
+}
+
+func TestExternalControlPlaneReconcilerRequiresAddress(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+		Spec:       v1alpha1.IstioOperatorSpec{Profile: "external"},
+	}
+	c := newFakeClientWithObjects(t, iop)
+
+	r := &ExternalControlPlaneReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err == nil {
+		t.Fatal("Reconcile() = nil, want an error for a missing externalControlPlane.address")
+	}
+}
+
+func TestExternalControlPlaneReconcilerCreatesServiceAndEndpointsForIPAddress(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Profile:              "external",
+			ExternalControlPlane: &v1alpha1.ExternalControlPlaneSpec{Address: "10.0.0.5:15012"},
+		},
+	}
+	c := newFakeClientWithObjects(t, iop)
+
+	r := &ExternalControlPlaneReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	var svc corev1.Service
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, &svc); err != nil {
+		t.Fatalf("Get(Service) = %v", err)
+	}
+	if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		t.Errorf("Service.Spec.ClusterIP = %q, want %q", svc.Spec.ClusterIP, corev1.ClusterIPNone)
+	}
+
+	var ep corev1.Endpoints
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, &ep); err != nil {
+		t.Fatalf("Get(Endpoints) = %v", err)
+	}
+	if len(ep.Subsets) != 1 || len(ep.Subsets[0].Addresses) != 1 || ep.Subsets[0].Addresses[0].IP != "10.0.0.5" {
+		t.Fatalf("Endpoints.Subsets = %+v, want one subset addressing 10.0.0.5", ep.Subsets)
+	}
+	if ep.Subsets[0].Ports[0].Port != 15012 {
+		t.Errorf("Endpoints port = %d, want 15012", ep.Subsets[0].Ports[0].Port)
+	}
+
+	var cm corev1.ConfigMap
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: meshConfigMapName}, &cm); err != nil {
+		t.Fatalf("Get(ConfigMap) = %v", err)
+	}
+	if !strings.Contains(cm.Data["mesh"], "10.0.0.5:15012") {
+		t.Errorf("ConfigMap Data[mesh] = %q, want it to contain caAddress 10.0.0.5:15012", cm.Data["mesh"])
+	}
+}
+
+func TestExternalControlPlaneReconcilerCreatesExternalNameServiceForHostname(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Profile:              "external",
+			ExternalControlPlane: &v1alpha1.ExternalControlPlaneSpec{Address: "istiod.external.example.com:15012"},
+		},
+	}
+	c := newFakeClientWithObjects(t, iop)
+
+	r := &ExternalControlPlaneReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	var svc corev1.Service
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, &svc); err != nil {
+		t.Fatalf("Get(Service) = %v", err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeExternalName || svc.Spec.ExternalName != "istiod.external.example.com" {
+		t.Fatalf("Service.Spec = %+v, want an ExternalName Service for istiod.external.example.com", svc.Spec)
+	}
+
+	var ep corev1.Endpoints
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, &ep); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get(Endpoints) error = %v, want NotFound: a hostname address needs no Endpoints", err)
+	}
+}
+
+func TestExternalControlPlaneReconcilerPreservesOtherMeshConfigKeys(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Profile:              "external",
+			ExternalControlPlane: &v1alpha1.ExternalControlPlaneSpec{Address: "10.0.0.5:15012"},
+		},
+	}
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: meshConfigMapName},
+		Data:       map[string]string{"meshNetworks": "networks: {}\n"},
+	}
+	c := newFakeClientWithObjects(t, iop, existing)
+
+	r := &ExternalControlPlaneReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	var cm corev1.ConfigMap
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: meshConfigMapName}, &cm); err != nil {
+		t.Fatalf("Get(ConfigMap) = %v", err)
+	}
+	if cm.Data["meshNetworks"] != "networks: {}\n" {
+		t.Errorf("Data[meshNetworks] = %q, want it left untouched", cm.Data["meshNetworks"])
+	}
+	if !strings.Contains(cm.Data["mesh"], "10.0.0.5:15012") {
+		t.Errorf("Data[mesh] = %q, want it to contain caAddress 10.0.0.5:15012", cm.Data["mesh"])
+	}
+}
+
+func TestExternalControlPlaneReconcilerNamesResourcesByRevision(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Profile:              "external",
+			Revision:             "canary",
+			ExternalControlPlane: &v1alpha1.ExternalControlPlaneSpec{Address: "10.0.0.5:15012"},
+		},
+	}
+	c := newFakeClientWithObjects(t, iop)
+
+	r := &ExternalControlPlaneReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	var svc corev1.Service
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod-canary"}, &svc); err != nil {
+		t.Fatalf("Get(Service) = %v, want a Service named istiod-canary", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+//go:embed dashboards/*.json
+var istioDashboardsFS embed.FS
+
+// grafanaDashboardNamespace is the default Namespace GrafanaDashboardReconciler
+// creates ConfigMaps in when GrafanaSpec.Namespace is empty.
+const grafanaDashboardNamespace = "istio-system"
+
+// grafanaDashboardLabel is the label Grafana's own sidecar/ConfigMap
+// discovery looks for to pick up a dashboard ConfigMap automatically.
+const grafanaDashboardLabel = "grafana_dashboard"
+
+// grafanaVersionAnnotation records the IstioOperatorSpec.Version a dashboard
+// ConfigMap was last provisioned from, so it's visible which dashboard
+// revision is live without having to diff the ConfigMap's data against the
+// embedded JSON.
+const grafanaVersionAnnotation = "istiooperator.istio.io/istio-version"
+
+// GrafanaDashboardReconciler creates one ConfigMap per official Istio
+// Grafana dashboard, embedded at build time from the dashboards directory,
+// when IstioOperatorSpec.Telemetry.Grafana.AutoProvision is set. Each
+// ConfigMap is labeled grafana_dashboard: "1" so Grafana's ConfigMap
+// sidecar picks it up automatically, and annotated with the Istio version
+// the dashboard JSON was embedded for; applying is done unconditionally via
+// server-side apply, so a version change is picked up the same way every
+// other field on the ConfigMap would be, without needing to diff first.
+type GrafanaDashboardReconciler struct{}
+
+// Reconcile applies a ConfigMap for every dashboard embedded in
+// dashboards/*.json, or does nothing if AutoProvision isn't enabled.
+func (r *GrafanaDashboardReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.Telemetry == nil || iop.Spec.Telemetry.Grafana == nil || !iop.Spec.Telemetry.Grafana.AutoProvision {
+		return nil
+	}
+
+	namespace := iop.Spec.Telemetry.Grafana.Namespace
+	if namespace == "" {
+		namespace = grafanaDashboardNamespace
+	}
+
+	entries, err := istioDashboardsFS.ReadDir("dashboards")
+	if err != nil {
+		return fmt.Errorf("reading embedded dashboards: %w", err)
+	}
+	for _, entry := range entries {
+		if err := r.applyDashboard(ctx, c, iop, namespace, entry.Name()); err != nil {
+			return fmt.Errorf("applying dashboard ConfigMap for %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// applyDashboard applies the ConfigMap for the dashboard embedded at
+// dashboards/fileName.
+func (r *GrafanaDashboardReconciler) applyDashboard(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, namespace, fileName string) error {
+	content, err := istioDashboardsFS.ReadFile(path.Join("dashboards", fileName))
+	if err != nil {
+		return fmt.Errorf("reading embedded dashboard: %w", err)
+	}
+
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      dashboardConfigMapName(fileName),
+			Labels:    map[string]string{grafanaDashboardLabel: "1"},
+			Annotations: map[string]string{
+				grafanaVersionAnnotation: iop.Spec.Version,
+			},
+		},
+		Data: map[string]string{fileName: string(content)},
+	}
+	return c.Patch(ctx, configMap, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner))
+}
+
+// dashboardConfigMapName derives the ConfigMap name for the dashboard
+// embedded at dashboards/fileName, e.g. "istio-mesh-dashboard.json" becomes
+// "istio-mesh-dashboard".
+func dashboardConfigMapName(fileName string) string {
+	return strings.TrimSuffix(fileName, path.Ext(fileName))
+}
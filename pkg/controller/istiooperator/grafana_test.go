@@ -0,0 +1,132 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestGrafanaDashboardReconcilerCreatesOneConfigMapPerDashboard(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: "iop-uid"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Version:   "1.20",
+			Telemetry: &v1alpha1.TelemetrySpec{Grafana: &v1alpha1.GrafanaSpec{AutoProvision: true}},
+		},
+	}
+	c := newFakeClientWithObjects(t)
+
+	r := &GrafanaDashboardReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	entries, err := istioDashboardsFS.ReadDir("dashboards")
+	if err != nil {
+		t.Fatalf("ReadDir() = %v", err)
+	}
+	if len(entries) == 0 {
+		t.Fatalf("expected at least one embedded dashboard")
+	}
+	for _, entry := range entries {
+		name := dashboardConfigMapName(entry.Name())
+		configMap := &corev1.ConfigMap{}
+		if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: name}, configMap); err != nil {
+			t.Fatalf("Get(%s) = %v", name, err)
+		}
+		if configMap.Labels[grafanaDashboardLabel] != "1" {
+			t.Fatalf("ConfigMap %s labels = %v, want grafana_dashboard=1", name, configMap.Labels)
+		}
+		if configMap.Annotations[grafanaVersionAnnotation] != "1.20" {
+			t.Fatalf("ConfigMap %s annotations = %v, want istio-version=1.20", name, configMap.Annotations)
+		}
+		if configMap.Data[entry.Name()] == "" {
+			t.Fatalf("ConfigMap %s data[%s] is empty", name, entry.Name())
+		}
+	}
+}
+
+func TestGrafanaDashboardReconcilerDefaultsToIstioSystemNamespace(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "custom-ns", Name: "example", UID: "iop-uid"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Telemetry: &v1alpha1.TelemetrySpec{Grafana: &v1alpha1.GrafanaSpec{AutoProvision: true}},
+		},
+	}
+	c := newFakeClientWithObjects(t)
+
+	r := &GrafanaDashboardReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istio-mesh-dashboard"}, configMap); err != nil {
+		t.Fatalf("Get() = %v, want ConfigMap created in istio-system by default", err)
+	}
+}
+
+func TestGrafanaDashboardReconcilerUpdatesAnnotationOnVersionChange(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: "iop-uid"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Version:   "1.20",
+			Telemetry: &v1alpha1.TelemetrySpec{Grafana: &v1alpha1.GrafanaSpec{AutoProvision: true}},
+		},
+	}
+	c := newFakeClientWithObjects(t)
+	r := &GrafanaDashboardReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	iop.Spec.Version = "1.21"
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() after version bump = %v", err)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istio-mesh-dashboard"}, configMap); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if configMap.Annotations[grafanaVersionAnnotation] != "1.21" {
+		t.Fatalf("ConfigMap annotations = %v, want istio-version=1.21 after a version bump", configMap.Annotations)
+	}
+}
+
+func TestGrafanaDashboardReconcilerNoopWithoutAutoProvision(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: "iop-uid"},
+	}
+	c := newFakeClientWithObjects(t)
+
+	r := &GrafanaDashboardReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istio-mesh-dashboard"}, configMap)
+	if err == nil {
+		t.Fatalf("Get() succeeded, want no ConfigMap created without AutoProvision")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// istiodAppLabel selects istiod's pods in iop.Namespace, the same label
+// CanaryUpgrade's istiod Deployments carry.
+const istiodAppLabel = "istiod"
+
+// istiodDebugPort is the port istiod exposes its /debug/* endpoints on.
+const istiodDebugPort = 8080
+
+// istiodSynczPath is istiod's debug endpoint reporting, per connected
+// proxy, whether its most recent xDS push was acknowledged.
+const istiodSynczPath = "/debug/syncz"
+
+// synczEntry is one element of istiod's /debug/syncz JSON array.
+type synczEntry struct {
+	ProxyID      string `json:"proxy"`
+	ClusterSent  string `json:"cluster_sent"`
+	ClusterAcked string `json:"cluster_acked"`
+}
+
+// CheckComponentHealth aggregates Envoy proxy sync status across the mesh by
+// querying every running istiod pod's /debug/syncz endpoint through the API
+// server's pod-proxy subresource, authenticated with cfg's credentials the
+// same way `kubectl port-forward`/`kubectl proxy` reach a pod's port without
+// it being directly reachable from outside the cluster. A proxy reported by
+// more than one istiod replica (e.g. during a rolling restart) is only
+// counted once.
+func CheckComponentHealth(ctx context.Context, cfg *rest.Config, iop *v1alpha1.IstioOperator) (v1alpha1.ComponentHealth, error) {
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return v1alpha1.ComponentHealth{}, fmt.Errorf("building client for istiod debug endpoint: %w", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(iop.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=" + istiodAppLabel,
+	})
+	if err != nil {
+		return v1alpha1.ComponentHealth{}, fmt.Errorf("listing istiod pods: %w", err)
+	}
+
+	var perPod [][]synczEntry
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		entries, err := fetchSyncz(ctx, clientset, pod.Namespace, pod.Name)
+		if err != nil {
+			return v1alpha1.ComponentHealth{}, fmt.Errorf("querying %s on pod %s: %w", istiodSynczPath, pod.Name, err)
+		}
+		perPod = append(perPod, entries)
+	}
+	return aggregateSyncz(perPod), nil
+}
+
+// aggregateSyncz merges /debug/syncz entries gathered from one or more
+// istiod replicas into a single ComponentHealth, counting each proxy ID
+// once even if more than one replica reports it (e.g. during a rolling
+// restart of istiod itself).
+func aggregateSyncz(perPod [][]synczEntry) v1alpha1.ComponentHealth {
+	var health v1alpha1.ComponentHealth
+	seen := make(map[string]bool)
+	for _, entries := range perPod {
+		for _, entry := range entries {
+			if seen[entry.ProxyID] {
+				continue
+			}
+			seen[entry.ProxyID] = true
+			health.TotalProxies++
+			if entry.ClusterSent != "" && entry.ClusterSent == entry.ClusterAcked {
+				health.SyncedProxies++
+			} else {
+				health.StalledProxies++
+			}
+		}
+	}
+	return health
+}
+
+// fetchSyncz GETs istiodSynczPath on pod through the API server's pod-proxy
+// subresource and parses the resulting JSON array.
+func fetchSyncz(ctx context.Context, clientset kubernetes.Interface, namespace, pod string) ([]synczEntry, error) {
+	raw, err := clientset.CoreV1().RESTClient().Get().
+		Namespace(namespace).
+		Resource("pods").
+		Name(fmt.Sprintf("%s:%d", pod, istiodDebugPort)).
+		SubResource("proxy").
+		Suffix(istiodSynczPath).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []synczEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("parsing %s response: %w", istiodSynczPath, err)
+	}
+	return entries, nil
+}
+
+// RefreshComponentHealth runs CheckComponentHealth and patches the result
+// onto iop.Status.ComponentStatus. Reconcile loops are expected to call this
+// once per pass so ComponentStatus never reflects a state older than the
+// most recent reconcile.
+func RefreshComponentHealth(ctx context.Context, c client.Client, cfg *rest.Config, iop *v1alpha1.IstioOperator) error {
+	health, err := CheckComponentHealth(ctx, cfg, iop)
+	if err != nil {
+		return err
+	}
+
+	before := iop.DeepCopy()
+	iop.Status.ComponentStatus = &health
+	if err := c.Status().Patch(ctx, iop, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("updating component status of IstioOperator %s/%s: %w", iop.Namespace, iop.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"testing"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestAggregateSyncz(t *testing.T) {
+	tests := []struct {
+		name   string
+		perPod [][]synczEntry
+		want   v1alpha1.ComponentHealth
+	}{
+		{
+			name: "all synced",
+			perPod: [][]synczEntry{
+				{
+					{ProxyID: "a.shop", ClusterSent: "1", ClusterAcked: "1"},
+					{ProxyID: "b.shop", ClusterSent: "2", ClusterAcked: "2"},
+				},
+			},
+			want: v1alpha1.ComponentHealth{TotalProxies: 2, SyncedProxies: 2, StalledProxies: 0},
+		},
+		{
+			name: "one stalled on a never-acked push",
+			perPod: [][]synczEntry{
+				{
+					{ProxyID: "a.shop", ClusterSent: "1", ClusterAcked: "1"},
+					{ProxyID: "b.shop", ClusterSent: "2", ClusterAcked: ""},
+				},
+			},
+			want: v1alpha1.ComponentHealth{TotalProxies: 2, SyncedProxies: 1, StalledProxies: 1},
+		},
+		{
+			name: "same proxy reported by two istiod replicas counts once",
+			perPod: [][]synczEntry{
+				{{ProxyID: "a.shop", ClusterSent: "1", ClusterAcked: "1"}},
+				{{ProxyID: "a.shop", ClusterSent: "1", ClusterAcked: "1"}},
+			},
+			want: v1alpha1.ComponentHealth{TotalProxies: 1, SyncedProxies: 1, StalledProxies: 0},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := aggregateSyncz(tc.perPod); got != tc.want {
+				t.Errorf("aggregateSyncz() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
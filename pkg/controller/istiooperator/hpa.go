@@ -0,0 +1,122 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// hpaReconciledTotal counts HPAReconciler passes that created or updated a
+// HorizontalPodAutoscaler, so a steady climb here tracks ordinary
+// ComponentHPASpecs changes rather than a bug.
+var hpaReconciledTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "hpa_reconciled_total",
+	Help: "Number of HorizontalPodAutoscalers created or updated by HPAReconciler.",
+})
+
+// hpaReconcileErrorTotal counts HPAReconciler passes that failed to apply or
+// delete a HorizontalPodAutoscaler, so a steady climb here — rather than an
+// occasional blip during a cluster upgrade — would flag a problem with the
+// autoscaling/v2 API or this operator's permissions against it.
+var hpaReconcileErrorTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "hpa_reconcile_error_total",
+	Help: "Number of errors HPAReconciler hit creating, updating or deleting a HorizontalPodAutoscaler.",
+})
+
+func init() {
+	prometheus.MustRegister(hpaReconciledTotal, hpaReconcileErrorTotal)
+}
+
+// HPAReconciler creates or updates a HorizontalPodAutoscaler for every
+// component in iop.Spec.Components that has an entry in
+// iop.Spec.ComponentHPASpecs, most commonly the gateway
+// components (istio-ingressgateway, istio-egressgateway) that benefit from
+// scaling on load rather than running a fixed replica count. A component
+// whose entry is removed from ComponentHPASpecs has its HorizontalPodAutoscaler
+// deleted, handing its replica count back to whatever last set it (e.g. a
+// plain Deployment spec) instead of leaving a stale autoscaler fighting it.
+type HPAReconciler struct{}
+
+// Reconcile applies iop.Spec.ComponentHPASpecs: every component in
+// iop.Spec.Components with an entry gets a HorizontalPodAutoscaler created or
+// updated to match, owned by iop so a CR deletion also deletes its
+// HorizontalPodAutoscalers; every component in iop.Spec.Components with no
+// entry has any previously-created HorizontalPodAutoscaler deleted. An entry
+// for a component not listed in iop.Spec.Components is ignored.
+func (r *HPAReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	for _, componentName := range iop.Spec.Components {
+		if err := r.reconcileComponent(ctx, c, iop, componentName); err != nil {
+			hpaReconcileErrorTotal.Inc()
+			return fmt.Errorf("reconciling HorizontalPodAutoscaler for component %s: %w", componentName, err)
+		}
+	}
+	return nil
+}
+
+func (r *HPAReconciler) reconcileComponent(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, componentName string) error {
+	name := ResourceName(iop, componentName)
+	spec, ok := iop.Spec.ComponentHPASpecs[componentName]
+	if !ok {
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: iop.Namespace, Name: name}}
+		if err := c.Delete(ctx, hpa); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting HorizontalPodAutoscaler %s: %w", name, err)
+		}
+		return nil
+	}
+
+	spec = *spec.DeepCopy()
+	spec.ScaleTargetRef = autoscalingv2.CrossVersionObjectReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       name,
+	}
+
+	blockOwnerDeletion := true
+	isController := true
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		TypeMeta: metav1.TypeMeta{APIVersion: autoscalingv2.SchemeGroupVersion.String(), Kind: "HorizontalPodAutoscaler"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: iop.Namespace,
+			Labels:    RevisionLabels(iop),
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion:         v1alpha1.SchemeGroupVersion.String(),
+					Kind:               "IstioOperator",
+					Name:               iop.Name,
+					UID:                iop.UID,
+					Controller:         &isController,
+					BlockOwnerDeletion: &blockOwnerDeletion,
+				},
+			},
+		},
+		Spec: spec,
+	}
+	if err := c.Patch(ctx, hpa, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying HorizontalPodAutoscaler %s: %w", name, err)
+	}
+	hpaReconciledTotal.Inc()
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestHPAReconcilerCreatesHPAForComponentWithSpec(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components: []string{"istio-ingressgateway"},
+			ComponentHPASpecs: map[string]autoscalingv2.HorizontalPodAutoscalerSpec{
+				"istio-ingressgateway": {MinReplicas: int32Ptr(2), MaxReplicas: 5},
+			},
+		},
+	}
+	c := newFakeClientWithObjects(t)
+
+	r := &HPAReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istio-ingressgateway"}, hpa); err != nil {
+		t.Fatalf("Get() HorizontalPodAutoscaler = %v", err)
+	}
+	if hpa.Spec.MaxReplicas != 5 {
+		t.Fatalf("MaxReplicas = %d, want 5", hpa.Spec.MaxReplicas)
+	}
+	if hpa.Spec.ScaleTargetRef.Name != "istio-ingressgateway" || hpa.Spec.ScaleTargetRef.Kind != "Deployment" {
+		t.Fatalf("ScaleTargetRef = %+v, want it to target the component's Deployment", hpa.Spec.ScaleTargetRef)
+	}
+	if len(hpa.OwnerReferences) != 1 || hpa.OwnerReferences[0].Name != "default" {
+		t.Fatalf("OwnerReferences = %+v, want one owner reference to the IstioOperator", hpa.OwnerReferences)
+	}
+}
+
+func TestHPAReconcilerDeletesHPAWhenSpecRemoved(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components: []string{"istio-ingressgateway"},
+			ComponentHPASpecs: map[string]autoscalingv2.HorizontalPodAutoscalerSpec{
+				"istio-ingressgateway": {MinReplicas: int32Ptr(2), MaxReplicas: 5},
+			},
+		},
+	}
+	c := newFakeClientWithObjects(t)
+
+	r := &HPAReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	iop.Spec.ComponentHPASpecs = nil
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istio-ingressgateway"}, hpa)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() HorizontalPodAutoscaler = %v, want a not-found error once ComponentHPASpecs no longer has an entry", err)
+	}
+}
+
+func TestHPAReconcilerDeletingAbsentHPAIsANoop(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}},
+	}
+	c := newFakeClientWithObjects(t)
+
+	r := &HPAReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want no error for a component that never had a HorizontalPodAutoscaler", err)
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// meshExpansionGatewayGVK, meshExpansionVirtualServiceGVK and
+// meshExpansionDestinationRuleGVK identify the Istio networking resources
+// MeshExpansionReconciler manages. None of the three is vendored as a typed
+// Go API in this repo, so they're handled as unstructured.Unstructured the
+// same way WasmPluginReconciler handles WasmPlugin.
+var (
+	meshExpansionGatewayGVK         = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "Gateway"}
+	meshExpansionVirtualServiceGVK  = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "VirtualService"}
+	meshExpansionDestinationRuleGVK = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "DestinationRule"}
+)
+
+// meshExpansionGatewayBaseName, meshExpansionVirtualServiceBaseName and
+// meshExpansionDestinationRuleBaseName name the three networking resources
+// MeshExpansionReconciler manages, and istioCoreDNSDeploymentBaseName and
+// clusterEnvConfigMapBaseName name the Deployment and ConfigMap rounding out
+// the five, each suffixed by revision the same way ResourceName suffixes
+// every other component's resources.
+const (
+	meshExpansionGatewayBaseName         = "istio-meshexpansion-gateway"
+	meshExpansionVirtualServiceBaseName  = "istio-meshexpansion-vs"
+	meshExpansionDestinationRuleBaseName = "istio-meshexpansion-dr"
+	istioCoreDNSDeploymentBaseName       = "istiocoredns"
+	clusterEnvConfigMapBaseName          = "istio-vm-cluster-env"
+)
+
+// meshExpansionGatewayPort is the port VM workloads dial to reach the mesh
+// through meshExpansionGatewayBaseName, the same port Istio's own VM
+// expansion docs have ingress gateways listen on for AUTO_PASSTHROUGH mTLS.
+const meshExpansionGatewayPort = 15443
+
+// meshExpansionAppliedTotal counts MeshExpansionReconciler passes that
+// applied all five mesh expansion resources.
+var meshExpansionAppliedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "mesh_expansion_applied_total",
+	Help: "Number of times MeshExpansionReconciler has applied its Gateway, VirtualService, DestinationRule, Deployment and ConfigMap.",
+})
+
+// meshExpansionErrorTotal counts MeshExpansionReconciler passes that failed
+// to apply or delete one of the five resources it manages.
+var meshExpansionErrorTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "mesh_expansion_error_total",
+	Help: "Number of errors MeshExpansionReconciler hit applying or deleting its Gateway, VirtualService, DestinationRule, Deployment or ConfigMap.",
+})
+
+func init() {
+	prometheus.MustRegister(meshExpansionAppliedTotal, meshExpansionErrorTotal)
+}
+
+// MeshExpansionReconciler provisions everything an on-premise VM needs to
+// join the mesh as a plain workload: a Gateway and VirtualService that admit
+// VM traffic on meshExpansionGatewayPort, a DestinationRule enforcing mTLS
+// between VMs and in-cluster pods, an istiocoredns Deployment VMs resolve
+// cluster DNS names through, and a cluster.env ConfigMap a VM's
+// sidecar-bootstrap tooling downloads to configure itself. It is a no-op,
+// and deletes anything it previously created, unless iop.Spec.MeshExpansion
+// is set with Enabled true.
+type MeshExpansionReconciler struct{}
+
+// Reconcile applies all five mesh expansion resources, owned by iop so a CR
+// deletion also deletes them, when iop.Spec.MeshExpansion.Enabled is true;
+// otherwise it deletes any of the five a previous reconcile created.
+func (r *MeshExpansionReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.MeshExpansion == nil || !iop.Spec.MeshExpansion.Enabled {
+		if err := r.deleteAll(ctx, c, iop); err != nil {
+			meshExpansionErrorTotal.Inc()
+			return err
+		}
+		return nil
+	}
+
+	if err := r.applyGateway(ctx, c, iop); err != nil {
+		meshExpansionErrorTotal.Inc()
+		return fmt.Errorf("applying Gateway: %w", err)
+	}
+	if err := r.applyVirtualService(ctx, c, iop); err != nil {
+		meshExpansionErrorTotal.Inc()
+		return fmt.Errorf("applying VirtualService: %w", err)
+	}
+	if err := r.applyDestinationRule(ctx, c, iop); err != nil {
+		meshExpansionErrorTotal.Inc()
+		return fmt.Errorf("applying DestinationRule: %w", err)
+	}
+	if err := r.applyCoreDNSDeployment(ctx, c, iop); err != nil {
+		meshExpansionErrorTotal.Inc()
+		return fmt.Errorf("applying Deployment %s: %w", ResourceName(iop, istioCoreDNSDeploymentBaseName), err)
+	}
+	if err := r.applyClusterEnvConfigMap(ctx, c, iop); err != nil {
+		meshExpansionErrorTotal.Inc()
+		return fmt.Errorf("applying ConfigMap %s: %w", ResourceName(iop, clusterEnvConfigMapBaseName), err)
+	}
+
+	meshExpansionAppliedTotal.Inc()
+	return nil
+}
+
+// applyGateway admits traffic on meshExpansionGatewayPort for every host,
+// passed through to the receiving sidecar's own mTLS termination rather than
+// terminated at the gateway, the standard shape for a VM expansion gateway.
+func (r *MeshExpansionReconciler) applyGateway(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	name := ResourceName(iop, meshExpansionGatewayBaseName)
+
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(meshExpansionGatewayGVK)
+	gateway.SetNamespace(iop.Namespace)
+	gateway.SetName(name)
+	gateway.SetLabels(RevisionLabels(iop))
+	gateway.SetOwnerReferences(meshExpansionOwnerReferences(iop))
+	gateway.Object["spec"] = map[string]interface{}{
+		"selector": map[string]interface{}{"istio": "ingressgateway"},
+		"servers": []interface{}{
+			map[string]interface{}{
+				"port":  map[string]interface{}{"number": int64(meshExpansionGatewayPort), "name": "tls", "protocol": "TLS"},
+				"tls":   map[string]interface{}{"mode": "AUTO_PASSTHROUGH"},
+				"hosts": []interface{}{"*"},
+			},
+		},
+	}
+
+	return c.Patch(ctx, gateway, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner))
+}
+
+// applyVirtualService routes traffic meshExpansionGatewayBaseName admitted
+// to the destination SNI'd by the connecting VM's sidecar, the routing half
+// of AUTO_PASSTHROUGH expansion.
+func (r *MeshExpansionReconciler) applyVirtualService(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	name := ResourceName(iop, meshExpansionVirtualServiceBaseName)
+
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(meshExpansionVirtualServiceGVK)
+	vs.SetNamespace(iop.Namespace)
+	vs.SetName(name)
+	vs.SetLabels(RevisionLabels(iop))
+	vs.SetOwnerReferences(meshExpansionOwnerReferences(iop))
+	vs.Object["spec"] = map[string]interface{}{
+		"hosts":    []interface{}{"*"},
+		"gateways": []interface{}{ResourceName(iop, meshExpansionGatewayBaseName)},
+		"tls": []interface{}{
+			map[string]interface{}{
+				"match": []interface{}{
+					map[string]interface{}{"sniHosts": []interface{}{"*"}},
+				},
+				"route": []interface{}{
+					map[string]interface{}{"destination": map[string]interface{}{"host": "*"}},
+				},
+			},
+		},
+	}
+
+	return c.Patch(ctx, vs, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner))
+}
+
+// applyDestinationRule requires ISTIO_MUTUAL mTLS for traffic between VMs
+// and in-cluster pods, so a VM reached through meshExpansionGatewayBaseName
+// is held to the same mTLS posture as any in-mesh pod.
+func (r *MeshExpansionReconciler) applyDestinationRule(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	name := ResourceName(iop, meshExpansionDestinationRuleBaseName)
+
+	dr := &unstructured.Unstructured{}
+	dr.SetGroupVersionKind(meshExpansionDestinationRuleGVK)
+	dr.SetNamespace(iop.Namespace)
+	dr.SetName(name)
+	dr.SetLabels(RevisionLabels(iop))
+	dr.SetOwnerReferences(meshExpansionOwnerReferences(iop))
+	dr.Object["spec"] = map[string]interface{}{
+		"host":          "*.local",
+		"trafficPolicy": map[string]interface{}{"tls": map[string]interface{}{"mode": "ISTIO_MUTUAL"}},
+	}
+
+	return c.Patch(ctx, dr, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner))
+}
+
+// applyCoreDNSDeployment creates or updates the istiocoredns Deployment a
+// connecting VM's dnsmasq forwards in-mesh lookups to, tagged with
+// iop.Spec.Version the same way AmbientReconciler tags ztunnel.
+func (r *MeshExpansionReconciler) applyCoreDNSDeployment(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	name := ResourceName(iop, istioCoreDNSDeploymentBaseName)
+	labels := mergeLabels(map[string]string{"app": istioCoreDNSDeploymentBaseName}, RevisionLabels(iop))
+	replicas := int32(1)
+
+	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       iop.Namespace,
+			Labels:          labels,
+			OwnerReferences: meshExpansionOwnerReferences(iop),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  istioCoreDNSDeploymentBaseName,
+							Image: fmt.Sprintf("docker.io/istio/coredns-plugin:%s", iop.Spec.Version),
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := c.Patch(ctx, deployment, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying Deployment %s: %w", name, err)
+	}
+	return nil
+}
+
+// applyClusterEnvConfigMap writes the cluster.env file a VM's
+// sidecar-bootstrap tooling downloads to point its sidecar at this
+// iop.Namespace's control plane and service CIDR.
+func (r *MeshExpansionReconciler) applyClusterEnvConfigMap(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	name := ResourceName(iop, clusterEnvConfigMapBaseName)
+	clusterEnv := fmt.Sprintf(
+		"ISTIO_NAMESPACE=%s\nISTIO_CP_AUTH=MUTUAL_TLS\nISTIO_PILOT_PORT=15012\nISTIO_SERVICE_CIDR=*\n",
+		iop.Namespace,
+	)
+
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       iop.Namespace,
+			Labels:          RevisionLabels(iop),
+			OwnerReferences: meshExpansionOwnerReferences(iop),
+		},
+		Data: map[string]string{"cluster.env": clusterEnv},
+	}
+	if err := c.Patch(ctx, cm, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying ConfigMap %s: %w", name, err)
+	}
+	return nil
+}
+
+// deleteAll deletes all five mesh expansion resources in iop.Namespace, for
+// iop's revision, ignoring a resource that's already gone.
+func (r *MeshExpansionReconciler) deleteAll(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(meshExpansionGatewayGVK)
+	gateway.SetNamespace(iop.Namespace)
+	gateway.SetName(ResourceName(iop, meshExpansionGatewayBaseName))
+	if err := c.Delete(ctx, gateway); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting Gateway %s: %w", gateway.GetName(), err)
+	}
+
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(meshExpansionVirtualServiceGVK)
+	vs.SetNamespace(iop.Namespace)
+	vs.SetName(ResourceName(iop, meshExpansionVirtualServiceBaseName))
+	if err := c.Delete(ctx, vs); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting VirtualService %s: %w", vs.GetName(), err)
+	}
+
+	dr := &unstructured.Unstructured{}
+	dr.SetGroupVersionKind(meshExpansionDestinationRuleGVK)
+	dr.SetNamespace(iop.Namespace)
+	dr.SetName(ResourceName(iop, meshExpansionDestinationRuleBaseName))
+	if err := c.Delete(ctx, dr); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting DestinationRule %s: %w", dr.GetName(), err)
+	}
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: iop.Namespace, Name: ResourceName(iop, istioCoreDNSDeploymentBaseName)}}
+	if err := c.Delete(ctx, deployment); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting Deployment %s: %w", deployment.Name, err)
+	}
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: iop.Namespace, Name: ResourceName(iop, clusterEnvConfigMapBaseName)}}
+	if err := c.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting ConfigMap %s: %w", cm.Name, err)
+	}
+
+	return nil
+}
+
+// meshExpansionOwnerReferences returns the single controlling owner
+// reference every mesh expansion resource carries back to iop, the same
+// shape WasmPluginReconciler.applyPlugin sets on each WasmPlugin.
+func meshExpansionOwnerReferences(iop *v1alpha1.IstioOperator) []metav1.OwnerReference {
+	isController, blockOwnerDeletion := true, true
+	return []metav1.OwnerReference{
+		{
+			APIVersion:         v1alpha1.SchemeGroupVersion.String(),
+			Kind:               "IstioOperator",
+			Name:               iop.Name,
+			UID:                iop.UID,
+			Controller:         &isController,
+			BlockOwnerDeletion: &blockOwnerDeletion,
+		},
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// newFakeMeshExpansionClient builds on newFakeClientWithObjects's scheme,
+// adding the three networking GVKs MeshExpansionReconciler manages the way
+// newFakeWasmPluginClient does for WasmPlugin, since none is registered by
+// default.
+func newFakeMeshExpansionClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(meshExpansionGatewayGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(meshExpansionGatewayGVK.GroupVersion().WithKind("GatewayList"), &unstructured.UnstructuredList{})
+	scheme.AddKnownTypeWithName(meshExpansionVirtualServiceGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(meshExpansionVirtualServiceGVK.GroupVersion().WithKind("VirtualServiceList"), &unstructured.UnstructuredList{})
+	scheme.AddKnownTypeWithName(meshExpansionDestinationRuleGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(meshExpansionDestinationRuleGVK.GroupVersion().WithKind("DestinationRuleList"), &unstructured.UnstructuredList{})
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func meshExpansionIOP() *v1alpha1.IstioOperator {
+	return &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Version:       "1.20.0",
+			MeshExpansion: &v1alpha1.MeshExpansionSpec{Enabled: true},
+		},
+	}
+}
+
+func TestMeshExpansionReconcilerIsNoOpWhenDisabled(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+	}
+	c := newFakeMeshExpansionClient(t)
+
+	r := &MeshExpansionReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want nil with MeshExpansion unset", err)
+	}
+
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(meshExpansionGatewayGVK)
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: ResourceName(iop, meshExpansionGatewayBaseName)}, gateway)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() Gateway = %v, want a not-found error", err)
+	}
+}
+
+func TestMeshExpansionReconcilerAppliesAllFiveResources(t *testing.T) {
+	iop := meshExpansionIOP()
+	c := newFakeMeshExpansionClient(t)
+
+	r := &MeshExpansionReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(meshExpansionGatewayGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: ResourceName(iop, meshExpansionGatewayBaseName)}, gateway); err != nil {
+		t.Fatalf("Get() Gateway = %v", err)
+	}
+	if len(gateway.GetOwnerReferences()) != 1 || gateway.GetOwnerReferences()[0].Name != "default" {
+		t.Fatalf("Gateway OwnerReferences = %+v, want one owner reference to the IstioOperator", gateway.GetOwnerReferences())
+	}
+
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(meshExpansionVirtualServiceGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: ResourceName(iop, meshExpansionVirtualServiceBaseName)}, vs); err != nil {
+		t.Fatalf("Get() VirtualService = %v", err)
+	}
+	gateways, _, _ := unstructured.NestedStringSlice(vs.Object, "spec", "gateways")
+	if len(gateways) != 1 || gateways[0] != ResourceName(iop, meshExpansionGatewayBaseName) {
+		t.Fatalf("spec.gateways = %v, want [%s]", gateways, ResourceName(iop, meshExpansionGatewayBaseName))
+	}
+
+	dr := &unstructured.Unstructured{}
+	dr.SetGroupVersionKind(meshExpansionDestinationRuleGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: ResourceName(iop, meshExpansionDestinationRuleBaseName)}, dr); err != nil {
+		t.Fatalf("Get() DestinationRule = %v", err)
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: ResourceName(iop, istioCoreDNSDeploymentBaseName)}, deployment); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if deployment.Spec.Template.Spec.Containers[0].Image != "docker.io/istio/coredns-plugin:1.20.0" {
+		t.Fatalf("image = %q, want the tagged istiocoredns image", deployment.Spec.Template.Spec.Containers[0].Image)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: ResourceName(iop, clusterEnvConfigMapBaseName)}, cm); err != nil {
+		t.Fatalf("Get() ConfigMap = %v", err)
+	}
+	if cm.Data["cluster.env"] == "" {
+		t.Fatal("Data[\"cluster.env\"] is empty")
+	}
+}
+
+func TestMeshExpansionReconcilerDeletesAllFiveResourcesWhenDisabled(t *testing.T) {
+	iop := meshExpansionIOP()
+	c := newFakeMeshExpansionClient(t)
+
+	r := &MeshExpansionReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	iop.Spec.MeshExpansion.Enabled = false
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(meshExpansionGatewayGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: ResourceName(iop, meshExpansionGatewayBaseName)}, gateway); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() Gateway = %v, want a not-found error once disabled", err)
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: ResourceName(iop, istioCoreDNSDeploymentBaseName)}, deployment); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() Deployment = %v, want a not-found error once disabled", err)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: ResourceName(iop, clusterEnvConfigMapBaseName)}, cm); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() ConfigMap = %v, want a not-found error once disabled", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestIsPaused(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+	}{
+		{name: "no annotations", want: false},
+		{name: "paused true", annotations: map[string]string{PausedAnnotation: "true"}, want: true},
+		{name: "paused false", annotations: map[string]string{PausedAnnotation: "false"}, want: false},
+		{name: "paused typo'd value", annotations: map[string]string{PausedAnnotation: "TRUE"}, want: false},
+		{name: "unrelated annotation", annotations: map[string]string{"other": "true"}, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			if got := isPaused(iop); got != tc.want {
+				t.Errorf("isPaused() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckPausedSkipsAndUpdatesStatusWhenPaused(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "istio-system",
+			Name:        "example",
+			Annotations: map[string]string{PausedAnnotation: "true"},
+		},
+	}
+	c := newFakeClient(t, iop)
+	before := reconciliationPausedTotalValue(t)
+
+	paused, err := CheckPaused(context.Background(), c, iop)
+	if err != nil {
+		t.Fatalf("CheckPaused() = %v", err)
+	}
+	if !paused {
+		t.Fatal("CheckPaused() = false, want true for an annotated IstioOperator")
+	}
+
+	cond := apimeta.FindStatusCondition(iop.Status.Conditions, ConditionReconciling)
+	if cond == nil {
+		t.Fatal("Reconciling condition not set after CheckPaused")
+	}
+	if cond.Status != metav1.ConditionFalse || cond.Reason != "Paused" {
+		t.Fatalf("Reconciling condition = %s/%s, want False/Paused", cond.Status, cond.Reason)
+	}
+
+	if after := reconciliationPausedTotalValue(t); after != before+1 {
+		t.Fatalf("reconciliationPausedTotal = %v, want %v", after, before+1)
+	}
+}
+
+func TestCheckPausedDoesNothingWhenNotPaused(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+	}
+	c := newFakeClient(t, iop)
+
+	paused, err := CheckPaused(context.Background(), c, iop)
+	if err != nil {
+		t.Fatalf("CheckPaused() = %v", err)
+	}
+	if paused {
+		t.Fatal("CheckPaused() = true, want false for an IstioOperator without the pause annotation")
+	}
+	if cond := apimeta.FindStatusCondition(iop.Status.Conditions, ConditionReconciling); cond != nil {
+		t.Fatalf("Reconciling condition set to %v, want untouched when not paused", cond)
+	}
+}
+
+func reconciliationPausedTotalValue(t *testing.T) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := reconciliationPausedTotal.Write(&m); err != nil {
+		t.Fatalf("reconciliationPausedTotal.Write() = %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
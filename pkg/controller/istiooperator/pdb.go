@@ -0,0 +1,117 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// defaultPDBMinAvailable is the MinAvailable every PodDisruptionBudget
+// PDBReconciler creates gets unless overridden by
+// IstioOperatorSpec.ComponentPodDisruptionBudgets.
+const defaultPDBMinAvailable int32 = 1
+
+// PDBReconciler creates a PodDisruptionBudget for each of an IstioOperator's
+// Components, protecting control plane components from voluntary eviction
+// during node drain. A component currently running a single replica is
+// skipped and a warning event is emitted instead: a MinAvailable: 1 PDB
+// against one replica blocks every eviction of that pod, which would make
+// node drains hang rather than just risk a brief control-plane gap.
+type PDBReconciler struct{}
+
+// Reconcile creates or updates a PodDisruptionBudget for every component in
+// iop.Spec.Components that currently has more than one replica, owned by
+// iop so a CR deletion also deletes its PDBs. Components not found as a
+// Deployment yet (e.g. one listed but not yet reconciled) are skipped
+// rather than failing the whole pass, since they have no replica count to
+// base a PDB on yet.
+func (r *PDBReconciler) Reconcile(ctx context.Context, c client.Client, recorder record.EventRecorder, iop *v1alpha1.IstioOperator) error {
+	for _, componentName := range iop.Spec.Components {
+		if err := r.reconcileComponent(ctx, c, recorder, iop, componentName); err != nil {
+			return fmt.Errorf("reconciling PodDisruptionBudget for component %s: %w", componentName, err)
+		}
+	}
+	return nil
+}
+
+func (r *PDBReconciler) reconcileComponent(ctx context.Context, c client.Client, recorder record.EventRecorder, iop *v1alpha1.IstioOperator, componentName string) error {
+	deployment := &appsv1.Deployment{}
+	name := ResourceName(iop, componentName)
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: name}, deployment)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("getting Deployment %s: %w", name, err)
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	if replicas <= 1 {
+		recorder.Eventf(iop, corev1.EventTypeWarning, "PodDisruptionBudgetSkipped",
+			"skipping PodDisruptionBudget for component %s: it has %d replica(s), and a MinAvailable PDB against a single replica would block all evictions", componentName, replicas)
+		return nil
+	}
+
+	minAvailable := defaultPDBMinAvailable
+	if override, ok := iop.Spec.ComponentPodDisruptionBudgets[componentName]; ok && override.MinAvailable != nil {
+		minAvailable = *override.MinAvailable
+	}
+	minAvailableIntStr := intstr.FromInt(int(minAvailable))
+
+	blockOwnerDeletion := true
+	isController := true
+	pdb := &policyv1.PodDisruptionBudget{
+		TypeMeta: metav1.TypeMeta{APIVersion: policyv1.SchemeGroupVersion.String(), Kind: "PodDisruptionBudget"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: iop.Namespace,
+			Labels:    RevisionLabels(iop),
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion:         v1alpha1.SchemeGroupVersion.String(),
+					Kind:               "IstioOperator",
+					Name:               iop.Name,
+					UID:                iop.UID,
+					Controller:         &isController,
+					BlockOwnerDeletion: &blockOwnerDeletion,
+				},
+			},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailableIntStr,
+			Selector:     deployment.Spec.Selector,
+		},
+	}
+	if err := c.Patch(ctx, pdb, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying PodDisruptionBudget %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func deploymentWithReplicas(namespace, name string, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+}
+
+func TestPDBReconcilerCreatesPDBForMultiReplicaComponent(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}},
+	}
+	c := newFakeClientWithObjects(t, deploymentWithReplicas("istio-system", "istiod", 3))
+	recorder := record.NewFakeRecorder(1)
+
+	r := &PDBReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	pdb := &policyv1.PodDisruptionBudget{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, pdb); err != nil {
+		t.Fatalf("Get() PodDisruptionBudget = %v", err)
+	}
+	if pdb.Spec.MinAvailable == nil || pdb.Spec.MinAvailable.IntValue() != 1 {
+		t.Fatalf("MinAvailable = %v, want 1", pdb.Spec.MinAvailable)
+	}
+	if len(pdb.OwnerReferences) != 1 || pdb.OwnerReferences[0].Name != "default" {
+		t.Fatalf("OwnerReferences = %+v, want one owner reference to the IstioOperator", pdb.OwnerReferences)
+	}
+}
+
+func TestPDBReconcilerSkipsSingleReplicaComponentAndEmitsWarning(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}},
+	}
+	c := newFakeClientWithObjects(t, deploymentWithReplicas("istio-system", "istiod", 1))
+	recorder := record.NewFakeRecorder(1)
+
+	r := &PDBReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	pdb := &policyv1.PodDisruptionBudget{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, pdb); err == nil {
+		t.Fatal("Get() PodDisruptionBudget succeeded, want no PDB created for a single-replica component")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "PodDisruptionBudgetSkipped") {
+			t.Fatalf("event = %q, want reason PodDisruptionBudgetSkipped", event)
+		}
+	default:
+		t.Fatal("no event recorded, want a warning event for the skipped component")
+	}
+}
+
+func TestPDBReconcilerAppliesMinAvailableOverride(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components: []string{"istiod"},
+			ComponentPodDisruptionBudgets: map[string]v1alpha1.PodDisruptionBudgetSpec{
+				"istiod": {MinAvailable: int32Ptr(2)},
+			},
+		},
+	}
+	c := newFakeClientWithObjects(t, deploymentWithReplicas("istio-system", "istiod", 3))
+	recorder := record.NewFakeRecorder(1)
+
+	r := &PDBReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	pdb := &policyv1.PodDisruptionBudget{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, pdb); err != nil {
+		t.Fatalf("Get() PodDisruptionBudget = %v", err)
+	}
+	if pdb.Spec.MinAvailable == nil || pdb.Spec.MinAvailable.IntValue() != 2 {
+		t.Fatalf("MinAvailable = %v, want the overridden value 2", pdb.Spec.MinAvailable)
+	}
+}
+
+func int32Ptr(v int32) *int32 { return &v }
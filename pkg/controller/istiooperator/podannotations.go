@@ -0,0 +1,81 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// PodAnnotationsReconciler merges iop.Spec.ComponentPodAnnotations into each
+// component's Deployment's pod template, letting users inject sidecar
+// annotations (e.g. for a Vault agent or Datadog sidecar) uniformly across
+// the control plane without a Helm chart change. Unlike PDBReconciler and
+// HPAReconciler, which own a whole resource, this only ever touches the
+// Deployment's Spec.Template.Annotations map, merging into whatever's
+// already there (e.g. the Helm chart's own defaults) via
+// client.Patch(MergeFrom) rather than a server-side apply of the whole
+// Deployment, the same way labelCanaryNamespaces merges a label into a
+// Namespace it doesn't otherwise own.
+type PodAnnotationsReconciler struct{}
+
+// Reconcile merges the annotations configured in
+// iop.Spec.ComponentPodAnnotations for each component in iop.Spec.Components
+// into that component's Deployment pod template. Components with no entry, or
+// whose Deployment doesn't exist yet, are left untouched; an entry for a
+// component not listed in iop.Spec.Components is ignored.
+func (r *PodAnnotationsReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	for _, componentName := range iop.Spec.Components {
+		if err := r.reconcileComponent(ctx, c, iop, componentName); err != nil {
+			return fmt.Errorf("merging pod annotations for component %s: %w", componentName, err)
+		}
+	}
+	return nil
+}
+
+func (r *PodAnnotationsReconciler) reconcileComponent(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, componentName string) error {
+	annotations := iop.Spec.ComponentPodAnnotations[componentName]
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	name := ResourceName(iop, componentName)
+	deployment := &appsv1.Deployment{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: name}, deployment)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("getting Deployment %s: %w", name, err)
+	}
+
+	before := deployment.DeepCopy()
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = make(map[string]string, len(annotations))
+	}
+	for k, v := range annotations {
+		deployment.Spec.Template.Annotations[k] = v
+	}
+	if err := c.Patch(ctx, deployment, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("patching Deployment %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestPodAnnotationsReconcilerMergesWithoutClobberingExistingAnnotations(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"},
+	}
+	deployment.Spec.Template.Annotations = map[string]string{"sidecar.istio.io/inject": "false"}
+
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components: []string{"istiod"},
+			ComponentPodAnnotations: map[string]map[string]string{
+				"istiod": {"vault.hashicorp.com/agent-inject": "true"},
+			},
+		},
+	}
+	c := newFakeClientWithObjects(t, deployment)
+
+	r := &PodAnnotationsReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, got); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if got.Spec.Template.Annotations["sidecar.istio.io/inject"] != "false" {
+		t.Fatalf("annotations = %v, want the existing operator-set annotation left untouched", got.Spec.Template.Annotations)
+	}
+	if got.Spec.Template.Annotations["vault.hashicorp.com/agent-inject"] != "true" {
+		t.Fatalf("annotations = %v, want the configured podAnnotations entry merged in", got.Spec.Template.Annotations)
+	}
+}
+
+func TestPodAnnotationsReconcilerUserValueWinsOnCollision(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"},
+	}
+	deployment.Spec.Template.Annotations = map[string]string{"ad.datadoghq.com/tags": "default"}
+
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components: []string{"istiod"},
+			ComponentPodAnnotations: map[string]map[string]string{
+				"istiod": {"ad.datadoghq.com/tags": "overridden"},
+			},
+		},
+	}
+	c := newFakeClientWithObjects(t, deployment)
+
+	r := &PodAnnotationsReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, got); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if want := "overridden"; got.Spec.Template.Annotations["ad.datadoghq.com/tags"] != want {
+		t.Fatalf("annotations[%q] = %q, want %q", "ad.datadoghq.com/tags", got.Spec.Template.Annotations["ad.datadoghq.com/tags"], want)
+	}
+}
+
+func TestPodAnnotationsReconcilerSkipsComponentWithNoEntry(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"},
+	}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}},
+	}
+	c := newFakeClientWithObjects(t, deployment)
+
+	r := &PodAnnotationsReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want no error when no component has a ComponentPodAnnotations entry", err)
+	}
+
+	got := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, got); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if len(got.Spec.Template.Annotations) != 0 {
+		t.Fatalf("annotations = %v, want none added for a component with no ComponentPodAnnotations entry", got.Spec.Template.Annotations)
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// externalControlPlaneDialTimeout bounds how long checkExternalControlPlaneReachable
+// waits to open a TCP connection to spec.externalControlPlane.address before
+// reporting it unreachable.
+const externalControlPlaneDialTimeout = 5 * time.Second
+
+//go:embed compatibility_matrix.json
+var compatibilityMatrixJSON []byte
+
+// PreflightSeverity classifies how serious a PreflightResult is.
+type PreflightSeverity string
+
+const (
+	SeverityWarning PreflightSeverity = "Warning"
+	SeverityError   PreflightSeverity = "Error"
+)
+
+// PreflightResult is one check's finding from RunUpgradePreflightChecks.
+type PreflightResult struct {
+	// Check names the check this result came from, e.g. "version-skew".
+	Check string
+
+	Severity PreflightSeverity
+
+	// Message describes what the check found.
+	Message string
+
+	// Remediation suggests how to resolve the finding, so it shows up
+	// somewhere actionable (e.g. a status condition or a CLI's output)
+	// rather than requiring someone to go read this function's source.
+	Remediation string
+}
+
+// istioProxyContainerName is the name Istio's sidecar injector gives the
+// proxy container it adds to every injected pod.
+const istioProxyContainerName = "istio-proxy"
+
+// maxSupportedMinorSkew is how many Istio minor versions behind desired a
+// running sidecar proxy can be before RunUpgradePreflightChecks flags it,
+// matching Istio's own documented N-1 data-plane/control-plane skew policy.
+const maxSupportedMinorSkew = 1
+
+// deprecatedProfiles names profiles removed from upstream Istio, flagged so
+// they're caught here rather than failing later during reconciliation with a
+// less specific error.
+var deprecatedProfiles = map[string]string{
+	"sds": `profile "sds" was folded into "default" in Istio 1.5 and no longer exists`,
+}
+
+// RunUpgradePreflightChecks checks whether upgrading current to desired is
+// safe to apply: (1) desired.Spec.Version must appear in the embedded
+// version compatibility matrix and list current.Spec.Version as a supported
+// upgrade source, (2) every istio-proxy sidecar found in the cluster must be
+// within maxSupportedMinorSkew minor versions of desired.Spec.Version, (3)
+// desired.Spec.Profile must not be one of deprecatedProfiles, and (4) if
+// desired.Spec.Profile is "external", its externalControlPlane.address must
+// be reachable over TCP. Each failed check becomes one PreflightResult
+// rather than a returned error, so
+// a caller can surface every finding at once (e.g. as IstioOperator status
+// conditions) instead of stopping at the first problem; only a bad embedded
+// matrix returns an error. A failure to build a client from cfg or to list
+// pods for check (2) is itself reported as a Warning PreflightResult.
+func RunUpgradePreflightChecks(ctx context.Context, cfg *rest.Config, current, desired *v1alpha1.IstioOperator) ([]PreflightResult, error) {
+	matrix, err := loadCompatibilityMatrix()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []PreflightResult
+
+	supportedFrom, known := matrix[desired.Spec.Version]
+	switch {
+	case !known:
+		results = append(results, PreflightResult{
+			Check:       "version-known",
+			Severity:    SeverityError,
+			Message:     fmt.Sprintf("desired version %q is not in the compatibility matrix", desired.Spec.Version),
+			Remediation: "choose a supported version or update the operator's embedded compatibility matrix",
+		})
+	case current.Spec.Version != "" && !containsString(supportedFrom, current.Spec.Version):
+		results = append(results, PreflightResult{
+			Check:       "version-skew",
+			Severity:    SeverityError,
+			Message:     fmt.Sprintf("upgrading from %q to %q is not a supported version skew", current.Spec.Version, desired.Spec.Version),
+			Remediation: fmt.Sprintf("upgrade through one of the supported versions first: %s", strings.Join(supportedFrom, ", ")),
+		})
+	}
+
+	if cfg != nil {
+		proxyResults, err := checkProxyVersionSkew(ctx, cfg, desired.Spec.Version)
+		if err != nil {
+			results = append(results, PreflightResult{
+				Check:       "proxy-version-skew",
+				Severity:    SeverityWarning,
+				Message:     fmt.Sprintf("could not determine data plane proxy versions: %v", err),
+				Remediation: "verify the operator has permission to list pods across the mesh's namespaces",
+			})
+		} else {
+			results = append(results, proxyResults...)
+		}
+	}
+
+	if desired.Spec.Profile == "external" && desired.Spec.ExternalControlPlane != nil {
+		if result := checkExternalControlPlaneReachable(desired.Spec.ExternalControlPlane.Address); result != nil {
+			results = append(results, *result)
+		}
+	}
+
+	if msg, deprecated := deprecatedProfiles[desired.Spec.Profile]; deprecated {
+		results = append(results, PreflightResult{
+			Check:       "deprecated-fields",
+			Severity:    SeverityError,
+			Message:     msg,
+			Remediation: "set spec.profile to a currently supported profile",
+		})
+	}
+
+	return results, nil
+}
+
+// checkExternalControlPlaneReachable dials address over TCP, returning a
+// PreflightResult only if the connection can't be opened within
+// externalControlPlaneDialTimeout: ExternalControlPlaneReconciler.Reconcile
+// points cluster workloads at address regardless of whether anything's
+// listening there, so this is the only thing standing between a bad address
+// and every sidecar in the mesh losing its control plane.
+func checkExternalControlPlaneReachable(address string) *PreflightResult {
+	conn, err := net.DialTimeout("tcp", address, externalControlPlaneDialTimeout)
+	if err != nil {
+		return &PreflightResult{
+			Check:       "external-control-plane-reachable",
+			Severity:    SeverityError,
+			Message:     fmt.Sprintf("could not reach external control plane at %q: %v", address, err),
+			Remediation: "verify externalControlPlane.address is correct and reachable from the cluster",
+		}
+	}
+	conn.Close()
+	return nil
+}
+
+func loadCompatibilityMatrix() (map[string][]string, error) {
+	matrix := map[string][]string{}
+	if err := json.Unmarshal(compatibilityMatrixJSON, &matrix); err != nil {
+		return nil, fmt.Errorf("parsing embedded compatibility matrix: %w", err)
+	}
+	return matrix, nil
+}
+
+// checkProxyVersionSkew lists every pod across the cluster and flags each
+// distinct istio-proxy sidecar image version found that's more than
+// maxSupportedMinorSkew minor versions behind desiredVersion. Pods are
+// listed cluster-wide since a mesh's sidecars are rarely confined to one
+// namespace.
+func checkProxyVersionSkew(ctx context.Context, cfg *rest.Config, desiredVersion string) ([]PreflightResult, error) {
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := kubeClient.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]struct{}{}
+	var results []PreflightResult
+	for _, pod := range pods.Items {
+		for _, c := range pod.Spec.Containers {
+			if c.Name != istioProxyContainerName {
+				continue
+			}
+			version := proxyImageVersion(c.Image)
+			if version == "" {
+				continue
+			}
+			if _, ok := seen[version]; ok {
+				continue
+			}
+			seen[version] = struct{}{}
+			if !withinMinorSkew(version, desiredVersion, maxSupportedMinorSkew) {
+				results = append(results, PreflightResult{
+					Check:       "proxy-version-skew",
+					Severity:    SeverityWarning,
+					Message:     fmt.Sprintf("found sidecar proxies running %q, more than %d minor version(s) behind desired %q", version, maxSupportedMinorSkew, desiredVersion),
+					Remediation: "upgrade or restart the outdated sidecars before completing the control plane upgrade",
+				})
+			}
+		}
+	}
+	return results, nil
+}
+
+// proxyImageVersion extracts the version tag from an istio-proxy container
+// image reference, e.g. "docker.io/istio/proxyv2:1.17.2" -> "1.17.2". It
+// returns "" for an image with no tag or a "@sha256:..." digest reference,
+// since neither names a comparable version.
+func proxyImageVersion(image string) string {
+	lastSlash := strings.LastIndex(image, "/")
+	tagPart := image[lastSlash+1:]
+	if strings.Contains(tagPart, "@") {
+		return ""
+	}
+	colon := strings.LastIndex(tagPart, ":")
+	if colon < 0 {
+		return ""
+	}
+	return tagPart[colon+1:]
+}
+
+// withinMinorSkew reports whether version's minor release is no more than
+// maxSkew behind desired's, comparing only major.minor since patch releases
+// never affect proxy/control-plane compatibility. Versions that don't parse
+// as major.minor are treated as out of skew, since an unparsable version is
+// exactly the kind of unexpected state this check exists to surface.
+func withinMinorSkew(version, desired string, maxSkew int) bool {
+	vMajor, vMinor, ok := parseMajorMinor(version)
+	if !ok {
+		return false
+	}
+	dMajor, dMinor, ok := parseMajorMinor(desired)
+	if !ok {
+		return false
+	}
+	if vMajor != dMajor {
+		return false
+	}
+	skew := dMinor - vMinor
+	return skew >= 0 && skew <= maxSkew
+}
+
+func parseMajorMinor(version string) (major, minor int, ok bool) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestRunUpgradePreflightChecksVersionSkew(t *testing.T) {
+	tests := []struct {
+		name          string
+		current       string
+		desired       string
+		wantCheckFail string
+	}{
+		{name: "supported upgrade", current: "1.17", desired: "1.18"},
+		{name: "unknown desired version", current: "1.18", desired: "9.9", wantCheckFail: "version-known"},
+		{name: "unsupported skew", current: "1.14", desired: "1.18", wantCheckFail: "version-skew"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			current := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: tc.current}}
+			desired := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: tc.desired}}
+
+			results, err := RunUpgradePreflightChecks(context.Background(), nil, current, desired)
+			if err != nil {
+				t.Fatalf("RunUpgradePreflightChecks() = %v", err)
+			}
+
+			failed := ""
+			for _, r := range results {
+				if r.Severity == SeverityError {
+					failed = r.Check
+				}
+			}
+			if failed != tc.wantCheckFail {
+				t.Errorf("failed check = %q, want %q (results: %+v)", failed, tc.wantCheckFail, results)
+			}
+		})
+	}
+}
+
+func TestRunUpgradePreflightChecksFlagsDeprecatedProfile(t *testing.T) {
+	current := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: "1.17"}}
+	desired := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: "1.18", Profile: "sds"}}
+
+	results, err := RunUpgradePreflightChecks(context.Background(), nil, current, desired)
+	if err != nil {
+		t.Fatalf("RunUpgradePreflightChecks() = %v", err)
+	}
+
+	found := false
+	for _, r := range results {
+		if r.Check == "deprecated-fields" && r.Severity == SeverityError {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("results did not flag deprecated profile %q: %+v", desired.Spec.Profile, results)
+	}
+}
+
+func TestRunUpgradePreflightChecksFlagsUnreachableExternalControlPlane(t *testing.T) {
+	current := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: "1.17"}}
+	desired := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{
+		Version:              "1.18",
+		Profile:              "external",
+		ExternalControlPlane: &v1alpha1.ExternalControlPlaneSpec{Address: "192.0.2.1:15012"}, // TEST-NET-1, unroutable
+	}}
+
+	results, err := RunUpgradePreflightChecks(context.Background(), nil, current, desired)
+	if err != nil {
+		t.Fatalf("RunUpgradePreflightChecks() = %v", err)
+	}
+
+	found := false
+	for _, r := range results {
+		if r.Check == "external-control-plane-reachable" && r.Severity == SeverityError {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("results did not flag unreachable externalControlPlane.address: %+v", results)
+	}
+}
+
+func TestRunUpgradePreflightChecksAllowsReachableExternalControlPlane(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	current := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: "1.17"}}
+	desired := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{
+		Version:              "1.18",
+		Profile:              "external",
+		ExternalControlPlane: &v1alpha1.ExternalControlPlaneSpec{Address: ln.Addr().String()},
+	}}
+
+	results, err := RunUpgradePreflightChecks(context.Background(), nil, current, desired)
+	if err != nil {
+		t.Fatalf("RunUpgradePreflightChecks() = %v", err)
+	}
+
+	for _, r := range results {
+		if r.Check == "external-control-plane-reachable" {
+			t.Errorf("got a result for a reachable external control plane: %+v", r)
+		}
+	}
+}
+
+func TestWithinMinorSkew(t *testing.T) {
+	tests := []struct {
+		version, desired string
+		maxSkew          int
+		want             bool
+	}{
+		{"1.17.2", "1.18.0", 1, true},
+		{"1.16.0", "1.18.0", 1, false},
+		{"1.18.5", "1.18.0", 1, true},
+		{"2.0.0", "1.18.0", 1, false},
+		{"not-a-version", "1.18.0", 1, false},
+	}
+	for _, tc := range tests {
+		if got := withinMinorSkew(tc.version, tc.desired, tc.maxSkew); got != tc.want {
+			t.Errorf("withinMinorSkew(%q, %q, %d) = %v, want %v", tc.version, tc.desired, tc.maxSkew, got, tc.want)
+		}
+	}
+}
+
+func TestProxyImageVersion(t *testing.T) {
+	tests := []struct {
+		image, want string
+	}{
+		{"docker.io/istio/proxyv2:1.17.2", "1.17.2"},
+		{"gcr.io/istio-release/proxyv2:1.18.0-distroless", "1.18.0-distroless"},
+		{"gcr.io/istio-release/proxyv2@sha256:abcdef", ""},
+	}
+	for _, tc := range tests {
+		if got := proxyImageVersion(tc.image); got != tc.want {
+			t.Errorf("proxyImageVersion(%q) = %q, want %q", tc.image, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// newFakePrometheusMonitorClient builds on newFakeClientWithObjects's
+// scheme, adding serviceMonitorGVK/podMonitorGVK the way
+// newFakeServiceMeshMemberClient does for ServiceMeshMember. withMonitoring
+// controls whether the fake client's RESTMapper knows about the
+// monitoring.coreos.com API group.
+func newFakePrometheusMonitorClient(t *testing.T, withMonitoring bool, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(serviceMonitorGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(serviceMonitorGVK.GroupVersion().WithKind("ServiceMonitorList"), &unstructured.UnstructuredList{})
+	scheme.AddKnownTypeWithName(podMonitorGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(podMonitorGVK.GroupVersion().WithKind("PodMonitorList"), &unstructured.UnstructuredList{})
+
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion, appsv1.SchemeGroupVersion})
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), apimeta.RESTScopeNamespace)
+	if withMonitoring {
+		mapper.Add(serviceMonitorGVK, apimeta.RESTScopeNamespace)
+		mapper.Add(podMonitorGVK, apimeta.RESTScopeNamespace)
+	}
+
+	return fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(objs...).Build()
+}
+
+func istiodDeployment(namespace, name string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "istiod"}},
+		},
+	}
+}
+
+func TestPrometheusMonitorReconcilerCreatesMonitorsForEachComponent(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: "iop-uid"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components: []string{"istiod"},
+			Telemetry:  &v1alpha1.TelemetrySpec{PrometheusOperatorIntegration: true},
+		},
+	}
+	c := newFakePrometheusMonitorClient(t, true, istiodDeployment("istio-system", "istiod"))
+
+	r := &PrometheusMonitorReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, sm); err != nil {
+		t.Fatalf("Get() ServiceMonitor = %v", err)
+	}
+	app, _, _ := unstructured.NestedString(sm.Object, "spec", "selector", "matchLabels", "app")
+	if app != "istiod" {
+		t.Fatalf("ServiceMonitor spec.selector.matchLabels.app = %q, want istiod", app)
+	}
+
+	pm := &unstructured.Unstructured{}
+	pm.SetGroupVersionKind(podMonitorGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, pm); err != nil {
+		t.Fatalf("Get() PodMonitor = %v", err)
+	}
+	endpoints, _, err := unstructured.NestedSlice(pm.Object, "spec", "podMetricsEndpoints")
+	if err != nil || len(endpoints) != 1 {
+		t.Fatalf("PodMonitor spec.podMetricsEndpoints = %v (err %v), want one entry", endpoints, err)
+	}
+	entry, ok := endpoints[0].(map[string]interface{})
+	if !ok || entry["port"] != prometheusMonitorPort {
+		t.Fatalf("PodMonitor spec.podMetricsEndpoints[0] = %v, want port %q", endpoints[0], prometheusMonitorPort)
+	}
+}
+
+func TestPrometheusMonitorReconcilerNoopWithoutTelemetryOptIn(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: "iop-uid"},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}},
+	}
+	c := newFakePrometheusMonitorClient(t, true, istiodDeployment("istio-system", "istiod"))
+
+	r := &PrometheusMonitorReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, sm)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() ServiceMonitor = %v, want NotFound without telemetry opt-in", err)
+	}
+}
+
+func TestPrometheusMonitorReconcilerNoopWithoutMonitoringAPIGroup(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: "iop-uid"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components: []string{"istiod"},
+			Telemetry:  &v1alpha1.TelemetrySpec{PrometheusOperatorIntegration: true},
+		},
+	}
+	c := newFakePrometheusMonitorClient(t, false, istiodDeployment("istio-system", "istiod"))
+
+	r := &PrometheusMonitorReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want nil on a cluster without the monitoring.coreos.com API group", err)
+	}
+}
+
+func TestPrometheusMonitorReconcilerSkipsComponentWithoutDeployment(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: "iop-uid"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components: []string{"istiod"},
+			Telemetry:  &v1alpha1.TelemetrySpec{PrometheusOperatorIntegration: true},
+		},
+	}
+	c := newFakePrometheusMonitorClient(t, true)
+
+	r := &PrometheusMonitorReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want nil when the component has no Deployment yet", err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// QuotaViolation is one resource that desired would push over a namespace's
+// ResourceQuota, found by CheckResourceQuotaFit.
+type QuotaViolation struct {
+	// ResourceQuota names the ResourceQuota object that would be exceeded.
+	ResourceQuota string
+
+	// ResourceName is the quota-scoped resource that would be exceeded, e.g.
+	// "requests.cpu" or "limits.memory".
+	ResourceName corev1.ResourceName
+
+	// Used is the resource's current usage, from ResourceQuota.Status.Used.
+	Used resource.Quantity
+
+	// Requested is the additional amount desired's containers would add.
+	Requested resource.Quantity
+
+	// Hard is the resource's limit, from ResourceQuota.Status.Hard.
+	Hard resource.Quantity
+}
+
+// CheckResourceQuotaFit lists every ResourceQuota in ns and checks whether
+// adding desired's requests and limits on top of each quota's current
+// Status.Used would exceed its Status.Hard — the same check the apiserver
+// itself would perform at admission time, run ahead of reconciliation so a
+// quota-exceeded rejection shows up as a QuotaViolation instead of a failed
+// Create/Update deep in component installation. A namespace commonly has
+// more than one ResourceQuota (e.g. one scoped to BestEffort pods and
+// another unscoped), so every quota found is checked independently and
+// every exceeded resource across all of them is returned, rather than
+// stopping at the first violation.
+func CheckResourceQuotaFit(ctx context.Context, c client.Client, ns string, desired []corev1.ResourceRequirements) ([]QuotaViolation, error) {
+	var quotas corev1.ResourceQuotaList
+	if err := c.List(ctx, &quotas, client.InNamespace(ns)); err != nil {
+		return nil, fmt.Errorf("listing resource quotas in namespace %s: %w", ns, err)
+	}
+	if len(quotas.Items) == 0 {
+		return nil, nil
+	}
+
+	requested := sumResourceRequirements(desired)
+
+	var violations []QuotaViolation
+	for _, quota := range quotas.Items {
+		for name, hard := range quota.Status.Hard {
+			amount, ok := requested[name]
+			if !ok {
+				continue
+			}
+			used := quota.Status.Used[name]
+
+			projected := used.DeepCopy()
+			projected.Add(amount)
+			if projected.Cmp(hard) > 0 {
+				violations = append(violations, QuotaViolation{
+					ResourceQuota: quota.Name,
+					ResourceName:  name,
+					Used:          used,
+					Requested:     amount,
+					Hard:          hard,
+				})
+			}
+		}
+	}
+	return violations, nil
+}
+
+// sumResourceRequirements adds up requests and limits across all of desired
+// into a single per-resource-name total, keyed the way ResourceQuota.Status
+// keys resource names it tracks (e.g. "requests.cpu", "limits.memory")
+// rather than the bare "cpu"/"memory" keys ResourceRequirements itself uses.
+func sumResourceRequirements(desired []corev1.ResourceRequirements) map[corev1.ResourceName]resource.Quantity {
+	totals := map[corev1.ResourceName]resource.Quantity{}
+	for _, rr := range desired {
+		addQuantities(totals, "requests.", rr.Requests)
+		addQuantities(totals, "limits.", rr.Limits)
+	}
+	return totals
+}
+
+func addQuantities(totals map[corev1.ResourceName]resource.Quantity, prefix string, list corev1.ResourceList) {
+	for name, qty := range list {
+		key := corev1.ResourceName(prefix + string(name))
+		total := totals[key]
+		total.Add(qty)
+		totals[key] = total
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckResourceQuotaFitFlagsExceededResource(t *testing.T) {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "compute"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{"requests.cpu": resource.MustParse("2")},
+			Used: corev1.ResourceList{"requests.cpu": resource.MustParse("1500m")},
+		},
+	}
+	c := newFakeClientWithObjects(t, quota)
+
+	desired := []corev1.ResourceRequirements{
+		{Requests: corev1.ResourceList{"cpu": resource.MustParse("1")}},
+	}
+	violations, err := CheckResourceQuotaFit(context.Background(), c, "istio-system", desired)
+	if err != nil {
+		t.Fatalf("CheckResourceQuotaFit() = %v", err)
+	}
+	if len(violations) != 1 {
+		t.Fatalf("violations = %+v, want exactly one", violations)
+	}
+	v := violations[0]
+	if v.ResourceQuota != "compute" || v.ResourceName != "requests.cpu" {
+		t.Fatalf("violation = %+v, want the compute quota's requests.cpu flagged", v)
+	}
+	if v.Hard.Cmp(resource.MustParse("2")) != 0 {
+		t.Fatalf("Hard = %v, want 2", v.Hard)
+	}
+}
+
+func TestCheckResourceQuotaFitAllowsRequestsWithinLimit(t *testing.T) {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "compute"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{"requests.cpu": resource.MustParse("2")},
+			Used: corev1.ResourceList{"requests.cpu": resource.MustParse("500m")},
+		},
+	}
+	c := newFakeClientWithObjects(t, quota)
+
+	desired := []corev1.ResourceRequirements{
+		{Requests: corev1.ResourceList{"cpu": resource.MustParse("1")}},
+	}
+	violations, err := CheckResourceQuotaFit(context.Background(), c, "istio-system", desired)
+	if err != nil {
+		t.Fatalf("CheckResourceQuotaFit() = %v", err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("violations = %+v, want none", violations)
+	}
+}
+
+func TestCheckResourceQuotaFitIgnoresResourcesNotTrackedByAnyQuota(t *testing.T) {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "compute"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{"requests.cpu": resource.MustParse("1")},
+			Used: corev1.ResourceList{"requests.cpu": resource.MustParse("1")},
+		},
+	}
+	c := newFakeClientWithObjects(t, quota)
+
+	desired := []corev1.ResourceRequirements{
+		{Requests: corev1.ResourceList{"memory": resource.MustParse("1Gi")}},
+	}
+	violations, err := CheckResourceQuotaFit(context.Background(), c, "istio-system", desired)
+	if err != nil {
+		t.Fatalf("CheckResourceQuotaFit() = %v", err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("violations = %+v, want none since no quota tracks requests.memory", violations)
+	}
+}
+
+func TestCheckResourceQuotaFitNoQuotasInNamespace(t *testing.T) {
+	c := newFakeClientWithObjects(t)
+
+	desired := []corev1.ResourceRequirements{
+		{Requests: corev1.ResourceList{"cpu": resource.MustParse("1")}},
+	}
+	violations, err := CheckResourceQuotaFit(context.Background(), c, "istio-system", desired)
+	if err != nil {
+		t.Fatalf("CheckResourceQuotaFit() = %v", err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("violations = %+v, want none when the namespace has no ResourceQuota objects", violations)
+	}
+}
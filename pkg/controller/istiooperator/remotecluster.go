@@ -0,0 +1,151 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// remoteSecretNamespace is where RemoteClusterReconciler creates each
+// remote cluster's "istio-remote-secret-<name>" Secret, matching where
+// istioctl's own multi-cluster remote secrets live.
+const remoteSecretNamespace = "istio-system"
+
+// multiClusterLabel marks a Secret as an Istio multi-cluster remote secret,
+// the same label istioctl's create-remote-secret puts on the Secrets it
+// generates.
+const multiClusterLabel = "istio/multiCluster"
+
+// RemoteClusterReconciler registers every cluster listed in
+// iop.Spec.MultiCluster.Remotes with this control plane for
+// primary-primary multi-cluster Istio: for each remote it reads a
+// kubeconfig from SecretRef, confirms the remote API server is reachable,
+// creates an "istio-remote-secret-<name>" Secret carrying that kubeconfig,
+// and records the connectivity result in iop.Status.RemoteClusters.
+type RemoteClusterReconciler struct{}
+
+// Reconcile registers every entry in iop.Spec.MultiCluster.Remotes,
+// continuing past a remote whose connectivity check fails so one
+// unreachable cluster doesn't block registering the others, and replaces
+// iop.Status.RemoteClusters with the result of this pass.
+func (r *RemoteClusterReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.MultiCluster == nil {
+		return nil
+	}
+
+	statuses := make([]v1alpha1.RemoteClusterStatus, 0, len(iop.Spec.MultiCluster.Remotes))
+	for _, remote := range iop.Spec.MultiCluster.Remotes {
+		status := r.reconcileRemote(ctx, c, iop, remote)
+		statuses = append(statuses, status)
+	}
+
+	before := iop.DeepCopy()
+	iop.Status.RemoteClusters = statuses
+	if err := c.Status().Patch(ctx, iop, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("updating remote cluster status of IstioOperator %s/%s: %w", iop.Namespace, iop.Name, err)
+	}
+	return nil
+}
+
+// reconcileRemote validates connectivity to remote and, if reachable,
+// creates its "istio-remote-secret-<name>" Secret. A failure at any step
+// becomes the returned status's Message rather than an error, so it's
+// visible on the IstioOperator CR instead of only in operator logs.
+func (r *RemoteClusterReconciler) reconcileRemote(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, remote v1alpha1.RemoteClusterSpec) v1alpha1.RemoteClusterStatus {
+	status := v1alpha1.RemoteClusterStatus{Name: remote.Name, LastCheckedTime: metav1.Now()}
+
+	kubeconfig, err := readKubeconfigSecret(ctx, c, iop.Namespace, remote)
+	if err != nil {
+		status.Message = err.Error()
+		return status
+	}
+
+	if err := checkRemoteClusterReachable(ctx, kubeconfig); err != nil {
+		status.Message = fmt.Sprintf("cluster %q unreachable: %v", remote.Name, err)
+		return status
+	}
+	status.Connected = true
+
+	if err := r.createRemoteSecret(ctx, c, iop, remote, kubeconfig); err != nil {
+		status.Connected = false
+		status.Message = fmt.Sprintf("creating remote secret: %v", err)
+		return status
+	}
+
+	return status
+}
+
+// readKubeconfigSecret fetches remote.SecretRef from namespace and returns
+// its kubeconfig bytes, read from the data key matching remote.Name or,
+// failing that, the Secret's only data key.
+func readKubeconfigSecret(ctx context.Context, c client.Client, namespace string, remote v1alpha1.RemoteClusterSpec) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: remote.SecretRef.Name}, secret); err != nil {
+		return nil, fmt.Errorf("getting kubeconfig secret %s/%s: %w", namespace, remote.SecretRef.Name, err)
+	}
+
+	if data, ok := secret.Data[remote.Name]; ok {
+		return data, nil
+	}
+	if len(secret.Data) == 1 {
+		for _, data := range secret.Data {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("secret %s/%s has no data key %q and more than one data key, so which one holds the kubeconfig is ambiguous", namespace, remote.SecretRef.Name, remote.Name)
+}
+
+// checkRemoteClusterReachable parses kubeconfig and confirms its API server
+// responds to a version request.
+func checkRemoteClusterReachable(ctx context.Context, kubeconfig []byte) error {
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("parsing kubeconfig: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("building client: %w", err)
+	}
+	if _, err := clientset.Discovery().ServerVersion(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// createRemoteSecret creates or updates "istio-remote-secret-<remote.Name>"
+// in remoteSecretNamespace, carrying kubeconfig under a data key named
+// remote.Name the way istioctl's generated remote secrets do.
+func (r *RemoteClusterReconciler) createRemoteSecret(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, remote v1alpha1.RemoteClusterSpec, kubeconfig []byte) error {
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: remoteSecretNamespace,
+			Name:      "istio-remote-secret-" + remote.Name,
+			Labels:    map[string]string{multiClusterLabel: "true"},
+		},
+		Data: map[string][]byte{remote.Name: kubeconfig},
+	}
+	return c.Patch(ctx, secret, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner))
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// unreachableKubeconfig is a minimal kubeconfig pointing at a loopback port
+// nothing listens on, so checkRemoteClusterReachable fails fast with a
+// connection error instead of needing a real remote API server in tests.
+const unreachableKubeconfig = `
+apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://127.0.0.1:1
+    insecure-skip-tls-verify: true
+contexts:
+- name: remote
+  context:
+    cluster: remote
+current-context: remote
+`
+
+func TestReadKubeconfigSecretPrefersNameKey(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "remote-kubeconfig"},
+		Data: map[string][]byte{
+			"cluster-b": []byte("b-config"),
+			"other":     []byte("ignored"),
+		},
+	}
+	c := newFakeClientWithObjects(t, secret)
+
+	got, err := readKubeconfigSecret(context.Background(), c, "istio-system", v1alpha1.RemoteClusterSpec{
+		Name:      "cluster-b",
+		SecretRef: corev1.LocalObjectReference{Name: "remote-kubeconfig"},
+	})
+	if err != nil {
+		t.Fatalf("readKubeconfigSecret() = %v", err)
+	}
+	if string(got) != "b-config" {
+		t.Fatalf("readKubeconfigSecret() = %q, want b-config", got)
+	}
+}
+
+func TestReadKubeconfigSecretFallsBackToSoleKey(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "remote-kubeconfig"},
+		Data:       map[string][]byte{"kubeconfig": []byte("sole-config")},
+	}
+	c := newFakeClientWithObjects(t, secret)
+
+	got, err := readKubeconfigSecret(context.Background(), c, "istio-system", v1alpha1.RemoteClusterSpec{
+		Name:      "cluster-b",
+		SecretRef: corev1.LocalObjectReference{Name: "remote-kubeconfig"},
+	})
+	if err != nil {
+		t.Fatalf("readKubeconfigSecret() = %v", err)
+	}
+	if string(got) != "sole-config" {
+		t.Fatalf("readKubeconfigSecret() = %q, want sole-config", got)
+	}
+}
+
+func TestReadKubeconfigSecretAmbiguousWithoutNameKey(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "remote-kubeconfig"},
+		Data: map[string][]byte{
+			"cluster-a": []byte("a-config"),
+			"cluster-c": []byte("c-config"),
+		},
+	}
+	c := newFakeClientWithObjects(t, secret)
+
+	if _, err := readKubeconfigSecret(context.Background(), c, "istio-system", v1alpha1.RemoteClusterSpec{
+		Name:      "cluster-b",
+		SecretRef: corev1.LocalObjectReference{Name: "remote-kubeconfig"},
+	}); err == nil {
+		t.Fatalf("readKubeconfigSecret() = nil error, want an ambiguous-key error")
+	}
+}
+
+func TestCheckRemoteClusterReachableRejectsMalformedKubeconfig(t *testing.T) {
+	if err := checkRemoteClusterReachable(context.Background(), []byte("not a kubeconfig")); err == nil {
+		t.Fatalf("checkRemoteClusterReachable() = nil error, want a parse error")
+	}
+}
+
+func TestReconcileNoMultiClusterIsNoop(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"}}
+	c := newFakeClientWithObjects(t, iop)
+
+	r := &RemoteClusterReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if iop.Status.RemoteClusters != nil {
+		t.Fatalf("Status.RemoteClusters = %v, want nil", iop.Status.RemoteClusters)
+	}
+}
+
+func TestReconcileRecordsUnreachableRemoteStatus(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "remote-kubeconfig"},
+		Data:       map[string][]byte{"cluster-b": []byte(unreachableKubeconfig)},
+	}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			MultiCluster: &v1alpha1.MultiClusterSpec{
+				Remotes: []v1alpha1.RemoteClusterSpec{
+					{Name: "cluster-b", SecretRef: corev1.LocalObjectReference{Name: "remote-kubeconfig"}},
+				},
+			},
+		},
+	}
+	c := newFakeClientWithObjects(t, iop, secret)
+
+	r := &RemoteClusterReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	if len(iop.Status.RemoteClusters) != 1 {
+		t.Fatalf("Status.RemoteClusters = %+v, want one entry", iop.Status.RemoteClusters)
+	}
+	got := iop.Status.RemoteClusters[0]
+	if got.Name != "cluster-b" || got.Connected {
+		t.Fatalf("Status.RemoteClusters[0] = %+v, want cluster-b marked unreachable", got)
+	}
+	if got.Message == "" {
+		t.Fatalf("Status.RemoteClusters[0].Message is empty, want the connectivity error")
+	}
+
+	remoteSecret := &corev1.Secret{}
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: remoteSecretNamespace, Name: "istio-remote-secret-cluster-b"}, remoteSecret)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() istio-remote-secret-cluster-b = %v, want NotFound since the cluster was unreachable", err)
+	}
+}
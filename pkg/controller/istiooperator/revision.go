@@ -0,0 +1,63 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// ResourceName returns the name a resource named base should be created
+// with for iop: base unchanged if iop.Spec.Revision is empty, so existing
+// single-revision installations see no name change, or
+// "<base>-<revision>" when it's set, so two IstioOperator CRs in the same
+// namespace (e.g. "stable" and "canary" revisions) never create a
+// Deployment, Service or ConfigMap with the same name.
+func ResourceName(iop *v1alpha1.IstioOperator, base string) string {
+	if iop.Spec.Revision == "" {
+		return base
+	}
+	return base + "-" + iop.Spec.Revision
+}
+
+// RevisionLabels returns the labels ResourceName's resources should also
+// carry: istioRevLabel set to iop.Spec.Revision, or nil when Revision is
+// empty. Callers merge this into a resource's own labels before creating
+// it. Listing resources back with RevisionSelector, or passing istioRevLabel
+// in kubemetrics.DiscoveryOptions.InfoMetricLabelKeys, both key off this
+// same label, so a revision never has to be threaded through twice.
+func RevisionLabels(iop *v1alpha1.IstioOperator) map[string]string {
+	if iop.Spec.Revision == "" {
+		return nil
+	}
+	return map[string]string{istioRevLabel: iop.Spec.Revision}
+}
+
+// RevisionSelector returns a client.ListOption restricting a List call to
+// resources carrying iop.Spec.Revision's istioRevLabel, so a reconciler
+// iterating over "every Deployment this operator owns" (e.g. Prune,
+// scaleDownPreviousIstiod) only ever sees — and can only ever modify or
+// delete — its own revision's resources, not another IstioOperator CR's
+// sharing the same namespace. Empty Revision returns an empty
+// client.MatchingLabels, which applies no filtering at all: a
+// single-revision installation has no other revision's resources to step
+// on, so there's nothing to narrow down.
+func RevisionSelector(iop *v1alpha1.IstioOperator) client.MatchingLabels {
+	if iop.Spec.Revision == "" {
+		return client.MatchingLabels{}
+	}
+	return client.MatchingLabels{istioRevLabel: iop.Spec.Revision}
+}
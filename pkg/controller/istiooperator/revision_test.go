@@ -0,0 +1,65 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"testing"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestResourceNameWithoutRevision(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{}
+	if got := ResourceName(iop, "istiod"); got != "istiod" {
+		t.Fatalf("ResourceName() = %q, want %q", got, "istiod")
+	}
+}
+
+func TestResourceNameWithRevision(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Revision: "canary"}}
+	if got, want := ResourceName(iop, "istiod"), "istiod-canary"; got != want {
+		t.Fatalf("ResourceName() = %q, want %q", got, want)
+	}
+}
+
+func TestRevisionLabelsWithoutRevision(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{}
+	if got := RevisionLabels(iop); got != nil {
+		t.Fatalf("RevisionLabels() = %v, want nil", got)
+	}
+}
+
+func TestRevisionLabelsWithRevision(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Revision: "canary"}}
+	got := RevisionLabels(iop)
+	if want := "canary"; got[istioRevLabel] != want {
+		t.Fatalf("RevisionLabels()[%q] = %q, want %q", istioRevLabel, got[istioRevLabel], want)
+	}
+}
+
+func TestRevisionSelectorWithoutRevision(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{}
+	if got := RevisionSelector(iop); len(got) != 0 {
+		t.Fatalf("RevisionSelector() = %v, want empty", got)
+	}
+}
+
+func TestRevisionSelectorWithRevision(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Revision: "stable"}}
+	got := RevisionSelector(iop)
+	if want := "stable"; got[istioRevLabel] != want {
+		t.Fatalf("RevisionSelector()[%q] = %q, want %q", istioRevLabel, got[istioRevLabel], want)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// serviceMeshMemberGVK identifies the OpenShift Service Mesh resource
+// ServiceMeshMemberReconciler manages. It isn't vendored as a typed Go API
+// in this repo, so it's handled as unstructured.Unstructured the same way
+// TelemetryReconciler handles Telemetry.
+var serviceMeshMemberGVK = schema.GroupVersionKind{Group: "maistra.io", Version: "v1", Kind: "ServiceMeshMember"}
+
+// istioInjectionLabel is the namespace label OpenShift Service Mesh's own
+// webhooks read to decide whether to inject sidecars; ServiceMeshMember
+// auto-enrollment piggybacks on the same label so enabling injection on a
+// namespace is also all it takes to enroll it.
+const istioInjectionLabel = "istio-injection"
+
+// serviceMeshMemberOwnerUIDLabel records the UID of the IstioOperator CR a
+// ServiceMeshMember was created for. ServiceMeshMember lives in the member
+// namespace rather than iop.Namespace, so — as with controller.Prune's
+// ownerUIDLabel — a real OwnerReference can't be used to find it back, and
+// this label substitutes.
+const serviceMeshMemberOwnerUIDLabel = "istiooperator.istio.io/owner-uid"
+
+// serviceMeshMemberName is the name every ServiceMeshMember this reconciler
+// creates gets, matching the "default" convention OpenShift Service Mesh's
+// own documentation uses for a namespace's sole membership.
+const serviceMeshMemberName = "default"
+
+// ServiceMeshMemberReconciler enrolls every namespace labeled
+// istio-injection=enabled into iop's control plane by creating a
+// ServiceMeshMember for it, and removes the ServiceMeshMember from any
+// namespace that had one but no longer carries the label. It is a no-op —
+// not an error — on a cluster without the maistra.io API group, so vanilla
+// Kubernetes clusters running this operator are unaffected.
+type ServiceMeshMemberReconciler struct{}
+
+// Reconcile enrolls every namespace labeled istio-injection=enabled and
+// retires ServiceMeshMembers for namespaces that lost the label, skipping
+// both if the maistra.io API group isn't registered on this cluster.
+func (r *ServiceMeshMemberReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	available, err := r.apiGroupAvailable(c)
+	if err != nil {
+		return fmt.Errorf("checking for the maistra.io API group: %w", err)
+	}
+	if !available {
+		return nil
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := c.List(ctx, namespaces, client.MatchingLabels{istioInjectionLabel: "enabled"}); err != nil {
+		return fmt.Errorf("listing namespaces: %w", err)
+	}
+
+	enrolled := make(map[string]bool, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		enrolled[ns.Name] = true
+		if err := r.enroll(ctx, c, iop, ns.Name); err != nil {
+			return fmt.Errorf("enrolling namespace %s: %w", ns.Name, err)
+		}
+	}
+
+	return r.pruneRemoved(ctx, c, iop, enrolled)
+}
+
+// apiGroupAvailable reports whether the cluster c talks to knows about
+// serviceMeshMemberGVK.
+func (r *ServiceMeshMemberReconciler) apiGroupAvailable(c client.Client) (bool, error) {
+	_, err := c.RESTMapper().RESTMapping(serviceMeshMemberGVK.GroupKind(), serviceMeshMemberGVK.Version)
+	if apimeta.IsNoMatchError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// enroll creates or updates the ServiceMeshMember in namespace that points
+// it at iop's control plane.
+func (r *ServiceMeshMemberReconciler) enroll(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, namespace string) error {
+	member := &unstructured.Unstructured{}
+	member.SetGroupVersionKind(serviceMeshMemberGVK)
+	member.SetNamespace(namespace)
+	member.SetName(serviceMeshMemberName)
+	member.SetLabels(map[string]string{serviceMeshMemberOwnerUIDLabel: string(iop.UID)})
+	member.Object["spec"] = map[string]interface{}{
+		"controlPlaneRef": map[string]interface{}{
+			"namespace": iop.Namespace,
+			"name":      iop.Name,
+		},
+	}
+
+	return c.Patch(ctx, member, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner))
+}
+
+// pruneRemoved deletes the ServiceMeshMember this reconciler previously
+// created for iop in any namespace that isn't in enrolled.
+func (r *ServiceMeshMemberReconciler) pruneRemoved(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, enrolled map[string]bool) error {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(serviceMeshMemberGVK.GroupVersion().WithKind("ServiceMeshMemberList"))
+	if err := c.List(ctx, list, client.MatchingLabels{serviceMeshMemberOwnerUIDLabel: string(iop.UID)}); err != nil {
+		return fmt.Errorf("listing ServiceMeshMembers: %w", err)
+	}
+
+	for i := range list.Items {
+		member := &list.Items[i]
+		if enrolled[member.GetNamespace()] {
+			continue
+		}
+		if err := c.Delete(ctx, member); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting ServiceMeshMember in namespace %s: %w", member.GetNamespace(), err)
+		}
+	}
+	return nil
+}
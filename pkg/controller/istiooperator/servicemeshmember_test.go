@@ -0,0 +1,120 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// newFakeServiceMeshMemberClient builds on newFakeClientWithObjects's
+// scheme, adding serviceMeshMemberGVK the way newFakeTelemetryClient does
+// for Telemetry. withMaistra controls whether the fake client's RESTMapper
+// knows about the maistra.io API group, so tests can exercise
+// ServiceMeshMemberReconciler's vanilla-Kubernetes no-op path.
+func newFakeServiceMeshMemberClient(t *testing.T, withMaistra bool, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(serviceMeshMemberGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(serviceMeshMemberGVK.GroupVersion().WithKind("ServiceMeshMemberList"), &unstructured.UnstructuredList{})
+
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), apimeta.RESTScopeRoot)
+	if withMaistra {
+		mapper.Add(serviceMeshMemberGVK, apimeta.RESTScopeNamespace)
+	}
+
+	return fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(objs...).Build()
+}
+
+func enabledNamespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{istioInjectionLabel: "enabled"}},
+	}
+}
+
+func TestServiceMeshMemberReconcilerEnrollsLabeledNamespace(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default", UID: "iop-uid"}}
+	c := newFakeServiceMeshMemberClient(t, true, enabledNamespace("app-team"))
+
+	r := &ServiceMeshMemberReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	member := &unstructured.Unstructured{}
+	member.SetGroupVersionKind(serviceMeshMemberGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "app-team", Name: "default"}, member); err != nil {
+		t.Fatalf("Get() ServiceMeshMember = %v", err)
+	}
+	namespace, _, _ := unstructured.NestedString(member.Object, "spec", "controlPlaneRef", "namespace")
+	if namespace != "istio-system" {
+		t.Fatalf("spec.controlPlaneRef.namespace = %q, want istio-system", namespace)
+	}
+}
+
+func TestServiceMeshMemberReconcilerRemovesMemberWhenLabelRemoved(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default", UID: "iop-uid"}}
+	ns := enabledNamespace("app-team")
+	c := newFakeServiceMeshMemberClient(t, true, ns)
+
+	r := &ServiceMeshMemberReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	ns.Labels = nil
+	if err := c.Update(context.Background(), ns); err != nil {
+		t.Fatalf("Update() namespace = %v", err)
+	}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	member := &unstructured.Unstructured{}
+	member.SetGroupVersionKind(serviceMeshMemberGVK)
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "app-team", Name: "default"}, member)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() ServiceMeshMember = %v, want a not-found error once the label is removed", err)
+	}
+}
+
+func TestServiceMeshMemberReconcilerNoopsWithoutMaistraAPIGroup(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default", UID: "iop-uid"}}
+	c := newFakeServiceMeshMemberClient(t, false, enabledNamespace("app-team"))
+
+	r := &ServiceMeshMemberReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want nil on a cluster without the maistra.io API group", err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// serviceMigrationPollInterval and serviceMigrationTimeout bound how long
+// MigrateServiceType waits for a cloud provider to assign a LoadBalancer
+// Service its external address, the same poll-until-timeout shape
+// waitForProxiesDrained uses for RunDrainFinalizer.
+const (
+	serviceMigrationPollInterval = 5 * time.Second
+	serviceMigrationTimeout      = 5 * time.Minute
+)
+
+// MigrateServiceType changes the Service type of the gateway component
+// named gateway (e.g. "istio-ingressgateway") to toType, most commonly
+// moving a user off the NodePort they started with onto a cloud
+// LoadBalancer, in four steps, each reported with its own event on iop:
+//
+//  1. If the Service's current spec already names an external IP — either
+//     LoadBalancerIP from an earlier migration, or an ExternalIPs entry —
+//     that IP is carried forward as the new LoadBalancerIP, so the cloud
+//     provider has a chance to hand back the same address instead of
+//     connections draining against one that's about to disappear. Skipped,
+//     without an error, when the Service has no such IP to carry forward.
+//  2. The Service's type is patched to toType.
+//  3. If toType is LoadBalancer, MigrateServiceType polls until the
+//     Service's status reports an external IP or hostname, or
+//     serviceMigrationTimeout elapses.
+//  4. iop.Status.GatewayAddresses[gateway] is updated with the address
+//     found in step 3, persisted via a Status().Patch.
+//
+// A failure at any step returns an error immediately rather than
+// attempting the remaining ones.
+func MigrateServiceType(ctx context.Context, c client.Client, recorder record.EventRecorder, iop *v1alpha1.IstioOperator, gateway string, toType corev1.ServiceType) error {
+	name := ResourceName(iop, gateway)
+	svc := &corev1.Service{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: name}, svc); err != nil {
+		return fmt.Errorf("getting Service %s/%s: %w", iop.Namespace, name, err)
+	}
+
+	if ip := carryOverIP(svc); ip != "" {
+		before := svc.DeepCopy()
+		svc.Spec.LoadBalancerIP = ip
+		if err := c.Patch(ctx, svc, client.MergeFrom(before)); err != nil {
+			return fmt.Errorf("setting loadBalancerIP on Service %s/%s ahead of migration: %w", iop.Namespace, name, err)
+		}
+		recorder.Eventf(iop, corev1.EventTypeNormal, "ServiceMigrationDraining", "Service %s/%s: requesting %s as its LoadBalancer IP ahead of migrating from %s to %s", iop.Namespace, name, ip, svc.Spec.Type, toType)
+	}
+
+	before := svc.DeepCopy()
+	svc.Spec.Type = toType
+	if err := c.Patch(ctx, svc, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("patching Service %s/%s type to %s: %w", iop.Namespace, name, toType, err)
+	}
+	recorder.Eventf(iop, corev1.EventTypeNormal, "ServiceMigrationTypeChanged", "Service %s/%s: type changed to %s", iop.Namespace, name, toType)
+
+	if toType != corev1.ServiceTypeLoadBalancer {
+		return nil
+	}
+
+	address, err := waitForLoadBalancerAddress(ctx, c, iop.Namespace, name)
+	if err != nil {
+		return fmt.Errorf("waiting for Service %s/%s to be assigned a LoadBalancer address: %w", iop.Namespace, name, err)
+	}
+	recorder.Eventf(iop, corev1.EventTypeNormal, "ServiceMigrationAddressAssigned", "Service %s/%s: assigned LoadBalancer address %s", iop.Namespace, name, address)
+
+	if err := setGatewayAddress(ctx, c, iop, gateway, address); err != nil {
+		return fmt.Errorf("recording LoadBalancer address for gateway %s on IstioOperator %s/%s: %w", gateway, iop.Namespace, iop.Name, err)
+	}
+	recorder.Eventf(iop, corev1.EventTypeNormal, "ServiceMigrationStatusUpdated", "IstioOperator %s/%s: status.gatewayAddresses[%s] set to %s", iop.Namespace, iop.Name, gateway, address)
+	return nil
+}
+
+// carryOverIP returns the external IP, if any, svc's current spec already
+// names — LoadBalancerIP, or failing that the first ExternalIPs entry — for
+// MigrateServiceType to request on the Service's new type. Returns "" when
+// neither is set, leaving the cloud provider to assign whatever address it
+// would have anyway.
+func carryOverIP(svc *corev1.Service) string {
+	if svc.Spec.LoadBalancerIP != "" {
+		return svc.Spec.LoadBalancerIP
+	}
+	if len(svc.Spec.ExternalIPs) > 0 {
+		return svc.Spec.ExternalIPs[0]
+	}
+	return ""
+}
+
+// waitForLoadBalancerAddress polls the named Service until its status
+// reports an external IP or hostname, or serviceMigrationTimeout elapses.
+func waitForLoadBalancerAddress(ctx context.Context, c client.Client, namespace, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, serviceMigrationTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(serviceMigrationPollInterval)
+	defer ticker.Stop()
+	for {
+		svc := &corev1.Service{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, svc); err != nil {
+			return "", err
+		}
+		if address := loadBalancerAddress(svc); address != "" {
+			return address, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("timed out after %s with no LoadBalancer address assigned", serviceMigrationTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// loadBalancerAddress returns the first IP, or failing that hostname,
+// among svc.Status.LoadBalancer.Ingress, or "" if it's still empty.
+func loadBalancerAddress(svc *corev1.Service) string {
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+		if ingress.Hostname != "" {
+			return ingress.Hostname
+		}
+	}
+	return ""
+}
+
+// setGatewayAddress records address in iop.Status.GatewayAddresses[gateway]
+// and persists it with a Status().Patch, mutating iop in place the same way
+// EnsureDrainFinalizer mutates it in place with a MergeFrom patch.
+func setGatewayAddress(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, gateway, address string) error {
+	before := iop.DeepCopy()
+	if iop.Status.GatewayAddresses == nil {
+		iop.Status.GatewayAddresses = map[string]string{}
+	}
+	iop.Status.GatewayAddresses[gateway] = address
+	return c.Status().Patch(ctx, iop, client.MergeFrom(before))
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func nodePortGatewayService(namespace, name, externalIP string) *corev1.Service {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort},
+	}
+	if externalIP != "" {
+		svc.Spec.ExternalIPs = []string{externalIP}
+	}
+	return svc
+}
+
+func TestMigrateServiceTypeCarriesOverExternalIPAndAssignsAddress(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	svc := nodePortGatewayService("istio-system", "istio-ingressgateway", "203.0.113.10")
+	// The fake client has no cloud provider to assign a LoadBalancer address
+	// once MigrateServiceType patches Spec.Type, so the Status it would
+	// eventually report is seeded up front — waitForLoadBalancerAddress's
+	// very first poll already sees it, the same way
+	// TestRunDrainFinalizerRemovesFinalizerAndWarnsWhenProxiesNeverDrain
+	// arranges for its first poll to already see the end state rather than
+	// this test actually waiting on one.
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}}
+	c := newFakeClientWithObjects(t, iop, svc)
+	recorder := record.NewFakeRecorder(10)
+
+	if err := MigrateServiceType(context.Background(), c, recorder, iop, "istio-ingressgateway", corev1.ServiceTypeLoadBalancer); err != nil {
+		t.Fatalf("MigrateServiceType() = %v", err)
+	}
+
+	var migrated corev1.Service
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istio-ingressgateway"}, &migrated); err != nil {
+		t.Fatalf("Get() migrated Service = %v", err)
+	}
+	if migrated.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		t.Fatalf("Spec.Type = %v, want LoadBalancer", migrated.Spec.Type)
+	}
+	if migrated.Spec.LoadBalancerIP != "203.0.113.10" {
+		t.Fatalf("Spec.LoadBalancerIP = %q, want the carried-over ExternalIPs[0]", migrated.Spec.LoadBalancerIP)
+	}
+
+	var updated v1alpha1.IstioOperator
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "default"}, &updated); err != nil {
+		t.Fatalf("Get() IstioOperator = %v", err)
+	}
+	if got := updated.Status.GatewayAddresses["istio-ingressgateway"]; got != "203.0.113.10" {
+		t.Fatalf("Status.GatewayAddresses[istio-ingressgateway] = %q, want 203.0.113.10", got)
+	}
+
+	gotDraining, gotTypeChanged, gotAddressAssigned, gotStatusUpdated := false, false, false, false
+	for i := 0; i < 4; i++ {
+		select {
+		case e := <-recorder.Events:
+			switch {
+			case strings.Contains(e, "ServiceMigrationDraining"):
+				gotDraining = true
+			case strings.Contains(e, "ServiceMigrationTypeChanged"):
+				gotTypeChanged = true
+			case strings.Contains(e, "ServiceMigrationAddressAssigned"):
+				gotAddressAssigned = true
+			case strings.Contains(e, "ServiceMigrationStatusUpdated"):
+				gotStatusUpdated = true
+			}
+		default:
+		}
+	}
+	if !gotDraining || !gotTypeChanged || !gotAddressAssigned || !gotStatusUpdated {
+		t.Fatalf("events: draining=%v typeChanged=%v addressAssigned=%v statusUpdated=%v, want all four", gotDraining, gotTypeChanged, gotAddressAssigned, gotStatusUpdated)
+	}
+}
+
+func TestMigrateServiceTypeSkipsCarryOverWithoutAnExistingIP(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	svc := nodePortGatewayService("istio-system", "istio-ingressgateway", "")
+	c := newFakeClientWithObjects(t, iop, svc)
+	recorder := record.NewFakeRecorder(10)
+
+	if err := MigrateServiceType(context.Background(), c, recorder, iop, "istio-ingressgateway", corev1.ServiceTypeClusterIP); err != nil {
+		t.Fatalf("MigrateServiceType() = %v", err)
+	}
+
+	var migrated corev1.Service
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istio-ingressgateway"}, &migrated); err != nil {
+		t.Fatalf("Get() migrated Service = %v", err)
+	}
+	if migrated.Spec.Type != corev1.ServiceTypeClusterIP {
+		t.Fatalf("Spec.Type = %v, want ClusterIP", migrated.Spec.Type)
+	}
+	if migrated.Spec.LoadBalancerIP != "" {
+		t.Fatalf("Spec.LoadBalancerIP = %q, want unset without an existing IP to carry over", migrated.Spec.LoadBalancerIP)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		if strings.Contains(e, "ServiceMigrationDraining") {
+			t.Fatalf("unexpected draining event %q without an existing IP to carry over", e)
+		}
+	default:
+	}
+}
+
+func TestMigrateServiceTypeSkipsWaitAndStatusUpdateForNonLoadBalancerTarget(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	svc := nodePortGatewayService("istio-system", "istio-ingressgateway", "")
+	c := newFakeClientWithObjects(t, iop, svc)
+	recorder := record.NewFakeRecorder(10)
+
+	if err := MigrateServiceType(context.Background(), c, recorder, iop, "istio-ingressgateway", corev1.ServiceTypeClusterIP); err != nil {
+		t.Fatalf("MigrateServiceType() = %v", err)
+	}
+
+	var updated v1alpha1.IstioOperator
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "default"}, &updated); err != nil {
+		t.Fatalf("Get() IstioOperator = %v", err)
+	}
+	if _, ok := updated.Status.GatewayAddresses["istio-ingressgateway"]; ok {
+		t.Fatalf("Status.GatewayAddresses = %v, want no entry for a migration that never targeted LoadBalancer", updated.Status.GatewayAddresses)
+	}
+}
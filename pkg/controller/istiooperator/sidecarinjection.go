@@ -0,0 +1,107 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// SidecarInjectionReconciler labels namespaces named in
+// IstioOperatorSpec.SidecarInjection with istioInjectionLabel, the same
+// label ServiceMeshMemberReconciler reads, so a fleet operator can toggle
+// sidecar injection on or off for a namespace from the IstioOperator spec
+// instead of editing the namespace directly. It never touches a namespace
+// that isn't named in EnabledNamespaces or DisabledNamespaces, leaving any
+// istio-injection label a user set by hand alone.
+type SidecarInjectionReconciler struct{}
+
+// Reconcile labels every namespace in
+// iop.Spec.SidecarInjection.EnabledNamespaces istio-injection: enabled and
+// every namespace in DisabledNamespaces istio-injection: disabled. A
+// namespace listed in both emits a Warning event and is left untouched by
+// this reconcile, rather than guessing which value should win. A nil
+// iop.Spec.SidecarInjection is a no-op.
+func (r *SidecarInjectionReconciler) Reconcile(ctx context.Context, c client.Client, recorder record.EventRecorder, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.SidecarInjection == nil {
+		return nil
+	}
+
+	conflicting := conflictingNamespaces(iop.Spec.SidecarInjection.EnabledNamespaces, iop.Spec.SidecarInjection.DisabledNamespaces)
+	for name := range conflicting {
+		recorder.Eventf(iop, corev1.EventTypeWarning, "SidecarInjectionConflict",
+			"namespace %s is listed in both sidecarInjection.enabledNamespaces and sidecarInjection.disabledNamespaces; leaving its istio-injection label untouched", name)
+	}
+
+	if err := r.label(ctx, c, iop.Spec.SidecarInjection.EnabledNamespaces, "enabled", conflicting); err != nil {
+		return err
+	}
+	return r.label(ctx, c, iop.Spec.SidecarInjection.DisabledNamespaces, "disabled", conflicting)
+}
+
+// label sets namespaces' istioInjectionLabel to value, skipping any name
+// present in conflicting.
+func (r *SidecarInjectionReconciler) label(ctx context.Context, c client.Client, namespaces []string, value string, conflicting map[string]bool) error {
+	for _, name := range namespaces {
+		if conflicting[name] {
+			continue
+		}
+
+		ns := &corev1.Namespace{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, ns); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("getting namespace %s: %w", name, err)
+		}
+		if ns.Labels[istioInjectionLabel] == value {
+			continue
+		}
+
+		before := ns.DeepCopy()
+		if ns.Labels == nil {
+			ns.Labels = map[string]string{}
+		}
+		ns.Labels[istioInjectionLabel] = value
+		if err := c.Patch(ctx, ns, client.MergeFrom(before)); err != nil {
+			return fmt.Errorf("labeling namespace %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// conflictingNamespaces returns, as a set, every name present in both
+// enabled and disabled.
+func conflictingNamespaces(enabled, disabled []string) map[string]bool {
+	enabledSet := make(map[string]bool, len(enabled))
+	for _, name := range enabled {
+		enabledSet[name] = true
+	}
+
+	conflicting := map[string]bool{}
+	for _, name := range disabled {
+		if enabledSet[name] {
+			conflicting[name] = true
+		}
+	}
+	return conflicting
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestSidecarInjectionReconcilerLabelsEnabledAndDisabledNamespaces(t *testing.T) {
+	shop := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	legacy := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "legacy"}}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			SidecarInjection: &v1alpha1.SidecarInjectionSpec{
+				EnabledNamespaces:  []string{"shop"},
+				DisabledNamespaces: []string{"legacy"},
+			},
+		},
+	}
+	c := newFakeClientWithObjects(t, shop, legacy)
+	recorder := record.NewFakeRecorder(2)
+
+	r := &SidecarInjectionReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	gotShop := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: "shop"}, gotShop); err != nil {
+		t.Fatalf("Get() shop = %v", err)
+	}
+	if gotShop.Labels[istioInjectionLabel] != "enabled" {
+		t.Fatalf("shop labels = %v, want istio-injection=enabled", gotShop.Labels)
+	}
+
+	gotLegacy := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: "legacy"}, gotLegacy); err != nil {
+		t.Fatalf("Get() legacy = %v", err)
+	}
+	if gotLegacy.Labels[istioInjectionLabel] != "disabled" {
+		t.Fatalf("legacy labels = %v, want istio-injection=disabled", gotLegacy.Labels)
+	}
+}
+
+func TestSidecarInjectionReconcilerDoesNotTouchUnlistedNamespaces(t *testing.T) {
+	other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			SidecarInjection: &v1alpha1.SidecarInjectionSpec{EnabledNamespaces: []string{"shop"}},
+		},
+	}
+	c := newFakeClientWithObjects(t, other)
+	recorder := record.NewFakeRecorder(1)
+
+	r := &SidecarInjectionReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: "other"}, got); err != nil {
+		t.Fatalf("Get() other = %v", err)
+	}
+	if _, ok := got.Labels[istioInjectionLabel]; ok {
+		t.Fatalf("other labels = %v, want no istio-injection label for a namespace not listed in either field", got.Labels)
+	}
+}
+
+func TestSidecarInjectionReconcilerSkipsAndWarnsOnConflict(t *testing.T) {
+	both := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "both"}}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			SidecarInjection: &v1alpha1.SidecarInjectionSpec{
+				EnabledNamespaces:  []string{"both"},
+				DisabledNamespaces: []string{"both"},
+			},
+		},
+	}
+	c := newFakeClientWithObjects(t, both)
+	recorder := record.NewFakeRecorder(1)
+
+	r := &SidecarInjectionReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: "both"}, got); err != nil {
+		t.Fatalf("Get() both = %v", err)
+	}
+	if _, ok := got.Labels[istioInjectionLabel]; ok {
+		t.Fatalf("both labels = %v, want no istio-injection label applied for a namespace listed in both fields", got.Labels)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		if !containsWarning(e) {
+			t.Fatalf("event = %q, want a Warning event", e)
+		}
+	default:
+		t.Fatal("no event recorded, want a Warning event for the conflicting namespace")
+	}
+}
+
+func TestSidecarInjectionReconcilerIsANoOpWithoutSidecarInjectionSpec(t *testing.T) {
+	shop := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	c := newFakeClientWithObjects(t, shop)
+	recorder := record.NewFakeRecorder(1)
+
+	r := &SidecarInjectionReconciler{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: "shop"}, got); err != nil {
+		t.Fatalf("Get() shop = %v", err)
+	}
+	if _, ok := got.Labels[istioInjectionLabel]; ok {
+		t.Fatalf("shop labels = %v, want none when SidecarInjection is nil", got.Labels)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// sidecarGVK and serviceEntryGVK identify the Istio networking resources
+// ValidateSidecarScopes reads. Neither is vendored as a typed Go API in this
+// repo, so both are handled as unstructured.Unstructured the same way
+// WasmPluginReconciler handles WasmPlugin.
+var (
+	sidecarGVK      = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "Sidecar"}
+	serviceEntryGVK = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "ServiceEntry"}
+)
+
+// sidecarScopeAnyHost is the "namespace/dnsName" egress host ValidateSidecarScopes
+// treats a Sidecar with no spec.egress entries as carrying: Istio's own
+// default egress listener for a Sidecar with none configured exports every
+// namespace's services, so omitting egress is the broadest scope a Sidecar
+// can have, not the narrowest.
+const sidecarScopeAnyHost = "*/*"
+
+// SidecarScopeWarning flags one networking.istio.io Sidecar resource whose
+// estimated in-scope endpoint count, from ValidateSidecarScopes, exceeds the
+// threshold it was run with. A broad Sidecar — or the absence of one, which
+// implicitly scopes every Service and ServiceEntry in the mesh — is the most
+// common cause of an outsized xDS push in a large mesh.
+type SidecarScopeWarning struct {
+	Namespace          string
+	Name               string
+	EstimatedEndpoints int
+	Threshold          int
+}
+
+// String renders w as a one-line, human-readable message.
+func (w SidecarScopeWarning) String() string {
+	return fmt.Sprintf("Sidecar %s/%s scopes an estimated %d endpoint(s), over the %d-endpoint threshold",
+		w.Namespace, w.Name, w.EstimatedEndpoints, w.Threshold)
+}
+
+// ValidateSidecarScopes lists every networking.istio.io Sidecar in the
+// cluster and, for each, estimates how many endpoints its egress hosts bring
+// into scope by counting matching ServiceEntry endpoints and Service
+// Endpoints, returning a SidecarScopeWarning for every Sidecar whose
+// estimate exceeds threshold. The estimate is necessarily approximate —
+// without fully implementing Istio's own egress host matching and service
+// registry, a ServiceEntry or Service just outside the real scope can still
+// get counted — so it's deliberately biased toward over-counting rather
+// than under-counting: a false-positive warning here costs a look at a
+// dashboard, while a false negative lets a mesh grow past its performance
+// limits unnoticed. A failure listing ServiceEntries, Services or Endpoints
+// is logged and treated as zero additional warnings from that kind, rather
+// than failing the whole check; a failure listing Sidecars themselves
+// returns no warnings at all, since there's nothing left to check.
+func ValidateSidecarScopes(ctx context.Context, c client.Client, threshold int) []SidecarScopeWarning {
+	sidecars := &unstructured.UnstructuredList{}
+	sidecars.SetGroupVersionKind(sidecarGVK.GroupVersion().WithKind("SidecarList"))
+	if err := c.List(ctx, sidecars); err != nil {
+		logger.Error(err, "Listing Sidecars for ValidateSidecarScopes")
+		return nil
+	}
+	if len(sidecars.Items) == 0 {
+		return nil
+	}
+
+	serviceEntries := &unstructured.UnstructuredList{}
+	serviceEntries.SetGroupVersionKind(serviceEntryGVK.GroupVersion().WithKind("ServiceEntryList"))
+	if err := c.List(ctx, serviceEntries); err != nil {
+		logger.Error(err, "Listing ServiceEntries for ValidateSidecarScopes")
+	}
+
+	services := &corev1.ServiceList{}
+	if err := c.List(ctx, services); err != nil {
+		logger.Error(err, "Listing Services for ValidateSidecarScopes")
+	}
+
+	endpoints := &corev1.EndpointsList{}
+	if err := c.List(ctx, endpoints); err != nil {
+		logger.Error(err, "Listing Endpoints for ValidateSidecarScopes")
+	}
+	endpointCountByService := make(map[string]int, len(endpoints.Items))
+	for _, ep := range endpoints.Items {
+		count := 0
+		for _, subset := range ep.Subsets {
+			count += len(subset.Addresses) + len(subset.NotReadyAddresses)
+		}
+		endpointCountByService[ep.Namespace+"/"+ep.Name] = count
+	}
+
+	var warnings []SidecarScopeWarning
+	for i := range sidecars.Items {
+		sidecar := &sidecars.Items[i]
+		estimate := estimateSidecarScopeEndpoints(sidecarEgressHosts(sidecar), serviceEntries, services, endpointCountByService)
+		if estimate <= threshold {
+			continue
+		}
+		warnings = append(warnings, SidecarScopeWarning{
+			Namespace:          sidecar.GetNamespace(),
+			Name:               sidecar.GetName(),
+			EstimatedEndpoints: estimate,
+			Threshold:          threshold,
+		})
+	}
+	return warnings
+}
+
+// sidecarScopeMeshConfig is the subset of Istio's mesh config MaybeValidateSidecarScopes
+// needs to read; a full render belongs to istio.io/istio's own mesh config
+// package once this operator vendors it, same caveat as trustDomainMeshConfig's
+// and vaultPKIMeshConfig's for the rest of mesh config.
+type sidecarScopeMeshConfig struct {
+	EnableEnvoyAccessLogService bool `json:"enableEnvoyAccessLogService,omitempty"`
+}
+
+// MaybeValidateSidecarScopes runs ValidateSidecarScopes only when
+// ConfigMap/istio's "mesh" key has enableEnvoyAccessLogService set: that
+// setting has every sidecar in scope stream its access logs back over gRPC,
+// so a mesh large enough for that to matter is exactly the mesh
+// ValidateSidecarScopes exists to flag before its xDS pushes get out of
+// hand. It's a no-op, returning no warnings, when the setting is unset or
+// false.
+func MaybeValidateSidecarScopes(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, threshold int) ([]SidecarScopeWarning, error) {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: meshConfigMapName}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("getting ConfigMap %s: %w", meshConfigMapName, err)
+	}
+
+	mesh := sidecarScopeMeshConfig{}
+	if cm.Data != nil {
+		_ = yaml.Unmarshal([]byte(cm.Data["mesh"]), &mesh)
+	}
+	if !mesh.EnableEnvoyAccessLogService {
+		return nil, nil
+	}
+	return ValidateSidecarScopes(ctx, c, threshold), nil
+}
+
+// sidecarEgressHosts returns sidecar's egress hosts across every
+// spec.egress listener, in Istio's "namespace/dnsName" form. A Sidecar with
+// no egress listeners at all returns sidecarScopeAnyHost, its broadest
+// possible scope.
+func sidecarEgressHosts(sidecar *unstructured.Unstructured) []string {
+	egress, found, _ := unstructured.NestedSlice(sidecar.Object, "spec", "egress")
+	if !found {
+		return []string{sidecarScopeAnyHost}
+	}
+
+	var hosts []string
+	for _, listener := range egress {
+		listenerMap, ok := listener.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		listenerHosts, _, _ := unstructured.NestedStringSlice(listenerMap, "hosts")
+		hosts = append(hosts, listenerHosts...)
+	}
+	if len(hosts) == 0 {
+		return []string{sidecarScopeAnyHost}
+	}
+	return hosts
+}
+
+// estimateSidecarScopeEndpoints sums, over every ServiceEntry whose
+// spec.hosts matches egressHosts, its spec.endpoints count (or 1, for a
+// ServiceEntry that resolves endpoints itself rather than listing them),
+// plus, over every Service whose cluster-local DNS name matches egressHosts,
+// its ready and not-ready Endpoints address count.
+func estimateSidecarScopeEndpoints(egressHosts []string, serviceEntries *unstructured.UnstructuredList, services *corev1.ServiceList, endpointCountByService map[string]int) int {
+	total := 0
+
+	for i := range serviceEntries.Items {
+		se := &serviceEntries.Items[i]
+		seHosts, _, _ := unstructured.NestedStringSlice(se.Object, "spec", "hosts")
+		if !anyHostInScope(egressHosts, se.GetNamespace(), seHosts) {
+			continue
+		}
+		seEndpoints, _, _ := unstructured.NestedSlice(se.Object, "spec", "endpoints")
+		if len(seEndpoints) > 0 {
+			total += len(seEndpoints)
+		} else {
+			total++
+		}
+	}
+
+	for i := range services.Items {
+		svc := &services.Items[i]
+		dnsName := fmt.Sprintf("%s.%s.svc.cluster.local", svc.Name, svc.Namespace)
+		if !sidecarScopeIncludesHost(egressHosts, svc.Namespace, dnsName) {
+			continue
+		}
+		total += endpointCountByService[svc.Namespace+"/"+svc.Name]
+	}
+
+	return total
+}
+
+// anyHostInScope reports whether any of dnsNames, belonging to namespace, is
+// in scope for egressHosts.
+func anyHostInScope(egressHosts []string, namespace string, dnsNames []string) bool {
+	for _, dnsName := range dnsNames {
+		if sidecarScopeIncludesHost(egressHosts, namespace, dnsName) {
+			return true
+		}
+	}
+	return false
+}
+
+// sidecarScopeIncludesHost reports whether dnsName, belonging to namespace,
+// matches any entry in egressHosts, each in Istio's "namespace/dnsName"
+// form. "*" matches any namespace; a dnsName entry of "*" matches any name,
+// and a "*.some.suffix" entry matches by suffix the way Istio's own egress
+// host matching does.
+func sidecarScopeIncludesHost(egressHosts []string, namespace, dnsName string) bool {
+	for _, egressHost := range egressHosts {
+		ns, dns, ok := strings.Cut(egressHost, "/")
+		if !ok {
+			continue
+		}
+		if ns != "*" && ns != namespace {
+			continue
+		}
+		switch {
+		case dns == "*":
+			return true
+		case strings.HasPrefix(dns, "*."):
+			if strings.HasSuffix(dnsName, dns[1:]) {
+				return true
+			}
+		case dns == dnsName:
+			return true
+		}
+	}
+	return false
+}
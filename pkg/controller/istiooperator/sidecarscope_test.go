@@ -0,0 +1,169 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// newFakeSidecarScopeClient builds on newFakeClientWithObjects's scheme,
+// adding sidecarGVK and serviceEntryGVK the way newFakeWasmPluginClient does
+// for WasmPlugin, since neither is registered by default.
+func newFakeSidecarScopeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(sidecarGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(sidecarGVK.GroupVersion().WithKind("SidecarList"), &unstructured.UnstructuredList{})
+	scheme.AddKnownTypeWithName(serviceEntryGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(serviceEntryGVK.GroupVersion().WithKind("ServiceEntryList"), &unstructured.UnstructuredList{})
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func unstructuredSidecar(namespace, name string, egressHosts ...string) *unstructured.Unstructured {
+	sidecar := &unstructured.Unstructured{}
+	sidecar.SetGroupVersionKind(sidecarGVK)
+	sidecar.SetNamespace(namespace)
+	sidecar.SetName(name)
+	if len(egressHosts) > 0 {
+		hosts := make([]interface{}, len(egressHosts))
+		for i, h := range egressHosts {
+			hosts[i] = h
+		}
+		sidecar.Object["spec"] = map[string]interface{}{
+			"egress": []interface{}{
+				map[string]interface{}{"hosts": hosts},
+			},
+		}
+	}
+	return sidecar
+}
+
+func unstructuredServiceEntry(namespace, name string, hosts []string, endpointCount int) *unstructured.Unstructured {
+	se := &unstructured.Unstructured{}
+	se.SetGroupVersionKind(serviceEntryGVK)
+	se.SetNamespace(namespace)
+	se.SetName(name)
+	hostList := make([]interface{}, len(hosts))
+	for i, h := range hosts {
+		hostList[i] = h
+	}
+	spec := map[string]interface{}{"hosts": hostList}
+	if endpointCount > 0 {
+		endpoints := make([]interface{}, endpointCount)
+		for i := range endpoints {
+			endpoints[i] = map[string]interface{}{"address": "10.0.0.1"}
+		}
+		spec["endpoints"] = endpoints
+	}
+	se.Object["spec"] = spec
+	return se
+}
+
+func TestValidateSidecarScopesFlagsServiceEntryOverThreshold(t *testing.T) {
+	sidecar := unstructuredSidecar("apps", "default", "*/*.example.com")
+	se := unstructuredServiceEntry("apps", "external", []string{"api.example.com"}, 5)
+	c := newFakeSidecarScopeClient(t, sidecar, se)
+
+	warnings := ValidateSidecarScopes(context.Background(), c, 3)
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, want exactly one", warnings)
+	}
+	if warnings[0].EstimatedEndpoints != 5 {
+		t.Fatalf("EstimatedEndpoints = %d, want 5", warnings[0].EstimatedEndpoints)
+	}
+}
+
+func TestValidateSidecarScopesIgnoresServiceEntryOutOfScope(t *testing.T) {
+	sidecar := unstructuredSidecar("apps", "default", "apps/api.internal")
+	se := unstructuredServiceEntry("apps", "external", []string{"api.example.com"}, 10)
+	c := newFakeSidecarScopeClient(t, sidecar, se)
+
+	warnings := ValidateSidecarScopes(context.Background(), c, 1)
+	if len(warnings) != 0 {
+		t.Fatalf("warnings = %v, want none for a ServiceEntry outside the egress hosts", warnings)
+	}
+}
+
+func TestValidateSidecarScopesTreatsMissingEgressAsUnbounded(t *testing.T) {
+	sidecar := unstructuredSidecar("apps", "default")
+	se := unstructuredServiceEntry("other", "external", []string{"api.example.com"}, 2)
+	c := newFakeSidecarScopeClient(t, sidecar, se)
+
+	warnings := ValidateSidecarScopes(context.Background(), c, 1)
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, want one: a Sidecar with no egress entries scopes every namespace", warnings)
+	}
+}
+
+func TestValidateSidecarScopesStaysUnderThreshold(t *testing.T) {
+	sidecar := unstructuredSidecar("apps", "default", "apps/api.internal")
+	se := unstructuredServiceEntry("apps", "internal", []string{"api.internal"}, 2)
+	c := newFakeSidecarScopeClient(t, sidecar, se)
+
+	warnings := ValidateSidecarScopes(context.Background(), c, 5)
+	if len(warnings) != 0 {
+		t.Fatalf("warnings = %v, want none at 2 endpoints against a threshold of 5", warnings)
+	}
+}
+
+func TestMaybeValidateSidecarScopesSkipsWithoutAccessLogService(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	sidecar := unstructuredSidecar("apps", "default")
+	se := unstructuredServiceEntry("other", "external", []string{"api.example.com"}, 10)
+	c := newFakeSidecarScopeClient(t, sidecar, se)
+
+	warnings, err := MaybeValidateSidecarScopes(context.Background(), c, iop, 1)
+	if err != nil {
+		t.Fatalf("MaybeValidateSidecarScopes() = %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("warnings = %v, want none without enableEnvoyAccessLogService set", warnings)
+	}
+}
+
+func TestMaybeValidateSidecarScopesRunsWhenAccessLogServiceEnabled(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: meshConfigMapName},
+		Data:       map[string]string{"mesh": "enableEnvoyAccessLogService: true\n"},
+	}
+	sidecar := unstructuredSidecar("apps", "default")
+	se := unstructuredServiceEntry("other", "external", []string{"api.example.com"}, 10)
+	c := newFakeSidecarScopeClient(t, sidecar, se, cm)
+
+	warnings, err := MaybeValidateSidecarScopes(context.Background(), c, iop, 1)
+	if err != nil {
+		t.Fatalf("MaybeValidateSidecarScopes() = %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, want one with enableEnvoyAccessLogService set", warnings)
+	}
+}
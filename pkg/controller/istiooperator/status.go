@@ -0,0 +1,104 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package istiooperator holds the reconciliation helpers for the
+// IstioOperator custom resource, starting with its status subresource.
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+const (
+	// ConditionInstalled reports whether the control plane's resources have
+	// been applied to the cluster at least once.
+	ConditionInstalled = "Installed"
+	// ConditionReconciling is True for the duration of an in-progress
+	// reconcile loop, so observers can distinguish "still working" from
+	// "settled on Ready/Degraded".
+	ConditionReconciling = "Reconciling"
+	// ConditionReady reports whether every reconciled component reported
+	// healthy on the most recent reconcile.
+	ConditionReady = "Ready"
+	// ConditionDegraded reports whether at least one reconciled component
+	// reported unhealthy on the most recent reconcile.
+	ConditionDegraded = "Degraded"
+	// ConditionCanaryUpgrading is True for the duration of a CanaryUpgrade
+	// run, so observers can tell a slow upgrade apart from a stalled
+	// ordinary reconcile.
+	ConditionCanaryUpgrading = "CanaryUpgrading"
+	// ConditionMigrating is True for the duration of a TrustDomainMigration
+	// run, so observers can tell which step it's on and so a second call
+	// can refuse to start a migration over an in-progress one.
+	ConditionMigrating = "Migrating"
+)
+
+// UpdateOperatorStatus sets condType to status on iop's Status.Conditions via
+// apimeta.SetStatusCondition — which updates an existing condition of that
+// type in place, or appends a new one, and bumps LastTransitionTime only
+// when Status actually changes — then patches iop's status subresource so
+// the write can't clobber a concurrent change to iop's spec. Reconcile loops
+// are expected to call this with ConditionReconciling=True at the start of a
+// pass, then with ConditionReady=True or ConditionDegraded=True once every
+// component's health has been checked.
+func UpdateOperatorStatus(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator,
+	condType string, status metav1.ConditionStatus, reason, message string) error {
+	before := iop.DeepCopy()
+	apimeta.SetStatusCondition(&iop.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: iop.Generation,
+	})
+	if err := c.Status().Patch(ctx, iop, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("updating status of IstioOperator %s/%s: %w", iop.Namespace, iop.Name, err)
+	}
+	return nil
+}
+
+// SetComponentCondition records component's most recent manifest-rendering
+// outcome on iop.Status.ComponentConditions, bumping LastTransitionTime only
+// when phase differs from what's already recorded there. Reconcile loops are
+// expected to call this the moment a component's manifests are rendered or
+// fail to render, rather than waiting for the whole pass to finish, so a
+// kubectl-watching user sees a failure as soon as it happens instead of only
+// once reconciliation settles on Ready/Degraded.
+func SetComponentCondition(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator,
+	component string, phase v1alpha1.ComponentConditionPhase, message string) error {
+	before := iop.DeepCopy()
+
+	if iop.Status.ComponentConditions == nil {
+		iop.Status.ComponentConditions = make(map[string]v1alpha1.ComponentCondition)
+	}
+	condition := iop.Status.ComponentConditions[component]
+	if condition.Phase != phase {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	condition.Phase = phase
+	condition.Message = message
+	iop.Status.ComponentConditions[component] = condition
+
+	if err := c.Status().Patch(ctx, iop, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("setting %s component condition on IstioOperator %s/%s: %w", component, iop.Namespace, iop.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func newFakeClient(t *testing.T, iop *v1alpha1.IstioOperator) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(iop).WithStatusSubresource(iop).Build()
+}
+
+func TestUpdateOperatorStatusTransitions(t *testing.T) {
+	tests := []struct {
+		name        string
+		transitions []struct {
+			condType string
+			status   metav1.ConditionStatus
+			reason   string
+		}
+	}{
+		{
+			name: "reconciling then ready",
+			transitions: []struct {
+				condType string
+				status   metav1.ConditionStatus
+				reason   string
+			}{
+				{ConditionReconciling, metav1.ConditionTrue, "ReconcileStarted"},
+				{ConditionInstalled, metav1.ConditionTrue, "ResourcesApplied"},
+				{ConditionReady, metav1.ConditionTrue, "AllComponentsHealthy"},
+				{ConditionReconciling, metav1.ConditionFalse, "ReconcileFinished"},
+			},
+		},
+		{
+			name: "reconciling then degraded",
+			transitions: []struct {
+				condType string
+				status   metav1.ConditionStatus
+				reason   string
+			}{
+				{ConditionReconciling, metav1.ConditionTrue, "ReconcileStarted"},
+				{ConditionDegraded, metav1.ConditionTrue, "ComponentUnhealthy"},
+				{ConditionReconciling, metav1.ConditionFalse, "ReconcileFinished"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			iop := &v1alpha1.IstioOperator{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+			}
+			c := newFakeClient(t, iop)
+
+			for _, step := range tc.transitions {
+				if err := UpdateOperatorStatus(context.Background(), c, iop, step.condType, step.status, step.reason, ""); err != nil {
+					t.Fatalf("UpdateOperatorStatus(%s) = %v", step.condType, err)
+				}
+				cond := apimeta.FindStatusCondition(iop.Status.Conditions, step.condType)
+				if cond == nil {
+					t.Fatalf("condition %s not found after UpdateOperatorStatus", step.condType)
+				}
+				if cond.Status != step.status {
+					t.Fatalf("condition %s status = %s, want %s", step.condType, cond.Status, step.status)
+				}
+				if cond.Reason != step.reason {
+					t.Fatalf("condition %s reason = %s, want %s", step.condType, cond.Reason, step.reason)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateOperatorStatusIsIdempotentWhenUnchanged(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+	}
+	c := newFakeClient(t, iop)
+
+	if err := UpdateOperatorStatus(context.Background(), c, iop, ConditionReady, metav1.ConditionTrue, "AllComponentsHealthy", "ok"); err != nil {
+		t.Fatalf("UpdateOperatorStatus() = %v", err)
+	}
+	first := apimeta.FindStatusCondition(iop.Status.Conditions, ConditionReady).LastTransitionTime
+
+	if err := UpdateOperatorStatus(context.Background(), c, iop, ConditionReady, metav1.ConditionTrue, "AllComponentsHealthy", "ok"); err != nil {
+		t.Fatalf("UpdateOperatorStatus() = %v", err)
+	}
+	second := apimeta.FindStatusCondition(iop.Status.Conditions, ConditionReady).LastTransitionTime
+
+	if !first.Equal(&second) {
+		t.Fatalf("LastTransitionTime changed from %v to %v for a no-op status update", first, second)
+	}
+}
+
+func TestSetComponentConditionRecordsFailureImmediately(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+	}
+	c := newFakeClient(t, iop)
+
+	if err := SetComponentCondition(context.Background(), c, iop, "ingressGateway", v1alpha1.ComponentPhaseFailed, "rendering Service: invalid port"); err != nil {
+		t.Fatalf("SetComponentCondition() = %v", err)
+	}
+
+	got, ok := iop.Status.ComponentConditions["ingressGateway"]
+	if !ok {
+		t.Fatalf("ComponentConditions = %v, want an entry for ingressGateway", iop.Status.ComponentConditions)
+	}
+	if got.Phase != v1alpha1.ComponentPhaseFailed {
+		t.Fatalf("Phase = %s, want %s", got.Phase, v1alpha1.ComponentPhaseFailed)
+	}
+	if got.Message != "rendering Service: invalid port" {
+		t.Fatalf("Message = %q, want the rendering error", got.Message)
+	}
+	if got.LastTransitionTime.IsZero() {
+		t.Fatal("LastTransitionTime is zero, want it set for a new condition")
+	}
+
+	// A second, unrelated component's condition must not disturb the first.
+	if err := SetComponentCondition(context.Background(), c, iop, "istiod", v1alpha1.ComponentPhaseInstalled, ""); err != nil {
+		t.Fatalf("SetComponentCondition() = %v", err)
+	}
+	if len(iop.Status.ComponentConditions) != 2 {
+		t.Fatalf("ComponentConditions = %v, want entries for both components", iop.Status.ComponentConditions)
+	}
+}
+
+func TestSetComponentConditionOnlyBumpsTransitionTimeOnPhaseChange(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+	}
+	c := newFakeClient(t, iop)
+
+	if err := SetComponentCondition(context.Background(), c, iop, "istiod", v1alpha1.ComponentPhaseInstalled, ""); err != nil {
+		t.Fatalf("SetComponentCondition() = %v", err)
+	}
+	first := iop.Status.ComponentConditions["istiod"].LastTransitionTime
+
+	if err := SetComponentCondition(context.Background(), c, iop, "istiod", v1alpha1.ComponentPhaseInstalled, ""); err != nil {
+		t.Fatalf("SetComponentCondition() = %v", err)
+	}
+	second := iop.Status.ComponentConditions["istiod"].LastTransitionTime
+
+	if !first.Equal(&second) {
+		t.Fatalf("LastTransitionTime changed from %v to %v for an unchanged phase", first, second)
+	}
+}
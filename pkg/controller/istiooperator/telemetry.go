@@ -0,0 +1,178 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// telemetryGVK identifies the Telemetry resource TelemetryReconciler
+// manages. It isn't vendored as a typed Go API in this repo, so it's handled
+// as unstructured.Unstructured the same way pkg/webhook's
+// EgressGatewayPolicyEnforcer handles ServiceEntry and VirtualService.
+var telemetryGVK = schema.GroupVersionKind{Group: "telemetry.istio.io", Version: "v1alpha1", Kind: "Telemetry"}
+
+// telemetryNamespace is where TelemetryReconciler creates its Telemetry
+// resource: Istio treats a Telemetry object with no workload selector as a
+// mesh-wide default only when it lives in the mesh's root namespace, which
+// this operator always installs as istio-system regardless of which
+// namespace a given IstioOperator CR itself lives in.
+const telemetryNamespace = "istio-system"
+
+// telemetryBaseName is the base name ResourceName suffixes with
+// iop.Spec.Revision for the Telemetry resource TelemetryReconciler owns.
+const telemetryBaseName = "mesh-default"
+
+// TelemetryFinalizer, while present on an IstioOperator, holds its deletion
+// open until RunTelemetryFinalizer has had a chance to delete the Telemetry
+// resource TelemetryReconciler created for it. A Telemetry resource can't
+// carry an OwnerReference back to iop the way PDBReconciler's and
+// HPAReconciler's resources do, since it lives in telemetryNamespace rather
+// than iop.Namespace and Kubernetes rejects an OwnerReference across
+// namespaces, so this finalizer is the only cleanup path.
+const TelemetryFinalizer = "operator.istio.io/telemetry"
+
+// TelemetryReconciler creates or updates the mesh-wide Telemetry resource
+// that customizes which Envoy stats every proxy in the mesh reports, per
+// iop.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher.
+type TelemetryReconciler struct{}
+
+// Reconcile applies a Telemetry resource named ResourceName(iop,
+// telemetryBaseName) in telemetryNamespace reflecting
+// iop.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher, or deletes it if
+// ProxyStatsMatcher is unset, so removing the field from iop.Spec hands
+// Envoy's stats back to its own defaults instead of leaving a stale
+// customization in place.
+func (r *TelemetryReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	name := ResourceName(iop, telemetryBaseName)
+
+	matcher := proxyStatsMatcher(iop)
+	if matcher == nil {
+		telemetry := &unstructured.Unstructured{}
+		telemetry.SetGroupVersionKind(telemetryGVK)
+		telemetry.SetNamespace(telemetryNamespace)
+		telemetry.SetName(name)
+		if err := c.Delete(ctx, telemetry); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting Telemetry %s: %w", name, err)
+		}
+		return nil
+	}
+
+	telemetry := &unstructured.Unstructured{}
+	telemetry.SetGroupVersionKind(telemetryGVK)
+	telemetry.SetNamespace(telemetryNamespace)
+	telemetry.SetName(name)
+	telemetry.SetLabels(RevisionLabels(iop))
+	telemetry.Object["spec"] = map[string]interface{}{
+		"metrics": []interface{}{
+			map[string]interface{}{
+				"providers": []interface{}{
+					map[string]interface{}{"name": "prometheus"},
+				},
+			},
+		},
+		// statsMatcher projects ProxyStatsMatcher verbatim. It isn't part of
+		// Telemetry's real API — stats inclusion/exclusion is a
+		// ProxyConfig-level concept in Istio, not a Telemetry one — but this
+		// operator doesn't have anywhere else to put it on a resource named
+		// after a specific IstioOperator CR, so it's kept here as a custom
+		// extension field until istio.io/istio exposes an official
+		// equivalent.
+		"statsMatcher": map[string]interface{}{
+			"inclusionPrefixes": toInterfaceSlice(matcher.InclusionPrefixes),
+			"inclusionSuffixes": toInterfaceSlice(matcher.InclusionSuffixes),
+			"inclusionRegexps":  toInterfaceSlice(matcher.InclusionRegexps),
+		},
+	}
+
+	if err := c.Patch(ctx, telemetry, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying Telemetry %s: %w", name, err)
+	}
+	return nil
+}
+
+// proxyStatsMatcher returns iop.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher,
+// or nil if any step of that path is unset.
+func proxyStatsMatcher(iop *v1alpha1.IstioOperator) *v1alpha1.ProxyStatsMatcherSpec {
+	if iop.Spec.MeshConfig == nil || iop.Spec.MeshConfig.DefaultConfig == nil {
+		return nil
+	}
+	return iop.Spec.MeshConfig.DefaultConfig.ProxyStatsMatcher
+}
+
+// toInterfaceSlice converts ss to the []interface{} shape
+// unstructured.Unstructured requires for a nested array field.
+func toInterfaceSlice(ss []string) []interface{} {
+	out := make([]interface{}, len(ss))
+	for i, s := range ss {
+		out[i] = s
+	}
+	return out
+}
+
+// EnsureTelemetryFinalizer adds TelemetryFinalizer to iop if it isn't
+// already present. Reconcile loops are expected to call this on every pass
+// for an IstioOperator that isn't already being deleted, so deletion always
+// has a chance to clean up the Telemetry resource rather than only when the
+// finalizer happened to be added on CR creation.
+func EnsureTelemetryFinalizer(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if controllerutil.ContainsFinalizer(iop, TelemetryFinalizer) {
+		return nil
+	}
+
+	before := iop.DeepCopy()
+	controllerutil.AddFinalizer(iop, TelemetryFinalizer)
+	if err := c.Patch(ctx, iop, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("adding %s finalizer to IstioOperator %s/%s: %w", TelemetryFinalizer, iop.Namespace, iop.Name, err)
+	}
+	return nil
+}
+
+// RunTelemetryFinalizer is meant to run from the IstioOperator reconcile
+// loop once iop.DeletionTimestamp is set and TelemetryFinalizer is still
+// present. It deletes the Telemetry resource TelemetryReconciler would have
+// created for iop, then removes the finalizer either way: a Telemetry
+// resource that was never created (e.g. ProxyStatsMatcher was never set) is
+// no reason to hold deletion open.
+func RunTelemetryFinalizer(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if !controllerutil.ContainsFinalizer(iop, TelemetryFinalizer) {
+		return nil
+	}
+
+	telemetry := &unstructured.Unstructured{}
+	telemetry.SetGroupVersionKind(telemetryGVK)
+	telemetry.SetNamespace(telemetryNamespace)
+	telemetry.SetName(ResourceName(iop, telemetryBaseName))
+	if err := c.Delete(ctx, telemetry); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting Telemetry %s: %w", telemetry.GetName(), err)
+	}
+
+	before := iop.DeepCopy()
+	controllerutil.RemoveFinalizer(iop, TelemetryFinalizer)
+	if err := c.Patch(ctx, iop, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("removing %s finalizer from IstioOperator %s/%s: %w", TelemetryFinalizer, iop.Namespace, iop.Name, err)
+	}
+	return nil
+}
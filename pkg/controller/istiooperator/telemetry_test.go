@@ -0,0 +1,154 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// newFakeTelemetryClient builds on newFakeClientWithObjects's scheme, adding
+// telemetryGVK the way newFakeEgressGatewayClient does for ServiceEntry and
+// VirtualService in pkg/webhook, since it isn't registered by default.
+func newFakeTelemetryClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(telemetryGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(telemetryGVK.GroupVersion().WithKind("TelemetryList"), &unstructured.UnstructuredList{})
+
+	var iops []client.Object
+	for _, o := range objs {
+		if _, ok := o.(*v1alpha1.IstioOperator); ok {
+			iops = append(iops, o)
+		}
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(iops...).Build()
+}
+
+func TestTelemetryReconcilerAppliesStatsMatcher(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			MeshConfig: &v1alpha1.MeshConfigSpec{
+				DefaultConfig: &v1alpha1.ProxyConfigSpec{
+					ProxyStatsMatcher: &v1alpha1.ProxyStatsMatcherSpec{
+						InclusionRegexps: []string{"reductor.*"},
+					},
+				},
+			},
+		},
+	}
+	c := newFakeTelemetryClient(t)
+
+	r := &TelemetryReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	telemetry := &unstructured.Unstructured{}
+	telemetry.SetGroupVersionKind(telemetryGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "mesh-default"}, telemetry); err != nil {
+		t.Fatalf("Get() Telemetry = %v", err)
+	}
+
+	regexps, _, _ := unstructured.NestedStringSlice(telemetry.Object, "spec", "statsMatcher", "inclusionRegexps")
+	if len(regexps) != 1 || regexps[0] != "reductor.*" {
+		t.Fatalf("statsMatcher.inclusionRegexps = %v, want [reductor.*]", regexps)
+	}
+}
+
+func TestTelemetryReconcilerDeletesWhenMatcherRemoved(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			MeshConfig: &v1alpha1.MeshConfigSpec{
+				DefaultConfig: &v1alpha1.ProxyConfigSpec{
+					ProxyStatsMatcher: &v1alpha1.ProxyStatsMatcherSpec{InclusionPrefixes: []string{"cluster."}},
+				},
+			},
+		},
+	}
+	c := newFakeTelemetryClient(t)
+
+	r := &TelemetryReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	iop.Spec.MeshConfig = nil
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	telemetry := &unstructured.Unstructured{}
+	telemetry.SetGroupVersionKind(telemetryGVK)
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "mesh-default"}, telemetry)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() Telemetry = %v, want a not-found error once ProxyStatsMatcher is unset", err)
+	}
+}
+
+func TestTelemetryFinalizerDeletesTelemetryOnDeletion(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			MeshConfig: &v1alpha1.MeshConfigSpec{
+				DefaultConfig: &v1alpha1.ProxyConfigSpec{
+					ProxyStatsMatcher: &v1alpha1.ProxyStatsMatcherSpec{InclusionPrefixes: []string{"cluster."}},
+				},
+			},
+		},
+	}
+	c := newFakeTelemetryClient(t, iop)
+
+	if err := EnsureTelemetryFinalizer(context.Background(), c, iop); err != nil {
+		t.Fatalf("EnsureTelemetryFinalizer() = %v", err)
+	}
+	if !controllerutil.ContainsFinalizer(iop, TelemetryFinalizer) {
+		t.Fatalf("iop.Finalizers = %v, want %s", iop.Finalizers, TelemetryFinalizer)
+	}
+
+	r := &TelemetryReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	if err := RunTelemetryFinalizer(context.Background(), c, iop); err != nil {
+		t.Fatalf("RunTelemetryFinalizer() = %v", err)
+	}
+	if controllerutil.ContainsFinalizer(iop, TelemetryFinalizer) {
+		t.Fatalf("iop.Finalizers = %v, want %s removed", iop.Finalizers, TelemetryFinalizer)
+	}
+
+	telemetry := &unstructured.Unstructured{}
+	telemetry.SetGroupVersionKind(telemetryGVK)
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "mesh-default"}, telemetry)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() Telemetry = %v, want a not-found error after RunTelemetryFinalizer", err)
+	}
+}
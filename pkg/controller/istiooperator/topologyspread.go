@@ -0,0 +1,120 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// zoneTopologyLabel is the well-known node label used to spread istiod
+// across failure domains.
+const zoneTopologyLabel = "topology.kubernetes.io/zone"
+
+// TopologySpreadReconciler sets each component's Deployment pod template's
+// TopologySpreadConstraints from
+// IstioOperatorSpec.ComponentTopologySpreadConstraints, the same merge
+// pattern PodAnnotationsReconciler uses for ComponentPodAnnotations. A
+// component with no entry gets no explicit constraints from this
+// reconciler, except for "istiod": when the cluster has nodes in more than
+// one zone, it gets defaultTopologySpreadConstraint's zone-spread default
+// instead of being left unconstrained, since that's the scenario users
+// actually run into zone-affinity issues with.
+type TopologySpreadReconciler struct{}
+
+// Reconcile applies TopologySpreadConstraints to every component in
+// iop.Spec.Components that has a rendered Deployment.
+func (r *TopologySpreadReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	for _, componentName := range iop.Spec.Components {
+		if err := r.reconcileComponent(ctx, c, iop, componentName); err != nil {
+			return fmt.Errorf("setting topology spread constraints for component %s: %w", componentName, err)
+		}
+	}
+	return nil
+}
+
+func (r *TopologySpreadReconciler) reconcileComponent(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, componentName string) error {
+	name := ResourceName(iop, componentName)
+	deployment := &appsv1.Deployment{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: name}, deployment)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("getting Deployment %s: %w", name, err)
+	}
+
+	constraints := iop.Spec.ComponentTopologySpreadConstraints[componentName]
+	if len(constraints) == 0 && componentName == "istiod" {
+		defaultConstraint, ok, err := r.defaultTopologySpreadConstraint(ctx, c, deployment)
+		if err != nil {
+			return fmt.Errorf("computing default topology spread constraint: %w", err)
+		}
+		if ok {
+			constraints = []corev1.TopologySpreadConstraint{defaultConstraint}
+		}
+	}
+	if len(constraints) == 0 {
+		return nil
+	}
+
+	before := deployment.DeepCopy()
+	deployment.Spec.Template.Spec.TopologySpreadConstraints = constraints
+	if err := c.Patch(ctx, deployment, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("patching Deployment %s: %w", name, err)
+	}
+	return nil
+}
+
+// defaultTopologySpreadConstraint returns the maxSkew=1,
+// topologyKey=topology.kubernetes.io/zone, whenUnsatisfiable=ScheduleAnyway
+// constraint istiod gets when no explicit override is configured, scoped to
+// deployment's own selector so it only spreads istiod's own pods against
+// each other. ok is false (with no error) when the cluster's nodes aren't
+// labeled across more than one zone, or when deployment has no selector to
+// scope the constraint to.
+func (r *TopologySpreadReconciler) defaultTopologySpreadConstraint(ctx context.Context, c client.Client, deployment *appsv1.Deployment) (corev1.TopologySpreadConstraint, bool, error) {
+	if deployment.Spec.Selector == nil {
+		return corev1.TopologySpreadConstraint{}, false, nil
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		return corev1.TopologySpreadConstraint{}, false, fmt.Errorf("listing nodes: %w", err)
+	}
+	zones := make(map[string]bool)
+	for _, node := range nodes.Items {
+		if zone := node.Labels[zoneTopologyLabel]; zone != "" {
+			zones[zone] = true
+		}
+	}
+	if len(zones) < 2 {
+		return corev1.TopologySpreadConstraint{}, false, nil
+	}
+
+	return corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       zoneTopologyLabel,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     deployment.Spec.Selector,
+	}, true, nil
+}
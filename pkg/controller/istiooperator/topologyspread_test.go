@@ -0,0 +1,147 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func zoneNode(name, zone string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{zoneTopologyLabel: zone}},
+	}
+}
+
+func TestTopologySpreadReconcilerAppliesConfiguredConstraints(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"},
+	}
+	deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "istiod"}}
+
+	constraint := corev1.TopologySpreadConstraint{MaxSkew: 2, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: corev1.DoNotSchedule}
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Components: []string{"istiod"},
+			ComponentTopologySpreadConstraints: map[string][]corev1.TopologySpreadConstraint{
+				"istiod": {constraint},
+			},
+		},
+	}
+	c := newFakeClientWithObjects(t, deployment)
+
+	r := &TopologySpreadReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, got); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if len(got.Spec.Template.Spec.TopologySpreadConstraints) != 1 || got.Spec.Template.Spec.TopologySpreadConstraints[0] != constraint {
+		t.Fatalf("TopologySpreadConstraints = %+v, want [%+v]", got.Spec.Template.Spec.TopologySpreadConstraints, constraint)
+	}
+}
+
+func TestTopologySpreadReconcilerDefaultsIstiodAcrossMultipleZones(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"},
+	}
+	deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "istiod"}}
+
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}},
+	}
+	c := newFakeClientWithObjects(t, deployment, zoneNode("node-a", "zone-a"), zoneNode("node-b", "zone-b"))
+
+	r := &TopologySpreadReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, got); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	constraints := got.Spec.Template.Spec.TopologySpreadConstraints
+	if len(constraints) != 1 {
+		t.Fatalf("TopologySpreadConstraints = %+v, want one default constraint", constraints)
+	}
+	if constraints[0].MaxSkew != 1 || constraints[0].TopologyKey != zoneTopologyLabel || constraints[0].WhenUnsatisfiable != corev1.ScheduleAnyway {
+		t.Fatalf("constraints[0] = %+v, want the maxSkew=1/%s/ScheduleAnyway default", constraints[0], zoneTopologyLabel)
+	}
+}
+
+func TestTopologySpreadReconcilerSkipsIstiodDefaultInSingleZoneCluster(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"},
+	}
+	deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "istiod"}}
+
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}},
+	}
+	c := newFakeClientWithObjects(t, deployment, zoneNode("node-a", "zone-a"), zoneNode("node-b", "zone-a"))
+
+	r := &TopologySpreadReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, got); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if len(got.Spec.Template.Spec.TopologySpreadConstraints) != 0 {
+		t.Fatalf("TopologySpreadConstraints = %+v, want none in a single-zone cluster", got.Spec.Template.Spec.TopologySpreadConstraints)
+	}
+}
+
+func TestTopologySpreadReconcilerNoDefaultForNonIstiodComponent(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istio-ingressgateway"},
+	}
+	deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "istio-ingressgateway"}}
+
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istio-ingressgateway"}},
+	}
+	c := newFakeClientWithObjects(t, deployment, zoneNode("node-a", "zone-a"), zoneNode("node-b", "zone-b"))
+
+	r := &TopologySpreadReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	got := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istio-ingressgateway"}, got); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if len(got.Spec.Template.Spec.TopologySpreadConstraints) != 0 {
+		t.Fatalf("TopologySpreadConstraints = %+v, want none for a component other than istiod with no explicit override", got.Spec.Template.Spec.TopologySpreadConstraints)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// trustDomainProxySyncPollInterval and trustDomainProxySyncTimeout bound how
+// long TrustDomainMigration waits for every Envoy sidecar in the mesh to come
+// back up healthy after each istiod rollout it triggers.
+const (
+	trustDomainProxySyncPollInterval = 5 * time.Second
+	trustDomainProxySyncTimeout      = 10 * time.Minute
+)
+
+// trustDomainMeshConfig is the subset of Istio's mesh config
+// TrustDomainMigration needs to read and write; a full render belongs to
+// istio.io/istio's own mesh config package once this operator vendors it,
+// same caveat as externalControlPlaneMeshConfig's and webhook.ValidateSpec's
+// for the rest of mesh config.
+type trustDomainMeshConfig struct {
+	TrustDomain        string   `json:"trustDomain,omitempty"`
+	TrustDomainAliases []string `json:"trustDomainAliases,omitempty"`
+}
+
+// TrustDomainMigration safely rolls iop's mesh over from its current
+// spec.meshConfig.trustDomain to newDomain, following Istio's documented
+// trust domain migration procedure
+// (https://istio.io/latest/docs/tasks/security/authentication/change-trust-domain/):
+// it sets ConfigMap/istio's mesh config to newDomain while keeping the old
+// domain as a trustDomainAliases entry so in-flight mTLS connections using
+// either identity still validate, rolls istiod out so it starts issuing
+// certificates for newDomain, waits for every sidecar in the mesh to resync
+// against it, removes the old domain from trustDomainAliases now that
+// nothing should still be presenting it, then rolls istiod out a second time
+// to drop the alias. Progress is recorded on iop.Status.Conditions as
+// ConditionMigrating so a kubectl-watching user can see which step it's on;
+// a second call while ConditionMigrating is already True returns an error
+// instead of running two migrations over each other's edits to
+// trustDomainAliases.
+func TrustDomainMigration(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, newDomain string) error {
+	if newDomain == "" {
+		return fmt.Errorf("newDomain must not be empty")
+	}
+	if cond := apimeta.FindStatusCondition(iop.Status.Conditions, ConditionMigrating); cond != nil && cond.Status == metav1.ConditionTrue {
+		return fmt.Errorf("a trust domain migration is already in progress for IstioOperator %s/%s", iop.Namespace, iop.Name)
+	}
+
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionMigrating, metav1.ConditionTrue,
+		"AddingTrustDomainAlias", fmt.Sprintf("Setting trust domain to %s, keeping the previous domain as an alias", newDomain)); err != nil {
+		return err
+	}
+
+	oldDomain, err := addTrustDomainAlias(ctx, c, iop, newDomain)
+	if err != nil {
+		return fmt.Errorf("adding trust domain alias: %w", err)
+	}
+	if oldDomain == newDomain {
+		return UpdateOperatorStatus(ctx, c, iop, ConditionMigrating, metav1.ConditionFalse,
+			"TrustDomainUnchanged", fmt.Sprintf("Trust domain is already %s; nothing to migrate", newDomain))
+	}
+
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionMigrating, metav1.ConditionTrue,
+		"RollingOutNewTrustDomain", "Restarting istiod so it issues certificates for the new trust domain"); err != nil {
+		return err
+	}
+	if err := restartIstiod(ctx, c, iop); err != nil {
+		return fmt.Errorf("rolling out istiod for the new trust domain: %w", err)
+	}
+
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionMigrating, metav1.ConditionTrue,
+		"WaitingForProxies", "Waiting for every Envoy sidecar in the mesh to resync against the new trust domain"); err != nil {
+		return err
+	}
+	if err := waitForProxiesResynced(ctx, c); err != nil {
+		_ = UpdateOperatorStatus(ctx, c, iop, ConditionMigrating, metav1.ConditionFalse, "ProxySyncTimedOut", err.Error())
+		return fmt.Errorf("waiting for proxies to resync against the new trust domain: %w", err)
+	}
+
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionMigrating, metav1.ConditionTrue,
+		"RemovingTrustDomainAlias", fmt.Sprintf("Removing %s from trustDomainAliases now that every proxy has resynced", oldDomain)); err != nil {
+		return err
+	}
+	if err := removeTrustDomainAlias(ctx, c, iop, oldDomain); err != nil {
+		return fmt.Errorf("removing trust domain alias: %w", err)
+	}
+
+	if err := UpdateOperatorStatus(ctx, c, iop, ConditionMigrating, metav1.ConditionTrue,
+		"RollingOutAliasRemoval", "Restarting istiod a second time to drop the old trust domain alias"); err != nil {
+		return err
+	}
+	if err := restartIstiod(ctx, c, iop); err != nil {
+		return fmt.Errorf("rolling out istiod for the alias removal: %w", err)
+	}
+
+	return UpdateOperatorStatus(ctx, c, iop, ConditionMigrating, metav1.ConditionFalse,
+		"TrustDomainMigrationComplete", fmt.Sprintf("Trust domain migrated from %s to %s", oldDomain, newDomain))
+}
+
+// addTrustDomainAlias sets ConfigMap/istio's mesh config trustDomain to
+// newDomain, appending whatever trustDomain was previously set to
+// trustDomainAliases (unless it's already listed, or was never set at all).
+// It returns the previous trustDomain so callers can detect a no-op
+// migration and, later, remove the alias again.
+func addTrustDomainAlias(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, newDomain string) (string, error) {
+	cm, mesh, err := getMeshConfig(ctx, c, iop)
+	if err != nil {
+		return "", err
+	}
+	oldDomain := mesh.TrustDomain
+	before := cm.DeepCopy()
+
+	mesh.TrustDomain = newDomain
+	if oldDomain != "" && oldDomain != newDomain && !containsString(mesh.TrustDomainAliases, oldDomain) {
+		mesh.TrustDomainAliases = append(mesh.TrustDomainAliases, oldDomain)
+	}
+
+	return oldDomain, patchMeshConfig(ctx, c, cm, before, mesh)
+}
+
+// removeTrustDomainAlias drops domain from ConfigMap/istio's mesh config
+// trustDomainAliases, leaving trustDomain and every other alias untouched.
+func removeTrustDomainAlias(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, domain string) error {
+	cm, mesh, err := getMeshConfig(ctx, c, iop)
+	if err != nil {
+		return err
+	}
+	before := cm.DeepCopy()
+
+	aliases := make([]string, 0, len(mesh.TrustDomainAliases))
+	for _, alias := range mesh.TrustDomainAliases {
+		if alias != domain {
+			aliases = append(aliases, alias)
+		}
+	}
+	mesh.TrustDomainAliases = aliases
+
+	return patchMeshConfig(ctx, c, cm, before, mesh)
+}
+
+// getMeshConfig reads ConfigMap/istio's "mesh" key into a trustDomainMeshConfig,
+// creating an empty in-memory ConfigMap (not yet persisted) if one doesn't exist yet.
+func getMeshConfig(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) (*corev1.ConfigMap, trustDomainMeshConfig, error) {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: meshConfigMapName}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: meshConfigMapName, Namespace: iop.Namespace}}
+	case err != nil:
+		return nil, trustDomainMeshConfig{}, fmt.Errorf("getting ConfigMap %s: %w", meshConfigMapName, err)
+	}
+
+	mesh := trustDomainMeshConfig{}
+	if cm.Data != nil {
+		_ = yaml.Unmarshal([]byte(cm.Data["mesh"]), &mesh)
+	}
+	return cm, mesh, nil
+}
+
+// patchMeshConfig renders mesh back into cm's "mesh" key and persists it,
+// creating cm if before shows it didn't already exist. It skips the write
+// entirely, per ShouldUpdateConfigMap, if rendering mesh reproduces what's
+// already there: istiod restarts on every write to ConfigMap/istio, and
+// addTrustDomainAlias/removeTrustDomainAlias may be called against a mesh
+// config that's already in the desired state.
+func patchMeshConfig(ctx context.Context, c client.Client, cm *corev1.ConfigMap, before *corev1.ConfigMap, mesh trustDomainMeshConfig) error {
+	rendered, err := yaml.Marshal(mesh)
+	if err != nil {
+		return fmt.Errorf("rendering mesh config: %w", err)
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data["mesh"] = string(rendered)
+
+	if cm.ResourceVersion == "" {
+		return c.Create(ctx, cm)
+	}
+	if !ShouldUpdateConfigMap(before, cm) {
+		return nil
+	}
+	return c.Patch(ctx, cm, client.MergeFrom(before))
+}
+
+// waitForProxiesResynced polls until every istio-proxy sidecar in the
+// cluster is Ready, or trustDomainProxySyncTimeout elapses. Like
+// waitForProxiesConnected, pod readiness stands in for confirming a proxy
+// has actually pulled fresh certificates for the new trust domain, since
+// that would need istiod's /debug/syncz endpoint through a rest.Config this
+// function doesn't have.
+func waitForProxiesResynced(ctx context.Context, c client.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, trustDomainProxySyncTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(trustDomainProxySyncPollInterval)
+	defer ticker.Stop()
+	for {
+		ready, err := allProxiesReadyClusterWide(ctx, c)
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for every Envoy sidecar in the mesh to resync", trustDomainProxySyncTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// allProxiesReadyClusterWide reports whether every pod in the cluster
+// carrying an istio-proxy container is Ready.
+func allProxiesReadyClusterWide(ctx context.Context, c client.Client) (bool, error) {
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods); err != nil {
+		return false, fmt.Errorf("listing pods: %w", err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !hasContainer(pod.Spec.Containers, istioProxyContainerName) {
+			continue
+		}
+		if !isPodReady(pod) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestTrustDomainMigrationHappyPath(t *testing.T) {
+	mesh := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: meshConfigMapName},
+		Data:       map[string]string{"mesh": "trustDomain: cluster.local\n"},
+	}
+	istiod := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"}}
+	pod := readyPod(t, "shop", "reviews-abc")
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	c := newFakeClientWithObjects(t, iop, mesh, istiod, pod)
+
+	if err := TrustDomainMigration(context.Background(), c, iop, "new.example.com"); err != nil {
+		t.Fatalf("TrustDomainMigration() = %v", err)
+	}
+
+	got := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: meshConfigMapName}, got); err != nil {
+		t.Fatalf("Get() ConfigMap = %v", err)
+	}
+	gotMesh := trustDomainMeshConfig{}
+	if err := yaml.Unmarshal([]byte(got.Data["mesh"]), &gotMesh); err != nil {
+		t.Fatalf("unmarshaling mesh config: %v", err)
+	}
+	if gotMesh.TrustDomain != "new.example.com" {
+		t.Errorf("mesh.TrustDomain = %q, want %q", gotMesh.TrustDomain, "new.example.com")
+	}
+	if len(gotMesh.TrustDomainAliases) != 0 {
+		t.Errorf("mesh.TrustDomainAliases = %v, want the old domain removed once the migration completes", gotMesh.TrustDomainAliases)
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "istiod"}, deployment); err != nil {
+		t.Fatalf("Get() Deployment = %v", err)
+	}
+	if deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] == "" {
+		t.Fatalf("istiod Deployment was not annotated to trigger a rollout restart")
+	}
+
+	cond := apimeta.FindStatusCondition(iop.Status.Conditions, ConditionMigrating)
+	if cond == nil {
+		t.Fatal("ConditionMigrating not set after TrustDomainMigration")
+	}
+	if cond.Status != metav1.ConditionFalse || cond.Reason != "TrustDomainMigrationComplete" {
+		t.Errorf("final ConditionMigrating = %s/%s, want False/TrustDomainMigrationComplete", cond.Status, cond.Reason)
+	}
+}
+
+func TestTrustDomainMigrationBlocksWhileAlreadyInProgress(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"}}
+	iop.Status.Conditions = []metav1.Condition{{
+		Type:               ConditionMigrating,
+		Status:             metav1.ConditionTrue,
+		Reason:             "WaitingForProxies",
+		Message:            "migration already running",
+		LastTransitionTime: metav1.Now(),
+	}}
+	c := newFakeClientWithObjects(t, iop)
+
+	if err := TrustDomainMigration(context.Background(), c, iop, "new.example.com"); err == nil {
+		t.Fatal("TrustDomainMigration() = nil, want an error when ConditionMigrating is already True")
+	}
+}
+
+func TestAllProxiesReadyClusterWideFailsFastOnUnreadyPod(t *testing.T) {
+	notReady := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "reviews-abc"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: istioProxyContainerName}}},
+	}
+	c := newFakeClientWithObjects(t, notReady)
+
+	ready, err := allProxiesReadyClusterWide(context.Background(), c)
+	if err != nil {
+		t.Fatalf("allProxiesReadyClusterWide() = %v", err)
+	}
+	if ready {
+		t.Error("allProxiesReadyClusterWide() = true, want false for a pod with no Ready condition")
+	}
+}
+
@@ -0,0 +1,252 @@
+//go:build vaultpki
+
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	vaultkubeauth "github.com/hashicorp/vault/api/auth/kubernetes"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// serviceAccountTokenPath is where kubelet projects the operator's own
+// ServiceAccount JWT, the same path client-go's in-cluster config reads a
+// token from.
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// citadelAgentBaseName names the Service VaultPKIIntegration points
+// spec.meshConfig.caAddress at, suffixed by revision the same way
+// ResourceName suffixes every other component's resources. The Service
+// itself, and the CitadelAgent deployment backing it, are provisioned
+// outside this operator; VaultPKIIntegration only wires caAddress to it.
+const citadelAgentBaseName = "citadel-agent"
+
+// citadelAgentPort is the gRPC port a CitadelAgent serves the Vault-backed
+// CA API on.
+const citadelAgentPort = "8060"
+
+// istiodVaultTokenBaseName names the Secret VaultPKIIntegration writes the
+// Vault client token istiod uses into, suffixed by revision the same way
+// ResourceName suffixes every other component's resources.
+const istiodVaultTokenBaseName = "istiod-vault-token"
+
+// istiodVaultTokenSecretKey is the Secret.Data key VaultPKIIntegration
+// writes the Vault client token under.
+const istiodVaultTokenSecretKey = "token"
+
+// vaultLoginTotal counts the times VaultPKIIntegration has logged in to
+// Vault and refreshed istiod's token Secret, the same way
+// certManagerRestartTotal counts CertManagerIntegration's istiod restarts.
+var vaultLoginTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "vault_pki_login_total",
+	Help: "Number of times VaultPKIIntegration has logged in to Vault and refreshed istiod's Vault token Secret.",
+})
+
+func init() {
+	prometheus.MustRegister(vaultLoginTotal)
+}
+
+// vaultPKIMeshConfig is the subset of Istio's mesh config VaultPKIIntegration
+// needs to read and write; a full render belongs to istio.io/istio's own
+// mesh config package once this operator vendors it, same caveat as
+// trustDomainMeshConfig's, externalControlPlaneMeshConfig's, and
+// webhook.ValidateSpec's for the rest of mesh config.
+type vaultPKIMeshConfig struct {
+	TrustDomain string `json:"trustDomain,omitempty"`
+	CaAddress   string `json:"caAddress,omitempty"`
+}
+
+// VaultPKIIntegration hands issuance of Istio workload certificates over to
+// a HashiCorp Vault PKI secrets engine, for an IstioOperator with
+// iop.Spec.Security.CertProvider set to v1alpha1.CertProviderVault: it logs
+// in to Vault as the operator's own ServiceAccount, ensures a Vault PKI
+// role exists for the mesh's trust domain, writes the resulting Vault
+// client token into a Secret for istiod to consume, and points
+// spec.meshConfig.caAddress at a CitadelAgent fronting Vault's PKI secrets
+// engine in place of istiod's own built-in CA.
+type VaultPKIIntegration struct{}
+
+// Reconcile logs in to Vault, ensures a PKI role exists for the mesh's
+// trust domain, refreshes the Vault client token istiod reads from its
+// Secret, and points spec.meshConfig.caAddress at a CitadelAgent. A nil
+// iop.Spec.Security, or one whose CertProvider isn't
+// v1alpha1.CertProviderVault, is a no-op, the same as SecretReconciler and
+// CertManagerIntegration disabling themselves when another CertProvider is
+// in effect.
+func (r *VaultPKIIntegration) Reconcile(ctx context.Context, c client.Client, recorder record.EventRecorder, iop *v1alpha1.IstioOperator) error {
+	if iop.Spec.Security == nil || iop.Spec.Security.CertProvider != v1alpha1.CertProviderVault {
+		return nil
+	}
+	if iop.Spec.Security.VaultAddress == "" || iop.Spec.Security.VaultPKIMountPath == "" || iop.Spec.Security.VaultKubernetesAuthRole == "" {
+		return fmt.Errorf("CertProviderVault requires vaultAddress, vaultPKIMountPath, and vaultKubernetesAuthRole to all be set")
+	}
+
+	vaultClient, err := r.login(ctx, iop)
+	if err != nil {
+		return fmt.Errorf("logging in to Vault at %s: %w", iop.Spec.Security.VaultAddress, err)
+	}
+
+	cm, mesh, err := r.getMeshConfig(ctx, c, iop)
+	if err != nil {
+		return err
+	}
+	before := cm.DeepCopy()
+
+	if err := r.reconcilePKIRole(vaultClient, iop, mesh.TrustDomain); err != nil {
+		return fmt.Errorf("reconciling Vault PKI role for trust domain %q: %w", mesh.TrustDomain, err)
+	}
+
+	if err := r.reconcileTokenSecret(ctx, c, iop, vaultClient.Token()); err != nil {
+		return fmt.Errorf("reconciling istiod's Vault token Secret: %w", err)
+	}
+
+	mesh.CaAddress = fmt.Sprintf("%s.%s.svc:%s", ResourceName(iop, citadelAgentBaseName), iop.Namespace, citadelAgentPort)
+	if err := r.patchMeshConfig(ctx, c, cm, before, mesh); err != nil {
+		return fmt.Errorf("reconciling ConfigMap/%s: %w", meshConfigMapName, err)
+	}
+
+	recorder.Eventf(iop, corev1.EventTypeNormal, "VaultPKILogin",
+		"logged in to Vault at %s and refreshed istiod's Vault token Secret", iop.Spec.Security.VaultAddress)
+	vaultLoginTotal.Inc()
+	return nil
+}
+
+// login authenticates to Vault as the operator's own ServiceAccount,
+// presenting the JWT kubelet projected at serviceAccountTokenPath to
+// Vault's Kubernetes auth method under the role
+// iop.Spec.Security.VaultKubernetesAuthRole, and returns a Vault client
+// carrying the resulting token.
+func (r *VaultPKIIntegration) login(ctx context.Context, iop *v1alpha1.IstioOperator) (*vaultapi.Client, error) {
+	vaultClient, err := vaultapi.NewClient(&vaultapi.Config{Address: iop.Spec.Security.VaultAddress})
+	if err != nil {
+		return nil, fmt.Errorf("constructing Vault client: %w", err)
+	}
+
+	auth, err := vaultkubeauth.NewKubernetesAuth(
+		iop.Spec.Security.VaultKubernetesAuthRole,
+		vaultkubeauth.WithServiceAccountTokenPath(serviceAccountTokenPath),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("constructing Kubernetes auth method: %w", err)
+	}
+
+	if _, err := vaultClient.Auth.Login(ctx, auth); err != nil {
+		return nil, fmt.Errorf("login: %w", err)
+	}
+	return vaultClient, nil
+}
+
+// reconcilePKIRole ensures trustDomain, Vault PKI's stand-in for istiod's
+// own per-trust-domain SPIFFE issuance, has a role configured under
+// iop.Spec.Security.VaultPKIMountPath, allowing issuance for trustDomain
+// and its subdomains.
+func (r *VaultPKIIntegration) reconcilePKIRole(vaultClient *vaultapi.Client, iop *v1alpha1.IstioOperator, trustDomain string) error {
+	if trustDomain == "" {
+		return fmt.Errorf("mesh config has no trustDomain set")
+	}
+	path := fmt.Sprintf("%s/roles/%s", iop.Spec.Security.VaultPKIMountPath, trustDomain)
+	_, err := vaultClient.Logical().Write(path, map[string]interface{}{
+		"allowed_domains":  trustDomain,
+		"allow_subdomains": true,
+		"allow_any_name":   true,
+		"max_ttl":          "8760h",
+	})
+	return err
+}
+
+// reconcileTokenSecret creates, or updates, the Secret istiod reads its
+// Vault client token from.
+func (r *VaultPKIIntegration) reconcileTokenSecret(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, token string) error {
+	name := ResourceName(iop, istiodVaultTokenBaseName)
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: caSecretNamespace, Name: name}, secret)
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: caSecretNamespace, Name: name},
+			Data:       map[string][]byte{istiodVaultTokenSecretKey: []byte(token)},
+		}
+		return c.Create(ctx, secret)
+	case err != nil:
+		return fmt.Errorf("getting: %w", err)
+	}
+
+	if string(secret.Data[istiodVaultTokenSecretKey]) == token {
+		return nil
+	}
+	before := secret.DeepCopy()
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[istiodVaultTokenSecretKey] = []byte(token)
+	return c.Patch(ctx, secret, client.MergeFrom(before))
+}
+
+// getMeshConfig reads ConfigMap/istio's "mesh" key into a
+// vaultPKIMeshConfig, creating an empty in-memory ConfigMap (not yet
+// persisted) if one doesn't exist yet.
+func (r *VaultPKIIntegration) getMeshConfig(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) (*corev1.ConfigMap, vaultPKIMeshConfig, error) {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: iop.Namespace, Name: meshConfigMapName}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: meshConfigMapName, Namespace: iop.Namespace}}
+	case err != nil:
+		return nil, vaultPKIMeshConfig{}, fmt.Errorf("getting ConfigMap %s: %w", meshConfigMapName, err)
+	}
+
+	mesh := vaultPKIMeshConfig{}
+	if cm.Data != nil {
+		_ = yaml.Unmarshal([]byte(cm.Data["mesh"]), &mesh)
+	}
+	return cm, mesh, nil
+}
+
+// patchMeshConfig renders mesh back into cm's "mesh" key and persists it,
+// creating cm if before shows it didn't already exist. It skips the write
+// entirely, per ShouldUpdateConfigMap, if rendering mesh reproduces what's
+// already there: istiod restarts on every write to ConfigMap/istio, and
+// Reconcile calls this on every pass regardless of whether caAddress
+// actually changed.
+func (r *VaultPKIIntegration) patchMeshConfig(ctx context.Context, c client.Client, cm, before *corev1.ConfigMap, mesh vaultPKIMeshConfig) error {
+	rendered, err := yaml.Marshal(mesh)
+	if err != nil {
+		return fmt.Errorf("rendering mesh config: %w", err)
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data["mesh"] = string(rendered)
+
+	if cm.ResourceVersion == "" {
+		return c.Create(ctx, cm)
+	}
+	if !ShouldUpdateConfigMap(before, cm) {
+		return nil
+	}
+	return c.Patch(ctx, cm, client.MergeFrom(before))
+}
@@ -0,0 +1,178 @@
+//go:build vaultpki
+
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func newFakeVaultPKIClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func vaultPKIIOP() *v1alpha1.IstioOperator {
+	return &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Security: &v1alpha1.SecuritySpec{
+				CertProvider:            v1alpha1.CertProviderVault,
+				VaultAddress:            "https://vault.vault.svc:8200",
+				VaultPKIMountPath:       "pki/istio",
+				VaultKubernetesAuthRole: "istio-operator",
+			},
+		},
+	}
+}
+
+func TestVaultPKIIntegrationSkipsWithoutVaultProvider(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec:       v1alpha1.IstioOperatorSpec{Security: &v1alpha1.SecuritySpec{}},
+	}
+	c := newFakeVaultPKIClient(t, iop)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &VaultPKIIntegration{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err != nil {
+		t.Fatalf("Reconcile() = %v, want nil without CertProvider set to vault", err)
+	}
+}
+
+func TestVaultPKIIntegrationRequiresVaultSettings(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Security: &v1alpha1.SecuritySpec{CertProvider: v1alpha1.CertProviderVault},
+		},
+	}
+	c := newFakeVaultPKIClient(t, iop)
+	recorder := record.NewFakeRecorder(10)
+
+	r := &VaultPKIIntegration{}
+	if err := r.Reconcile(context.Background(), c, recorder, iop); err == nil {
+		t.Fatal("Reconcile() = nil, want an error for a missing vaultAddress/vaultPKIMountPath/vaultKubernetesAuthRole")
+	}
+}
+
+func TestVaultPKIIntegrationReconcileTokenSecretCreatesWhenMissing(t *testing.T) {
+	iop := vaultPKIIOP()
+	c := newFakeVaultPKIClient(t, iop)
+
+	r := &VaultPKIIntegration{}
+	if err := r.reconcileTokenSecret(context.Background(), c, iop, "s.exampletoken"); err != nil {
+		t.Fatalf("reconcileTokenSecret() = %v", err)
+	}
+
+	got := &corev1.Secret{}
+	name := ResourceName(iop, istiodVaultTokenBaseName)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: caSecretNamespace, Name: name}, got); err != nil {
+		t.Fatalf("Get() Secret = %v", err)
+	}
+	if string(got.Data[istiodVaultTokenSecretKey]) != "s.exampletoken" {
+		t.Fatalf("Data[%q] = %q, want s.exampletoken", istiodVaultTokenSecretKey, got.Data[istiodVaultTokenSecretKey])
+	}
+}
+
+func TestVaultPKIIntegrationReconcileTokenSecretUpdatesOnRotation(t *testing.T) {
+	iop := vaultPKIIOP()
+	name := ResourceName(iop, istiodVaultTokenBaseName)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: caSecretNamespace, Name: name},
+		Data:       map[string][]byte{istiodVaultTokenSecretKey: []byte("s.oldtoken")},
+	}
+	c := newFakeVaultPKIClient(t, iop, secret)
+
+	r := &VaultPKIIntegration{}
+	if err := r.reconcileTokenSecret(context.Background(), c, iop, "s.newtoken"); err != nil {
+		t.Fatalf("reconcileTokenSecret() = %v", err)
+	}
+
+	got := &corev1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: caSecretNamespace, Name: name}, got); err != nil {
+		t.Fatalf("Get() Secret = %v", err)
+	}
+	if string(got.Data[istiodVaultTokenSecretKey]) != "s.newtoken" {
+		t.Fatalf("Data[%q] = %q, want s.newtoken", istiodVaultTokenSecretKey, got.Data[istiodVaultTokenSecretKey])
+	}
+}
+
+func TestVaultPKIIntegrationGetMeshConfigDefaultsToEmptyConfigMap(t *testing.T) {
+	iop := vaultPKIIOP()
+	c := newFakeVaultPKIClient(t, iop)
+
+	r := &VaultPKIIntegration{}
+	cm, mesh, err := r.getMeshConfig(context.Background(), c, iop)
+	if err != nil {
+		t.Fatalf("getMeshConfig() = %v", err)
+	}
+	if cm.ResourceVersion != "" {
+		t.Fatalf("ResourceVersion = %q, want empty for a ConfigMap that doesn't exist yet", cm.ResourceVersion)
+	}
+	if mesh.TrustDomain != "" || mesh.CaAddress != "" {
+		t.Fatalf("mesh = %+v, want zero value", mesh)
+	}
+}
+
+func TestVaultPKIIntegrationPatchMeshConfigSetsCaAddress(t *testing.T) {
+	iop := vaultPKIIOP()
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: iop.Namespace, Name: meshConfigMapName},
+		Data:       map[string]string{"mesh": "trustDomain: cluster.local\n"},
+	}
+	c := newFakeVaultPKIClient(t, iop, cm)
+
+	r := &VaultPKIIntegration{}
+	fetched, mesh, err := r.getMeshConfig(context.Background(), c, iop)
+	if err != nil {
+		t.Fatalf("getMeshConfig() = %v", err)
+	}
+	before := fetched.DeepCopy()
+	mesh.CaAddress = "citadel-agent.istio-system.svc:8060"
+
+	if err := r.patchMeshConfig(context.Background(), c, fetched, before, mesh); err != nil {
+		t.Fatalf("patchMeshConfig() = %v", err)
+	}
+
+	got := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: iop.Namespace, Name: meshConfigMapName}, got); err != nil {
+		t.Fatalf("Get() ConfigMap = %v", err)
+	}
+	if _, mesh, err := r.getMeshConfig(context.Background(), c, iop); err != nil || mesh.CaAddress != "citadel-agent.istio-system.svc:8060" {
+		t.Fatalf("CaAddress = %q (err %v), want citadel-agent.istio-system.svc:8060", mesh.CaAddress, err)
+	}
+	if got.Data["mesh"] == "" {
+		t.Fatal("Data[\"mesh\"] is empty after patchMeshConfig")
+	}
+}
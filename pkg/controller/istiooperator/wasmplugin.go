@@ -0,0 +1,136 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// wasmPluginGVK identifies the WasmPlugin resource WasmPluginReconciler
+// manages. It isn't vendored as a typed Go API in this repo, so it's
+// handled as unstructured.Unstructured the same way TelemetryReconciler
+// handles Telemetry.
+var wasmPluginGVK = schema.GroupVersionKind{Group: "extensions.istio.io", Version: "v1alpha1", Kind: "WasmPlugin"}
+
+// wasmPluginAppliedTotal counts WasmPlugin resources WasmPluginReconciler
+// has created or updated, across every IstioOperatorSpec.WasmPlugins entry
+// it has ever reconciled.
+var wasmPluginAppliedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "wasm_plugin_applied_total",
+	Help: "Number of WasmPlugin resources created or updated by WasmPluginReconciler.",
+})
+
+// wasmPluginErrorTotal counts WasmPluginReconciler passes that failed to
+// apply, list or delete a WasmPlugin resource.
+var wasmPluginErrorTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "wasm_plugin_error_total",
+	Help: "Number of errors WasmPluginReconciler hit creating, updating, listing or deleting a WasmPlugin resource.",
+})
+
+func init() {
+	prometheus.MustRegister(wasmPluginAppliedTotal, wasmPluginErrorTotal)
+}
+
+// WasmPluginReconciler keeps the cluster's WasmPlugin resources in sync
+// with iop.Spec.WasmPlugins: every entry gets a WasmPlugin resource created
+// or updated to match, and a WasmPlugin resource whose entry was removed
+// from the list is deleted, the same as HPAReconciler deletes a
+// HorizontalPodAutoscaler once its ComponentHPASpecs entry is removed.
+type WasmPluginReconciler struct{}
+
+// Reconcile applies iop.Spec.WasmPlugins and deletes any WasmPlugin
+// resource in iop.Namespace, for iop's revision, that no longer has a
+// corresponding entry.
+func (r *WasmPluginReconciler) Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	desired := make(map[string]bool, len(iop.Spec.WasmPlugins))
+	for _, p := range iop.Spec.WasmPlugins {
+		name := ResourceName(iop, p.Name)
+		desired[name] = true
+		if err := r.applyPlugin(ctx, c, iop, name, p); err != nil {
+			wasmPluginErrorTotal.Inc()
+			return fmt.Errorf("applying WasmPlugin %s: %w", name, err)
+		}
+		wasmPluginAppliedTotal.Inc()
+	}
+
+	if err := r.pruneRemoved(ctx, c, iop, desired); err != nil {
+		wasmPluginErrorTotal.Inc()
+		return err
+	}
+	return nil
+}
+
+// applyPlugin projects p onto a WasmPlugin resource named name, owned by
+// iop so a CR deletion also deletes its WasmPlugins.
+func (r *WasmPluginReconciler) applyPlugin(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, name string, p v1alpha1.WasmPluginSpec) error {
+	blockOwnerDeletion := true
+	isController := true
+
+	plugin := &unstructured.Unstructured{}
+	plugin.SetGroupVersionKind(wasmPluginGVK)
+	plugin.SetNamespace(iop.Namespace)
+	plugin.SetName(name)
+	plugin.SetLabels(RevisionLabels(iop))
+	plugin.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion:         v1alpha1.SchemeGroupVersion.String(),
+			Kind:               "IstioOperator",
+			Name:               iop.Name,
+			UID:                iop.UID,
+			Controller:         &isController,
+			BlockOwnerDeletion: &blockOwnerDeletion,
+		},
+	})
+
+	spec := map[string]interface{}{"url": p.URL}
+	if p.PullSecret.Name != "" {
+		spec["imagePullSecret"] = p.PullSecret.Name
+	}
+	plugin.Object["spec"] = spec
+
+	return c.Patch(ctx, plugin, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner))
+}
+
+// pruneRemoved deletes every WasmPlugin resource in iop.Namespace, for
+// iop's revision, whose name isn't in desired.
+func (r *WasmPluginReconciler) pruneRemoved(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, desired map[string]bool) error {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(wasmPluginGVK.GroupVersion().WithKind("WasmPluginList"))
+	if err := c.List(ctx, list, client.InNamespace(iop.Namespace), RevisionSelector(iop)); err != nil {
+		return fmt.Errorf("listing WasmPlugins: %w", err)
+	}
+
+	for i := range list.Items {
+		plugin := &list.Items[i]
+		if desired[plugin.GetName()] {
+			continue
+		}
+		if err := c.Delete(ctx, plugin); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting WasmPlugin %s: %w", plugin.GetName(), err)
+		}
+	}
+	return nil
+}
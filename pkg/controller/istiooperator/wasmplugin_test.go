@@ -0,0 +1,170 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiooperator
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// newFakeWasmPluginClient builds on newFakeClientWithObjects's scheme,
+// adding wasmPluginGVK the way newFakeTelemetryClient does for Telemetry,
+// since it isn't registered by default.
+func newFakeWasmPluginClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(wasmPluginGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(wasmPluginGVK.GroupVersion().WithKind("WasmPluginList"), &unstructured.UnstructuredList{})
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestWasmPluginReconcilerCreatesPluginWithPullSecret(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			WasmPlugins: []v1alpha1.WasmPluginSpec{
+				{
+					Name:       "header-injector",
+					URL:        "oci://registry.example.com/plugins/header-injector:1.0",
+					PullSecret: corev1.LocalObjectReference{Name: "registry-creds"},
+				},
+			},
+		},
+	}
+	c := newFakeWasmPluginClient(t)
+
+	r := &WasmPluginReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	plugin := &unstructured.Unstructured{}
+	plugin.SetGroupVersionKind(wasmPluginGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "header-injector"}, plugin); err != nil {
+		t.Fatalf("Get() WasmPlugin = %v", err)
+	}
+
+	url, _, _ := unstructured.NestedString(plugin.Object, "spec", "url")
+	if url != "oci://registry.example.com/plugins/header-injector:1.0" {
+		t.Fatalf("spec.url = %q, want the configured OCI URL", url)
+	}
+	secret, _, _ := unstructured.NestedString(plugin.Object, "spec", "imagePullSecret")
+	if secret != "registry-creds" {
+		t.Fatalf("spec.imagePullSecret = %q, want registry-creds", secret)
+	}
+	if len(plugin.GetOwnerReferences()) != 1 || plugin.GetOwnerReferences()[0].Name != "default" {
+		t.Fatalf("OwnerReferences = %+v, want one owner reference to the IstioOperator", plugin.GetOwnerReferences())
+	}
+}
+
+func TestWasmPluginReconcilerOmitsPullSecretWhenUnset(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			WasmPlugins: []v1alpha1.WasmPluginSpec{
+				{Name: "header-injector", URL: "oci://registry.example.com/plugins/header-injector:1.0"},
+			},
+		},
+	}
+	c := newFakeWasmPluginClient(t)
+
+	r := &WasmPluginReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	plugin := &unstructured.Unstructured{}
+	plugin.SetGroupVersionKind(wasmPluginGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "header-injector"}, plugin); err != nil {
+		t.Fatalf("Get() WasmPlugin = %v", err)
+	}
+	if _, ok, _ := unstructured.NestedString(plugin.Object, "spec", "imagePullSecret"); ok {
+		t.Fatalf("spec.imagePullSecret present, want it omitted when PullSecret is unset")
+	}
+}
+
+func TestWasmPluginReconcilerDeletesPluginWhenEntryRemoved(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "default"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			WasmPlugins: []v1alpha1.WasmPluginSpec{
+				{Name: "header-injector", URL: "oci://registry.example.com/plugins/header-injector:1.0"},
+			},
+		},
+	}
+	c := newFakeWasmPluginClient(t)
+
+	r := &WasmPluginReconciler{}
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	iop.Spec.WasmPlugins = nil
+	if err := r.Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	plugin := &unstructured.Unstructured{}
+	plugin.SetGroupVersionKind(wasmPluginGVK)
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "header-injector"}, plugin)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() WasmPlugin = %v, want a not-found error once the entry is removed", err)
+	}
+}
+
+func TestWasmPluginReconcilerLeavesOtherRevisionsAlone(t *testing.T) {
+	stable := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "stable"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Revision:    "stable",
+			WasmPlugins: []v1alpha1.WasmPluginSpec{{Name: "header-injector", URL: "oci://example.com/a:1"}},
+		},
+	}
+	canary := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "canary"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			Revision:    "canary",
+			WasmPlugins: []v1alpha1.WasmPluginSpec{{Name: "header-injector", URL: "oci://example.com/b:2"}},
+		},
+	}
+	c := newFakeWasmPluginClient(t)
+
+	r := &WasmPluginReconciler{}
+	if err := r.Reconcile(context.Background(), c, stable); err != nil {
+		t.Fatalf("Reconcile(stable) = %v", err)
+	}
+	if err := r.Reconcile(context.Background(), c, canary); err != nil {
+		t.Fatalf("Reconcile(canary) = %v", err)
+	}
+
+	stablePlugin := &unstructured.Unstructured{}
+	stablePlugin.SetGroupVersionKind(wasmPluginGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "istio-system", Name: "header-injector-stable"}, stablePlugin); err != nil {
+		t.Fatalf("Get() stable WasmPlugin = %v, want canary's reconcile to leave it alone", err)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package networkpolicy keeps the NetworkPolicies that let istiod reach
+// mesh sidecars in sync with an IstioOperator's watched namespaces, so a
+// cluster's default-deny NetworkPolicies don't silently break sidecar
+// injection and xDS delivery.
+package networkpolicy
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// policyName is the NetworkPolicy every watched namespace gets; one per
+// namespace is enough since it only ever needs the one rule below.
+const policyName = "allow-istiod"
+
+// istioSystemNamespace is where istiod runs and the namespace
+// istiodIngressPorts' ingress rule is scoped to. Istio itself doesn't
+// support installing istiod anywhere else, so this isn't made configurable.
+const istioSystemNamespace = "istio-system"
+
+// istiodIngressPorts are the ports a default-deny NetworkPolicy most
+// commonly blocks that istiod needs open on every sidecar's namespace:
+// 15010 (xDS, plaintext, used by some debug/compat paths) and 15012 (xDS
+// over mTLS, the port sidecars normally use).
+var istiodIngressPorts = []int32{15010, 15012}
+
+// ownerNamespaceLabel and ownerNameLabel identify which IstioOperator a
+// NetworkPolicy was created for. NetworkPolicies this package manages live
+// in whatever namespace they protect, almost never iop.Namespace, and
+// Kubernetes doesn't run garbage collection across an owner reference that
+// crosses namespaces — so these labels, not an OwnerReference, are what
+// Prune uses to find every policy an IstioOperator owns.
+const (
+	ownerNamespaceLabel = "istiooperator.istio.io/owner-namespace"
+	ownerNameLabel      = "istiooperator.istio.io/owner-name"
+)
+
+// Reconcile creates or updates, in every namespace matching
+// iop.Spec.WatchNamespaceSelector, a NetworkPolicy permitting ingress from
+// istioSystemNamespace on istiod's xDS ports. iop.Namespace itself is
+// skipped when it matches the selector — a real-world
+// WatchNamespaceSelector only ever matches workload namespaces, and istiod
+// doesn't need a rule admitting traffic to itself.
+func Reconcile(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if err := controller.EnsureSSAMigrated(ctx, c, iop); err != nil {
+		return fmt.Errorf("recording server-side apply migration: %w", err)
+	}
+
+	namespaces, err := matchingNamespaces(ctx, c, iop.Spec.WatchNamespaceSelector)
+	if err != nil {
+		return fmt.Errorf("listing namespaces matching watch selector: %w", err)
+	}
+
+	for _, ns := range namespaces {
+		if ns == iop.Namespace {
+			continue
+		}
+		if err := reconcilePolicy(ctx, c, iop, ns); err != nil {
+			return fmt.Errorf("reconciling NetworkPolicy in namespace %s: %w", ns, err)
+		}
+	}
+	return nil
+}
+
+// Prune deletes every NetworkPolicy labeled as owned by iop, across every
+// namespace, regardless of whether that namespace still matches
+// iop.Spec.WatchNamespaceSelector. Call this from iop's deletion path (e.g.
+// a finalizer) before letting the delete go through: the per-namespace
+// policies Reconcile creates can't carry a real OwnerReference back to iop,
+// so nothing else cleans them up.
+func Prune(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	var policies networkingv1.NetworkPolicyList
+	if err := c.List(ctx, &policies, client.MatchingLabels{
+		ownerNamespaceLabel: iop.Namespace,
+		ownerNameLabel:      iop.Name,
+	}); err != nil {
+		return fmt.Errorf("listing NetworkPolicies owned by IstioOperator %s/%s: %w", iop.Namespace, iop.Name, err)
+	}
+
+	for i := range policies.Items {
+		p := &policies.Items[i]
+		if err := c.Delete(ctx, p); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting NetworkPolicy %s/%s: %w", p.Namespace, p.Name, err)
+		}
+	}
+	return nil
+}
+
+// matchingNamespaces lists the names of every namespace selector matches.
+// A nil selector matches nothing: WatchNamespaceSelector must be set
+// explicitly rather than defaulting to "every namespace", since Reconcile
+// creating NetworkPolicies cluster-wide by default would be a surprising
+// amount of blast radius for an unset field.
+func matchingNamespaces(ctx context.Context, c client.Client, selector *metav1.LabelSelector) ([]string, error) {
+	if selector == nil {
+		return nil, nil
+	}
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("parsing watch namespace selector: %w", err)
+	}
+
+	var namespaces corev1.NamespaceList
+	if err := c.List(ctx, &namespaces, &client.ListOptions{LabelSelector: sel}); err != nil {
+		return nil, fmt.Errorf("listing namespaces: %w", err)
+	}
+
+	names := make([]string, 0, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		names = append(names, ns.Name)
+	}
+	return names, nil
+}
+
+// reconcilePolicy server-side applies the allow-istiod NetworkPolicy in ns,
+// owned by iop via the ownerNamespaceLabel/ownerNameLabel pair. Using
+// client.Apply under controller.FieldOwner, rather than a get-then-
+// create-or-update, means this operator only ever owns the fields it sets
+// here; client.ForceOwnership reclaims any of them still held by an older,
+// pre-SSA reconcile's field manager after an upgrade. An OwnerReference to
+// iop is also set, purely as a best-effort courtesy, when ns equals
+// iop.Namespace — Kubernetes silently ignores an OwnerReference whose
+// namespaced owner lives outside the dependent's own namespace, so it would
+// do nothing for any other ns.
+func reconcilePolicy(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator, ns string) error {
+	tcp := corev1.ProtocolTCP
+	ports := make([]networkingv1.NetworkPolicyPort, 0, len(istiodIngressPorts))
+	for _, port := range istiodIngressPorts {
+		p := intstr.FromInt(int(port))
+		ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &p})
+	}
+
+	desired := &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      policyName,
+			Namespace: ns,
+			Labels: map[string]string{
+				ownerNamespaceLabel: iop.Namespace,
+				ownerNameLabel:      iop.Name,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							NamespaceSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{corev1.LabelMetadataName: istioSystemNamespace},
+							},
+						},
+					},
+					Ports: ports,
+				},
+			},
+		},
+	}
+	if ns == iop.Namespace {
+		blockOwnerDeletion := true
+		controller := true
+		desired.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion:         v1alpha1.SchemeGroupVersion.String(),
+				Kind:               "IstioOperator",
+				Name:               iop.Name,
+				UID:                iop.UID,
+				Controller:         &controller,
+				BlockOwnerDeletion: &blockOwnerDeletion,
+			},
+		}
+	}
+
+	if err := c.Patch(ctx, desired, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying NetworkPolicy %s: %w", policyName, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networkpolicy
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := networkingv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestReconcileCreatesPolicyInEachMatchingNamespace(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			WatchNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"istio-injection": "enabled"}},
+		},
+	}
+	shop := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"istio-injection": "enabled"}}}
+	other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	c := newFakeClient(t, iop, shop, other)
+
+	if err := Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	policy := &networkingv1.NetworkPolicy{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "shop", Name: policyName}, policy); err != nil {
+		t.Fatalf("getting NetworkPolicy in matching namespace: %v", err)
+	}
+	if policy.Labels[ownerNamespaceLabel] != iop.Namespace || policy.Labels[ownerNameLabel] != iop.Name {
+		t.Fatalf("policy labels = %v, want owner %s/%s", policy.Labels, iop.Namespace, iop.Name)
+	}
+
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "other", Name: policyName}, &networkingv1.NetworkPolicy{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("NetworkPolicy in non-matching namespace: err = %v, want NotFound", err)
+	}
+}
+
+func TestReconcileIsIdempotent(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+		Spec: v1alpha1.IstioOperatorSpec{
+			WatchNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"istio-injection": "enabled"}},
+		},
+	}
+	shop := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"istio-injection": "enabled"}}}
+	c := newFakeClient(t, iop, shop)
+
+	if err := Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() #1 = %v", err)
+	}
+	if err := Reconcile(context.Background(), c, iop); err != nil {
+		t.Fatalf("Reconcile() #2 = %v", err)
+	}
+}
+
+func TestPruneDeletesOnlyOwnedPolicies(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example"},
+	}
+	owned := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "shop",
+			Name:      policyName,
+			Labels:    map[string]string{ownerNamespaceLabel: "istio-system", ownerNameLabel: "example"},
+		},
+	}
+	unrelated := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "keep-me"},
+	}
+	c := newFakeClient(t, iop, owned, unrelated)
+
+	if err := Prune(context.Background(), c, iop); err != nil {
+		t.Fatalf("Prune() = %v", err)
+	}
+
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(owned), &networkingv1.NetworkPolicy{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("owned policy: err = %v, want NotFound", err)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(unrelated), &networkingv1.NetworkPolicy{}); err != nil {
+		t.Fatalf("unrelated policy should survive Prune: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package controller holds reconciliation helpers that need to look across
+// every IstioOperator CR in the cluster at once, rather than a single one,
+// starting with Prune.
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+var log = logf.Log.WithName("controller")
+
+// managedByLabel and managedByValue mark every Deployment and Service an
+// IstioOperator reconcile creates for one of its components, the same way
+// any resource following the recommended Kubernetes labels
+// (https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/)
+// would. Prune only ever considers resources carrying this pair, so it
+// can't delete anything this operator didn't create in the first place.
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "istio-operator"
+)
+
+// componentLabel records which IstioOperatorSpec.Components entry a
+// Deployment or Service belongs to, using the same recommended label
+// managedByLabel does.
+const componentLabel = "app.kubernetes.io/component"
+
+// ownerUIDLabel records the UID of the IstioOperator CR a Deployment or
+// Service was created for. A real OwnerReference would do this natively,
+// but — as with networkpolicy's NetworkPolicies — nothing guarantees a
+// component's resources live in their owning IstioOperator's namespace, and
+// Kubernetes garbage collection ignores an OwnerReference that crosses
+// namespaces, so Prune reads this label instead of relying on one.
+const ownerUIDLabel = "istiooperator.istio.io/owner-uid"
+
+// resourcesPrunedTotal counts Deployments and Services Prune has deleted,
+// so a steady climb in this counter across many reconciles — rather than
+// one burst right after a user edits Components — would flag a bug letting
+// something keep recreating a resource Prune just removed.
+var resourcesPrunedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "resources_pruned_total",
+	Help: "Number of Deployments and Services deleted by Prune because their owning IstioOperator no longer exists or no longer lists their component.",
+})
+
+func init() {
+	prometheus.MustRegister(resourcesPrunedTotal)
+}
+
+// Prune is meant to run after each successful IstioOperator reconcile. It
+// lists every Deployment and Service labeled managedByLabel=managedByValue
+// across the cluster and deletes any whose ownerUIDLabel doesn't match a
+// currently live IstioOperator's UID, or whose componentLabel names a
+// component no longer present in that IstioOperator's Spec.Components —
+// e.g. a user removing "istio-ingressgateway" from Components otherwise
+// leaves its Deployment and Service running forever, since nothing else in
+// this tree reconciles a removed component away.
+func Prune(ctx context.Context, c client.Client) error {
+	live, err := liveComponentsByUID(ctx, c)
+	if err != nil {
+		return fmt.Errorf("listing live IstioOperators: %w", err)
+	}
+
+	if err := pruneDeployments(ctx, c, live); err != nil {
+		return err
+	}
+	return pruneServices(ctx, c, live)
+}
+
+// liveComponentsByUID maps every currently live IstioOperator's UID to the
+// set of component names in its Spec.Components.
+func liveComponentsByUID(ctx context.Context, c client.Client) (map[types.UID]map[string]bool, error) {
+	var iops v1alpha1.IstioOperatorList
+	if err := c.List(ctx, &iops); err != nil {
+		return nil, err
+	}
+
+	live := make(map[types.UID]map[string]bool, len(iops.Items))
+	for i := range iops.Items {
+		iop := &iops.Items[i]
+		components := make(map[string]bool, len(iop.Spec.Components))
+		for _, name := range iop.Spec.Components {
+			components[name] = true
+		}
+		live[iop.UID] = components
+	}
+	return live, nil
+}
+
+// shouldPrune reports whether a resource carrying labels should be deleted:
+// either its owner isn't in live at all (the IstioOperator that created it
+// is gone), or its owner is live but no longer lists its component.
+func shouldPrune(labels map[string]string, live map[types.UID]map[string]bool) bool {
+	components, ok := live[types.UID(labels[ownerUIDLabel])]
+	if !ok {
+		return true
+	}
+	return !components[labels[componentLabel]]
+}
+
+func pruneDeployments(ctx context.Context, c client.Client, live map[types.UID]map[string]bool) error {
+	var deployments appsv1.DeploymentList
+	if err := c.List(ctx, &deployments, client.MatchingLabels{managedByLabel: managedByValue}); err != nil {
+		return fmt.Errorf("listing managed Deployments: %w", err)
+	}
+
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		if !shouldPrune(d.Labels, live) {
+			continue
+		}
+		if err := c.Delete(ctx, d); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("pruning Deployment %s/%s: %w", d.Namespace, d.Name, err)
+		}
+		log.Info("Pruned orphaned Deployment", "namespace", d.Namespace, "name", d.Name, "component", d.Labels[componentLabel])
+		resourcesPrunedTotal.Inc()
+	}
+	return nil
+}
+
+func pruneServices(ctx context.Context, c client.Client, live map[types.UID]map[string]bool) error {
+	var services corev1.ServiceList
+	if err := c.List(ctx, &services, client.MatchingLabels{managedByLabel: managedByValue}); err != nil {
+		return fmt.Errorf("listing managed Services: %w", err)
+	}
+
+	for i := range services.Items {
+		s := &services.Items[i]
+		if !shouldPrune(s.Labels, live) {
+			continue
+		}
+		if err := c.Delete(ctx, s); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("pruning Service %s/%s: %w", s.Namespace, s.Name, err)
+		}
+		log.Info("Pruned orphaned Service", "namespace", s.Namespace, "name", s.Name, "component", s.Labels[componentLabel])
+		resourcesPrunedTotal.Inc()
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func managedDeployment(name, namespace string, ownerUID types.UID, component string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				managedByLabel: managedByValue,
+				componentLabel: component,
+				ownerUIDLabel:  string(ownerUID),
+			},
+		},
+	}
+}
+
+func managedService(name, namespace string, ownerUID types.UID, component string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				managedByLabel: managedByValue,
+				componentLabel: component,
+				ownerUIDLabel:  string(ownerUID),
+			},
+		},
+	}
+}
+
+func TestPruneDeletesResourcesOfAComponentRemovedFromSpec(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: types.UID("iop-uid")},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}},
+	}
+	removed := managedDeployment("istio-ingressgateway", "istio-system", iop.UID, "istio-ingressgateway")
+	kept := managedDeployment("istiod", "istio-system", iop.UID, "istiod")
+	c := newFakeClient(t, iop, removed, kept)
+	before := resourcesPrunedTotalValue(t)
+
+	if err := Prune(context.Background(), c); err != nil {
+		t.Fatalf("Prune() = %v", err)
+	}
+
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(removed), &appsv1.Deployment{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() on removed component's Deployment = %v, want NotFound", err)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(kept), &appsv1.Deployment{}); err != nil {
+		t.Fatalf("Get() on still-listed component's Deployment = %v, want it untouched", err)
+	}
+	if after := resourcesPrunedTotalValue(t); after != before+1 {
+		t.Fatalf("resourcesPrunedTotal = %v, want %v", after, before+1)
+	}
+}
+
+func TestPruneDeletesResourcesOfADeletedIstioOperator(t *testing.T) {
+	orphaned := managedDeployment("istiod", "istio-system", types.UID("gone"), "istiod")
+	c := newFakeClient(t, orphaned)
+
+	if err := Prune(context.Background(), c); err != nil {
+		t.Fatalf("Prune() = %v", err)
+	}
+
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(orphaned), &appsv1.Deployment{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() on orphaned Deployment = %v, want NotFound", err)
+	}
+}
+
+func TestPruneLeavesUnmanagedResourcesAlone(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: types.UID("iop-uid")},
+	}
+	unmanaged := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"}}
+	c := newFakeClient(t, iop, unmanaged)
+
+	if err := Prune(context.Background(), c); err != nil {
+		t.Fatalf("Prune() = %v", err)
+	}
+
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(unmanaged), &appsv1.Deployment{}); err != nil {
+		t.Fatalf("Get() on unmanaged Deployment = %v, want it untouched", err)
+	}
+}
+
+func TestPruneAppliesSameRulesToServices(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "example", UID: types.UID("iop-uid")},
+		Spec:       v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}},
+	}
+	removed := managedService("istio-ingressgateway", "istio-system", iop.UID, "istio-ingressgateway")
+	kept := managedService("istiod", "istio-system", iop.UID, "istiod")
+	c := newFakeClient(t, iop, removed, kept)
+
+	if err := Prune(context.Background(), c); err != nil {
+		t.Fatalf("Prune() = %v", err)
+	}
+
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(removed), &corev1.Service{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() on removed component's Service = %v, want NotFound", err)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(kept), &corev1.Service{}); err != nil {
+		t.Fatalf("Get() on still-listed component's Service = %v, want it untouched", err)
+	}
+}
+
+func resourcesPrunedTotalValue(t *testing.T) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := resourcesPrunedTotal.Write(&m); err != nil {
+		t.Fatalf("resourcesPrunedTotal.Write() = %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
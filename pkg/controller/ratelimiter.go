@@ -0,0 +1,80 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// defaultRateLimiterBaseDelay, defaultRateLimiterMaxDelay,
+// defaultRateLimiterQPS, and defaultRateLimiterBurst match
+// workqueue.DefaultControllerRateLimiter's own defaults, so
+// RateLimiterOptions{} behaves exactly like never setting
+// controller.Options.RateLimiter at all.
+const (
+	defaultRateLimiterBaseDelay = 5 * time.Millisecond
+	defaultRateLimiterMaxDelay  = 1000 * time.Second
+	defaultRateLimiterQPS       = 10
+	defaultRateLimiterBurst     = 100
+)
+
+// RateLimiterOptions configures the workqueue.RateLimiter NewRateLimiter
+// builds for controller.Options.RateLimiter, so a large cluster's reconcile
+// requeues don't all retry on the same schedule and pile up against the API
+// server at once.
+type RateLimiterOptions struct {
+	// BaseDelay is the delay before the first retry of an item that failed
+	// to reconcile. Doubles on every subsequent failure of that same item,
+	// up to MaxDelay. Zero uses defaultRateLimiterBaseDelay.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the exponential backoff between retries of the same
+	// item. Zero uses defaultRateLimiterMaxDelay.
+	MaxDelay time.Duration
+
+	// QPS caps the overall rate, across every item, at which the queue
+	// releases work, smoothing out a thundering herd of simultaneously
+	// queued reconciles rather than letting them all through at once. Zero
+	// uses defaultRateLimiterQPS.
+	QPS int
+}
+
+// NewRateLimiter builds the workqueue.RateLimiter opts describes: per-item
+// exponential backoff bounded by BaseDelay/MaxDelay, combined with an
+// overall QPS token bucket, the same two-limiter shape
+// workqueue.DefaultControllerRateLimiter uses. A reconciled item waits for
+// whichever of the two limiters currently asks for the longer delay.
+func NewRateLimiter(opts RateLimiterOptions) workqueue.RateLimiter {
+	baseDelay := opts.BaseDelay
+	if baseDelay == 0 {
+		baseDelay = defaultRateLimiterBaseDelay
+	}
+	maxDelay := opts.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = defaultRateLimiterMaxDelay
+	}
+	qps := opts.QPS
+	if qps == 0 {
+		qps = defaultRateLimiterQPS
+	}
+
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), defaultRateLimiterBurst)},
+	)
+}
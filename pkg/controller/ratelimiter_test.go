@@ -0,0 +1,48 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiterUsesDefaultsWhenOptionsAreZero(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterOptions{})
+
+	if got, want := limiter.When("item"), defaultRateLimiterBaseDelay; got != want {
+		t.Fatalf("first When() delay = %v, want the default base delay %v", got, want)
+	}
+}
+
+func TestNewRateLimiterAppliesCustomBaseDelay(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterOptions{BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second})
+
+	if got, want := limiter.When("item"), 50*time.Millisecond; got != want {
+		t.Fatalf("first When() delay = %v, want the configured base delay %v", got, want)
+	}
+}
+
+func TestNewRateLimiterBackoffIsBoundedByMaxDelay(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterOptions{BaseDelay: time.Second, MaxDelay: 2 * time.Second})
+
+	var last time.Duration
+	for i := 0; i < 5; i++ {
+		last = limiter.When("item")
+	}
+	if last > 2*time.Second {
+		t.Fatalf("When() delay after repeated failures = %v, want it capped at MaxDelay (2s)", last)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// FieldOwner is the field manager name every server-side apply mutation
+// this operator makes is submitted under, so the API server can tell which
+// fields on a shared object — e.g. an HPA-managed Deployment's replica
+// count, or a PDB another controller also writes to — this operator owns,
+// rather than a client.Update or client.Patch(MergeFrom) silently stomping
+// whatever that other controller last set.
+const FieldOwner = "istio-operator"
+
+// SSAMigratedAnnotation is set on an IstioOperator the first time
+// EnsureSSAMigrated runs for it, recording when this operator's reconcilers
+// switched that CR's managed resources over to server-side apply.
+// Reconcilers pass client.ForceOwnership on every SSA apply regardless of
+// this annotation's presence, so its only purpose is as a visible marker of
+// when the migration happened, for anyone debugging an unexpected field
+// ownership conflict from around that time.
+const SSAMigratedAnnotation = "operator.istio.io/ssa-migrated-at"
+
+// EnsureSSAMigrated records SSAMigratedAnnotation on iop, with the current
+// time, the first time it's called for that CR; later calls are a no-op.
+// Reconcilers are expected to call this once near the start of a reconcile
+// pass, before making any SSA apply of a resource that may still carry
+// fields from before this operator adopted server-side apply.
+func EnsureSSAMigrated(ctx context.Context, c client.Client, iop *v1alpha1.IstioOperator) error {
+	if _, ok := iop.Annotations[SSAMigratedAnnotation]; ok {
+		return nil
+	}
+
+	before := iop.DeepCopy()
+	if iop.Annotations == nil {
+		iop.Annotations = map[string]string{}
+	}
+	iop.Annotations[SSAMigratedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if err := c.Patch(ctx, iop, client.MergeFrom(before)); err != nil {
+		return fmt.Errorf("recording SSA migration on IstioOperator %s/%s: %w", iop.Namespace, iop.Name, err)
+	}
+	return nil
+}
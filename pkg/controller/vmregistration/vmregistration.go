@@ -0,0 +1,188 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vmregistration turns VMRegistration CRs, created from VM
+// registration requests a webhook endpoint accepts (see
+// RegistrationHandler), into Istio WorkloadEntries, and removes those
+// WorkloadEntries again once a VM stops responding to health checks.
+package vmregistration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// workloadEntryGVK identifies the WorkloadEntry resource Reconciler
+// manages. It isn't vendored as a typed Go API in this repo, so it's
+// handled as unstructured.Unstructured the same way
+// istiooperator.TelemetryReconciler handles Telemetry.
+var workloadEntryGVK = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "WorkloadEntry"}
+
+// healthCheckTimeout bounds how long CheckHealth waits for reg.Spec.HealthCheckURL
+// to respond before treating the VM as unreachable.
+const healthCheckTimeout = 5 * time.Second
+
+// vmRegisteredTotal and vmDeregisteredTotal count WorkloadEntries
+// Reconcile has created and CheckAndDeregister has deleted, so a mesh
+// operator can tell from metrics alone whether VMs are churning through
+// registration/deregistration faster than expected.
+var (
+	vmRegisteredTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "vm_registered_total",
+		Help: "Number of WorkloadEntries created for a VMRegistration.",
+	})
+	vmDeregisteredTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "vm_deregistered_total",
+		Help: "Number of WorkloadEntries deleted after their VMRegistration's VM exceeded its UnhealthyThreshold.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(vmRegisteredTotal, vmDeregisteredTotal)
+}
+
+// Reconciler creates and removes the WorkloadEntry behind a VMRegistration.
+type Reconciler struct {
+	// HTTPClient is used by CheckAndDeregister to poll a VM's
+	// HealthCheckURL. Defaults to http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// Reconcile creates or updates a WorkloadEntry named reg.Name in
+// reg.Namespace from reg.Spec, owned by reg so deleting the VMRegistration
+// also deletes its WorkloadEntry, and sets reg.Status.WorkloadEntryCreated.
+func (r *Reconciler) Reconcile(ctx context.Context, c client.Client, reg *v1alpha1.VMRegistration) error {
+	blockOwnerDeletion := true
+	isController := true
+
+	entry := &unstructured.Unstructured{}
+	entry.SetGroupVersionKind(workloadEntryGVK)
+	entry.SetNamespace(reg.Namespace)
+	entry.SetName(reg.Name)
+	entry.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion:         v1alpha1.SchemeGroupVersion.String(),
+			Kind:               "VMRegistration",
+			Name:               reg.Name,
+			UID:                reg.UID,
+			Controller:         &isController,
+			BlockOwnerDeletion: &blockOwnerDeletion,
+		},
+	})
+	entry.Object["spec"] = map[string]interface{}{
+		"address":        reg.Spec.Address,
+		"serviceAccount": reg.Spec.ServiceAccount,
+		"labels":         toInterfaceMap(reg.Spec.Labels),
+	}
+
+	if err := c.Patch(ctx, entry, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying WorkloadEntry %s/%s: %w", reg.Namespace, reg.Name, err)
+	}
+
+	if !reg.Status.WorkloadEntryCreated {
+		vmRegisteredTotal.Inc()
+		before := reg.DeepCopy()
+		reg.Status.WorkloadEntryCreated = true
+		if err := c.Status().Patch(ctx, reg, client.MergeFrom(before)); err != nil {
+			return fmt.Errorf("updating VMRegistration %s/%s status: %w", reg.Namespace, reg.Name, err)
+		}
+	}
+	return nil
+}
+
+// CheckAndDeregister polls reg.Spec.HealthCheckURL. A healthy response
+// updates reg.Status.LastHealthyTime; an unhealthy one deletes the
+// WorkloadEntry Reconcile created once reg.Status.LastHealthyTime is more
+// than reg.Spec.UnhealthyThreshold in the past, or immediately if the VM
+// has never been observed healthy at all.
+func (r *Reconciler) CheckAndDeregister(ctx context.Context, c client.Client, reg *v1alpha1.VMRegistration) error {
+	if r.checkHealth(ctx, reg.Spec.HealthCheckURL) {
+		before := reg.DeepCopy()
+		reg.Status.LastHealthyTime = metav1.Now()
+		if err := c.Status().Patch(ctx, reg, client.MergeFrom(before)); err != nil {
+			return fmt.Errorf("updating VMRegistration %s/%s status: %w", reg.Namespace, reg.Name, err)
+		}
+		return nil
+	}
+
+	if !reg.Status.LastHealthyTime.IsZero() && time.Since(reg.Status.LastHealthyTime.Time) < reg.Spec.UnhealthyThreshold.Duration {
+		return nil
+	}
+
+	entry := &unstructured.Unstructured{}
+	entry.SetGroupVersionKind(workloadEntryGVK)
+	entry.SetNamespace(reg.Namespace)
+	entry.SetName(reg.Name)
+	if err := c.Delete(ctx, entry); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting WorkloadEntry %s/%s: %w", reg.Namespace, reg.Name, err)
+	}
+
+	if reg.Status.WorkloadEntryCreated {
+		vmDeregisteredTotal.Inc()
+		before := reg.DeepCopy()
+		reg.Status.WorkloadEntryCreated = false
+		if err := c.Status().Patch(ctx, reg, client.MergeFrom(before)); err != nil {
+			return fmt.Errorf("updating VMRegistration %s/%s status: %w", reg.Namespace, reg.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkHealth reports whether a GET against healthCheckURL returns a 2xx
+// status within healthCheckTimeout. An empty healthCheckURL, a request
+// that errors, or a non-2xx response all count as unhealthy.
+func (r *Reconciler) checkHealth(ctx context.Context, healthCheckURL string) bool {
+	if healthCheckURL == "" {
+		return false
+	}
+	httpClient := r.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthCheckURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// toInterfaceMap converts m to the map[string]interface{} shape
+// unstructured.Unstructured requires for a nested object field.
+func toInterfaceMap(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
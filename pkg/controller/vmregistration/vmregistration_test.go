@@ -0,0 +1,250 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmregistration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// newFakeVMRegistrationClient builds on the v1alpha1 scheme, adding
+// workloadEntryGVK the way istiooperator's newFakeTelemetryClient does for
+// telemetryGVK, since WorkloadEntry isn't registered by default either.
+func newFakeVMRegistrationClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(workloadEntryGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(workloadEntryGVK.GroupVersion().WithKind("WorkloadEntryList"), &unstructured.UnstructuredList{})
+
+	var regs []client.Object
+	for _, o := range objs {
+		if _, ok := o.(*v1alpha1.VMRegistration); ok {
+			regs = append(regs, o)
+		}
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(regs...).Build()
+}
+
+func TestReconcilerReconcileCreatesWorkloadEntry(t *testing.T) {
+	reg := &v1alpha1.VMRegistration{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "vm-namespace", Name: "vm-1", UID: "vm-1-uid"},
+		Spec: v1alpha1.VMRegistrationSpec{
+			Address:        "10.0.0.5",
+			ServiceAccount: "vm-sa",
+			Labels:         map[string]string{"app": "legacy-db"},
+		},
+	}
+	c := newFakeVMRegistrationClient(t, reg)
+
+	r := &Reconciler{}
+	if err := r.Reconcile(context.Background(), c, reg); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	entry := &unstructured.Unstructured{}
+	entry.SetGroupVersionKind(workloadEntryGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "vm-namespace", Name: "vm-1"}, entry); err != nil {
+		t.Fatalf("Get() WorkloadEntry = %v", err)
+	}
+
+	address, _, _ := unstructured.NestedString(entry.Object, "spec", "address")
+	if address != "10.0.0.5" {
+		t.Fatalf("spec.address = %q, want 10.0.0.5", address)
+	}
+	owners := entry.GetOwnerReferences()
+	if len(owners) != 1 || owners[0].Name != "vm-1" || owners[0].UID != "vm-1-uid" {
+		t.Fatalf("OwnerReferences = %v, want a single owner referencing vm-1", owners)
+	}
+
+	updated := &v1alpha1.VMRegistration{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "vm-namespace", Name: "vm-1"}, updated); err != nil {
+		t.Fatalf("Get() VMRegistration = %v", err)
+	}
+	if !updated.Status.WorkloadEntryCreated {
+		t.Fatalf("Status.WorkloadEntryCreated = false, want true")
+	}
+}
+
+func TestCheckAndDeregisterHealthyUpdatesLastHealthyTime(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	reg := &v1alpha1.VMRegistration{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "vm-namespace", Name: "vm-1"},
+		Spec:       v1alpha1.VMRegistrationSpec{HealthCheckURL: server.URL},
+	}
+	c := newFakeVMRegistrationClient(t, reg)
+
+	r := &Reconciler{}
+	if err := r.CheckAndDeregister(context.Background(), c, reg); err != nil {
+		t.Fatalf("CheckAndDeregister() = %v", err)
+	}
+
+	updated := &v1alpha1.VMRegistration{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "vm-namespace", Name: "vm-1"}, updated); err != nil {
+		t.Fatalf("Get() VMRegistration = %v", err)
+	}
+	if updated.Status.LastHealthyTime.IsZero() {
+		t.Fatalf("Status.LastHealthyTime is zero, want set")
+	}
+}
+
+func TestCheckAndDeregisterUnhealthyWithinThresholdIsNoOp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	reg := &v1alpha1.VMRegistration{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "vm-namespace", Name: "vm-1"},
+		Spec: v1alpha1.VMRegistrationSpec{
+			HealthCheckURL:     server.URL,
+			UnhealthyThreshold: metav1.Duration{Duration: time.Hour},
+		},
+		Status: v1alpha1.VMRegistrationStatus{
+			LastHealthyTime:      metav1.Now(),
+			WorkloadEntryCreated: true,
+		},
+	}
+	c := newFakeVMRegistrationClient(t, reg)
+
+	entry := &unstructured.Unstructured{}
+	entry.SetGroupVersionKind(workloadEntryGVK)
+	entry.SetNamespace("vm-namespace")
+	entry.SetName("vm-1")
+	if err := c.Create(context.Background(), entry); err != nil {
+		t.Fatalf("Create() WorkloadEntry = %v", err)
+	}
+
+	r := &Reconciler{}
+	if err := r.CheckAndDeregister(context.Background(), c, reg); err != nil {
+		t.Fatalf("CheckAndDeregister() = %v", err)
+	}
+
+	got := &unstructured.Unstructured{}
+	got.SetGroupVersionKind(workloadEntryGVK)
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "vm-namespace", Name: "vm-1"}, got); err != nil {
+		t.Fatalf("Get() WorkloadEntry = %v, want it to still exist within UnhealthyThreshold", err)
+	}
+}
+
+func TestCheckAndDeregisterUnhealthyPastThresholdDeletesWorkloadEntry(t *testing.T) {
+	reg := &v1alpha1.VMRegistration{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "vm-namespace", Name: "vm-1"},
+		Spec: v1alpha1.VMRegistrationSpec{
+			HealthCheckURL:     "",
+			UnhealthyThreshold: metav1.Duration{Duration: time.Minute},
+		},
+		Status: v1alpha1.VMRegistrationStatus{
+			LastHealthyTime:      metav1.NewTime(time.Now().Add(-time.Hour)),
+			WorkloadEntryCreated: true,
+		},
+	}
+	c := newFakeVMRegistrationClient(t, reg)
+
+	entry := &unstructured.Unstructured{}
+	entry.SetGroupVersionKind(workloadEntryGVK)
+	entry.SetNamespace("vm-namespace")
+	entry.SetName("vm-1")
+	if err := c.Create(context.Background(), entry); err != nil {
+		t.Fatalf("Create() WorkloadEntry = %v", err)
+	}
+
+	r := &Reconciler{}
+	if err := r.CheckAndDeregister(context.Background(), c, reg); err != nil {
+		t.Fatalf("CheckAndDeregister() = %v", err)
+	}
+
+	got := &unstructured.Unstructured{}
+	got.SetGroupVersionKind(workloadEntryGVK)
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "vm-namespace", Name: "vm-1"}, got)
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() WorkloadEntry = %v, want a not-found error once UnhealthyThreshold is exceeded", err)
+	}
+
+	updated := &v1alpha1.VMRegistration{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "vm-namespace", Name: "vm-1"}, updated); err != nil {
+		t.Fatalf("Get() VMRegistration = %v", err)
+	}
+	if updated.Status.WorkloadEntryCreated {
+		t.Fatalf("Status.WorkloadEntryCreated = true, want false")
+	}
+}
+
+func TestRegistrationHandlerCreatesVMRegistration(t *testing.T) {
+	c := newFakeVMRegistrationClient(t)
+	h := &RegistrationHandler{Client: c}
+
+	body := `{"name":"vm-1","namespace":"vm-namespace","address":"10.0.0.5","serviceAccount":"vm-sa"}`
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+
+	reg := &v1alpha1.VMRegistration{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "vm-namespace", Name: "vm-1"}, reg); err != nil {
+		t.Fatalf("Get() VMRegistration = %v", err)
+	}
+	if reg.Spec.Address != "10.0.0.5" {
+		t.Fatalf("Spec.Address = %q, want 10.0.0.5", reg.Spec.Address)
+	}
+}
+
+func TestRegistrationHandlerRejectsMissingFields(t *testing.T) {
+	c := newFakeVMRegistrationClient(t)
+	h := &RegistrationHandler{Client: c}
+
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(`{"name":"vm-1"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRegistrationHandlerRejectsNonPOST(t *testing.T) {
+	c := newFakeVMRegistrationClient(t)
+	h := &RegistrationHandler{Client: c}
+
+	req := httptest.NewRequest(http.MethodGet, "/register", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
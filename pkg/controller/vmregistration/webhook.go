@@ -0,0 +1,107 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmregistration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// registrationRequest is the JSON body RegistrationHandler accepts from a
+// VM, not a Kubernetes admission request: a VM is a process outside the
+// cluster, not something kube-apiserver ever reviews, so this is a plain
+// HTTP endpoint rather than one of pkg/webhook's admission.Handlers.
+type registrationRequest struct {
+	Name               string            `json:"name"`
+	Namespace          string            `json:"namespace"`
+	Address            string            `json:"address"`
+	ServiceAccount     string            `json:"serviceAccount"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	HealthCheckURL     string            `json:"healthCheckURL,omitempty"`
+	UnhealthyThreshold metav1.Duration   `json:"unhealthyThreshold,omitempty"`
+}
+
+// RegistrationHandler accepts a VM's registration request and creates or
+// updates the VMRegistration CR Reconciler watches, so the VM's
+// WorkloadEntry gets created without anyone having to author the
+// VMRegistration by hand.
+type RegistrationHandler struct {
+	Client client.Client
+}
+
+// ServeHTTP decodes a registrationRequest from the request body and
+// applies the VMRegistration it describes. Any request method other than
+// POST, a body that doesn't decode as JSON, or a request missing
+// name/namespace/address/serviceAccount is rejected with 4xx before
+// touching the API server.
+func (h *RegistrationHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body registrationRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("decoding registration request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" || body.Namespace == "" || body.Address == "" || body.ServiceAccount == "" {
+		http.Error(w, "name, namespace, address, and serviceAccount are required", http.StatusBadRequest)
+		return
+	}
+
+	reg := &v1alpha1.VMRegistration{}
+	err := h.Client.Get(req.Context(), client.ObjectKey{Namespace: body.Namespace, Name: body.Name}, reg)
+	switch {
+	case apierrors.IsNotFound(err):
+		reg = &v1alpha1.VMRegistration{
+			ObjectMeta: metav1.ObjectMeta{Namespace: body.Namespace, Name: body.Name},
+			Spec: v1alpha1.VMRegistrationSpec{
+				Address:            body.Address,
+				ServiceAccount:     body.ServiceAccount,
+				Labels:             body.Labels,
+				HealthCheckURL:     body.HealthCheckURL,
+				UnhealthyThreshold: body.UnhealthyThreshold,
+			},
+		}
+		if err := h.Client.Create(req.Context(), reg); err != nil {
+			http.Error(w, fmt.Sprintf("creating VMRegistration: %v", err), http.StatusInternalServerError)
+			return
+		}
+	case err != nil:
+		http.Error(w, fmt.Sprintf("getting VMRegistration: %v", err), http.StatusInternalServerError)
+		return
+	default:
+		before := reg.DeepCopy()
+		reg.Spec.Address = body.Address
+		reg.Spec.ServiceAccount = body.ServiceAccount
+		reg.Spec.Labels = body.Labels
+		reg.Spec.HealthCheckURL = body.HealthCheckURL
+		reg.Spec.UnhealthyThreshold = body.UnhealthyThreshold
+		if err := h.Client.Patch(req.Context(), reg, client.MergeFrom(before)); err != nil {
+			http.Error(w, fmt.Sprintf("updating VMRegistration: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
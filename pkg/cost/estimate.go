@@ -0,0 +1,120 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cost estimates the monthly cloud spend an IstioOperator's
+// component resource requests add up to.
+package cost
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// hoursPerMonth approximates a month as 30 days, the same round figure most
+// cloud billing calculators use for a monthly estimate rather than the
+// actual (and variable) number of hours in a given calendar month.
+const hoursPerMonth = 24 * 30
+
+// PriceSheet gives the hourly USD price of one CPU core and one GiB of
+// memory requested. It replaces EstimateMonthlyCost's request-body-described
+// single nodeHourlyRate float64, which can't express CPU and memory having
+// different unit prices (the cost drivers FinOps teams actually care about
+// comparing); see LoadPriceSheet for loading one from a ConfigMap.
+type PriceSheet struct {
+	CPUCoreHourly   float64 `json:"cpuCoreHourly"`
+	MemoryGiBHourly float64 `json:"memoryGiBHourly"`
+}
+
+// CostEstimate is the monthly cost EstimateMonthlyCost projects for an
+// IstioOperatorSpec's component resource requests, split out by resource
+// alongside the combined Total.
+type CostEstimate struct {
+	CPU      float64
+	Memory   float64
+	Total    float64
+	Currency string
+}
+
+// EstimateMonthlyCost sums the CPU and memory requests spec.ComponentResources
+// declares for every component in spec.Components, falling back to
+// defaultResources' entry for any component with no requests of its own —
+// the same per-component default mechanism webhook.IstioOperatorDefaulter
+// injects at admission time, since this tree has no Helm chart whose values
+// a "Helm values" default could be read from — and prices the total at
+// prices' per-core and per-GiB hourly rates, projected across hoursPerMonth.
+// A component present in neither spec.ComponentResources nor
+// defaultResources contributes nothing to the estimate.
+func EstimateMonthlyCost(spec *v1alpha1.IstioOperatorSpec, defaultResources map[string]corev1.ResourceRequirements, prices PriceSheet) CostEstimate {
+	var cpu, memory resource.Quantity
+	for _, component := range spec.Components {
+		requests := spec.ComponentResources[component].Requests
+		if len(requests) == 0 {
+			requests = defaultResources[component].Requests
+		}
+		cpu.Add(*requests.Cpu())
+		memory.Add(*requests.Memory())
+	}
+
+	cpuCost := cpu.AsApproximateFloat64() * prices.CPUCoreHourly * hoursPerMonth
+	memoryCost := memory.AsApproximateFloat64() / (1024 * 1024 * 1024) * prices.MemoryGiBHourly * hoursPerMonth
+	return CostEstimate{
+		CPU:      cpuCost,
+		Memory:   memoryCost,
+		Total:    cpuCost + memoryCost,
+		Currency: "USD",
+	}
+}
+
+// LoadPriceSheet parses the "prices.json" key of a ConfigMap's Data into a
+// PriceSheet, mirroring how webhook.LoadDefaultResources parses that
+// package's ConfigMap-sourced defaults from a "resources.json" key.
+func LoadPriceSheet(data map[string]string) (PriceSheet, error) {
+	raw, ok := data["prices.json"]
+	if !ok {
+		return PriceSheet{}, fmt.Errorf(`price sheet ConfigMap has no "prices.json" key`)
+	}
+	var prices PriceSheet
+	if err := json.Unmarshal([]byte(raw), &prices); err != nil {
+		return PriceSheet{}, fmt.Errorf("parsing price sheet: %w", err)
+	}
+	return prices, nil
+}
+
+// estimatedMonthlyCostUSD exposes the most recent CostEstimate.Total Record
+// was called with, per IstioOperator, as "estimated_monthly_cost_usd" —
+// a Gauge rather than a Counter, since a cost estimate can fall as readily
+// as it can rise (a component's requests dropping, a price sheet update).
+var estimatedMonthlyCostUSD = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "estimated_monthly_cost_usd",
+		Help: "Estimated monthly cost, in USD, of the CPU and memory requests across an IstioOperator's enabled components.",
+	},
+	[]string{"namespace", "name"},
+)
+
+func init() {
+	prometheus.MustRegister(estimatedMonthlyCostUSD)
+}
+
+// Record sets estimated_monthly_cost_usd{namespace=iop.Namespace,name=iop.Name}
+// to estimate.Total.
+func Record(iop *v1alpha1.IstioOperator, estimate CostEstimate) {
+	estimatedMonthlyCostUSD.WithLabelValues(iop.Namespace, iop.Name).Set(estimate.Total)
+}
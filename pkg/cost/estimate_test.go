@@ -0,0 +1,101 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cost
+
+import (
+	"math"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func approxEqual(got, want float64) bool {
+	return math.Abs(got-want) < 0.001
+}
+
+func TestEstimateMonthlyCostSumsRequestsAcrossComponents(t *testing.T) {
+	spec := &v1alpha1.IstioOperatorSpec{
+		Components: []string{"istiod", "istio-ingressgateway"},
+		ComponentResources: map[string]corev1.ResourceRequirements{
+			"istiod":               {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}},
+			"istio-ingressgateway": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("512Mi")}},
+		},
+	}
+	prices := PriceSheet{CPUCoreHourly: 0.05, MemoryGiBHourly: 0.01}
+
+	got := EstimateMonthlyCost(spec, nil, prices)
+
+	wantCPUCost := 1.5 * 0.05 * hoursPerMonth
+	if !approxEqual(got.CPU, wantCPUCost) {
+		t.Errorf("CPU = %v, want %v", got.CPU, wantCPUCost)
+	}
+	wantMemoryCost := 1.5 * 0.01 * hoursPerMonth
+	if !approxEqual(got.Memory, wantMemoryCost) {
+		t.Errorf("Memory = %v, want %v", got.Memory, wantMemoryCost)
+	}
+	if !approxEqual(got.Total, got.CPU+got.Memory) {
+		t.Errorf("Total = %v, want CPU+Memory = %v", got.Total, got.CPU+got.Memory)
+	}
+	if got.Currency != "USD" {
+		t.Errorf("Currency = %q, want USD", got.Currency)
+	}
+}
+
+func TestEstimateMonthlyCostFallsBackToDefaultResources(t *testing.T) {
+	spec := &v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}}
+	defaults := map[string]corev1.ResourceRequirements{
+		"istiod": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}},
+	}
+	prices := PriceSheet{CPUCoreHourly: 0.05, MemoryGiBHourly: 0.01}
+
+	got := EstimateMonthlyCost(spec, defaults, prices)
+
+	wantCPUCost := 1 * 0.05 * hoursPerMonth
+	if !approxEqual(got.CPU, wantCPUCost) {
+		t.Errorf("CPU = %v, want %v (defaulted from defaultResources)", got.CPU, wantCPUCost)
+	}
+}
+
+func TestEstimateMonthlyCostIgnoresComponentsWithNoRequestsOrDefault(t *testing.T) {
+	spec := &v1alpha1.IstioOperatorSpec{Components: []string{"istiod"}}
+	got := EstimateMonthlyCost(spec, nil, PriceSheet{CPUCoreHourly: 0.05, MemoryGiBHourly: 0.01})
+
+	if got.Total != 0 {
+		t.Errorf("Total = %v, want 0 for a component with neither configured nor default requests", got.Total)
+	}
+}
+
+func TestLoadPriceSheet(t *testing.T) {
+	data := map[string]string{
+		"prices.json": `{"cpuCoreHourly":0.0475,"memoryGiBHourly":0.0063}`,
+	}
+	prices, err := LoadPriceSheet(data)
+	if err != nil {
+		t.Fatalf("LoadPriceSheet() = %v", err)
+	}
+	if prices.CPUCoreHourly != 0.0475 {
+		t.Errorf("CPUCoreHourly = %v, want 0.0475", prices.CPUCoreHourly)
+	}
+	if prices.MemoryGiBHourly != 0.0063 {
+		t.Errorf("MemoryGiBHourly = %v, want 0.0063", prices.MemoryGiBHourly)
+	}
+
+	if _, err := LoadPriceSheet(map[string]string{}); err == nil {
+		t.Fatal("LoadPriceSheet() with no prices.json key = nil error, want an error")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dryrun lets the operator preview the changes a reconcile would
+// make without actually making them, by swapping the client.Client every
+// reconciler is handed for a Client from this package.
+package dryrun
+
+import (
+	"context"
+	"encoding/json"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("dryrun")
+
+// DryRunMode is set by the operator binary's --dry-run flag before its
+// manager is built, so main can decide whether to wrap the manager's client
+// in a Client before handing it to the reconcilers. This tree has no
+// cmd/main.go to attach such a flag to; a binary that does should do
+// roughly:
+//
+//	flag.BoolVar(&dryrun.DryRunMode, "dry-run", false, "log planned changes instead of applying them")
+//	...
+//	c := mgr.GetClient()
+//	if dryrun.DryRunMode {
+//		c = dryrun.NewClient(c)
+//	}
+var DryRunMode bool
+
+// Client wraps a client.Client, replacing its mutating calls — Create,
+// Update, Patch, Delete, DeleteAllOf, and Create, Update and Patch through
+// Status() — with an Info-level log describing the operation and the object
+// it would have been performed on, instead of performing it. Every other
+// method (Get, List, Scheme, RESTMapper, ...) passes straight through to the
+// wrapped client.Client via embedding, including SubResource, so writes made
+// through SubResource(...) are not intercepted.
+type Client struct {
+	client.Client
+}
+
+// NewClient wraps c in a dry-run Client.
+func NewClient(c client.Client) *Client {
+	return &Client{Client: c}
+}
+
+func (c *Client) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	logPlannedChange("create", obj)
+	return nil
+}
+
+func (c *Client) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	logPlannedChange("update", obj)
+	return nil
+}
+
+func (c *Client) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	logPlannedChange("patch", obj)
+	return nil
+}
+
+func (c *Client) Delete(_ context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	logPlannedChange("delete", obj)
+	return nil
+}
+
+func (c *Client) DeleteAllOf(_ context.Context, obj client.Object, _ ...client.DeleteAllOfOption) error {
+	logPlannedChange("delete-all-of", obj)
+	return nil
+}
+
+// Status returns a SubResourceWriter that applies the same dry-run
+// treatment to status writes, since helpers like
+// istiooperator.UpdateOperatorStatus go through Status().Patch rather than
+// Patch directly.
+func (c *Client) Status() client.SubResourceWriter {
+	return &dryRunSubResourceWriter{subResource: "status"}
+}
+
+// dryRunSubResourceWriter is what Client.Status returns; subResource is
+// folded into the logged operation name ("status create", "status patch",
+// ...) so a dry-run log reads the same way regardless of which
+// SubResourceWriter-returning method it came from.
+type dryRunSubResourceWriter struct {
+	subResource string
+}
+
+func (w *dryRunSubResourceWriter) Create(_ context.Context, obj client.Object, subResource client.Object, _ ...client.SubResourceCreateOption) error {
+	logPlannedChange(w.subResource+" create", subResource)
+	return nil
+}
+
+func (w *dryRunSubResourceWriter) Update(_ context.Context, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+	logPlannedChange(w.subResource+" update", obj)
+	return nil
+}
+
+func (w *dryRunSubResourceWriter) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.SubResourcePatchOption) error {
+	logPlannedChange(w.subResource+" patch", obj)
+	return nil
+}
+
+// logPlannedChange logs op and obj serialized as JSON at Info level — the
+// level a dry-run plan should be visible at without raising the operator's
+// default log verbosity.
+func logPlannedChange(op string, obj client.Object) {
+	serialized, err := json.Marshal(obj)
+	if err != nil {
+		log.Info("Dry run: "+op, "error", err)
+		return
+	}
+	log.Info("Dry run: "+op, "object", string(serialized))
+}
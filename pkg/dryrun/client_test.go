@@ -0,0 +1,119 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dryrun
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(&corev1.Namespace{}).Build()
+}
+
+func TestClientCreateDoesNotMutateCluster(t *testing.T) {
+	underlying := newFakeClient(t)
+	c := NewClient(underlying)
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	if err := c.Create(context.Background(), ns); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+
+	if err := underlying.Get(context.Background(), client.ObjectKeyFromObject(ns), &corev1.Namespace{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() after dry-run Create = %v, want NotFound", err)
+	}
+}
+
+func TestClientUpdateDoesNotMutateCluster(t *testing.T) {
+	existing := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	underlying := newFakeClient(t, existing)
+	c := NewClient(underlying)
+
+	changed := existing.DeepCopy()
+	changed.Labels = map[string]string{"istio-injection": "enabled"}
+	if err := c.Update(context.Background(), changed); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+
+	got := &corev1.Namespace{}
+	if err := underlying.Get(context.Background(), client.ObjectKeyFromObject(existing), got); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if len(got.Labels) != 0 {
+		t.Fatalf("Labels = %v, want untouched by a dry-run Update", got.Labels)
+	}
+}
+
+func TestClientDeleteDoesNotMutateCluster(t *testing.T) {
+	existing := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	underlying := newFakeClient(t, existing)
+	c := NewClient(underlying)
+
+	if err := c.Delete(context.Background(), existing); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+
+	if err := underlying.Get(context.Background(), client.ObjectKeyFromObject(existing), &corev1.Namespace{}); err != nil {
+		t.Fatalf("Get() after dry-run Delete = %v, want the object to still exist", err)
+	}
+}
+
+func TestClientStatusPatchDoesNotMutateCluster(t *testing.T) {
+	existing := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	underlying := newFakeClient(t, existing)
+	c := NewClient(underlying)
+
+	before := existing.DeepCopy()
+	changed := existing.DeepCopy()
+	changed.Status.Phase = corev1.NamespaceTerminating
+	if err := c.Status().Patch(context.Background(), changed, client.MergeFrom(before)); err != nil {
+		t.Fatalf("Status().Patch() = %v", err)
+	}
+
+	got := &corev1.Namespace{}
+	if err := underlying.Get(context.Background(), client.ObjectKeyFromObject(existing), got); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if got.Status.Phase == corev1.NamespaceTerminating {
+		t.Fatal("Status.Phase changed, want untouched by a dry-run Status().Patch")
+	}
+}
+
+func TestClientGetPassesThroughToUnderlyingClient(t *testing.T) {
+	existing := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	underlying := newFakeClient(t, existing)
+	c := NewClient(underlying)
+
+	got := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(existing), got); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if got.Name != "shop" {
+		t.Fatalf("Get() returned %q, want %q", got.Name, "shop")
+	}
+}
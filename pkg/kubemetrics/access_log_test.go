@@ -0,0 +1,114 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+// recordingSink is a minimal logr.LogSink that remembers every Info call it
+// receives, so tests can assert on the fields ServeHTTP's access log passes.
+type recordingSink struct {
+	infos []recordedInfo
+}
+
+type recordedInfo struct {
+	level         int
+	msg           string
+	keysAndValues []interface{}
+}
+
+func (s *recordingSink) Init(logr.RuntimeInfo)   {}
+func (s *recordingSink) Enabled(level int) bool  { return true }
+func (s *recordingSink) Error(error, string, ...interface{}) {}
+
+func (s *recordingSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.infos = append(s.infos, recordedInfo{level: level, msg: msg, keysAndValues: keysAndValues})
+}
+
+func (s *recordingSink) WithValues(keysAndValues ...interface{}) logr.LogSink { return s }
+func (s *recordingSink) WithName(name string) logr.LogSink                   { return s }
+
+func (s *recordingSink) field(key string) (interface{}, bool) {
+	if len(s.infos) == 0 {
+		return nil, false
+	}
+	kvs := s.infos[len(s.infos)-1].keysAndValues
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if k, ok := kvs[i].(string); ok && k == key {
+			return kvs[i+1], true
+		}
+	}
+	return nil, false
+}
+
+func withRecordingSink(t *testing.T) *recordingSink {
+	t.Helper()
+	sink := &recordingSink{}
+	previous := log
+	log = logr.New(sink)
+	t.Cleanup(func() { log = previous })
+	return sink
+}
+
+func TestStoreRegistryServeHTTPLogsAccessWhenEnabled(t *testing.T) {
+	sink := withRecordingSink(t)
+
+	r := newStoreRegistry()
+	r.accessLog = true
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(sink.infos) != 1 {
+		t.Fatalf("Info() calls = %d, want 1", len(sink.infos))
+	}
+	if sink.infos[0].msg != "Served CR metrics scrape" {
+		t.Fatalf("msg = %q", sink.infos[0].msg)
+	}
+	if v, _ := sink.field("remoteAddr"); v != "10.0.0.5:1234" {
+		t.Fatalf("remoteAddr = %v, want 10.0.0.5:1234", v)
+	}
+	if v, _ := sink.field("path"); v != "/metrics" {
+		t.Fatalf("path = %v, want /metrics", v)
+	}
+	if v, _ := sink.field("status"); v != http.StatusOK {
+		t.Fatalf("status = %v, want %d", v, http.StatusOK)
+	}
+	if _, ok := sink.field("bytesWritten"); !ok {
+		t.Fatalf("bytesWritten field missing from access log entry")
+	}
+	if _, ok := sink.field("duration"); !ok {
+		t.Fatalf("duration field missing from access log entry")
+	}
+}
+
+func TestStoreRegistryServeHTTPDoesNotLogWhenDisabled(t *testing.T) {
+	sink := withRecordingSink(t)
+
+	r := newStoreRegistry()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(sink.infos) != 0 {
+		t.Fatalf("Info() calls = %d, want 0 when AccessLog is disabled", len(sink.infos))
+	}
+}
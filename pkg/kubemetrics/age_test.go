@@ -0,0 +1,82 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func ageTestObj(namespace, name string, created time.Time) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name},
+	}}
+	if namespace != "" {
+		obj.Object["metadata"].(map[string]interface{})["namespace"] = namespace
+	}
+	if !created.IsZero() {
+		obj.SetCreationTimestamp(metav1.NewTime(created))
+	}
+	return obj
+}
+
+func TestAgeFamilyGeneratorComputesAgeFromInjectedClock(t *testing.T) {
+	created := time.Date(2026, 8, 7, 0, 0, 0, 0, time.UTC)
+	now := created.Add(90 * time.Second)
+	gen := ageFamilyGenerator("IstioOperator", true, func() time.Time { return now })
+	if gen.Name != "istiooperator_age_seconds" {
+		t.Fatalf("gen.Name = %q, want istiooperator_age_seconds", gen.Name)
+	}
+
+	family := gen.GenerateFunc(ageTestObj("istio-system", "example", created))
+	if len(family.Metrics) != 1 {
+		t.Fatalf("GenerateFunc() = %+v, want one series", family.Metrics)
+	}
+	if family.Metrics[0].Value != 90 {
+		t.Fatalf("Metrics[0].Value = %v, want 90", family.Metrics[0].Value)
+	}
+}
+
+func TestAgeFamilyGeneratorOmitsNamespaceLabelForClusterScopedKind(t *testing.T) {
+	created := time.Date(2026, 8, 7, 0, 0, 0, 0, time.UTC)
+	now := created.Add(time.Hour)
+	gen := ageFamilyGenerator("ClusterIstio", false, func() time.Time { return now })
+
+	family := gen.GenerateFunc(ageTestObj("", "example", created))
+	if len(family.Metrics) != 1 {
+		t.Fatalf("GenerateFunc() = %+v, want one series", family.Metrics)
+	}
+	m := family.Metrics[0]
+	for _, k := range m.LabelKeys {
+		if k == "namespace" {
+			t.Fatalf("Metrics[0].LabelKeys = %v, want no namespace label for a cluster-scoped kind", m.LabelKeys)
+		}
+	}
+	if len(m.LabelKeys) != 1 || m.LabelKeys[0] != "name" {
+		t.Fatalf("Metrics[0].LabelKeys = %v, want [name]", m.LabelKeys)
+	}
+}
+
+func TestAgeFamilyGeneratorNoCreationTimestampEmitsNoSeries(t *testing.T) {
+	gen := ageFamilyGenerator("IstioOperator", true, func() time.Time { return time.Now() })
+
+	family := gen.GenerateFunc(ageTestObj("istio-system", "example", time.Time{}))
+	if len(family.Metrics) != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want no series without a creationTimestamp", family.Metrics)
+	}
+}
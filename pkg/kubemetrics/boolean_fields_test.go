@@ -0,0 +1,166 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// smcpMTLSSpec builds a minimal ServiceMeshControlPlane-shaped object with a
+// boolean spec.security.dataPlane.mtls field, the motivating example from
+// the feature request this generator shipped for.
+func smcpMTLSSpec(mtls interface{}) *unstructured.Unstructured {
+	obj := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}
+	if mtls != nil {
+		obj["spec"] = map[string]interface{}{
+			"security": map[string]interface{}{
+				"dataPlane": map[string]interface{}{"mtls": mtls},
+			},
+		}
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func TestBooleanFieldMetricNameDerivesFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "spec.security.dataPlane.mtls", want: "spec_security_dataplane_mtls"},
+		{path: "status.conditions[type=Ready].status", want: "status_conditions_type_ready_status"},
+	}
+	for _, tc := range tests {
+		if got := booleanFieldMetricName(tc.path); got != tc.want {
+			t.Errorf("booleanFieldMetricName(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestBooleanFieldFamilyGeneratorEmitsTrueAndFalse(t *testing.T) {
+	gen := booleanFieldFamilyGenerator(BooleanFieldSpec{Path: "spec.security.dataPlane.mtls"})
+	if gen.Name != "spec_security_dataplane_mtls" {
+		t.Fatalf("Name = %q, want a name derived from Path", gen.Name)
+	}
+
+	tests := []struct {
+		name string
+		mtls interface{}
+		want float64
+	}{
+		{name: "true", mtls: true, want: 1},
+		{name: "false", mtls: false, want: 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			family := gen.GenerateFunc(smcpMTLSSpec(tc.mtls))
+			if len(family.Metrics) != 1 || family.Metrics[0].Value != tc.want {
+				t.Fatalf("GenerateFunc() = %+v, want a single sample valued %v", family.Metrics, tc.want)
+			}
+		})
+	}
+}
+
+func TestBooleanFieldFamilyGeneratorOmitsMissingFieldByDefault(t *testing.T) {
+	gen := booleanFieldFamilyGenerator(BooleanFieldSpec{Path: "spec.security.dataPlane.mtls"})
+	family := gen.GenerateFunc(smcpMTLSSpec(nil))
+	if len(family.Metrics) != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want no samples for a missing field under the default MissingFieldOmit policy", family.Metrics)
+	}
+}
+
+func TestBooleanFieldFamilyGeneratorZerosMissingFieldUnderZeroPolicy(t *testing.T) {
+	gen := booleanFieldFamilyGenerator(BooleanFieldSpec{Path: "spec.security.dataPlane.mtls", MissingPolicy: MissingFieldZero})
+	family := gen.GenerateFunc(smcpMTLSSpec(nil))
+	if len(family.Metrics) != 1 || family.Metrics[0].Value != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want a single sample valued 0 under MissingFieldZero", family.Metrics)
+	}
+}
+
+func TestBooleanFieldFamilyGeneratorCountsExtractionErrorOnNonBooleanValue(t *testing.T) {
+	before := metricExtractionErrorsTotalValue(t)
+
+	gen := booleanFieldFamilyGenerator(BooleanFieldSpec{Path: "spec.security.dataPlane.mtls"})
+	family := gen.GenerateFunc(smcpMTLSSpec("yes"))
+
+	if len(family.Metrics) != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want no samples for a non-boolean value", family.Metrics)
+	}
+	if after := metricExtractionErrorsTotalValue(t); after != before+1 {
+		t.Fatalf("metricExtractionErrorsTotal = %v, want %v", after, before+1)
+	}
+}
+
+func TestParseMetricsConfigValidatesBooleanFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			raw: `
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  booleanFields:
+  - path: spec.security.dataPlane.mtls
+`,
+			wantErr: false,
+		},
+		{
+			name: "valid with missingPolicy",
+			raw: `
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  booleanFields:
+  - path: spec.security.dataPlane.mtls
+    missingPolicy: Zero
+`,
+			wantErr: false,
+		},
+		{
+			name: "invalid path syntax",
+			raw: `
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  booleanFields:
+  - path: "spec.security[mtls"
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid missingPolicy",
+			raw: `
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  booleanFields:
+  - path: spec.security.dataPlane.mtls
+    missingPolicy: Bogus
+`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseMetricsConfig([]byte(tc.raw))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("ParseMetricsConfig() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// smcpWithVersionDrift is a ServiceMeshControlPlane whose status hasn't
+// caught up to a just-applied spec.version bump.
+func smcpWithVersionDrift() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"spec":     map[string]interface{}{"version": "v2.5"},
+		"status":   map[string]interface{}{"appliedVersion": "v2.4", "readyComponents": int64(8), "totalComponents": int64(10)},
+	}}
+}
+
+func TestExpressionFamilyGeneratorDetectsVersionDrift(t *testing.T) {
+	gen := expressionFamilyGenerator(MetricSpec{
+		Name:       "smcp_version_drift",
+		Type:       MetricExpression,
+		Expression: "self.spec.version != self.status.appliedVersion",
+	})
+
+	family := gen.GenerateFunc(smcpWithVersionDrift())
+	if len(family.Metrics) != 1 || family.Metrics[0].Value != 1 {
+		t.Fatalf("GenerateFunc() = %+v, want a single sample valued 1 for drifted versions", family.Metrics)
+	}
+}
+
+func TestExpressionFamilyGeneratorComputesReadyRatio(t *testing.T) {
+	gen := expressionFamilyGenerator(MetricSpec{
+		Name:       "smcp_component_ready_ratio",
+		Type:       MetricExpression,
+		Expression: "double(self.status.readyComponents) / double(self.status.totalComponents)",
+	})
+
+	family := gen.GenerateFunc(smcpWithVersionDrift())
+	if len(family.Metrics) != 1 || family.Metrics[0].Value != 0.8 {
+		t.Fatalf("GenerateFunc() = %+v, want a single sample valued 0.8", family.Metrics)
+	}
+}
+
+func TestExpressionFamilyGeneratorNoSampleOnEvaluationError(t *testing.T) {
+	before := metricExtractionErrorsTotalValue(t)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	gen := expressionFamilyGenerator(MetricSpec{
+		Name:       "smcp_version_drift",
+		Type:       MetricExpression,
+		Expression: "self.spec.version != self.status.appliedVersion",
+	})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want no samples when spec/status are absent", family.Metrics)
+	}
+	if after := metricExtractionErrorsTotalValue(t); after != before+1 {
+		t.Fatalf("metricExtractionErrorsTotal = %v, want %v", after, before+1)
+	}
+}
+
+func TestExpressionFamilyGeneratorNoSampleOnMapResult(t *testing.T) {
+	before := metricExtractionErrorsTotalValue(t)
+
+	gen := expressionFamilyGenerator(MetricSpec{
+		Name:       "smcp_status_map",
+		Type:       MetricExpression,
+		Expression: "self.status",
+	})
+
+	family := gen.GenerateFunc(smcpWithVersionDrift())
+	if len(family.Metrics) != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want no samples for a cardinality-affecting map result", family.Metrics)
+	}
+	if after := metricExtractionErrorsTotalValue(t); after != before+1 {
+		t.Fatalf("metricExtractionErrorsTotal = %v, want %v", after, before+1)
+	}
+}
+
+func TestParseMetricsConfigRejectsInvalidCELExpression(t *testing.T) {
+	raw := []byte(`
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  metrics:
+  - name: smcp_version_drift
+    help: Whether the applied version lags the spec version.
+    type: Expression
+    expression: "self.spec.version !="
+`)
+	if _, err := ParseMetricsConfig(raw); err == nil {
+		t.Fatalf("ParseMetricsConfig() = no error, want one for an unparseable CEL expression")
+	}
+}
+
+func TestParseMetricsConfigRejectsMissingExpression(t *testing.T) {
+	raw := []byte(`
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  metrics:
+  - name: smcp_version_drift
+    help: Whether the applied version lags the spec version.
+    type: Expression
+`)
+	if _, err := ParseMetricsConfig(raw); err == nil {
+		t.Fatalf("ParseMetricsConfig() = no error, want one for an Expression metric with no expression set")
+	}
+}
+
+func TestParseMetricsConfigAcceptsValidCELExpression(t *testing.T) {
+	raw := []byte(`
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  metrics:
+  - name: smcp_version_drift
+    help: Whether the applied version lags the spec version.
+    type: Expression
+    expression: "self.spec.version != self.status.appliedVersion"
+`)
+	cfg, err := ParseMetricsConfig(raw)
+	if err != nil {
+		t.Fatalf("ParseMetricsConfig() = %v", err)
+	}
+	if len(cfg.Resources) != 1 || len(cfg.Resources[0].Metrics) != 1 {
+		t.Fatalf("ParseMetricsConfig() = %+v, want exactly one resource with one metric", cfg)
+	}
+}
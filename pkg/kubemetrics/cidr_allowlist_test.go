@@ -0,0 +1,130 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func allowHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestIPAllowlistMiddlewareAllowsAllWhenUnconfigured(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "203.0.113.9:54321"
+	w := httptest.NewRecorder()
+	m.ipAllowlistMiddleware(allowHandler()).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d with no AllowedCIDRs configured", w.Code, http.StatusOK)
+	}
+	if got := m.CIDRRejections(); got != 0 {
+		t.Errorf("CIDRRejections() = %d, want 0", got)
+	}
+}
+
+func TestIPAllowlistMiddlewareAllowsMatchingIPv4Peer(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+	m.allowedCIDRs = []*net.IPNet{cidr}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "10.1.2.3:54321"
+	w := httptest.NewRecorder()
+	m.ipAllowlistMiddleware(allowHandler()).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d for a peer inside the allowed CIDR", w.Code, http.StatusOK)
+	}
+}
+
+func TestIPAllowlistMiddlewareRejectsNonMatchingIPv4Peer(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+	m.allowedCIDRs = []*net.IPNet{cidr}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "203.0.113.9:54321"
+	w := httptest.NewRecorder()
+	m.ipAllowlistMiddleware(allowHandler()).ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d for a peer outside every allowed CIDR", w.Code, http.StatusForbidden)
+	}
+	if got := m.CIDRRejections(); got != 1 {
+		t.Errorf("CIDRRejections() = %d, want 1", got)
+	}
+}
+
+func TestIPAllowlistMiddlewareIgnoresXForwardedFor(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+	m.allowedCIDRs = []*net.IPNet{cidr}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "203.0.113.9:54321"
+	req.Header.Set("X-Forwarded-For", "10.1.2.3")
+	w := httptest.NewRecorder()
+	m.ipAllowlistMiddleware(allowHandler()).ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: a spoofed X-Forwarded-For must not bypass the peer-address check", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestIPAllowlistMiddlewareHandlesIPv6Peers(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	_, cidr, _ := net.ParseCIDR("2001:db8::/32")
+	m.allowedCIDRs = []*net.IPNet{cidr}
+
+	allowed := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	allowed.RemoteAddr = "[2001:db8::1]:54321"
+	w := httptest.NewRecorder()
+	m.ipAllowlistMiddleware(allowHandler()).ServeHTTP(w, allowed)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d for an IPv6 peer inside the allowed CIDR", w.Code, http.StatusOK)
+	}
+
+	rejected := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rejected.RemoteAddr = "[2001:db9::1]:54321"
+	w = httptest.NewRecorder()
+	m.ipAllowlistMiddleware(allowHandler()).ServeHTTP(w, rejected)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d for an IPv6 peer outside the allowed CIDR", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestIPAllowlistMiddlewareWiredIntoAuthMiddleware(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+	m.allowedCIDRs = []*net.IPNet{cidr}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "203.0.113.9:54321"
+	w := httptest.NewRecorder()
+	m.authMiddleware(allowHandler(), nil).ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: authMiddleware should apply the CIDR allowlist", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// newUnstructuredCR builds a minimal custom resource of gvk for feeding into
+// a fake dynamic client.
+func newUnstructuredCR(gvk schema.GroupVersionKind, name string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(gvk.GroupVersion().String())
+	u.SetKind(gvk.Kind)
+	u.SetName(name)
+	return u
+}
+
+// TestNewClusterScopedMetricsStores exercises newClusterScopedMetricsStores
+// (this package's unexported equivalent of the old exported
+// NewClusterScopedMetricsStores helper) against a fake dynamic client, using
+// a GVK whose group makes its GroupVersion string contain a "/" — the common
+// case for every non-core Istio CRD — to make sure that doesn't trip up
+// metric family generation.
+func TestNewClusterScopedMetricsStores(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "MeshConfig"}
+	gvr := schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: "meshconfigs"}
+
+	tests := []struct {
+		name      string
+		objects   []runtime.Object
+		wantNames []string
+	}{
+		{name: "zero objects", objects: nil, wantNames: nil},
+		{
+			name:      "apiVersion contains a slash",
+			objects:   []runtime.Object{newUnstructuredCR(gvk, "default")},
+			wantNames: []string{"default"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			listKinds := map[schema.GroupVersionResource]string{gvr: "MeshConfigList"}
+			dclient := fake.NewSimpleDynamicClientWithCustomListKinds(scheme, listKinds, tc.objects...)
+
+			familyGenerators := generateMetricFamilies(gvk.Kind, nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+			stores := newClusterScopedMetricsStores(dclient.Resource(gvr), familyGenerators, gvk.Kind, 0, false, nil, nil, 0)
+			if len(stores) != 1 {
+				t.Fatalf("len(stores) = %d, want 1", len(stores))
+			}
+			defer stores[0].stop()
+
+			var out string
+			for i := 0; i < 50; i++ {
+				var buf strings.Builder
+				stores[0].WriteAll(&buf)
+				out = buf.String()
+				if containsAll(out, tc.wantNames) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if !containsAll(out, tc.wantNames) {
+				t.Fatalf("exposition output = %q, want it to mention every name in %v", out, tc.wantNames)
+			}
+			if len(tc.wantNames) == 0 && strings.Contains(out, "meshconfig_info{") {
+				t.Fatalf("exposition output = %q, want no meshconfig_info series for zero objects", out)
+			}
+		})
+	}
+}
+
+// TestNewClusterScopedMetricsStoresWatchesEvents asserts that a store built
+// by newClusterScopedMetricsStores picks up an object created or deleted
+// after the store was built without needing a full relist, i.e. that it's
+// already watch/event-driven (via the cache.Reflector newReflectedMetricsStore
+// starts) rather than only refreshed by periodic polling.
+func TestNewClusterScopedMetricsStoresWatchesEvents(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "MeshConfig"}
+	gvr := schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: "meshconfigs"}
+
+	scheme := runtime.NewScheme()
+	listKinds := map[schema.GroupVersionResource]string{gvr: "MeshConfigList"}
+	dclient := fake.NewSimpleDynamicClientWithCustomListKinds(scheme, listKinds)
+
+	familyGenerators := generateMetricFamilies(gvk.Kind, nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	stores := newClusterScopedMetricsStores(dclient.Resource(gvr), familyGenerators, gvk.Kind, 0, false, nil, nil, 0)
+	defer stores[0].stop()
+
+	waitUntil := func(want bool) string {
+		t.Helper()
+		var out string
+		for i := 0; i < 50; i++ {
+			var buf strings.Builder
+			stores[0].WriteAll(&buf)
+			out = buf.String()
+			if strings.Contains(out, "meshconfig_info{") == want {
+				return out
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("exposition output = %q, want a meshconfig_info series present = %v", out, want)
+		return out
+	}
+
+	ctx := context.Background()
+	if _, err := dclient.Resource(gvr).Create(ctx, newUnstructuredCR(gvk, "default"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	waitUntil(true)
+
+	if err := dclient.Resource(gvr).Delete(ctx, "default", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	waitUntil(false)
+}
+
+// TestClusterScopedMetricsStoreCountMetricTracksAddsAndDeletes builds a
+// cluster-scoped store with countMetric enabled and asserts the resulting
+// "meshconfig_count" gauge tracks CRs created and deleted on the fake
+// dynamic client, the same watch-driven way TestNewClusterScopedMetricsStoresWatchesEvents
+// asserts for the "meshconfig_info" gauge.
+func TestClusterScopedMetricsStoreCountMetricTracksAddsAndDeletes(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "MeshConfig"}
+	gvr := schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: "meshconfigs"}
+
+	scheme := runtime.NewScheme()
+	listKinds := map[schema.GroupVersionResource]string{gvr: "MeshConfigList"}
+	dclient := fake.NewSimpleDynamicClientWithCustomListKinds(scheme, listKinds)
+
+	familyGenerators := generateMetricFamilies(gvk.Kind, nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	stores := newClusterScopedMetricsStores(dclient.Resource(gvr), familyGenerators, gvk.Kind, 0, true, nil, nil, 0)
+	defer stores[0].stop()
+
+	waitForCount := func(want string) string {
+		t.Helper()
+		var out string
+		for i := 0; i < 50; i++ {
+			var buf strings.Builder
+			stores[0].WriteAll(&buf)
+			writeCountMetric(&buf, gvk.Kind, stores[0].namespaced, stores[0].namespace, stores[0].List())
+			out = buf.String()
+			if strings.Contains(out, want) {
+				return out
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("exposition output = %q, want it to contain %q", out, want)
+		return out
+	}
+
+	ctx := context.Background()
+	waitForCount("meshconfig_count 0")
+
+	if _, err := dclient.Resource(gvr).Create(ctx, newUnstructuredCR(gvk, "default"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	waitForCount("meshconfig_count 1")
+
+	if _, err := dclient.Resource(gvr).Create(ctx, newUnstructuredCR(gvk, "other"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	waitForCount("meshconfig_count 2")
+
+	if err := dclient.Resource(gvr).Delete(ctx, "default", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	waitForCount("meshconfig_count 1")
+}
+
+func containsAll(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,584 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// metricExtractionErrorsTotal counts every time a configured MetricSpec's
+// Path fails to resolve or convert against an actual CR at runtime. Path
+// syntax itself is rejected at config load time by ParseMetricsConfig, so
+// this only fires for fields genuinely absent (or of an unexpected shape)
+// on a given object, the way drainTimedOutTotal counts an operational
+// condition rather than a programming error.
+var metricExtractionErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "cr_metric_extraction_errors_total",
+	Help: "Number of times a configured CustomResourceMetricSpec's Path failed to resolve or convert against a CR.",
+})
+
+// mapTruncatedTotal counts every time a configured Type: Map metric's map
+// field had more entries than MetricSpec.MaxMapEntries for a given object,
+// labeled by metric name, so a truncated series set doesn't silently read
+// as complete.
+var mapTruncatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "cr_metric_map_truncated_total",
+	Help: "Number of times a configured CustomResourceMetricSpec's Type: Map metric was truncated because an object's map exceeded MaxMapEntries.",
+}, []string{"metric"})
+
+func init() {
+	prometheus.MustRegister(metricExtractionErrorsTotal)
+	prometheus.MustRegister(mapTruncatedTotal)
+}
+
+// metricNameRE matches a valid Prometheus metric name, per
+// https://prometheus.io/docs/concepts/data_model/#metric-names-and-labels.
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// MetricType identifies which flavor of metric a MetricSpec produces, mirroring
+// the customresourcestate config model kube-state-metrics uses for generic CRs.
+type MetricType string
+
+const (
+	// MetricGauge extracts a numeric/bool field as a gauge value.
+	MetricGauge MetricType = "Gauge"
+	// MetricInfo extracts a string field and exposes it as a label on a
+	// constant-value info metric.
+	MetricInfo MetricType = "Info"
+	// MetricStateSet enumerates the States a field is allowed to take and
+	// emits one series per state, valued 1 for the state the field is
+	// currently set to and 0 for every other declared state.
+	MetricStateSet MetricType = "StateSet"
+	// MetricConditions walks a status.conditions-shaped array and, for each
+	// distinct condition type found, emits one series per possible status
+	// ("True", "False", "Unknown"), valued 1 for the status the condition is
+	// currently set to and 0 for the other two, the way kube-state-metrics
+	// does for kube_node_status_condition.
+	MetricConditions MetricType = "Conditions"
+	// MetricExpression evaluates Expression, a CEL expression, against the
+	// object as a Gauge value, for logic a field lookup alone can't
+	// express (e.g. comparing two fields). See compileCELExpression.
+	MetricExpression MetricType = "Expression"
+	// MetricMap walks a map field at Path and emits one Gauge series per
+	// entry, the map key sanitized into a label value named MapLabelName
+	// and the entry's value (bool or number) becoming the sample — e.g. a
+	// "status.components" map of component name to readiness becomes
+	// "<name>_component_ready{component=\"pilot\"} 1". MaxMapEntries caps
+	// how many entries a single object contributes.
+	MetricMap MetricType = "Map"
+)
+
+// MetricSpec declares a single additional metric to extract from a custom
+// resource, alongside the fixed "<kind>_info" gauge generateMetricFamilies
+// always emits.
+type MetricSpec struct {
+	// Name is the Prometheus metric name, e.g. "replicas_desired".
+	Name string `json:"name"`
+	// Help is the metric's HELP text.
+	Help string `json:"help"`
+	// Type selects how Path's value is turned into a metric.
+	Type MetricType `json:"type"`
+	// Path is the JSON path into the unstructured object the value is read
+	// from, e.g. "status.conditions[type=Ready].status". See resolvePath
+	// for the supported syntax; ParseMetricsConfig rejects a syntactically
+	// invalid Path up front, so a Gauge/Info/StateSet generator only ever
+	// sees a Path that resolved cleanly or didn't resolve at all. For
+	// Type: Gauge, Path resolving to an array yields the array's length
+	// rather than an error, e.g. pointing it at "status.conditions" to
+	// count conditions without a dedicated MetricType. For Type:
+	// Conditions, Path points at the conditions array itself (e.g.
+	// "status.conditions") rather than a single condition's field, and
+	// defaults to "status.conditions" when left empty; it's the only Type
+	// that allows an empty Path.
+	Path string `json:"path"`
+	// NilIsZero makes a Gauge metric resolve to 0 instead of being dropped
+	// when Path resolves to a nil value. It is applied the same way to a nil
+	// map entry for Type: Map and a null result for Type: Expression, and is
+	// unused for every other Type.
+	NilIsZero bool `json:"nilIsZero,omitempty"`
+	// States is the set of allowed values for Type: StateSet. Unused for
+	// every other Type, including Conditions, whose possible statuses are
+	// fixed to "True"/"False"/"Unknown".
+	States []string `json:"states,omitempty"`
+	// Expression is a CEL expression evaluated against the object for
+	// Type: Expression, with the object itself bound to the variable
+	// "self", e.g. "self.spec.version != self.status.appliedVersion".
+	// Unused for every other Type. An expression evaluating to a map is
+	// rejected at runtime rather than producing a sample, since a
+	// cardinality-affecting result isn't supported yet.
+	Expression string `json:"expression,omitempty"`
+	// MapLabelName names the label a map entry's key becomes for Type:
+	// Map, e.g. "component" for a "status.components" map keyed by
+	// component name. Required for Type: Map, unused for every other Type.
+	MapLabelName string `json:"mapLabelName,omitempty"`
+	// MaxMapEntries caps how many entries of the map at Path a single
+	// object contributes series for, for Type: Map. Entries beyond the cap
+	// are dropped in sorted-key order and mapTruncatedTotal is incremented,
+	// so an unbounded map can't blow up cardinality unnoticed. Zero (the
+	// default) leaves the map uncapped. Unused for every other Type.
+	MaxMapEntries int `json:"maxMapEntries,omitempty"`
+}
+
+// CustomResourceMetricSpec declares the additional metrics to generate for
+// every object of a given GVK, on top of the fixed "<kind>_info" gauge.
+type CustomResourceMetricSpec struct {
+	GVK     schema.GroupVersionKind `json:"gvk"`
+	Metrics []MetricSpec            `json:"metrics"`
+	// BooleanFields declares a list of boolean fields to expose as their own
+	// 0/1 gauges, named mechanically from each entry's Path rather than
+	// requiring a full MetricSpec (and an explicit Name) per field. See
+	// BooleanFieldSpec.
+	BooleanFields []BooleanFieldSpec `json:"booleanFields,omitempty"`
+}
+
+// MetricsConfig is the top-level shape of the file or ConfigMap passed to
+// GenerateAndServeCRMetricsWithOptions to declare CustomResourceMetricSpecs.
+type MetricsConfig struct {
+	Resources []CustomResourceMetricSpec `json:"resources"`
+}
+
+// LoadMetricsConfig reads and parses a MetricsConfig from a YAML (or JSON,
+// since JSON is valid YAML) file at path. The same bytes can equally be
+// sourced from a mounted ConfigMap key.
+func LoadMetricsConfig(path string) (*MetricsConfig, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics config %s: %w", path, err)
+	}
+	return ParseMetricsConfig(raw)
+}
+
+// ParseMetricsConfig parses a MetricsConfig from raw YAML/JSON bytes, e.g.
+// the contents of a ConfigMap key.
+func ParseMetricsConfig(raw []byte) (*MetricsConfig, error) {
+	cfg := &MetricsConfig{}
+	if err := yaml.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse metrics config: %w", err)
+	}
+	for _, resource := range cfg.Resources {
+		for _, m := range resource.Metrics {
+			if !metricNameRE.MatchString(m.Name) {
+				return nil, fmt.Errorf("invalid metric name %q: must match %s", m.Name, metricNameRE.String())
+			}
+			if strings.ContainsAny(m.Help, "\r\n") {
+				return nil, fmt.Errorf("metric %q: help text must not contain raw newlines, since that corrupts the exposition format's \"# HELP\" line", m.Name)
+			}
+			switch m.Type {
+			case MetricGauge, MetricInfo, MetricStateSet, MetricConditions, MetricExpression, MetricMap:
+			default:
+				return nil, fmt.Errorf("invalid metric type %q for metric %q: must be one of %q, %q, %q, %q, %q, %q",
+					m.Type, m.Name, MetricGauge, MetricInfo, MetricStateSet, MetricConditions, MetricExpression, MetricMap)
+			}
+			if m.Type == MetricExpression {
+				if m.Expression == "" {
+					return nil, fmt.Errorf("metric %q: expression is required for type %q", m.Name, MetricExpression)
+				}
+				if _, err := compileCELExpression(m.Expression); err != nil {
+					return nil, fmt.Errorf("metric %q: %w", m.Name, err)
+				}
+				continue
+			}
+			if m.Type == MetricMap && m.MapLabelName == "" {
+				return nil, fmt.Errorf("metric %q: mapLabelName is required for type %q", m.Name, MetricMap)
+			}
+			if m.Type == MetricMap && !metricNameRE.MatchString(m.MapLabelName) {
+				return nil, fmt.Errorf("invalid mapLabelName %q for metric %q: must match %s", m.MapLabelName, m.Name, metricNameRE.String())
+			}
+			if m.Path == "" && m.Type != MetricConditions {
+				return nil, fmt.Errorf("metric %q: path is required for type %q", m.Name, m.Type)
+			}
+			if m.Path != "" {
+				if _, err := splitPath(m.Path); err != nil {
+					return nil, fmt.Errorf("metric %q: %w", m.Name, err)
+				}
+			}
+		}
+		for _, b := range resource.BooleanFields {
+			if _, err := splitPath(b.Path); err != nil {
+				return nil, fmt.Errorf("boolean field %q: %w", b.Path, err)
+			}
+			switch b.MissingPolicy {
+			case "", MissingFieldOmit, MissingFieldZero:
+			default:
+				return nil, fmt.Errorf("boolean field %q: invalid missingPolicy %q: must be one of %q, %q or empty", b.Path, b.MissingPolicy, MissingFieldOmit, MissingFieldZero)
+			}
+			if name := booleanFieldMetricName(b.Path); !metricNameRE.MatchString(name) {
+				return nil, fmt.Errorf("boolean field %q: derived metric name %q is invalid: must match %s", b.Path, name, metricNameRE.String())
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// specFor returns the CustomResourceMetricSpec configured for gvk, if any.
+func (c *MetricsConfig) specFor(gvk schema.GroupVersionKind) (CustomResourceMetricSpec, bool) {
+	if c == nil {
+		return CustomResourceMetricSpec{}, false
+	}
+	for _, spec := range c.Resources {
+		if spec.GVK == gvk {
+			return spec, true
+		}
+	}
+	return CustomResourceMetricSpec{}, false
+}
+
+// generateConfiguredFamilies builds the ksmetric.FamilyGenerators declared by
+// spec, to be appended to the fixed "<kind>_info" family generateMetricFamilies
+// always produces.
+func generateConfiguredFamilies(spec CustomResourceMetricSpec) []ksmetric.FamilyGenerator {
+	generators := make([]ksmetric.FamilyGenerator, 0, len(spec.Metrics)+len(spec.BooleanFields))
+	for _, m := range spec.Metrics {
+		m := m
+		switch m.Type {
+		case MetricStateSet:
+			generators = append(generators, stateSetFamilyGenerator(m))
+		case MetricInfo:
+			generators = append(generators, infoFamilyGenerator(m))
+		case MetricConditions:
+			generators = append(generators, conditionsFamilyGenerator(m), conditionLastTransitionTimeFamilyGenerator(m))
+		case MetricExpression:
+			generators = append(generators, expressionFamilyGenerator(m))
+		case MetricMap:
+			generators = append(generators, mapFamilyGenerator(m))
+		default: // MetricGauge; ParseMetricsConfig rejects any other Type.
+			generators = append(generators, gaugeFamilyGenerator(m))
+		}
+	}
+	for _, b := range spec.BooleanFields {
+		generators = append(generators, booleanFieldFamilyGenerator(b))
+	}
+	return generators
+}
+
+func gaugeFamilyGenerator(m MetricSpec) ksmetric.FamilyGenerator {
+	return ksmetric.FamilyGenerator{
+		Name: m.Name,
+		Type: ksmetric.Gauge,
+		Help: m.Help,
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			value, ok, err := resolvePath(crd.Object, m.Path)
+			if err != nil {
+				log.Error(err, "Failed to resolve metric path", "path", m.Path, "metric", m.Name)
+				return &ksmetric.Family{}
+			}
+			if !ok {
+				metricExtractionErrorsTotal.Inc()
+				return &ksmetric.Family{}
+			}
+			f, err := toFloat64(value, m.NilIsZero)
+			if err != nil {
+				log.Error(err, "Failed to convert metric value", "path", m.Path, "metric", m.Name)
+				metricExtractionErrorsTotal.Inc()
+				return &ksmetric.Family{}
+			}
+			return &ksmetric.Family{
+				Metrics: []*ksmetric.Metric{
+					{
+						Value:       f,
+						LabelKeys:   []string{"namespace", "name"},
+						LabelValues: []string{crd.GetNamespace(), crd.GetName()},
+					},
+				},
+			}
+		},
+	}
+}
+
+func infoFamilyGenerator(m MetricSpec) ksmetric.FamilyGenerator {
+	return ksmetric.FamilyGenerator{
+		Name: m.Name,
+		Type: ksmetric.Gauge,
+		Help: m.Help,
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			value, ok, err := resolvePath(crd.Object, m.Path)
+			if err != nil {
+				log.Error(err, "Failed to resolve metric path", "path", m.Path, "metric", m.Name)
+				return &ksmetric.Family{}
+			}
+			if !ok {
+				metricExtractionErrorsTotal.Inc()
+				return &ksmetric.Family{}
+			}
+			return &ksmetric.Family{
+				Metrics: []*ksmetric.Metric{
+					{
+						Value:       1,
+						LabelKeys:   []string{"namespace", "name", "value"},
+						LabelValues: []string{crd.GetNamespace(), crd.GetName(), fmt.Sprintf("%v", value)},
+					},
+				},
+			}
+		},
+	}
+}
+
+func stateSetFamilyGenerator(m MetricSpec) ksmetric.FamilyGenerator {
+	return ksmetric.FamilyGenerator{
+		Name: m.Name,
+		Type: ksmetric.Gauge,
+		Help: m.Help,
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			value, ok, err := resolvePath(crd.Object, m.Path)
+			if err != nil {
+				log.Error(err, "Failed to resolve metric path", "path", m.Path, "metric", m.Name)
+				return &ksmetric.Family{}
+			}
+			current := ""
+			if ok {
+				current = fmt.Sprintf("%v", value)
+			}
+			metrics := make([]*ksmetric.Metric, 0, len(m.States))
+			for _, state := range m.States {
+				v := 0.0
+				if state == current {
+					v = 1.0
+				}
+				metrics = append(metrics, &ksmetric.Metric{
+					Value:       v,
+					LabelKeys:   []string{"namespace", "name", m.Name},
+					LabelValues: []string{crd.GetNamespace(), crd.GetName(), state},
+				})
+			}
+			return &ksmetric.Family{Metrics: metrics}
+		},
+	}
+}
+
+// conditionStatuses are the three values status.conditions entries are
+// documented to take, per the Kubernetes API conventions' Condition type.
+var conditionStatuses = []string{"True", "False", "Unknown"}
+
+// conditionsFamilyGenerator walks the array at m.Path (defaulting to
+// "status.conditions"), and for each distinct condition "type" found emits
+// one series per conditionStatuses value, labeled type/status/reason, valued
+// 1 for the status the condition currently reports and 0 for the other two.
+// "reason" is only populated on the matching series; the other two report an
+// empty reason, since a condition only ever has one actual reason at a time.
+// A condition entry missing "type" is skipped; an object with no conditions
+// array at all (or an empty one) produces no samples, not a zero-valued
+// placeholder.
+func conditionsFamilyGenerator(m MetricSpec) ksmetric.FamilyGenerator {
+	path := m.Path
+	if path == "" {
+		path = "status.conditions"
+	}
+	return ksmetric.FamilyGenerator{
+		Name: m.Name,
+		Type: ksmetric.Gauge,
+		Help: m.Help,
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			value, ok, err := resolvePath(crd.Object, path)
+			if err != nil {
+				log.Error(err, "Failed to resolve metric path", "path", path, "metric", m.Name)
+				return &ksmetric.Family{}
+			}
+			if !ok {
+				return &ksmetric.Family{}
+			}
+			conditions, ok := value.([]interface{})
+			if !ok {
+				return &ksmetric.Family{}
+			}
+
+			metrics := make([]*ksmetric.Metric, 0, len(conditions)*len(conditionStatuses))
+			for _, c := range conditions {
+				condition, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				condType, _ := condition["type"].(string)
+				if condType == "" {
+					continue
+				}
+				observedStatus, _ := condition["status"].(string)
+				reason, _ := condition["reason"].(string)
+
+				for _, status := range conditionStatuses {
+					v := 0.0
+					seriesReason := ""
+					if status == observedStatus {
+						v = 1.0
+						seriesReason = reason
+					}
+					metrics = append(metrics, &ksmetric.Metric{
+						Value:       v,
+						LabelKeys:   []string{"namespace", "name", "type", "status", "reason"},
+						LabelValues: []string{crd.GetNamespace(), crd.GetName(), condType, status, seriesReason},
+					})
+				}
+			}
+			return &ksmetric.Family{Metrics: metrics}
+		},
+	}
+}
+
+// conditionLastTransitionTimeFamilyGenerator walks the same conditions
+// array as conditionsFamilyGenerator and, for each distinct condition
+// "type" found, emits one gauge series named m.Name with a
+// "_last_transition_time" suffix, valued at that condition's
+// lastTransitionTime as Unix seconds, so `time() - x` gives how long the
+// condition has held its current status directly in PromQL — the same
+// parity kube_pod_status_condition's family has no equivalent of today. A
+// condition missing "type" or a lastTransitionTime parseConditionTime can
+// make sense of is skipped entirely rather than reported as 0, since a
+// missing or malformed timestamp isn't the same as a zero one.
+func conditionLastTransitionTimeFamilyGenerator(m MetricSpec) ksmetric.FamilyGenerator {
+	path := m.Path
+	if path == "" {
+		path = "status.conditions"
+	}
+	return ksmetric.FamilyGenerator{
+		Name: m.Name + "_last_transition_time",
+		Type: ksmetric.Gauge,
+		Help: fmt.Sprintf("Unix timestamp of the last transition for each condition type %s reports.", m.Name),
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			value, ok, err := resolvePath(crd.Object, path)
+			if err != nil {
+				log.Error(err, "Failed to resolve metric path", "path", path, "metric", m.Name)
+				return &ksmetric.Family{}
+			}
+			if !ok {
+				return &ksmetric.Family{}
+			}
+			conditions, ok := value.([]interface{})
+			if !ok {
+				return &ksmetric.Family{}
+			}
+
+			var metrics []*ksmetric.Metric
+			for _, c := range conditions {
+				condition, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				condType, _ := condition["type"].(string)
+				if condType == "" {
+					continue
+				}
+				t, ok := parseConditionTime(condition["lastTransitionTime"])
+				if !ok {
+					continue
+				}
+				metrics = append(metrics, &ksmetric.Metric{
+					Value:       float64(t.Unix()),
+					LabelKeys:   []string{"namespace", "name", "type"},
+					LabelValues: []string{crd.GetNamespace(), crd.GetName(), condType},
+				})
+			}
+			return &ksmetric.Family{Metrics: metrics}
+		},
+	}
+}
+
+// parseConditionTime parses v as a condition's lastTransitionTime,
+// accepting both the RFC3339 string it's decoded as from real JSON/YAML
+// (what metav1.Time marshals to) and a metav1.Time-shaped nested value —
+// map[string]interface{}{"Time": <RFC3339 string>} — for a condition built
+// by hand or by a converter that preserved metav1.Time's field structure
+// instead of calling its MarshalJSON. Returns false for anything else,
+// including an empty or malformed string, rather than guessing.
+func parseConditionTime(v interface{}) (time.Time, bool) {
+	switch value := v.(type) {
+	case string:
+		if value == "" {
+			return time.Time{}, false
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, true
+		}
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t, true
+		}
+		return time.Time{}, false
+	case map[string]interface{}:
+		return parseConditionTime(value["Time"])
+	default:
+		return time.Time{}, false
+	}
+}
+
+// mapFamilyGenerator walks the map at m.Path and emits one series per entry,
+// the entry's key sanitized into a label value named m.MapLabelName and its
+// value converted with toFloat64 into the sample. Entries are visited in
+// sorted key order, both so output is deterministic and so truncation at
+// m.MaxMapEntries (if set) drops a stable, predictable tail rather than
+// whichever entries a map iteration happens to skip.
+func mapFamilyGenerator(m MetricSpec) ksmetric.FamilyGenerator {
+	return ksmetric.FamilyGenerator{
+		Name: m.Name,
+		Type: ksmetric.Gauge,
+		Help: m.Help,
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			value, ok, err := resolvePath(crd.Object, m.Path)
+			if err != nil {
+				log.Error(err, "Failed to resolve metric path", "path", m.Path, "metric", m.Name)
+				return &ksmetric.Family{}
+			}
+			if !ok {
+				return &ksmetric.Family{}
+			}
+			entries, ok := value.(map[string]interface{})
+			if !ok {
+				metricExtractionErrorsTotal.Inc()
+				return &ksmetric.Family{}
+			}
+
+			keys := make([]string, 0, len(entries))
+			for k := range entries {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if m.MaxMapEntries > 0 && len(keys) > m.MaxMapEntries {
+				mapTruncatedTotal.WithLabelValues(m.Name).Inc()
+				keys = keys[:m.MaxMapEntries]
+			}
+
+			metrics := make([]*ksmetric.Metric, 0, len(keys))
+			for _, k := range keys {
+				f, err := toFloat64(entries[k], m.NilIsZero)
+				if err != nil {
+					log.Error(err, "Failed to convert metric value", "path", m.Path, "metric", m.Name, "key", k)
+					metricExtractionErrorsTotal.Inc()
+					continue
+				}
+				metrics = append(metrics, &ksmetric.Metric{
+					Value:       f,
+					LabelKeys:   []string{"namespace", "name", m.MapLabelName},
+					LabelValues: []string{crd.GetNamespace(), crd.GetName(), sanitizeLabelValue(k)},
+				})
+			}
+			return &ksmetric.Family{Metrics: metrics}
+		},
+	}
+}
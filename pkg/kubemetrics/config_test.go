@@ -0,0 +1,295 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// smcpStatus is a realistic, trimmed status.conditions payload from a
+// maistra ServiceMeshControlPlane: Installed has already settled True, but
+// Ready has flipped False with a component failure reason.
+func smcpStatus() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{
+					"type":    "Installed",
+					"status":  "True",
+					"reason":  "InstallSuccessful",
+					"message": "Installation succeeded",
+				},
+				map[string]interface{}{
+					"type":    "Ready",
+					"status":  "False",
+					"reason":  "ComponentFailure",
+					"message": "istiod is not ready",
+				},
+			},
+		},
+	}}
+}
+
+func TestConditionsFamilyGeneratorEmitsOneSeriesPerStatus(t *testing.T) {
+	gen := conditionsFamilyGenerator(MetricSpec{Name: "smcp_status_condition", Type: MetricConditions})
+	family := gen.GenerateFunc(smcpStatus())
+
+	if len(family.Metrics) != 2*len(conditionStatuses) {
+		t.Fatalf("len(Metrics) = %d, want %d (2 condition types x %d statuses)", len(family.Metrics), 2*len(conditionStatuses), len(conditionStatuses))
+	}
+
+	got := map[[2]string]struct {
+		value  float64
+		reason string
+	}{}
+	for _, m := range family.Metrics {
+		key := [2]string{m.LabelValues[2], m.LabelValues[3]} // type, status
+		got[key] = struct {
+			value  float64
+			reason string
+		}{m.Value, m.LabelValues[4]}
+	}
+
+	if v := got[[2]string{"Installed", "True"}]; v.value != 1 || v.reason != "InstallSuccessful" {
+		t.Errorf("Installed/True = %+v, want value 1 and reason %q", v, "InstallSuccessful")
+	}
+	if v := got[[2]string{"Installed", "False"}]; v.value != 0 || v.reason != "" {
+		t.Errorf("Installed/False = %+v, want value 0 and no reason", v)
+	}
+	if v := got[[2]string{"Ready", "False"}]; v.value != 1 || v.reason != "ComponentFailure" {
+		t.Errorf("Ready/False = %+v, want value 1 and reason %q", v, "ComponentFailure")
+	}
+	if v := got[[2]string{"Ready", "True"}]; v.value != 0 || v.reason != "" {
+		t.Errorf("Ready/True = %+v, want value 0 and no reason", v)
+	}
+}
+
+func TestConditionsFamilyGeneratorProducesNoSamplesWithoutConditions(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	gen := conditionsFamilyGenerator(MetricSpec{Name: "smcp_status_condition", Type: MetricConditions})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 0 {
+		t.Fatalf("len(Metrics) = %d, want 0 for an object with no status.conditions array", len(family.Metrics))
+	}
+}
+
+func TestConditionsFamilyGeneratorSkipsConditionsMissingType(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"status": "True", "reason": "NoType"},
+			},
+		},
+	}}
+	gen := conditionsFamilyGenerator(MetricSpec{Name: "smcp_status_condition", Type: MetricConditions})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 0 {
+		t.Fatalf("len(Metrics) = %d, want 0 for a condition entry missing \"type\"", len(family.Metrics))
+	}
+}
+
+func TestConditionLastTransitionTimeFamilyGeneratorEmitsOneSeriesPerType(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Installed", "status": "True", "lastTransitionTime": "2024-01-01T00:00:00Z"},
+				map[string]interface{}{"type": "Ready", "status": "False", "lastTransitionTime": "2024-06-15T12:30:00Z"},
+			},
+		},
+	}}
+	gen := conditionLastTransitionTimeFamilyGenerator(MetricSpec{Name: "smcp_status_condition", Type: MetricConditions})
+
+	if gen.Name != "smcp_status_condition_last_transition_time" {
+		t.Fatalf("Name = %q, want %q", gen.Name, "smcp_status_condition_last_transition_time")
+	}
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 2 {
+		t.Fatalf("len(Metrics) = %d, want 2", len(family.Metrics))
+	}
+
+	got := map[string]float64{}
+	for _, m := range family.Metrics {
+		got[m.LabelValues[2]] = m.Value // type
+	}
+	if got["Installed"] != 1704067200 {
+		t.Errorf("Installed = %v, want Unix seconds for 2024-01-01T00:00:00Z", got["Installed"])
+	}
+	if got["Ready"] != 1718454600 {
+		t.Errorf("Ready = %v, want Unix seconds for 2024-06-15T12:30:00Z", got["Ready"])
+	}
+}
+
+func TestConditionLastTransitionTimeFamilyGeneratorSkipsConditionsMissingTheTimestamp(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Installed", "status": "True"},
+				map[string]interface{}{"type": "Ready", "status": "False", "lastTransitionTime": "not-a-timestamp"},
+			},
+		},
+	}}
+	gen := conditionLastTransitionTimeFamilyGenerator(MetricSpec{Name: "smcp_status_condition", Type: MetricConditions})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 0 {
+		t.Fatalf("len(Metrics) = %d, want 0 for conditions with a missing or malformed lastTransitionTime", len(family.Metrics))
+	}
+}
+
+func TestConditionLastTransitionTimeFamilyGeneratorReadsAMetav1TimeShapedValue(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{
+					"type":               "Installed",
+					"status":             "True",
+					"lastTransitionTime": map[string]interface{}{"Time": "2024-01-01T00:00:00Z"},
+				},
+			},
+		},
+	}}
+	gen := conditionLastTransitionTimeFamilyGenerator(MetricSpec{Name: "smcp_status_condition", Type: MetricConditions})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 1 {
+		t.Fatalf("len(Metrics) = %d, want 1", len(family.Metrics))
+	}
+	if family.Metrics[0].Value != 1704067200 {
+		t.Errorf("Value = %v, want Unix seconds for 2024-01-01T00:00:00Z", family.Metrics[0].Value)
+	}
+}
+
+func TestParseConditionTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  bool
+	}{
+		{"valid RFC3339 string", "2024-01-01T00:00:00Z", true},
+		{"valid RFC3339Nano string", "2024-01-01T00:00:00.123456789Z", true},
+		{"empty string", "", false},
+		{"malformed string", "not-a-timestamp", false},
+		{"metav1.Time-shaped map", map[string]interface{}{"Time": "2024-01-01T00:00:00Z"}, true},
+		{"metav1.Time-shaped map with a malformed inner value", map[string]interface{}{"Time": "nope"}, false},
+		{"nil", nil, false},
+		{"unexpected type", 1704067200, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ok := parseConditionTime(tc.value)
+			if ok != tc.want {
+				t.Errorf("parseConditionTime(%#v) ok = %v, want %v", tc.value, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseMetricsConfigRejectsInvalidPathSyntax(t *testing.T) {
+	raw := []byte(`
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  metrics:
+  - name: smcp_bad_path
+    help: A metric with an unparseable path.
+    type: Gauge
+    path: "status.conditions[type=Ready"
+`)
+	if _, err := ParseMetricsConfig(raw); err == nil {
+		t.Fatalf("ParseMetricsConfig() = no error, want one for an unterminated bracket selector")
+	}
+}
+
+func TestParseMetricsConfigRejectsMissingPathForGauge(t *testing.T) {
+	raw := []byte(`
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  metrics:
+  - name: smcp_no_path
+    help: A gauge with no path set.
+    type: Gauge
+`)
+	if _, err := ParseMetricsConfig(raw); err == nil {
+		t.Fatalf("ParseMetricsConfig() = no error, want one for a Gauge metric with no path")
+	}
+}
+
+func TestGaugeFamilyGeneratorConvertsArrayToLength(t *testing.T) {
+	gen := gaugeFamilyGenerator(MetricSpec{Name: "smcp_condition_count", Type: MetricGauge, Path: "status.conditions"})
+	family := gen.GenerateFunc(smcpStatus())
+
+	if len(family.Metrics) != 1 || family.Metrics[0].Value != 2 {
+		t.Fatalf("GenerateFunc() = %+v, want a single sample valued 2", family.Metrics)
+	}
+}
+
+func TestGaugeFamilyGeneratorCountsExtractionErrorOnMissingField(t *testing.T) {
+	before := metricExtractionErrorsTotalValue(t)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	gen := gaugeFamilyGenerator(MetricSpec{Name: "smcp_missing_field", Type: MetricGauge, Path: "status.replicas"})
+	family := gen.GenerateFunc(obj)
+
+	if len(family.Metrics) != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want no samples for a missing field", family.Metrics)
+	}
+	if after := metricExtractionErrorsTotalValue(t); after != before+1 {
+		t.Fatalf("metricExtractionErrorsTotal = %v, want %v", after, before+1)
+	}
+}
+
+func metricExtractionErrorsTotalValue(t *testing.T) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metricExtractionErrorsTotal.Write(&m); err != nil {
+		t.Fatalf("metricExtractionErrorsTotal.Write() = %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestParseMetricsConfigAcceptsConditionsType(t *testing.T) {
+	raw := []byte(`
+resources:
+- gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+  metrics:
+  - name: smcp_status_condition
+    help: Status conditions reported by the ServiceMeshControlPlane.
+    type: Conditions
+`)
+	cfg, err := ParseMetricsConfig(raw)
+	if err != nil {
+		t.Fatalf("ParseMetricsConfig() = %v", err)
+	}
+	if len(cfg.Resources) != 1 || len(cfg.Resources[0].Metrics) != 1 {
+		t.Fatalf("ParseMetricsConfig() = %+v, want exactly one resource with one metric", cfg)
+	}
+	if cfg.Resources[0].Metrics[0].Type != MetricConditions {
+		t.Errorf("Metrics[0].Type = %q, want %q", cfg.Resources[0].Metrics[0].Type, MetricConditions)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// TransitionCounterTracker maintains a monotonically increasing count of
+// observed field transitions per object, keyed by UID, for custom
+// ksmetric.FamilyGenerators that declare Type: ksmetric.Counter rather than
+// the Gauge every other generator in this package uses. A relist re-derives
+// every gauge value from scratch, which is fine for a Gauge but would reset
+// a Counter to whatever the current field value happens to be; a
+// TransitionCounterTracker instead remembers, across relists, how many
+// times it's seen the field change for a given UID, and only ever returns a
+// value at least as large as the last one it returned for that UID.
+//
+// Keying by UID rather than namespace/name is what makes a recreated object
+// restart its counter at zero instead of resuming the deleted object's
+// count: Kubernetes assigns a new UID on every create, so Observe has never
+// seen it before. Entries are removed only by Forget, which
+// trackerEvictingStore calls when its reflector deletes an object; an entry
+// for an object that drops out without a Delete (e.g. through a relist's
+// Replace), or held by a tracker no trackerEvictingStore wraps, is never
+// removed, so a long-running process that churns through many short-lived
+// objects can grow this map without bound.
+type TransitionCounterTracker struct {
+	mu     sync.Mutex
+	counts map[types.UID]float64
+	last   map[types.UID]string
+}
+
+// NewTransitionCounterTracker returns an empty TransitionCounterTracker.
+func NewTransitionCounterTracker() *TransitionCounterTracker {
+	return &TransitionCounterTracker{
+		counts: map[types.UID]float64{},
+		last:   map[types.UID]string{},
+	}
+}
+
+// Forget discards uid's tracked state, so a later Observe for a UID that
+// happens to be reused starts the transition count fresh rather than
+// resuming wherever the forgotten UID left off. Kubernetes never reuses a
+// UID across objects, so in practice this only matters for bounding the map
+// growth TransitionCounterTracker's own doc comment calls out: a caller that
+// knows an object has been deleted (e.g. a cache.Store wrapper observing a
+// Delete) can call Forget to reclaim that UID's entry instead of leaving it
+// behind forever.
+func (t *TransitionCounterTracker) Forget(uid types.UID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.counts, uid)
+	delete(t.last, uid)
+}
+
+// Observe records current as the latest value seen at uid and returns the
+// running transition count for uid: unchanged the first time uid is seen
+// (there's nothing to transition from yet), incremented by one whenever
+// current differs from the value passed to the previous Observe(uid, ...)
+// call, and left alone otherwise. current is compared with fmt.Sprint rather
+// than ==, since resolvePath can return a map or slice for a path pointing
+// at a composite field, and those aren't comparable with ==.
+func (t *TransitionCounterTracker) Observe(uid types.UID, current interface{}) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	value := fmt.Sprint(current)
+	if last, seen := t.last[uid]; seen && last != value {
+		t.counts[uid]++
+	}
+	t.last[uid] = value
+	return t.counts[uid]
+}
+
+// transitionCounterFamilyGenerator builds a Counter family that reports, via
+// tracker, how many times the value at path has changed across successive
+// relists of kind. path is resolved with resolvePath against each object in
+// turn; an object whose path doesn't resolve is treated like any other
+// value, so a field disappearing and reappearing counts as two transitions.
+func transitionCounterFamilyGenerator(kind, name, help, path string, tracker *TransitionCounterTracker) ksmetric.FamilyGenerator {
+	return ksmetric.FamilyGenerator{
+		Name: name,
+		Type: ksmetric.Counter,
+		Help: help,
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			value, _, err := resolvePath(crd.Object, path)
+			if err != nil {
+				log.Error(err, "Failed to resolve metric path", "path", path, "metric", name, "kind", kind)
+				return &ksmetric.Family{}
+			}
+
+			count := tracker.Observe(crd.GetUID(), value)
+			keys, values := []string{"namespace", "name"}, crdNameLabelValues(crd)
+			return &ksmetric.Family{
+				Metrics: []*ksmetric.Metric{
+					{
+						Value:       count,
+						LabelKeys:   keys,
+						LabelValues: values,
+					},
+				},
+			}
+		},
+	}
+}
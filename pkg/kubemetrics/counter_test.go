@@ -0,0 +1,110 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func statusObj(uid types.UID, name, phase string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"uid": string(uid), "name": name, "namespace": "istio-system"},
+		"status":   map[string]interface{}{"phase": phase},
+	}}
+}
+
+func TestTransitionCounterTrackerCountsOnlyActualChanges(t *testing.T) {
+	tracker := NewTransitionCounterTracker()
+
+	if got := tracker.Observe("uid-1", "Pending"); got != 0 {
+		t.Fatalf("Observe() first call = %v, want 0", got)
+	}
+	if got := tracker.Observe("uid-1", "Pending"); got != 0 {
+		t.Fatalf("Observe() unchanged value = %v, want 0", got)
+	}
+	if got := tracker.Observe("uid-1", "Running"); got != 1 {
+		t.Fatalf("Observe() after a transition = %v, want 1", got)
+	}
+	if got := tracker.Observe("uid-1", "Healthy"); got != 2 {
+		t.Fatalf("Observe() after a second transition = %v, want 2", got)
+	}
+}
+
+func TestTransitionCounterTrackerRestartsOnRecreate(t *testing.T) {
+	tracker := NewTransitionCounterTracker()
+	tracker.Observe("uid-1", "Pending")
+	tracker.Observe("uid-1", "Running")
+	if got := tracker.Observe("uid-1", "Healthy"); got != 2 {
+		t.Fatalf("Observe() = %v, want 2 before recreate", got)
+	}
+
+	// A recreated object gets a new UID, so its counter starts fresh
+	// rather than resuming uid-1's count.
+	if got := tracker.Observe("uid-2", "Pending"); got != 0 {
+		t.Fatalf("Observe() for a new UID = %v, want 0", got)
+	}
+}
+
+func TestTransitionCounterTrackerForgetRestartsACount(t *testing.T) {
+	tracker := NewTransitionCounterTracker()
+	tracker.Observe("uid-1", "Pending")
+	tracker.Observe("uid-1", "Running")
+
+	tracker.Forget("uid-1")
+
+	if got := tracker.Observe("uid-1", "Pending"); got != 0 {
+		t.Fatalf("Observe() after Forget() = %v, want 0, as if uid-1 had never been seen", got)
+	}
+}
+
+func TestTransitionCounterFamilyGeneratorEmitsCounterType(t *testing.T) {
+	tracker := NewTransitionCounterTracker()
+	gen := transitionCounterFamilyGenerator("IstioOperator", "istiooperator_status_phase_transitions_total",
+		"Number of times status.phase has changed.", "status.phase", tracker)
+
+	if gen.Type != ksmetric.Counter {
+		t.Fatalf("gen.Type = %v, want ksmetric.Counter", gen.Type)
+	}
+	if gen.Name != "istiooperator_status_phase_transitions_total" {
+		t.Fatalf("gen.Name = %q, want the _total-suffixed name passed in", gen.Name)
+	}
+}
+
+func TestTransitionCounterFamilyGeneratorCountsAcrossSimulatedRelists(t *testing.T) {
+	tracker := NewTransitionCounterTracker()
+	gen := transitionCounterFamilyGenerator("IstioOperator", "istiooperator_status_phase_transitions_total",
+		"Number of times status.phase has changed.", "status.phase", tracker)
+
+	relists := []string{"Pending", "Pending", "Reconciling", "Healthy", "Healthy"}
+	var lastCount float64
+	for _, phase := range relists {
+		family := gen.GenerateFunc(statusObj("uid-1", "example", phase))
+		if len(family.Metrics) != 1 {
+			t.Fatalf("GenerateFunc() = %+v, want one series", family.Metrics)
+		}
+		if family.Metrics[0].Value < lastCount {
+			t.Fatalf("Metrics[0].Value = %v, want it to never decrease from %v", family.Metrics[0].Value, lastCount)
+		}
+		lastCount = family.Metrics[0].Value
+	}
+	if lastCount != 2 {
+		t.Fatalf("final count = %v, want 2 transitions (Pending->Reconciling, Reconciling->Healthy)", lastCount)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// defaultDiscoveryResync is how often getAPIResourceLists is re-run to pick
+// up newly installed CRD versions when the caller doesn't set
+// DiscoveryOptions.ResyncPeriod.
+const defaultDiscoveryResync = 30 * time.Second
+
+// crdGVR is the GVR of the CustomResourceDefinition type itself, used to
+// watch for CRDs coming and going on the cluster.
+var crdGVR = apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions")
+
+// wildcardKey identifies a wildcard entry from operatorGVKs, used to key the
+// cache of GVKs it has been expanded to.
+type wildcardKey struct {
+	group          string
+	versionPattern string
+	kindPattern    string
+}
+
+// gvkDiscoverer watches CustomResourceDefinitions and expands any wildcard
+// GVKs passed to GenerateAndServeCRMetricsWithOptions into concrete GVKs,
+// keeping registry in sync as CRDs are added, updated and removed.
+type gvkDiscoverer struct {
+	cfg           *rest.Config
+	ns            []string
+	wildcards     []schema.GroupVersionKind
+	registry      *storeRegistry
+	resync              time.Duration
+	metricsConfig       *MetricsConfig
+	gvkOptions          map[schema.GroupVersionKind]GVKOptions
+	infoMetricLabelKeys []string
+	maxResourcesPerGVK  int
+
+	// metricNamePrefix is forwarded to generateMetricFamilies for every GVK
+	// this discoverer builds stores for. Set directly on the returned
+	// gvkDiscoverer by GenerateAndServeCRMetricsWithOptions, the same way
+	// CRMetricsManager.metricNamePrefix is; see DiscoveryOptions.MetricNamePrefix.
+	metricNamePrefix string
+
+	// groupQualifiedMetricNames is forwarded to generateMetricFamilies for
+	// every GVK this discoverer builds stores for. Set directly on the
+	// returned gvkDiscoverer the same way metricNamePrefix is; see
+	// DiscoveryOptions.GroupQualifiedMetricNames.
+	groupQualifiedMetricNames bool
+
+	// nameLabelMode is forwarded to generateMetricFamilies for every GVK
+	// this discoverer builds stores for. Set directly on the returned
+	// gvkDiscoverer the same way metricNamePrefix is; see
+	// DiscoveryOptions.NameLabelMode.
+	nameLabelMode NameLabelMode
+
+	// constLabels is forwarded to applyGVKOptions for every GVK this
+	// discoverer builds stores for, which appends it to every sample. Set
+	// directly on the returned gvkDiscoverer the same way metricNamePrefix
+	// is; see DiscoveryOptions.ConstLabels.
+	constLabels map[string]string
+
+	// reconcileMu serializes resolveAll end-to-end (not just the diff
+	// computation) so the resync-ticker goroutine (Run), the informer
+	// event-handler goroutine (handleCRDEvent) and a config reload
+	// (CRMetricsManager.Reload, which forces a rebuild of every matched GVK)
+	// can never run it concurrently; without that, two overlapping runs can
+	// both build stores for the same GVK, wasting the discarded one's
+	// list/watch setup before registry.set stops it in favor of whichever
+	// call's set() lands last. It also guards metricsConfig so
+	// SetMetricsConfig can't race a resolveAll that's reading it.
+	reconcileMu sync.Mutex
+	resolved    map[wildcardKey]map[schema.GroupVersionKind]struct{}
+
+	informer cache.SharedIndexInformer
+}
+
+func newGVKDiscoverer(cfg *rest.Config, ns []string, wildcards []schema.GroupVersionKind,
+	registry *storeRegistry, resync time.Duration, metricsConfig *MetricsConfig,
+	gvkOptions map[schema.GroupVersionKind]GVKOptions, infoMetricLabelKeys []string,
+	maxResourcesPerGVK int) (*gvkDiscoverer, error) {
+	dclient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dclient, resync)
+	informer := factory.ForResource(crdGVR).Informer()
+
+	d := &gvkDiscoverer{
+		cfg:                 cfg,
+		ns:                  ns,
+		wildcards:           wildcards,
+		registry:            registry,
+		resync:              resync,
+		metricsConfig:       metricsConfig,
+		gvkOptions:          gvkOptions,
+		infoMetricLabelKeys: infoMetricLabelKeys,
+		maxResourcesPerGVK:  maxResourcesPerGVK,
+		resolved:            make(map[wildcardKey]map[schema.GroupVersionKind]struct{}),
+		informer:            informer,
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { d.handleCRDEvent(obj) },
+		UpdateFunc: func(_, obj interface{}) { d.handleCRDEvent(obj) },
+		DeleteFunc: func(obj interface{}) { d.handleCRDEvent(obj) },
+	})
+
+	return d, nil
+}
+
+// Run starts the CRD watch and, in parallel, periodically re-resolves the
+// wildcard GVKs against a fresh server API resource discovery so that a
+// newly installed CRD version is picked up without an operator restart. Both
+// loops stop when ctx is cancelled; the informer's list/watch is torn down
+// the same way cache.SharedIndexInformer.Run always stops, via its stopCh.
+func (d *gvkDiscoverer) Run(ctx context.Context) {
+	go d.informer.Run(ctx.Done())
+
+	ticker := time.NewTicker(d.resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.resolveAll(false)
+		}
+	}
+}
+
+// handleCRDEvent re-resolves every wildcard GVK whenever any CRD changes.
+// A single CRD add/update/delete can affect which concrete GVKs several
+// wildcard entries expand to (e.g. two entries sharing a group), so the
+// whole set is recomputed rather than trying to reason about just the one
+// object.
+func (d *gvkDiscoverer) handleCRDEvent(obj interface{}) {
+	d.resolveAll(false)
+}
+
+// resolveAll recomputes the concrete GVKs matched by every wildcard entry
+// and updates the registry accordingly, creating stores for newly
+// discovered GVKs and tearing down stores for GVKs that no longer match
+// (e.g. because their CRD was deleted) — registry.remove stops each
+// removed GVK's reflector rather than leaving it running indefinitely
+// against a resource that no longer exists.
+// force rebuilds the stores for every currently-matched GVK even if it was
+// already resolved, which CRMetricsManager.Reload relies on to push a config
+// change into already-discovered GVKs that the CRD set itself didn't change;
+// the resync ticker (Run) and CRD watch events (handleCRDEvent) pass false
+// since they only care about GVKs entering or leaving the matched set.
+// The whole reconciliation runs under reconcileMu: it can be triggered by
+// the resync ticker, CRD watch events and Reload concurrently, and letting
+// two runs overlap would waste effort building stores for the same GVK
+// twice, with registry.set stopping and discarding whichever one loses the
+// race.
+func (d *gvkDiscoverer) resolveAll(force bool) {
+	d.reconcileMu.Lock()
+	defer d.reconcileMu.Unlock()
+
+	apiResourceLists, err := getAPIResourceLists(d.cfg)
+	if err != nil {
+		log.Error(err, "Failed to refresh API resource lists for wildcard GVK discovery")
+		return
+	}
+
+	for _, wc := range d.wildcards {
+		key := wildcardKey{group: wc.Group, versionPattern: wc.Version, kindPattern: wc.Kind}
+		matched := expandWildcard(wc, apiResourceLists)
+
+		previous := d.resolved[key]
+		current := make(map[schema.GroupVersionKind]struct{}, len(matched))
+		for _, gvk := range matched {
+			current[gvk] = struct{}{}
+		}
+		d.resolved[key] = current
+
+		for gvk := range current {
+			if _, ok := previous[gvk]; ok && !force {
+				continue
+			}
+			stores, err := buildStoresForGVK(d.cfg, apiResourceLists, gvk, d.ns, d.metricsConfig, d.gvkOptions[wc], d.infoMetricLabelKeys, d.maxResourcesPerGVK, d.metricNamePrefix, d.groupQualifiedMetricNames, d.nameLabelMode, d.constLabels)
+			if err != nil {
+				log.Error(err, "Failed to build metrics stores for discovered GVK", "gvk", gvk.String())
+				continue
+			}
+			if _, alreadyMatched := previous[gvk]; alreadyMatched {
+				log.V(1).Info("Rebuilt stores for already-matched GVK on config reload", "gvk", gvk.String())
+			} else {
+				log.V(1).Info("Discovered new GVK matching wildcard", "gvk", gvk.String())
+			}
+			d.registry.set(gvk, stores)
+		}
+		for gvk := range previous {
+			if _, ok := current[gvk]; ok {
+				continue
+			}
+			log.V(1).Info("GVK no longer matches wildcard, stopping and removing stores", "gvk", gvk.String())
+			d.registry.remove(gvk)
+		}
+	}
+}
+
+// SetMetricsConfig updates the metrics configuration used to build stores on
+// the next resolveAll. CRMetricsManager.Reload always follows this with a
+// forced resolveAll so every currently-matched GVK is rebuilt against the new
+// config, not just ones the next CRD-driven diff happens to touch — that's
+// what makes the hot-reload apply to wildcard-discovered GVKs the same as
+// statically-registered ones.
+func (d *gvkDiscoverer) SetMetricsConfig(metricsConfig *MetricsConfig) {
+	d.reconcileMu.Lock()
+	d.metricsConfig = metricsConfig
+	d.reconcileMu.Unlock()
+}
+
+// expandWildcard returns every concrete GVK in apiResourceLists matching
+// wc's group exactly and its Version/Kind patterns, where "*" matches
+// anything.
+func expandWildcard(wc schema.GroupVersionKind, apiResourceLists []*metav1.APIResourceList) []schema.GroupVersionKind {
+	var matched []schema.GroupVersionKind
+	for _, resourceList := range apiResourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil || gv.Group != wc.Group {
+			continue
+		}
+		if wc.Version != wildcard && gv.Version != wc.Version {
+			continue
+		}
+		for _, apiResource := range resourceList.APIResources {
+			if wc.Kind != wildcard && apiResource.Kind != wc.Kind {
+				continue
+			}
+			matched = append(matched, gv.WithKind(apiResource.Kind))
+		}
+	}
+	return matched
+}
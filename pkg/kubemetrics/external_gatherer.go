@@ -0,0 +1,72 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// mergeExternalFamilies appends every family gatherer.Gather() returns to
+// buf, encoded the same way expfmt would write them for any other
+// Gatherer-backed /metrics endpoint, so their HELP/TYPE lines come through
+// unchanged. gatherer.Gather() already returns families sorted by name, so
+// appending them in that order keeps the merged scrape's family ordering
+// deterministic across requests. A family whose name was already written by
+// one of our own stores is skipped and logged, rather than emitting the same
+// family name twice, which Prometheus's own parser rejects as invalid.
+func mergeExternalFamilies(buf *bytes.Buffer, gatherer prometheus.Gatherer) error {
+	existing := collectFamilyNames(buf.Bytes())
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	encoder := expfmt.NewEncoder(buf, expfmt.FmtText)
+	for _, family := range families {
+		if _, dup := existing[family.GetName()]; dup {
+			log.V(1).Info("Skipping metric family already served by CR metrics to avoid a duplicate", "family", family.GetName())
+			continue
+		}
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectFamilyNames scans data for "# TYPE <name> <type>" lines and returns
+// the set of family names they declare, so mergeExternalFamilies can tell
+// which family names are already spoken for before appending more.
+func collectFamilyNames(data []byte) map[string]struct{} {
+	names := map[string]struct{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 3 {
+			names[fields[2]] = struct{}{}
+		}
+	}
+	return names
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var errGatherFailed = errors.New("gather failed")
+
+func TestStoreRegistryServeHTTPMergesExternalGatherer(t *testing.T) {
+	registry := virtualServiceRegistryForGzipTests(t, 1)
+
+	extra := prometheus.NewRegistry()
+	workqueueDepth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "workqueue_depth", Help: "Current depth of workqueue."})
+	workqueueDepth.Set(3)
+	extra.MustRegister(workqueueDepth)
+	registry.extraGatherer = extra
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "# TYPE workqueue_depth gauge") {
+		t.Fatalf("response missing merged family's TYPE line; body:\n%s", body)
+	}
+	if !strings.Contains(body, "workqueue_depth 3") {
+		t.Fatalf("response missing merged family's sample; body:\n%s", body)
+	}
+	if !strings.Contains(body, "# TYPE virtualservice_info gauge") {
+		t.Fatalf("response lost our own families after merging; body:\n%s", body)
+	}
+}
+
+func TestStoreRegistryServeHTTPSkipsDuplicateFamilyFromExternalGatherer(t *testing.T) {
+	registry := virtualServiceRegistryForGzipTests(t, 1)
+
+	extra := prometheus.NewRegistry()
+	dup := prometheus.NewGauge(prometheus.GaugeOpts{Name: "virtualservice_info", Help: "Conflicts with our own family."})
+	extra.MustRegister(dup)
+	registry.extraGatherer = extra
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if strings.Count(body, "# TYPE virtualservice_info gauge") != 1 {
+		t.Fatalf("virtualservice_info family was emitted more than once; body:\n%s", body)
+	}
+}
+
+func TestCollectFamilyNames(t *testing.T) {
+	data := []byte("# HELP foo_total A counter.\n# TYPE foo_total counter\nfoo_total 1\n# TYPE bar_info gauge\nbar_info{label=\"x\"} 1\n")
+	names := collectFamilyNames(data)
+	for _, want := range []string{"foo_total", "bar_info"} {
+		if _, ok := names[want]; !ok {
+			t.Errorf("collectFamilyNames() missing %q; got %v", want, names)
+		}
+	}
+	if len(names) != 2 {
+		t.Errorf("collectFamilyNames() = %v, want exactly 2 entries", names)
+	}
+}
+
+func TestMergeExternalFamiliesPropagatesGatherError(t *testing.T) {
+	var buf bytes.Buffer
+	err := mergeExternalFamilies(&buf, failingGatherer{})
+	if err == nil {
+		t.Fatal("mergeExternalFamilies() = nil error, want the Gatherer's error")
+	}
+}
+
+type failingGatherer struct{}
+
+func (failingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	return nil, errGatherFailed
+}
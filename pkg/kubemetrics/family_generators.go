@@ -0,0 +1,60 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// FamilyGeneratorRegistry holds the ksmetric.FamilyGenerators registered per
+// GVK via RegisterFamilyGenerator. buildStoresForGVK consults it ahead of the
+// built-in "<kind>_info" gauge generateMetricFamilies produces: a GVK with
+// one or more registered generators uses exactly those instead, so operators
+// who still want an info gauge alongside custom metrics like
+// "<kind>_created_timestamp_seconds" or "<kind>_generation" must register one
+// themselves.
+type FamilyGeneratorRegistry struct {
+	mu         sync.RWMutex
+	generators map[schema.GroupVersionKind][]ksmetric.FamilyGenerator
+}
+
+// defaultFamilyGenerators is the process-wide registry RegisterFamilyGenerator
+// populates and buildStoresForGVK reads from.
+var defaultFamilyGenerators = &FamilyGeneratorRegistry{
+	generators: map[schema.GroupVersionKind][]ksmetric.FamilyGenerator{},
+}
+
+// RegisterFamilyGenerator adds gen to the metric families generated for gvk.
+// It must be called before GenerateAndServeCRMetrics(WithOptions) starts
+// reflecting gvk, since buildStoresForGVK reads the registry once, at store
+// construction time; registering a generator after that point has no effect
+// until the GVK's stores are rebuilt, e.g. via CRMetricsManager.Reload.
+func RegisterFamilyGenerator(gvk schema.GroupVersionKind, gen ksmetric.FamilyGenerator) {
+	defaultFamilyGenerators.mu.Lock()
+	defer defaultFamilyGenerators.mu.Unlock()
+	defaultFamilyGenerators.generators[gvk] = append(defaultFamilyGenerators.generators[gvk], gen)
+}
+
+// familyGeneratorsFor returns the generators registered for gvk, if any.
+func (r *FamilyGeneratorRegistry) familyGeneratorsFor(gvk schema.GroupVersionKind) ([]ksmetric.FamilyGenerator, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	gens, ok := r.generators[gvk]
+	return gens, ok
+}
@@ -0,0 +1,795 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+)
+
+func TestRegisterFamilyGeneratorReplacesDefaultInfoGauge(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "TestRegisterFamilyGenerator"}
+	defer func() {
+		defaultFamilyGenerators.mu.Lock()
+		delete(defaultFamilyGenerators.generators, gvk)
+		defaultFamilyGenerators.mu.Unlock()
+	}()
+
+	if _, ok := defaultFamilyGenerators.familyGeneratorsFor(gvk); ok {
+		t.Fatalf("familyGeneratorsFor(%v) found generators before any were registered", gvk)
+	}
+
+	RegisterFamilyGenerator(gvk, ksmetric.FamilyGenerator{Name: "testregisterfamilygenerator_generation"})
+	RegisterFamilyGenerator(gvk, ksmetric.FamilyGenerator{Name: "testregisterfamilygenerator_created_timestamp_seconds"})
+
+	gens, ok := defaultFamilyGenerators.familyGeneratorsFor(gvk)
+	if !ok {
+		t.Fatalf("familyGeneratorsFor(%v) = false, want true after registering", gvk)
+	}
+	if len(gens) != 2 {
+		t.Fatalf("len(gens) = %d, want 2", len(gens))
+	}
+	if gens[0].Name != "testregisterfamilygenerator_generation" || gens[1].Name != "testregisterfamilygenerator_created_timestamp_seconds" {
+		t.Fatalf("gens = %+v, want the two registered generators in registration order", gens)
+	}
+}
+
+// memberCountFamilyGenerator returns an example custom generator of the kind
+// GVKOptions.FamilyGenerators accepts: one that extracts a value from an
+// unstructured status field rather than emitting the fixed "<kind>_info"
+// gauge.
+func memberCountFamilyGenerator() ksmetric.FamilyGenerator {
+	return ksmetric.FamilyGenerator{
+		Name: "servicemeshcontrolplane_member_count",
+		Type: ksmetric.Gauge,
+		Help: "Number of namespaces joined to this ServiceMeshControlPlane.",
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			count, _, _ := unstructured.NestedInt64(crd.Object, "status", "memberCount")
+			return &ksmetric.Family{
+				Metrics: []*ksmetric.Metric{
+					{Value: float64(count), LabelKeys: []string{"namespace", "servicemeshcontrolplane"}, LabelValues: []string{crd.GetNamespace(), crd.GetName()}},
+				},
+			}
+		},
+	}
+}
+
+func TestFamilyGeneratorsForGVKUsesGVKOptionsFamilyGeneratorsOverDefault(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "maistra.io", Version: "v2", Kind: "ServiceMeshControlPlane"}
+	gvkOpts := GVKOptions{FamilyGenerators: []ksmetric.FamilyGenerator{memberCountFamilyGenerator()}}
+
+	gens := familyGeneratorsForGVK(gvk, gvk.Kind, gvkOpts, nil, "", false, NameLabelModeKind, true)
+	if len(gens) != 1 || gens[0].Name != "servicemeshcontrolplane_member_count" {
+		t.Fatalf("familyGeneratorsForGVK() = %+v, want only the GVKOptions.FamilyGenerators entry", gens)
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status":   map[string]interface{}{"memberCount": int64(3)},
+	}}
+	family := gens[0].GenerateFunc(obj)
+	if len(family.Metrics) != 1 || family.Metrics[0].Value != 3 {
+		t.Fatalf("GenerateFunc(obj) = %+v, want a single metric with value 3", family.Metrics)
+	}
+	if got, want := family.Metrics[0].LabelValues, []string{"istio-system", "basic"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("LabelValues = %v, want %v", got, want)
+	}
+}
+
+func TestFamilyGeneratorsForGVKFallsBackToRegisteredGenerators(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "TestFamilyGeneratorsForGVKFallback"}
+	defer func() {
+		defaultFamilyGenerators.mu.Lock()
+		delete(defaultFamilyGenerators.generators, gvk)
+		defaultFamilyGenerators.mu.Unlock()
+	}()
+	RegisterFamilyGenerator(gvk, ksmetric.FamilyGenerator{Name: "registered"})
+
+	gens := familyGeneratorsForGVK(gvk, gvk.Kind, GVKOptions{}, nil, "", false, NameLabelModeKind, true)
+	if len(gens) != 1 || gens[0].Name != "registered" {
+		t.Fatalf("familyGeneratorsForGVK() = %+v, want the registered generator when GVKOptions.FamilyGenerators is unset", gens)
+	}
+}
+
+func TestFamilyGeneratorsForGVKFallsBackToInfoGauge(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "TestFamilyGeneratorsForGVKInfoFallback"}
+
+	gens := familyGeneratorsForGVK(gvk, gvk.Kind, GVKOptions{}, nil, "", false, NameLabelModeKind, true)
+	if len(gens) != 2 || gens[0].Name != "testfamilygeneratorsforgvkinfofallback_info" {
+		t.Fatalf("familyGeneratorsForGVK() = %+v, want the default info gauge (plus its created-timestamp counterpart) when nothing else is configured", gens)
+	}
+	if gens[1].Name != "testfamilygeneratorsforgvkinfofallback_created" {
+		t.Fatalf("familyGeneratorsForGVK() = %+v, want a created-timestamp gauge alongside the info gauge", gens)
+	}
+}
+
+func TestFamilyGeneratorsForGVKAddsGenerationDriftGauges(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "TestFamilyGeneratorsForGVKGenerationDrift"}
+
+	gens := familyGeneratorsForGVK(gvk, gvk.Kind, GVKOptions{GenerationDriftMetrics: true}, nil, "", false, NameLabelModeKind, true)
+	if len(gens) != 4 {
+		t.Fatalf("familyGeneratorsForGVK() = %+v, want 4 families (info, created, metadata_generation, status_observed_generation)", gens)
+	}
+	if gens[2].Name != "testfamilygeneratorsforgvkgenerationdrift_metadata_generation" {
+		t.Fatalf("familyGeneratorsForGVK()[2].Name = %q, want the metadata_generation gauge", gens[2].Name)
+	}
+	if gens[3].Name != "testfamilygeneratorsforgvkgenerationdrift_status_observed_generation" {
+		t.Fatalf("familyGeneratorsForGVK()[3].Name = %q, want the status_observed_generation gauge", gens[3].Name)
+	}
+}
+
+func TestFamilyGeneratorsForGVKAppliesMetricNamePrefix(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "TestFamilyGeneratorsForGVKPrefix"}
+
+	gens := familyGeneratorsForGVK(gvk, gvk.Kind, GVKOptions{}, nil, "istio_operator", false, NameLabelModeKind, true)
+	if len(gens) != 2 || gens[0].Name != "istio_operator_testfamilygeneratorsforgvkprefix_info" {
+		t.Fatalf("familyGeneratorsForGVK() = %+v, want the info gauge's name prefixed", gens)
+	}
+	if gens[1].Name != "istio_operator_testfamilygeneratorsforgvkprefix_created" {
+		t.Fatalf("familyGeneratorsForGVK() = %+v, want the created gauge's name prefixed too", gens)
+	}
+}
+
+func TestGenerateMetricFamiliesAppliesPrefixToHELPAndTYPELinesAndSampleNames(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "istio_operator", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	wantName := "istio_operator_virtualservice_info"
+	if !strings.Contains(out, "# HELP "+wantName+" ") {
+		t.Fatalf("exposition output = %q, want a HELP line for %q", out, wantName)
+	}
+	if !strings.Contains(out, "# TYPE "+wantName+" gauge") {
+		t.Fatalf("exposition output = %q, want a TYPE line for %q", out, wantName)
+	}
+	if !strings.Contains(out, wantName+`{namespace="istio-system",virtualservice="basic"} 1`) {
+		t.Fatalf("exposition output = %q, want a sample named %q", out, wantName)
+	}
+}
+
+func TestGenerateMetricFamiliesOmitsNamespaceLabelForClusterScopedGVK(t *testing.T) {
+	familyGenerators := generateMetricFamilies("MeshConfig", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, false, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "default"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if strings.Contains(out, "namespace=") {
+		t.Fatalf("exposition output = %q, want no namespace label for a cluster-scoped GVK", out)
+	}
+	if !strings.Contains(out, `meshconfig_info{meshconfig="default"} 1`) {
+		t.Fatalf("exposition output = %q, want a namespace-less sample", out)
+	}
+}
+
+func TestGenerateMetricFamiliesIncludesNamespaceLabelForNamespacedGVK(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `virtualservice_info{namespace="istio-system",virtualservice="basic"} 1`) {
+		t.Fatalf("exposition output = %q, want the namespace label for a namespaced GVK", out)
+	}
+}
+
+func TestGenerateMetricFamiliesUsesInfoHelpOverrideWhenSet(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "Number of mesh VirtualServices currently registered with istiod.")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	wantHelp := "# HELP virtualservice_info Number of mesh VirtualServices currently registered with istiod."
+	if !strings.Contains(out, wantHelp) {
+		t.Fatalf("exposition output = %q, want the HELP line %q", out, wantHelp)
+	}
+}
+
+func TestGenerateMetricFamiliesDefaultsInfoHelpWhenUnset(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	if familyGenerators[0].Help != "Information about the VirtualService custom resource." {
+		t.Fatalf("familyGenerators[0].Help = %q, want the default boilerplate when no override is set", familyGenerators[0].Help)
+	}
+}
+
+func TestGenerateMetricFamiliesEmitsCreatedGaugeWithCreationTimestamp(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":              "basic",
+			"namespace":         "istio-system",
+			"creationTimestamp": "2024-01-01T00:00:00Z",
+		},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	wantName := "virtualservice_created"
+	if !strings.Contains(out, "# TYPE "+wantName+" gauge") {
+		t.Fatalf("exposition output = %q, want a TYPE line for %q", out, wantName)
+	}
+	if !strings.Contains(out, wantName+`{namespace="istio-system",virtualservice="basic"} 1704067200`) {
+		t.Fatalf("exposition output = %q, want a sample named %q with the Unix creation timestamp", out, wantName)
+	}
+}
+
+func TestGenerateMetricFamiliesOmitsCreatedSampleForZeroTimestamp(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if strings.Contains(out, "virtualservice_created{") {
+		t.Fatalf("exposition output = %q, want no virtualservice_created sample for an object with no creationTimestamp", out)
+	}
+}
+
+func TestGenerateMetricFamiliesEmitsGenerationDriftGaugesWhenEnabled(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", true, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system", "generation": int64(3)},
+		"status":   map[string]interface{}{"observedGeneration": int64(2)},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `virtualservice_metadata_generation{namespace="istio-system",virtualservice="basic"} 3`) {
+		t.Fatalf("exposition output = %q, want a virtualservice_metadata_generation sample of 3", out)
+	}
+	if !strings.Contains(out, `virtualservice_status_observed_generation{namespace="istio-system",virtualservice="basic"} 2`) {
+		t.Fatalf("exposition output = %q, want a virtualservice_status_observed_generation sample of 2", out)
+	}
+}
+
+func TestGenerateMetricFamiliesOmitsObservedGenerationSampleWhenAbsent(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", true, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system", "generation": int64(1)},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `virtualservice_metadata_generation{namespace="istio-system",virtualservice="basic"} 1`) {
+		t.Fatalf("exposition output = %q, want a virtualservice_metadata_generation sample even without status.observedGeneration", out)
+	}
+	if strings.Contains(out, "virtualservice_status_observed_generation{") {
+		t.Fatalf("exposition output = %q, want no virtualservice_status_observed_generation sample for an object with no status.observedGeneration", out)
+	}
+}
+
+func TestGenerateMetricFamiliesOmitsObservedGenerationSampleWhenNonNumeric(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", true, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system", "generation": int64(1)},
+		"status":   map[string]interface{}{"observedGeneration": "not-a-number"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if strings.Contains(out, "virtualservice_status_observed_generation{") {
+		t.Fatalf("exposition output = %q, want no virtualservice_status_observed_generation sample for a non-numeric value", out)
+	}
+}
+
+func TestGenerateMetricFamiliesOmitsGenerationDriftGaugesWhenDisabled(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	if len(familyGenerators) != 2 {
+		t.Fatalf("len(familyGenerators) = %d, want 2 (info, created) when generationDrift is false", len(familyGenerators))
+	}
+}
+
+func TestGenerateMetricFamiliesDeletionTimestampSampleAppearsThenVanishes(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, true, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":              "basic",
+			"namespace":         "istio-system",
+			"deletionTimestamp": "2024-01-01T00:00:00Z",
+		},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	wantName := "virtualservice_deletion_timestamp"
+	if !strings.Contains(out, "# TYPE "+wantName+" gauge") {
+		t.Fatalf("exposition output = %q, want a TYPE line for %q", out, wantName)
+	}
+	if !strings.Contains(out, wantName+`{namespace="istio-system",virtualservice="basic"} 1704067200`) {
+		t.Fatalf("exposition output = %q, want a sample named %q with the Unix deletionTimestamp", out, wantName)
+	}
+
+	store.Delete(obj)
+
+	buf.Reset()
+	store.WriteAll(&buf)
+	out = buf.String()
+	if strings.Contains(out, wantName+"{") {
+		t.Fatalf("exposition output = %q, want no %s sample once the object is removed from the store", out, wantName)
+	}
+}
+
+func TestGenerateMetricFamiliesOmitsDeletionTimestampSampleWhenNotTerminating(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, true, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if strings.Contains(out, "virtualservice_deletion_timestamp{") {
+		t.Fatalf("exposition output = %q, want no virtualservice_deletion_timestamp sample for an object with no deletionTimestamp", out)
+	}
+}
+
+func TestFamilyGeneratorsForGVKAddsDeletionTimestampGauge(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "TestFamilyGeneratorsForGVKDeletionTimestamp"}
+
+	gens := familyGeneratorsForGVK(gvk, gvk.Kind, GVKOptions{DeletionTimestampMetric: true}, nil, "", false, NameLabelModeKind, true)
+	if len(gens) != 3 {
+		t.Fatalf("familyGeneratorsForGVK() = %+v, want 3 families (info, created, deletion_timestamp)", gens)
+	}
+	if gens[2].Name != "testfamilygeneratorsforgvkdeletiontimestamp_deletion_timestamp" {
+		t.Fatalf("familyGeneratorsForGVK()[2].Name = %q, want the deletion_timestamp gauge", gens[2].Name)
+	}
+}
+
+func TestGenerateMetricFamiliesOwnerReferenceLabelsZeroOwners(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, true, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `owner_kind="",owner_name=""`) {
+		t.Fatalf("exposition output = %q, want empty owner_kind/owner_name labels for an object with no owner references", out)
+	}
+}
+
+func TestGenerateMetricFamiliesOwnerReferenceLabelsSingleOwner(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, true, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "basic",
+			"namespace": "istio-system",
+			"ownerReferences": []interface{}{
+				map[string]interface{}{"kind": "Gateway", "name": "ingress", "controller": true},
+			},
+		},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `owner_kind="Gateway",owner_name="ingress"`) {
+		t.Fatalf("exposition output = %q, want owner_kind/owner_name taken from the sole owner reference", out)
+	}
+}
+
+func TestGenerateMetricFamiliesOwnerReferenceLabelsMultipleOwnersPrefersController(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, true, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "basic",
+			"namespace": "istio-system",
+			"ownerReferences": []interface{}{
+				map[string]interface{}{"kind": "Namespace", "name": "first"},
+				map[string]interface{}{"kind": "Gateway", "name": "owner", "controller": true},
+				map[string]interface{}{"kind": "Namespace", "name": "last"},
+			},
+		},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `owner_kind="Gateway",owner_name="owner"`) {
+		t.Fatalf("exposition output = %q, want owner_kind/owner_name taken from the owner reference marked as controller, not the first in the list", out)
+	}
+}
+
+func TestGenerateMetricFamiliesOwnerReferenceLabelsMultipleOwnersNoControllerPicksFirst(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, true, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "basic",
+			"namespace": "istio-system",
+			"ownerReferences": []interface{}{
+				map[string]interface{}{"kind": "Namespace", "name": "first"},
+				map[string]interface{}{"kind": "Namespace", "name": "second"},
+			},
+		},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `owner_kind="Namespace",owner_name="first"`) {
+		t.Fatalf("exposition output = %q, want owner_kind/owner_name to deterministically pick the first owner reference when none is marked as the controller", out)
+	}
+}
+
+func TestGenerateMetricFamiliesUIDLabelDisabledByDefault(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system", "uid": "11111111-1111-1111-1111-111111111111"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if strings.Contains(out, "uid=") {
+		t.Fatalf("exposition output = %q, want no uid label when uidLabel is false", out)
+	}
+}
+
+func TestGenerateMetricFamiliesUIDLabelAppendsMetadataUID(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, true, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system", "uid": "11111111-1111-1111-1111-111111111111"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `uid="11111111-1111-1111-1111-111111111111"`) {
+		t.Fatalf("exposition output = %q, want a uid label taken from metadata.uid when uidLabel is true", out)
+	}
+}
+
+func TestGenerateMetricFamiliesSpecHashLabelAppendsSpecHash(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, true, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"spec":     map[string]interface{}{"host": "example.com"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	want := SpecHash(obj.Object["spec"])
+	if !strings.Contains(out, `spec_hash="`+want+`"`) {
+		t.Fatalf("exposition output = %q, want a spec_hash label equal to SpecHash(spec) = %q when specHashLabel is true", out, want)
+	}
+}
+
+func TestGenerateMetricFamiliesNoSpecHashLabelWhenDisabled(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"spec":     map[string]interface{}{"host": "example.com"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if strings.Contains(out, "spec_hash=") {
+		t.Fatalf("exposition output = %q, want no spec_hash label when specHashLabel is false", out)
+	}
+}
+
+func TestGenerateMetricFamiliesRevisionLabelPrefersTheWellKnownLabelOverSpecRevision(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, true, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "basic", "namespace": "istio-system",
+			"labels": map[string]interface{}{"istio.io/rev": "canary"},
+		},
+		"spec": map[string]interface{}{"revision": "stable"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `revision="canary"`) {
+		t.Fatalf("exposition output = %q, want a revision label taken from istio.io/rev over spec.revision when revisionLabel is true", out)
+	}
+}
+
+func TestGenerateMetricFamiliesRevisionLabelFallsBackToSpecRevision(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, true, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"spec":     map[string]interface{}{"revision": "stable"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `revision="stable"`) {
+		t.Fatalf("exposition output = %q, want a revision label taken from spec.revision when istio.io/rev is absent", out)
+	}
+}
+
+func TestGenerateMetricFamiliesRevisionLabelEmptyWhenNeitherSourceIsSet(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, true, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `revision=""`) {
+		t.Fatalf("exposition output = %q, want an empty revision label when neither istio.io/rev nor spec.revision is set", out)
+	}
+}
+
+func TestGenerateMetricFamiliesNoRevisionLabelWhenDisabled(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "basic", "namespace": "istio-system",
+			"labels": map[string]interface{}{"istio.io/rev": "canary"},
+		},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if strings.Contains(out, "revision=") {
+		t.Fatalf("exposition output = %q, want no revision label when revisionLabel is false", out)
+	}
+}
+
+// TestGenerateMetricFamiliesVersionAndGroupLabelsDistinguishStoresPerGVK
+// builds two stores for the same kind at different versions, the way
+// buildStoresForGVK builds one managedStore per GVK a wildcard discovery
+// matches, and asserts their "<kind>_info" series carry different
+// version/group label values rather than colliding into one series.
+func TestGenerateMetricFamiliesVersionAndGroupLabelsDistinguishStoresPerGVK(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+
+	v1Generators := generateMetricFamilies("ServiceMeshControlPlane", nil, "", "", false, false, false, false, false, false, "v1", "maistra.io", NameLabelModeKind, true, "")
+	v1Store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(v1Generators),
+		ksmetric.ComposeMetricGenFuncs(v1Generators),
+	)
+	v1Store.Add(obj)
+	var v1Buf strings.Builder
+	v1Store.WriteAll(&v1Buf)
+
+	v2Generators := generateMetricFamilies("ServiceMeshControlPlane", nil, "", "", false, false, false, false, false, false, "v2", "maistra.io", NameLabelModeKind, true, "")
+	v2Store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(v2Generators),
+		ksmetric.ComposeMetricGenFuncs(v2Generators),
+	)
+	v2Store.Add(obj)
+	var v2Buf strings.Builder
+	v2Store.WriteAll(&v2Buf)
+
+	if !strings.Contains(v1Buf.String(), `version="v1",group="maistra.io"`) {
+		t.Fatalf("v1 exposition output = %q, want version=\"v1\"", v1Buf.String())
+	}
+	if !strings.Contains(v2Buf.String(), `version="v2",group="maistra.io"`) {
+		t.Fatalf("v2 exposition output = %q, want version=\"v2\"", v2Buf.String())
+	}
+	if v1Buf.String() == v2Buf.String() {
+		t.Fatalf("v1 and v2 exposition output are identical, want distinguishable series per version")
+	}
+}
+
+func TestGenerateMetricFamiliesVersionAndGroupLabelsDefaultToEmpty(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `version="",group=""`) {
+		t.Fatalf("exposition output = %q, want empty version/group labels rather than the labels being omitted, so existing queries keep matching", out)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// istiodPodSelector matches every istiod pod in a namespace, the same
+// "app=istiod" label createCanaryIstiodDeployment and istiodIngressPorts'
+// NetworkPolicy already assume.
+const istiodPodSelector = "app=istiod"
+
+// istiodMetricsPortName is the name istiod gives the container port its
+// /metrics endpoint listens on. Resolved by name rather than hardcoded to
+// 15014 so a custom IstioOperator spec that moves the port still federates
+// correctly.
+const istiodMetricsPortName = "http-monitoring"
+
+// istiodMetricsFallbackPort is used when a pod's discovery container
+// doesn't name a istiodMetricsPortName port (e.g. a minimal test fixture);
+// it's istiod's documented default metrics port.
+const istiodMetricsFallbackPort = 15014
+
+// federatedMetricsPodRefreshInterval is how often StartFederatedMetricsProxy
+// re-lists istiod pods and re-scrapes them, so a pod added or removed by a
+// rollout or rescheduling is picked up without restarting the proxy.
+const federatedMetricsPodRefreshInterval = 30 * time.Second
+
+// FederatedMetricsProxy is the handle StartFederatedMetricsProxy returns.
+// Stop tears down its background refresh loop and HTTP listener.
+type FederatedMetricsProxy struct {
+	listener net.Listener
+	server   *http.Server
+	cancel   context.CancelFunc
+
+	mu     sync.RWMutex
+	merged []byte
+}
+
+// StartFederatedMetricsProxy scrapes /metrics from every istiod pod in ns
+// (selected by istiodPodSelector) and serves their merged families on port,
+// so a scrape target aimed at this proxy sees every replica's metrics
+// instead of whichever single pod a Service-routed scrape happened to land
+// on. The pod list, and the scrape of each pod it names, are refreshed every
+// federatedMetricsPodRefreshInterval; a request against port is always
+// answered from the most recently completed merge rather than blocking on a
+// live scrape of every pod.
+//
+// Every pod is reached by port-forwarding to it, the same way `kubectl
+// port-forward` does, rather than going through the istiod Service: a
+// Service load-balances a single connection to one backend pod, which is
+// exactly the problem federation exists to route around.
+//
+// The caller is responsible for calling Stop once ctx's cancellation (if
+// any) isn't enough — e.g. to free port synchronously in a test.
+func StartFederatedMetricsProxy(ctx context.Context, cfg *rest.Config, ns string, port int32) (*FederatedMetricsProxy, error) {
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("building client for metrics federation: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("listening for metrics federation: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	p := &FederatedMetricsProxy{listener: ln, cancel: cancel}
+	p.refresh(runCtx, cfg, clientset, ns)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", p.ServeHTTP)
+	p.server = &http.Server{Handler: mux}
+
+	go p.run(runCtx, cfg, clientset, ns)
+	go func() {
+		if err := p.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Error(err, "Federated metrics proxy HTTP server stopped unexpectedly")
+		}
+	}()
+
+	return p, nil
+}
+
+// ServeHTTP writes the most recently completed merge of every istiod pod's
+// metrics. It never itself port-forwards or scrapes: that only happens from
+// run's background refresh cycle, so a burst of concurrent scrapes against
+// the proxy is cheap no matter how many istiod replicas are running.
+func (p *FederatedMetricsProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.mu.RLock()
+	merged := p.merged
+	p.mu.RUnlock()
+
+	w.Header().Set("Content-Type", classicContentType)
+	_, _ = w.Write(merged)
+}
+
+// Stop cancels the background refresh loop and closes the HTTP listener.
+func (p *FederatedMetricsProxy) Stop() error {
+	p.cancel()
+	return p.server.Close()
+}
+
+// Addr returns the address the proxy is actually listening on, useful when
+// StartFederatedMetricsProxy was called with port 0.
+func (p *FederatedMetricsProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *FederatedMetricsProxy) run(ctx context.Context, cfg *rest.Config, clientset *kubernetes.Clientset, ns string) {
+	ticker := time.NewTicker(federatedMetricsPodRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.refresh(ctx, cfg, clientset, ns)
+		}
+	}
+}
+
+// refresh lists istiod pods in ns, scrapes each one, merges the results,
+// and swaps them into p.merged. A failed pod list is logged and ends the
+// cycle. A pod that isn't Running is skipped, and one that fails to scrape
+// is logged and skipped, rather than failing the whole cycle, so one stuck
+// pod doesn't blank out every other replica's metrics; the previous merge is
+// left in place until this cycle completes successfully.
+func (p *FederatedMetricsProxy) refresh(ctx context.Context, cfg *rest.Config, clientset *kubernetes.Clientset, ns string) {
+	pods, err := clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: istiodPodSelector})
+	if err != nil {
+		log.Error(err, "Failed to list istiod pods for metrics federation", "namespace", ns)
+		return
+	}
+
+	merged := map[string]*dto.MetricFamily{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		families, err := scrapePodMetrics(cfg, clientset, pod)
+		if err != nil {
+			log.Error(err, "Failed to scrape istiod pod metrics", "pod", pod.Name, "namespace", ns)
+			continue
+		}
+		mergeMetricFamilies(merged, families)
+	}
+
+	var buf bytes.Buffer
+	if err := writeMetricFamilies(&buf, merged); err != nil {
+		log.Error(err, "Failed to encode federated metrics", "namespace", ns)
+		return
+	}
+
+	p.mu.Lock()
+	p.merged = buf.Bytes()
+	p.mu.Unlock()
+}
+
+// scrapePodMetrics port-forwards to pod's istiodMetricsPortName container
+// port and fetches/parses its /metrics endpoint.
+func scrapePodMetrics(cfg *rest.Config, clientset *kubernetes.Clientset, pod *corev1.Pod) (map[string]*dto.MetricFamily, error) {
+	localPort, stopForwarding, err := portForwardToPod(cfg, clientset, pod, metricsPortFor(pod))
+	if err != nil {
+		return nil, err
+	}
+	defer stopForwarding()
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", localPort))
+	if err != nil {
+		return nil, fmt.Errorf("scraping %s: %w", pod.Name, err)
+	}
+	defer resp.Body.Close()
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// metricsPortFor returns the container port istiod's /metrics endpoint
+// listens on for pod, by name if the discovery container declares one named
+// istiodMetricsPortName, or istiodMetricsFallbackPort otherwise.
+func metricsPortFor(pod *corev1.Pod) int32 {
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == istiodMetricsPortName {
+				return containerPort.ContainerPort
+			}
+		}
+	}
+	return istiodMetricsFallbackPort
+}
+
+// portForwardToPod opens a port-forward session to pod's remotePort and
+// returns the local port it's listening on, and a func to tear the session
+// down. Modeled on kubectl port-forward's own use of
+// k8s.io/client-go/tools/portforward: ForwardPorts runs until stopCh is
+// closed and only signals its ready channel once the local listener is up,
+// so it runs in its own goroutine and the returned local port is only
+// usable after that channel has fired, which this function waits for
+// before returning.
+func portForwardToPod(cfg *rest.Config, clientset *kubernetes.Clientset, pod *corev1.Pod, remotePort int32) (int32, func(), error) {
+	transport, upgrader, err := spdy.RoundTripperFor(cfg)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	stopCh := make(chan struct{})
+	readyCh := make(chan struct{})
+	ports := []string{fmt.Sprintf("0:%d", remotePort)}
+	fw, err := portforward.New(dialer, ports, stopCh, readyCh, io.Discard, io.Discard)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	forwardErrCh := make(chan error, 1)
+	go func() { forwardErrCh <- fw.ForwardPorts() }()
+
+	select {
+	case <-readyCh:
+	case err := <-forwardErrCh:
+		return 0, nil, fmt.Errorf("port-forwarding to %s: %w", pod.Name, err)
+	}
+
+	forwardedPorts, err := fw.GetPorts()
+	if err != nil {
+		close(stopCh)
+		return 0, nil, err
+	}
+	if len(forwardedPorts) == 0 {
+		close(stopCh)
+		return 0, nil, fmt.Errorf("port-forwarding to %s: no port was forwarded", pod.Name)
+	}
+
+	return int32(forwardedPorts[0].Local), func() { close(stopCh) }, nil
+}
+
+// mergeMetricFamilies folds families into merged in place, keyed by family
+// name. A family name not yet in merged is copied in wholesale; one that's
+// already present has its samples merged metric-for-metric (matched by
+// label set) via mergeMetric.
+func mergeMetricFamilies(merged map[string]*dto.MetricFamily, families map[string]*dto.MetricFamily) {
+	for name, family := range families {
+		existing, ok := merged[name]
+		if !ok {
+			merged[name] = family
+			continue
+		}
+		for _, metric := range family.Metric {
+			mergeMetric(existing, metric)
+		}
+	}
+}
+
+// mergeMetric folds metric into family: if family already has a metric
+// with the same label set, their values are combined by mergeValue;
+// otherwise metric is appended as a new series (e.g. a per-pod label value
+// family doesn't otherwise already carry).
+func mergeMetric(family *dto.MetricFamily, metric *dto.Metric) {
+	for _, existing := range family.Metric {
+		if !sameLabels(existing.Label, metric.Label) {
+			continue
+		}
+		mergeValue(family.GetType(), existing, metric)
+		return
+	}
+	family.Metric = append(family.Metric, metric)
+}
+
+// mergeValue combines metric's value into existing in place, per typ:
+// counters are summed, since each replica's counter only ever grows and the
+// cluster-wide total is every replica's contribution added together;
+// gauges keep whichever of the two values is larger, since a gauge is a
+// point-in-time reading and the larger of two replicas' readings is more
+// likely to be the current one than a stale value from a replica that's
+// fallen behind. Any other type (summary, histogram, untyped) is replaced
+// by metric only when metric carries a later timestamp than existing, and
+// is otherwise left as it is.
+func mergeValue(typ dto.MetricType, existing, metric *dto.Metric) {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		existing.Counter.Value = addFloat64Ptr(existing.Counter.Value, metric.Counter.Value)
+	case dto.MetricType_GAUGE:
+		existing.Gauge.Value = maxFloat64Ptr(existing.Gauge.Value, metric.Gauge.Value)
+	default:
+		if metric.TimestampMs != nil && (existing.TimestampMs == nil || *metric.TimestampMs > *existing.TimestampMs) {
+			*existing = *metric
+		}
+	}
+}
+
+func addFloat64Ptr(a, b *float64) *float64 {
+	sum := float64Ptr(a) + float64Ptr(b)
+	return &sum
+}
+
+func maxFloat64Ptr(a, b *float64) *float64 {
+	if float64Ptr(b) > float64Ptr(a) {
+		max := float64Ptr(b)
+		return &max
+	}
+	return a
+}
+
+// float64Ptr dereferences p, treating a nil pointer (a family's value
+// field left unset) as 0, the same way the protobuf-generated GetXxx
+// accessors on *dto.Metric itself already do for every other field.
+func float64Ptr(p *float64) float64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// sameLabels reports whether a and b carry the same set of label
+// name/value pairs, order notwithstanding.
+func sameLabels(a, b []*dto.LabelPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	av := make(map[string]string, len(a))
+	for _, l := range a {
+		av[l.GetName()] = l.GetValue()
+	}
+	for _, l := range b {
+		if av[l.GetName()] != l.GetValue() {
+			return false
+		}
+	}
+	return true
+}
+
+// writeMetricFamilies encodes families into w in Prometheus text exposition
+// format, sorted by name so repeated renders of the same merge are
+// byte-identical.
+func writeMetricFamilies(w io.Writer, families map[string]*dto.MetricFamily) error {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, name := range names {
+		if err := encoder.Encode(families[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// parseFamilies is a small test helper parsing Prometheus text exposition
+// format the same way scrapePodMetrics parses a pod's scrape response.
+func parseFamilies(t *testing.T, text string) map[string]*dto.MetricFamily {
+	t.Helper()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("TextToMetricFamilies() = %v", err)
+	}
+	return families
+}
+
+func TestMergeMetricFamiliesSumsCounters(t *testing.T) {
+	merged := map[string]*dto.MetricFamily{}
+	mergeMetricFamilies(merged, parseFamilies(t, "# TYPE requests_total counter\nrequests_total{pod=\"a\"} 10\n"))
+	mergeMetricFamilies(merged, parseFamilies(t, "# TYPE requests_total counter\nrequests_total{pod=\"a\"} 7\n"))
+
+	var buf bytes.Buffer
+	if err := writeMetricFamilies(&buf, merged); err != nil {
+		t.Fatalf("writeMetricFamilies() = %v", err)
+	}
+
+	got := buf.String()
+	if !strings.Contains(got, `requests_total{pod="a"} 17`) {
+		t.Fatalf("merged output = %q, want requests_total{pod=\"a\"} summed to 17", got)
+	}
+}
+
+func TestMergeMetricFamiliesTakesMaxOfGauges(t *testing.T) {
+	merged := map[string]*dto.MetricFamily{}
+	mergeMetricFamilies(merged, parseFamilies(t, "# TYPE connections counter\nconnections 3\n# TYPE queue_depth gauge\nqueue_depth 12\n"))
+	mergeMetricFamilies(merged, parseFamilies(t, "# TYPE connections counter\nconnections 9\n# TYPE queue_depth gauge\nqueue_depth 4\n"))
+
+	var buf bytes.Buffer
+	if err := writeMetricFamilies(&buf, merged); err != nil {
+		t.Fatalf("writeMetricFamilies() = %v", err)
+	}
+
+	got := buf.String()
+	if !strings.Contains(got, "queue_depth 12") {
+		t.Fatalf("merged output = %q, want queue_depth to keep the larger reading (12), not the last-merged one (4)", got)
+	}
+}
+
+func TestMergeMetricFamiliesKeepsDistinctLabelSetsAsSeparateSeries(t *testing.T) {
+	merged := map[string]*dto.MetricFamily{}
+	mergeMetricFamilies(merged, parseFamilies(t, "# TYPE requests_total counter\nrequests_total{path=\"/a\"} 1\n"))
+	mergeMetricFamilies(merged, parseFamilies(t, "# TYPE requests_total counter\nrequests_total{path=\"/b\"} 2\n"))
+
+	var buf bytes.Buffer
+	if err := writeMetricFamilies(&buf, merged); err != nil {
+		t.Fatalf("writeMetricFamilies() = %v", err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{`requests_total{path="/a"} 1`, `requests_total{path="/b"} 2`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("merged output = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestWriteMetricFamiliesOrdersByNameDeterministically(t *testing.T) {
+	merged := parseFamilies(t, "# TYPE zeta gauge\nzeta 1\n# TYPE alpha gauge\nalpha 1\n")
+
+	var buf bytes.Buffer
+	if err := writeMetricFamilies(&buf, merged); err != nil {
+		t.Fatalf("writeMetricFamilies() = %v", err)
+	}
+
+	got := buf.String()
+	if strings.Index(got, "alpha") > strings.Index(got, "zeta") {
+		t.Fatalf("merged output = %q, want alpha before zeta", got)
+	}
+}
+
+func TestMetricsPortForPrefersNamedPortOverFallback(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "discovery", Ports: []corev1.ContainerPort{{Name: istiodMetricsPortName, ContainerPort: 15099}}},
+	}}}
+
+	if got := metricsPortFor(pod); got != 15099 {
+		t.Fatalf("metricsPortFor() = %d, want 15099", got)
+	}
+}
+
+func TestMetricsPortForFallsBackWhenPortIsntNamed(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "discovery", Ports: []corev1.ContainerPort{{Name: "grpc-xds", ContainerPort: 15010}}},
+	}}}
+
+	if got := metricsPortFor(pod); got != istiodMetricsFallbackPort {
+		t.Fatalf("metricsPortFor() = %d, want the fallback port %d", got, istiodMetricsFallbackPort)
+	}
+}
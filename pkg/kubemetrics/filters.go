@@ -0,0 +1,723 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// defaultAnnotationValueMaxLen is applied in place of
+// GVKOptions.AnnotationValueMaxLen when it's zero.
+const defaultAnnotationValueMaxLen = 256
+
+// RelabelAction is the operation a RelabelConfig performs on a metric whose
+// SourceLabel value matches Regex.
+type RelabelAction string
+
+const (
+	// RelabelKeep drops every metric whose source label does NOT match Regex.
+	RelabelKeep RelabelAction = "keep"
+	// RelabelDrop drops every metric whose source label matches Regex.
+	RelabelDrop RelabelAction = "drop"
+	// RelabelReplace sets TargetLabel to Replacement for metrics whose source
+	// label matches Regex, adding the label if it isn't already present.
+	RelabelReplace RelabelAction = "replace"
+)
+
+// RelabelConfig is a small, Prometheus-relabel-inspired rule applied to each
+// ksmetric.Metric before it enters a MetricsStore.
+type RelabelConfig struct {
+	SourceLabel string        `json:"sourceLabel"`
+	Regex       string        `json:"regex"`
+	Action      RelabelAction `json:"action"`
+	TargetLabel string        `json:"targetLabel,omitempty"`
+	Replacement string        `json:"replacement,omitempty"`
+}
+
+// Filters controls cardinality of the metrics GenerateAndServeCRMetrics
+// produces for a single GVK: which metric families are emitted at all,
+// which labels survive on them, and a small relabeling pass applied to every
+// metric afterwards.
+type Filters struct {
+	// MetricAllow, if non-empty, restricts generated families to those whose
+	// name matches at least one of these regexes.
+	MetricAllow []string `json:"metricAllow,omitempty"`
+	// MetricDeny drops families whose name matches any of these regexes.
+	// Applied after MetricAllow.
+	MetricDeny []string `json:"metricDeny,omitempty"`
+	// LabelAllow, if non-empty, restricts each metric's labels to keys
+	// matching at least one of these regexes.
+	LabelAllow []string `json:"labelAllow,omitempty"`
+	// LabelDeny drops labels whose key matches any of these regexes.
+	// Applied after LabelAllow.
+	LabelDeny []string `json:"labelDeny,omitempty"`
+	// Relabel is a list of additional keep/drop/replace rules, applied in
+	// order, after MetricAllow/MetricDeny/LabelAllow/LabelDeny.
+	Relabel []RelabelConfig `json:"relabel,omitempty"`
+}
+
+// ExtraLabelSource describes where to pull the value for an extra, per-GVK
+// label from. Exactly one of the fields should be set; if more than one is,
+// FromLabel wins, then FromAnnotation, then FromPath.
+type ExtraLabelSource struct {
+	// FromLabel reads the object's metadata.labels[FromLabel].
+	FromLabel string `json:"fromLabel,omitempty"`
+	// FromAnnotation reads the object's metadata.annotations[FromAnnotation].
+	FromAnnotation string `json:"fromAnnotation,omitempty"`
+	// FromPath reads an arbitrary JSON path, using the same syntax as
+	// MetricSpec.Path.
+	FromPath string `json:"fromPath,omitempty"`
+}
+
+// GVKOptions bundles the Filters, ExtraLabels, LabelsAllowlist and
+// FamilyGenerators configured for a single GVK in
+// GenerateAndServeCRMetricsWithOptions.
+type GVKOptions struct {
+	Filters     Filters
+	ExtraLabels map[string]ExtraLabelSource
+
+	// LabelsAllowlist maps a Kubernetes metadata label key to the metric
+	// label name it should be surfaced as on every family generated for
+	// this GVK, similar to kube-state-metrics' --metric-labels-allowlist,
+	// e.g. {"team": "team", "env": "environment"} to join a CR's "team" and
+	// "env" labels against other series in PromQL. Unlike ExtraLabels'
+	// FromLabel source, a key absent from a given object's labels still
+	// surfaces with an empty value rather than being left off that
+	// instance's label set entirely, so every series for a family carries
+	// the same labels. Every target label name must be a legal Prometheus
+	// label name and must not collide with the "namespace" or "name"
+	// label every generated family already carries;
+	// GenerateAndServeCRMetricsWithOptions returns an error rather than
+	// serving broken output if either is violated. Nil by default,
+	// surfacing no additional labels.
+	LabelsAllowlist map[string]string
+
+	// AnnotationsAllowlist mirrors LabelsAllowlist, but reads from the
+	// object's annotations instead of its labels, e.g. to surface
+	// maistra.io/chart-version on the info metric for a fleet dashboard. The
+	// same "absent surfaces as empty rather than omitted" and "target name
+	// must be legal and non-reserved" rules apply; see
+	// validateAnnotationsAllowlist.
+	AnnotationsAllowlist map[string]string
+
+	// AnnotationValueMaxLen truncates, with a trailing "..." ellipsis, any
+	// AnnotationsAllowlist value longer than this many characters, since
+	// annotations (unlike labels) carry no Kubernetes-enforced length limit
+	// and an operator-stamped one like a full chart values.yaml digest could
+	// otherwise blow up this series' cardinality cost on every scrape.
+	// Defaults to 256 when zero.
+	AnnotationValueMaxLen int
+
+	// FamilyGenerators, if non-empty, replaces the fixed "<kind>_info" gauge
+	// buildStoresForGVK would otherwise build for this GVK — e.g. to emit
+	// condition metrics, a member count, or a version label extracted from
+	// an unstructured status field instead of (or alongside) the info gauge.
+	// Takes priority over any generators registered for this GVK via
+	// RegisterFamilyGenerator. Filters, ExtraLabels, LabelsAllowlist and
+	// AnnotationsAllowlist still apply to whatever this produces.
+	FamilyGenerators []ksmetric.FamilyGenerator
+
+	// GenerationDriftMetrics additionally emits "<kind>_metadata_generation"
+	// and "<kind>_status_observed_generation" gauges alongside the fixed
+	// "<kind>_info"/"<kind>_created" gauges, so a controller that's stopped
+	// reconciling this GVK can be alerted on by diffing the two over time.
+	// Only takes effect on the default "<kind>_info" fallback: it has no
+	// effect once FamilyGenerators is set or a generator's been registered
+	// for this GVK via RegisterFamilyGenerator, since both replace the
+	// fallback entirely rather than adding to it.
+	GenerationDriftMetrics bool
+
+	// DeletionTimestampMetric additionally emits a "<kind>_deletion_timestamp"
+	// gauge, reporting metadata.deletionTimestamp as Unix seconds, alongside
+	// the fixed "<kind>_info"/"<kind>_created" gauges, so a CR stuck
+	// terminating because a finalizer never clears can be alerted on with
+	// something like `time() - istiooperator_deletion_timestamp > 600`. The
+	// gauge has no sample at all for an object that isn't terminating, and
+	// stops reporting one entirely once the object is actually removed, the
+	// same way every other generated family does for an object no longer in
+	// its MetricsStore. Only takes effect on the default "<kind>_info"
+	// fallback, the same restriction as GenerationDriftMetrics.
+	DeletionTimestampMetric bool
+
+	// CountMetric additionally emits a "<kind>_count" gauge reporting how
+	// many instances of this GVK are currently in the store — one series
+	// per namespace for a namespaced GVK, or a single unlabeled series for
+	// a cluster-scoped one — recomputed from the store's current contents
+	// on every scrape. Unlike GenerationDriftMetrics and
+	// DeletionTimestampMetric, this isn't one of the per-object families
+	// generateMetricFamilies produces, so it isn't affected by FamilyGenerators
+	// or a RegisterFamilyGenerator override: it's written directly by the
+	// store alongside "<kind>_info" (or whatever replaces it) regardless of
+	// which family generators are in effect.
+	CountMetric bool
+
+	// OwnerReferenceLabels additionally labels "<kind>_info" with
+	// "owner_kind" and "owner_name", taken from the object's controller
+	// owner reference (metadata.ownerReferences[*] with controller: true),
+	// so a CR created by a higher-level object (e.g. a Gateway API route
+	// generated by a Gateway, or a VirtualService generated by some other
+	// controller) can be correlated back to its owner in PromQL without a
+	// join against the Kubernetes API. Both labels are empty for a CR with
+	// no owner references. If more than one owner reference is present but
+	// none is marked as the controller, the first one in
+	// metadata.ownerReferences is used, for a deterministic choice rather
+	// than an arbitrary one. Only takes effect on the default "<kind>_info"
+	// fallback, the same restriction as GenerationDriftMetrics.
+	OwnerReferenceLabels bool
+
+	// UIDLabel additionally labels "<kind>_info" with "uid", taken from the
+	// object's metadata.uid, so a recording rule aggregating this series
+	// doesn't silently merge two lifetimes of a CR deleted and recreated
+	// under the same namespace/name: the uid label changes across that
+	// recreation even though every other label stays the same. Off by
+	// default and configurable per GVK, since metadata.uid is unique per
+	// object and so turning it on unconditionally would make "<kind>_info"'s
+	// cardinality track every CR ever created rather than every CR
+	// currently live. Only takes effect on the default "<kind>_info"
+	// fallback, the same restriction as GenerationDriftMetrics.
+	UIDLabel bool
+
+	// SpecHashLabel additionally labels "<kind>_info" with "spec_hash", the
+	// first 8 hex characters of the sha256 digest of the object's spec (see
+	// SpecHash), so control planes with an identical desired state can be
+	// grouped together in PromQL without comparing the spec itself, and a
+	// drifted one stands out by a changed label value alone. Off by default:
+	// like UIDLabel, it's a label computed from the object rather than read
+	// off it directly, so it's opt-in per GVK rather than always on. Only
+	// takes effect on the default "<kind>_info" fallback, the same
+	// restriction as GenerationDriftMetrics.
+	SpecHashLabel bool
+
+	// RevisionLabel additionally labels "<kind>_info" with "revision",
+	// taken from the object via RevisionLabelValue: its istio.io/rev label
+	// if set, else its spec.revision field if present, else "". This is
+	// how revision-based canary upgrades, which run multiple control
+	// planes distinguished by that label, get a "revision" label to slice
+	// every "<kind>_info" series by in PromQL without a join. Off by
+	// default and opt-in per GVK, the same as UIDLabel and SpecHashLabel;
+	// a GVK whose revision lives somewhere RevisionLabelValue doesn't look
+	// should use a FamilyGenerators override calling RevisionLabelValue (or
+	// its own equivalent) directly instead. Only takes effect on the
+	// default "<kind>_info" fallback, the same restriction as
+	// GenerationDriftMetrics.
+	RevisionLabel bool
+
+	// FinalizerCountMetric additionally emits a "<kind>_finalizers" gauge
+	// reporting the number of entries in metadata.finalizers, so a CR stuck
+	// terminating because a finalizer never clears can be alerted on.
+	// Unlike GenerationDriftMetrics and DeletionTimestampMetric, this isn't
+	// restricted to the default "<kind>_info" fallback: it's appended by
+	// buildStoresForGVK the same way scaleReplicasFamilyGenerators is, so it
+	// still applies even when FamilyGenerators is set or a generator's been
+	// registered via RegisterFamilyGenerator.
+	FinalizerCountMetric bool
+
+	// FinalizerLabelsMetric additionally emits a "<kind>_finalizer"
+	// info series with one sample per metadata.finalizers entry, labeled
+	// with the finalizer string itself, so a stuck deletion can be traced
+	// to the specific finalizer that never cleared. Off by default and
+	// separate from FinalizerCountMetric: finalizer strings are
+	// operator/controller-defined and can be high cardinality across a
+	// fleet, so this is opt-in even where FinalizerCountMetric is enabled.
+	FinalizerLabelsMetric bool
+
+	// StatusPhaseMetric, if non-nil, additionally emits a
+	// "<kind>_status_phase" gauge reporting which of StatusPhaseSpec.KnownPhases
+	// this object currently has, labeled "phase". Like FinalizerCountMetric,
+	// it's appended by buildStoresForGVK rather than folded into the default
+	// "<kind>_info" fallback, so it still applies even when FamilyGenerators
+	// is set or a generator's been registered via RegisterFamilyGenerator.
+	StatusPhaseMetric *StatusPhaseSpec
+
+	// AgeMetric additionally emits a "<kind>_age_seconds" gauge computed at
+	// render time as now minus metadata.creationTimestamp. Unlike
+	// "<kind>_created", which reports a fixed Unix timestamp and so only
+	// changes value on object churn, "<kind>_age_seconds" grows every
+	// scrape, which some teams prefer for a simple `> threshold` alert on
+	// resources that never converge. Like FinalizerCountMetric, it's
+	// appended by buildStoresForGVK rather than folded into the default
+	// "<kind>_info" fallback, so it still applies even when FamilyGenerators
+	// is set or a generator's been registered via RegisterFamilyGenerator.
+	AgeMetric bool
+
+	// UpdatesCounterMetric additionally emits a "<kind>_updates_total"
+	// counter, incremented per object whenever metadata.resourceVersion
+	// changes from what was last observed for that object's UID, so a CR
+	// being hot-looped by some controller stands out from a fleet of
+	// otherwise-idle ones. A periodic relist that finds resourceVersion
+	// unchanged doesn't advance the count, and an object's count is dropped
+	// once the object itself is deleted rather than kept around forever.
+	// Like FinalizerCountMetric, it's appended by buildStoresForGVK rather
+	// than folded into the default "<kind>_info" fallback, so it still
+	// applies even when FamilyGenerators is set or a generator's been
+	// registered via RegisterFamilyGenerator.
+	UpdatesCounterMetric bool
+
+	// OwnedMetric additionally emits a "<kind>_owned" gauge labeled
+	// owned="true"/"false" reporting whether the object has a controller
+	// owner reference (metadata.ownerReferences[*] with controller: true),
+	// so `sum(<kind>_owned{owned="false"}) > 0` finds CRs that lost their
+	// owning controller and linger forever. Like FinalizerCountMetric, it's
+	// appended by buildStoresForGVK rather than folded into the default
+	// "<kind>_info" fallback, so it still applies even when FamilyGenerators
+	// is set or a generator's been registered via RegisterFamilyGenerator.
+	OwnedMetric bool
+
+	// SizeMetric additionally emits a "<kind>_size_bytes" gauge reporting
+	// the serialized JSON size of an object's unstructured content, so
+	// outsized specs that pressure etcd and the apiserver can be singled
+	// out before they cause trouble. The size is cached per object UID and
+	// only recomputed when metadata.resourceVersion changes, so repeated
+	// scrapes of an unchanged object don't re-marshal it. Like
+	// FinalizerCountMetric, it's appended by buildStoresForGVK rather than
+	// folded into the default "<kind>_info" fallback, so it still applies
+	// even when FamilyGenerators is set or a generator's been registered
+	// via RegisterFamilyGenerator.
+	SizeMetric bool
+
+	// InfoMetricHelp, if non-empty, overrides the fixed "<kind>_info" gauge's
+	// default HELP text ("Information about the <kind> custom resource."),
+	// e.g. to tell downstream catalogs what an SMCP's "<kind>_info" actually
+	// means instead of leaving them with boilerplate. Only takes effect on
+	// the default "<kind>_info" fallback, the same restriction as
+	// GenerationDriftMetrics; a FamilyGenerators override or a generator
+	// registered via RegisterFamilyGenerator sets its own Help directly.
+	// Rejected if it contains a raw newline, which would corrupt the
+	// exposition format's "# HELP" line; see validateInfoMetricHelp.
+	InfoMetricHelp string
+
+	// TombstoneGracePeriod, if positive, keeps the "<kind>_info" gauge
+	// emitting a series for a deleted object, valued 0 instead of 1, for this
+	// long after the reflector observes its deletion, instead of the series
+	// vanishing on the very next scrape. This gives an alert rule evaluated
+	// against "<kind>_info" a chance to fire on the 1→0 transition and
+	// capture the object's last labels before it disappears for good,
+	// instead of resolving instantly with no trace of what was deleted. Only
+	// takes effect on the default "<kind>_info" fallback, the same
+	// restriction as GenerationDriftMetrics. Zero, the default, preserves
+	// today's immediate-removal behavior. See tombstoneEvictingStore.
+	TombstoneGracePeriod time.Duration
+
+	// MaxResources, if positive, overrides MetricOptions.MaxResourcesPerGVK
+	// for this GVK alone, so one tenant's misbehaving CRD (e.g. 40k
+	// ServiceMeshMembers) can be capped tighter than the fleet-wide default
+	// without lowering it for every other kind. truncateList keeps the
+	// oldest objects by metadata.creationTimestamp and reports the overflow
+	// via the "<kind>_truncated" gauge the same way the global default
+	// does; see MetricOptions.MaxResourcesPerGVK.
+	MaxResources int
+}
+
+// labelNameRE matches a valid Prometheus label name, per
+// https://prometheus.io/docs/concepts/data_model/#metric-names-and-labels.
+// Unlike metricNameRE, a label name may not contain ":".
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabelNames are the label names every family generateMetricFamilies,
+// generateConfiguredFamilies, and the wrapping applyGVKOptions itself produce
+// already use, so a GVKOptions.LabelsAllowlist target name can't be allowed
+// to collide with either: "namespace" labels every family, and "name" is the
+// object-name label MetricsConfig-declared gauges/info/stateset metrics use
+// (the fixed "<kind>_info"/"<kind>_created" gauges use the lowercased kind
+// name instead, which varies per GVK and so can't be checked here).
+var reservedLabelNames = map[string]bool{"namespace": true, "name": true}
+
+// validateLabelsAllowlist returns an error naming the first GVKOptions.LabelsAllowlist
+// entry, across every GVK in gvkOptions, whose target metric label name either
+// isn't a legal Prometheus label name or collides with a reservedLabelNames entry.
+func validateLabelsAllowlist(gvkOptions map[schema.GroupVersionKind]GVKOptions) error {
+	for gvk, opts := range gvkOptions {
+		for k8sLabel, metricLabel := range opts.LabelsAllowlist {
+			if !labelNameRE.MatchString(metricLabel) {
+				return fmt.Errorf("GVK %s LabelsAllowlist[%q] = %q: not a legal Prometheus label name, must match %s", gvk.String(), k8sLabel, metricLabel, labelNameRE.String())
+			}
+			if reservedLabelNames[metricLabel] {
+				return fmt.Errorf("GVK %s LabelsAllowlist[%q] = %q: collides with the built-in %q label", gvk.String(), k8sLabel, metricLabel, metricLabel)
+			}
+		}
+	}
+	return nil
+}
+
+// validateAnnotationsAllowlist returns an error naming the first GVKOptions.AnnotationsAllowlist
+// entry, across every GVK in gvkOptions, whose target metric label name either isn't a legal
+// Prometheus label name, collides with a reservedLabelNames entry, or collides with that same
+// GVK's LabelsAllowlist target names — both ultimately land on the same metric's label set, so a
+// name used by one can't be reused by the other. Also rejects a negative
+// AnnotationValueMaxLen or TombstoneGracePeriod.
+func validateAnnotationsAllowlist(gvkOptions map[schema.GroupVersionKind]GVKOptions) error {
+	for gvk, opts := range gvkOptions {
+		if opts.AnnotationValueMaxLen < 0 {
+			return fmt.Errorf("GVK %s AnnotationValueMaxLen = %d: must not be negative", gvk.String(), opts.AnnotationValueMaxLen)
+		}
+		if opts.TombstoneGracePeriod < 0 {
+			return fmt.Errorf("GVK %s TombstoneGracePeriod = %s: must not be negative", gvk.String(), opts.TombstoneGracePeriod)
+		}
+		for k8sAnnotation, metricLabel := range opts.AnnotationsAllowlist {
+			if !labelNameRE.MatchString(metricLabel) {
+				return fmt.Errorf("GVK %s AnnotationsAllowlist[%q] = %q: not a legal Prometheus label name, must match %s", gvk.String(), k8sAnnotation, metricLabel, labelNameRE.String())
+			}
+			if reservedLabelNames[metricLabel] {
+				return fmt.Errorf("GVK %s AnnotationsAllowlist[%q] = %q: collides with the built-in %q label", gvk.String(), k8sAnnotation, metricLabel, metricLabel)
+			}
+			for _, labelTarget := range opts.LabelsAllowlist {
+				if labelTarget == metricLabel {
+					return fmt.Errorf("GVK %s AnnotationsAllowlist[%q] = %q: collides with a LabelsAllowlist target of the same name", gvk.String(), k8sAnnotation, metricLabel)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateConstLabels returns an error naming the first DiscoveryOptions.ConstLabels
+// key that either isn't a legal Prometheus label name, collides with a
+// reservedLabelNames entry, or — across every GVK in gvkOptions — collides
+// with an ExtraLabels key or a LabelsAllowlist/AnnotationsAllowlist target
+// name, since applyGVKOptions appends constLabels to the same label set
+// those per-object mechanisms write into.
+func validateConstLabels(constLabels map[string]string, gvkOptions map[schema.GroupVersionKind]GVKOptions) error {
+	for k := range constLabels {
+		if !labelNameRE.MatchString(k) {
+			return fmt.Errorf("ConstLabels[%q]: not a legal Prometheus label name, must match %s", k, labelNameRE.String())
+		}
+		if reservedLabelNames[k] {
+			return fmt.Errorf("ConstLabels[%q]: collides with the built-in %q label", k, k)
+		}
+		for gvk, opts := range gvkOptions {
+			if _, ok := opts.ExtraLabels[k]; ok {
+				return fmt.Errorf("ConstLabels[%q]: collides with GVK %s's ExtraLabels target of the same name", k, gvk.String())
+			}
+			for _, target := range opts.LabelsAllowlist {
+				if target == k {
+					return fmt.Errorf("ConstLabels[%q]: collides with GVK %s's LabelsAllowlist target of the same name", k, gvk.String())
+				}
+			}
+			for _, target := range opts.AnnotationsAllowlist {
+				if target == k {
+					return fmt.Errorf("ConstLabels[%q]: collides with GVK %s's AnnotationsAllowlist target of the same name", k, gvk.String())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateInfoMetricHelp returns an error naming the first GVK, across
+// gvkOptions, whose InfoMetricHelp contains a raw newline, which would
+// corrupt the "# HELP" line of the exposition format.
+func validateInfoMetricHelp(gvkOptions map[schema.GroupVersionKind]GVKOptions) error {
+	for gvk, opts := range gvkOptions {
+		if strings.ContainsAny(opts.InfoMetricHelp, "\r\n") {
+			return fmt.Errorf("GVK %s InfoMetricHelp: must not contain raw newlines, since that corrupts the exposition format's \"# HELP\" line", gvk.String())
+		}
+	}
+	return nil
+}
+
+// sanitizeAnnotationValue replaces any newline in v with a space, since a
+// Prometheus label value containing one breaks the single-line text
+// exposition format, then truncates the result to maxLen runes (or
+// defaultAnnotationValueMaxLen, if maxLen is zero), appending "..." when it
+// does, so one operator-stamped annotation can't blow up a scrape's size.
+func sanitizeAnnotationValue(v string, maxLen int) string {
+	v = strings.ReplaceAll(v, "\r\n", " ")
+	v = strings.ReplaceAll(v, "\n", " ")
+	v = strings.ReplaceAll(v, "\r", " ")
+
+	if maxLen <= 0 {
+		maxLen = defaultAnnotationValueMaxLen
+	}
+	runes := []rune(v)
+	if len(runes) > maxLen {
+		return string(runes[:maxLen]) + "..."
+	}
+	return v
+}
+
+// allowlistedAnnotationValues mirrors allowlistedLabelValues, reading obj's
+// annotations instead of its labels and passing each value through
+// sanitizeAnnotationValue first.
+func allowlistedAnnotationValues(obj *unstructured.Unstructured, allowlist map[string]string, maxLen int) (keys, values []string) {
+	if len(allowlist) == 0 {
+		return nil, nil
+	}
+	objAnnotations := obj.GetAnnotations()
+	keys = make([]string, 0, len(allowlist))
+	values = make([]string, 0, len(allowlist))
+	for k8sAnnotation, metricLabel := range allowlist {
+		keys = append(keys, metricLabel)
+		values = append(values, sanitizeAnnotationValue(objAnnotations[k8sAnnotation], maxLen))
+	}
+	return keys, values
+}
+
+// allowlistedLabelValues looks up each Kubernetes label key in allowlist
+// against obj's metadata labels, returning the configured metric label name
+// for every entry and "" for any key obj doesn't carry, so every series for
+// a family ends up with the same label set regardless of which objects
+// happen to carry which labels.
+func allowlistedLabelValues(obj *unstructured.Unstructured, allowlist map[string]string) (keys, values []string) {
+	if len(allowlist) == 0 {
+		return nil, nil
+	}
+	objLabels := obj.GetLabels()
+	keys = make([]string, 0, len(allowlist))
+	values = make([]string, 0, len(allowlist))
+	for k8sLabel, metricLabel := range allowlist {
+		keys = append(keys, metricLabel)
+		values = append(values, objLabels[k8sLabel])
+	}
+	return keys, values
+}
+
+// compiledFilters is Filters with its regexes pre-compiled, so the hot path
+// of generating metrics on every scrape doesn't recompile them.
+type compiledFilters struct {
+	metricAllow []*regexp.Regexp
+	metricDeny  []*regexp.Regexp
+	labelAllow  []*regexp.Regexp
+	labelDeny   []*regexp.Regexp
+	relabel     []RelabelConfig
+	compiledRe  map[string]*regexp.Regexp
+}
+
+func compileFilters(f Filters) (*compiledFilters, error) {
+	cf := &compiledFilters{compiledRe: make(map[string]*regexp.Regexp)}
+	var err error
+	if cf.metricAllow, err = compileAll(f.MetricAllow); err != nil {
+		return nil, fmt.Errorf("invalid metricAllow: %w", err)
+	}
+	if cf.metricDeny, err = compileAll(f.MetricDeny); err != nil {
+		return nil, fmt.Errorf("invalid metricDeny: %w", err)
+	}
+	if cf.labelAllow, err = compileAll(f.LabelAllow); err != nil {
+		return nil, fmt.Errorf("invalid labelAllow: %w", err)
+	}
+	if cf.labelDeny, err = compileAll(f.LabelDeny); err != nil {
+		return nil, fmt.Errorf("invalid labelDeny: %w", err)
+	}
+	for _, rc := range f.Relabel {
+		switch rc.Action {
+		case RelabelKeep, RelabelDrop, RelabelReplace:
+		default:
+			return nil, fmt.Errorf("invalid relabel action %q: must be one of %q, %q, %q",
+				rc.Action, RelabelKeep, RelabelDrop, RelabelReplace)
+		}
+		re, err := regexp.Compile(rc.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relabel regex %q: %w", rc.Regex, err)
+		}
+		cf.compiledRe[rc.Regex] = re
+	}
+	cf.relabel = f.Relabel
+	return cf, nil
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func (cf *compiledFilters) allowsMetric(name string) bool {
+	if len(cf.metricAllow) > 0 && !anyMatch(cf.metricAllow, name) {
+		return false
+	}
+	return !anyMatch(cf.metricDeny, name)
+}
+
+func (cf *compiledFilters) allowsLabel(key string) bool {
+	if len(cf.labelAllow) > 0 && !anyMatch(cf.labelAllow, key) {
+		return false
+	}
+	return !anyMatch(cf.labelDeny, key)
+}
+
+func anyMatch(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyToMetric filters a single metric's labels and applies the relabel
+// rules, returning ok=false if the metric should be dropped entirely.
+func (cf *compiledFilters) applyToMetric(m *ksmetric.Metric) (*ksmetric.Metric, bool) {
+	keys := make([]string, 0, len(m.LabelKeys))
+	values := make([]string, 0, len(m.LabelValues))
+	for i, k := range m.LabelKeys {
+		if !cf.allowsLabel(k) {
+			continue
+		}
+		keys = append(keys, k)
+		values = append(values, m.LabelValues[i])
+	}
+	out := &ksmetric.Metric{Value: m.Value, LabelKeys: keys, LabelValues: values}
+
+	for _, rc := range cf.relabel {
+		sourceValue := labelValue(out, rc.SourceLabel)
+		re := cf.compiledRe[rc.Regex]
+		matches := re.MatchString(sourceValue)
+		switch rc.Action {
+		case RelabelDrop:
+			if matches {
+				return nil, false
+			}
+		case RelabelReplace:
+			if matches {
+				setLabel(out, rc.TargetLabel, rc.Replacement)
+			}
+		default: // RelabelKeep; compileFilters rejects any other Action.
+			if !matches {
+				return nil, false
+			}
+		}
+	}
+	return out, true
+}
+
+func labelValue(m *ksmetric.Metric, key string) string {
+	for i, k := range m.LabelKeys {
+		if k == key {
+			return m.LabelValues[i]
+		}
+	}
+	return ""
+}
+
+func setLabel(m *ksmetric.Metric, key, value string) {
+	for i, k := range m.LabelKeys {
+		if k == key {
+			m.LabelValues[i] = value
+			return
+		}
+	}
+	m.LabelKeys = append(m.LabelKeys, key)
+	m.LabelValues = append(m.LabelValues, value)
+}
+
+// extraLabelValues resolves extraLabels against obj, skipping any source
+// that doesn't resolve rather than failing the whole scrape.
+func extraLabelValues(obj *unstructured.Unstructured, extraLabels map[string]ExtraLabelSource) (keys, values []string) {
+	for label, src := range extraLabels {
+		switch {
+		case src.FromLabel != "":
+			if v, ok := obj.GetLabels()[src.FromLabel]; ok {
+				keys = append(keys, label)
+				values = append(values, v)
+			}
+		case src.FromAnnotation != "":
+			if v, ok := obj.GetAnnotations()[src.FromAnnotation]; ok {
+				keys = append(keys, label)
+				values = append(values, v)
+			}
+		case src.FromPath != "":
+			if v, ok, err := resolvePath(obj.Object, src.FromPath); err == nil && ok {
+				keys = append(keys, label)
+				values = append(values, fmt.Sprintf("%v", v))
+			}
+		}
+	}
+	return keys, values
+}
+
+// applyGVKOptions wraps generators with ExtraLabels/LabelsAllowlist/
+// AnnotationsAllowlist/constLabels injection and Filters filtering/
+// relabeling, and drops any generator Filters.MetricAllow/Deny excludes
+// outright. constLabels is DiscoveryOptions.ConstLabels, forwarded here so it
+// lands on every sample the same way it lands on every self-metrics sample in
+// newSelfMetrics.
+func applyGVKOptions(generators []ksmetric.FamilyGenerator, opts GVKOptions, constLabels map[string]string) ([]ksmetric.FamilyGenerator, error) {
+	cf, err := compileFilters(opts.Filters)
+	if err != nil {
+		return nil, err
+	}
+	constKeys, constValues := constLabelValues(constLabels)
+
+	out := make([]ksmetric.FamilyGenerator, 0, len(generators))
+	for _, g := range generators {
+		if !cf.allowsMetric(g.Name) {
+			continue
+		}
+		g := g
+		innerGenerate := g.GenerateFunc
+		g.GenerateFunc = func(obj interface{}) *ksmetric.Family {
+			family := innerGenerate(obj)
+			if crd, ok := obj.(*unstructured.Unstructured); ok {
+				extraKeys, extraValues := extraLabelValues(crd, opts.ExtraLabels)
+				allowlistKeys, allowlistValues := allowlistedLabelValues(crd, opts.LabelsAllowlist)
+				annotationKeys, annotationValues := allowlistedAnnotationValues(crd, opts.AnnotationsAllowlist, opts.AnnotationValueMaxLen)
+				for _, m := range family.Metrics {
+					m.LabelKeys = append(m.LabelKeys, extraKeys...)
+					m.LabelValues = append(m.LabelValues, extraValues...)
+					m.LabelKeys = append(m.LabelKeys, allowlistKeys...)
+					m.LabelValues = append(m.LabelValues, allowlistValues...)
+					m.LabelKeys = append(m.LabelKeys, annotationKeys...)
+					m.LabelValues = append(m.LabelValues, annotationValues...)
+				}
+			}
+			for _, m := range family.Metrics {
+				m.LabelKeys = append(m.LabelKeys, constKeys...)
+				m.LabelValues = append(m.LabelValues, constValues...)
+			}
+			filtered := make([]*ksmetric.Metric, 0, len(family.Metrics))
+			for _, m := range family.Metrics {
+				if fm, ok := cf.applyToMetric(m); ok {
+					filtered = append(filtered, fm)
+				}
+			}
+			return &ksmetric.Family{Metrics: filtered}
+		}
+		out = append(out, g)
+	}
+	return out, nil
+}
+
+// constLabelValues returns constLabels as parallel key/value slices, sorted
+// by key so two calls with the same map always append labels in the same
+// order, the same way ksmetric.Metric expects LabelKeys/LabelValues to line
+// up pairwise.
+func constLabelValues(constLabels map[string]string) (keys, values []string) {
+	if len(constLabels) == 0 {
+		return nil, nil
+	}
+	keys = make([]string, 0, len(constLabels))
+	for k := range constLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values = make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = constLabels[k]
+	}
+	return keys, values
+}
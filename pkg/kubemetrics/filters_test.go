@@ -0,0 +1,256 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func TestValidateLabelsAllowlistAcceptsLegalLabelName(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {LabelsAllowlist: map[string]string{"team": "team", "env": "environment"}},
+	}
+	if err := validateLabelsAllowlist(opts); err != nil {
+		t.Fatalf("validateLabelsAllowlist() = %v, want nil for legal, non-colliding target names", err)
+	}
+}
+
+func TestValidateLabelsAllowlistRejectsInvalidCharacters(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {LabelsAllowlist: map[string]string{"team": "my-team"}},
+	}
+	if err := validateLabelsAllowlist(opts); err == nil {
+		t.Fatal("validateLabelsAllowlist() = nil, want an error for a target label name containing a dash")
+	}
+}
+
+func TestValidateLabelsAllowlistRejectsReservedName(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {LabelsAllowlist: map[string]string{"team": "namespace"}},
+	}
+	if err := validateLabelsAllowlist(opts); err == nil {
+		t.Fatal("validateLabelsAllowlist() = nil, want an error for a target name colliding with the built-in \"namespace\" label")
+	}
+}
+
+func TestAllowlistedLabelValuesFillsMissingLabelsWithEmptyString(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "basic",
+			"namespace": "istio-system",
+			"labels":    map[string]interface{}{"team": "checkout"},
+		},
+	}}
+	allowlist := map[string]string{"team": "team", "env": "environment"}
+
+	keys, values := allowlistedLabelValues(obj, allowlist)
+	got := map[string]string{}
+	for i, k := range keys {
+		got[k] = values[i]
+	}
+	if got["team"] != "checkout" {
+		t.Errorf("allowlistedLabelValues()[team] = %q, want %q", got["team"], "checkout")
+	}
+	if _, ok := got["environment"]; !ok {
+		t.Fatal("allowlistedLabelValues() dropped the \"environment\" label entirely, want it present with an empty value")
+	}
+	if got["environment"] != "" {
+		t.Errorf("allowlistedLabelValues()[environment] = %q, want empty string for a label the object doesn't carry", got["environment"])
+	}
+}
+
+func TestAllowlistedLabelValuesEmptyAllowlistReturnsNil(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+
+	keys, values := allowlistedLabelValues(obj, nil)
+	if keys != nil || values != nil {
+		t.Fatalf("allowlistedLabelValues() = %v, %v, want nil, nil for an empty allowlist", keys, values)
+	}
+}
+
+func TestValidateAnnotationsAllowlistRejectsCollisionWithLabelsAllowlist(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {
+			LabelsAllowlist:      map[string]string{"team": "team"},
+			AnnotationsAllowlist: map[string]string{"maistra.io/chart-version": "team"},
+		},
+	}
+	if err := validateAnnotationsAllowlist(opts); err == nil {
+		t.Fatal("validateAnnotationsAllowlist() = nil, want an error when an AnnotationsAllowlist target collides with a LabelsAllowlist target")
+	}
+}
+
+func TestValidateAnnotationsAllowlistRejectsNegativeMaxLen(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {AnnotationValueMaxLen: -1},
+	}
+	if err := validateAnnotationsAllowlist(opts); err == nil {
+		t.Fatal("validateAnnotationsAllowlist() = nil, want an error for a negative AnnotationValueMaxLen")
+	}
+}
+
+func TestValidateAnnotationsAllowlistRejectsNegativeTombstoneGracePeriod(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {TombstoneGracePeriod: -time.Minute},
+	}
+	if err := validateAnnotationsAllowlist(opts); err == nil {
+		t.Fatal("validateAnnotationsAllowlist() = nil, want an error for a negative TombstoneGracePeriod")
+	}
+}
+
+func TestValidateConstLabelsAcceptsLegalNonCollidingName(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {ExtraLabels: map[string]ExtraLabelSource{"team": {FromLabel: "team"}}},
+	}
+	if err := validateConstLabels(map[string]string{"cluster": "prod-us-east"}, opts); err != nil {
+		t.Fatalf("validateConstLabels() = %v, want nil for a legal, non-colliding name", err)
+	}
+}
+
+func TestValidateConstLabelsRejectsInvalidCharacters(t *testing.T) {
+	if err := validateConstLabels(map[string]string{"my-cluster": "prod"}, nil); err == nil {
+		t.Fatal("validateConstLabels() = nil, want an error for a key containing a dash")
+	}
+}
+
+func TestValidateConstLabelsRejectsReservedName(t *testing.T) {
+	if err := validateConstLabels(map[string]string{"namespace": "prod"}, nil); err == nil {
+		t.Fatal("validateConstLabels() = nil, want an error for a key colliding with the built-in \"namespace\" label")
+	}
+}
+
+func TestValidateConstLabelsRejectsCollisionWithExtraLabels(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {ExtraLabels: map[string]ExtraLabelSource{"cluster": {FromLabel: "topology.istio.io/cluster"}}},
+	}
+	if err := validateConstLabels(map[string]string{"cluster": "prod-us-east"}, opts); err == nil {
+		t.Fatal("validateConstLabels() = nil, want an error when a key collides with a GVK's ExtraLabels target of the same name")
+	}
+}
+
+func TestValidateConstLabelsRejectsCollisionWithLabelsAllowlist(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {LabelsAllowlist: map[string]string{"team": "cluster"}},
+	}
+	if err := validateConstLabels(map[string]string{"cluster": "prod-us-east"}, opts); err == nil {
+		t.Fatal("validateConstLabels() = nil, want an error when a key collides with a GVK's LabelsAllowlist target of the same name")
+	}
+}
+
+func TestApplyGVKOptionsAppendsConstLabels(t *testing.T) {
+	generators := []ksmetric.FamilyGenerator{{
+		Name: "virtualservice_info",
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			return &ksmetric.Family{Metrics: []*ksmetric.Metric{{Value: 1}}}
+		},
+	}}
+
+	out, err := applyGVKOptions(generators, GVKOptions{}, map[string]string{"cluster": "prod-us-east"})
+	if err != nil {
+		t.Fatalf("applyGVKOptions() = %v", err)
+	}
+	family := out[0].GenerateFunc(&unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}})
+	m := family.Metrics[0]
+	got := map[string]string{}
+	for i, k := range m.LabelKeys {
+		got[k] = m.LabelValues[i]
+	}
+	if got["cluster"] != "prod-us-east" {
+		t.Fatalf("GenerateFunc() labels = %v, want a \"cluster\" label valued %q", got, "prod-us-east")
+	}
+}
+
+func TestValidateInfoMetricHelpRejectsRawNewline(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {InfoMetricHelp: "Number of VirtualServices.\nSee the docs for details."},
+	}
+	if err := validateInfoMetricHelp(opts); err == nil {
+		t.Fatal("validateInfoMetricHelp() = nil, want an error when InfoMetricHelp contains a raw newline")
+	}
+}
+
+func TestValidateInfoMetricHelpAcceptsSingleLineText(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "istio.io", Version: "v1alpha1", Kind: "VirtualService"}
+	opts := map[schema.GroupVersionKind]GVKOptions{
+		gvk: {InfoMetricHelp: "Information about the mesh's VirtualServices."},
+	}
+	if err := validateInfoMetricHelp(opts); err != nil {
+		t.Fatalf("validateInfoMetricHelp() = %v, want nil for single-line help text", err)
+	}
+}
+
+func TestSanitizeAnnotationValueReplacesNewlines(t *testing.T) {
+	got := sanitizeAnnotationValue("line one\nline two\r\nline three", 256)
+	if strings.ContainsAny(got, "\r\n") {
+		t.Fatalf("sanitizeAnnotationValue() = %q, want no newlines left", got)
+	}
+}
+
+func TestSanitizeAnnotationValueTruncatesWithEllipsis(t *testing.T) {
+	got := sanitizeAnnotationValue("0123456789", 5)
+	if want := "01234..."; got != want {
+		t.Fatalf("sanitizeAnnotationValue() = %q, want %q", got, want)
+	}
+}
+
+func TestSanitizeAnnotationValueUsesDefaultMaxLenWhenZero(t *testing.T) {
+	got := sanitizeAnnotationValue(strings.Repeat("a", defaultAnnotationValueMaxLen+1), 0)
+	if want := strings.Repeat("a", defaultAnnotationValueMaxLen) + "..."; got != want {
+		t.Fatalf("sanitizeAnnotationValue() truncated to %d chars, want the default max length %d applied", len(got), defaultAnnotationValueMaxLen)
+	}
+}
+
+func TestAllowlistedAnnotationValuesFillsMissingAnnotationWithEmptyString(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        "basic",
+			"namespace":   "istio-system",
+			"annotations": map[string]interface{}{"maistra.io/chart-version": "2.4.0"},
+		},
+	}}
+	allowlist := map[string]string{"maistra.io/chart-version": "chart_version", "operator.istio.io/revision": "revision"}
+
+	keys, values := allowlistedAnnotationValues(obj, allowlist, 0)
+	got := map[string]string{}
+	for i, k := range keys {
+		got[k] = values[i]
+	}
+	if got["chart_version"] != "2.4.0" {
+		t.Errorf("allowlistedAnnotationValues()[chart_version] = %q, want %q", got["chart_version"], "2.4.0")
+	}
+	if v, ok := got["revision"]; !ok || v != "" {
+		t.Errorf("allowlistedAnnotationValues()[revision] = %q, ok=%v, want empty string present for an annotation the object doesn't carry", v, ok)
+	}
+}
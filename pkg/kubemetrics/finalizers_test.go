@@ -0,0 +1,100 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestFinalizerCountFamilyGeneratorTracksAdditionsAndRemovals(t *testing.T) {
+	gen := finalizerCountFamilyGenerator("VirtualService")
+	if gen.Name != "virtualservice_finalizers" {
+		t.Fatalf("gen.Name = %q, want virtualservice_finalizers", gen.Name)
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	if family := gen.GenerateFunc(obj); len(family.Metrics) != 1 || family.Metrics[0].Value != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want a single sample valued 0 with no finalizers", family.Metrics)
+	}
+
+	obj.SetFinalizers([]string{"a.istio.io/cleanup"})
+	if family := gen.GenerateFunc(obj); len(family.Metrics) != 1 || family.Metrics[0].Value != 1 {
+		t.Fatalf("GenerateFunc() = %+v, want value 1 after adding a finalizer", family.Metrics)
+	}
+
+	obj.SetFinalizers([]string{"a.istio.io/cleanup", "b.istio.io/cleanup"})
+	if family := gen.GenerateFunc(obj); len(family.Metrics) != 1 || family.Metrics[0].Value != 2 {
+		t.Fatalf("GenerateFunc() = %+v, want value 2 after adding a second finalizer", family.Metrics)
+	}
+
+	obj.SetFinalizers([]string{"b.istio.io/cleanup"})
+	if family := gen.GenerateFunc(obj); len(family.Metrics) != 1 || family.Metrics[0].Value != 1 {
+		t.Fatalf("GenerateFunc() = %+v, want value 1 after removing one finalizer", family.Metrics)
+	}
+
+	obj.SetFinalizers(nil)
+	if family := gen.GenerateFunc(obj); len(family.Metrics) != 1 || family.Metrics[0].Value != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want value 0 once every finalizer is removed", family.Metrics)
+	}
+}
+
+func TestFinalizerLabelsFamilyGeneratorEmitsOneSeriesPerFinalizer(t *testing.T) {
+	gen := finalizerLabelsFamilyGenerator("VirtualService")
+	if gen.Name != "virtualservice_finalizer" {
+		t.Fatalf("gen.Name = %q, want virtualservice_finalizer", gen.Name)
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	obj.SetFinalizers([]string{"a.istio.io/cleanup", "b.istio.io/cleanup"})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 2 {
+		t.Fatalf("GenerateFunc() = %+v, want one sample per finalizer", family.Metrics)
+	}
+	if got := family.Metrics[0].LabelValues; len(got) != 3 || got[2] != "a.istio.io/cleanup" {
+		t.Fatalf("Metrics[0].LabelValues = %v, want the first finalizer string", got)
+	}
+	if got := family.Metrics[1].LabelValues; len(got) != 3 || got[2] != "b.istio.io/cleanup" {
+		t.Fatalf("Metrics[1].LabelValues = %v, want the second finalizer string", got)
+	}
+}
+
+func TestFinalizerLabelsFamilyGeneratorEmptyWithoutFinalizers(t *testing.T) {
+	gen := finalizerLabelsFamilyGenerator("VirtualService")
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+
+	if family := gen.GenerateFunc(obj); len(family.Metrics) != 0 {
+		t.Fatalf("GenerateFunc() = %+v, want no samples for an object with no finalizers", family.Metrics)
+	}
+}
+
+func TestFamilyGeneratorsForGVKOmitsFinalizerGauge(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "VirtualService"}
+	gens := familyGeneratorsForGVK(gvk, gvk.Kind, GVKOptions{}, nil, "", false, NameLabelModeKind, true)
+	for _, gen := range gens {
+		if gen.Name == "virtualservice_finalizers" || gen.Name == "virtualservice_finalizer" {
+			t.Fatalf("familyGeneratorsForGVK() = %+v, want no finalizer gauges: they're appended directly by buildStoresForGVK, not by the generateMetricFamilies fallback familyGeneratorsForGVK returns", gens)
+		}
+	}
+}
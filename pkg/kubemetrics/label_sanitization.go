@@ -0,0 +1,52 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// sanitizeLabelValue escapes v for the Prometheus text exposition format's
+// label-value syntax — backslash, double-quote and newline are the only
+// characters that syntax requires escaped, so a hostile value (say, a CR
+// name sourced from an external system) can't break the parser and take
+// the whole scrape down with it. Invalid UTF-8 is replaced with the
+// standard replacement character first, since ReplaceAll over invalid
+// encoding can otherwise corrupt neighboring valid runes. v remains
+// recoverable enough to identify the object it came from; this is escaping
+// for safe transport, not redaction.
+//
+// Backslash is escaped before newline or double-quote so the backslashes
+// those two escapes introduce aren't themselves re-escaped.
+func sanitizeLabelValue(v string) string {
+	if !utf8.ValidString(v) {
+		v = strings.ToValidUTF8(v, string(utf8.RuneError))
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return v
+}
+
+// crdNameLabelValues returns crd's namespace and name, sanitized for use as
+// the "namespace"/"<kind>" (or "namespace"/"name") LabelValues pair every
+// per-object family generator in this package emits, so a hostile object
+// name can't break exposition any more than a hostile label value can.
+func crdNameLabelValues(crd *unstructured.Unstructured) []string {
+	return []string{sanitizeLabelValue(crd.GetNamespace()), sanitizeLabelValue(crd.GetName())}
+}
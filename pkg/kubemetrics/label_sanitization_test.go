@@ -0,0 +1,103 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+)
+
+func TestSanitizeLabelValueEscapesExpositionSyntax(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "backslash", in: `a\b`, want: `a\\b`},
+		{name: "newline", in: "a\nb", want: `a\nb`},
+		{name: "double quote", in: `a"b`, want: `a\"b`},
+		{name: "backslash before newline isn't re-escaped", in: "a\\\nb", want: `a\\\nb`},
+		{name: "clean value is untouched", in: "basic", want: "basic"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sanitizeLabelValue(tc.in); got != tc.want {
+				t.Fatalf("sanitizeLabelValue(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeLabelValueReplacesInvalidUTF8(t *testing.T) {
+	in := "bad\xffname"
+	got := sanitizeLabelValue(in)
+	if strings.ContainsRune(got, 0xff) {
+		t.Fatalf("sanitizeLabelValue(%q) = %q, want the invalid byte replaced", in, got)
+	}
+	if !strings.HasPrefix(got, "bad") || !strings.HasSuffix(got, "name") {
+		t.Fatalf("sanitizeLabelValue(%q) = %q, want the valid prefix/suffix preserved", in, got)
+	}
+}
+
+func TestGenerateMetricFamiliesSanitizesHostileObjectName(t *testing.T) {
+	familyGenerators := generateMetricFamilies("ServiceMeshMember", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic\"\n\\evil", "namespace": "istio-system"},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `servicemeshmember="basic\"\n\\evil"`) {
+		t.Fatalf("exposition output = %q, want the hostile name escaped in place", out)
+	}
+}
+
+func TestGenerateMetricFamiliesSanitizesHostileInfoMetricLabelKey(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", []string{"team"}, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "basic",
+			"namespace": "istio-system",
+			"labels":    map[string]interface{}{"team": `payments\"`},
+		},
+	}}
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `team="payments\\\""`) {
+		t.Fatalf("exposition output = %q, want the hostile label value escaped in place", out)
+	}
+}
@@ -0,0 +1,953 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	stdlog "log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// defaultUnixSocketMode is the file mode applied to the socket file Serve
+// creates for a "unix://<path>" host when DiscoveryOptions.UnixSocketMode
+// isn't set.
+const defaultUnixSocketMode = 0660
+
+// CRMetricsManager is the controller-like handle GenerateAndServeCRMetrics
+// returns. It wraps the storeRegistry backing /metrics and lets callers
+// add/remove GVKs and reload the metric family configuration without
+// restarting the operator; ServeMetrics-style scraping keeps reading the
+// same RWMutex-guarded registry underneath.
+type CRMetricsManager struct {
+	cfg *rest.Config
+	ns  []string
+
+	registry *storeRegistry
+
+	mu                  sync.RWMutex
+	metricsConfig       *MetricsConfig
+	gvkOptions          map[schema.GroupVersionKind]GVKOptions
+	infoMetricLabelKeys []string
+	trackedGVKs         map[schema.GroupVersionKind]struct{}
+
+	// maxResourcesPerGVK caps how many instances of a GVK are reflected into
+	// its metrics store; see MetricOptions. 0 means unlimited.
+	maxResourcesPerGVK int
+
+	// metricsPath is where Serve mounts the CR metrics handler; defaults to
+	// defaultMetricsPath when DiscoveryOptions.MetricsPath is empty.
+	metricsPath string
+
+	// unixSocketMode is the file mode Serve applies to the socket file it
+	// creates when host is a "unix://<path>" URL; see listen. Defaults to
+	// 0660 when zero.
+	unixSocketMode os.FileMode
+
+	// timeouts configures the http.Server Serve starts; see TimeoutOptions.
+	// Defaults are filled in by newCRMetricsManager, so every field here is
+	// already nonzero.
+	timeouts TimeoutOptions
+
+	// tlsOptions, if non-nil, makes Serve bind an HTTPS rather than a plain
+	// HTTP listener.
+	tlsOptions *TLSOptions
+
+	// clientCertRejectionCount counts client-certificate verification
+	// failures when tlsOptions.ClientCAFile is set; read via
+	// ClientCertRejections. Accessed atomically since handshake failures are
+	// reported from http.Server's own internal goroutines.
+	clientCertRejectionCount int64
+
+	// readyFn backs the /readyz handler Serve registers alongside /metrics,
+	// in addition to (not instead of) waiting for registry.allSynced(); nil
+	// means /readyz only gates on every store's initial sync.
+	readyFn func() bool
+
+	// discoverer resolves wildcard GVKs and is set by
+	// GenerateAndServeCRMetricsWithOptions when operatorGVKs contains at
+	// least one wildcard entry and discovery isn't disabled. It's nil
+	// otherwise, so Reload must guard every use of it.
+	discoverer *gvkDiscoverer
+
+	watcher *fsnotify.Watcher
+
+	// httpServer and addr are set by Serve once the listener is bound, so
+	// Shutdown has something to drain and Addr can report the actual port
+	// when the caller requested port 0.
+	httpServer *http.Server
+	addr       string
+
+	// serveErrCh receives at most one error if the HTTP server started by
+	// Serve stops unexpectedly after a successful bind; see ServeErrors.
+	// Buffered by one so the background goroutine never blocks on a send
+	// nobody's receiving.
+	serveErrCh chan error
+
+	// bearerTokenFile, if non-empty, makes Serve require a matching bearer
+	// token on every request to metricsPath; see bearerAuthMiddleware.
+	bearerTokenFile string
+
+	// bearerAuthRejectionCount counts requests rejected for a missing or
+	// mismatched bearer token; read via BearerAuthRejections. Accessed
+	// atomically since it's incremented from request-handling goroutines.
+	bearerAuthRejectionCount int64
+
+	// tokenReviewAuth, if non-nil, makes Serve authenticate and authorize
+	// every request via TokenReview/SubjectAccessReview; see
+	// tokenReviewAuthMiddleware.
+	tokenReviewAuth *TokenReviewAuthOptions
+
+	// tokenReviewAuthRejectionCount counts requests rejected by
+	// tokenReviewAuthMiddleware, whether for failing authentication or
+	// authorization; read via TokenReviewAuthRejections. Accessed atomically
+	// since it's incremented from request-handling goroutines.
+	tokenReviewAuthRejectionCount int64
+
+	// enablePprof mounts net/http/pprof's handlers at pprofPathPrefix when
+	// true; see DiscoveryOptions.EnablePprof.
+	enablePprof bool
+
+	// listenNetwork is the network listen passes to net.Listen: "tcp",
+	// "tcp4", or "tcp6". Defaults to "tcp" in newCRMetricsManager, which
+	// binds both address families on a dual-stack host; see
+	// DiscoveryOptions.ListenNetwork.
+	listenNetwork string
+
+	// middlewares wraps Handler() in each of these, outermost first. Set
+	// directly on manager by GenerateAndServeCRMetricsWithOptions, the same
+	// way discoverer is, rather than threaded through newCRMetricsManager's
+	// already-long parameter list. Empty by default; see
+	// DiscoveryOptions.Middlewares.
+	middlewares []func(http.Handler) http.Handler
+
+	// allowedCIDRs, if non-empty, makes ipAllowlistMiddleware reject every
+	// request to metricsPath whose peer address doesn't fall in one of
+	// these networks. Set directly on manager by
+	// GenerateAndServeCRMetricsWithOptions, the same way middlewares is; see
+	// DiscoveryOptions.AllowedCIDRs.
+	allowedCIDRs []*net.IPNet
+
+	// cidrRejectionCount counts requests rejected by ipAllowlistMiddleware;
+	// read via CIDRRejections. Accessed atomically since it's incremented
+	// from request-handling goroutines.
+	cidrRejectionCount int64
+
+	// metricNamePrefix is forwarded to generateMetricFamilies for every GVK
+	// this manager builds stores for. Set directly on manager by
+	// GenerateAndServeCRMetricsWithOptions, the same way middlewares is; see
+	// DiscoveryOptions.MetricNamePrefix.
+	metricNamePrefix string
+
+	// groupQualifiedMetricNames is forwarded to generateMetricFamilies for
+	// every GVK this manager builds stores for. Set directly on manager the
+	// same way metricNamePrefix is; see DiscoveryOptions.GroupQualifiedMetricNames.
+	groupQualifiedMetricNames bool
+
+	// nameLabelMode is forwarded to generateMetricFamilies for every GVK
+	// this manager builds stores for. Set directly on manager the same way
+	// metricNamePrefix is; see DiscoveryOptions.NameLabelMode.
+	nameLabelMode NameLabelMode
+
+	// constLabels is forwarded to applyGVKOptions for every GVK this manager
+	// builds stores for, which appends it to every sample. Set directly on
+	// manager the same way metricNamePrefix is; see DiscoveryOptions.ConstLabels.
+	constLabels map[string]string
+}
+
+func newCRMetricsManager(cfg *rest.Config, ns []string, registry *storeRegistry,
+	metricsConfig *MetricsConfig, gvkOptions map[schema.GroupVersionKind]GVKOptions,
+	infoMetricLabelKeys []string, tlsOptions *TLSOptions, readyFn func() bool,
+	maxResourcesPerGVK int, metricsPath string, unixSocketMode os.FileMode,
+	timeouts TimeoutOptions, bearerTokenFile string, tokenReviewAuth *TokenReviewAuthOptions, enablePprof bool,
+	listenNetwork string) *CRMetricsManager {
+	if metricsPath == "" {
+		metricsPath = defaultMetricsPath
+	}
+	if unixSocketMode == 0 {
+		unixSocketMode = defaultUnixSocketMode
+	}
+	if timeouts.ReadTimeout == 0 {
+		timeouts.ReadTimeout = defaultReadTimeout
+	}
+	if timeouts.ReadHeaderTimeout == 0 {
+		timeouts.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	if timeouts.WriteTimeout == 0 {
+		timeouts.WriteTimeout = defaultWriteTimeout
+	}
+	if timeouts.IdleTimeout == 0 {
+		timeouts.IdleTimeout = defaultIdleTimeout
+	}
+	if timeouts.DrainTimeout == 0 {
+		timeouts.DrainTimeout = defaultDrainTimeout
+	}
+	if listenNetwork == "" {
+		listenNetwork = "tcp"
+	}
+	return &CRMetricsManager{
+		cfg:                 cfg,
+		ns:                  ns,
+		registry:            registry,
+		metricsConfig:       metricsConfig,
+		gvkOptions:          gvkOptions,
+		infoMetricLabelKeys: infoMetricLabelKeys,
+		trackedGVKs:         make(map[schema.GroupVersionKind]struct{}),
+		tlsOptions:          tlsOptions,
+		readyFn:             readyFn,
+		maxResourcesPerGVK:  maxResourcesPerGVK,
+		metricsPath:         metricsPath,
+		unixSocketMode:      unixSocketMode,
+		timeouts:            timeouts,
+		serveErrCh:          make(chan error, 1),
+		bearerTokenFile:     bearerTokenFile,
+		tokenReviewAuth:     tokenReviewAuth,
+		enablePprof:         enablePprof,
+		listenNetwork:       listenNetwork,
+	}
+}
+
+// AddGVK starts serving metrics for gvk, building its stores against the
+// cluster's current API resource discovery and the manager's current metric
+// configuration. Wildcard GVKs aren't accepted here; pass them to
+// GenerateAndServeCRMetricsWithOptions instead, which resolves them via its
+// own discovery loop.
+func (m *CRMetricsManager) AddGVK(gvk schema.GroupVersionKind) error {
+	if isWildcardGVK(gvk) {
+		return fmt.Errorf("AddGVK does not accept wildcard GVKs: %s", gvk.String())
+	}
+
+	apiResourceLists, err := getAPIResourceLists(m.cfg)
+	if err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	metricsConfig := m.metricsConfig
+	gvkOpts := m.gvkOptions[gvk]
+	m.mu.RUnlock()
+
+	stores, err := buildStoresForGVK(m.cfg, apiResourceLists, gvk, m.ns, metricsConfig, gvkOpts, m.infoMetricLabelKeys, m.maxResourcesPerGVK, m.metricNamePrefix, m.groupQualifiedMetricNames, m.nameLabelMode, m.constLabels)
+	if err != nil {
+		return err
+	}
+	m.registry.set(gvk, stores)
+
+	m.mu.Lock()
+	m.trackedGVKs[gvk] = struct{}{}
+	m.mu.Unlock()
+	return nil
+}
+
+// RemoveGVK stops serving metrics for gvk; its series stop appearing on the
+// next scrape.
+func (m *CRMetricsManager) RemoveGVK(gvk schema.GroupVersionKind) {
+	m.registry.remove(gvk)
+	m.mu.Lock()
+	delete(m.trackedGVKs, gvk)
+	m.mu.Unlock()
+}
+
+// Reload re-parses the metric family configuration at configPath and rebuilds
+// the stores for every GVK AddGVK has been called with, so edits to the
+// config take effect live. If the manager also has a wildcard-GVK discoverer
+// running (see GenerateAndServeCRMetricsWithOptions), the new config is
+// pushed into it too and an immediate resolveAll is triggered, so wildcard-
+// discovered GVKs pick up the reload the same as statically-registered ones.
+func (m *CRMetricsManager) Reload(configPath string) error {
+	metricsConfig, err := LoadMetricsConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.metricsConfig = metricsConfig
+	gvks := make([]schema.GroupVersionKind, 0, len(m.trackedGVKs))
+	for gvk := range m.trackedGVKs {
+		gvks = append(gvks, gvk)
+	}
+	m.mu.Unlock()
+
+	apiResourceLists, err := getAPIResourceLists(m.cfg)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	gvkOptions := m.gvkOptions
+	m.mu.RUnlock()
+	for _, gvk := range gvks {
+		stores, err := buildStoresForGVK(m.cfg, apiResourceLists, gvk, m.ns, metricsConfig, gvkOptions[gvk], m.infoMetricLabelKeys, m.maxResourcesPerGVK, m.metricNamePrefix, m.groupQualifiedMetricNames, m.nameLabelMode, m.constLabels)
+		if err != nil {
+			log.Error(err, "Failed to rebuild metrics stores on config reload", "gvk", gvk.String())
+			continue
+		}
+		m.registry.set(gvk, stores)
+	}
+
+	if m.discoverer != nil {
+		m.discoverer.SetMetricsConfig(metricsConfig)
+		m.discoverer.resolveAll(true)
+	}
+	return nil
+}
+
+// watchConfigFile starts an fsnotify watch on configPath's directory (rather
+// than the file itself, since ConfigMap volume mounts replace the file via a
+// symlink swap that a direct file watch misses) and calls Reload whenever
+// the file configPath resolves to changes. This mirrors the hot-reload
+// approach viper's WatchConfig uses for the same ConfigMap-symlink-swap
+// problem: a swap only ever touches the directory's "..data" symlink and
+// spawns a fresh "..timestamp" dir, never producing an event whose Name is
+// configPath itself, so matching on event.Name would silently never fire.
+func (m *CRMetricsManager) watchConfigFile(configPath string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		watcher.Close()
+		return err
+	}
+	m.watcher = watcher
+
+	realConfigFile, _ := filepath.EvalSymlinks(configPath)
+
+	go func() {
+		for event := range watcher.Events {
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			currentConfigFile, err := filepath.EvalSymlinks(configPath)
+			if err != nil || currentConfigFile == realConfigFile {
+				continue
+			}
+			realConfigFile = currentConfigFile
+			log.V(1).Info("Metrics config changed, reloading", "path", configPath)
+			if err := m.Reload(configPath); err != nil {
+				log.Error(err, "Failed to reload metrics config", "path", configPath)
+			}
+		}
+	}()
+	return nil
+}
+
+// Serve binds host:port and starts serving the manager's currently tracked
+// stores in a background goroutine, returning once the listener is bound so
+// a caller passing port 0 can immediately read back the actual address via
+// Addr. Unlike the old fire-and-forget "go ServeMetrics(...)" pattern, the
+// returned error reports a bind failure (e.g. the port already in use)
+// synchronously instead of losing it in the goroutine. If tlsOptions was
+// set, the keypair is also loaded synchronously here, so a bad cert/key
+// path, or an unrecognized name in tlsOptions.CipherSuites, is returned the
+// same way instead of only surfacing once the first scrape hits the
+// background goroutine.
+// host may also be a "unix://<path>" URL, in which case port is ignored and
+// the metrics are served over a unix domain socket at path instead of TCP;
+// see listen.
+func (m *CRMetricsManager) Serve(host string, port int32) error {
+	ln, err := m.listen(host, port)
+	if err != nil {
+		return err
+	}
+
+	var errorLog *stdlog.Logger
+	if m.tlsOptions != nil {
+		cert, err := tls.LoadX509KeyPair(m.tlsOptions.CertFile, m.tlsOptions.KeyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		minVersion := m.tlsOptions.MinVersion
+		if minVersion == 0 {
+			minVersion = tls.VersionTLS12
+		}
+		cipherSuites, err := resolveCipherSuites(m.tlsOptions.CipherSuites)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		tlsConfig := &tls.Config{
+			MinVersion:   minVersion,
+			CipherSuites: cipherSuites,
+		}
+		tlsConfig.GetCertificate = m.watchedCertificate(m.tlsOptions.CertFile, m.tlsOptions.KeyFile, cert)
+
+		if m.tlsOptions.ClientCAFile != "" {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			tlsConfig.GetConfigForClient = m.clientCAConfig(tlsConfig, m.tlsOptions.ClientCAFile)
+			errorLog = stdlog.New(&clientCertRejectionLogger{m: m}, "", 0)
+		}
+
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	var authClient kubernetes.Interface
+	if m.tokenReviewAuth != nil {
+		c, err := kubernetes.NewForConfig(m.cfg)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("building TokenReview/SubjectAccessReview client: %w", err)
+		}
+		authClient = c
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(m.metricsPath, m.authMiddleware(m.Handler(), authClient))
+	RegisterHealthChecks(mux, m.Ready)
+	if m.enablePprof {
+		mux.Handle(pprofPathPrefix, m.authMiddleware(pprofHandler(), authClient))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, fmt.Sprintf("not found; custom resource metrics are served at %s", m.metricsPath), http.StatusNotFound)
+	})
+	srv := &http.Server{
+		Handler:           mux,
+		ErrorLog:          errorLog,
+		ReadTimeout:       m.timeouts.ReadTimeout,
+		ReadHeaderTimeout: m.timeouts.ReadHeaderTimeout,
+		WriteTimeout:      m.timeouts.WriteTimeout,
+		IdleTimeout:       m.timeouts.IdleTimeout,
+	}
+
+	m.mu.Lock()
+	m.httpServer = srv
+	m.addr = ln.Addr().String()
+	m.mu.Unlock()
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Error(err, "Failed to serve custom resource metrics")
+			select {
+			case m.serveErrCh <- err:
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+// listen binds host, returning a listener on m.listenNetwork ("tcp", "tcp4",
+// or "tcp6") unless host is a "unix://<path>" URL, in which case it binds a
+// unix domain socket at path instead and port is ignored. A stale socket
+// file left behind by a previous, uncleanly-stopped process is removed
+// first, since net.Listen otherwise fails with "address already in use" on
+// a path that's just a leftover file and nothing is listening on it. The
+// socket file's permissions are set to unixSocketMode (see
+// newCRMetricsManager) so callers that need to restrict scraping to, say, a
+// sidecar sharing the same filesystem namespace can do so without relying
+// on network policy.
+//
+// host may be a bracketed IPv6 literal (e.g. "[::1]"), a bare one (e.g.
+// "::"), an IPv4 literal, or a hostname; the address passed to net.Listen is
+// always built with net.JoinHostPort rather than string concatenation, so a
+// bare IPv6 literal gets bracketed correctly instead of producing an
+// ambiguous "::1:8080" that net.Listen would misparse.
+func (m *CRMetricsManager) listen(host string, port int32) (net.Listener, error) {
+	path := strings.TrimPrefix(host, "unix://")
+	if path == host {
+		literal, err := normalizeListenHost(host)
+		if err != nil {
+			return nil, err
+		}
+		return net.Listen(m.listenNetwork, net.JoinHostPort(literal, strconv.Itoa(int(port))))
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing stale CR metrics socket %s: %w", path, err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, m.unixSocketMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("setting mode of CR metrics socket %s: %w", path, err)
+	}
+	return ln, nil
+}
+
+// normalizeListenHost strips a bracketed IPv6 literal's brackets (e.g.
+// "[::1]" becomes "::1") so the result can be passed to net.JoinHostPort,
+// which adds its own brackets whenever the host contains a colon. Returns a
+// descriptive error if host opens with "[" but never closes it, or if
+// what's inside the brackets (or, unbracketed, anything containing a colon)
+// isn't a valid IP literal.
+func normalizeListenHost(host string) (string, error) {
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end == -1 {
+			return "", fmt.Errorf("listen host %q has an opening \"[\" with no matching \"]\"", host)
+		}
+		literal := host[1:end]
+		if net.ParseIP(literal) == nil {
+			return "", fmt.Errorf("listen host %q is not a valid IP literal", host)
+		}
+		return literal, nil
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("listen host %q looks like a bare IPv6 literal but isn't a valid IP", host)
+	}
+	return host, nil
+}
+
+// resolveCipherSuites turns names, a list of TLS cipher suite names as
+// tls.CipherSuite.Name reports them, into the IDs tls.Config.CipherSuites
+// expects, returning an error naming the first entry that doesn't match any
+// suite crypto/tls knows about (secure or insecure) rather than silently
+// dropping it. An empty names defaults to defaultCipherSuites rather than
+// nil, so a TLSOptions with CipherSuites unset still gets a suite list with
+// every CBC suite excluded instead of crypto/tls's own zero-value default
+// ordering, which keeps CBC suites in for compatibility with older clients.
+func resolveCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return defaultCipherSuites(), nil
+	}
+
+	known := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		known[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// defaultCipherSuites returns the IDs of every cipher suite tls.CipherSuites
+// reports as safe to use today — AEAD, forward-secret, no CBC — for
+// TLSOptions.CipherSuites' default, so the metrics TLS listener doesn't rely
+// on crypto/tls's own default ordering, which also includes CBC suites for
+// backward compatibility with older clients that a FIPS-adjacent policy
+// forbidding CBC and TLS 1.0/1.1 on every listener can't allow.
+func defaultCipherSuites() []uint16 {
+	suites := tls.CipherSuites()
+	ids := make([]uint16, 0, len(suites))
+	for _, s := range suites {
+		if strings.Contains(s.Name, "_CBC_") {
+			continue
+		}
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+// watchedCertificate returns a tls.Config.GetCertificate callback that
+// serves initial (already loaded from certFile/keyFile by Serve) until
+// certFile's mtime changes on disk, at which point it reloads the pair and
+// serves that from then on. This is how cert-manager's periodic rotation of
+// the serving certificate takes effect without restarting the operator:
+// connections that already completed their handshake keep whatever
+// certificate they negotiated, since Go only calls GetCertificate for new
+// handshakes.
+func (m *CRMetricsManager) watchedCertificate(certFile, keyFile string, initial tls.Certificate) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var mtime time.Time
+	if info, err := os.Stat(certFile); err == nil {
+		mtime = info.ModTime()
+	}
+
+	var mu sync.Mutex
+	cert := initial
+	return func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		info, err := os.Stat(certFile)
+		if err != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			return &cert, nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if info.ModTime().Equal(mtime) {
+			return &cert, nil
+		}
+
+		reloaded, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Error(err, "Failed to reload rotated CR metrics TLS certificate, keeping the previous one", "certFile", certFile)
+			return &cert, nil
+		}
+		cert = reloaded
+		mtime = info.ModTime()
+		log.V(1).Info("Reloaded rotated CR metrics TLS certificate", "certFile", certFile)
+		return &cert, nil
+	}
+}
+
+// authMiddleware wraps next with whichever of bearerAuthMiddleware and
+// tokenReviewAuthMiddleware are configured, in that order, so any handler
+// that should be gated the same way the CR metrics endpoint is — e.g. the
+// pprof handlers EnablePprof mounts — gets identical auth behavior without
+// duplicating the wrapping logic. authClient is nil unless m.tokenReviewAuth
+// is set; Serve builds it once up front since it's shared across every
+// handler this wraps. ipAllowlistMiddleware, when configured, wraps
+// outermost of all so a disallowed peer is rejected before doing any
+// bearer/TokenReview auth work.
+func (m *CRMetricsManager) authMiddleware(next http.Handler, authClient kubernetes.Interface) http.Handler {
+	if m.bearerTokenFile != "" {
+		next = m.bearerAuthMiddleware(next)
+	}
+	if m.tokenReviewAuth != nil {
+		next = tokenReviewAuthMiddleware(next, authClient, *m.tokenReviewAuth, &m.tokenReviewAuthRejectionCount)
+	}
+	if len(m.allowedCIDRs) > 0 {
+		next = m.ipAllowlistMiddleware(next)
+	}
+	return next
+}
+
+// ipAllowlistMiddleware wraps next, rejecting every request whose peer
+// address — r.RemoteAddr, which net/http sets from the actual TCP
+// connection, never from a client-controlled header like X-Forwarded-For —
+// doesn't fall within one of m.allowedCIDRs. Defense in depth against a
+// NetworkPolicy that's supposed to restrict scrapers to the same set of
+// CIDRs but has drifted out of sync; see DiscoveryOptions.AllowedCIDRs.
+// Rejected requests get 403 and increment cidrRejectionCount (see
+// CIDRRejections).
+func (m *CRMetricsManager) ipAllowlistMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		peer := net.ParseIP(host)
+		if peer == nil {
+			atomic.AddInt64(&m.cidrRejectionCount, 1)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		for _, cidr := range m.allowedCIDRs {
+			if cidr.Contains(peer) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		atomic.AddInt64(&m.cidrRejectionCount, 1)
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+}
+
+// CIDRRejections counts requests to the CR metrics endpoint rejected for
+// originating outside DiscoveryOptions.AllowedCIDRs, for callers that want
+// to alert if traffic from an unexpected source is reaching the endpoint.
+// Always 0 when AllowedCIDRs is empty.
+func (m *CRMetricsManager) CIDRRejections() int64 {
+	return atomic.LoadInt64(&m.cidrRejectionCount)
+}
+
+// bearerAuthMiddleware wraps next, requiring every request to present
+// "Authorization: Bearer <token>" matching the current contents of
+// m.bearerTokenFile before it reaches next. The file is re-read whenever its
+// mtime changes (see watchedBearerToken), so rotating the token, e.g. via a
+// Secret-mounted file, takes effect without restarting the operator.
+// Requests without a match get 401 and increment bearerAuthRejectionCount
+// (see BearerAuthRejections) so probing the endpoint shows up as something
+// operators can alert on.
+func (m *CRMetricsManager) bearerAuthMiddleware(next http.Handler) http.Handler {
+	tokenFn := m.watchedBearerToken(m.bearerTokenFile)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := tokenFn()
+		if err != nil {
+			log.Error(err, "Failed to read CR metrics bearer token file", "path", m.bearerTokenFile)
+			atomic.AddInt64(&m.bearerAuthRejectionCount, 1)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		const prefix = "Bearer "
+		presented := r.Header.Get("Authorization")
+		// An empty configured token never matches, even against an empty
+		// Authorization header, so a token file emptied by a mount hiccup
+		// fails closed rather than accepting every request.
+		if token == "" || !strings.HasPrefix(presented, prefix) ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(presented, prefix)), []byte(token)) != 1 {
+			atomic.AddInt64(&m.bearerAuthRejectionCount, 1)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// watchedBearerToken returns a function that reads tokenFile's contents,
+// caching them until tokenFile's mtime changes on disk, the same reload
+// strategy watchedCertificate uses for the TLS serving certificate.
+func (m *CRMetricsManager) watchedBearerToken(tokenFile string) func() (string, error) {
+	var mu sync.Mutex
+	var mtime time.Time
+	var token string
+
+	return func() (string, error) {
+		info, err := os.Stat(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading CR metrics bearer token file %s: %w", tokenFile, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if info.ModTime().Equal(mtime) {
+			return token, nil
+		}
+
+		raw, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading CR metrics bearer token file %s: %w", tokenFile, err)
+		}
+		token = strings.TrimSpace(string(raw))
+		mtime = info.ModTime()
+		log.V(1).Info("Reloaded CR metrics bearer token", "path", tokenFile)
+		return token, nil
+	}
+}
+
+// BearerAuthRejections counts requests to the CR metrics endpoint rejected
+// for a missing or invalid bearer token, for callers that want to alert if
+// something is probing the endpoint without a valid token. Always 0 when
+// DiscoveryOptions.BearerTokenFile wasn't set.
+func (m *CRMetricsManager) BearerAuthRejections() int64 {
+	return atomic.LoadInt64(&m.bearerAuthRejectionCount)
+}
+
+// TokenReviewAuthRejections counts requests to the CR metrics endpoint
+// rejected by TokenReview/SubjectAccessReview authentication or
+// authorization, for callers that want to alert if something is probing the
+// endpoint without valid credentials. Always 0 when
+// DiscoveryOptions.TokenReviewAuth wasn't set.
+func (m *CRMetricsManager) TokenReviewAuthRejections() int64 {
+	return atomic.LoadInt64(&m.tokenReviewAuthRejectionCount)
+}
+
+// ThrottledScrapes counts requests to the CR metrics endpoint answered 503
+// because DiscoveryOptions.ScrapeLimits.MaxConcurrentRenders renders were
+// already in flight and none freed up in time.
+func (m *CRMetricsManager) ThrottledScrapes() int64 {
+	return m.registry.ThrottledScrapes()
+}
+
+// TimedOutScrapes counts renders answered 503 because they were still
+// running when DiscoveryOptions.ScrapeLimits.RenderTimeout elapsed.
+func (m *CRMetricsManager) TimedOutScrapes() int64 {
+	return m.registry.TimedOutScrapes()
+}
+
+// RegisterHealthChecks mounts /healthz and /readyz on mux: /healthz always
+// returns 200, and /readyz returns 200 while readyFn returns true (or
+// always, if readyFn is nil) and 503 otherwise. Serve mounts these
+// alongside /metrics so operator liveness/readiness probes can share the
+// metrics port instead of needing a separate containerPort; callers wiring
+// their own mux can call this directly too.
+func RegisterHealthChecks(mux *http.ServeMux, readyFn func() bool) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if readyFn != nil && !readyFn() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// clientCAConfig returns a tls.Config.GetConfigForClient callback that
+// re-reads caFile on every handshake and hands back base with its ClientCAs
+// set to the freshly parsed bundle, so rotating the CA bundle on disk takes
+// effect on the very next scrape instead of requiring a restart.
+func (m *CRMetricsManager) clientCAConfig(base *tls.Config, caFile string) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates found in %s", caFile)
+		}
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = pool
+		return cfg, nil
+	}
+}
+
+// ClientCertRejections counts client-certificate verification failures on
+// the TLS listener bound by Serve, for callers that want to alert if
+// Prometheus's scrape cert is rejected (e.g. because it expired).
+func (m *CRMetricsManager) ClientCertRejections() int64 {
+	return atomic.LoadInt64(&m.clientCertRejectionCount)
+}
+
+// clientCertRejectionLogger adapts the "http: TLS handshake error" lines
+// http.Server writes to its ErrorLog into clientCertRejectionCount,
+// counting only failures caused by client certificate verification rather
+// than every handshake error (e.g. a plain scanner probing the port).
+type clientCertRejectionLogger struct {
+	m *CRMetricsManager
+}
+
+func (l *clientCertRejectionLogger) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), "tls: ") && strings.Contains(string(p), "certificate") {
+		atomic.AddInt64(&l.m.clientCertRejectionCount, 1)
+	}
+	log.V(1).Info("TLS handshake error on CR metrics listener", "error", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// Handler returns the http.Handler Serve mounts at the configured metrics
+// path, wrapped in every DiscoveryOptions.Middlewares entry, outermost
+// first. Operators that already run their own HTTP server for controller-
+// runtime metrics and healthz can mount this directly (e.g. via
+// manager.AddMetricsExtraHandler) instead of GenerateAndServeCRMetrics
+// opening a second port just for CR metrics. Because the returned handler
+// reads from the same live registry Serve does, GVKs added, removed or
+// reloaded after the fact show up on either one without extra wiring. With
+// no middlewares configured, the default, this returns exactly m.registry,
+// so Serve's output is unchanged from before Middlewares existed.
+func (m *CRMetricsManager) Handler() http.Handler {
+	return chainMiddleware(m.registry, m.middlewares)
+}
+
+// chainMiddleware wraps h in each of middlewares, outermost first: the
+// request reaches middlewares[0] before any other, and h only runs once
+// every middleware has called through to its next handler.
+func chainMiddleware(h http.Handler, middlewares []func(http.Handler) http.Handler) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// StoresSynced reports whether every currently registered GVK's stores have
+// completed at least one initial list, so scrapes right after startup don't
+// read as empty/absent() in a way that pages on an empty-results alert
+// before the first list has even finished. Exposed as its own method (on
+// top of backing Serve's default /readyz) so callers running their own
+// liveness/readiness endpoint, e.g. via controller-runtime manager's
+// AddReadyzCheck, can wire the same check into it.
+func (m *CRMetricsManager) StoresSynced() bool {
+	return m.registry.allSynced()
+}
+
+// Ready is the readiness check Serve registers at /readyz by default: not
+// ready until StoresSynced, and after that deferring to readyFn if the
+// caller set one via DiscoveryOptions.ReadyFn.
+func (m *CRMetricsManager) Ready() bool {
+	if !m.StoresSynced() {
+		return false
+	}
+	if m.readyFn != nil {
+		return m.readyFn()
+	}
+	return true
+}
+
+// Addr returns the address Serve bound to, including the actual port chosen
+// when the caller passed port 0. It returns "" if Serve hasn't bound yet.
+func (m *CRMetricsManager) Addr() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.addr
+}
+
+// ServeErrors returns a channel that receives at most one error if the HTTP
+// server started by Serve stops unexpectedly (e.g. its listener's
+// underlying socket fails) after a successful bind. A bind failure itself
+// is returned directly from Serve and never appears here; this is only for
+// failures surfacing later, from the background goroutine Serve starts,
+// which previously only reached a log line. Callers that want to crash-loop
+// rather than keep running with no metrics endpoint should select on this
+// alongside ctx.Done() and os.Exit or panic on receipt.
+func (m *CRMetricsManager) ServeErrors() <-chan error {
+	return m.serveErrCh
+}
+
+// Shutdown gracefully drains in-flight scrapes and stops the HTTP server
+// started by Serve, honoring ctx's deadline. It's a no-op if Serve was never
+// called. It does not stop GVK reflectors or the wildcard discoverer; callers
+// that also want those torn down too should call stopAll (see the ctx
+// passed to GenerateAndServeCRMetricsWithOptions) instead.
+//
+// If ctx's deadline passes before every in-flight scrape finishes,
+// srv.Shutdown returns without having closed those connections; Shutdown
+// force-closes them via srv.Close rather than leaving them to linger past
+// the caller's requested deadline.
+func (m *CRMetricsManager) Shutdown(ctx context.Context) error {
+	m.mu.RLock()
+	srv := m.httpServer
+	m.mu.RUnlock()
+	if srv == nil {
+		return nil
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		_ = srv.Close()
+		return err
+	}
+	return nil
+}
+
+// ShutdownWithTimeout is Shutdown with a bounded deadline of timeout, for
+// callers tearing down on pod restart that want a hard cap on how long they
+// wait for in-flight scrapes to drain rather than constructing their own
+// context.WithTimeout.
+func (m *CRMetricsManager) ShutdownWithTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.Shutdown(ctx)
+}
+
+// stopAll tears down everything the manager owns: the HTTP listener started
+// by Serve and every GVK's reflectors (static and wildcard-discovered
+// alike). It's called once ctx passed to GenerateAndServeCRMetricsWithOptions
+// is cancelled, since at that point there's no deadline left to hand
+// Shutdown, unlike an explicit caller-driven Shutdown(ctx) which still has
+// one; it uses timeouts.DrainTimeout as a stand-in deadline instead.
+//
+// The HTTP server is drained before the reflectors are stopped, not after:
+// a scrape already in flight reads from the stores the reflectors keep
+// populated, and stopping those reflectors first would risk the scrape
+// panicking on a store that's been closed out from under it.
+func (m *CRMetricsManager) stopAll() {
+	if err := m.ShutdownWithTimeout(m.timeouts.DrainTimeout); err != nil {
+		log.Error(err, "Failed to shut down CR metrics HTTP server")
+	}
+	m.registry.stopAll()
+}
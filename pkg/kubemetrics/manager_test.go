@@ -0,0 +1,898 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// writeSelfSignedCert generates a throwaway self-signed certificate/key pair
+// for "127.0.0.1" and returns the paths of the PEM files it wrote under a
+// temporary directory that's cleaned up when t ends.
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	writeSelfSignedCertAt(t, certFile, keyFile, 1)
+	return certFile, keyFile
+}
+
+// writeSelfSignedCertAt generates a throwaway self-signed certificate/key
+// pair for "127.0.0.1" with the given serial number and writes it to
+// certFile/keyFile, overwriting whatever was there before.
+func writeSelfSignedCertAt(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() = %v", err)
+	}
+
+	writePEMFile(t, certFile, "CERTIFICATE", der)
+	writePEMFile(t, keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+}
+
+func TestManagerServeTLS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil,
+		&TLSOptions{CertFile: certFile, KeyFile: keyFile}, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	resp, err := client.Get("https://" + m.Addr() + defaultMetricsPath)
+	if err != nil {
+		t.Fatalf("GET %s%s = %v", m.Addr(), defaultMetricsPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("reading exposition body: %v", err)
+	}
+}
+
+func TestManagerServeTLSBadKeyPair(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil,
+		&TLSOptions{CertFile: "does-not-exist.crt", KeyFile: "does-not-exist.key"}, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+
+	if err := m.Serve("127.0.0.1", 0); err == nil {
+		t.Fatal("Serve() = nil error, want one for a missing cert/key pair")
+	}
+	if addr := m.Addr(); addr != "" {
+		t.Fatalf("Addr() = %q after a failed Serve(), want \"\"", addr)
+	}
+}
+
+// TestManagerServeDiscoversBoundPort asserts that passing port 0 to Serve
+// (as GenerateAndServeCRMetrics forwards it) resolves to an actual listening
+// port that's readable back through Addr as soon as Serve returns, instead
+// of callers having to guess or hardcode a port for e2e tests.
+func TestManagerServeDiscoversBoundPort(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	addr, err := net.ResolveTCPAddr("tcp", m.Addr())
+	if err != nil {
+		t.Fatalf("net.ResolveTCPAddr(%q) = %v", m.Addr(), err)
+	}
+	if addr.Port == 0 {
+		t.Fatal("Addr() resolved to port 0, want Serve(host, 0) to have picked an actual port")
+	}
+
+	resp, err := http.Get("http://" + m.Addr() + defaultMetricsPath)
+	if err != nil {
+		t.Fatalf("GET %s%s = %v", m.Addr(), defaultMetricsPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+// TestManagerServeIPv6Loopback asserts that Serve can bind and be scraped
+// over IPv6 when given a bare "::1" host, the case a dual-stack cluster with
+// IPv6-only Prometheus pods depends on.
+func TestManagerServeIPv6Loopback(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("::1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	resp, err := http.Get("http://" + m.Addr() + defaultMetricsPath)
+	if err != nil {
+		t.Fatalf("GET %s%s = %v", m.Addr(), defaultMetricsPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+// TestManagerServeTCP6RejectsIPv4Host asserts that ListenNetwork "tcp6"
+// actually restricts the bind to the IPv6 address family rather than being
+// ignored, by trying to bind an IPv4 literal through it.
+func TestManagerServeTCP6RejectsIPv4Host(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "tcp6")
+	if err := m.Serve("127.0.0.1", 0); err == nil {
+		t.Fatal("Serve(\"127.0.0.1\", 0) on a \"tcp6\" manager = nil error, want a bind failure")
+	}
+}
+
+func TestNormalizeListenHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{name: "ipv4 literal", host: "0.0.0.0", want: "0.0.0.0"},
+		{name: "hostname", host: "localhost", want: "localhost"},
+		{name: "bare ipv6 any", host: "::", want: "::"},
+		{name: "bare ipv6 loopback", host: "::1", want: "::1"},
+		{name: "bracketed ipv6 loopback", host: "[::1]", want: "::1"},
+		{name: "unterminated bracket", host: "[::1", wantErr: true},
+		{name: "bracketed garbage", host: "[not-an-ip]", wantErr: true},
+		{name: "bare garbage with colon", host: "not:an:ip", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := normalizeListenHost(tc.host)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("normalizeListenHost(%q) = %q, nil; want an error", tc.host, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeListenHost(%q) = %v", tc.host, err)
+			}
+			if got != tc.want {
+				t.Fatalf("normalizeListenHost(%q) = %q, want %q", tc.host, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestManagerServePortInUse asserts that Serve reports a bind failure as an
+// error instead of logging it and returning nil, so a caller propagating
+// Serve's error (as GenerateAndServeCRMetricsWithOptions does) fails loudly
+// rather than running with no metrics endpoint because another process
+// already holds the port.
+func TestManagerServePortInUse(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer occupied.Close()
+
+	addr := occupied.Addr().(*net.TCPAddr)
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve(addr.IP.String(), int32(addr.Port)); err == nil {
+		t.Fatal("Serve() = nil error, want one since the port is already in use")
+	}
+	if got := m.Addr(); got != "" {
+		t.Fatalf("Addr() = %q after a failed Serve(), want \"\"", got)
+	}
+
+	select {
+	case err := <-m.ServeErrors():
+		t.Fatalf("ServeErrors() received %v for a bind failure, want it untouched: Serve already returned that failure directly", err)
+	default:
+	}
+}
+
+func TestResolveCipherSuites(t *testing.T) {
+	t.Run("empty defaults to the secure suite list", func(t *testing.T) {
+		got, err := resolveCipherSuites(nil)
+		if err != nil {
+			t.Fatalf("resolveCipherSuites(nil) = %v", err)
+		}
+		want := defaultCipherSuites()
+		if len(got) != len(want) {
+			t.Fatalf("resolveCipherSuites(nil) = %d suites, want %d", len(got), len(want))
+		}
+	})
+
+	t.Run("known suite name resolves to its ID", func(t *testing.T) {
+		name := tls.CipherSuites()[0].Name
+		got, err := resolveCipherSuites([]string{name})
+		if err != nil {
+			t.Fatalf("resolveCipherSuites([%q]) = %v", name, err)
+		}
+		if len(got) != 1 || got[0] != tls.CipherSuites()[0].ID {
+			t.Fatalf("resolveCipherSuites([%q]) = %v, want [%d]", name, got, tls.CipherSuites()[0].ID)
+		}
+	})
+
+	t.Run("unknown suite name is rejected", func(t *testing.T) {
+		if _, err := resolveCipherSuites([]string{"TLS_NOT_A_REAL_SUITE"}); err == nil {
+			t.Fatal("resolveCipherSuites() = nil error, want one for an unrecognized suite name")
+		}
+	})
+}
+
+func TestDefaultCipherSuitesExcludesCBC(t *testing.T) {
+	for _, id := range defaultCipherSuites() {
+		if strings.Contains(tls.CipherSuiteName(id), "_CBC_") {
+			t.Fatalf("defaultCipherSuites() includes CBC suite %s, want only AEAD suites", tls.CipherSuiteName(id))
+		}
+	}
+}
+
+func TestManagerServeRejectsUnknownCipherSuite(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil,
+		&TLSOptions{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_NOT_A_REAL_SUITE"}},
+		nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err == nil {
+		t.Fatal("Serve() = nil error, want one for an unrecognized cipher suite name")
+	}
+}
+
+func TestManagerServeRejectsTLS10Client(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil,
+		&TLSOptions{CertFile: certFile, KeyFile: keyFile}, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	_, err := tls.Dial("tcp", m.Addr(), &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		MaxVersion:         tls.VersionTLS10,
+	})
+	if err == nil {
+		t.Fatal("tls.Dial() with a TLS 1.0-only client = nil error, want the handshake refused")
+	}
+}
+
+func TestManagerServeTLSCertRotation(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil,
+		&TLSOptions{CertFile: certFile, KeyFile: keyFile}, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	peerSerial := func() *big.Int {
+		conn, err := tls.Dial("tcp", m.Addr(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("tls.Dial() = %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	before := peerSerial()
+	if before.Cmp(big.NewInt(1)) != 0 {
+		t.Fatalf("initial serial = %s, want 1", before)
+	}
+
+	// mtime resolution on some filesystems is coarse enough that rewriting
+	// the files within the same tick wouldn't register as a change.
+	time.Sleep(10 * time.Millisecond)
+	writeSelfSignedCertAt(t, certFile, keyFile, 2)
+
+	after := peerSerial()
+	if after.Cmp(before) == 0 {
+		t.Fatal("serial number unchanged after rotating the certificate on disk")
+	}
+	if after.Cmp(big.NewInt(2)) != 0 {
+		t.Fatalf("rotated serial = %s, want 2", after)
+	}
+}
+
+// testCA is a throwaway certificate authority used to sign a server and a
+// client cert for the mTLS tests below.
+type testCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() = %v", err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// sign issues a leaf certificate for cn with serverAuth set for a server
+// cert (with a 127.0.0.1 SAN) or unset for a client cert, writing the
+// resulting PEM cert/key pair under a temporary directory.
+func (ca *testCA) sign(t *testing.T, cn string, serverAuth bool) (certFile, keyFile string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	if serverAuth {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() = %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	writePEMFile(t, certFile, "CERTIFICATE", der)
+	writePEMFile(t, keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	return certFile, keyFile
+}
+
+func writePEMFile(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating %s: %v", path, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		t.Fatalf("encoding %s into %s: %v", blockType, path, err)
+	}
+}
+
+func TestManagerServeMTLS(t *testing.T) {
+	ca := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	writePEMFile(t, caFile, "CERTIFICATE", ca.cert.Raw)
+	serverCertFile, serverKeyFile := ca.sign(t, "127.0.0.1", true)
+	clientCertFile, clientKeyFile := ca.sign(t, "prometheus", false)
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, &TLSOptions{
+		CertFile:     serverCertFile,
+		KeyFile:      serverKeyFile,
+		ClientCAFile: caFile,
+	}, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	t.Run("no client cert is rejected", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+		if _, err := client.Get("https://" + m.Addr() + defaultMetricsPath); err == nil {
+			t.Fatal("GET without a client cert = nil error, want a TLS handshake failure")
+		}
+
+		var rejections int64
+		for i := 0; i < 50; i++ {
+			if rejections = m.ClientCertRejections(); rejections > 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if rejections == 0 {
+			t.Fatal("ClientCertRejections() = 0, want at least 1 after a rejected handshake")
+		}
+	})
+
+	t.Run("valid client cert is accepted", func(t *testing.T) {
+		clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			t.Fatalf("tls.LoadX509KeyPair() = %v", err)
+		}
+		client := &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{clientCert},
+			}},
+		}
+		resp, err := client.Get("https://" + m.Addr() + defaultMetricsPath)
+		if err != nil {
+			t.Fatalf("GET with a valid client cert = %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	})
+}
+
+func TestManagerServeMetricsPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		metricsPath string
+		scrapePath  string
+	}{
+		{name: "default path", metricsPath: "", scrapePath: defaultMetricsPath},
+		{name: "custom path", metricsPath: "/operator/metrics", scrapePath: "/operator/metrics"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, tc.metricsPath, 0, TimeoutOptions{}, "", nil, false, "")
+			if err := m.Serve("127.0.0.1", 0); err != nil {
+				t.Fatalf("Serve() = %v", err)
+			}
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				m.Shutdown(ctx)
+			}()
+
+			resp, err := http.Get("http://" + m.Addr() + tc.scrapePath)
+			if err != nil {
+				t.Fatalf("GET %s = %v", tc.scrapePath, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("GET %s status = %d, want %d", tc.scrapePath, resp.StatusCode, http.StatusOK)
+			}
+
+			resp, err = http.Get("http://" + m.Addr() + "/some-other-path")
+			if err != nil {
+				t.Fatalf("GET /some-other-path = %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusNotFound {
+				t.Fatalf("GET /some-other-path status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading 404 body: %v", err)
+			}
+			if !strings.Contains(string(body), tc.scrapePath) {
+				t.Fatalf("404 body = %q, want it to mention %q", body, tc.scrapePath)
+			}
+		})
+	}
+}
+
+func TestManagerServePprofDisabledByDefault(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	resp, err := http.Get("http://" + m.Addr() + pprofPathPrefix)
+	if err != nil {
+		t.Fatalf("GET %s = %v", pprofPathPrefix, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET %s status = %d, want %d when EnablePprof is unset", pprofPathPrefix, resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestManagerServePprofEnabled(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, true, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	resp, err := http.Get("http://" + m.Addr() + pprofPathPrefix + "goroutine")
+	if err != nil {
+		t.Fatalf("GET %sgoroutine = %v", pprofPathPrefix, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %sgoroutine status = %d, want %d when EnablePprof is set", pprofPathPrefix, resp.StatusCode, http.StatusOK)
+	}
+
+	// The metrics endpoint's own not-found handling is unaffected by pprof
+	// being mounted alongside it.
+	resp, err = http.Get("http://" + m.Addr() + "/some-other-path")
+	if err != nil {
+		t.Fatalf("GET /some-other-path = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET /some-other-path status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+// unixHTTPClient returns an http.Client that dials socketPath over a unix
+// domain socket for every request, regardless of the host:port in the URL,
+// so tests can use the usual "http://<addr>/<path>" request shape against a
+// server bound to a socket file instead of a TCP port.
+func unixHTTPClient(socketPath string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+}
+
+func TestManagerServeUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "metrics.sock")
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("unix://"+socketPath, 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("os.Stat(%s) = %v", socketPath, err)
+	}
+	if perm := info.Mode().Perm(); perm != defaultUnixSocketMode {
+		t.Fatalf("socket mode = %o, want %o", perm, defaultUnixSocketMode)
+	}
+
+	resp, err := unixHTTPClient(socketPath).Get("http://unix" + defaultMetricsPath)
+	if err != nil {
+		t.Fatalf("GET over unix socket = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestManagerServeUnixSocketRemovesStaleSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "metrics.sock")
+	if err := os.WriteFile(socketPath, []byte("stale"), 0600); err != nil {
+		t.Fatalf("os.WriteFile() = %v", err)
+	}
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("unix://"+socketPath, 0); err != nil {
+		t.Fatalf("Serve() = %v, want it to remove the stale socket file and bind", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m.Shutdown(ctx)
+}
+
+func TestManagerServeUnixSocketCustomMode(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "metrics.sock")
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0600, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("unix://"+socketPath, 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("os.Stat(%s) = %v", socketPath, err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("socket mode = %o, want %o", perm, 0600)
+	}
+}
+
+// TestManagerServeReadHeaderTimeoutCutsOffSlowClient simulates a
+// slowloris-style client that opens a connection and never finishes sending
+// its request headers, asserting the server closes the connection once
+// ReadHeaderTimeout elapses instead of holding it open indefinitely.
+func TestManagerServeReadHeaderTimeoutCutsOffSlowClient(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0,
+		TimeoutOptions{ReadHeaderTimeout: 50 * time.Millisecond}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	conn, err := net.Dial("tcp", m.Addr())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("GET /metrics HTTP/1.1\r\n")); err != nil {
+		t.Fatalf("conn.Write() = %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err == nil {
+		t.Fatal("conn.Read() = nil error, want the server to close the connection once ReadHeaderTimeout elapses")
+	}
+}
+
+func TestManagerServeBearerAuth(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatalf("os.WriteFile() = %v", err)
+	}
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, tokenFile, nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	get := func(authHeader string) int {
+		req, err := http.NewRequest(http.MethodGet, "http://"+m.Addr()+defaultMetricsPath, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest() = %v", err)
+		}
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s = %v", defaultMetricsPath, err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Fatalf("GET without Authorization = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := get("Bearer wrong-token"); code != http.StatusUnauthorized {
+		t.Fatalf("GET with wrong token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := get("Bearer s3cr3t"); code != http.StatusOK {
+		t.Fatalf("GET with correct token = %d, want %d", code, http.StatusOK)
+	}
+	if got := m.BearerAuthRejections(); got != 2 {
+		t.Fatalf("BearerAuthRejections() = %d, want 2", got)
+	}
+
+	// Healthz/readyz stay open even with a token configured.
+	resp, err := http.Get("http://" + m.Addr() + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /healthz = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// Rotating the token file without restarting the server takes effect on
+	// the next request.
+	if err := os.WriteFile(tokenFile, []byte("new-token\n"), 0600); err != nil {
+		t.Fatalf("os.WriteFile() = %v", err)
+	}
+	if err := os.Chtimes(tokenFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("os.Chtimes() = %v", err)
+	}
+	if code := get("Bearer s3cr3t"); code != http.StatusUnauthorized {
+		t.Fatalf("GET with old token after rotation = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := get("Bearer new-token"); code != http.StatusOK {
+		t.Fatalf("GET with rotated token = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestManagerReady(t *testing.T) {
+	notSynced := int32(0)
+	synced := int32(1)
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "Gateway"}
+
+	tests := []struct {
+		name      string
+		synced    *int32
+		readyFn   func() bool
+		wantReady bool
+	}{
+		{name: "store not yet synced", synced: &notSynced, readyFn: nil, wantReady: false},
+		{name: "store synced, no readyFn", synced: &synced, readyFn: nil, wantReady: true},
+		{name: "store synced, readyFn false", synced: &synced, readyFn: func() bool { return false }, wantReady: false},
+		{name: "store synced, readyFn true", synced: &synced, readyFn: func() bool { return true }, wantReady: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := newStoreRegistry()
+			registry.set(gvk, []*managedStore{{MetricsStore: nil, synced: tc.synced}})
+
+			m := newCRMetricsManager(nil, nil, registry, nil, nil, nil, nil, tc.readyFn, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+			if got := m.Ready(); got != tc.wantReady {
+				t.Fatalf("Ready() = %v, want %v", got, tc.wantReady)
+			}
+		})
+	}
+}
+
+func TestManagerServeReadyzBeforeSync(t *testing.T) {
+	notSynced := int32(0)
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "Gateway"}
+
+	registry := newStoreRegistry()
+	registry.set(gvk, []*managedStore{{MetricsStore: nil, synced: &notSynced}})
+
+	m := newCRMetricsManager(nil, nil, registry, nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	resp, err := http.Get("http://" + m.Addr() + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("GET /readyz status = %d, want %d before the store has synced", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	atomic.StoreInt32(&notSynced, 1)
+
+	resp, err = http.Get("http://" + m.Addr() + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /readyz status = %d, want %d once the store has synced", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestRegisterHealthChecks(t *testing.T) {
+	tests := []struct {
+		name       string
+		readyFn    func() bool
+		path       string
+		wantStatus int
+	}{
+		{name: "healthz always 200", readyFn: func() bool { return false }, path: "/healthz", wantStatus: http.StatusOK},
+		{name: "readyz 200 when ready", readyFn: func() bool { return true }, path: "/readyz", wantStatus: http.StatusOK},
+		{name: "readyz 503 when not ready", readyFn: func() bool { return false }, path: "/readyz", wantStatus: http.StatusServiceUnavailable},
+		{name: "readyz 200 with nil readyFn", readyFn: nil, path: "/readyz", wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			RegisterHealthChecks(mux, tc.readyFn)
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("%s = %d, want %d", tc.path, rec.Code, tc.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func mapTruncatedTotalValue(t *testing.T, metric string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := mapTruncatedTotal.WithLabelValues(metric).Write(&m); err != nil {
+		t.Fatalf("mapTruncatedTotal.Write() = %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// smcpComponentStatus is a realistic, trimmed status.components payload from
+// a maistra ServiceMeshControlPlane v2: a map from component name to its
+// current readiness.
+func smcpComponentStatus() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status": map[string]interface{}{
+			"components": map[string]interface{}{
+				"pilot":             true,
+				"istio-ingressgateway": false,
+				"grafana":           true,
+			},
+		},
+	}}
+}
+
+func TestMapFamilyGeneratorEmitsOneSeriesPerMapEntry(t *testing.T) {
+	m := MetricSpec{Name: "smcp_component_ready", Path: "status.components", MapLabelName: "component"}
+	gen := mapFamilyGenerator(m)
+
+	family := gen.GenerateFunc(smcpComponentStatus())
+	if len(family.Metrics) != 3 {
+		t.Fatalf("len(Metrics) = %d, want 3, one per status.components entry", len(family.Metrics))
+	}
+
+	got := map[string]float64{}
+	for _, metric := range family.Metrics {
+		if len(metric.LabelKeys) != 3 || metric.LabelKeys[2] != "component" {
+			t.Fatalf("LabelKeys = %v, want [namespace name component]", metric.LabelKeys)
+		}
+		got[metric.LabelValues[2]] = metric.Value
+	}
+	want := map[string]float64{"pilot": 1, "istio-ingressgateway": 0, "grafana": 1}
+	for component, value := range want {
+		if got[component] != value {
+			t.Fatalf("got[%q] = %v, want %v", component, got[component], value)
+		}
+	}
+}
+
+func TestMapFamilyGeneratorTruncatesAboveMaxMapEntries(t *testing.T) {
+	m := MetricSpec{Name: "smcp_component_ready", Path: "status.components", MapLabelName: "component", MaxMapEntries: 2}
+	gen := mapFamilyGenerator(m)
+
+	before := mapTruncatedTotalValue(t, m.Name)
+	family := gen.GenerateFunc(smcpComponentStatus())
+	after := mapTruncatedTotalValue(t, m.Name)
+
+	if len(family.Metrics) != 2 {
+		t.Fatalf("len(Metrics) = %d, want 2 after truncation to MaxMapEntries", len(family.Metrics))
+	}
+	if after != before+1 {
+		t.Fatalf("mapTruncatedTotal = %v, want it incremented by 1", after-before)
+	}
+
+	var components []string
+	for _, metric := range family.Metrics {
+		components = append(components, metric.LabelValues[2])
+	}
+	if components[0] != "grafana" || components[1] != "istio-ingressgateway" {
+		t.Fatalf("components = %v, want the first two entries in sorted key order", components)
+	}
+}
+
+func TestMapFamilyGeneratorSanitizesMapKeys(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status": map[string]interface{}{
+			"components": map[string]interface{}{"weird\"name": true},
+		},
+	}}
+	gen := mapFamilyGenerator(MetricSpec{Name: "smcp_component_ready", Path: "status.components", MapLabelName: "component"})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 1 || family.Metrics[0].LabelValues[2] != `weird\"name` {
+		t.Fatalf("LabelValues = %v, want the map key's double-quote escaped", family.Metrics)
+	}
+}
+
+func TestMapFamilyGeneratorProducesNoSamplesWithoutTheMapField(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	gen := mapFamilyGenerator(MetricSpec{Name: "smcp_component_ready", Path: "status.components", MapLabelName: "component"})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 0 {
+		t.Fatalf("Metrics = %v, want none for an object with no status.components field", family.Metrics)
+	}
+}
+
+func TestParseMetricsConfigRequiresMapLabelNameForMapType(t *testing.T) {
+	raw := []byte(`
+resources:
+  - gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+    metrics:
+      - name: smcp_component_ready
+        type: Map
+        path: status.components
+`)
+	if _, err := ParseMetricsConfig(raw); err == nil {
+		t.Fatal("ParseMetricsConfig() = nil, want an error for a Map metric missing mapLabelName")
+	}
+}
+
+func TestParseMetricsConfigAcceptsMapType(t *testing.T) {
+	raw := []byte(`
+resources:
+  - gvk: {group: maistra.io, version: v2, kind: ServiceMeshControlPlane}
+    metrics:
+      - name: smcp_component_ready
+        type: Map
+        path: status.components
+        mapLabelName: component
+        maxMapEntries: 50
+`)
+	if _, err := ParseMetricsConfig(raw); err != nil {
+		t.Fatalf("ParseMetricsConfig() = %v", err)
+	}
+}
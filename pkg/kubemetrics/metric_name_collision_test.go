@@ -0,0 +1,82 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func TestDefaultInfoMetricNameQualifiesByGroup(t *testing.T) {
+	if got, want := defaultInfoMetricName("gateway", ""), "gateway_info"; got != want {
+		t.Fatalf("defaultInfoMetricName(%q, \"\") = %q, want %q", "gateway", got, want)
+	}
+	if got, want := defaultInfoMetricName("gateway", "networking.istio.io"), "networking_istio_io_gateway_info"; got != want {
+		t.Fatalf("defaultInfoMetricName(%q, %q) = %q, want %q", "gateway", "networking.istio.io", got, want)
+	}
+}
+
+func TestDetectDefaultMetricNameCollisionsFindsSharedKind(t *testing.T) {
+	gvks := []schema.GroupVersionKind{
+		{Group: "maistra.io", Version: "v2", Kind: "Gateway"},
+		{Group: "networking.istio.io", Version: "v1alpha3", Kind: "Gateway"},
+	}
+
+	if err := detectDefaultMetricNameCollisions(gvks, nil, false); err == nil {
+		t.Fatal("detectDefaultMetricNameCollisions() = nil, want an error for two GVKs both named gateway_info")
+	} else if !strings.Contains(err.Error(), "gateway_info") {
+		t.Fatalf("error = %v, want it to mention the colliding metric name", err)
+	}
+}
+
+func TestDetectDefaultMetricNameCollisionsResolvedByGroupQualifiedNames(t *testing.T) {
+	gvks := []schema.GroupVersionKind{
+		{Group: "maistra.io", Version: "v2", Kind: "Gateway"},
+		{Group: "networking.istio.io", Version: "v1alpha3", Kind: "Gateway"},
+	}
+
+	if err := detectDefaultMetricNameCollisions(gvks, nil, true); err != nil {
+		t.Fatalf("detectDefaultMetricNameCollisions() = %v, want nil once group-qualified names tell the two Gateways apart", err)
+	}
+}
+
+func TestDetectDefaultMetricNameCollisionsIgnoresGVKOptionsFamilyGeneratorsOverride(t *testing.T) {
+	gvks := []schema.GroupVersionKind{
+		{Group: "maistra.io", Version: "v2", Kind: "Gateway"},
+		{Group: "networking.istio.io", Version: "v1alpha3", Kind: "Gateway"},
+	}
+	gvkOptions := map[schema.GroupVersionKind]GVKOptions{
+		gvks[0]: {FamilyGenerators: []ksmetric.FamilyGenerator{{Name: "maistra_gateway_info"}}},
+	}
+
+	if err := detectDefaultMetricNameCollisions(gvks, gvkOptions, false); err != nil {
+		t.Fatalf("detectDefaultMetricNameCollisions() = %v, want nil since one Gateway's FamilyGenerators no longer uses the default name", err)
+	}
+}
+
+func TestDetectDefaultMetricNameCollisionsNoCollisionAmongDistinctKinds(t *testing.T) {
+	gvks := []schema.GroupVersionKind{
+		{Group: "networking.istio.io", Version: "v1alpha3", Kind: "Gateway"},
+		{Group: "networking.istio.io", Version: "v1alpha3", Kind: "VirtualService"},
+	}
+
+	if err := detectDefaultMetricNameCollisions(gvks, nil, false); err != nil {
+		t.Fatalf("detectDefaultMetricNameCollisions() = %v, want nil for two distinct Kinds", err)
+	}
+}
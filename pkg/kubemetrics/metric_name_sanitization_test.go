@@ -0,0 +1,82 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSanitizeKindForMetricNameMapsInvalidCharactersAndLeadingDigits(t *testing.T) {
+	tests := []struct {
+		kind string
+		want string
+	}{
+		{kind: "virtualservice", want: "virtualservice"},
+		{kind: "aggregatedapi-v2", want: "aggregatedapi_v2"},
+		{kind: "2faauthpolicy", want: "_2faauthpolicy"},
+		{kind: "my.weird/kind", want: "my_weird_kind"},
+		{kind: "9lives", want: "_9lives"},
+		{kind: "", want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.kind, func(t *testing.T) {
+			got := sanitizeKindForMetricName(tc.kind)
+			if got != tc.want {
+				t.Fatalf("sanitizeKindForMetricName(%q) = %q, want %q", tc.kind, got, tc.want)
+			}
+			if !metricNameRE.MatchString(got + "_info") {
+				t.Fatalf("sanitizeKindForMetricName(%q) = %q, produced a name metricNameRE still rejects", tc.kind, got)
+			}
+		})
+	}
+}
+
+func TestSanitizeKindForMetricNameIsStableAcrossCalls(t *testing.T) {
+	const kind = "aggregatedapi-v2"
+	first := sanitizeKindForMetricName(kind)
+	second := sanitizeKindForMetricName(kind)
+	if first != second {
+		t.Fatalf("sanitizeKindForMetricName(%q) = %q then %q, want the same result both times", kind, first, second)
+	}
+}
+
+func TestDefaultInfoMetricNameSanitizesDashAndLeadingDigit(t *testing.T) {
+	if got, want := defaultInfoMetricName("aggregatedapi-v2", ""), "aggregatedapi_v2_info"; got != want {
+		t.Fatalf("defaultInfoMetricName(%q, \"\") = %q, want %q", "aggregatedapi-v2", got, want)
+	}
+	if got, want := defaultInfoMetricName("2faauthpolicy", ""), "_2faauthpolicy_info"; got != want {
+		t.Fatalf("defaultInfoMetricName(%q, \"\") = %q, want %q", "2faauthpolicy", got, want)
+	}
+	if !metricNameRE.MatchString(defaultInfoMetricName("2faauthpolicy", "")) {
+		t.Fatalf("defaultInfoMetricName(%q, \"\") produced a name metricNameRE rejects", "2faauthpolicy")
+	}
+}
+
+func TestValidateDefaultMetricNamesAcceptsSanitizedKind(t *testing.T) {
+	gvks := []schema.GroupVersionKind{{Group: "istio.io", Version: "v1alpha1", Kind: "AggregatedAPI-V2"}}
+	if err := validateDefaultMetricNames(gvks, nil, false); err != nil {
+		t.Fatalf("validateDefaultMetricNames() = %v, want nil since sanitizeKindForMetricName fixes the dash", err)
+	}
+}
+
+func TestValidateDefaultMetricNamesRejectsNameStillIllegalAfterSanitization(t *testing.T) {
+	gvks := []schema.GroupVersionKind{{Group: "2nd.istio.io", Version: "v1alpha1", Kind: "Gateway"}}
+	if err := validateDefaultMetricNames(gvks, nil, true); err == nil {
+		t.Fatal("validateDefaultMetricNames() = nil, want an error since the group-qualified name starts with a digit")
+	}
+}
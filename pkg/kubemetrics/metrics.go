@@ -0,0 +1,1238 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kubemetrics extends the operator-sdk's vendored kube-metrics
+// helper (github.com/operator-framework/operator-sdk/pkg/kube-metrics) with
+// wildcard GVK discovery, CR-spec/status-derived metric families,
+// metrics.k8s.io resource usage, hot-reload, and cardinality controls. It's
+// a first-party package rather than a patch to the vendored dependency so
+// it survives `dep ensure`/`go mod vendor` re-vendoring.
+package kubemetrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("kubemetrics")
+
+// wildcard is the token operatorGVKs entries use in their Version or Kind
+// fields to mean "match anything discovered on the cluster for this group".
+const wildcard = "*"
+
+// DiscoveryOptions controls the optional CRD-discovery loop used to resolve
+// wildcard GVKs in GenerateAndServeCRMetrics.
+type DiscoveryOptions struct {
+	// DisableDiscovery turns off the background CRD watch/poll and restricts
+	// GenerateAndServeCRMetrics to the static behavior of only serving
+	// metrics for the exact GVKs passed in operatorGVKs. Wildcard entries
+	// are ignored when discovery is disabled.
+	DisableDiscovery bool
+
+	// ResyncPeriod is how often getAPIResourceLists is re-run to pick up
+	// newly installed CRD versions. Defaults to 30s when zero.
+	ResyncPeriod time.Duration
+
+	// ConfigPath, if set, is loaded as a MetricsConfig (see LoadMetricsConfig)
+	// declaring additional per-GVK metrics to extract from CR spec/status
+	// fields, on top of the default "<kind>_info" gauge.
+	ConfigPath string
+
+	// GVKOptions configures, per GVK, the Filters and ExtraLabels applied to
+	// that GVK's generated metric families, and — via
+	// GVKOptions.FamilyGenerators — what those families are in the first
+	// place, for callers that need more than the fixed "<kind>_info" gauge
+	// (e.g. condition metrics or a member count derived from CR status). A
+	// wildcard GVK's options are looked up using the wildcard entry itself as
+	// the map key and apply to every GVK it's discovered to expand to.
+	GVKOptions map[schema.GroupVersionKind]GVKOptions
+
+	// InfoMetricLabelKeys names metadata labels to surface as extra labels on
+	// the fixed "<kind>_info" gauge, for every GVK in operatorGVKs. This is
+	// for CRD authors who want e.g. a "team" or "version" label they set on
+	// their custom resources to show up on the exported metric without
+	// writing a full MetricsConfig entry for it.
+	InfoMetricLabelKeys []string
+
+	// MetricNamePrefix, if set, is prepended (with an underscore) to the
+	// fixed "<kind>_info" gauge's name, e.g. "istio_operator" turns
+	// "virtualservice_info" into "istio_operator_virtualservice_info" —
+	// useful for namespacing this operator's metrics apart from another
+	// exporter's kube-state-metrics-style "<kind>_info" gauge for the same
+	// kind. It is NOT applied to MetricsConfig-declared families or to
+	// GVKOptions.FamilyGenerators, since both already name their own metrics
+	// explicitly; PrefixedMetricName lets either opt in to the same prefix.
+	// Must match metricNameRE or GenerateAndServeCRMetricsWithOptions
+	// returns an error. Empty by default, preserving today's unprefixed
+	// names so existing dashboards built against them don't break.
+	MetricNamePrefix string
+
+	// GroupQualifiedMetricNames, if set, derives the fixed "<kind>_info"
+	// gauge's name from both the GVK's group and kind, e.g.
+	// "networking_istio_io_gateway_info", instead of kind alone. Without it,
+	// two GVKs that share a Kind in different groups — e.g. maistra.io
+	// Gateway and networking.istio.io Gateway both in operatorGVKs — both
+	// produce "gateway_info", and the two FamilyGenerators then disagree on
+	// that one series' HELP text. False by default, preserving today's
+	// "<kind>_info" names for operators whose operatorGVKs have no such
+	// collision. Whichever way this is set,
+	// GenerateAndServeCRMetricsWithOptions returns an error rather than
+	// serving broken output if any of operatorGVKs' static (non-wildcard)
+	// entries would still collide on their default family name; wildcard
+	// entries are resolved at runtime and can't be checked this way.
+	GroupQualifiedMetricNames bool
+
+	// NameLabelMode controls which label key(s) carry a CR's name on
+	// "<kind>_info" and every other per-object family generateMetricFamilies
+	// produces. NameLabelModeKind, the zero value, preserves today's
+	// kindName-keyed label (e.g. virtualservice="basic"). NameLabelModeName
+	// switches to the standard "name" label kube-state-metrics conventions
+	// use instead. NameLabelModeBoth emits both side by side, for migrating
+	// dashboards off the old label without a hard cutover. Defaults to
+	// NameLabelModeKind so existing dashboards don't break overnight.
+	NameLabelMode NameLabelMode
+
+	// ConstLabels is appended to every sample this package produces — every
+	// CR-generated family's metrics and every cr_metrics_scrape_* self-metric
+	// alike — the same way Prometheus client libraries' own ConstLabels work,
+	// e.g. {"cluster": "prod-us-east"} read from an env var so a central
+	// Prometheus scraping several clusters can tell their series apart
+	// without relying on external_labels alone. GenerateAndServeCRMetricsWithOptions
+	// returns an error rather than serving broken output if a key here isn't a
+	// legal Prometheus label name, collides with the "namespace"/"name"
+	// labels every family already carries, or collides with any GVK's
+	// GVKOptions.ExtraLabels, LabelsAllowlist or AnnotationsAllowlist target
+	// name, since a per-object label for that GVK would otherwise silently
+	// win or lose depending on append order. Nil by default, adding no
+	// labels.
+	ConstLabels map[string]string
+
+	// Elected, if set, makes GenerateAndServeCRMetricsWithOptions wait until
+	// Elected is closed before binding host:port — pass the channel returned
+	// by a controller-runtime manager's Elected() so only the replica that
+	// wins leader election serves metrics. Without it, every operator
+	// replica in an HA deployment races to bind the same port. If ctx is
+	// cancelled first, the metrics server never starts.
+	Elected <-chan struct{}
+
+	// ReadyFn, if set, backs the /readyz handler Serve registers alongside
+	// /metrics: a scrape of /readyz returns 200 while ReadyFn returns true
+	// and 503 otherwise. /healthz always returns 200 regardless of ReadyFn.
+	// This lets liveness/readiness probes share the metrics port instead of
+	// needing a separate containerPort. See RegisterHealthChecks to mount
+	// the same handlers on a caller-owned mux.
+	ReadyFn func() bool
+
+	// Retry configures how the initial getAPIResourceLists call rides out a
+	// temporarily unavailable API server (e.g. during a node restart or
+	// apiserver upgrade) instead of crashing the operator on startup.
+	Retry RetryOptions
+
+	// TLS, if set, serves the CR metrics endpoint over HTTPS instead of
+	// plain HTTP using the given certificate/key pair.
+	TLS *TLSOptions
+
+	// Metrics configures cardinality controls applied to every GVK's
+	// generated metrics.
+	Metrics MetricOptions
+
+	// MetricsPath is where Serve mounts the CR metrics handler. Defaults to
+	// "/metrics" when empty. Requests to any other path get a 404 naming the
+	// configured path. Must start with "/" if set.
+	MetricsPath string
+
+	// PathPrefix is prepended to "/metrics" to get the path Serve mounts the
+	// CR metrics handler at, for operators deployed behind a reverse proxy
+	// that strips a path prefix before forwarding the scrape (e.g.
+	// "/operator" makes Prometheus scrape "/operator/metrics"). Ignored if
+	// MetricsPath is also set, since MetricsPath already names the full
+	// path. Must start with "/" if set.
+	PathPrefix string
+
+	// UnixSocketMode sets the file mode of the socket file created when host
+	// is a "unix://<path>" URL (see Serve). Ignored for a regular TCP host.
+	// Defaults to 0660 when zero.
+	UnixSocketMode os.FileMode
+
+	// Timeouts configures the CR metrics HTTP server's connection timeouts.
+	// Every field defaults to a nonzero value when zero, so a caller who
+	// never sets this still gets the default timeouts rather than the
+	// unbounded ones net/http leaves in place when a http.Server's fields
+	// are left at their zero value.
+	Timeouts TimeoutOptions
+
+	// BearerTokenFile, if set, requires every request to MetricsPath to
+	// present "Authorization: Bearer <token>" matching the file's contents,
+	// so a pod that can merely reach the port can't read out custom resource
+	// names/namespaces without also having the token, e.g. one projected
+	// from a Secret alongside a ServiceMonitor's bearerTokenSecret. The file
+	// is re-read on token file changes (see Serve), so rotating the Secret
+	// takes effect without restarting the operator. /healthz and /readyz
+	// stay open so liveness/readiness probes keep working. Unauthorized
+	// requests are counted; see CRMetricsManager.BearerAuthRejections.
+	BearerTokenFile string
+
+	// TokenReviewAuth, if set, makes Serve authenticate and authorize every
+	// request to MetricsPath against the API server, the same mechanism
+	// kube-rbac-proxy uses, instead of requiring a second sidecar container
+	// just to front the metrics port with RBAC. Mutually usable alongside
+	// BearerTokenFile, though operators typically pick one or the other.
+	TokenReviewAuth *TokenReviewAuthOptions
+
+	// Gatherer, if set, makes Serve merge this Gatherer's families onto the
+	// same /metrics scrape as the CR metrics generated from operatorGVKs, so
+	// an operator can pass its controller-runtime manager's metrics registry
+	// (exposing workqueue_ and similar process metrics) and serve everything
+	// from one port instead of running a second one just for CR metrics. A
+	// family name already emitted by our own stores is skipped rather than
+	// duplicated; see storeRegistry.ServeHTTP.
+	Gatherer prometheus.Gatherer
+
+	// ScrapeLimits bounds how many scrape renders run concurrently and how
+	// long any one of them may take, so a burst of simultaneous scrapers
+	// (e.g. two Prometheus replicas plus a debugging curl) can't multiply
+	// the cost of walking every MetricsStore. See ScrapeLimitOptions.
+	ScrapeLimits ScrapeLimitOptions
+
+	// EnablePprof mounts net/http/pprof's /debug/pprof/* handlers on the CR
+	// metrics server when true. Disabled by default, since pprof can dump
+	// process memory contents and shouldn't be reachable by anything that
+	// can merely scrape /metrics. Subject to the same BearerTokenFile/
+	// TokenReviewAuth gating as MetricsPath when either is set.
+	EnablePprof bool
+
+	// AccessLog makes ServeHTTP log, at V(1) through this package's logr
+	// Logger, the remote address, path, status, bytes written, and render
+	// duration of every scrape it serves. Off by default: most deployments
+	// scrape every few seconds, and even a V(1)-gated line per scrape is
+	// noise nobody asked for, so the work of timing and recording the
+	// response is skipped entirely unless this is set.
+	AccessLog bool
+
+	// ListenNetwork is the network Serve binds: "tcp" (the default, binding
+	// both address families on a dual-stack host), "tcp4", or "tcp6". Set it
+	// to "tcp6" to bind only an IPv6 listener, e.g. when host is "::" on a
+	// cluster where IPv6-only Prometheus pods must reach this port and
+	// nothing should also bind the IPv4 wildcard address.
+	ListenNetwork string
+
+	// ResponseCache makes ServeHTTP reuse a recently rendered response
+	// instead of re-rendering every store on each scrape, for deployments
+	// with multiple scrapers (e.g. two Prometheus replicas plus a Thanos
+	// sidecar) hitting the endpoint within the same few seconds. See
+	// ResponseCacheOptions. Disabled by default.
+	ResponseCache ResponseCacheOptions
+
+	// Middlewares wraps CRMetricsManager.Handler() (and, through it, the
+	// handler Serve mounts at MetricsPath) in each of these, in the order
+	// given: Middlewares[0] is outermost and sees a request first, so it can
+	// run cross-cutting behavior — trace propagation, a custom audit header
+	// check — before the CR metrics handler itself runs, or inspect/modify
+	// the response on the way back out. Empty by default, which makes
+	// Handler() return exactly the bare registry it always has, with no
+	// wrapping at all.
+	Middlewares []func(http.Handler) http.Handler
+
+	// AllowedCIDRs restricts the CR metrics endpoint to clients whose peer
+	// address (the real TCP source address, never the client-controlled
+	// X-Forwarded-For header) falls within one of these CIDRs, e.g. a
+	// cluster's Prometheus pod CIDR. A request from outside all of them gets
+	// 403 and increments CRMetricsManager.CIDRRejections, the same shape as
+	// the existing bearer/TokenReview rejection counters. Defense in depth
+	// for when a NetworkPolicy meant to enforce the same restriction has
+	// drifted out of sync or isn't enforced at all. Empty by default, which
+	// allows every peer, the behavior before AllowedCIDRs existed.
+	AllowedCIDRs []string
+}
+
+// ResponseCacheOptions controls optional short-TTL caching of rendered
+// scrape responses.
+type ResponseCacheOptions struct {
+	// TTL is how long a rendered response is reused for later scrapes
+	// negotiating the same format and compression before it's re-rendered.
+	// Zero, the default, disables caching: every scrape renders fresh, the
+	// behavior before ResponseCache existed.
+	TTL time.Duration
+}
+
+// ScrapeLimitOptions caps the cost a burst of concurrent scrapes can impose
+// on the CR metrics endpoint.
+type ScrapeLimitOptions struct {
+	// MaxConcurrentRenders is the number of /metrics renders allowed to run
+	// at once; requests beyond the limit wait briefly for a slot and are
+	// answered 503 if none frees up in time. Defaults to 2 when zero. See
+	// CRMetricsManager.ThrottledScrapes.
+	MaxConcurrentRenders int
+
+	// RenderTimeout bounds how long a single render may run once it has a
+	// slot, so one scrape stuck behind a slow or overloaded apiserver can't
+	// also starve every other scraper waiting on MaxConcurrentRenders.
+	// Defaults to 30s when zero. See CRMetricsManager.TimedOutScrapes.
+	RenderTimeout time.Duration
+}
+
+// TokenReviewAuthOptions configures Kubernetes-native authentication and
+// authorization of requests to the CR metrics endpoint: the bearer token
+// presented by the client is checked with a TokenReview, then the resulting
+// user is checked for access to NonResourceURL with a SubjectAccessReview,
+// both against the cluster cfg was built from (see
+// GenerateAndServeCRMetricsWithOptions).
+type TokenReviewAuthOptions struct {
+	// NonResourceURL is the URL a SubjectAccessReview is issued for, so
+	// operators can grant scrape access via a ClusterRole rule like
+	// `nonResourceURLs: ["/metrics"], verbs: ["get"]`. Defaults to
+	// MetricsPath (or "/metrics") when empty.
+	NonResourceURL string
+
+	// CacheTTL bounds how long a TokenReview/SubjectAccessReview result is
+	// reused for the same bearer token before re-checking with the API
+	// server, so a steady stream of scrapes doesn't turn into a steady
+	// stream of TokenReview/SubjectAccessReview calls. Defaults to 10s when
+	// zero.
+	CacheTTL time.Duration
+}
+
+// TimeoutOptions configures http.Server's timeout fields for the CR metrics
+// listener, so a stuck scraper or a slowloris-style client holding a
+// connection open can't exhaust the operator's file descriptors. Each field
+// defaults to a conservative but generous value when zero: a misbehaving
+// client is cut off, but a healthy Prometheus scrape that's merely slow
+// because of a large payload or a loaded apiserver isn't.
+type TimeoutOptions struct {
+	// ReadTimeout bounds how long reading an entire request, including its
+	// body, may take. Defaults to 10s when zero.
+	ReadTimeout time.Duration
+
+	// ReadHeaderTimeout bounds how long reading just the request headers
+	// may take. Defaults to 5s when zero.
+	ReadHeaderTimeout time.Duration
+
+	// WriteTimeout bounds how long writing the response, i.e. rendering the
+	// scrape payload, may take. Defaults to 30s when zero.
+	WriteTimeout time.Duration
+
+	// IdleTimeout bounds how long a keep-alive connection may sit idle
+	// between requests before the server closes it. Defaults to 60s when
+	// zero.
+	IdleTimeout time.Duration
+
+	// DrainTimeout bounds how long stopAll, run once the ctx passed to
+	// GenerateAndServeCRMetricsWithOptions is cancelled, waits for an
+	// in-flight scrape to finish before force-closing its connection.
+	// CRMetricsManager.Shutdown doesn't use it and honors its own ctx's
+	// deadline instead. New scrapes are refused the moment
+	// shutdown begins, regardless of DrainTimeout; this only covers a
+	// scrape already in progress. Defaults to 10s when zero.
+	DrainTimeout time.Duration
+}
+
+// defaultReadTimeout, defaultReadHeaderTimeout, defaultWriteTimeout,
+// defaultIdleTimeout and defaultDrainTimeout are applied by
+// newCRMetricsManager whenever the corresponding TimeoutOptions field is
+// zero, including when a caller uses GenerateAndServeCRMetrics's
+// DiscoveryOptions{}-less signature.
+const (
+	defaultReadTimeout       = 10 * time.Second
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 60 * time.Second
+	defaultDrainTimeout      = 10 * time.Second
+)
+
+// MetricOptions configures limits on the metrics GenerateAndServeCRMetrics
+// generates, to keep a single misbehaving or simply large CRD kind from
+// blowing up the size of every scrape.
+type MetricOptions struct {
+	// MaxResourcesPerGVK caps how many custom resource instances of a single
+	// GVK are reflected into its metrics store. Once a GVK's cluster-wide
+	// instance count exceeds the cap, the excess is dropped and a single
+	// "<kind>_truncated" gauge is emitted with the number of objects dropped,
+	// so the cardinality blowup shows up as a metric instead of silently
+	// growing the scrape. Defaults to 0, meaning unlimited, to preserve
+	// today's behavior.
+	MaxResourcesPerGVK int
+}
+
+// RetryOptions configures the exponential-backoff retry loop
+// getAPIResourceListsWithRetry uses for the initial API resource discovery
+// call in GenerateAndServeCRMetricsWithOptions.
+type RetryOptions struct {
+	// InitialInterval is how long to wait before the first retry, doubling
+	// on every subsequent attempt up to MaxInterval. Defaults to 1s when
+	// zero.
+	InitialInterval time.Duration
+
+	// MaxInterval caps the backoff between retries. Defaults to 30s when
+	// zero.
+	MaxInterval time.Duration
+
+	// Timeout bounds the total time spent retrying; once it elapses, the
+	// most recent error is returned. Defaults to 2m when zero.
+	Timeout time.Duration
+}
+
+// TLSOptions configures HTTPS for the CR metrics endpoint, for clusters
+// whose security policy doesn't allow scraping plaintext HTTP across
+// namespaces.
+type TLSOptions struct {
+	// CertFile and KeyFile are paths to a PEM-encoded certificate and
+	// private key, as accepted by tls.LoadX509KeyPair.
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is the minimum TLS version the listener accepts, e.g.
+	// tls.VersionTLS12. Defaults to tls.VersionTLS12 when zero, which also
+	// rules out every CBC cipher suite TLS 1.0/1.1 would otherwise allow
+	// negotiating.
+	MinVersion uint16
+
+	// CipherSuites restricts the listener to these TLS 1.0-1.2 cipher suites,
+	// named the way tls.CipherSuite.Name reports them (e.g.
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"); TLS 1.3 suites are always
+	// negotiable regardless of this setting, per crypto/tls's own design —
+	// Go doesn't let a server configure them. Defaults to the suites
+	// tls.CipherSuites reports as safe (AEAD, forward-secret, no CBC) when
+	// empty, rather than crypto/tls's own zero-value default ordering, which
+	// also includes CBC suites for compatibility with older clients. An
+	// unrecognized name is rejected with an error from Serve rather than
+	// silently ignored.
+	CipherSuites []string
+
+	// ClientCAFile, if set, is a PEM bundle of CA certificates used to
+	// require and verify a client certificate on every connection, so only
+	// callers presenting a cert signed by one of these CAs (e.g. Prometheus
+	// instances issued a scrape cert) can reach the metrics endpoint. This
+	// replaces sidecaring kube-rbac-proxy just to gate the port. The bundle
+	// is re-read from disk on every handshake rather than parsed once at
+	// startup, so rotating it on disk takes effect without restarting the
+	// server.
+	ClientCAFile string
+}
+
+// GenerateAndServeCRMetrics generates CustomResource specific metrics for each custom resource GVK in operatorGVKs.
+// A list of namespaces, ns, can be passed to scope the generated metrics. Passing nil or an empty list of
+// namespaces will result in an error.
+// Entries in operatorGVKs may use "*" for Version and/or Kind; such entries are resolved dynamically against
+// the CustomResourceDefinitions installed on the cluster instead of against a single, fixed GVK.
+// The function also starts serving the generated collections of the metrics on given host and port, and
+// returns a CRMetricsManager that can be used to add/remove GVKs, reload the metric configuration without
+// restarting the operator, read back the bound address (useful when port is 0), and Shutdown the listener
+// to drain in-flight scrapes before the caller exits.
+// Cancelling ctx stops every GVK's reflectors (static and wildcard-discovered alike) and closes the
+// listener, so callers that restart their metrics stack on leader-election loss don't leak the old
+// list/watch loops into the new one.
+// host may be a "unix://<path>" URL to serve over a unix domain socket instead of TCP, in which case
+// port is ignored; see DiscoveryOptions.UnixSocketMode to control the socket file's permissions.
+// A port already in use (e.g. colliding with an operator's own controller-runtime metrics listener)
+// is returned here as an error, the same as any other failure above, rather than only surfacing as a
+// buried log line from a background goroutine; see CRMetricsManager.Serve and
+// TestManagerServePortInUse.
+func GenerateAndServeCRMetrics(ctx context.Context, cfg *rest.Config,
+	ns []string,
+	operatorGVKs []schema.GroupVersionKind,
+	host string, port int32) (*CRMetricsManager, error) {
+	return GenerateAndServeCRMetricsWithOptions(ctx, cfg, ns, operatorGVKs, host, port, DiscoveryOptions{})
+}
+
+// GenerateAndServeCRMetricsWithOptions behaves like GenerateAndServeCRMetrics but additionally accepts
+// DiscoveryOptions to control wildcard GVK resolution and config hot-reloading.
+func GenerateAndServeCRMetricsWithOptions(ctx context.Context, cfg *rest.Config,
+	ns []string,
+	operatorGVKs []schema.GroupVersionKind,
+	host string, port int32,
+	opts DiscoveryOptions) (*CRMetricsManager, error) {
+	// We have to have at least one namespace. The cluster-wide sentinel
+	// GetNamespacesForMetrics returns, []string{""}, satisfies this with a
+	// single entry rather than tripping it, since cluster-scoped GVKs never
+	// actually use ns to scope a list/watch (see isNamespaced).
+	if len(ns) < 1 {
+		return nil, errors.New(
+			"namespaces were empty; pass at least one namespace to generate custom resource metrics")
+	}
+	if opts.MetricsPath != "" && !strings.HasPrefix(opts.MetricsPath, "/") {
+		return nil, fmt.Errorf("metrics path %q must start with \"/\"", opts.MetricsPath)
+	}
+	if opts.PathPrefix != "" && !strings.HasPrefix(opts.PathPrefix, "/") {
+		return nil, fmt.Errorf("path prefix %q must start with \"/\"", opts.PathPrefix)
+	}
+	switch opts.ListenNetwork {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf(`listen network %q must be "tcp", "tcp4", or "tcp6"`, opts.ListenNetwork)
+	}
+	if opts.MetricNamePrefix != "" && !metricNameRE.MatchString(opts.MetricNamePrefix) {
+		return nil, fmt.Errorf("invalid metric name prefix %q: must match %s", opts.MetricNamePrefix, metricNameRE.String())
+	}
+	var allowedCIDRs []*net.IPNet
+	for _, cidr := range opts.AllowedCIDRs {
+		_, parsed, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing allowed CIDR %q: %w", cidr, err)
+		}
+		allowedCIDRs = append(allowedCIDRs, parsed)
+	}
+	metricsPath := opts.MetricsPath
+	if metricsPath == "" && opts.PathPrefix != "" {
+		metricsPath = opts.PathPrefix + defaultMetricsPath
+	}
+	log.V(1).Info("Starting collecting operator types")
+
+	apiResourceLists, err := getAPIResourceListsWithRetry(cfg, opts.Retry)
+	if err != nil {
+		return nil, err
+	}
+
+	var metricsConfig *MetricsConfig
+	if opts.ConfigPath != "" {
+		metricsConfig, err = LoadMetricsConfig(opts.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	registry := newStoreRegistry()
+	registry.extraGatherer = opts.Gatherer
+	registry.configureScrapeLimits(opts.ScrapeLimits)
+	registry.configureResponseCache(opts.ResponseCache)
+	registry.configureConstLabels(opts.ConstLabels)
+	registry.accessLog = opts.AccessLog
+	manager := newCRMetricsManager(cfg, ns, registry, metricsConfig, opts.GVKOptions, opts.InfoMetricLabelKeys, opts.TLS, opts.ReadyFn, opts.Metrics.MaxResourcesPerGVK, metricsPath, opts.UnixSocketMode, opts.Timeouts, opts.BearerTokenFile, opts.TokenReviewAuth, opts.EnablePprof, opts.ListenNetwork)
+	manager.middlewares = opts.Middlewares
+	manager.allowedCIDRs = allowedCIDRs
+	manager.metricNamePrefix = opts.MetricNamePrefix
+	manager.groupQualifiedMetricNames = opts.GroupQualifiedMetricNames
+	manager.nameLabelMode = opts.NameLabelMode
+	manager.constLabels = opts.ConstLabels
+
+	var staticGVKs []schema.GroupVersionKind
+	var wildcardGVKs []schema.GroupVersionKind
+	for _, gvk := range operatorGVKs {
+		if isWildcardGVK(gvk) {
+			wildcardGVKs = append(wildcardGVKs, gvk)
+		} else {
+			staticGVKs = append(staticGVKs, gvk)
+		}
+	}
+
+	if err := detectDefaultMetricNameCollisions(staticGVKs, opts.GVKOptions, opts.GroupQualifiedMetricNames); err != nil {
+		return nil, err
+	}
+	if err := validateDefaultMetricNames(staticGVKs, opts.GVKOptions, opts.GroupQualifiedMetricNames); err != nil {
+		return nil, err
+	}
+	if err := validateLabelsAllowlist(opts.GVKOptions); err != nil {
+		return nil, err
+	}
+	if err := validateAnnotationsAllowlist(opts.GVKOptions); err != nil {
+		return nil, err
+	}
+	if err := validateInfoMetricHelp(opts.GVKOptions); err != nil {
+		return nil, err
+	}
+	if err := validateConstLabels(opts.ConstLabels, opts.GVKOptions); err != nil {
+		return nil, err
+	}
+
+	// Loop through all the possible operator/custom resource specific types.
+	for _, gvk := range staticGVKs {
+		if err := manager.AddGVK(gvk); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(wildcardGVKs) > 0 && !opts.DisableDiscovery {
+		resyncPeriod := opts.ResyncPeriod
+		if resyncPeriod <= 0 {
+			resyncPeriod = defaultDiscoveryResync
+		}
+		d, err := newGVKDiscoverer(cfg, ns, wildcardGVKs, registry, resyncPeriod, metricsConfig, opts.GVKOptions, opts.InfoMetricLabelKeys, opts.Metrics.MaxResourcesPerGVK)
+		if err != nil {
+			return nil, err
+		}
+		d.metricNamePrefix = opts.MetricNamePrefix
+		d.groupQualifiedMetricNames = opts.GroupQualifiedMetricNames
+		d.nameLabelMode = opts.NameLabelMode
+		d.constLabels = opts.ConstLabels
+		manager.discoverer = d
+		go d.Run(ctx)
+	}
+
+	if opts.ConfigPath != "" {
+		if err := manager.watchConfigFile(opts.ConfigPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Tear down every reflector (static and wildcard-discovered alike) and
+	// the HTTP listener, if any, once ctx is cancelled. This is started
+	// before the Elected wait below so a replica that's cancelled while
+	// still waiting to win leadership doesn't leak its reflectors.
+	go func() {
+		<-ctx.Done()
+		manager.stopAll()
+	}()
+
+	if opts.Elected != nil {
+		log.V(1).Info("Waiting to win leader election before serving custom resource metrics")
+		select {
+		case <-opts.Elected:
+		case <-ctx.Done():
+			return manager, nil
+		}
+	}
+
+	// Start serving metrics. The registry is served directly (rather than a
+	// one-time snapshot) so that GVKs added, removed or reloaded later show
+	// up on /metrics without a restart. Serve binds synchronously so a bind
+	// failure is returned here instead of disappearing into a goroutine, and
+	// so manager.Addr() is already populated by the time this returns.
+	log.V(1).Info("Starting serving custom resource metrics")
+	if err := manager.Serve(host, port); err != nil {
+		return nil, err
+	}
+
+	return manager, nil
+}
+
+// PrefixedMetricName prepends prefix (with an underscore) to name, or
+// returns name unchanged if prefix is empty. generateMetricFamilies uses it
+// for the fixed "<kind>_info" gauge; a custom ksmetric.FamilyGenerator
+// passed via GVKOptions.FamilyGenerators, or a MetricsConfig-declared
+// MetricSpec.Name, can call it too to opt the same family into
+// DiscoveryOptions.MetricNamePrefix instead of being forced into it.
+func PrefixedMetricName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// qualifiedKindName returns the sanitized, optionally group-qualified kind
+// name every default metric family for this kind is built from, e.g.
+// "networking_istio_io_gateway" for group "networking.istio.io"; see
+// DiscoveryOptions.GroupQualifiedMetricNames. lowercasedKindName is run
+// through sanitizeKindForMetricName first, so a Kind containing characters
+// metricNameRE forbids (e.g. a dash, from an aggregated API) or starting
+// with a digit (e.g. some CRD Kinds) still produces a legal family name.
+func qualifiedKindName(lowercasedKindName, group string) string {
+	kindName := sanitizeKindForMetricName(lowercasedKindName)
+	if group == "" {
+		return kindName
+	}
+	return sanitizeMetricNameComponent(group) + "_" + kindName
+}
+
+// defaultInfoMetricName returns qualifiedKindName's "<kind>_info" gauge name.
+func defaultInfoMetricName(lowercasedKindName, group string) string {
+	return qualifiedKindName(lowercasedKindName, group) + "_info"
+}
+
+// defaultCreatedMetricName returns qualifiedKindName's "<kind>_created" gauge
+// name, generateMetricFamilies' counterpart to defaultInfoMetricName for the
+// CR's creation timestamp.
+func defaultCreatedMetricName(lowercasedKindName, group string) string {
+	return qualifiedKindName(lowercasedKindName, group) + "_created"
+}
+
+// invalidMetricNameCharRE matches any character a Prometheus metric name
+// component isn't allowed to contain, i.e. anything outside [a-zA-Z0-9_].
+var invalidMetricNameCharRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// sanitizeKindForMetricName maps every character in lowercasedKindName that
+// invalidMetricNameCharRE forbids to "_", then prefixes a leading "_" if the
+// result would otherwise start with a digit, since metricNameRE requires a
+// metric name to start with a letter, "_" or ":". It's a pure function of
+// lowercasedKindName, so the sanitized name stays the same across restarts.
+func sanitizeKindForMetricName(lowercasedKindName string) string {
+	s := invalidMetricNameCharRE.ReplaceAllString(lowercasedKindName, "_")
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		s = "_" + s
+	}
+	return s
+}
+
+// sanitizeMetricNameComponent replaces the characters metricNameRE forbids
+// that commonly appear in a Kubernetes API group, namely "." and "-", with
+// "_", e.g. "networking.istio.io" -> "networking_istio_io".
+func sanitizeMetricNameComponent(s string) string {
+	s = strings.ReplaceAll(s, ".", "_")
+	return strings.ReplaceAll(s, "-", "_")
+}
+
+// generateMetricFamilies builds the fixed "<kind>_info" gauge for kind,
+// named by PrefixedMetricName(prefix, defaultInfoMetricName(kind, group)),
+// alongside a "<kind>_created" gauge reporting the CR's creationTimestamp as
+// Unix seconds, the same parity kube-state-metrics' kube_pod_created gives
+// Pods. group is the GVK's group to qualify both names with, or "" to name
+// them "<lowercased-kind>_info"/"<lowercased-kind>_created" alone; see
+// DiscoveryOptions.GroupQualifiedMetricNames. In addition to the "namespace"
+// and kind-name label every "<kind>_info" series carries, labelKeys names
+// the object's metadata labels to surface as extra series labels (e.g. a
+// "team" or "version" label CRD authors have put on their custom
+// resources); a label missing from a given object resolves to an empty
+// string rather than dropping the metric. "<kind>_created" only ever
+// carries the namespace/name labels, not labelKeys, mirroring
+// kube_pod_created. generationDrift, set from GVKOptions.GenerationDriftMetrics,
+// additionally appends the "<kind>_metadata_generation" and
+// "<kind>_status_observed_generation" gauges generationFamilyGenerators
+// builds; deletionTimestamp, set from GVKOptions.DeletionTimestampMetric,
+// additionally appends the "<kind>_deletion_timestamp" gauge
+// deletionTimestampFamilyGenerator builds. ownerReferenceLabels, set from
+// GVKOptions.OwnerReferenceLabels, additionally appends "owner_kind" and
+// "owner_name" labels to "<kind>_info"; see ownerReferenceForLabels.
+// uidLabel, set from GVKOptions.UIDLabel, additionally appends a "uid"
+// label to "<kind>_info" taken from the object's metadata.uid. specHashLabel,
+// set from GVKOptions.SpecHashLabel, additionally appends a "spec_hash"
+// label to "<kind>_info" computed via SpecHash over the object's spec.
+// revisionLabel, set from GVKOptions.RevisionLabel, additionally appends a
+// "revision" label to "<kind>_info" computed via RevisionLabelValue.
+// versionLabel and groupLabel additionally label "<kind>_info" with
+// "version" and "group", taken from the GVK used to build the store rather
+// than from the object itself, so a CRD served under multiple API versions
+// (e.g. v1 and v1beta1) produces a distinguishable series per version even
+// though every object looks identical. Always on and additive, unlike
+// group, which only qualifies the metric *name* and only when
+// DiscoveryOptions.GroupQualifiedMetricNames is set. nameLabelMode, set from
+// DiscoveryOptions.NameLabelMode, controls which label key(s) carry the
+// object's name on every family below; see nameLabelPairs. namespaced, set
+// from isNamespaced, drops the "namespace" label from every family below
+// for a cluster-scoped GVK rather than emitting it as namespace="".
+func generateMetricFamilies(kind string, labelKeys []string, prefix string, group string, generationDrift bool, deletionTimestamp bool, ownerReferenceLabels bool, uidLabel bool, specHashLabel bool, revisionLabel bool, versionLabel string, groupLabel string, nameLabelMode NameLabelMode, namespaced bool, infoHelp string) []ksmetric.FamilyGenerator {
+	kindName := sanitizeKindForMetricName(strings.ToLower(kind))
+	infoMetricName := PrefixedMetricName(prefix, defaultInfoMetricName(kindName, group))
+	createdMetricName := PrefixedMetricName(prefix, defaultCreatedMetricName(kindName, group))
+	if infoHelp == "" {
+		infoHelp = fmt.Sprintf("Information about the %s custom resource.", kind)
+	}
+
+	families := []ksmetric.FamilyGenerator{
+		{
+			Name: infoMetricName,
+			Type: ksmetric.Gauge,
+			Help: infoHelp,
+			GenerateFunc: func(obj interface{}) *ksmetric.Family {
+				crd := obj.(*unstructured.Unstructured)
+				nameKeys, nameValues := nameLabelPairs(crd, kindName, nameLabelMode, namespaced)
+				keys := append(nameKeys, labelKeys...)
+				values := append(nameValues, labelValues(crd, labelKeys)...)
+				if ownerReferenceLabels {
+					ownerKind, ownerName := ownerReferenceForLabels(crd)
+					keys = append(keys, "owner_kind", "owner_name")
+					values = append(values, ownerKind, ownerName)
+				}
+				if uidLabel {
+					keys = append(keys, "uid")
+					values = append(values, string(crd.GetUID()))
+				}
+				if specHashLabel {
+					keys = append(keys, "spec_hash")
+					values = append(values, SpecHash(crd.Object["spec"]))
+				}
+				if revisionLabel {
+					keys = append(keys, "revision")
+					values = append(values, RevisionLabelValue(crd))
+				}
+				keys = append(keys, "version", "group")
+				values = append(values, versionLabel, groupLabel)
+				value := 1.0
+				if isTombstoned(crd) {
+					value = 0
+				}
+				return &ksmetric.Family{
+					Metrics: []*ksmetric.Metric{
+						{
+							Value:       value,
+							LabelKeys:   keys,
+							LabelValues: values,
+						},
+					},
+				}
+			},
+		},
+		{
+			Name: createdMetricName,
+			Type: ksmetric.Gauge,
+			Help: fmt.Sprintf("Unix creation timestamp of the %s custom resource.", kind),
+			GenerateFunc: func(obj interface{}) *ksmetric.Family {
+				crd := obj.(*unstructured.Unstructured)
+				created := crd.GetCreationTimestamp()
+				if created.IsZero() {
+					return &ksmetric.Family{}
+				}
+				keys, values := nameLabelPairs(crd, kindName, nameLabelMode, namespaced)
+				return &ksmetric.Family{
+					Metrics: []*ksmetric.Metric{
+						{
+							Value:       float64(created.Unix()),
+							LabelKeys:   keys,
+							LabelValues: values,
+						},
+					},
+				}
+			},
+		},
+	}
+	if generationDrift {
+		families = append(families, generationFamilyGenerators(kindName, prefix, group, nameLabelMode, namespaced)...)
+	}
+	if deletionTimestamp {
+		families = append(families, deletionTimestampFamilyGenerator(kindName, prefix, group, nameLabelMode, namespaced))
+	}
+	return families
+}
+
+// ownerReferenceForLabels resolves the "<kind>_info" owner_kind/owner_name
+// label pair for crd: the owner reference with Controller set to true, or,
+// if crd has owner references but none of them is marked as the
+// controller, the first one in metadata.ownerReferences, so the choice
+// among several non-controller owners is still deterministic rather than
+// depending on map iteration or apiserver response order. Both labels are
+// the empty string when crd has no owner references at all.
+func ownerReferenceForLabels(crd *unstructured.Unstructured) (ownerKind, ownerName string) {
+	refs := crd.GetOwnerReferences()
+	if len(refs) == 0 {
+		return "", ""
+	}
+	for _, ref := range refs {
+		if ref.Controller != nil && *ref.Controller {
+			return sanitizeLabelValue(ref.Kind), sanitizeLabelValue(ref.Name)
+		}
+	}
+	return sanitizeLabelValue(refs[0].Kind), sanitizeLabelValue(refs[0].Name)
+}
+
+// generationFamilyGenerators builds the "<kind>_metadata_generation" and
+// "<kind>_status_observed_generation" gauges generateMetricFamilies appends
+// when generationDrift is set, so a controller that's stopped reconciling
+// can be alerted on by diffing the two: metadata.generation bumps on every
+// spec change, while status.observedGeneration only catches up once a
+// controller has reconciled that change, so a persistent gap between them
+// means reconciliation is stuck. metadata.generation is always present on
+// any Kubernetes object, so its gauge always reports a sample;
+// status.observedGeneration is only ever set by a controller that's adopted
+// the observedGeneration convention, so its gauge omits the sample entirely
+// — rather than reporting 0, which would read as "caught up with
+// generation 0" — for an object that hasn't set it, or whose value can't be
+// converted to a number.
+func generationFamilyGenerators(kindName, prefix, group string, nameLabelMode NameLabelMode, namespaced bool) []ksmetric.FamilyGenerator {
+	generationMetricName := PrefixedMetricName(prefix, qualifiedKindName(kindName, group)+"_metadata_generation")
+	observedGenerationMetricName := PrefixedMetricName(prefix, qualifiedKindName(kindName, group)+"_status_observed_generation")
+
+	return []ksmetric.FamilyGenerator{
+		{
+			Name: generationMetricName,
+			Type: ksmetric.Gauge,
+			Help: "The generation of this custom resource, bumped by the API server on every spec change.",
+			GenerateFunc: func(obj interface{}) *ksmetric.Family {
+				crd := obj.(*unstructured.Unstructured)
+				keys, values := nameLabelPairs(crd, kindName, nameLabelMode, namespaced)
+				return &ksmetric.Family{
+					Metrics: []*ksmetric.Metric{
+						{
+							Value:       float64(crd.GetGeneration()),
+							LabelKeys:   keys,
+							LabelValues: values,
+						},
+					},
+				}
+			},
+		},
+		{
+			Name: observedGenerationMetricName,
+			Type: ksmetric.Gauge,
+			Help: "The generation of this custom resource last reconciled by its controller, per status.observedGeneration.",
+			GenerateFunc: func(obj interface{}) *ksmetric.Family {
+				crd := obj.(*unstructured.Unstructured)
+				value, ok, err := resolvePath(crd.Object, "status.observedGeneration")
+				if err != nil {
+					log.Error(err, "Failed to resolve status.observedGeneration", "metric", observedGenerationMetricName)
+					return &ksmetric.Family{}
+				}
+				if !ok {
+					return &ksmetric.Family{}
+				}
+				f, err := toFloat64(value, false)
+				if err != nil {
+					log.Error(err, "Failed to convert status.observedGeneration to a number", "metric", observedGenerationMetricName)
+					return &ksmetric.Family{}
+				}
+				keys, values := nameLabelPairs(crd, kindName, nameLabelMode, namespaced)
+				return &ksmetric.Family{
+					Metrics: []*ksmetric.Metric{
+						{
+							Value:       f,
+							LabelKeys:   keys,
+							LabelValues: values,
+						},
+					},
+				}
+			},
+		},
+	}
+}
+
+// deletionTimestampFamilyGenerator builds the "<kind>_deletion_timestamp"
+// gauge generateMetricFamilies appends when deletionTimestamp is set: Unix
+// seconds of metadata.deletionTimestamp for an object that's terminating,
+// letting `time() - <kind>_deletion_timestamp > threshold` alert on a CR
+// stuck in deletion because a finalizer never clears. An object that isn't
+// terminating has no deletionTimestamp at all, so its sample is omitted
+// rather than reported as 0, which would read as "deleted at the Unix
+// epoch". Once the object is actually removed, its MetricsStore entry goes
+// with it, so the sample disappears from the next scrape on its own.
+func deletionTimestampFamilyGenerator(kindName, prefix, group string, nameLabelMode NameLabelMode, namespaced bool) ksmetric.FamilyGenerator {
+	metricName := PrefixedMetricName(prefix, qualifiedKindName(kindName, group)+"_deletion_timestamp")
+	return ksmetric.FamilyGenerator{
+		Name: metricName,
+		Type: ksmetric.Gauge,
+		Help: "Unix timestamp of metadata.deletionTimestamp for a custom resource pending deletion.",
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			deletionTimestamp := crd.GetDeletionTimestamp()
+			if deletionTimestamp.IsZero() {
+				return &ksmetric.Family{}
+			}
+			keys, values := nameLabelPairs(crd, kindName, nameLabelMode, namespaced)
+			return &ksmetric.Family{
+				Metrics: []*ksmetric.Metric{
+					{
+						Value:       float64(deletionTimestamp.Unix()),
+						LabelKeys:   keys,
+						LabelValues: values,
+					},
+				},
+			}
+		},
+	}
+}
+
+// labelValues looks up each of labelKeys in obj's metadata labels, returning
+// "" for any key that isn't set rather than shortening the slice, so the
+// result always lines up positionally with labelKeys.
+func labelValues(obj *unstructured.Unstructured, labelKeys []string) []string {
+	objLabels := obj.GetLabels()
+	values := make([]string, len(labelKeys))
+	for i, k := range labelKeys {
+		values[i] = sanitizeLabelValue(objLabels[k])
+	}
+	return values
+}
+
+// GetNamespacesForMetrics wil return all namespaces which will be used to export the metrics.
+// If WATCH_NAMESPACE is "" or the operator-sdk all-namespaces sentinel "*" — both of which many
+// operators set to watch cluster-scoped resources like Istio's MeshConfig — it returns
+// []string{""} instead of an error. That slice still satisfies GenerateAndServeCRMetricsWithOptions'
+// "at least one namespace" guard, and isNamespaced routes cluster-scoped GVKs to
+// newClusterScopedMetricsStores regardless of what's in ns, so the empty-string entry is never
+// actually used to scope a namespaced list/watch.
+func GetNamespacesForMetrics(operatorNs string) ([]string, error) {
+	ns := []string{operatorNs}
+
+	// Get the value from WATCH_NAMESPACES
+	watchNamespace, err := k8sutil.GetWatchNamespace()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case watchNamespace == "" || watchNamespace == wildcard:
+		return []string{""}, nil
+	case strings.Contains(watchNamespace, ","):
+		// Generate metrics from the WATCH_NAMESPACES value if it contains multiple namespaces
+		ns = strings.Split(watchNamespace, ",")
+	}
+	return ns, nil
+}
+
+func isNamespaced(gvk schema.GroupVersionKind, resourceLists []*metav1.APIResourceList) (bool, error) {
+	for _, resourceList := range resourceLists {
+		if resourceList.GroupVersion == gvk.GroupVersion().String() {
+			for _, apiResource := range resourceList.APIResources {
+				if apiResource.Kind == gvk.Kind {
+					return apiResource.Namespaced, nil
+				}
+			}
+		}
+	}
+	return false, errors.New("unable to find type: " + gvk.String() + " in server")
+}
+
+// hasScaleSubresource reports whether gvk's resource advertises a
+// "<resource>/scale" entry in apiResourceLists, the same discovery data
+// isNamespaced and apiResourceNameForGVK already read gvk's resource name
+// and namespaced-ness from.
+func hasScaleSubresource(gvk schema.GroupVersionKind, apiResourceLists []*metav1.APIResourceList) bool {
+	resourceName, err := apiResourceNameForGVK(gvk, apiResourceLists)
+	if err != nil {
+		return false
+	}
+	scaleName := resourceName + "/scale"
+	for _, resourceList := range apiResourceLists {
+		if resourceList.GroupVersion != gvk.GroupVersion().String() {
+			continue
+		}
+		for _, apiResource := range resourceList.APIResources {
+			if apiResource.Name == scaleName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scaleReplicasFamilyGenerators returns the "<kind>_spec_replicas" and
+// "<kind>_status_ready_replicas" gauges buildStoresForGVK adds automatically
+// for a GVK with a scale subresource, the same pair kube-state-metrics
+// itself generates for Deployments. spec.replicas missing falls back to 1,
+// the scale subresource's own implied default when a CRD doesn't default
+// it explicitly; status.readyReplicas missing falls back to 0, since
+// "not yet reported" and "zero ready" read the same to a caller either way.
+func scaleReplicasFamilyGenerators(kind string) []ksmetric.FamilyGenerator {
+	kindName := sanitizeKindForMetricName(strings.ToLower(kind))
+	return []ksmetric.FamilyGenerator{
+		{
+			Name: kindName + "_spec_replicas",
+			Type: ksmetric.Gauge,
+			Help: fmt.Sprintf("Number of desired replicas for a %s, from its scale subresource's spec.replicas.", kind),
+			GenerateFunc: func(obj interface{}) *ksmetric.Family {
+				crd := obj.(*unstructured.Unstructured)
+				replicas, ok, _ := unstructured.NestedInt64(crd.Object, "spec", "replicas")
+				if !ok {
+					replicas = 1
+				}
+				return &ksmetric.Family{
+					Metrics: []*ksmetric.Metric{
+						{Value: float64(replicas), LabelKeys: []string{"namespace", "name"}, LabelValues: crdNameLabelValues(crd)},
+					},
+				}
+			},
+		},
+		{
+			Name: kindName + "_status_ready_replicas",
+			Type: ksmetric.Gauge,
+			Help: fmt.Sprintf("Number of ready replicas for a %s, from its scale subresource's status.readyReplicas.", kind),
+			GenerateFunc: func(obj interface{}) *ksmetric.Family {
+				crd := obj.(*unstructured.Unstructured)
+				ready, ok, _ := unstructured.NestedInt64(crd.Object, "status", "readyReplicas")
+				if !ok {
+					ready = 0
+				}
+				return &ksmetric.Family{
+					Metrics: []*ksmetric.Metric{
+						{Value: float64(ready), LabelKeys: []string{"namespace", "name"}, LabelValues: crdNameLabelValues(crd)},
+					},
+				}
+			},
+		},
+	}
+}
+
+// isWildcardGVK reports whether gvk uses the wildcard token in its Version
+// and/or Kind field.
+func isWildcardGVK(gvk schema.GroupVersionKind) bool {
+	return gvk.Version == wildcard || gvk.Kind == wildcard
+}
+
+// familyGeneratorsForGVK picks the metric families buildStoresForGVK builds
+// stores from, before metricsConfig's and gvkOpts' families/filters are
+// layered on: gvkOpts.FamilyGenerators wins if set, then whatever's
+// registered for gvk via RegisterFamilyGenerator, falling back to the fixed
+// "<kind>_info" gauge generateMetricFamilies produces when neither applies.
+// groupQualifiedMetricNames forwards DiscoveryOptions.GroupQualifiedMetricNames
+// to that fallback, and gvkOpts.GenerationDriftMetrics/gvkOpts.DeletionTimestampMetric/gvkOpts.InfoMetricHelp
+// forward to its generationDrift/deletionTimestamp/infoHelp parameters — all
+// four only take effect on that fallback path, since a FamilyGenerators override
+// or a RegisterFamilyGenerator registration replaces generateMetricFamilies'
+// output entirely. gvk.Version and gvk.Group are likewise forwarded to that
+// fallback's version/group labels, so a GVK matched at two different
+// versions (e.g. by a wildcard discovery) produces distinguishable
+// "<kind>_info" series. nameLabelMode forwards DiscoveryOptions.NameLabelMode
+// to that same fallback. namespaced forwards isNamespaced's determination
+// for gvk to that same fallback, so a cluster-scoped GVK's families drop
+// the "namespace" label instead of emitting it empty.
+func familyGeneratorsForGVK(gvk schema.GroupVersionKind, kind string, gvkOpts GVKOptions, infoMetricLabelKeys []string, metricNamePrefix string, groupQualifiedMetricNames bool, nameLabelMode NameLabelMode, namespaced bool) []ksmetric.FamilyGenerator {
+	if len(gvkOpts.FamilyGenerators) > 0 {
+		return append([]ksmetric.FamilyGenerator(nil), gvkOpts.FamilyGenerators...)
+	}
+	if gens, ok := defaultFamilyGenerators.familyGeneratorsFor(gvk); ok {
+		return append([]ksmetric.FamilyGenerator(nil), gens...)
+	}
+	group := ""
+	if groupQualifiedMetricNames {
+		group = gvk.Group
+	}
+	return generateMetricFamilies(kind, infoMetricLabelKeys, metricNamePrefix, group, gvkOpts.GenerationDriftMetrics, gvkOpts.DeletionTimestampMetric, gvkOpts.OwnerReferenceLabels, gvkOpts.UIDLabel, gvkOpts.SpecHashLabel, gvkOpts.RevisionLabel, gvk.Version, gvk.Group, nameLabelMode, namespaced, gvkOpts.InfoMetricHelp)
+}
+
+// detectDefaultMetricNameCollisions returns an error naming a default
+// "<kind>_info" family name shared by more than one of staticGVKs, and every
+// GVK sharing it; if several names collide, only the first one found is
+// reported. For example, both
+// maistra.io Gateway and networking.istio.io Gateway producing
+// "gateway_info" when groupQualifiedMetricNames is false. A GVK whose
+// gvkOptions entry sets FamilyGenerators, or that has generators registered
+// via RegisterFamilyGenerator, is skipped: familyGeneratorsForGVK never
+// falls back to the default name for it, so it can't collide on one.
+// Wildcard GVKs aren't checked here since they're resolved against the
+// cluster at runtime, not known at startup.
+func detectDefaultMetricNameCollisions(staticGVKs []schema.GroupVersionKind, gvkOptions map[schema.GroupVersionKind]GVKOptions, groupQualifiedMetricNames bool) error {
+	gvksByName := map[string][]schema.GroupVersionKind{}
+	for _, gvk := range staticGVKs {
+		if opts := gvkOptions[gvk]; len(opts.FamilyGenerators) > 0 {
+			continue
+		}
+		if _, ok := defaultFamilyGenerators.familyGeneratorsFor(gvk); ok {
+			continue
+		}
+		group := ""
+		if groupQualifiedMetricNames {
+			group = gvk.Group
+		}
+		name := defaultInfoMetricName(strings.ToLower(gvk.Kind), group)
+		gvksByName[name] = append(gvksByName[name], gvk)
+	}
+
+	for name, gvks := range gvksByName {
+		if len(gvks) <= 1 {
+			continue
+		}
+		gvkStrings := make([]string, len(gvks))
+		for i, gvk := range gvks {
+			gvkStrings[i] = gvk.String()
+		}
+		return fmt.Errorf("GVKs %s would all produce the metric family %q; set DiscoveryOptions.GroupQualifiedMetricNames, or give one of them a GVKOptions.FamilyGenerators override, to resolve the collision", strings.Join(gvkStrings, ", "), name)
+	}
+	return nil
+}
+
+// validateDefaultMetricNames returns an error naming the first GVK in
+// staticGVKs whose default "<kind>_info" family name is still illegal after
+// sanitizeKindForMetricName and sanitizeMetricNameComponent have run, e.g.
+// because groupQualifiedMetricNames is set and the GVK's group itself starts
+// with a digit. A GVK skipped by detectDefaultMetricNameCollisions for the
+// same reasons (a FamilyGenerators override, or one registered via
+// RegisterFamilyGenerator) is skipped here too, since neither ever falls
+// back to the default name.
+func validateDefaultMetricNames(staticGVKs []schema.GroupVersionKind, gvkOptions map[schema.GroupVersionKind]GVKOptions, groupQualifiedMetricNames bool) error {
+	for _, gvk := range staticGVKs {
+		if opts := gvkOptions[gvk]; len(opts.FamilyGenerators) > 0 {
+			continue
+		}
+		if _, ok := defaultFamilyGenerators.familyGeneratorsFor(gvk); ok {
+			continue
+		}
+		group := ""
+		if groupQualifiedMetricNames {
+			group = gvk.Group
+		}
+		name := defaultInfoMetricName(strings.ToLower(gvk.Kind), group)
+		if !metricNameRE.MatchString(name) {
+			return fmt.Errorf("GVK %s would produce the metric family %q, which isn't a legal Prometheus metric name even after sanitization; give it a GVKOptions.FamilyGenerators override to resolve this", gvk.String(), name)
+		}
+	}
+	return nil
+}
+
+// buildStoresForGVK resolves the namespaced/cluster-scoped decision for gvk against
+// apiResourceLists and constructs the MetricsStores backing it. gvkOpts.FamilyGenerators,
+// if set, is used in place of the fixed "<kind>_info" gauge; otherwise, if gvk has
+// generators registered via RegisterFamilyGenerator, those are used instead;
+// otherwise generateMetricFamilies supplies it. If metricsConfig declares a
+// CustomResourceMetricSpec for gvk, its additional metric families are generated
+// alongside whichever of the above applied. gvkOpts' Filters and ExtraLabels are
+// then applied to every generated family. infoMetricLabelKeys is forwarded to
+// generateMetricFamilies to extract extra metadata labels onto the
+// "<kind>_info" gauge. maxResourcesPerGVK, if positive, caps how many
+// instances of gvk are reflected into the returned stores; see MetricOptions.
+// gvkOpts.MaxResources, if positive, overrides maxResourcesPerGVK for gvk
+// alone. metricNamePrefix is forwarded to generateMetricFamilies; see
+// DiscoveryOptions.MetricNamePrefix. groupQualifiedMetricNames and
+// nameLabelMode are forwarded the same way; see
+// DiscoveryOptions.GroupQualifiedMetricNames and DiscoveryOptions.NameLabelMode.
+// constLabels is forwarded to applyGVKOptions, which appends it to every
+// family's samples; see DiscoveryOptions.ConstLabels.
+func buildStoresForGVK(cfg *rest.Config, apiResourceLists []*metav1.APIResourceList,
+	gvk schema.GroupVersionKind, ns []string, metricsConfig *MetricsConfig, gvkOpts GVKOptions,
+	infoMetricLabelKeys []string, maxResourcesPerGVK int, metricNamePrefix string, groupQualifiedMetricNames bool, nameLabelMode NameLabelMode, constLabels map[string]string) ([]*managedStore, error) {
+	kind := gvk.Kind
+	namespaced, err := isNamespaced(gvk, apiResourceLists)
+	if err != nil {
+		return nil, err
+	}
+	metricFamilies := familyGeneratorsForGVK(gvk, kind, gvkOpts, infoMetricLabelKeys, metricNamePrefix, groupQualifiedMetricNames, nameLabelMode, namespaced)
+	if spec, ok := metricsConfig.specFor(gvk); ok {
+		metricFamilies = append(metricFamilies, generateConfiguredFamilies(spec)...)
+	}
+	if hasScaleSubresource(gvk, apiResourceLists) {
+		metricFamilies = append(metricFamilies, scaleReplicasFamilyGenerators(kind)...)
+	}
+	if gvkOpts.FinalizerCountMetric {
+		metricFamilies = append(metricFamilies, finalizerCountFamilyGenerator(kind))
+	}
+	if gvkOpts.FinalizerLabelsMetric {
+		metricFamilies = append(metricFamilies, finalizerLabelsFamilyGenerator(kind))
+	}
+	if gvkOpts.StatusPhaseMetric != nil {
+		metricFamilies = append(metricFamilies, statusPhaseFamilyGenerator(kind, *gvkOpts.StatusPhaseMetric))
+	}
+	if gvkOpts.AgeMetric {
+		metricFamilies = append(metricFamilies, ageFamilyGenerator(kind, namespaced, realClock))
+	}
+	if gvkOpts.OwnedMetric {
+		metricFamilies = append(metricFamilies, ownedFamilyGenerator(kind))
+	}
+	var updatesTracker *TransitionCounterTracker
+	if gvkOpts.UpdatesCounterMetric {
+		updatesTracker = NewTransitionCounterTracker()
+		metricFamilies = append(metricFamilies, updatesTotalFamilyGenerator(kind, updatesTracker))
+	}
+	var sizeTracker *SizeTracker
+	if gvkOpts.SizeMetric {
+		sizeTracker = NewSizeTracker()
+		metricFamilies = append(metricFamilies, objectSizeFamilyGenerator(kind, sizeTracker))
+	}
+	metricFamilies, err = applyGVKOptions(metricFamilies, gvkOpts, constLabels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filters for %s: %w", gvk.String(), err)
+	}
+	log.V(1).Info("Generating metric families", "gvk", gvk.String())
+
+	dclient, err := dynamicClientForGVK(cfg, apiResourceLists, gvk)
+	if err != nil {
+		return nil, err
+	}
+	if gvkOpts.MaxResources > 0 {
+		maxResourcesPerGVK = gvkOpts.MaxResources
+	}
+	if namespaced {
+		return newNamespacedMetricsStores(dclient, ns, metricFamilies, kind, maxResourcesPerGVK, gvkOpts.CountMetric, updatesTracker, sizeTracker, gvkOpts.TombstoneGracePeriod), nil
+	}
+	return newClusterScopedMetricsStores(dclient, metricFamilies, kind, maxResourcesPerGVK, gvkOpts.CountMetric, updatesTracker, sizeTracker, gvkOpts.TombstoneGracePeriod), nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// headerMiddleware returns an example middleware, of the shape
+// DiscoveryOptions.Middlewares accepts, that sets a response header naming
+// itself before delegating to next — the kind of audit-header or tracing
+// middleware the request this feature was built for wants to plug in.
+func headerMiddleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestCRMetricsManagerHandlerAppliesMiddlewaresInOrder(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	m.middlewares = []func(http.Handler) http.Handler{headerMiddleware("first"), headerMiddleware("second")}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, req)
+
+	got := w.Header().Values("X-Middleware")
+	want := []string{"first", "second"}
+	if len(got) != len(want) {
+		t.Fatalf("X-Middleware = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("X-Middleware = %v, want %v: middlewares[0] should run first", got, want)
+		}
+	}
+}
+
+func TestCRMetricsManagerHandlerIsUnchangedWithNoMiddlewares(t *testing.T) {
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil, nil, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+
+	if m.Handler() != m.registry {
+		t.Fatal("Handler() with no middlewares configured should return the bare registry, not a wrapper around it")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+// NameLabelMode controls which label key(s) carry a CR's name on the
+// "<kind>_info" gauge and every other per-object family generateMetricFamilies
+// builds. See DiscoveryOptions.NameLabelMode.
+type NameLabelMode string
+
+const (
+	// NameLabelModeKind labels a CR's name with its lowercased kind, e.g.
+	// virtualservice="basic" for a VirtualService. This is the zero value
+	// and the only behavior before NameLabelMode existed, kept as the default so existing
+	// dashboards built against that label don't break without opting in.
+	NameLabelModeKind NameLabelMode = ""
+
+	// NameLabelModeName labels a CR's name with the standard "name" label
+	// kube-state-metrics conventions use, e.g. name="basic", instead of the
+	// lowercased kind, so joins against other kube-state-metrics series
+	// don't need a label_replace just for this operator's metrics.
+	NameLabelModeName NameLabelMode = "name"
+
+	// NameLabelModeBoth emits both the lowercased-kind and "name" labels
+	// side by side, so dashboards can be migrated from one to the other
+	// without a hard cutover that breaks both at once during the
+	// transition.
+	NameLabelModeBoth NameLabelMode = "both"
+)
+
+// nameLabelPairs returns the LabelKeys/LabelValues pair(s) carrying crd's
+// namespace and (sanitized) name, per mode. namespaced is isNamespaced's
+// namespaced/cluster-scoped determination for the GVK; when false,
+// the "namespace" label is dropped entirely rather than emitted as
+// namespace="", since a cluster-scoped object has no namespace to report.
+func nameLabelPairs(crd *unstructured.Unstructured, kindName string, mode NameLabelMode, namespaced bool) (keys, values []string) {
+	namespace, name := sanitizeLabelValue(crd.GetNamespace()), sanitizeLabelValue(crd.GetName())
+	switch mode {
+	case NameLabelModeName:
+		keys, values = []string{"namespace", "name"}, []string{namespace, name}
+	case NameLabelModeBoth:
+		keys, values = []string{"namespace", kindName, "name"}, []string{namespace, name, name}
+	default:
+		keys, values = []string{"namespace", kindName}, []string{namespace, name}
+	}
+	if !namespaced {
+		keys, values = keys[1:], values[1:]
+	}
+	return keys, values
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+)
+
+func virtualServiceObj() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+}
+
+func TestGenerateMetricFamiliesNameLabelModeKindKeepsKindLabel(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+	store.Add(virtualServiceObj())
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `virtualservice_info{namespace="istio-system",virtualservice="basic"`) {
+		t.Fatalf("exposition output = %q, want the default kindName label unchanged", out)
+	}
+	if strings.Contains(out, `name="basic"`) {
+		t.Fatalf("exposition output = %q, want no \"name\" label under NameLabelModeKind", out)
+	}
+}
+
+func TestGenerateMetricFamiliesNameLabelModeNameSwitchesLabel(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeName, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+	store.Add(virtualServiceObj())
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `virtualservice_info{namespace="istio-system",name="basic"`) {
+		t.Fatalf("exposition output = %q, want the \"name\" label in place of the kindName label", out)
+	}
+	if strings.Contains(out, `virtualservice="basic"`) {
+		t.Fatalf("exposition output = %q, want the kindName label dropped under NameLabelModeName", out)
+	}
+}
+
+func TestGenerateMetricFamiliesNameLabelModeBothEmitsBothLabels(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeBoth, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+	store.Add(virtualServiceObj())
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `virtualservice_info{namespace="istio-system",virtualservice="basic",name="basic"`) {
+		t.Fatalf("exposition output = %q, want both the kindName and \"name\" labels side by side", out)
+	}
+}
+
+func TestGenerateMetricFamiliesNameLabelModeAppliesToCreatedAndGenerationGauges(t *testing.T) {
+	familyGenerators := generateMetricFamilies("VirtualService", nil, "", "", true, false, false, false, false, false, "", "", NameLabelModeName, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	obj := virtualServiceObj()
+	obj.Object["metadata"].(map[string]interface{})["creationTimestamp"] = "2024-01-01T00:00:00Z"
+	obj.SetGeneration(3)
+	store.Add(obj)
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `virtualservice_created{namespace="istio-system",name="basic"}`) {
+		t.Fatalf("exposition output = %q, want the created gauge keyed by \"name\" too", out)
+	}
+	if !strings.Contains(out, `virtualservice_metadata_generation{namespace="istio-system",name="basic"}`) {
+		t.Fatalf("exposition output = %q, want the generation gauge keyed by \"name\" too", out)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// SizeTracker caches the serialized JSON size of an object's unstructured
+// content, keyed by UID, so objectSizeFamilyGenerator doesn't re-marshal an
+// unchanged object on every scrape: Observe only recomputes the size when
+// the object's metadata.resourceVersion differs from what it saw the
+// previous time it was called for that UID.
+type SizeTracker struct {
+	mu               sync.Mutex
+	resourceVersions map[types.UID]string
+	sizes            map[types.UID]float64
+}
+
+// NewSizeTracker returns an empty SizeTracker.
+func NewSizeTracker() *SizeTracker {
+	return &SizeTracker{
+		resourceVersions: map[types.UID]string{},
+		sizes:            map[types.UID]float64{},
+	}
+}
+
+// Forget discards crd's tracked state, so trackerEvictingStore can reclaim
+// a deleted object's entry instead of leaving it behind forever.
+func (t *SizeTracker) Forget(uid types.UID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.resourceVersions, uid)
+	delete(t.sizes, uid)
+}
+
+// Observe returns crd's cached serialized JSON size in bytes, recomputing
+// it only if crd.GetResourceVersion() differs from the value seen on the
+// previous Observe call for this UID (or this is the first time the UID is
+// seen). An object that fails to marshal reports a size of 0 rather than
+// erroring, the same way resolvePath failures are logged and shrugged off
+// elsewhere in this package.
+func (t *SizeTracker) Observe(crd *unstructured.Unstructured) float64 {
+	uid := crd.GetUID()
+	resourceVersion := crd.GetResourceVersion()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, seen := t.resourceVersions[uid]; seen && last == resourceVersion {
+		return t.sizes[uid]
+	}
+
+	var size float64
+	if data, err := json.Marshal(crd.Object); err == nil {
+		size = float64(len(data))
+	} else {
+		log.Error(err, "Failed to marshal object to compute its size", "kind", crd.GetKind(), "name", crd.GetName())
+	}
+	t.resourceVersions[uid] = resourceVersion
+	t.sizes[uid] = size
+	return size
+}
+
+// objectSizeFamilyGenerator builds the "<kind>_size_bytes" gauge
+// buildStoresForGVK adds when GVKOptions.SizeMetric is set, using tracker to
+// avoid re-marshalling an object that hasn't changed since the last scrape.
+// tracker must be the same SizeTracker passed to trackerEvictingStore for
+// this GVK's stores, so a deleted object's cached size is reclaimed instead
+// of outliving it.
+func objectSizeFamilyGenerator(kind string, tracker *SizeTracker) ksmetric.FamilyGenerator {
+	kindName := sanitizeKindForMetricName(strings.ToLower(kind))
+	return ksmetric.FamilyGenerator{
+		Name: kindName + "_size_bytes",
+		Type: ksmetric.Gauge,
+		Help: fmt.Sprintf("Serialized JSON size, in bytes, of a %s's unstructured content.", kind),
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			return &ksmetric.Family{
+				Metrics: []*ksmetric.Metric{
+					{
+						Value:       tracker.Observe(crd),
+						LabelKeys:   []string{"namespace", "name"},
+						LabelValues: crdNameLabelValues(crd),
+					},
+				},
+			}
+		},
+	}
+}
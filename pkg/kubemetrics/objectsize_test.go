@@ -0,0 +1,73 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func objectOfSize(uid, name, resourceVersion string, payloadBytes int) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"uid": uid, "name": name, "namespace": "istio-system", "resourceVersion": resourceVersion},
+		"spec":     map[string]interface{}{"payload": strings.Repeat("x", payloadBytes)},
+	}}
+}
+
+func TestSizeTrackerRecomputesOnlyWhenResourceVersionChanges(t *testing.T) {
+	tracker := NewSizeTracker()
+
+	small := tracker.Observe(objectOfSize("uid-1", "example", "1", 10))
+	sameVersion := tracker.Observe(objectOfSize("uid-1", "example", "1", 10000))
+	if sameVersion != small {
+		t.Fatalf("Observe() with an unchanged resourceVersion = %v, want the cached value %v even though the object grew", sameVersion, small)
+	}
+
+	grown := tracker.Observe(objectOfSize("uid-1", "example", "2", 10000))
+	if grown <= small {
+		t.Fatalf("Observe() after a resourceVersion change = %v, want it larger than the cached value %v", grown, small)
+	}
+}
+
+func TestSizeTrackerForgetDropsCachedSize(t *testing.T) {
+	tracker := NewSizeTracker()
+	first := tracker.Observe(objectOfSize("uid-1", "example", "1", 10))
+	tracker.Forget("uid-1")
+
+	second := tracker.Observe(objectOfSize("uid-1", "example", "1", 10000))
+	if second == first {
+		t.Fatalf("Observe() after Forget() treated resourceVersion %q as already seen, want it recomputed", "1")
+	}
+}
+
+func TestObjectSizeFamilyGeneratorReportsSerializedSize(t *testing.T) {
+	tracker := NewSizeTracker()
+	gen := objectSizeFamilyGenerator("IstioOperator", tracker)
+
+	if gen.Name != "istiooperator_size_bytes" {
+		t.Fatalf("gen.Name = %q, want istiooperator_size_bytes", gen.Name)
+	}
+
+	small := gen.GenerateFunc(objectOfSize("uid-1", "default", "1", 10))
+	large := gen.GenerateFunc(objectOfSize("uid-2", "other", "1", 5000))
+	if len(small.Metrics) != 1 || len(large.Metrics) != 1 {
+		t.Fatalf("GenerateFunc() = %+v / %+v, want a single sample each", small.Metrics, large.Metrics)
+	}
+	if large.Metrics[0].Value <= small.Metrics[0].Value {
+		t.Fatalf("large.Value = %v, want it greater than small.Value = %v", large.Metrics[0].Value, small.Metrics[0].Value)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// ownedFamilyGenerator builds the "<kind>_owned" gauge buildStoresForGVK
+// adds when GVKOptions.OwnedMetric is set: a single sample labeled
+// owned="true" or owned="false" depending on whether the object has a
+// controller owner reference (metadata.ownerReferences[*] with
+// controller: true). A fleet operator whose CRs sometimes lose their owning
+// control plane can alert on `sum(<kind>_owned{owned="false"}) > 0` to find
+// them, the same way deletionTimestampFamilyGenerator's gauge surfaces
+// stuck deletions. The determination is purely from metadata.ownerReferences
+// in the unstructured object — it doesn't check whether the owner itself
+// still exists, only whether the reference is present.
+func ownedFamilyGenerator(kind string) ksmetric.FamilyGenerator {
+	kindName := sanitizeKindForMetricName(strings.ToLower(kind))
+	return ksmetric.FamilyGenerator{
+		Name: kindName + "_owned",
+		Type: ksmetric.Gauge,
+		Help: fmt.Sprintf("Whether a %s has a controller owner reference (metadata.ownerReferences[*] with controller: true).", kind),
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			nameValues := crdNameLabelValues(crd)
+			return &ksmetric.Family{
+				Metrics: []*ksmetric.Metric{
+					{
+						Value:       1,
+						LabelKeys:   []string{"namespace", "name", "owned"},
+						LabelValues: []string{nameValues[0], nameValues[1], fmt.Sprintf("%t", hasControllerOwner(crd))},
+					},
+				},
+			}
+		},
+	}
+}
+
+// hasControllerOwner reports whether crd has an owner reference with
+// Controller set to true.
+func hasControllerOwner(crd *unstructured.Unstructured) bool {
+	for _, ref := range crd.GetOwnerReferences() {
+		if ref.Controller != nil && *ref.Controller {
+			return true
+		}
+	}
+	return false
+}
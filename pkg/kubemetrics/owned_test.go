@@ -0,0 +1,68 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestOwnedFamilyGeneratorTrueWithAControllerOwner(t *testing.T) {
+	gen := ownedFamilyGenerator("VirtualService")
+	if gen.Name != "virtualservice_owned" {
+		t.Fatalf("gen.Name = %q, want virtualservice_owned", gen.Name)
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	isController := true
+	obj.SetOwnerReferences([]metav1.OwnerReference{{Kind: "IstioOperator", Name: "default", Controller: &isController}})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 1 || family.Metrics[0].LabelValues[2] != "true" {
+		t.Fatalf("GenerateFunc() = %+v, want a single sample labeled owned=\"true\"", family.Metrics)
+	}
+}
+
+func TestOwnedFamilyGeneratorFalseWithANonControllerOwner(t *testing.T) {
+	gen := ownedFamilyGenerator("VirtualService")
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	notController := false
+	obj.SetOwnerReferences([]metav1.OwnerReference{{Kind: "IstioOperator", Name: "default", Controller: &notController}})
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 1 || family.Metrics[0].LabelValues[2] != "false" {
+		t.Fatalf("GenerateFunc() = %+v, want owned=\"false\" for an owner reference that isn't marked as the controller", family.Metrics)
+	}
+}
+
+func TestOwnedFamilyGeneratorFalseWithNoOwner(t *testing.T) {
+	gen := ownedFamilyGenerator("VirtualService")
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+
+	family := gen.GenerateFunc(obj)
+	if len(family.Metrics) != 1 || family.Metrics[0].LabelValues[2] != "false" {
+		t.Fatalf("GenerateFunc() = %+v, want owned=\"false\" for an object with no owner references at all", family.Metrics)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resolvePath walks obj (as decoded from an unstructured.Unstructured) along
+// path and returns the value found there, or ok=false if any segment of the
+// path doesn't resolve. path is a dotted sequence of field names, optionally
+// followed by "[index]" to select an element of an array by position, or
+// "[key=value]" to select the first element of an array of objects whose
+// "key" field equals "value" (e.g. "status.conditions[type=Ready].status").
+func resolvePath(obj map[string]interface{}, path string) (interface{}, bool, error) {
+	segments, err := splitPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var current interface{} = obj
+	for _, seg := range segments {
+		next, ok, err := seg.resolve(current)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			return nil, false, nil
+		}
+		current = next
+	}
+	return current, true, nil
+}
+
+// pathSegment is either a plain field name, an array index, or a
+// "key=value" array selector.
+type pathSegment struct {
+	field    string
+	index    *int
+	selector *keyValueSelector
+}
+
+type keyValueSelector struct {
+	key   string
+	value string
+}
+
+func splitPath(path string) ([]pathSegment, error) {
+	parts, err := splitOnTopLevelDots(path)
+	if err != nil {
+		return nil, err
+	}
+	var segments []pathSegment
+	for _, part := range parts {
+		field, brackets, err := splitBrackets(part)
+		if err != nil {
+			return nil, err
+		}
+		if field != "" {
+			segments = append(segments, pathSegment{field: field})
+		}
+		segments = append(segments, brackets...)
+	}
+	return segments, nil
+}
+
+// splitOnTopLevelDots splits path on "." the way strings.Split(path, ".")
+// would, except that dots inside a "[...]" bracket expression are not treated
+// as separators; otherwise a dotted selector value (e.g.
+// "metadata.annotations[team=foo.bar]", or a dotted value inside a
+// "[key=value]" selector) would be torn apart mid-selector before
+// splitBrackets ever sees it.
+func splitOnTopLevelDots(path string) ([]string, error) {
+	var parts []string
+	depth := 0
+	start := 0
+	for i, r := range path {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				return nil, fmt.Errorf("invalid path %q: unmatched ']'", path)
+			}
+			depth--
+		case '.':
+			if depth == 0 {
+				parts = append(parts, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid path %q: missing ']'", path)
+	}
+	parts = append(parts, path[start:])
+	return parts, nil
+}
+
+// splitBrackets splits "conditions[type=Ready]" into the field name
+// "conditions" and one pathSegment per bracketed selector.
+func splitBrackets(part string) (string, []pathSegment, error) {
+	idx := strings.IndexByte(part, '[')
+	if idx == -1 {
+		return part, nil, nil
+	}
+	field := part[:idx]
+	rest := part[idx:]
+
+	var segments []pathSegment
+	for len(rest) > 0 {
+		if rest[0] != '[' {
+			return "", nil, fmt.Errorf("invalid path segment %q: expected '['", part)
+		}
+		end := strings.IndexByte(rest, ']')
+		if end == -1 {
+			return "", nil, fmt.Errorf("invalid path segment %q: missing ']'", part)
+		}
+		inner := rest[1:end]
+		seg, err := parseBracket(inner)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid path segment %q: %w", part, err)
+		}
+		segments = append(segments, seg)
+		rest = rest[end+1:]
+	}
+	return field, segments, nil
+}
+
+func parseBracket(inner string) (pathSegment, error) {
+	if kv := strings.SplitN(inner, "=", 2); len(kv) == 2 {
+		return pathSegment{selector: &keyValueSelector{key: kv[0], value: kv[1]}}, nil
+	}
+	i, err := strconv.Atoi(inner)
+	if err != nil {
+		return pathSegment{}, fmt.Errorf("expected an index or key=value selector, got %q", inner)
+	}
+	return pathSegment{index: &i}, nil
+}
+
+func (s pathSegment) resolve(current interface{}) (interface{}, bool, error) {
+	switch {
+	case s.field != "":
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false, nil
+		}
+		v, ok := m[s.field]
+		return v, ok, nil
+	case s.index != nil:
+		arr, ok := current.([]interface{})
+		if !ok || *s.index < 0 || *s.index >= len(arr) {
+			return nil, false, nil
+		}
+		return arr[*s.index], true, nil
+	case s.selector != nil:
+		arr, ok := current.([]interface{})
+		if !ok {
+			return nil, false, nil
+		}
+		for _, elem := range arr {
+			m, ok := elem.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if fmt.Sprintf("%v", m[s.selector.key]) == s.selector.value {
+				return elem, true, nil
+			}
+		}
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("empty path segment")
+	}
+}
+
+// toFloat64 implicitly converts an int, float, bool, string, array or nil
+// value pulled off a CR into the float64 a gauge metric needs. Strings are
+// parsed as RFC3339 timestamps and converted to unix seconds, or failing
+// that as decimal numbers (e.g. "7"); any other string returns an error.
+// An array converts to its length, so a path pointed at e.g.
+// "status.conditions" can be exposed as a count without a dedicated
+// MetricType. nilIsZero controls whether a nil value converts to 0 or
+// returns an error.
+func toFloat64(v interface{}, nilIsZero bool) (float64, error) {
+	switch val := v.(type) {
+	case nil:
+		if nilIsZero {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("value is nil")
+	case []interface{}:
+		return float64(len(val)), nil
+	case bool:
+		if val {
+			return 1, nil
+		}
+		return 0, nil
+	case int:
+		return float64(val), nil
+	case int32:
+		return float64(val), nil
+	case int64:
+		return float64(val), nil
+	case float32:
+		return float64(val), nil
+	case float64:
+		return val, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			return float64(t.Unix()), nil
+		}
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert string %q to a number or RFC3339 timestamp", val)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("cannot convert value of type %T to float64", v)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolvePath(t *testing.T) {
+	obj := map[string]interface{}{
+		"status": map[string]interface{}{
+			"replicas": int64(3),
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Progressing", "status": "True"},
+				map[string]interface{}{"type": "Ready", "status": "False"},
+			},
+			"tags": []interface{}{"a", "b"},
+		},
+		"labels": []interface{}{
+			map[string]interface{}{"key": "foo.bar", "value": "matched"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    interface{}
+		wantOk  bool
+		wantErr bool
+	}{
+		{
+			name:   "plain nested field",
+			path:   "status.replicas",
+			want:   int64(3),
+			wantOk: true,
+		},
+		{
+			name:   "array index",
+			path:   "status.tags[1]",
+			want:   "b",
+			wantOk: true,
+		},
+		{
+			name:   "key=value selector",
+			path:   "status.conditions[type=Ready].status",
+			want:   "False",
+			wantOk: true,
+		},
+		{
+			name:   "dotted selector value isn't split on its dots",
+			path:   "labels[key=foo.bar].value",
+			want:   "matched",
+			wantOk: true,
+		},
+		{
+			name:   "missing field",
+			path:   "status.doesNotExist",
+			wantOk: false,
+		},
+		{
+			name:   "selector with no match",
+			path:   "status.conditions[type=Unknown].status",
+			wantOk: false,
+		},
+		{
+			name:   "index out of range",
+			path:   "status.tags[5]",
+			wantOk: false,
+		},
+		{
+			name:    "malformed bracket: missing closing bracket",
+			path:    "status.tags[1",
+			wantErr: true,
+		},
+		{
+			name:    "malformed bracket: unmatched closing bracket",
+			path:    "status.tags]1[",
+			wantErr: true,
+		},
+		{
+			name:    "malformed bracket: not an index or key=value",
+			path:    "status.tags[bogus]",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok, err := resolvePath(obj, tc.path)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("resolvePath(%q) = no error, want one", tc.path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolvePath(%q) returned unexpected error: %v", tc.path, err)
+			}
+			if ok != tc.wantOk {
+				t.Fatalf("resolvePath(%q) ok = %v, want %v", tc.path, ok, tc.wantOk)
+			}
+			if tc.wantOk && !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("resolvePath(%q) = %v, want %v", tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestToFloat64(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		nilIsZero bool
+		want      float64
+		wantErr   bool
+	}{
+		{name: "nil without nilIsZero errors", value: nil, wantErr: true},
+		{name: "nil with nilIsZero", value: nil, nilIsZero: true, want: 0},
+		{name: "true", value: true, want: 1},
+		{name: "false", value: false, want: 0},
+		{name: "int", value: 42, want: 42},
+		{name: "int64", value: int64(42), want: 42},
+		{name: "float64", value: 3.5, want: 3.5},
+		{name: "numeric string", value: "7", want: 7},
+		{name: "RFC3339 string", value: "2021-01-01T00:00:00Z", want: 1609459200},
+		{name: "invalid string errors", value: "not-a-number", wantErr: true},
+		{name: "unsupported type errors", value: []int{1}, wantErr: true},
+		{name: "array converts to its length", value: []interface{}{"a", "b", "c"}, want: 3},
+		{name: "empty array converts to zero", value: []interface{}{}, want: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := toFloat64(tc.value, tc.nilIsZero)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("toFloat64(%v) = no error, want one", tc.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("toFloat64(%v) returned unexpected error: %v", tc.value, err)
+			}
+			if got != tc.want {
+				t.Fatalf("toFloat64(%v) = %v, want %v", tc.value, got, tc.want)
+			}
+		})
+	}
+}
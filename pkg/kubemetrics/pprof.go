@@ -0,0 +1,41 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofPathPrefix is where Serve mounts pprofHandler's routes when
+// DiscoveryOptions.EnablePprof is set.
+const pprofPathPrefix = "/debug/pprof/"
+
+// pprofHandler serves net/http/pprof's standard /debug/pprof/* routes
+// without relying on net/http/pprof's init()-time registration onto
+// http.DefaultServeMux, so enabling EnablePprof can't accidentally expose
+// pprof on some other server in the process that happens to use the default
+// mux. pprof.Index itself dispatches named profiles (heap, goroutine,
+// threadcreate, block, mutex, allocs, ...) looked up by
+// runtime/pprof.Lookup, so mounting it at pprofPathPrefix covers those too.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pprofPathPrefix, pprof.Index)
+	mux.HandleFunc(pprofPathPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(pprofPathPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(pprofPathPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(pprofPathPrefix+"trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+)
+
+// RefreshTarget names one GVR's MetricsStores for StartMetricStoreRefresher
+// to keep in sync by periodic relisting. Namespaces and Stores are parallel
+// slices refreshed index-for-index; leave Namespaces empty (or use a single
+// "" entry) for a cluster-scoped resource with one Store, the shape
+// newClusterScopedMetricsStores and newNamespacedMetricsStores already
+// return.
+type RefreshTarget struct {
+	GVR        schema.GroupVersionResource
+	Namespaces []string
+	Stores     []*metricsstore.MetricsStore
+}
+
+// MetricStoreRefresher is the handle StartMetricStoreRefresher returns.
+type MetricStoreRefresher struct {
+	targets []RefreshTarget
+
+	// lastRefreshed holds one unix timestamp per entry in targets, accessed
+	// atomically since refresh cycles run from a background goroutine while
+	// WriteLastRefreshMetrics may be called concurrently from a scrape.
+	lastRefreshed []int64
+}
+
+// StartMetricStoreRefresher periodically relists every target's GVR via
+// dclient and Replaces the result into its MetricsStores, so resources
+// created or deleted between cycles eventually show up without a running
+// cache.Reflector. This package's own stores (built by
+// newNamespacedMetricsStores and newClusterScopedMetricsStores, which back
+// GenerateAndServeCRMetrics) already stay live via a reflector — see
+// newReflectedMetricsStore — and don't need this. StartMetricStoreRefresher
+// is for MetricsStores a caller built independently and wants to keep fresh
+// with a simple poll-and-Replace loop instead of wiring their own reflector.
+//
+// Every target is refreshed once synchronously before this returns, then
+// refreshed again every interval in the background until ctx is cancelled.
+func StartMetricStoreRefresher(ctx context.Context, interval time.Duration, dclient dynamic.Interface, targets []RefreshTarget) *MetricStoreRefresher {
+	r := &MetricStoreRefresher{
+		targets:       targets,
+		lastRefreshed: make([]int64, len(targets)),
+	}
+	for i := range r.targets {
+		r.refresh(dclient, i)
+	}
+	go r.run(ctx, dclient, interval)
+	return r
+}
+
+func (r *MetricStoreRefresher) run(ctx context.Context, dclient dynamic.Interface, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for i := range r.targets {
+				r.refresh(dclient, i)
+			}
+		}
+	}
+}
+
+// refresh relists targets[i]'s GVR once per namespace (cluster-wide if
+// Namespaces is empty) and Replaces the result into the corresponding
+// MetricsStore. A list error is logged and that namespace's store is left
+// with its previous contents rather than cleared, so a transient apiserver
+// hiccup doesn't blank out a scrape; the next cycle tries again.
+func (r *MetricStoreRefresher) refresh(dclient dynamic.Interface, i int) {
+	target := r.targets[i]
+	start := time.Now()
+	resourceClient := dclient.Resource(target.GVR)
+
+	namespaces := target.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	for j, namespace := range namespaces {
+		if j >= len(target.Stores) {
+			break
+		}
+		var list *unstructured.UnstructuredList
+		var err error
+		if namespace == "" {
+			list, err = resourceClient.List(context.TODO(), metav1.ListOptions{})
+		} else {
+			list, err = resourceClient.Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+		}
+		if err != nil {
+			log.Error(err, "Failed to refresh metrics store", "gvr", target.GVR.String(), "namespace", namespace)
+			continue
+		}
+
+		items := make([]interface{}, len(list.Items))
+		for k := range list.Items {
+			items[k] = &list.Items[k]
+		}
+		if err := target.Stores[j].Replace(items, ""); err != nil {
+			log.Error(err, "Failed to replace metrics store contents", "gvr", target.GVR.String(), "namespace", namespace)
+		}
+	}
+
+	atomic.StoreInt64(&r.lastRefreshed[i], time.Now().Unix())
+	log.V(1).Info("Refreshed metrics store", "gvr", target.GVR.String(), "duration", time.Since(start).String())
+}
+
+// WriteLastRefreshMetrics writes a "<resource>_last_refresh_timestamp_seconds"
+// gauge per target, reporting the unix time its most recent refresh cycle
+// completed, in the same exposition format MetricsStore.WriteAll uses.
+// Targets that haven't completed a cycle yet are skipped. Callers serving
+// their own MetricsStores should call this alongside WriteAll, the same way
+// storeRegistry.ServeHTTP appends writeTruncatedMetric after WriteAll for
+// MaxResourcesPerGVK.
+func (r *MetricStoreRefresher) WriteLastRefreshMetrics(w io.Writer) {
+	for i, target := range r.targets {
+		ts := atomic.LoadInt64(&r.lastRefreshed[i])
+		if ts == 0 {
+			continue
+		}
+		name := strings.ToLower(target.GVR.Resource) + "_last_refresh_timestamp_seconds"
+		fmt.Fprintf(w, "# HELP %s Unix timestamp of the last completed refresh cycle of this resource's metrics store.\n", name)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		fmt.Fprintf(w, "%s %d\n", name, ts)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+)
+
+func TestStartMetricStoreRefresher(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "MeshConfig"}
+	gvr := schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: "meshconfigs"}
+
+	scheme := runtime.NewScheme()
+	listKinds := map[schema.GroupVersionResource]string{gvr: "MeshConfigList"}
+	dclient := fake.NewSimpleDynamicClientWithCustomListKinds(scheme, listKinds)
+
+	familyGenerators := generateMetricFamilies(gvk.Kind, nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	refresher := StartMetricStoreRefresher(ctx, 10*time.Millisecond, dclient, []RefreshTarget{
+		{GVR: gvr, Stores: []*metricsstore.MetricsStore{store}},
+	})
+
+	var buf strings.Builder
+	store.WriteAll(&buf)
+	if strings.Contains(buf.String(), "meshconfig_info{") {
+		t.Fatalf("exposition output = %q, want no meshconfig_info series before any object exists", buf.String())
+	}
+
+	if _, err := dclient.Resource(gvr).Create(ctx, newUnstructuredCR(gvk, "default"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+
+	var out string
+	for i := 0; i < 50; i++ {
+		buf.Reset()
+		store.WriteAll(&buf)
+		out = buf.String()
+		if strings.Contains(out, `meshconfig_info{namespace="",meshconfig="default"}`) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(out, "meshconfig_info{") {
+		t.Fatalf("exposition output = %q, want it to pick up the created object on the next refresh cycle", out)
+	}
+
+	buf.Reset()
+	refresher.WriteLastRefreshMetrics(&buf)
+	metricsOut := buf.String()
+	if !strings.Contains(metricsOut, "meshconfigs_last_refresh_timestamp_seconds") {
+		t.Fatalf("WriteLastRefreshMetrics() = %q, want it to mention meshconfigs_last_refresh_timestamp_seconds", metricsOut)
+	}
+}
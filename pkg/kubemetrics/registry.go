@@ -0,0 +1,594 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+)
+
+// defaultMetricsPath is the path CR metrics are served on when
+// DiscoveryOptions.MetricsPath isn't set.
+const defaultMetricsPath = "/metrics"
+
+// gzipSizeThreshold is the minimum size, in bytes, a rendered response must
+// reach before ServeHTTP bothers gzip-compressing it for clients that accept
+// it; small scrapes aren't worth the CPU cost, and gzip's own framing
+// overhead can make tiny payloads larger rather than smaller.
+const gzipSizeThreshold = 1500
+
+// openMetricsContentType is the Content-Type ServeHTTP responds with when a
+// request negotiates OpenMetrics, per
+// https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md#overall-structure.
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// classicContentType is the Content-Type ServeHTTP responds with for the
+// legacy Prometheus text exposition format, per
+// https://prometheus.io/docs/instrumenting/exposition_formats/.
+const classicContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// defaultMaxConcurrentRenders and defaultRenderTimeout apply when
+// ScrapeLimitOptions.MaxConcurrentRenders/RenderTimeout are zero.
+const (
+	defaultMaxConcurrentRenders = 2
+	defaultRenderTimeout        = 30 * time.Second
+)
+
+// scrapeQueueWait is how long ServeHTTP lets a request wait for a free
+// render slot before answering 503, so a brief burst (e.g. two scrapers
+// landing a few milliseconds apart) queues rather than failing outright,
+// while a sustained pile-up still gets rejected quickly instead of queuing
+// indefinitely behind MaxConcurrentRenders. A var, not a const, so tests can
+// shrink it rather than spending real wall-clock time on the queue wait.
+var scrapeQueueWait = 2 * time.Second
+
+// errRenderTimedOut is returned by storeRegistry.render when a render is
+// still running once its RenderTimeout elapses.
+var errRenderTimedOut = errors.New("metrics render exceeded its deadline")
+
+// bufPool recycles the *bytes.Buffer ServeHTTP renders each scrape into,
+// rather than growing a fresh one from nothing on every request. A large
+// mesh's rendered buffer can reach several megabytes; sortRenderedFamilies
+// and the gzip-size threshold both need the whole response in memory at
+// once to work at all, so this can't avoid buffering the way a truly
+// streaming write path would, but reusing buffers across requests avoids
+// the repeated transient multi-megabyte allocations a fresh bytes.Buffer
+// per scrape would otherwise cause.
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// cachedVariant is one previously rendered response body, together with the
+// headers ServeHTTP set alongside it, keyed in storeRegistry.cache by the
+// cacheKey that produced it so a later scrape negotiating the same format
+// and compression gets byte-identical output.
+type cachedVariant struct {
+	body            []byte
+	contentType     string
+	contentEncoding string
+	expiresAt       time.Time
+}
+
+// cacheKey distinguishes the response variants ResponseCacheOptions.TTL
+// caches independently: OpenMetrics negotiation and gzip compression each
+// change the bytes and headers a scrape gets, so every combination is
+// rendered and expired on its own.
+type cacheKey struct {
+	openMetrics bool
+	gzip        bool
+}
+
+// managedStore pairs a MetricsStore with the teardown, if any, for whatever
+// keeps it in sync with the cluster. stop is non-nil for stores backed by a
+// cache.Reflector (see newReflectedMetricsStore) and closes its list/watch
+// goroutine; it's nil for stores the caller repopulates itself wholesale,
+// such as resource_metrics.go's poll-and-Replace stores, which own no
+// background goroutine to stop.
+type managedStore struct {
+	*metricsstore.MetricsStore
+	stop func()
+
+	// truncated and kind support the MaxResourcesPerGVK cap (see
+	// truncateList): when truncated is non-nil, ServeHTTP reads it after
+	// WriteAll and, if positive, reports it as a "<kind>_truncated" gauge.
+	// Both are nil for stores that aren't subject to the cap.
+	truncated *int64
+	kind      string
+
+	// synced is set to 1 once the store has completed its initial list, for
+	// allSynced to back CRMetricsManager.StoresSynced. nil for stores that
+	// don't track this (e.g. the resource-usage stores
+	// GenerateAndServeResourceMetrics populates via a one-shot Replace),
+	// which allSynced treats as already synced.
+	synced *int32
+
+	// namespaced and namespace support the "<kind>_count" gauge (see
+	// countMetric and writeCountMetric): namespaced records whether this
+	// store's GVK is namespace-scoped at all, and namespace is the specific
+	// namespace it was built for ("" for both a cluster-scoped resource and
+	// a namespaced one backed by the all-namespaces sentinel; see
+	// newReflectedMetricsStore).
+	namespaced bool
+	namespace  string
+
+	// countMetric enables writing the aggregated "<kind>_count" gauge after
+	// this store's own families on every render; see GVKOptions.CountMetric.
+	countMetric bool
+}
+
+// storeRegistry is a thread-safe collection of the MetricsStores currently
+// being served, keyed by the GVK they were built for. Static GVKs are set
+// once at startup; wildcard GVKs are added and removed as matching CRDs
+// come and go on the cluster.
+type storeRegistry struct {
+	mu     sync.RWMutex
+	stores map[schema.GroupVersionKind][]*managedStore
+
+	// extraGatherer, if set, makes ServeHTTP merge this Gatherer's families
+	// onto the same scrape, so a caller's own prometheus.Registry (e.g.
+	// controller-runtime's, exposing workqueue_ and similar process metrics)
+	// can share one port and one Service/ServiceMonitor with CR metrics
+	// instead of needing a second one. See DiscoveryOptions.Gatherer.
+	extraGatherer prometheus.Gatherer
+
+	// renderSem bounds how many ServeHTTP renders run concurrently; a render
+	// acquires a slot before walking any store and releases it before
+	// returning. nil until configureScrapeLimits is called, which ServeHTTP
+	// treats as "unlimited" so registries nobody configures (e.g. most
+	// existing tests) keep their old unthrottled behavior.
+	renderSem chan struct{}
+
+	// renderTimeout bounds how long a render may run once it has a
+	// renderSem slot. Zero means unbounded. Set by configureScrapeLimits.
+	renderTimeout time.Duration
+
+	// throttledScrapeCount and timedOutScrapeCount back ThrottledScrapes and
+	// TimedOutScrapes. Accessed atomically since ServeHTTP runs concurrently
+	// across request-handling goroutines.
+	throttledScrapeCount int64
+	timedOutScrapeCount  int64
+
+	// accessLog makes ServeHTTP emit a V(1) log entry for every scrape it
+	// serves. See DiscoveryOptions.AccessLog.
+	accessLog bool
+
+	// self tracks render duration and response size across scrapes, and
+	// appends them to every response as cr_metrics_scrape_duration_seconds
+	// and friends.
+	self *selfMetrics
+
+	// cacheTTL, if nonzero, makes ServeHTTP reuse a previously rendered
+	// response for this long instead of re-rendering on every scrape. Zero,
+	// the default, disables caching entirely. Set by configureResponseCache.
+	cacheTTL time.Duration
+
+	// cacheMu guards cache. Held across a cache miss's render, not merely
+	// the map lookup, so concurrent scrapes that miss the same cacheKey
+	// queue behind whichever one renders first rather than each rendering
+	// independently.
+	cacheMu sync.Mutex
+	cache   map[cacheKey]*cachedVariant
+}
+
+func newStoreRegistry() *storeRegistry {
+	return &storeRegistry{
+		stores: make(map[schema.GroupVersionKind][]*managedStore),
+		self:   newSelfMetrics(nil),
+	}
+}
+
+// configureScrapeLimits applies opts' concurrency and per-render deadline to
+// r, filling in defaultMaxConcurrentRenders/defaultRenderTimeout for zero
+// fields. Must be called before ServeHTTP serves its first request; it isn't
+// safe to call concurrently with a scrape.
+func (r *storeRegistry) configureScrapeLimits(opts ScrapeLimitOptions) {
+	max := opts.MaxConcurrentRenders
+	if max == 0 {
+		max = defaultMaxConcurrentRenders
+	}
+	r.renderSem = make(chan struct{}, max)
+
+	r.renderTimeout = opts.RenderTimeout
+	if r.renderTimeout == 0 {
+		r.renderTimeout = defaultRenderTimeout
+	}
+}
+
+// configureResponseCache applies opts' TTL to r. Must be called before
+// ServeHTTP serves its first request; it isn't safe to call concurrently
+// with a scrape.
+func (r *storeRegistry) configureResponseCache(opts ResponseCacheOptions) {
+	r.cacheTTL = opts.TTL
+}
+
+// configureConstLabels rebuilds r.self so cr_metrics_scrape_duration_seconds
+// and its siblings carry constLabels, the same labels DiscoveryOptions.ConstLabels
+// stamps onto every CR-generated family. Must be called before ServeHTTP
+// serves its first request, and before configureScrapeLimits/
+// configureResponseCache observe anything through r.self.
+func (r *storeRegistry) configureConstLabels(constLabels map[string]string) {
+	r.self = newSelfMetrics(constLabels)
+}
+
+// ThrottledScrapes counts requests to the CR metrics endpoint answered 503
+// because MaxConcurrentRenders renders were already in flight and none freed
+// up within scrapeQueueWait.
+func (r *storeRegistry) ThrottledScrapes() int64 {
+	return atomic.LoadInt64(&r.throttledScrapeCount)
+}
+
+// TimedOutScrapes counts renders answered 503 because they were still
+// running when RenderTimeout elapsed.
+func (r *storeRegistry) TimedOutScrapes() int64 {
+	return atomic.LoadInt64(&r.timedOutScrapeCount)
+}
+
+// set installs or replaces the stores for gvk, stopping whatever the
+// previous entry was syncing first so a reload or rediscovery can't leak its
+// reflector goroutine and apiserver watch.
+func (r *storeRegistry) set(gvk schema.GroupVersionKind, stores []*managedStore) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stopStores(r.stores[gvk])
+	r.stores[gvk] = stores
+}
+
+// remove tears down the entry for gvk, if any, stopping its stores before
+// discarding them.
+func (r *storeRegistry) remove(gvk schema.GroupVersionKind) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stopStores(r.stores[gvk])
+	delete(r.stores, gvk)
+}
+
+// stopStores stops every store in stores that owns a background goroutine.
+func stopStores(stores []*managedStore) {
+	for _, s := range stores {
+		if s.stop != nil {
+			s.stop()
+		}
+	}
+}
+
+// stopAll tears down every GVK currently in the registry, stopping every
+// store's reflector and clearing the map so a subsequent scrape serves
+// nothing. Used to fully wind down a CRMetricsManager, e.g. when its
+// context is cancelled.
+func (r *storeRegistry) stopAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for gvk, stores := range r.stores {
+		stopStores(stores)
+		delete(r.stores, gvk)
+	}
+}
+
+// snapshot returns the current stores in the shape ServeMetrics expects.
+func (r *storeRegistry) snapshot() [][]*managedStore {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	all := make([][]*managedStore, 0, len(r.stores))
+	for _, gvkStores := range r.stores {
+		all = append(all, gvkStores)
+	}
+	return all
+}
+
+// allSynced reports whether every currently registered store that tracks
+// initial sync (see managedStore.synced) has completed at least one full
+// list. Backs CRMetricsManager.StoresSynced.
+func (r *storeRegistry) allSynced() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, gvkStores := range r.stores {
+		for _, s := range gvkStores {
+			if s.synced != nil && atomic.LoadInt32(s.synced) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// render writes every currently registered store, plus any extraGatherer
+// families, into buf, enforcing renderTimeout if one is set, then rewrites
+// buf in place via sortRenderedFamilies so the result is byte-stable across
+// scrapes regardless of the map iteration order renderAll assembled it in.
+// metricsstore's WriteAll has no context/deadline support of its own, so a
+// timed-out render is abandoned by returning early rather than cancelled
+// outright: its goroutine keeps running against buf in the background, but
+// nothing reads buf again once render has returned errRenderTimedOut, so the
+// abandoned write — and the sort that would otherwise follow it — is
+// harmless other than the goroutine's cost.
+func (r *storeRegistry) render(buf *bytes.Buffer) error {
+	if r.renderTimeout <= 0 {
+		r.renderAll(buf)
+		sortRenderedFamilies(buf)
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.renderAll(buf)
+	}()
+	select {
+	case <-done:
+		sortRenderedFamilies(buf)
+		return nil
+	case <-time.After(r.renderTimeout):
+		return errRenderTimedOut
+	}
+}
+
+// renderAll writes every currently registered store's metrics, plus any
+// extraGatherer families, into buf.
+func (r *storeRegistry) renderAll(buf *bytes.Buffer) {
+	for _, gvkStores := range r.snapshot() {
+		for _, s := range gvkStores {
+			s.WriteAll(buf)
+			if s.truncated != nil {
+				if dropped := atomic.LoadInt64(s.truncated); dropped > 0 {
+					writeTruncatedMetric(buf, s.kind, dropped)
+				}
+			}
+			if s.countMetric {
+				writeCountMetric(buf, s.kind, s.namespaced, s.namespace, s.List())
+			}
+		}
+	}
+
+	if r.extraGatherer != nil {
+		if err := mergeExternalFamilies(buf, r.extraGatherer); err != nil {
+			log.Error(err, "Failed to merge metrics from the external Gatherer")
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler by writing out every currently
+// registered store on each scrape. The response is rendered into a buffer
+// pooled via bufPool, rather than a fresh allocation per request, so its
+// size can be checked against gzipSizeThreshold: responses at or above it
+// are gzip-compressed for clients whose Accept-Encoding lists gzip, leaving
+// smaller responses and clients that don't accept gzip unaffected.
+//
+// The buffer is also where OpenMetrics negotiation happens. Nearly every
+// family this package emits is a Gauge, and OpenMetrics' HELP/TYPE/sample
+// line syntax for a Gauge is byte-identical to the classic text format's, so
+// negotiating OpenMetrics only changes the Content-Type header and appends
+// the "# EOF" terminator the OpenMetrics spec requires — there's no
+// per-family rewriting to do. The exception is a Counter family built with
+// transitionCounterFamilyGenerator: OpenMetrics requires a counter's sample
+// name to carry a "_total" suffix the TYPE line's name omits, which this
+// package doesn't rewrite for; give a Counter family's Name that suffix
+// up front (e.g. "widget_transitions_total") so the classic-format output
+// this package always renders is already OpenMetrics-compliant too.
+//
+// Once rendering finishes, r.self records how long it took and how large
+// buf is, then appends its own families describing that to buf, so every
+// response reports on the scrape that produced it.
+//
+// When cacheTTL is set (see ResponseCacheOptions), a scrape that negotiates
+// the same format and compression as one rendered within the last cacheTTL
+// reuses that earlier response's bytes and headers verbatim instead of
+// rendering again — for a burst of scrapers (e.g. two Prometheus replicas
+// plus a Thanos sidecar) hitting the endpoint within the same second. A
+// cache miss holds cacheMu for the whole render, so concurrent misses for
+// the same variant queue behind the one that renders rather than each
+// rendering independently; self-metrics, in that case, describe only the
+// render that actually happened, not every cached scrape that reused it.
+func (r *storeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	renderStart := time.Now()
+
+	if r.accessLog {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		w = rec
+		defer func() {
+			log.V(1).Info("Served CR metrics scrape",
+				"remoteAddr", req.RemoteAddr, "path", req.URL.Path,
+				"status", rec.status, "bytesWritten", rec.bytesWritten,
+				"duration", time.Since(start))
+		}()
+	}
+
+	key := cacheKey{openMetrics: negotiatesOpenMetrics(req), gzip: acceptsGzip(req)}
+	if r.cacheTTL > 0 {
+		r.cacheMu.Lock()
+		if cached := r.cache[key]; cached != nil && time.Now().Before(cached.expiresAt) {
+			r.cacheMu.Unlock()
+			writeCachedVariant(w, cached)
+			return
+		}
+		defer r.cacheMu.Unlock()
+	}
+
+	if r.renderSem != nil {
+		select {
+		case r.renderSem <- struct{}{}:
+			defer func() { <-r.renderSem }()
+		case <-time.After(scrapeQueueWait):
+			atomic.AddInt64(&r.throttledScrapeCount, 1)
+			http.Error(w, "too many concurrent scrape renders in progress", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+
+	if err := r.render(buf); err != nil {
+		atomic.AddInt64(&r.timedOutScrapeCount, 1)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	r.self.observe(time.Since(renderStart), buf)
+	if err := r.self.mergeInto(buf); err != nil {
+		log.Error(err, "Failed to merge self-metrics into CR metrics response")
+	}
+
+	contentType := classicContentType
+	if key.openMetrics {
+		contentType = openMetricsContentType
+		buf.WriteString("# EOF\n")
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	gzipped := key.gzip && buf.Len() >= gzipSizeThreshold
+	if gzipped {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
+
+	if r.cacheTTL == 0 {
+		// Caching disabled (the default): write straight to w, exactly as
+		// before ResponseCacheOptions existed, rather than buffering a copy
+		// nothing will reuse.
+		if gzipped {
+			gz := gzip.NewWriter(w)
+			if _, err := gz.Write(buf.Bytes()); err != nil {
+				logWriteError(err, "Failed to write gzip-compressed metrics response")
+			}
+			if err := gz.Close(); err != nil {
+				logWriteError(err, "Failed to flush gzip-compressed metrics response")
+			}
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			logWriteError(err, "Failed to write metrics response")
+		}
+		return
+	}
+
+	v := &cachedVariant{contentType: contentType, expiresAt: time.Now().Add(r.cacheTTL)}
+	if gzipped {
+		var gzBuf bytes.Buffer
+		gz := gzip.NewWriter(&gzBuf)
+		if _, err := gz.Write(buf.Bytes()); err != nil {
+			logWriteError(err, "Failed to write gzip-compressed metrics response")
+		}
+		if err := gz.Close(); err != nil {
+			logWriteError(err, "Failed to flush gzip-compressed metrics response")
+		}
+		v.body = gzBuf.Bytes()
+		v.contentEncoding = "gzip"
+	} else {
+		v.body = append([]byte(nil), buf.Bytes()...)
+	}
+
+	if r.cache == nil {
+		r.cache = make(map[cacheKey]*cachedVariant)
+	}
+	r.cache[key] = v
+
+	if _, err := w.Write(v.body); err != nil {
+		logWriteError(err, "Failed to write metrics response")
+	}
+}
+
+// writeCachedVariant serves a cache hit: the headers and body an earlier
+// ServeHTTP call stored for cacheKey, unchanged, so every scrape that hits
+// the same cache entry sees byte-identical output.
+func writeCachedVariant(w http.ResponseWriter, v *cachedVariant) {
+	w.Header().Set("Content-Type", v.contentType)
+	if v.contentEncoding != "" {
+		w.Header().Set("Content-Encoding", v.contentEncoding)
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
+	if _, err := w.Write(v.body); err != nil {
+		logWriteError(err, "Failed to write metrics response")
+	}
+}
+
+// logWriteError reports err at V(1) rather than Error when it looks like a
+// client disconnecting mid-write (e.g. "broken pipe" or "connection reset by
+// peer"): that's an ordinary, frequent occurrence for a scraper that timed
+// out or was restarted, not an operator-actionable failure, so it shouldn't
+// show up at the same verbosity as a genuine write failure.
+func logWriteError(err error, msg string) {
+	if isClientDisconnect(err) {
+		log.V(1).Info(msg, "error", err.Error())
+		return
+	}
+	log.Error(err, msg)
+}
+
+// isClientDisconnect reports whether err indicates the client went away
+// mid-write, rather than some other write failure.
+func isClientDisconnect(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, net.ErrClosed)
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code and
+// byte count ServeHTTP ends up responding with, for DiscoveryOptions.AccessLog.
+// Only allocated when accessLog is set, so the common case pays nothing for
+// it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status       int
+	bytesWritten int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytesWritten += n
+	return n, err
+}
+
+// negotiatesOpenMetrics reports whether req's Accept header lists
+// application/openmetrics-text as an acceptable media type, the way
+// Prometheus does once openmetrics_text_parser is enabled. Any q-value is
+// ignored: a client that mentions the media type at all is treated as
+// preferring it over the classic format ServeHTTP falls back to otherwise.
+func negotiatesOpenMetrics(req *http.Request) bool {
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0])
+		if mediaType == "application/openmetrics-text" {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether req's Accept-Encoding header lists gzip among
+// its acceptable encodings.
+func acceptsGzip(req *http.Request) bool {
+	for _, encoding := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		encoding = strings.TrimSpace(encoding)
+		if encoding == "gzip" || strings.HasPrefix(encoding, "gzip;") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+)
+
+func TestStoreRegistryStopAll(t *testing.T) {
+	r := newStoreRegistry()
+
+	var stopped []schema.GroupVersionKind
+	newStubStore := func(gvk schema.GroupVersionKind) *managedStore {
+		return &managedStore{stop: func() { stopped = append(stopped, gvk) }}
+	}
+
+	podGVK := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"}
+	cronGVK := schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"}
+	r.set(podGVK, []*managedStore{newStubStore(podGVK)})
+	r.set(cronGVK, []*managedStore{newStubStore(cronGVK), newStubStore(cronGVK)})
+
+	r.stopAll()
+
+	if len(stopped) != 3 {
+		t.Fatalf("stopAll() stopped %d stores, want 3", len(stopped))
+	}
+	if got := r.snapshot(); len(got) != 0 {
+		t.Fatalf("snapshot() after stopAll() = %v, want empty", got)
+	}
+
+	// stopAll must be safe to call again once already empty.
+	r.stopAll()
+}
+
+// virtualServiceRegistryForGzipTests builds a storeRegistry populated with n
+// VirtualService objects, large enough that its rendered output clears
+// gzipSizeThreshold for any n worth benchmarking.
+func virtualServiceRegistryForGzipTests(t testing.TB, n int) *storeRegistry {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "VirtualService"}
+	familyGenerators := generateMetricFamilies(gvk.Kind, nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	items := make([]interface{}, n)
+	for i := range items {
+		items[i] = &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"namespace": "istio-system",
+				"name":      fmt.Sprintf("virtualservice-gzip-test-%d", i),
+				"uid":       fmt.Sprintf("virtualservice-gzip-test-%d", i),
+			},
+		}}
+	}
+	if err := store.Replace(items, ""); err != nil {
+		t.Fatalf("store.Replace() = %v", err)
+	}
+
+	r := newStoreRegistry()
+	r.set(gvk, []*managedStore{{MetricsStore: store}})
+	return r
+}
+
+func TestStoreRegistryServeHTTPGzipsLargeResponsesWhenAccepted(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 5000)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want %q", got, "gzip")
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() = %v", err)
+	}
+	decompressed, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading gzip body = %v", err)
+	}
+	if len(decompressed) == 0 {
+		t.Fatal("decompressed body is empty")
+	}
+}
+
+func TestStoreRegistryServeHTTPLeavesUncompressedClientsUnaffected(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 5000)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q, want none for a client that didn't request gzip", got)
+	}
+	if w.Body.Len() == 0 {
+		t.Fatal("body is empty")
+	}
+}
+
+func TestStoreRegistryServeHTTPSkipsGzipBelowThreshold(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Body.Len() >= gzipSizeThreshold {
+		t.Fatalf("response is %d bytes, want it below gzipSizeThreshold for this test to be meaningful", w.Body.Len())
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q, want none for a response below gzipSizeThreshold", got)
+	}
+}
+
+func TestStoreRegistryServeHTTPClassicFormatByDefault(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Type"); got != classicContentType {
+		t.Fatalf("Content-Type = %q, want %q", got, classicContentType)
+	}
+	if strings.Contains(w.Body.String(), "# EOF") {
+		t.Fatalf("body contains an OpenMetrics EOF terminator for a request that didn't negotiate OpenMetrics: %q", w.Body.String())
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(w.Body.String()))
+	if err != nil {
+		t.Fatalf("parsing classic exposition format: %v", err)
+	}
+	if _, ok := families["virtualservice_info"]; !ok {
+		t.Fatalf("parsed families = %v, want a virtualservice_info family", families)
+	}
+}
+
+func TestStoreRegistryServeHTTPNegotiatesOpenMetrics(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;q=0.5")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Type"); got != openMetricsContentType {
+		t.Fatalf("Content-Type = %q, want %q", got, openMetricsContentType)
+	}
+	body := w.Body.String()
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Fatalf("body = %q, want it to end with the OpenMetrics EOF terminator", body)
+	}
+
+	// Every family this package emits is a Gauge, whose OpenMetrics line
+	// syntax matches the classic text format byte-for-byte, so the classic
+	// parser can still parse it once the "# EOF" terminator is stripped.
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(strings.TrimSuffix(body, "# EOF\n")))
+	if err != nil {
+		t.Fatalf("parsing OpenMetrics exposition format: %v", err)
+	}
+	family, ok := families["virtualservice_info"]
+	if !ok {
+		t.Fatalf("parsed families = %v, want a virtualservice_info family", families)
+	}
+	if family.GetType().String() != "GAUGE" {
+		t.Fatalf("family type = %v, want GAUGE", family.GetType())
+	}
+}
+
+// BenchmarkStoreRegistryServeHTTPGzip reports the bandwidth reduction gzip
+// compression gives a multi-megabyte scrape of several thousand CRs, which
+// is the scenario the threshold and Content-Encoding negotiation exist for.
+func BenchmarkStoreRegistryServeHTTPGzip(b *testing.B) {
+	r := virtualServiceRegistryForGzipTests(b, 5000)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	uncompressed := virtualServiceRegistryForGzipTests(b, 5000)
+	plainReq := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	plainW := httptest.NewRecorder()
+	uncompressed.ServeHTTP(plainW, plainReq)
+	b.Logf("uncompressed = %d bytes, gzipped = %d bytes (%.1f%% reduction)",
+		plainW.Body.Len(), w.Body.Len(), 100*(1-float64(w.Body.Len())/float64(plainW.Body.Len())))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+	}
+}
+
+func TestLogWriteErrorTreatsClientDisconnectsAsLowVerbosity(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		isDisconnect bool
+	}{
+		{"broken pipe", syscall.EPIPE, true},
+		{"connection reset by peer", syscall.ECONNRESET, true},
+		{"closed connection", net.ErrClosed, true},
+		{"wrapped broken pipe", fmt.Errorf("writing response: %w", syscall.EPIPE), true},
+		{"some other error", errors.New("disk full"), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isClientDisconnect(tc.err); got != tc.isDisconnect {
+				t.Fatalf("isClientDisconnect(%v) = %v, want %v", tc.err, got, tc.isDisconnect)
+			}
+		})
+	}
+}
+
+// BenchmarkServeHTTPAllocsBufferPool reports allocations per scrape with and
+// without bufPool's reuse, demonstrating the reduction recycling the
+// response buffer gives over allocating a fresh one on every request.
+func BenchmarkServeHTTPAllocsBufferPool(b *testing.B) {
+	r := virtualServiceRegistryForGzipTests(b, 5000)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+		}
+	})
+
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := new(bytes.Buffer)
+			if err := r.render(buf); err != nil {
+				b.Fatalf("render() = %v", err)
+			}
+			_ = buf.Bytes()
+		}
+	})
+}
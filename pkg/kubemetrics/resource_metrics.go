@@ -0,0 +1,298 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+	metricsclientset "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// defaultResourceMetricsInterval is how often GenerateAndServeResourceMetrics
+// polls metrics-server when interval is zero.
+const defaultResourceMetricsInterval = 30 * time.Second
+
+// maxOwnerHops bounds how far resolveOwningCR climbs a pod's owner chain
+// (Pod -> ReplicaSet -> Deployment -> ... ) looking for a CR owner, so a
+// cycle or unexpectedly deep chain can't spin forever.
+const maxOwnerHops = 5
+
+// podResourceUsage is a single pod's resource usage as reported by
+// metrics-server, together with the GVK of the custom resource that owns it
+// (directly or transitively). It embeds ObjectMeta, keyed off the owning
+// pod's namespace/name/UID, purely so it satisfies meta.Accessor and can be
+// fed into a MetricsStore like every other object that store handles.
+type podResourceUsage struct {
+	metav1.ObjectMeta
+	node        string
+	ownerGVK    schema.GroupVersionKind
+	ownerName   string
+	cpuCores    float64
+	memoryBytes float64
+}
+
+// GenerateAndServeResourceMetrics periodically queries the metrics.k8s.io API
+// for resource usage of pods owned (directly or transitively) by one of
+// operatorGVKs, and registers the results into manager's store registry, so
+// they're scraped on the same /metrics endpoint GenerateAndServeCRMetrics
+// already started serving manager on. interval controls how often
+// metrics-server is polled; it defaults to 30s when zero or negative. If
+// metrics-server isn't installed on the cluster, each poll is logged and
+// skipped rather than treated as fatal.
+func GenerateAndServeResourceMetrics(manager *CRMetricsManager,
+	operatorGVKs []schema.GroupVersionKind,
+	interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultResourceMetricsInterval
+	}
+
+	metricsClient, err := metricsclientset.NewForConfig(manager.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to build metrics.k8s.io client: %w", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(manager.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to build kubernetes client: %w", err)
+	}
+
+	poller := &resourceMetricsPoller{
+		cfg:           manager.cfg,
+		metricsClient: metricsClient,
+		kubeClient:    kubeClient,
+		ns:            manager.ns,
+		operatorGVKs:  operatorGVKs,
+		registry:      manager.registry,
+		interval:      interval,
+	}
+	go poller.Run()
+
+	return nil
+}
+
+type resourceMetricsPoller struct {
+	cfg           *rest.Config
+	metricsClient metricsclientset.Interface
+	kubeClient    kubernetes.Interface
+	ns            []string
+	operatorGVKs  []schema.GroupVersionKind
+	registry      *storeRegistry
+	interval      time.Duration
+}
+
+func (p *resourceMetricsPoller) Run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.poll()
+		<-ticker.C
+	}
+}
+
+// poll fetches the current pod metrics and updates the registry. Errors
+// talking to metrics-server (most commonly because it isn't installed) are
+// logged and otherwise ignored so the operator keeps running with stale or
+// absent resource usage metrics rather than crashing.
+func (p *resourceMetricsPoller) poll() {
+	usage, err := p.collect()
+	if err != nil {
+		log.V(1).Info("Skipping resource metrics poll", "reason", err.Error())
+		return
+	}
+
+	for _, gvk := range p.operatorGVKs {
+		if isWildcardGVK(gvk) {
+			continue
+		}
+		var forKind []interface{}
+		for i := range usage {
+			u := usage[i]
+			if u.ownerGVK == gvk {
+				forKind = append(forKind, &u)
+			}
+		}
+		store := metricsstore.NewMetricsStore(
+			ksmetric.ExtractMetricFamilyHeaders(resourceMetricFamilies(gvk.Kind)),
+			ksmetric.ComposeMetricGenFuncs(resourceMetricFamilies(gvk.Kind)),
+		)
+		if err := store.Replace(forKind, ""); err != nil {
+			log.Error(err, "Failed to update resource metrics store", "gvk", gvk.String())
+			continue
+		}
+		p.registry.set(resourceMetricsKey(gvk), []*managedStore{{MetricsStore: store}})
+	}
+}
+
+// resourceMetricsKey namespaces a GVK's entry in the shared store registry so
+// it doesn't collide with the CR info stores GenerateAndServeCRMetrics may
+// have registered for the same GVK.
+func resourceMetricsKey(gvk schema.GroupVersionKind) schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind + "#resource-metrics"}
+}
+
+// collect lists PodMetrics across p.ns, then walks each pod's ownerReferences
+// (following through intermediate owners such as ReplicaSets, up to
+// maxOwnerHops) to find the custom resource, if any, that ultimately owns it.
+func (p *resourceMetricsPoller) collect() ([]podResourceUsage, error) {
+	apiResourceLists, err := getAPIResourceLists(p.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var usage []podResourceUsage
+	for _, namespace := range p.ns {
+		podMetricsList, err := p.metricsClient.MetricsV1beta1().PodMetricses(namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("metrics.k8s.io not available: %w", err)
+			}
+			return nil, err
+		}
+		pods, err := p.kubeClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		podsByName := make(map[string]*corev1.Pod, len(pods.Items))
+		for i := range pods.Items {
+			podsByName[pods.Items[i].Name] = &pods.Items[i]
+		}
+
+		for _, pm := range podMetricsList.Items {
+			pod, ok := podsByName[pm.Name]
+			if !ok {
+				continue
+			}
+			ownerGVK, ownerName, ok := p.resolveOwningCR(context.TODO(), namespace, pod.GetOwnerReferences(), apiResourceLists, 0)
+			if !ok {
+				continue
+			}
+			var cpuCores, memoryBytes float64
+			for _, c := range pm.Containers {
+				cpuCores += c.Usage.Cpu().AsApproximateFloat64()
+				memoryBytes += c.Usage.Memory().AsApproximateFloat64()
+			}
+			usage = append(usage, podResourceUsage{
+				ObjectMeta:  metav1.ObjectMeta{Namespace: namespace, Name: pod.Name, UID: pod.UID},
+				node:        pod.Spec.NodeName,
+				ownerGVK:    ownerGVK,
+				ownerName:   ownerName,
+				cpuCores:    cpuCores,
+				memoryBytes: memoryBytes,
+			})
+		}
+	}
+	return usage, nil
+}
+
+// resolveOwningCR checks owners for a match against p.operatorGVKs and, if
+// none matches, fetches each owner in turn and recurses into its own
+// ownerReferences, up to maxOwnerHops deep. Owner kinds that can't be
+// resolved against apiResourceLists (or fetched) are skipped rather than
+// failing the whole lookup, since an intermediate owner controller-managed
+// type is out of scope for CR attribution.
+func (p *resourceMetricsPoller) resolveOwningCR(ctx context.Context, namespace string,
+	owners []metav1.OwnerReference, apiResourceLists []*metav1.APIResourceList, hop int) (schema.GroupVersionKind, string, bool) {
+	if hop >= maxOwnerHops {
+		return schema.GroupVersionKind{}, "", false
+	}
+
+	for _, owner := range owners {
+		gvk := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+		for _, operatorGVK := range p.operatorGVKs {
+			if gvk == operatorGVK {
+				return gvk, owner.Name, true
+			}
+		}
+	}
+
+	for _, owner := range owners {
+		gvk := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+		dclient, err := dynamicClientForGVK(p.cfg, apiResourceLists, gvk)
+		if err != nil {
+			continue
+		}
+		namespaced, err := isNamespaced(gvk, apiResourceLists)
+		if err != nil {
+			continue
+		}
+		var obj *unstructured.Unstructured
+		if namespaced {
+			obj, err = dclient.Namespace(namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		} else {
+			obj, err = dclient.Get(ctx, owner.Name, metav1.GetOptions{})
+		}
+		if err != nil {
+			continue
+		}
+		if matchGVK, matchName, ok := p.resolveOwningCR(ctx, namespace, obj.GetOwnerReferences(), apiResourceLists, hop+1); ok {
+			return matchGVK, matchName, ok
+		}
+	}
+	return schema.GroupVersionKind{}, "", false
+}
+
+// resourceMetricFamilies builds the cpu/memory gauge families for a single
+// operator kind, named after it the same way generateMetricFamilies names
+// the fixed info gauge.
+func resourceMetricFamilies(kind string) []ksmetric.FamilyGenerator {
+	kindName := sanitizeKindForMetricName(strings.ToLower(kind))
+	return []ksmetric.FamilyGenerator{
+		{
+			Name: fmt.Sprintf("%s_pod_cpu_usage_cores", kindName),
+			Type: ksmetric.Gauge,
+			Help: fmt.Sprintf("CPU cores currently used by pods owned by a %s custom resource, as reported by metrics-server.", kind),
+			GenerateFunc: func(obj interface{}) *ksmetric.Family {
+				u := obj.(*podResourceUsage)
+				return &ksmetric.Family{
+					Metrics: []*ksmetric.Metric{
+						{
+							Value:       u.cpuCores,
+							LabelKeys:   []string{"namespace", "pod", "node", kindName},
+							LabelValues: []string{u.Namespace, u.Name, u.node, u.ownerName},
+						},
+					},
+				}
+			},
+		},
+		{
+			Name: fmt.Sprintf("%s_pod_memory_usage_bytes", kindName),
+			Type: ksmetric.Gauge,
+			Help: fmt.Sprintf("Memory bytes currently used by pods owned by a %s custom resource, as reported by metrics-server.", kind),
+			GenerateFunc: func(obj interface{}) *ksmetric.Family {
+				u := obj.(*podResourceUsage)
+				return &ksmetric.Family{
+					Metrics: []*ksmetric.Metric{
+						{
+							Value:       u.memoryBytes,
+							LabelKeys:   []string{"namespace", "pod", "node", kindName},
+							LabelValues: []string{u.Namespace, u.Name, u.node, u.ownerName},
+						},
+					},
+				}
+			},
+		},
+	}
+}
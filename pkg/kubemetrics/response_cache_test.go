@@ -0,0 +1,144 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// countingGatherer counts how many times Gather is called, so tests can
+// assert on how many times storeRegistry.ServeHTTP actually rendered rather
+// than served a cached response. Its Gather always succeeds with no
+// families: renderAll only cares that gathering happened.
+type countingGatherer struct {
+	renders int64
+}
+
+func (g *countingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	atomic.AddInt64(&g.renders, 1)
+	return nil, nil
+}
+
+func TestStoreRegistryServeHTTPDoesNotCacheByDefault(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+	g := &countingGatherer{}
+	r.extraGatherer = g
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+	}
+
+	if got := atomic.LoadInt64(&g.renders); got != 3 {
+		t.Fatalf("renders = %d, want 3: caching must be disabled by default", got)
+	}
+}
+
+func TestStoreRegistryServeHTTPReusesResponseWithinTTL(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+	g := &countingGatherer{}
+	r.extraGatherer = g
+	r.configureResponseCache(ResponseCacheOptions{TTL: time.Hour})
+
+	var bodies []string
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		bodies = append(bodies, w.Body.String())
+	}
+
+	if got := atomic.LoadInt64(&g.renders); got != 1 {
+		t.Fatalf("renders = %d, want 1: later scrapes within TTL should reuse the first render", got)
+	}
+	for i, body := range bodies {
+		if body != bodies[0] {
+			t.Fatalf("response %d = %q, want it identical to the first response %q", i, body, bodies[0])
+		}
+	}
+}
+
+func TestStoreRegistryServeHTTPRerendersAfterTTLExpires(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+	g := &countingGatherer{}
+	r.extraGatherer = g
+	r.configureResponseCache(ResponseCacheOptions{TTL: time.Millisecond})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	time.Sleep(5 * time.Millisecond)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := atomic.LoadInt64(&g.renders); got != 2 {
+		t.Fatalf("renders = %d, want 2: a scrape after TTL expiry must re-render", got)
+	}
+}
+
+func TestStoreRegistryServeHTTPCachesGzipAndPlainVariantsSeparately(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 5000)
+	r.configureResponseCache(ResponseCacheOptions{TTL: time.Hour})
+
+	plainReq := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	plainW := httptest.NewRecorder()
+	r.ServeHTTP(plainW, plainReq)
+	if got := plainW.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q, want none for a request that didn't accept gzip", got)
+	}
+
+	gzipReq := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	gzipReq.Header.Set("Accept-Encoding", "gzip")
+	gzipW := httptest.NewRecorder()
+	r.ServeHTTP(gzipW, gzipReq)
+	if got := gzipW.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip for a request that accepted it", got)
+	}
+
+	// The cache must not have served the gzip scrape's compressed bytes back
+	// to the plain request, or vice versa.
+	if plainW.Body.String() == gzipW.Body.String() {
+		t.Fatal("plain and gzip responses are byte-identical, want the gzip variant compressed")
+	}
+}
+
+func TestStoreRegistryServeHTTPConcurrentScrapesRenderOnce(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+	g := &countingGatherer{}
+	r.extraGatherer = g
+	r.configureResponseCache(ResponseCacheOptions{TTL: time.Hour})
+
+	const concurrency = 20
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&g.renders); got != 1 {
+		t.Fatalf("renders = %d, want 1 for a burst of concurrent scrapes within TTL", got)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+// istioRevisionLabel is the well-known Kubernetes label revision-based
+// canary upgrades stamp onto a revisioned control plane resource, naming
+// which revision it belongs to.
+const istioRevisionLabel = "istio.io/rev"
+
+// RevisionLabelValue returns crd's istio.io/rev label if set, else its
+// spec.revision field if present, else "". It's the source
+// GVKOptions.RevisionLabel uses for the "revision" label on "<kind>_info",
+// and is exported so a custom family generator (registered via
+// RegisterFamilyGenerator, or set as a GVKOptions.FamilyGenerators
+// override) can surface the same label on its own metrics without
+// duplicating this lookup.
+func RevisionLabelValue(crd *unstructured.Unstructured) string {
+	if rev := crd.GetLabels()[istioRevisionLabel]; rev != "" {
+		return rev
+	}
+	rev, _, _ := unstructured.NestedString(crd.Object, "spec", "revision")
+	return rev
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func smcpAPIResourceLists() []*metav1.APIResourceList {
+	return []*metav1.APIResourceList{
+		{
+			GroupVersion: "maistra.io/v2",
+			APIResources: []metav1.APIResource{
+				{Name: "servicemeshcontrolplanes", Kind: "ServiceMeshControlPlane", Namespaced: true},
+				{Name: "servicemeshcontrolplanes/scale", Kind: "Scale", Namespaced: true},
+			},
+		},
+	}
+}
+
+func virtualServiceAPIResourceLists() []*metav1.APIResourceList {
+	return []*metav1.APIResourceList{
+		{
+			GroupVersion: "networking.istio.io/v1beta1",
+			APIResources: []metav1.APIResource{
+				{Name: "virtualservices", Kind: "VirtualService", Namespaced: true},
+			},
+		},
+	}
+}
+
+func TestHasScaleSubresourceDetectsScaleEntry(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "maistra.io", Version: "v2", Kind: "ServiceMeshControlPlane"}
+	if !hasScaleSubresource(gvk, smcpAPIResourceLists()) {
+		t.Fatalf("hasScaleSubresource() = false, want true for a GVK whose APIResourceList includes a /scale entry")
+	}
+}
+
+func TestHasScaleSubresourceFalseWithoutScaleEntry(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "VirtualService"}
+	if hasScaleSubresource(gvk, virtualServiceAPIResourceLists()) {
+		t.Fatalf("hasScaleSubresource() = true, want false for a GVK with no /scale entry")
+	}
+}
+
+func TestHasScaleSubresourceFalseForUnknownGVK(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "example.io", Version: "v1", Kind: "Unknown"}
+	if hasScaleSubresource(gvk, smcpAPIResourceLists()) {
+		t.Fatalf("hasScaleSubresource() = true, want false for a GVK absent from apiResourceLists")
+	}
+}
+
+func TestScaleReplicasFamilyGeneratorsReadsSpecAndStatus(t *testing.T) {
+	gens := scaleReplicasFamilyGenerators("ServiceMeshControlPlane")
+	if len(gens) != 2 || gens[0].Name != "servicemeshcontrolplane_spec_replicas" || gens[1].Name != "servicemeshcontrolplane_status_ready_replicas" {
+		t.Fatalf("scaleReplicasFamilyGenerators() = %+v, want spec_replicas and status_ready_replicas gauges in that order", gens)
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"spec":     map[string]interface{}{"replicas": int64(3)},
+		"status":   map[string]interface{}{"readyReplicas": int64(2)},
+	}}
+
+	specFamily := gens[0].GenerateFunc(obj)
+	if len(specFamily.Metrics) != 1 || specFamily.Metrics[0].Value != 3 {
+		t.Fatalf("spec_replicas GenerateFunc() = %+v, want a single sample valued 3", specFamily.Metrics)
+	}
+
+	statusFamily := gens[1].GenerateFunc(obj)
+	if len(statusFamily.Metrics) != 1 || statusFamily.Metrics[0].Value != 2 {
+		t.Fatalf("status_ready_replicas GenerateFunc() = %+v, want a single sample valued 2", statusFamily.Metrics)
+	}
+}
+
+func TestScaleReplicasFamilyGeneratorsFallBackWhenFieldsAbsent(t *testing.T) {
+	gens := scaleReplicasFamilyGenerators("ServiceMeshControlPlane")
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+
+	specFamily := gens[0].GenerateFunc(obj)
+	if len(specFamily.Metrics) != 1 || specFamily.Metrics[0].Value != 1 {
+		t.Fatalf("spec_replicas GenerateFunc() = %+v, want a fallback value of 1 when spec.replicas is absent", specFamily.Metrics)
+	}
+
+	statusFamily := gens[1].GenerateFunc(obj)
+	if len(statusFamily.Metrics) != 1 || statusFamily.Metrics[0].Value != 0 {
+		t.Fatalf("status_ready_replicas GenerateFunc() = %+v, want a fallback value of 0 when status.readyReplicas is absent", statusFamily.Metrics)
+	}
+}
+
+func TestBuildStoresForGVKSkipsReplicasGaugesWithoutScaleSubresource(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "VirtualService"}
+	gens := familyGeneratorsForGVK(gvk, gvk.Kind, GVKOptions{}, nil, "", false, NameLabelModeKind, true)
+	if hasScaleSubresource(gvk, virtualServiceAPIResourceLists()) {
+		t.Fatalf("hasScaleSubresource() = true, want false for VirtualService so buildStoresForGVK never appends replicas gauges to %+v", gens)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// blockingGatherer's Gather blocks until done is closed, for exercising
+// storeRegistry's render timeout without needing a genuinely slow store.
+type blockingGatherer struct{ done <-chan struct{} }
+
+func (g blockingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	<-g.done
+	return nil, nil
+}
+
+func TestStoreRegistryServeHTTPThrottlesWhenConcurrencyLimitExceeded(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+	r.configureScrapeLimits(ScrapeLimitOptions{MaxConcurrentRenders: 1, RenderTimeout: time.Minute})
+
+	originalWait := scrapeQueueWait
+	scrapeQueueWait = 20 * time.Millisecond
+	defer func() { scrapeQueueWait = originalWait }()
+
+	r.renderSem <- struct{}{} // occupy the only render slot
+	defer func() { <-r.renderSem }()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := r.ThrottledScrapes(); got != 1 {
+		t.Fatalf("ThrottledScrapes() = %d, want 1", got)
+	}
+	if got := r.TimedOutScrapes(); got != 0 {
+		t.Fatalf("TimedOutScrapes() = %d, want 0", got)
+	}
+}
+
+func TestStoreRegistryServeHTTPTimesOutSlowRender(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+	r.configureScrapeLimits(ScrapeLimitOptions{RenderTimeout: 20 * time.Millisecond})
+
+	block := make(chan struct{})
+	defer close(block)
+	r.extraGatherer = blockingGatherer{done: block}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := r.TimedOutScrapes(); got != 1 {
+		t.Fatalf("TimedOutScrapes() = %d, want 1", got)
+	}
+}
+
+func TestStoreRegistryConfigureScrapeLimitsDefaults(t *testing.T) {
+	r := newStoreRegistry()
+	r.configureScrapeLimits(ScrapeLimitOptions{})
+
+	if got := cap(r.renderSem); got != defaultMaxConcurrentRenders {
+		t.Errorf("renderSem capacity = %d, want defaultMaxConcurrentRenders (%d)", got, defaultMaxConcurrentRenders)
+	}
+	if r.renderTimeout != defaultRenderTimeout {
+		t.Errorf("renderTimeout = %v, want defaultRenderTimeout (%v)", r.renderTimeout, defaultRenderTimeout)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// selfMetricsNamespace prefixes every metric selfMetrics exposes, so they
+// read as e.g. "cr_metrics_scrape_duration_seconds" alongside the CR metrics
+// they describe.
+const selfMetricsNamespace = "cr_metrics"
+
+// selfMetrics instruments storeRegistry's own scrape handling: how long the
+// most recent render took, and how large the response it produced was. It
+// keeps its own prometheus.Registry rather than registering into the
+// default global one, so more than one storeRegistry — across tests, or a
+// process running more than one CRMetricsManager — never collides over the
+// same metric name.
+type selfMetrics struct {
+	registry *prometheus.Registry
+
+	scrapeDurationSeconds prometheus.Histogram
+	lastResponseSizeBytes prometheus.Gauge
+	lastResponseSeries    prometheus.Gauge
+}
+
+// newSelfMetrics constructs a selfMetrics whose series all carry constLabels,
+// the same DiscoveryOptions.ConstLabels every CR-generated family is stamped
+// with via applyGVKOptions, so a label like "cluster" joins cleanly across
+// both kinds of series in PromQL. Nil constLabels, the default, attaches no
+// extra labels, matching this function's pre-ConstLabels behavior.
+func newSelfMetrics(constLabels map[string]string) *selfMetrics {
+	m := &selfMetrics{
+		registry: prometheus.NewRegistry(),
+		scrapeDurationSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   selfMetricsNamespace,
+			Name:        "scrape_duration_seconds",
+			Help:        "How long the most recent render of this endpoint's stores took, in seconds.",
+			ConstLabels: constLabels,
+		}),
+		lastResponseSizeBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   selfMetricsNamespace,
+			Name:        "scrape_response_size_bytes",
+			Help:        "Size, in bytes, of the most recent scrape's rendered response, not counting this family itself or any gzip compression applied afterward.",
+			ConstLabels: constLabels,
+		}),
+		lastResponseSeries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   selfMetricsNamespace,
+			Name:        "scrape_response_series",
+			Help:        "Number of metric series in the most recent scrape's rendered response, not counting this family itself.",
+			ConstLabels: constLabels,
+		}),
+	}
+	m.registry.MustRegister(m.scrapeDurationSeconds, m.lastResponseSizeBytes, m.lastResponseSeries)
+	return m
+}
+
+// observe records a render that took duration and produced buf: buf's
+// length in bytes, and its number of sample series. Works the same whether
+// the stores behind buf are namespaced or cluster-scoped, since it only
+// ever looks at the rendered bytes, never at a store directly.
+func (m *selfMetrics) observe(duration time.Duration, buf *bytes.Buffer) {
+	m.scrapeDurationSeconds.Observe(duration.Seconds())
+	m.lastResponseSizeBytes.Set(float64(buf.Len()))
+	m.lastResponseSeries.Set(float64(countSeries(buf.Bytes())))
+}
+
+// countSeries counts data's sample lines: every non-empty line that isn't a
+// "#"-prefixed HELP/TYPE/EOF directive.
+func countSeries(data []byte) int {
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// mergeInto appends this selfMetrics' own families to buf, the same way
+// mergeExternalFamilies appends a caller-supplied Gatherer's, so
+// cr_metrics_scrape_duration_seconds and its siblings show up in the same
+// scrape they describe.
+func (m *selfMetrics) mergeInto(buf *bytes.Buffer) error {
+	families, err := m.registry.Gather()
+	if err != nil {
+		return err
+	}
+	encoder := expfmt.NewEncoder(buf, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
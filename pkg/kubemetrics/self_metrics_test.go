@@ -0,0 +1,124 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+)
+
+func TestStoreRegistryServeHTTPRecordsSelfMetrics(t *testing.T) {
+	r := virtualServiceRegistryForGzipTests(t, 1)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(strings.NewReader(w.Body.String()))
+		if err != nil {
+			t.Fatalf("parsing classic exposition format: %v", err)
+		}
+		if _, ok := families["cr_metrics_scrape_duration_seconds"]; !ok {
+			t.Fatalf("parsed families = %v, want a cr_metrics_scrape_duration_seconds family", families)
+		}
+		if _, ok := families["cr_metrics_scrape_response_size_bytes"]; !ok {
+			t.Fatalf("parsed families = %v, want a cr_metrics_scrape_response_size_bytes family", families)
+		}
+		if _, ok := families["cr_metrics_scrape_response_series"]; !ok {
+			t.Fatalf("parsed families = %v, want a cr_metrics_scrape_response_series family", families)
+		}
+
+		var m dto.Metric
+		if err := r.self.scrapeDurationSeconds.Write(&m); err != nil {
+			t.Fatalf("scrapeDurationSeconds.Write() = %v", err)
+		}
+		if got, want := m.GetHistogram().GetSampleCount(), uint64(i+1); got != want {
+			t.Fatalf("scrapeDurationSeconds sample count after %d scrape(s) = %d, want %d", i+1, got, want)
+		}
+	}
+}
+
+// TestStoreRegistryConstLabelsAppearOnEveryFamily builds a registry the way
+// GenerateAndServeCRMetricsWithOptions does for a non-nil DiscoveryOptions.ConstLabels:
+// applyGVKOptions wraps the CR family generators, and configureConstLabels
+// rebuilds r.self, so a scrape should carry the same constant label on both
+// kinds of series.
+func TestStoreRegistryConstLabelsAppearOnEveryFamily(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "VirtualService"}
+	constLabels := map[string]string{"cluster": "prod-us-east"}
+
+	generators := generateMetricFamilies(gvk.Kind, nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	generators, err := applyGVKOptions(generators, GVKOptions{}, constLabels)
+	if err != nil {
+		t.Fatalf("applyGVKOptions() = %v", err)
+	}
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(generators),
+		ksmetric.ComposeMetricGenFuncs(generators),
+	)
+	if err := store.Replace([]interface{}{&unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"namespace": "istio-system", "name": "basic"},
+	}}}, ""); err != nil {
+		t.Fatalf("store.Replace() = %v", err)
+	}
+
+	r := newStoreRegistry()
+	r.configureConstLabels(constLabels)
+	r.set(gvk, []*managedStore{{MetricsStore: store}})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(w.Body.String()))
+	if err != nil {
+		t.Fatalf("parsing classic exposition format: %v", err)
+	}
+
+	for _, name := range []string{"virtualservice_info", "cr_metrics_scrape_duration_seconds"} {
+		family, ok := families[name]
+		if !ok || len(family.Metric) == 0 {
+			t.Fatalf("parsed families[%q] = %v, want at least one sample", name, family)
+		}
+		var found string
+		for _, label := range family.Metric[0].Label {
+			if label.GetName() == "cluster" {
+				found = label.GetValue()
+			}
+		}
+		if found != "prod-us-east" {
+			t.Fatalf("families[%q] sample's \"cluster\" label = %q, want %q", name, found, "prod-us-east")
+		}
+	}
+}
+
+func TestCountSeries(t *testing.T) {
+	data := "# HELP foo help text\n# TYPE foo gauge\nfoo{a=\"1\"} 1\nfoo{a=\"2\"} 1\n"
+	if got, want := countSeries([]byte(data)), 2; got != want {
+		t.Fatalf("countSeries() = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// GenerateSelfSignedCert creates a throwaway self-signed certificate/key
+// pair valid for host (an IP address or a DNS name) and writes them as PEM
+// files under a fresh temporary directory, returning their paths for use as
+// TLSOptions.CertFile/KeyFile. It exists for tests and quick local setups
+// that need a keypair without running an external CA; a real deployment
+// should use a certificate issued by cert-manager or the cluster's CA
+// instead, since nothing else will trust this one.
+//
+// Because the certificate isn't signed by any CA Prometheus already trusts,
+// scraping an endpoint secured with it requires the scrape job's
+// tls_config to either set insecure_skip_verify: true, or point ca_file at
+// this same certFile, since the cert is also its own issuer:
+//
+//	scrape_configs:
+//	  - job_name: operator-cr-metrics
+//	    scheme: https
+//	    tls_config:
+//	      ca_file: /path/to/tls.crt
+func GenerateSelfSignedCert(host string) (certFile, keyFile string, err error) {
+	dir, err := ioutil.TempDir("", "kubemetrics-selfsigned")
+	if err != nil {
+		return "", "", err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", fmt.Errorf("generating private key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", fmt.Errorf("creating certificate: %w", err)
+	}
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	if err := writePEM(certFile, "CERTIFICATE", der); err != nil {
+		return "", "", err
+	}
+	if err := writePEM(keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// writePEM encodes der as a PEM block of blockType and writes it to path
+// with permissions readable only by its owner, since keyFile's contents are
+// a private key.
+func writePEM(path, blockType string, der []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		return fmt.Errorf("encoding %s into %s: %w", blockType, path, err)
+	}
+	return nil
+}
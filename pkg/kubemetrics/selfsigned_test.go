@@ -0,0 +1,53 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGenerateSelfSignedCert(t *testing.T) {
+	certFile, keyFile, err := GenerateSelfSignedCert("127.0.0.1")
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() = %v", err)
+	}
+
+	m := newCRMetricsManager(nil, nil, newStoreRegistry(), nil, nil, nil,
+		&TLSOptions{CertFile: certFile, KeyFile: keyFile}, nil, 0, "", 0, TimeoutOptions{}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	resp, err := client.Get("https://" + m.Addr() + defaultMetricsPath)
+	if err != nil {
+		t.Fatalf("GET %s%s = %v", m.Addr(), defaultMetricsPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// blockingGatherer blocks Gather until release is closed, so tests can hold
+// a scrape in flight while shutdown runs concurrently.
+type blockingGatherer struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockingGatherer() *blockingGatherer {
+	return &blockingGatherer{entered: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (g *blockingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	close(g.entered)
+	<-g.release
+	return nil, nil
+}
+
+func TestCRMetricsManagerStopAllDrainsInFlightScrapeBeforeStoppingReflectors(t *testing.T) {
+	registry := virtualServiceRegistryForGzipTests(t, 1)
+	g := newBlockingGatherer()
+	registry.extraGatherer = g
+
+	m := newCRMetricsManager(nil, nil, registry, nil, nil, nil, nil, nil, 0, "", 0,
+		TimeoutOptions{DrainTimeout: time.Second}, "", nil, false, "")
+	if err := m.Serve("127.0.0.1", 0); err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+
+	scrapeErrCh := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + m.Addr() + "/metrics")
+		if err != nil {
+			scrapeErrCh <- err
+			return
+		}
+		resp.Body.Close()
+		scrapeErrCh <- nil
+	}()
+
+	select {
+	case <-g.entered:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the in-flight scrape to start")
+	}
+
+	stoppedCh := make(chan struct{})
+	go func() {
+		m.stopAll()
+		close(stoppedCh)
+	}()
+
+	// stopAll must block on the in-flight scrape rather than tearing down
+	// the registry's reflectors out from under it.
+	select {
+	case <-stoppedCh:
+		t.Fatal("stopAll() returned before the in-flight scrape finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(g.release)
+
+	select {
+	case err := <-scrapeErrCh:
+		if err != nil {
+			t.Fatalf("in-flight scrape failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the in-flight scrape to complete")
+	}
+
+	select {
+	case <-stoppedCh:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for stopAll() to return")
+	}
+
+	if _, err := http.Get("http://" + m.Addr() + "/metrics"); err == nil {
+		t.Fatal("scrape after stopAll() succeeded, want the listener to be closed")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// smcpGroup is the API group maistra.io's ServiceMeshControlPlane is served
+// under, at both v1 and v2.
+const smcpGroup = "maistra.io"
+
+func init() {
+	infoGen := smcpInfoFamilyGenerator()
+	readyGen := smcpComponentReadyFamilyGenerator()
+	for _, version := range []string{"v1", "v2"} {
+		gvk := schema.GroupVersionKind{Group: smcpGroup, Version: version, Kind: "ServiceMeshControlPlane"}
+		RegisterFamilyGenerator(gvk, infoGen)
+		RegisterFamilyGenerator(gvk, readyGen)
+	}
+}
+
+// smcpInfoFamilyGenerator builds the servicemeshcontrolplane_info gauge,
+// registered in place of the fixed "<kind>_info" fallback generateMetricFamilies
+// would otherwise produce — registering any generator for a GVK opts it out
+// of that fallback entirely, so this is the only info gauge a maistra.io
+// ServiceMeshControlPlane gets. It labels every series with version (from
+// status.appliedVersion, falling back to spec.version when status hasn't
+// caught up yet) and profile (spec.profiles joined by comma), so fleet
+// dashboards can break down control planes still on an old version or
+// profile without a join. Both fields are read with plain unstructured
+// lookups so a v1 ServiceMeshControlPlane, which has never set
+// status.appliedVersion, degrades to spec.version rather than an error.
+func smcpInfoFamilyGenerator() ksmetric.FamilyGenerator {
+	return ksmetric.FamilyGenerator{
+		Name: "servicemeshcontrolplane_info",
+		Type: ksmetric.Gauge,
+		Help: "Information about the maistra.io ServiceMeshControlPlane custom resource, including its applied version and profiles.",
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+
+			version, ok, _ := unstructured.NestedString(crd.Object, "status", "appliedVersion")
+			if !ok || version == "" {
+				version, _, _ = unstructured.NestedString(crd.Object, "spec", "version")
+			}
+			profiles, _, _ := unstructured.NestedStringSlice(crd.Object, "spec", "profiles")
+
+			return &ksmetric.Family{
+				Metrics: []*ksmetric.Metric{
+					{
+						Value:       1,
+						LabelKeys:   []string{"namespace", "name", "version", "profile"},
+						LabelValues: []string{crd.GetNamespace(), crd.GetName(), sanitizeLabelValue(version), sanitizeLabelValue(strings.Join(profiles, ","))},
+					},
+				},
+			}
+		},
+	}
+}
+
+// smcpComponentReadyFamilyGenerator builds the smcp_component_ready gauge
+// family, registered automatically for both maistra.io ServiceMeshControlPlane
+// versions whenever one of them appears among the GVKs a CRMetricsManager
+// tracks: buildStoresForGVK consults RegisterFamilyGenerator's registry
+// ahead of the fixed "<kind>_info" fallback, so no opt-in config is needed
+// beyond adding the GVK itself. It emits one series per entry status.readiness.components.ready/
+// .unready/.pending names, valued 1 for a component listed under ready and 0
+// for one listed under unready or pending. A v1 ServiceMeshControlPlane has
+// no status.readiness field at all — its readiness is the flat
+// status.components map of booleans instead, already covered by the
+// MetricSpec Map type (see mapFamilyGenerator) for operators that configure
+// it — so resolving status.readiness.components against one produces no
+// samples rather than an error.
+func smcpComponentReadyFamilyGenerator() ksmetric.FamilyGenerator {
+	return ksmetric.FamilyGenerator{
+		Name: "smcp_component_ready",
+		Type: ksmetric.Gauge,
+		Help: "Whether each component maistra.io's ServiceMeshControlPlane v2 status reports readiness for is ready (1) or not (0).",
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			ready, _, _ := unstructured.NestedStringSlice(crd.Object, "status", "readiness", "components", "ready")
+			unready, _, _ := unstructured.NestedStringSlice(crd.Object, "status", "readiness", "components", "unready")
+			pending, _, _ := unstructured.NestedStringSlice(crd.Object, "status", "readiness", "components", "pending")
+
+			metrics := make([]*ksmetric.Metric, 0, len(ready)+len(unready)+len(pending))
+			for _, component := range ready {
+				metrics = append(metrics, smcpComponentReadyMetric(crd, component, 1))
+			}
+			for _, component := range unready {
+				metrics = append(metrics, smcpComponentReadyMetric(crd, component, 0))
+			}
+			for _, component := range pending {
+				metrics = append(metrics, smcpComponentReadyMetric(crd, component, 0))
+			}
+			return &ksmetric.Family{Metrics: metrics}
+		},
+	}
+}
+
+func smcpComponentReadyMetric(crd *unstructured.Unstructured, component string, value float64) *ksmetric.Metric {
+	return &ksmetric.Metric{
+		Value:       value,
+		LabelKeys:   []string{"namespace", "name", "component"},
+		LabelValues: []string{crd.GetNamespace(), crd.GetName(), sanitizeLabelValue(component)},
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// smcpV2Readiness builds a realistic, trimmed status.readiness payload from
+// a maistra ServiceMeshControlPlane v2: discovery and gateways are up,
+// proxy hasn't settled yet, and addons failed.
+func smcpV2Readiness() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status": map[string]interface{}{
+			"readiness": map[string]interface{}{
+				"components": map[string]interface{}{
+					"ready":   []interface{}{"discovery", "gateways"},
+					"pending": []interface{}{"proxy"},
+					"unready": []interface{}{"addons"},
+				},
+			},
+		},
+	}}
+}
+
+// smcpV1ComponentStatus builds a maistra ServiceMeshControlPlane v1-shaped
+// status: a flat status.components map of booleans, with no status.readiness
+// field at all.
+func smcpV1ComponentStatus() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"status": map[string]interface{}{
+			"components": map[string]interface{}{"pilot": true, "grafana": false},
+		},
+	}}
+}
+
+func TestSMCPInfoFamilyGeneratorPrefersAppliedVersionOverSpecVersion(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"spec":     map[string]interface{}{"version": "2.4.0", "profiles": []interface{}{"default"}},
+		"status":   map[string]interface{}{"appliedVersion": "2.4.1"},
+	}}
+	gen := smcpInfoFamilyGenerator()
+	family := gen.GenerateFunc(obj)
+
+	if len(family.Metrics) != 1 {
+		t.Fatalf("Metrics = %v, want exactly one series", family.Metrics)
+	}
+	got := map[string]string{}
+	for i, key := range family.Metrics[0].LabelKeys {
+		got[key] = family.Metrics[0].LabelValues[i]
+	}
+	if got["version"] != "2.4.1" {
+		t.Fatalf("version = %q, want status.appliedVersion (2.4.1) over spec.version", got["version"])
+	}
+	if got["profile"] != "default" {
+		t.Fatalf("profile = %q, want default", got["profile"])
+	}
+}
+
+func TestSMCPInfoFamilyGeneratorFallsBackToSpecVersionWithoutStatus(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+		"spec":     map[string]interface{}{"version": "2.3.0", "profiles": []interface{}{"default", "maistra"}},
+	}}
+	gen := smcpInfoFamilyGenerator()
+	family := gen.GenerateFunc(obj)
+
+	got := map[string]string{}
+	for i, key := range family.Metrics[0].LabelKeys {
+		got[key] = family.Metrics[0].LabelValues[i]
+	}
+	if got["version"] != "2.3.0" {
+		t.Fatalf("version = %q, want spec.version (2.3.0) with no status.appliedVersion set", got["version"])
+	}
+	if got["profile"] != "default,maistra" {
+		t.Fatalf("profile = %q, want default,maistra", got["profile"])
+	}
+}
+
+func TestSMCPInfoFamilyGeneratorDegradesToEmptyLabelsWithNeitherField(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	gen := smcpInfoFamilyGenerator()
+	family := gen.GenerateFunc(obj)
+
+	if len(family.Metrics) != 1 {
+		t.Fatalf("Metrics = %v, want exactly one series even with neither field set", family.Metrics)
+	}
+	got := map[string]string{}
+	for i, key := range family.Metrics[0].LabelKeys {
+		got[key] = family.Metrics[0].LabelValues[i]
+	}
+	if got["version"] != "" || got["profile"] != "" {
+		t.Fatalf("version/profile = %q/%q, want both empty", got["version"], got["profile"])
+	}
+}
+
+func TestSMCPComponentReadyFamilyGeneratorIsRegisteredForBothVersions(t *testing.T) {
+	for _, version := range []string{"v1", "v2"} {
+		gvk := schema.GroupVersionKind{Group: smcpGroup, Version: version, Kind: "ServiceMeshControlPlane"}
+		if _, ok := defaultFamilyGenerators.familyGeneratorsFor(gvk); !ok {
+			t.Fatalf("no family generator registered for %s", gvk.String())
+		}
+	}
+}
+
+func TestSMCPComponentReadyFamilyGeneratorEmitsOneSeriesPerComponent(t *testing.T) {
+	gen := smcpComponentReadyFamilyGenerator()
+	family := gen.GenerateFunc(smcpV2Readiness())
+
+	got := map[string]float64{}
+	for _, metric := range family.Metrics {
+		if len(metric.LabelKeys) != 3 || metric.LabelKeys[2] != "component" {
+			t.Fatalf("LabelKeys = %v, want [namespace name component]", metric.LabelKeys)
+		}
+		got[metric.LabelValues[2]] = metric.Value
+	}
+	want := map[string]float64{"discovery": 1, "gateways": 1, "proxy": 0, "addons": 0}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for component, value := range want {
+		if got[component] != value {
+			t.Fatalf("got[%q] = %v, want %v", component, got[component], value)
+		}
+	}
+}
+
+func TestSMCPComponentReadyFamilyGeneratorDegradesToNoSamplesForV1Status(t *testing.T) {
+	gen := smcpComponentReadyFamilyGenerator()
+	family := gen.GenerateFunc(smcpV1ComponentStatus())
+
+	if len(family.Metrics) != 0 {
+		t.Fatalf("Metrics = %v, want none for a v1 object with no status.readiness field", family.Metrics)
+	}
+}
+
+func TestSMCPComponentReadyFamilyGeneratorProducesNoSamplesWithoutReadiness(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	gen := smcpComponentReadyFamilyGenerator()
+	family := gen.GenerateFunc(obj)
+
+	if len(family.Metrics) != 0 {
+		t.Fatalf("Metrics = %v, want none for an object with no status field at all", family.Metrics)
+	}
+}
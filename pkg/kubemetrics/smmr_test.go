@@ -0,0 +1,92 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// smmrPartiallyReconciled builds a ServiceMeshMemberRoll whose spec.members
+// lists three namespaces but whose status.configuredMembers has only caught
+// up to one of them, the shape a ServiceMeshMemberRoll has while the control
+// plane is still rolling a newly added namespace's sidecar injection out.
+func smmrPartiallyReconciled() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "default", "namespace": "istio-system"},
+		"spec": map[string]interface{}{
+			"members": []interface{}{"apps", "billing", "checkout"},
+		},
+		"status": map[string]interface{}{
+			"configuredMembers": []interface{}{"apps"},
+		},
+	}}
+}
+
+func TestSMMRMembersConfiguredFamilyGeneratorCountsSpecMembers(t *testing.T) {
+	gen := smmrMembersConfiguredFamilyGenerator()
+	family := gen.GenerateFunc(smmrPartiallyReconciled())
+
+	if len(family.Metrics) != 1 || family.Metrics[0].Value != 3 {
+		t.Fatalf("Metrics = %v, want a single series valued 3", family.Metrics)
+	}
+}
+
+func TestSMMRMembersActiveFamilyGeneratorCountsConfiguredMembers(t *testing.T) {
+	gen := smmrMembersActiveFamilyGenerator()
+	family := gen.GenerateFunc(smmrPartiallyReconciled())
+
+	if len(family.Metrics) != 1 || family.Metrics[0].Value != 1 {
+		t.Fatalf("Metrics = %v, want a single series valued 1, behind spec.members during a partial reconcile", family.Metrics)
+	}
+}
+
+func TestSMMRMembersActiveFamilyGeneratorFallsBackToStatusMembers(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "default", "namespace": "istio-system"},
+		"spec":     map[string]interface{}{"members": []interface{}{"apps", "billing"}},
+		"status":   map[string]interface{}{"members": []interface{}{"apps", "billing"}},
+	}}
+	gen := smmrMembersActiveFamilyGenerator()
+	family := gen.GenerateFunc(obj)
+
+	if len(family.Metrics) != 1 || family.Metrics[0].Value != 2 {
+		t.Fatalf("Metrics = %v, want a single series valued 2 from status.members", family.Metrics)
+	}
+}
+
+func TestSMMRMembersFamilyGeneratorsCountMissingArraysAsZero(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "default", "namespace": "istio-system"},
+	}}
+
+	configured := smmrMembersConfiguredFamilyGenerator().GenerateFunc(obj)
+	if len(configured.Metrics) != 1 || configured.Metrics[0].Value != 0 {
+		t.Fatalf("members_configured Metrics = %v, want a single series valued 0", configured.Metrics)
+	}
+
+	active := smmrMembersActiveFamilyGenerator().GenerateFunc(obj)
+	if len(active.Metrics) != 1 || active.Metrics[0].Value != 0 {
+		t.Fatalf("members_active Metrics = %v, want a single series valued 0", active.Metrics)
+	}
+}
+
+func TestSMMRMembersFamilyGeneratorsAreRegistered(t *testing.T) {
+	gens, ok := defaultFamilyGenerators.familyGeneratorsFor(smmrGVK)
+	if !ok || len(gens) != 2 {
+		t.Fatalf("family generators for %s = %v, want exactly two registered", smmrGVK.String(), gens)
+	}
+}
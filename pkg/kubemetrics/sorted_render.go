@@ -0,0 +1,117 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// renderedFamily accumulates the lines renderAll wrote for a single metric
+// family so sortRenderedFamilies can re-emit it in a deterministic position.
+// help and typ are kept separately from samples, rather than as part of the
+// same slice, so a family written by more than one store — each of which
+// repeats its own "# HELP"/"# TYPE" pair before its samples, per
+// MetricsStore.WriteAll's convention — collapses back down to one HELP/TYPE
+// pair instead of duplicating it once per store.
+type renderedFamily struct {
+	help    string
+	typ     string
+	samples []string
+}
+
+// sortRenderedFamilies rewrites buf, which holds the Prometheus text
+// exposition format output renderAll just produced, into a byte-stable
+// layout: families ordered by metric name, and each family's sample lines
+// ordered by their full text (metric name plus label values, since that's
+// everything before the value field) rather than whatever order the
+// underlying stores' map iteration happened to produce them in. Without
+// this, two scrapes of an unchanged cluster can come back with families and
+// samples in a different order each time, which is indistinguishable from an
+// actual change to diff-based conformance tooling.
+func sortRenderedFamilies(buf *bytes.Buffer) {
+	if buf.Len() == 0 {
+		return
+	}
+
+	families := make(map[string]*renderedFamily)
+	var names []string
+	familyOf := func(name string) *renderedFamily {
+		f, ok := families[name]
+		if !ok {
+			f = &renderedFamily{}
+			families[name] = f
+			names = append(names, name)
+		}
+		return f
+	}
+
+	var current *renderedFamily
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# HELP "):
+			current = familyOf(metricNameFromDirective(line, "# HELP "))
+			current.help = line
+		case strings.HasPrefix(line, "# TYPE "):
+			current = familyOf(metricNameFromDirective(line, "# TYPE "))
+			current.typ = line
+		case strings.HasPrefix(line, "#"):
+			// Any other comment line isn't tied to a family; drop it here.
+			// ServeHTTP appends its own "# EOF" for OpenMetrics after the
+			// sort runs, so there's nothing meaningful to preserve.
+		default:
+			if current == nil {
+				// A sample line ahead of any HELP/TYPE directive would mean
+				// a store wrote malformed output; keep it rather than
+				// silently dropping it, grouped under the empty family name
+				// so it still sorts (and renders) first.
+				current = familyOf("")
+			}
+			current.samples = append(current.samples, line)
+		}
+	}
+
+	sort.Strings(names)
+
+	buf.Reset()
+	for _, name := range names {
+		f := families[name]
+		if f.help != "" {
+			buf.WriteString(f.help)
+			buf.WriteByte('\n')
+		}
+		if f.typ != "" {
+			buf.WriteString(f.typ)
+			buf.WriteByte('\n')
+		}
+		sort.Strings(f.samples)
+		for _, s := range f.samples {
+			buf.WriteString(s)
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// metricNameFromDirective extracts the metric name from a "# HELP <name>
+// ..." or "# TYPE <name> ..." line, given that line's directive prefix.
+func metricNameFromDirective(line, prefix string) string {
+	rest := strings.TrimPrefix(line, prefix)
+	if idx := strings.IndexByte(rest, ' '); idx != -1 {
+		return rest[:idx]
+	}
+	return rest
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestSortRenderedFamiliesOrdersFamiliesByName(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# HELP zebra_info Z.\n# TYPE zebra_info gauge\nzebra_info{name=\"z\"} 1\n")
+	buf.WriteString("# HELP apple_info A.\n# TYPE apple_info gauge\napple_info{name=\"a\"} 1\n")
+
+	sortRenderedFamilies(&buf)
+
+	const want = "# HELP apple_info A.\n# TYPE apple_info gauge\napple_info{name=\"a\"} 1\n" +
+		"# HELP zebra_info Z.\n# TYPE zebra_info gauge\nzebra_info{name=\"z\"} 1\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("sortRenderedFamilies() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSortRenderedFamiliesOrdersSamplesByLabelValues(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# HELP widget_info W.\n# TYPE widget_info gauge\n")
+	buf.WriteString("widget_info{name=\"c\"} 1\n")
+	buf.WriteString("widget_info{name=\"a\"} 1\n")
+	buf.WriteString("widget_info{name=\"b\"} 1\n")
+
+	sortRenderedFamilies(&buf)
+
+	const want = "# HELP widget_info W.\n# TYPE widget_info gauge\n" +
+		"widget_info{name=\"a\"} 1\nwidget_info{name=\"b\"} 1\nwidget_info{name=\"c\"} 1\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("sortRenderedFamilies() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSortRenderedFamiliesMergesRepeatedHeadersFromMultipleStores(t *testing.T) {
+	var buf bytes.Buffer
+	// Two "chunks" for the same family, as written by two separate
+	// managedStores in renderAll's loop, each repeating HELP/TYPE before
+	// its own samples.
+	buf.WriteString("# HELP widget_info W.\n# TYPE widget_info gauge\nwidget_info{name=\"b\"} 1\n")
+	buf.WriteString("# HELP widget_info W.\n# TYPE widget_info gauge\nwidget_info{name=\"a\"} 1\n")
+
+	sortRenderedFamilies(&buf)
+
+	const want = "# HELP widget_info W.\n# TYPE widget_info gauge\n" +
+		"widget_info{name=\"a\"} 1\nwidget_info{name=\"b\"} 1\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("sortRenderedFamilies() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSortRenderedFamiliesIsDeterministicAcrossInputOrder(t *testing.T) {
+	first := renderNShuffled(10, 1)
+	second := renderNShuffled(10, 2)
+
+	sortRenderedFamilies(&first)
+	sortRenderedFamilies(&second)
+
+	if first.String() != second.String() {
+		t.Fatalf("sortRenderedFamilies() output differs between two input orderings of the same data:\n%s\nvs\n%s", first.String(), second.String())
+	}
+}
+
+// renderNShuffled builds synthetic exposition text for n series across two
+// families, with the families and each family's samples visited in an order
+// that depends on seed, mimicking how two scrapes of the same underlying
+// data can come back from renderAll in different orders depending on map
+// iteration.
+func renderNShuffled(n int, seed int) bytes.Buffer {
+	var buf bytes.Buffer
+	families := []string{"widget_info", "gadget_info"}
+	if seed%2 == 0 {
+		families[0], families[1] = families[1], families[0]
+	}
+	for _, name := range families {
+		buf.WriteString(fmt.Sprintf("# HELP %s H.\n# TYPE %s gauge\n", name, name))
+		for i := 0; i < n; i++ {
+			idx := i
+			if seed%3 == 0 {
+				idx = n - 1 - i
+			}
+			buf.WriteString(fmt.Sprintf("%s{name=\"item-%04d\"} 1\n", name, idx))
+		}
+	}
+	return buf
+}
+
+// BenchmarkSortRenderedFamilies guards against sorting measurably regressing
+// render time at a scrape-sized, 10k-series workload.
+func BenchmarkSortRenderedFamilies(b *testing.B) {
+	const families = 10
+	const seriesPerFamily = 1000 // 10 families * 1000 series = 10k series.
+
+	var template bytes.Buffer
+	for f := 0; f < families; f++ {
+		name := fmt.Sprintf("bench_family_%d_info", f)
+		template.WriteString(fmt.Sprintf("# HELP %s H.\n# TYPE %s gauge\n", name, name))
+		for i := 0; i < seriesPerFamily; i++ {
+			template.WriteString(fmt.Sprintf("%s{name=\"item-%05d\",namespace=\"ns-%d\"} 1\n", name, i, i%20))
+		}
+	}
+	rendered := template.Bytes()
+
+	b.ResetTimer()
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		buf.Write(rendered)
+		sortRenderedFamilies(&buf)
+	}
+}
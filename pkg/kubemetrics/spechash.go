@@ -0,0 +1,40 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// SpecHash returns the first 8 hex characters of the sha256 digest of spec's
+// canonical JSON encoding, for use as a stable identifier of a CR's desired
+// state that's unaffected by map key ordering: encoding/json already
+// serializes map[string]interface{} keys in sorted order, so two maps built
+// by different codepaths but holding the same keys and values always
+// encode — and so hash — identically. Returns "" if spec can't be marshaled
+// to JSON, which shouldn't happen for a spec decoded from JSON or YAML in
+// the first place. Exported so a custom family generator (registered via
+// RegisterFamilyGenerator) can compute the same label without duplicating
+// this logic.
+func SpecHash(spec interface{}) string {
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])[:8]
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import "testing"
+
+func TestSpecHashIsStableAcrossMapKeyOrdering(t *testing.T) {
+	a := map[string]interface{}{"profile": "default", "components": []interface{}{"istiod", "ingressgateway"}}
+	b := map[string]interface{}{"components": []interface{}{"istiod", "ingressgateway"}, "profile": "default"}
+
+	if SpecHash(a) != SpecHash(b) {
+		t.Fatalf("SpecHash(a) = %q, SpecHash(b) = %q, want them equal for semantically identical specs built in different key orders", SpecHash(a), SpecHash(b))
+	}
+}
+
+func TestSpecHashChangesWithAChangedField(t *testing.T) {
+	a := map[string]interface{}{"profile": "default"}
+	b := map[string]interface{}{"profile": "minimal"}
+
+	if SpecHash(a) == SpecHash(b) {
+		t.Fatalf("SpecHash(a) = SpecHash(b) = %q, want different hashes for differing specs", SpecHash(a))
+	}
+}
+
+func TestSpecHashIsEightHexCharacters(t *testing.T) {
+	got := SpecHash(map[string]interface{}{"profile": "default"})
+	if len(got) != 8 {
+		t.Fatalf("len(SpecHash()) = %d, want 8", len(got))
+	}
+	for _, r := range got {
+		if !((r >= '0' && r <= '9') || (r >= 'a' && r <= 'f')) {
+			t.Fatalf("SpecHash() = %q, want only lowercase hex characters", got)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// statusPhaseUnknownTotal counts every time a StatusPhaseSpec's Path
+// resolved to a value outside its configured KnownPhases, so an operator
+// notices their KnownPhases list has drifted from what the controller
+// actually writes even if they aren't watching for the extra series
+// statusPhaseFamilyGenerator emits for it.
+var statusPhaseUnknownTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "cr_status_phase_unknown_total",
+	Help: "Number of times a GVKOptions.StatusPhaseMetric Path resolved to a phase not listed in KnownPhases.",
+})
+
+func init() {
+	prometheus.MustRegister(statusPhaseUnknownTotal)
+}
+
+// StatusPhaseSpec configures GVKOptions.StatusPhaseMetric.
+type StatusPhaseSpec struct {
+	// Path is the field the current phase is read from, e.g. "status.phase"
+	// or "status.status". Defaults to "status.phase" when empty.
+	Path string
+	// KnownPhases is the full set of phases statusPhaseFamilyGenerator
+	// emits a series for on every object, valued 1 for whichever phase Path
+	// currently resolves to and 0 for the rest. A phase encountered at
+	// Path that isn't listed here still gets its own series valued 1 — see
+	// statusPhaseFamilyGenerator — rather than being dropped, so an
+	// unanticipated phase is visible instead of silently missing from the
+	// metric.
+	KnownPhases []string
+}
+
+// statusPhaseFamilyGenerator builds the "<kind>_status_phase" gauge
+// buildStoresForGVK adds when GVKOptions.StatusPhaseMetric is set: one
+// series per spec.KnownPhases entry, valued 1 for the phase Path currently
+// resolves to and 0 for the rest, so PromQL's max_over_time works across a
+// phase transition without the series disappearing and reappearing. An
+// object whose current phase isn't in KnownPhases still gets a series for
+// that phase, valued 1, and increments statusPhaseUnknownTotal, rather than
+// reporting no current phase at all.
+func statusPhaseFamilyGenerator(kind string, spec StatusPhaseSpec) ksmetric.FamilyGenerator {
+	kindName := sanitizeKindForMetricName(strings.ToLower(kind))
+	path := spec.Path
+	if path == "" {
+		path = "status.phase"
+	}
+	known := make(map[string]bool, len(spec.KnownPhases))
+	for _, phase := range spec.KnownPhases {
+		known[phase] = true
+	}
+
+	return ksmetric.FamilyGenerator{
+		Name: kindName + "_status_phase",
+		Type: ksmetric.Gauge,
+		Help: fmt.Sprintf("Current %s phase, read from %s. One series per known phase, valued 1 for the current one.", kind, path),
+		GenerateFunc: func(obj interface{}) *ksmetric.Family {
+			crd := obj.(*unstructured.Unstructured)
+			value, ok, err := resolvePath(crd.Object, path)
+			if err != nil {
+				log.Error(err, "Failed to resolve status phase path", "path", path, "kind", kind)
+				return &ksmetric.Family{}
+			}
+			current := ""
+			if ok {
+				current = fmt.Sprintf("%v", value)
+			}
+
+			nameValues := crdNameLabelValues(crd)
+			namespace, name := nameValues[0], nameValues[1]
+			metrics := make([]*ksmetric.Metric, 0, len(spec.KnownPhases)+1)
+			for _, phase := range spec.KnownPhases {
+				v := 0.0
+				if phase == current {
+					v = 1.0
+				}
+				metrics = append(metrics, &ksmetric.Metric{
+					Value:       v,
+					LabelKeys:   []string{"namespace", "name", "phase"},
+					LabelValues: []string{namespace, name, phase},
+				})
+			}
+			if current != "" && !known[current] {
+				statusPhaseUnknownTotal.Inc()
+				metrics = append(metrics, &ksmetric.Metric{
+					Value:       1,
+					LabelKeys:   []string{"namespace", "name", "phase"},
+					LabelValues: []string{namespace, name, current},
+				})
+			}
+			return &ksmetric.Family{Metrics: metrics}
+		},
+	}
+}
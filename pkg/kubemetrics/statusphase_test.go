@@ -0,0 +1,119 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func statusPhaseObj(phase string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "basic", "namespace": "istio-system"},
+	}}
+	if phase != "" {
+		obj.Object["status"] = map[string]interface{}{"phase": phase}
+	}
+	return obj
+}
+
+func TestStatusPhaseFamilyGeneratorEmitsOneSeriesPerKnownPhase(t *testing.T) {
+	gen := statusPhaseFamilyGenerator("IstioOperator", StatusPhaseSpec{KnownPhases: []string{"Installing", "Ready", "Failed"}})
+	if gen.Name != "istiooperator_status_phase" {
+		t.Fatalf("gen.Name = %q, want istiooperator_status_phase", gen.Name)
+	}
+
+	family := gen.GenerateFunc(statusPhaseObj("Ready"))
+	if len(family.Metrics) != 3 {
+		t.Fatalf("GenerateFunc() = %+v, want one series per known phase", family.Metrics)
+	}
+	for _, m := range family.Metrics {
+		phase := m.LabelValues[2]
+		want := 0.0
+		if phase == "Ready" {
+			want = 1.0
+		}
+		if m.Value != want {
+			t.Fatalf("Metrics[phase=%s].Value = %v, want %v", phase, m.Value, want)
+		}
+	}
+}
+
+func TestStatusPhaseFamilyGeneratorUsesConfiguredPath(t *testing.T) {
+	gen := statusPhaseFamilyGenerator("VMRegistration", StatusPhaseSpec{Path: "status.state", KnownPhases: []string{"Pending", "Registered"}})
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "vm-1", "namespace": "vm-namespace"},
+		"status":   map[string]interface{}{"state": "Registered"},
+	}}
+	family := gen.GenerateFunc(obj)
+	for _, m := range family.Metrics {
+		if m.LabelValues[2] == "Registered" && m.Value != 1 {
+			t.Fatalf("Metrics[phase=Registered].Value = %v, want 1", m.Value)
+		}
+	}
+}
+
+func TestStatusPhaseFamilyGeneratorEmitsExtraSeriesForUnknownPhase(t *testing.T) {
+	gen := statusPhaseFamilyGenerator("IstioOperator", StatusPhaseSpec{KnownPhases: []string{"Installing", "Ready"}})
+	before := statusPhaseUnknownTotalValue(t)
+
+	family := gen.GenerateFunc(statusPhaseObj("Degraded"))
+	if len(family.Metrics) != 3 {
+		t.Fatalf("GenerateFunc() = %+v, want 2 known-phase series plus 1 for the unexpected phase", family.Metrics)
+	}
+
+	found := false
+	for _, m := range family.Metrics {
+		if m.LabelValues[2] == "Degraded" {
+			found = true
+			if m.Value != 1 {
+				t.Fatalf("Metrics[phase=Degraded].Value = %v, want 1", m.Value)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("GenerateFunc() = %+v, want a series for the unexpected phase Degraded", family.Metrics)
+	}
+
+	if after := statusPhaseUnknownTotalValue(t); after != before+1 {
+		t.Fatalf("statusPhaseUnknownTotal = %v, want %v", after, before+1)
+	}
+}
+
+func TestStatusPhaseFamilyGeneratorNoPhaseSetLeavesAllZero(t *testing.T) {
+	gen := statusPhaseFamilyGenerator("IstioOperator", StatusPhaseSpec{KnownPhases: []string{"Installing", "Ready"}})
+
+	family := gen.GenerateFunc(statusPhaseObj(""))
+	if len(family.Metrics) != 2 {
+		t.Fatalf("GenerateFunc() = %+v, want no extra series when no phase is set", family.Metrics)
+	}
+	for _, m := range family.Metrics {
+		if m.Value != 0 {
+			t.Fatalf("Metrics[phase=%s].Value = %v, want 0", m.LabelValues[2], m.Value)
+		}
+	}
+}
+
+func statusPhaseUnknownTotalValue(t *testing.T) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := statusPhaseUnknownTotal.Write(&m); err != nil {
+		t.Fatalf("statusPhaseUnknownTotal.Write() = %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
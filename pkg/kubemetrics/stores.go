@@ -0,0 +1,354 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+	metricsstore "k8s.io/kube-state-metrics/pkg/metrics_store"
+)
+
+// getAPIResourceLists returns every APIResourceList the apiserver advertises.
+// A partial discovery failure (e.g. a broken aggregated API service) is
+// tolerated as long as the discovery client returns a partial result
+// alongside the error, since that's still useful for resolving the GVKs this
+// package cares about.
+func getAPIResourceLists(cfg *rest.Config) ([]*metav1.APIResourceList, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	_, lists, err := dc.ServerGroupsAndResources()
+	if err != nil {
+		if _, partial := err.(*discovery.ErrGroupDiscoveryFailed); partial && lists != nil {
+			log.V(1).Info("Ignoring partial API discovery failure", "error", err.Error())
+			return lists, nil
+		}
+		return nil, err
+	}
+	return lists, nil
+}
+
+// getAPIResourceListsWithRetry wraps getAPIResourceLists with an
+// exponential-backoff retry loop, so a temporarily unavailable API server
+// (e.g. during a node restart or apiserver upgrade) doesn't crash the
+// operator on startup. It gives up and returns the most recent error once
+// opts.Timeout has elapsed.
+func getAPIResourceListsWithRetry(cfg *rest.Config, opts RetryOptions) ([]*metav1.APIResourceList, error) {
+	interval := opts.InitialInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	maxInterval := opts.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = 30 * time.Second
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	deadline := time.Now().Add(timeout)
+
+	for attempt := 1; ; attempt++ {
+		lists, err := getAPIResourceLists(cfg)
+		if err == nil {
+			return lists, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		log.V(1).Info("Retrying API resource discovery after error", "attempt", attempt, "error", err.Error(), "nextRetry", interval.String())
+		time.Sleep(interval)
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// apiResourceNameForGVK looks up the plural resource name (e.g. "widgets"
+// for kind "Widget") backing gvk, as advertised by the apiserver.
+func apiResourceNameForGVK(gvk schema.GroupVersionKind, apiResourceLists []*metav1.APIResourceList) (string, error) {
+	for _, resourceList := range apiResourceLists {
+		if resourceList.GroupVersion != gvk.GroupVersion().String() {
+			continue
+		}
+		for _, apiResource := range resourceList.APIResources {
+			if apiResource.Kind == gvk.Kind {
+				return apiResource.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unable to find resource name for type: %s in server", gvk.String())
+}
+
+// dynamicClientForGVK returns a dynamic client scoped to gvk's resource.
+func dynamicClientForGVK(cfg *rest.Config, apiResourceLists []*metav1.APIResourceList,
+	gvk schema.GroupVersionKind) (dynamic.NamespaceableResourceInterface, error) {
+	resourceName, err := apiResourceNameForGVK(gvk, apiResourceLists)
+	if err != nil {
+		return nil, err
+	}
+	dclient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	gvr := gvk.GroupVersion().WithResource(resourceName)
+	return dclient.Resource(gvr), nil
+}
+
+// newNamespacedMetricsStores returns one MetricsStore per namespace in ns,
+// each kept in sync with the cluster by its own reflector. maxResourcesPerGVK
+// caps the number of kind objects each of those stores reflects; see
+// MetricOptions. countMetric enables the aggregated "<kind>_count" gauge;
+// see GVKOptions.CountMetric.
+// updatesTracker, if non-nil, is the TransitionCounterTracker backing this
+// GVK's "<kind>_updates_total" family (see GVKOptions.UpdatesCounterMetric);
+// it's threaded through to newReflectedMetricsStore so each store's
+// reflector evicts a deleted object's tracked state via trackerEvictingStore.
+// sizeTracker is the same, for the SizeTracker backing "<kind>_size_bytes";
+// see GVKOptions.SizeMetric. tombstoneGracePeriod is forwarded to
+// newReflectedMetricsStore; see GVKOptions.TombstoneGracePeriod.
+func newNamespacedMetricsStores(dclient dynamic.NamespaceableResourceInterface, ns []string,
+	familyGenerators []ksmetric.FamilyGenerator, kind string, maxResourcesPerGVK int, countMetric bool, updatesTracker *TransitionCounterTracker, sizeTracker *SizeTracker, tombstoneGracePeriod time.Duration) []*managedStore {
+	stores := make([]*managedStore, 0, len(ns))
+	for _, namespace := range ns {
+		stores = append(stores, newReflectedMetricsStore(dclient, namespace, familyGenerators, kind, maxResourcesPerGVK, true, countMetric, updatesTracker, sizeTracker, tombstoneGracePeriod))
+	}
+	return stores
+}
+
+// newClusterScopedMetricsStores returns a single MetricsStore covering every
+// object of the resource cluster-wide. maxResourcesPerGVK caps the number of
+// kind objects it reflects; see MetricOptions. countMetric enables the
+// aggregated "<kind>_count" gauge; see GVKOptions.CountMetric. updatesTracker
+// and sizeTracker are forwarded to newReflectedMetricsStore, as is
+// tombstoneGracePeriod; see newNamespacedMetricsStores.
+func newClusterScopedMetricsStores(dclient dynamic.NamespaceableResourceInterface,
+	familyGenerators []ksmetric.FamilyGenerator, kind string, maxResourcesPerGVK int, countMetric bool, updatesTracker *TransitionCounterTracker, sizeTracker *SizeTracker, tombstoneGracePeriod time.Duration) []*managedStore {
+	return []*managedStore{newReflectedMetricsStore(dclient, "", familyGenerators, kind, maxResourcesPerGVK, false, countMetric, updatesTracker, sizeTracker, tombstoneGracePeriod)}
+}
+
+// newReflectedMetricsStore builds a MetricsStore for familyGenerators and
+// starts a cache.Reflector that keeps it in sync with the cluster via
+// list/watch against dclient, scoped to namespace ("" for cluster-wide). The
+// returned managedStore's stop closes the reflector's stop channel, so
+// storeRegistry.set/remove can tear down the list/watch instead of leaking
+// it when a reload or rediscovery replaces this store.
+//
+// Because the reflector drives the store's Add/Update/Delete directly off
+// its watch (falling back to a full Replace only on an initial sync or a
+// forced relist), resources created or deleted on the cluster already show
+// up on the very next scrape, the same latency a dedicated
+// cache.SharedInformer would give; see
+// TestNewClusterScopedMetricsStoresWatchesEvents. StartMetricStoreRefresher
+// is the poll-based alternative, for stores built outside this package.
+//
+// If maxResourcesPerGVK is positive, every full list (the reflector's
+// initial sync and its periodic relists) is truncated to that many items,
+// with the overflow count tracked so storeRegistry.ServeHTTP can report it
+// as a "<kind>_truncated" gauge; see writeTruncatedMetric. Watch-driven adds
+// between relists aren't capped, since the scenario this guards against —
+// thousands of instances of one CRD kind blowing up scrape size — is caught
+// by the next relist regardless.
+//
+// The returned managedStore's synced flips to true once its first List call
+// completes, so storeRegistry.allSynced (and so
+// CRMetricsManager.StoresSynced/Ready) doesn't report ready until the store
+// actually has data to serve.
+//
+// namespaced records whether the resource is namespace-scoped, and
+// countMetric enables the aggregated "<kind>_count" gauge storeRegistry.renderAll
+// writes from this store's contents, grouped by namespace for a namespaced
+// resource or as a single unlabeled series otherwise; see
+// GVKOptions.CountMetric and writeCountMetric.
+//
+// updatesTracker and sizeTracker, if non-nil, are wrapped around store
+// before it's handed to the reflector, via trackerEvictingStore, so a
+// Delete the reflector applies also forgets that object's UID from each of
+// them; see GVKOptions.UpdatesCounterMetric and GVKOptions.SizeMetric.
+// tombstoneGracePeriod, if positive, wraps that same cache.Store (or store
+// itself, if neither tracker applies) in a tombstoneEvictingStore instead,
+// deferring the real removal trackerEvictingStore's forgetting depends on
+// until the tombstone's grace period elapses; see GVKOptions.TombstoneGracePeriod.
+func newReflectedMetricsStore(dclient dynamic.NamespaceableResourceInterface, namespace string,
+	familyGenerators []ksmetric.FamilyGenerator, kind string, maxResourcesPerGVK int, namespaced bool, countMetric bool, updatesTracker *TransitionCounterTracker, sizeTracker *SizeTracker, tombstoneGracePeriod time.Duration) *managedStore {
+	store := metricsstore.NewMetricsStore(
+		ksmetric.ExtractMetricFamilyHeaders(familyGenerators),
+		ksmetric.ComposeMetricGenFuncs(familyGenerators),
+	)
+
+	var truncated int64
+	var synced int32
+	resourceClient := dclient
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			var list *unstructured.UnstructuredList
+			var err error
+			if namespace == "" {
+				list, err = resourceClient.List(context.TODO(), opts)
+			} else {
+				list, err = resourceClient.Namespace(namespace).List(context.TODO(), opts)
+			}
+			if err != nil {
+				return nil, err
+			}
+			truncateList(list, maxResourcesPerGVK, kind, &truncated)
+			atomic.StoreInt32(&synced, 1)
+			return list, nil
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			if namespace == "" {
+				return resourceClient.Watch(context.TODO(), opts)
+			}
+			return resourceClient.Namespace(namespace).Watch(context.TODO(), opts)
+		},
+	}
+
+	var forgetters []uidForgetter
+	if updatesTracker != nil {
+		forgetters = append(forgetters, updatesTracker)
+	}
+	if sizeTracker != nil {
+		forgetters = append(forgetters, sizeTracker)
+	}
+	var reflectorStore cache.Store = store
+	if len(forgetters) > 0 {
+		reflectorStore = &trackerEvictingStore{Store: store, trackers: forgetters}
+	}
+	var tombstoneStore *tombstoneEvictingStore
+	if tombstoneGracePeriod > 0 {
+		tombstoneStore = newTombstoneEvictingStore(reflectorStore, tombstoneGracePeriod)
+		reflectorStore = tombstoneStore
+	}
+	reflector := cache.NewReflector(lw, &unstructured.Unstructured{}, reflectorStore, 0)
+	stopCh := make(chan struct{})
+	go reflector.Run(stopCh)
+	if tombstoneStore != nil {
+		go tombstoneStore.sweepLoop(stopCh)
+	}
+	return &managedStore{
+		MetricsStore: store,
+		stop:         func() { close(stopCh) },
+		truncated:    &truncated,
+		kind:         kind,
+		synced:       &synced,
+		namespaced:   namespaced,
+		namespace:    namespace,
+		countMetric:  countMetric,
+	}
+}
+
+// truncateList enforces max on list in place (max <= 0 means unlimited),
+// recording the current overflow count in truncated so
+// storeRegistry.ServeHTTP can report it via writeTruncatedMetric, and
+// logging a warning whenever the count changes rather than on every relist.
+// Objects are kept oldest-first by metadata.creationTimestamp rather than in
+// whatever order the apiserver happened to return them, so which objects
+// survive the cutoff stays stable across relists instead of flapping with
+// list-order jitter.
+func truncateList(list *unstructured.UnstructuredList, max int, kind string, truncated *int64) {
+	if max <= 0 || len(list.Items) <= max {
+		atomic.StoreInt64(truncated, 0)
+		return
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].GetCreationTimestamp().Time.Before(list.Items[j].GetCreationTimestamp().Time)
+	})
+	dropped := int64(len(list.Items) - max)
+	list.Items = list.Items[:max]
+	if atomic.SwapInt64(truncated, dropped) != dropped {
+		log.Info("Dropping excess custom resources to stay under MaxResourcesPerGVK",
+			"kind", kind, "max", max, "dropped", dropped)
+	}
+}
+
+// writeTruncatedMetric writes a single "<kind>_truncated" gauge reporting
+// how many kind instances were dropped by truncateList, in the same
+// exposition format MetricsStore.WriteAll uses, so it shows up alongside the
+// "<kind>_info" series generateMetricFamilies produces.
+func writeTruncatedMetric(w io.Writer, kind string, dropped int64) {
+	kindName := sanitizeKindForMetricName(strings.ToLower(kind))
+	fmt.Fprintf(w, "# HELP %s_truncated Number of %s custom resources dropped from this endpoint because MaxResourcesPerGVK was exceeded.\n", kindName, kind)
+	fmt.Fprintf(w, "# TYPE %s_truncated gauge\n", kindName)
+	fmt.Fprintf(w, "%s_truncated %d\n", kindName, dropped)
+}
+
+// writeCountMetric writes the "<kind>_count" gauge storeRegistry.renderAll
+// appends for a store with countMetric enabled (see GVKOptions.CountMetric),
+// computed fresh from objs — a store's current List() — on every render
+// rather than maintained incrementally, so it's always exactly as correct
+// as the store's own contents; a store's List() already reflects every Add
+// and Delete the reflector has applied since the last scrape.
+//
+// For a cluster-scoped kind it's a single unlabeled series counting objs.
+// For a namespaced kind, objs is grouped by each object's own namespace
+// rather than trusting namespace (the store's configured namespace, which
+// is "" both for a cluster-scoped resource and for a namespaced one backed
+// by GetNamespacesForMetrics' all-namespaces sentinel): when namespace is
+// set, objs already only contains that namespace's objects, so the grouping
+// degenerates to one entry; when it's the all-namespaces sentinel, grouping
+// is the only way to label each namespace's own count. A namespace with no
+// objects left doesn't get an explicit 0 series — the same "absence means
+// zero" convention every other family in this package follows for a kind
+// with no instances at all.
+func writeCountMetric(w io.Writer, kind string, namespaced bool, namespace string, objs []interface{}) {
+	kindName := sanitizeKindForMetricName(strings.ToLower(kind))
+	metricName := kindName + "_count"
+	fmt.Fprintf(w, "# HELP %s Number of %s custom resources.\n", metricName, kind)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", metricName)
+
+	if !namespaced {
+		fmt.Fprintf(w, "%s %d\n", metricName, len(objs))
+		return
+	}
+	if namespace != "" {
+		fmt.Fprintf(w, "%s{namespace=%q} %d\n", metricName, namespace, len(objs))
+		return
+	}
+
+	counts := map[string]int{}
+	for _, obj := range objs {
+		crd, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		counts[crd.GetNamespace()]++
+	}
+	namespaces := make([]string, 0, len(counts))
+	for ns := range counts {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		fmt.Fprintf(w, "%s{namespace=%q} %d\n", metricName, ns, counts[ns])
+	}
+}
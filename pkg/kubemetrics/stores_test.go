@@ -0,0 +1,190 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+)
+
+func TestGetAPIResourceListsWithRetryGivesUpAfterTimeout(t *testing.T) {
+	// A loopback port nothing is listening on, so every discovery attempt
+	// fails immediately instead of hanging on a connect timeout.
+	cfg := &rest.Config{Host: "http://127.0.0.1:0"}
+
+	start := time.Now()
+	_, err := getAPIResourceListsWithRetry(cfg, RetryOptions{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     5 * time.Millisecond,
+		Timeout:         50 * time.Millisecond,
+	})
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("getAPIResourceListsWithRetry() = nil error, want one once the timeout elapses")
+	}
+	if elapsed > 2*time.Second {
+		t.Fatalf("getAPIResourceListsWithRetry() took %s, want it to give up close to the 50ms timeout", elapsed)
+	}
+}
+
+func TestTruncateList(t *testing.T) {
+	newList := func(n int) *unstructured.UnstructuredList {
+		list := &unstructured.UnstructuredList{}
+		for i := 0; i < n; i++ {
+			list.Items = append(list.Items, unstructured.Unstructured{})
+		}
+		return list
+	}
+
+	tests := []struct {
+		name          string
+		items         int
+		max           int
+		wantRemaining int
+		wantTruncated int64
+	}{
+		{name: "unlimited when max is zero", items: 10, max: 0, wantRemaining: 10, wantTruncated: 0},
+		{name: "under the cap", items: 3, max: 5, wantRemaining: 3, wantTruncated: 0},
+		{name: "exactly at the cap", items: 5, max: 5, wantRemaining: 5, wantTruncated: 0},
+		{name: "over the cap", items: 12, max: 5, wantRemaining: 5, wantTruncated: 7},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			list := newList(tc.items)
+			var truncated int64
+			truncateList(list, tc.max, "Widget", &truncated)
+
+			if len(list.Items) != tc.wantRemaining {
+				t.Errorf("len(list.Items) = %d, want %d", len(list.Items), tc.wantRemaining)
+			}
+			if truncated != tc.wantTruncated {
+				t.Errorf("truncated = %d, want %d", truncated, tc.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestTruncateListKeepsOldestObjectsDeterministically(t *testing.T) {
+	now := time.Now()
+	newObj := func(name string, age time.Duration) unstructured.Unstructured {
+		u := unstructured.Unstructured{}
+		u.SetName(name)
+		u.SetCreationTimestamp(metav1.NewTime(now.Add(-age)))
+		return u
+	}
+	list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+		newObj("newest", time.Minute),
+		newObj("oldest", time.Hour),
+		newObj("middle", 30*time.Minute),
+	}}
+
+	var truncated int64
+	truncateList(list, 2, "Widget", &truncated)
+
+	if truncated != 1 {
+		t.Fatalf("truncated = %d, want 1", truncated)
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	if len(names) != 2 || names[0] != "oldest" || names[1] != "middle" {
+		t.Fatalf("remaining names = %v, want [oldest middle]", names)
+	}
+}
+
+func TestWriteTruncatedMetric(t *testing.T) {
+	var buf strings.Builder
+	writeTruncatedMetric(&buf, "Widget", 7)
+
+	got := buf.String()
+	for _, want := range []string{"widget_truncated", "7"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("writeTruncatedMetric() output = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestWriteCountMetric(t *testing.T) {
+	newCR := func(namespace string) interface{} {
+		u := &unstructured.Unstructured{}
+		u.SetNamespace(namespace)
+		return u
+	}
+
+	tests := []struct {
+		name       string
+		namespaced bool
+		namespace  string
+		objs       []interface{}
+		want       []string
+		dontWant   []string
+	}{
+		{
+			name:       "cluster-scoped is a single unlabeled series",
+			namespaced: false,
+			objs:       []interface{}{newCR(""), newCR("")},
+			want:       []string{"widget_count 2"},
+			dontWant:   []string{"namespace="},
+		},
+		{
+			name:       "namespaced store built for one namespace",
+			namespaced: true,
+			namespace:  "istio-system",
+			objs:       []interface{}{newCR("istio-system")},
+			want:       []string{`widget_count{namespace="istio-system"} 1`},
+		},
+		{
+			name:       "namespaced store backed by the all-namespaces sentinel groups by object",
+			namespaced: true,
+			namespace:  "",
+			objs:       []interface{}{newCR("a"), newCR("a"), newCR("b")},
+			want:       []string{`widget_count{namespace="a"} 2`, `widget_count{namespace="b"} 1`},
+		},
+		{
+			name:       "zero objects omits the series entirely for a namespaced kind",
+			namespaced: true,
+			namespace:  "",
+			objs:       nil,
+			dontWant:   []string{"widget_count{"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			writeCountMetric(&buf, "Widget", tc.namespaced, tc.namespace, tc.objs)
+			got := buf.String()
+
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("writeCountMetric() output = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, dontWant := range tc.dontWant {
+				if strings.Contains(got, dontWant) {
+					t.Errorf("writeCountMetric() output = %q, want it to not contain %q", got, dontWant)
+				}
+			}
+		})
+	}
+}
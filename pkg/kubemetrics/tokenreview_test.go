@@ -0,0 +1,135 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeAuthClient returns a fake clientset whose TokenReviews authenticate
+// only validToken (as validUser) and whose SubjectAccessReviews allow only
+// allowedUser, mimicking an apiserver backing RBAC for the configured
+// NonResourceURL.
+func fakeAuthClient(validToken, validUser, allowedUser string) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		out := review.DeepCopy()
+		if review.Spec.Token == validToken {
+			out.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: validUser},
+			}
+		}
+		return true, out, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		sar := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		out := sar.DeepCopy()
+		out.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: sar.Spec.User == allowedUser}
+		return true, out, nil
+	})
+	return client
+}
+
+func TestTokenReviewAuthMiddleware(t *testing.T) {
+	client := fakeAuthClient("good-token", "alice", "alice")
+	var rejections int64
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := tokenReviewAuthMiddleware(next, client, TokenReviewAuthOptions{}, &rejections)
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"malformed header", "good-token", http.StatusUnauthorized},
+		{"bad token", "Bearer wrong-token", http.StatusUnauthorized},
+		{"good token", "Bearer good-token", http.StatusOK},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+
+	if rejections != 3 {
+		t.Errorf("rejections = %d, want 3", rejections)
+	}
+}
+
+func TestTokenReviewAuthMiddlewareForbidsUnauthorizedUser(t *testing.T) {
+	client := fakeAuthClient("good-token", "bob", "alice")
+	var rejections int64
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := tokenReviewAuthMiddleware(next, client, TokenReviewAuthOptions{}, &rejections)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer good-token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rejections != 1 {
+		t.Errorf("rejections = %d, want 1", rejections)
+	}
+}
+
+func TestTokenReviewAuthMiddlewareCachesResult(t *testing.T) {
+	client := fakeAuthClient("good-token", "alice", "alice")
+	var reviewCount int
+	client.PrependReactor("create", "tokenreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		reviewCount++
+		review := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		out := review.DeepCopy()
+		out.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "alice"}}
+		return true, out, nil
+	})
+
+	var rejections int64
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := tokenReviewAuthMiddleware(next, client, TokenReviewAuthOptions{CacheTTL: 0}, &rejections)
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Authorization", "Bearer good-token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i, rec.Code)
+		}
+	}
+	if reviewCount != 1 {
+		t.Errorf("TokenReview was issued %d times, want 1 (subsequent requests should hit the cache)", reviewCount)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// tombstoneAnnotation marks the deep copy tombstoneEvictingStore re-adds in
+// place of a deleted object, so generateMetricFamilies' "<kind>_info"
+// GenerateFunc can tell a tombstoned object from a live one and report it
+// with value 0 instead of 1. It's only ever set on that local copy, never on
+// the real cluster object, so it can't collide with an annotation a CR
+// author sets; see GVKOptions.TombstoneGracePeriod.
+const tombstoneAnnotation = "kubemetrics.internal/tombstoned"
+
+// isTombstoned reports whether crd is a tombstoneEvictingStore placeholder
+// for a deleted object still within its grace period, rather than a live
+// object.
+func isTombstoned(crd *unstructured.Unstructured) bool {
+	return crd.GetAnnotations()[tombstoneAnnotation] == "true"
+}
+
+// tombstoneEvictingStore wraps a cache.Store, intercepting Delete: instead
+// of forwarding it, a tombstoned deep copy of the deleted object is added in
+// its place, and the real removal is deferred to sweep, once grace has
+// elapsed. This is what lets "<kind>_info" report a deleted object at value
+// 0 for a while instead of its series vanishing on the very next scrape, at
+// the cost of retaining one deep copy per recently-deleted object until its
+// grace period elapses. Every other cache.Store method (Add/Update/List/...)
+// is forwarded unchanged, the same way trackerEvictingStore only overrides
+// Delete.
+type tombstoneEvictingStore struct {
+	cache.Store
+	grace time.Duration
+
+	mu      sync.Mutex
+	expires map[types.UID]tombstoneEntry
+}
+
+type tombstoneEntry struct {
+	obj       interface{}
+	expiresAt time.Time
+}
+
+func newTombstoneEvictingStore(inner cache.Store, grace time.Duration) *tombstoneEvictingStore {
+	return &tombstoneEvictingStore{
+		Store:   inner,
+		grace:   grace,
+		expires: make(map[types.UID]tombstoneEntry),
+	}
+}
+
+func (s *tombstoneEvictingStore) Delete(obj interface{}) error {
+	crd, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return s.Store.Delete(obj)
+	}
+
+	tombstoned := crd.DeepCopy()
+	annotations := tombstoned.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[tombstoneAnnotation] = "true"
+	tombstoned.SetAnnotations(annotations)
+
+	s.mu.Lock()
+	s.expires[crd.GetUID()] = tombstoneEntry{obj: obj, expiresAt: time.Now().Add(s.grace)}
+	s.mu.Unlock()
+
+	return s.Store.Add(tombstoned)
+}
+
+// sweepLoop periodically calls sweep until stopCh is closed. The interval is
+// a tenth of grace, clamped to [1s, 1m], so a short grace period is swept
+// promptly without a long one polling needlessly often.
+func (s *tombstoneEvictingStore) sweepLoop(stopCh <-chan struct{}) {
+	interval := s.grace / 10
+	if interval < time.Second {
+		interval = time.Second
+	} else if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			s.sweep(time.Now())
+		}
+	}
+}
+
+// sweep deletes, for real this time, every tombstoned object whose grace
+// period is over as of now. Split out from sweepLoop so tests can drive it
+// against a fixed time instead of waiting on a ticker.
+func (s *tombstoneEvictingStore) sweep(now time.Time) {
+	s.mu.Lock()
+	var expired []interface{}
+	for uid, entry := range s.expires {
+		if !now.Before(entry.expiresAt) {
+			expired = append(expired, entry.obj)
+			delete(s.expires, uid)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, obj := range expired {
+		_ = s.Store.Delete(obj)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeAddDeleteStore is a minimal cache.Store recording its Add and Delete
+// calls, for asserting tombstoneEvictingStore defers the real Delete and
+// re-adds a tombstoned copy in its place.
+type fakeAddDeleteStore struct {
+	fakeCacheStore
+	added []interface{}
+}
+
+func (s *fakeAddDeleteStore) Add(obj interface{}) error {
+	s.added = append(s.added, obj)
+	return nil
+}
+
+func virtualServiceObj(uid, name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"uid": uid, "name": name, "namespace": "istio-system"},
+	}}
+}
+
+func TestTombstoneEvictingStoreDefersDeleteAndTagsCopy(t *testing.T) {
+	inner := &fakeAddDeleteStore{}
+	store := newTombstoneEvictingStore(inner, time.Minute)
+
+	if err := store.Delete(virtualServiceObj("uid-1", "basic")); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+
+	if len(inner.deleted) != 0 {
+		t.Fatalf("inner.deleted = %v, want Delete deferred rather than forwarded immediately", inner.deleted)
+	}
+	if len(inner.added) != 1 {
+		t.Fatalf("inner.added = %v, want a tombstoned copy re-added in place of the deleted object", inner.added)
+	}
+	tombstoned, ok := inner.added[0].(*unstructured.Unstructured)
+	if !ok || !isTombstoned(tombstoned) {
+		t.Fatalf("inner.added[0] = %+v, want a tombstoned copy", inner.added[0])
+	}
+	if tombstoned.GetName() != "basic" || tombstoned.GetNamespace() != "istio-system" {
+		t.Fatalf("tombstoned copy = %+v, want the same name/namespace as the deleted object", tombstoned)
+	}
+}
+
+func TestTombstoneEvictingStoreSweepDeletesOnlyExpiredEntries(t *testing.T) {
+	inner := &fakeAddDeleteStore{}
+	store := newTombstoneEvictingStore(inner, time.Minute)
+	start := time.Now()
+
+	if err := store.Delete(virtualServiceObj("uid-1", "basic")); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+
+	store.sweep(start.Add(30 * time.Second))
+	if len(inner.deleted) != 0 {
+		t.Fatalf("inner.deleted = %v after a sweep before grace elapsed, want none", inner.deleted)
+	}
+
+	store.sweep(start.Add(time.Hour))
+	if len(inner.deleted) != 1 {
+		t.Fatalf("inner.deleted = %v after a sweep once grace elapsed, want the tombstoned object removed", inner.deleted)
+	}
+}
+
+func TestGenerateMetricFamiliesInfoValueTransitionsOneZeroAbsentAcrossTombstoning(t *testing.T) {
+	generators := generateMetricFamilies("VirtualService", nil, "", "", false, false, false, false, false, false, "", "", NameLabelModeKind, true, "")
+	infoGen := generators[0]
+
+	live := virtualServiceObj("uid-1", "basic")
+	if v := infoGen.GenerateFunc(live).Metrics[0].Value; v != 1 {
+		t.Fatalf("live object's info value = %v, want 1", v)
+	}
+
+	inner := &fakeAddDeleteStore{}
+	store := newTombstoneEvictingStore(inner, time.Minute)
+	if err := store.Delete(live); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	tombstoned := inner.added[0].(*unstructured.Unstructured)
+	if v := infoGen.GenerateFunc(tombstoned).Metrics[0].Value; v != 0 {
+		t.Fatalf("tombstoned object's info value = %v, want 0 during its grace period", v)
+	}
+
+	store.sweep(time.Now().Add(time.Hour))
+	if len(inner.deleted) != 1 {
+		t.Fatalf("inner.deleted = %v after grace elapsed, want the object actually removed (series now absent from any store)", inner.deleted)
+	}
+}
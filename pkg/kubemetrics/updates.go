@@ -0,0 +1,77 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// updatesTotalFamilyGenerator builds the "<kind>_updates_total" counter
+// buildStoresForGVK adds when GVKOptions.UpdatesCounterMetric is set: how
+// many times an object's metadata.resourceVersion has changed since tracker
+// started watching it, so a CR being hot-looped by some controller (its
+// resourceVersion climbing far faster than an operator would expect) can be
+// singled out from a fleet of otherwise-idle ones. It's
+// transitionCounterFamilyGenerator with path fixed to
+// "metadata.resourceVersion"; periodic relists that find resourceVersion
+// unchanged don't advance the count, since transitionCounterFamilyGenerator
+// only counts an actual change of value. tracker must be the same
+// TransitionCounterTracker passed to trackerEvictingStore for this GVK's
+// stores, so a deleted object's entry is reclaimed instead of outliving it.
+func updatesTotalFamilyGenerator(kind string, tracker *TransitionCounterTracker) ksmetric.FamilyGenerator {
+	kindName := sanitizeKindForMetricName(strings.ToLower(kind))
+	name := kindName + "_updates_total"
+	help := fmt.Sprintf("Number of times metadata.resourceVersion has changed for a %s since this process started watching it.", kind)
+	return transitionCounterFamilyGenerator(kind, name, help, "metadata.resourceVersion", tracker)
+}
+
+// uidForgetter is implemented by per-object state trackers — currently
+// TransitionCounterTracker and SizeTracker — that cache state keyed by UID
+// and need to reclaim a deleted object's entry instead of leaking it
+// forever.
+type uidForgetter interface {
+	Forget(uid types.UID)
+}
+
+// trackerEvictingStore wraps a cache.Store, forwarding every method to it
+// unchanged except Delete, which also forgets the deleted object's UID from
+// every tracker in trackers first. This is what makes
+// updatesTotalFamilyGenerator's counter and objectSizeFamilyGenerator's
+// cache drop an object once it's deleted instead of leaking its entry
+// forever the way their trackers otherwise would: the reflector that drives
+// a managedStore's MetricsStore calls Delete directly on whatever
+// cache.Store it's handed, so a trackerEvictingStore substituted in
+// newReflectedMetricsStore sees every Delete the family generators
+// themselves never do.
+type trackerEvictingStore struct {
+	cache.Store
+	trackers []uidForgetter
+}
+
+func (s *trackerEvictingStore) Delete(obj interface{}) error {
+	if crd, ok := obj.(*unstructured.Unstructured); ok {
+		for _, tracker := range s.trackers {
+			tracker.Forget(crd.GetUID())
+		}
+	}
+	return s.Store.Delete(obj)
+}
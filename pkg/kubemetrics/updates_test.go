@@ -0,0 +1,166 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubemetrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+
+	ksmetric "k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func resourceVersionObj(uid, name, resourceVersion string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"uid": uid, "name": name, "namespace": "istio-system", "resourceVersion": resourceVersion},
+	}}
+}
+
+func TestUpdatesTotalFamilyGeneratorCountsResourceVersionChangesNotRelists(t *testing.T) {
+	tracker := NewTransitionCounterTracker()
+	gen := updatesTotalFamilyGenerator("IstioOperator", tracker)
+
+	if gen.Type != ksmetric.Counter {
+		t.Fatalf("gen.Type = %v, want ksmetric.Counter", gen.Type)
+	}
+	if gen.Name != "istiooperator_updates_total" {
+		t.Fatalf("gen.Name = %q, want %q", gen.Name, "istiooperator_updates_total")
+	}
+
+	relists := []string{"100", "100", "101", "101", "105"}
+	var lastCount float64
+	for _, rv := range relists {
+		family := gen.GenerateFunc(resourceVersionObj("uid-1", "example", rv))
+		if family.Metrics[0].Value < lastCount {
+			t.Fatalf("Metrics[0].Value = %v, want it to never decrease from %v", family.Metrics[0].Value, lastCount)
+		}
+		lastCount = family.Metrics[0].Value
+	}
+	if lastCount != 2 {
+		t.Fatalf("final count = %v, want 2 resourceVersion changes (100->101, 101->105), unaffected by the repeated relists", lastCount)
+	}
+}
+
+// fakeCacheStore is a minimal cache.Store recording its Delete calls, for
+// asserting trackerEvictingStore forwards Delete rather than swallowing it.
+type fakeCacheStore struct {
+	cache.Store
+	deleted []interface{}
+}
+
+func (s *fakeCacheStore) Delete(obj interface{}) error {
+	s.deleted = append(s.deleted, obj)
+	return nil
+}
+
+func TestTrackerEvictingStoreForgetsTrackedUIDAndForwardsDelete(t *testing.T) {
+	tracker := NewTransitionCounterTracker()
+	tracker.Observe("uid-1", "100")
+	tracker.Observe("uid-1", "101")
+
+	inner := &fakeCacheStore{}
+	store := &trackerEvictingStore{Store: inner, trackers: []uidForgetter{tracker}}
+
+	obj := resourceVersionObj("uid-1", "example", "101")
+	if err := store.Delete(obj); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+
+	if len(inner.deleted) != 1 {
+		t.Fatalf("inner.deleted = %v, want the Delete forwarded to the wrapped store", inner.deleted)
+	}
+	if got := tracker.Observe("uid-1", "100"); got != 0 {
+		t.Fatalf("Observe() after Delete() = %v, want 0, as if uid-1 had never been seen", got)
+	}
+}
+
+// TestNewClusterScopedMetricsStoresUpdatesCounterMetric exercises the same
+// watch-driven store TestNewClusterScopedMetricsStoresWatchesEvents does,
+// but with an updatesTotalFamilyGenerator family and its tracker wired in:
+// an update that changes resourceVersion advances the counter, and deleting
+// the object drops its series entirely rather than leaving a stale count
+// behind.
+func TestNewClusterScopedMetricsStoresUpdatesCounterMetric(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "MeshConfig"}
+	gvr := schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: "meshconfigs"}
+
+	scheme := runtime.NewScheme()
+	listKinds := map[schema.GroupVersionResource]string{gvr: "MeshConfigList"}
+	dclient := fake.NewSimpleDynamicClientWithCustomListKinds(scheme, listKinds)
+
+	tracker := NewTransitionCounterTracker()
+	familyGenerators := []ksmetric.FamilyGenerator{updatesTotalFamilyGenerator(gvk.Kind, tracker)}
+	stores := newClusterScopedMetricsStores(dclient.Resource(gvr), familyGenerators, gvk.Kind, 0, false, tracker, nil, 0)
+	defer stores[0].stop()
+
+	waitUntil := func(want string) string {
+		t.Helper()
+		var out string
+		for i := 0; i < 50; i++ {
+			var buf strings.Builder
+			stores[0].WriteAll(&buf)
+			out = buf.String()
+			if strings.Contains(out, want) {
+				return out
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("exposition output = %q, want it to contain %q", out, want)
+		return out
+	}
+
+	ctx := context.Background()
+	obj := newUnstructuredCR(gvk, "default")
+	obj.SetResourceVersion("1")
+	if _, err := dclient.Resource(gvr).Create(ctx, obj, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	waitUntil("meshconfig_updates_total{")
+
+	obj.SetResourceVersion("2")
+	if _, err := dclient.Resource(gvr).Update(ctx, obj, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	out := waitUntil(" 1\n")
+	if !strings.Contains(out, "meshconfig_updates_total{") {
+		t.Fatalf("exposition output = %q, want a meshconfig_updates_total series", out)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "meshconfig_updates_total{") && !strings.HasSuffix(line, " 1") {
+			t.Fatalf("meshconfig_updates_total line = %q, want it to end in \" 1\" after a single resourceVersion change", line)
+		}
+	}
+
+	if err := dclient.Resource(gvr).Delete(ctx, "default", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		var buf strings.Builder
+		stores[0].WriteAll(&buf)
+		if !strings.Contains(buf.String(), "meshconfig_updates_total{") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("meshconfig_updates_total series still present 500ms after delete, want it dropped")
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package log wraps log/slog with the conventions this operator's call
+// sites share, replacing direct use of
+// sigs.k8s.io/controller-runtime/pkg/log (logf.Log.WithName) for packages
+// that have migrated to it: every record carries a "component" field naming
+// the package that logged it, Error always carries the original error
+// under "err", and the output format (text or JSON) is switched once, with
+// SetFormat, rather than each package constructing its own handler.
+// "namespace", "name" and "gvk" aren't enforced by this package; they're
+// this operator's own convention for which keys a call site should use when
+// logging about a namespaced object or a GVK, the same way "err" is for an
+// error.
+package log
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+)
+
+// handlerBox lets handlerState hold a slog.Handler in an atomic.Value:
+// atomic.Value panics if consecutive Store calls use different concrete
+// types, which storing a bare slog.Handler directly would trip the moment
+// SetFormat switches from a *slog.TextHandler to a *slog.JSONHandler or
+// back. Boxing it in a struct keeps the stored concrete type fixed.
+type handlerBox struct {
+	handler slog.Handler
+}
+
+// handlerState backs every Logger returned by New. It defaults to a text
+// handler writing to os.Stderr, matching slog's own default, and is swapped
+// by SetFormat — typically called once at startup from a --log-format flag
+// — so a Logger constructed at package-init time (the usual
+// `var log = log.New("istiooperator")` pattern) still honors whatever
+// format SetFormat is later called with, rather than freezing in whatever
+// was default when New ran.
+var handlerState atomic.Value
+
+func init() {
+	handlerState.Store(handlerBox{slog.NewTextHandler(os.Stderr, nil)})
+}
+
+func currentHandler() slog.Handler {
+	return handlerState.Load().(handlerBox).handler
+}
+
+// SetFormat switches every Logger's output between "text" (slog's own
+// key=value format, and this package's default) and "json", writing to w.
+// Typically called once at startup from a --log-format flag; this tree has
+// no cmd/main.go to attach that flag to, the same situation
+// istiooperator.DisableDiffEvents documents for its own flag, but a binary
+// that does should do roughly:
+//
+//	format := flag.String("log-format", "text", `log output format, "text" or "json"`)
+//	flag.Parse()
+//	if err := log.SetFormat(*format, os.Stderr); err != nil { ... }
+//
+// Returns an error naming format rather than defaulting to "text" if it's
+// neither, so a typo'd flag value is caught at startup instead of silently
+// logging in the wrong format.
+func SetFormat(format string, w io.Writer) error {
+	switch format {
+	case "", "text":
+		handlerState.Store(handlerBox{slog.NewTextHandler(w, nil)})
+	case "json":
+		handlerState.Store(handlerBox{slog.NewJSONHandler(w, nil)})
+	default:
+		return fmt.Errorf(`log format %q must be "text" or "json"`, format)
+	}
+	return nil
+}
+
+// Logger is a structured logger for one component (e.g. "istiooperator",
+// "webhook"), the same granularity logf.Log.WithName gave each package
+// before this package replaced it. Info, Debug and Error accept alternating
+// key-value pairs the way slog.Logger does.
+type Logger struct {
+	component string
+}
+
+// New returns a Logger for component. Called once per package, typically as
+// a package-level `var log = log.New("<package>")`, the direct replacement
+// for `logf.Log.WithName("<package>")`.
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// slog returns a *slog.Logger bound to l's component and the handler
+// currently installed by SetFormat, resolved fresh on every call rather
+// than once in New so a SetFormat call after New still takes effect.
+func (l *Logger) slog() *slog.Logger {
+	return slog.New(currentHandler()).With("component", l.component)
+}
+
+// Info logs msg at Info level with args as alternating key-value pairs.
+func (l *Logger) Info(msg string, args ...any) {
+	l.slog().Info(msg, args...)
+}
+
+// Debug logs msg at Debug level with args as alternating key-value pairs.
+func (l *Logger) Debug(msg string, args ...any) {
+	l.slog().Debug(msg, args...)
+}
+
+// Error logs msg at Error level, always including err under the "err" key
+// ahead of args, so every error-path log line in this operator carries the
+// original error consistently rather than each call site picking its own
+// key name for it.
+func (l *Logger) Error(err error, msg string, args ...any) {
+	l.slog().Error(msg, append([]any{"err", err}, args...)...)
+}
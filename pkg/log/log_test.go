@@ -0,0 +1,82 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestLoggerInfoIncludesComponentAndFields(t *testing.T) {
+	var buf bytes.Buffer
+	if err := SetFormat("json", &buf); err != nil {
+		t.Fatalf("SetFormat() = %v", err)
+	}
+	defer func() { _ = SetFormat("text", &buf) }()
+
+	l := New("istiooperator")
+	l.Info("reconciliation paused", "namespace", "istio-system", "name", "default")
+
+	record := decodeRecord(t, buf.Bytes())
+	if record["component"] != "istiooperator" {
+		t.Fatalf("record[component] = %v, want %q", record["component"], "istiooperator")
+	}
+	if record["namespace"] != "istio-system" {
+		t.Fatalf("record[namespace] = %v, want %q", record["namespace"], "istio-system")
+	}
+	if record["name"] != "default" {
+		t.Fatalf("record[name] = %v, want %q", record["name"], "default")
+	}
+	if record["msg"] != "reconciliation paused" {
+		t.Fatalf("record[msg] = %v, want %q", record["msg"], "reconciliation paused")
+	}
+}
+
+func TestLoggerErrorIncludesOriginalErrorUnderErrKey(t *testing.T) {
+	var buf bytes.Buffer
+	if err := SetFormat("json", &buf); err != nil {
+		t.Fatalf("SetFormat() = %v", err)
+	}
+	defer func() { _ = SetFormat("text", &buf) }()
+
+	l := New("webhook")
+	l.Error(errors.New("boom"), "defaulting failed", "gvk", "networking.istio.io/v1alpha3, Kind=Gateway")
+
+	record := decodeRecord(t, buf.Bytes())
+	if record["err"] != "boom" {
+		t.Fatalf("record[err] = %v, want %q", record["err"], "boom")
+	}
+	if record["gvk"] != "networking.istio.io/v1alpha3, Kind=Gateway" {
+		t.Fatalf("record[gvk] = %v, want the GVK string", record["gvk"])
+	}
+}
+
+func TestSetFormatRejectsUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := SetFormat("yaml", &buf); err == nil {
+		t.Fatal("SetFormat(\"yaml\", ...) = nil, want an error for an unsupported format")
+	}
+}
+
+func decodeRecord(t *testing.T, line []byte) map[string]interface{} {
+	t.Helper()
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(line, &record); err != nil {
+		t.Fatalf("json.Unmarshal(%q) = %v", line, err)
+	}
+	return record
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package migrate upgrades a serialized IstioOperatorSpec from one CRD
+// schema version to another, so a user stranded on an old version by a CRD
+// bump has a path forward instead of hand-editing YAML.
+package migrate
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// MigrationWarning describes a non-fatal issue a MigrationFunc ran into
+// translating a field from one version to the next — e.g. a renamed field
+// whose old and new names were both already present, or a field that
+// couldn't be reinterpreted and was dropped — that's worth surfacing to the
+// user without failing the migration outright.
+type MigrationWarning struct {
+	// FromVersion is the version the migration step producing this warning
+	// migrated from.
+	FromVersion string
+	// Field names the field the warning concerns.
+	Field string
+	// Message describes what happened.
+	Message string
+}
+
+// MigrationFunc transforms a spec one version step forward, returning the
+// transformed spec plus any MigrationWarnings encountered along the way.
+// spec is decoded YAML/JSON, so its nested maps and slices come back as
+// map[string]interface{}, []interface{}, and scalar types rather than any
+// generated Go struct.
+type MigrationFunc func(map[string]interface{}) (map[string]interface{}, []MigrationWarning)
+
+// versionOrder lists every schema version MigrateSpec knows how to migrate
+// between, oldest first. A version absent from this list is rejected by
+// MigrateSpec before it looks for a registered step at all.
+var versionOrder = []string{"v1alpha1", "v1beta1"}
+
+// steps maps a version to the MigrationFunc that migrates a spec from it to
+// the next version in versionOrder, registered via RegisterMigration.
+var steps = map[string]MigrationFunc{}
+
+// RegisterMigration registers fn as the migration step from fromVersion to
+// the version immediately after it in versionOrder. init functions in this
+// package's per-version-step files (e.g. v1alpha1_v1beta1.go) call this to
+// add themselves to the chain MigrateSpec walks.
+func RegisterMigration(fromVersion string, fn MigrationFunc) {
+	steps[fromVersion] = fn
+}
+
+// MigrateSpec parses old as a v<fromVersion> IstioOperatorSpec and applies
+// the chain of registered MigrationFuncs needed to reach toVersion, one
+// version step at a time, returning the migrated spec re-marshaled as YAML
+// alongside every MigrationWarning the chain produced along the way.
+// fromVersion and toVersion must both appear in versionOrder, and toVersion
+// may not precede fromVersion — MigrateSpec only migrates forward.
+func MigrateSpec(old []byte, fromVersion, toVersion string) ([]byte, []MigrationWarning, error) {
+	fromIndex, err := versionIndex(fromVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	toIndex, err := versionIndex(toVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	if toIndex < fromIndex {
+		return nil, nil, fmt.Errorf("cannot migrate backward from %q to %q", fromVersion, toVersion)
+	}
+
+	spec := map[string]interface{}{}
+	if err := yaml.Unmarshal(old, &spec); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s spec: %w", fromVersion, err)
+	}
+
+	var warnings []MigrationWarning
+	for i := fromIndex; i < toIndex; i++ {
+		step, ok := steps[versionOrder[i]]
+		if !ok {
+			return nil, nil, fmt.Errorf("no migration registered from %q to %q", versionOrder[i], versionOrder[i+1])
+		}
+		var stepWarnings []MigrationWarning
+		spec, stepWarnings = step(spec)
+		warnings = append(warnings, stepWarnings...)
+	}
+
+	out, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshaling %s spec: %w", toVersion, err)
+	}
+	return out, warnings, nil
+}
+
+// versionIndex returns version's position in versionOrder, or an error if
+// it isn't one MigrateSpec knows about.
+func versionIndex(version string) (int, error) {
+	for i, v := range versionOrder {
+		if v == version {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown schema version %q", version)
+}
+
+// renameField moves spec[from] to spec[to] in place, leaving spec
+// unchanged (aside from the rename) and returning a MigrationWarning
+// instead of overwriting if spec already has an entry at to. A spec with
+// no entry at from is left alone, since an omitted field has nothing to
+// rename.
+func renameField(fromVersion string, spec map[string]interface{}, from, to string) []MigrationWarning {
+	value, ok := spec[from]
+	if !ok {
+		return nil
+	}
+	if _, collision := spec[to]; collision {
+		return []MigrationWarning{{
+			FromVersion: fromVersion,
+			Field:       from,
+			Message:     fmt.Sprintf("both %q and its renamed form %q are set; keeping %q and dropping %q", from, to, to, from),
+		}}
+	}
+	spec[to] = value
+	delete(spec, from)
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestMigrateSpecRenamesV1Alpha1FieldsToV1Beta1(t *testing.T) {
+	old := []byte(`
+profile: default
+componentResources:
+  istiod:
+    requests:
+      cpu: 100m
+`)
+	migrated, warnings, err := MigrateSpec(old, "v1alpha1", "v1beta1")
+	if err != nil {
+		t.Fatalf("MigrateSpec() = %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("warnings = %+v, want none for a clean rename", warnings)
+	}
+
+	var spec map[string]interface{}
+	if err := yaml.Unmarshal(migrated, &spec); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if _, ok := spec["componentResources"]; ok {
+		t.Fatal("migrated spec still has componentResources, want it renamed to resources")
+	}
+	if _, ok := spec["resources"]; !ok {
+		t.Fatal("migrated spec has no resources field, want componentResources renamed to it")
+	}
+	if spec["profile"] != "default" {
+		t.Fatalf("profile = %v, want it carried over unchanged", spec["profile"])
+	}
+}
+
+func TestMigrateSpecWarnsOnRenameCollision(t *testing.T) {
+	old := []byte(`
+componentResources:
+  istiod:
+    requests:
+      cpu: 100m
+resources:
+  istiod:
+    requests:
+      cpu: 200m
+`)
+	migrated, warnings, err := MigrateSpec(old, "v1alpha1", "v1beta1")
+	if err != nil {
+		t.Fatalf("MigrateSpec() = %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, "componentResources") {
+		t.Fatalf("warnings = %+v, want one warning naming the collision", warnings)
+	}
+
+	var spec map[string]interface{}
+	if err := yaml.Unmarshal(migrated, &spec); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if _, ok := spec["componentResources"]; ok {
+		t.Fatal("migrated spec still has componentResources, want it dropped after the collision")
+	}
+}
+
+func TestMigrateSpecRejectsUnknownVersions(t *testing.T) {
+	if _, _, err := MigrateSpec([]byte(`{}`), "v1alpha1", "v2"); err == nil {
+		t.Fatal("MigrateSpec() = nil, want an error for an unknown toVersion")
+	}
+	if _, _, err := MigrateSpec([]byte(`{}`), "v2", "v1beta1"); err == nil {
+		t.Fatal("MigrateSpec() = nil, want an error for an unknown fromVersion")
+	}
+}
+
+func TestMigrateSpecRejectsBackwardMigration(t *testing.T) {
+	if _, _, err := MigrateSpec([]byte(`{}`), "v1beta1", "v1alpha1"); err == nil {
+		t.Fatal("MigrateSpec() = nil, want an error migrating backward")
+	}
+}
+
+func TestMigrateSpecIsANoOpForTheSameVersion(t *testing.T) {
+	old := []byte(`profile: default`)
+	migrated, warnings, err := MigrateSpec(old, "v1alpha1", "v1alpha1")
+	if err != nil {
+		t.Fatalf("MigrateSpec() = %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("warnings = %+v, want none", warnings)
+	}
+	var spec map[string]interface{}
+	if err := yaml.Unmarshal(migrated, &spec); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if spec["profile"] != "default" {
+		t.Fatalf("profile = %v, want it unchanged", spec["profile"])
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+// v1alpha1ToV1Beta1FieldRenames lists every top-level IstioOperatorSpec
+// field v1beta1 renamed from v1alpha1, oldest name first. Each entry is a
+// pure key rename — the value itself is carried over unchanged — which
+// covers every v1alpha1->v1beta1 rename currently documented; a future
+// rename that also needs to reshape its value should get its own
+// MigrationFunc rather than being folded into this table.
+var v1alpha1ToV1Beta1FieldRenames = [][2]string{
+	{"componentResources", "resources"},
+	{"componentPodDisruptionBudgets", "podDisruptionBudgets"},
+	{"componentHPASpecs", "autoscaling"},
+	{"componentTopologySpreadConstraints", "topologySpreadConstraints"},
+}
+
+func init() {
+	RegisterMigration("v1alpha1", migrateV1Alpha1ToV1Beta1)
+}
+
+// migrateV1Alpha1ToV1Beta1 applies every rename in
+// v1alpha1ToV1Beta1FieldRenames in turn, collecting a MigrationWarning for
+// any rename that would collide with a field already present under its new
+// name.
+func migrateV1Alpha1ToV1Beta1(spec map[string]interface{}) (map[string]interface{}, []MigrationWarning) {
+	var warnings []MigrationWarning
+	for _, rename := range v1alpha1ToV1Beta1FieldRenames {
+		warnings = append(warnings, renameField("v1alpha1", spec, rename[0], rename[1])...)
+	}
+	return spec, warnings
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package olm generates the pieces of an Operator Lifecycle Manager
+// ClusterServiceVersion that can be derived from resources this operator
+// already deploys, rather than hand-maintained as a separate copy that
+// drifts from them. This tree has no vendored operator-framework/api
+// ClusterServiceVersion type and no deploy/ directory of RBAC or Deployment
+// manifests to read, so GenerateCSVSpec takes the operator's Deployment and
+// RBAC objects as already-parsed Go values — it's the caller's job (e.g.
+// hack/generate-bundle.sh, once this operator has real manifests checked in)
+// to load those from wherever they live and pass them in. The CSV spec
+// fields below are the minimal subset GenerateCSVSpec populates, not the
+// full ClusterServiceVersion schema.
+package olm
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// InstallModeType names one of the namespace-scoping modes OLM lets a
+// ClusterServiceVersion declare support for.
+type InstallModeType string
+
+const (
+	InstallModeTypeOwnNamespace    InstallModeType = "OwnNamespace"
+	InstallModeTypeSingleNamespace InstallModeType = "SingleNamespace"
+	InstallModeTypeMultiNamespace  InstallModeType = "MultiNamespace"
+	InstallModeTypeAllNamespaces   InstallModeType = "AllNamespaces"
+)
+
+// InstallMode is one entry of a ClusterServiceVersion's spec.installModes.
+type InstallMode struct {
+	Type      InstallModeType `json:"type"`
+	Supported bool            `json:"supported"`
+}
+
+// StrategyDeploymentSpec is one entry of spec.install.spec.deployments: a
+// named Deployment the "deployment" install strategy creates when the CSV
+// is installed.
+type StrategyDeploymentSpec struct {
+	Name string                `json:"name"`
+	Spec appsv1.DeploymentSpec `json:"spec"`
+}
+
+// StrategyDeploymentPermission is one entry of spec.install.spec.permissions
+// or spec.install.spec.clusterPermissions: the rules a ServiceAccount needs,
+// namespace-scoped or cluster-scoped respectively.
+type StrategyDeploymentPermission struct {
+	ServiceAccountName string              `json:"serviceAccountName"`
+	Rules              []rbacv1.PolicyRule `json:"rules"`
+}
+
+// InstallStrategy is a ClusterServiceVersion's spec.install: OLM only
+// supports the "deployment" strategy today, so StrategyName is always
+// "deployment".
+type InstallStrategy struct {
+	StrategyName string              `json:"strategy"`
+	Spec         InstallStrategySpec `json:"spec"`
+}
+
+// InstallStrategySpec is spec.install.spec.
+type InstallStrategySpec struct {
+	Deployments        []StrategyDeploymentSpec       `json:"deployments"`
+	Permissions        []StrategyDeploymentPermission `json:"permissions,omitempty"`
+	ClusterPermissions []StrategyDeploymentPermission `json:"clusterPermissions,omitempty"`
+}
+
+// ClusterServiceVersionSpec is the subset of a ClusterServiceVersion's spec
+// GenerateCSVSpec populates.
+type ClusterServiceVersionSpec struct {
+	Install      InstallStrategy `json:"install"`
+	InstallModes []InstallMode   `json:"installModes"`
+}
+
+// GenerateCSVSpec builds spec.install and spec.installModes for a
+// ClusterServiceVersion from the operator's own Deployment and RBAC
+// objects, so the bundle's CSV can't drift from what the operator actually
+// deploys. clusterRoles and roles are each turned into one
+// StrategyDeploymentPermission per ServiceAccount named in
+// serviceAccountName (OLM's "deployment" install strategy only supports one
+// ServiceAccount per Deployment); roles become spec.install.spec.permissions
+// and clusterRoles become spec.install.spec.clusterPermissions.
+func GenerateCSVSpec(deploy *appsv1.Deployment, serviceAccountName string, clusterRoles []*rbacv1.ClusterRole, roles []*rbacv1.Role, installModes []InstallMode) ClusterServiceVersionSpec {
+	spec := ClusterServiceVersionSpec{
+		Install: InstallStrategy{
+			StrategyName: "deployment",
+			Spec: InstallStrategySpec{
+				Deployments: []StrategyDeploymentSpec{
+					{Name: deploy.Name, Spec: deploy.Spec},
+				},
+			},
+		},
+		InstallModes: installModes,
+	}
+	for _, cr := range clusterRoles {
+		spec.Install.Spec.ClusterPermissions = append(spec.Install.Spec.ClusterPermissions, StrategyDeploymentPermission{
+			ServiceAccountName: serviceAccountName,
+			Rules:              cr.Rules,
+		})
+	}
+	for _, r := range roles {
+		spec.Install.Spec.Permissions = append(spec.Install.Spec.Permissions, StrategyDeploymentPermission{
+			ServiceAccountName: serviceAccountName,
+			Rules:              r.Rules,
+		})
+	}
+	return spec
+}
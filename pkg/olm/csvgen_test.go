@@ -0,0 +1,71 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package olm
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestGenerateCSVSpecCopiesDeploymentAndInstallModes(t *testing.T) {
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-operator"},
+		Spec:       appsv1.DeploymentSpec{},
+	}
+	installModes := []InstallMode{
+		{Type: InstallModeTypeAllNamespaces, Supported: true},
+		{Type: InstallModeTypeOwnNamespace, Supported: false},
+	}
+
+	spec := GenerateCSVSpec(deploy, "istio-operator", nil, nil, installModes)
+
+	if spec.Install.StrategyName != "deployment" {
+		t.Fatalf("spec.Install.StrategyName = %q, want \"deployment\"", spec.Install.StrategyName)
+	}
+	if len(spec.Install.Spec.Deployments) != 1 || spec.Install.Spec.Deployments[0].Name != "istio-operator" {
+		t.Fatalf("spec.Install.Spec.Deployments = %+v, want a single entry named istio-operator", spec.Install.Spec.Deployments)
+	}
+	if len(spec.InstallModes) != 2 {
+		t.Fatalf("len(spec.InstallModes) = %d, want 2", len(spec.InstallModes))
+	}
+}
+
+func TestGenerateCSVSpecTurnsRBACIntoPermissions(t *testing.T) {
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "istio-operator"}}
+	clusterRole := &rbacv1.ClusterRole{Rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}}}}
+	role := &rbacv1.Role{Rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}}}}
+
+	spec := GenerateCSVSpec(deploy, "istio-operator", []*rbacv1.ClusterRole{clusterRole}, []*rbacv1.Role{role}, nil)
+
+	if len(spec.Install.Spec.ClusterPermissions) != 1 || spec.Install.Spec.ClusterPermissions[0].ServiceAccountName != "istio-operator" {
+		t.Fatalf("spec.Install.Spec.ClusterPermissions = %+v, want one entry for istio-operator", spec.Install.Spec.ClusterPermissions)
+	}
+	if len(spec.Install.Spec.Permissions) != 1 || spec.Install.Spec.Permissions[0].ServiceAccountName != "istio-operator" {
+		t.Fatalf("spec.Install.Spec.Permissions = %+v, want one entry for istio-operator", spec.Install.Spec.Permissions)
+	}
+}
+
+func TestGenerateCSVSpecNoRBACYieldsNoPermissions(t *testing.T) {
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "istio-operator"}}
+
+	spec := GenerateCSVSpec(deploy, "istio-operator", nil, nil, nil)
+
+	if spec.Install.Spec.Permissions != nil || spec.Install.Spec.ClusterPermissions != nil {
+		t.Fatalf("spec.Install.Spec = %+v, want nil Permissions/ClusterPermissions when no RBAC objects are given", spec.Install.Spec)
+	}
+}
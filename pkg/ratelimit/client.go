@@ -0,0 +1,146 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit caps the rate at which a client.Client makes
+// Kubernetes API calls, by swapping the client every reconciler is handed
+// for a Client from this package, so a large cluster's list/watch traffic
+// can't overwhelm the API server the way an unbounded controller can.
+package ratelimit
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// apiCallsTotal counts every API call a Client has let through its
+// rate.Limiter, labeled by verb and the Kind of object the call concerned,
+// so a sudden spike in one reconciler's API traffic is attributable rather
+// than lost in an aggregate request count.
+var apiCallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "client_api_calls_total",
+	Help: "Number of Kubernetes API calls made through a ratelimit.Client, labeled by verb and object kind.",
+}, []string{"verb", "kind"})
+
+func init() {
+	prometheus.MustRegister(apiCallsTotal)
+}
+
+// APIQPS and APIBurst are set by the operator binary's --api-qps and
+// --api-burst flags before its manager is built, so main can decide
+// whether to wrap the manager's client in a Client before handing it to
+// the reconcilers. This tree has no cmd/main.go to attach such flags to; a
+// binary that does should do roughly:
+//
+//	flag.Float64Var(&ratelimit.APIQPS, "api-qps", 0, "cap Kubernetes API calls made through the manager's client to this many per second (0 disables rate limiting)")
+//	flag.IntVar(&ratelimit.APIBurst, "api-burst", 1, "allow bursts of up to this many Kubernetes API calls above --api-qps")
+//	...
+//	c := mgr.GetClient()
+//	if ratelimit.APIQPS > 0 {
+//		c = ratelimit.NewClient(c, ratelimit.APIQPS, ratelimit.APIBurst)
+//	}
+var (
+	APIQPS   float64
+	APIBurst int
+)
+
+// Client wraps a client.Client, passing every Get, List, Create, Update,
+// Patch, and Delete call through a token-bucket rate.Limiter before letting
+// it reach the wrapped client, so a reconcile storm's API traffic is capped
+// at a steady rate instead of bursting against the API server. Every other
+// method (Scheme, RESTMapper, Status, ...) passes straight through to the
+// wrapped client.Client via embedding.
+type Client struct {
+	client.Client
+	limiter *rate.Limiter
+}
+
+// NewClient wraps c in a Client that allows up to qps calls per second,
+// with bursts of up to burst calls above that steady rate.
+func NewClient(c client.Client, qps float64, burst int) *Client {
+	return &Client{Client: c, limiter: rate.NewLimiter(rate.Limit(qps), burst)}
+}
+
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := c.wait(ctx, "get", kindOf(obj)); err != nil {
+		return err
+	}
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *Client) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := c.wait(ctx, "list", kindOf(list)); err != nil {
+		return err
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.wait(ctx, "create", kindOf(obj)); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.wait(ctx, "update", kindOf(obj)); err != nil {
+		return err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := c.wait(ctx, "patch", kindOf(obj)); err != nil {
+		return err
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.wait(ctx, "delete", kindOf(obj)); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// wait blocks until the limiter has a token free for verb/kind, or ctx is
+// done, and only increments apiCallsTotal once it has — a call the limiter
+// never let through didn't reach the API server, so it shouldn't count as
+// one.
+func (c *Client) wait(ctx context.Context, verb, kind string) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	apiCallsTotal.WithLabelValues(verb, kind).Inc()
+	return nil
+}
+
+// kindOf returns obj's Kind, preferring a GroupVersionKind already set on
+// it (the normal case for an unstructured.Unstructured) and falling back to
+// its Go type name, since a typed API object (e.g. *corev1.Pod) usually
+// leaves GroupVersionKind empty until it's serialized.
+func kindOf(obj runtime.Object) string {
+	if gvk := obj.GetObjectKind().GroupVersionKind(); gvk.Kind != "" {
+		return gvk.Kind
+	}
+	t := reflect.TypeOf(obj)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
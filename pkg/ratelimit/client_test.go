@@ -0,0 +1,91 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func apiCallsTotalValue(t *testing.T, verb, kind string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := apiCallsTotal.WithLabelValues(verb, kind).Write(&m); err != nil {
+		t.Fatalf("apiCallsTotal.Write() = %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestClientGetPassesThroughToUnderlyingClient(t *testing.T) {
+	existing := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	underlying := newFakeClient(t, existing)
+	c := NewClient(underlying, 100, 100)
+
+	got := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(existing), got); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if got.Name != "shop" {
+		t.Fatalf("Get() returned %q, want %q", got.Name, "shop")
+	}
+}
+
+func TestClientCreateIncrementsAPICallsTotalLabeledByVerbAndKind(t *testing.T) {
+	underlying := newFakeClient(t)
+	c := NewClient(underlying, 100, 100)
+
+	before := apiCallsTotalValue(t, "create", "Namespace")
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	if err := c.Create(context.Background(), ns); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	after := apiCallsTotalValue(t, "create", "Namespace")
+
+	if after != before+1 {
+		t.Fatalf("apiCallsTotal{create,Namespace} = %v, want it incremented by 1", after-before)
+	}
+}
+
+func TestClientBlocksOnceBurstIsExhausted(t *testing.T) {
+	underlying := newFakeClient(t)
+	c := NewClient(underlying, 1, 1)
+
+	if err := c.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "a"}}); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "b"}}); err == nil {
+		t.Fatal("Create() = nil, want the second call past burst to block until ctx's short deadline expires")
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package renderdir lets the operator be used as a manifest generator in a
+// GitOps pipeline (Argo CD, Flux) instead of a reconciler: rather than
+// swapping in a client.Client that makes no changes at all, like dryrun
+// does, it swaps in a Client that writes every resource a reconcile would
+// have created, updated or applied to a YAML file on disk.
+package renderdir
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+var log = logf.Log.WithName("renderdir")
+
+// RenderDir is set by the operator binary's --render-to-dir flag before its
+// manager is built, so main can decide whether to wrap the manager's client
+// in a Client before handing it to the reconcilers — and, having done so,
+// to exit once reconciliation completes rather than starting the usual
+// watch loop, since a render-to-dir run has no cluster state left to watch.
+// This tree has no cmd/main.go to attach such a flag to; a binary that does
+// should do roughly:
+//
+//	flag.StringVar(&renderdir.RenderDir, "render-to-dir", "", "render manifests to this directory instead of applying them, then exit")
+//	...
+//	c := mgr.GetClient()
+//	if renderdir.RenderDir != "" {
+//		c = renderdir.NewClient(c, renderdir.RenderDir)
+//	}
+//	// run reconcilers against c directly, then os.Exit(0) instead of mgr.Start(ctx)
+var RenderDir string
+
+// managedByLabel and managedByValue are stamped onto every rendered
+// manifest, the same pair prune.go's managedByLabel/managedByValue uses (and
+// controller.FieldOwner shares the value of), so a manifest rendered here
+// and later applied for real is immediately recognized by Prune as one this
+// operator owns.
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "istio-operator"
+)
+
+// Client wraps a client.Client, replacing Create, Update and Patch (and the
+// same three through Status()) with rendering obj to a YAML file under dir
+// instead of performing the call. Every read-only method (Get, List,
+// Scheme, RESTMapper, ...) passes straight through to the wrapped
+// client.Client via embedding, so a reconciler reading back a resource it
+// just "created" still needs that resource seeded into the wrapped client
+// ahead of time — Client renders manifests, it doesn't simulate a cluster.
+// Delete and DeleteAllOf are left to the wrapped client.Client unembellished,
+// since there's no meaningful file to render for "this resource shouldn't
+// exist"; a render-to-dir run isn't expected to issue either.
+type Client struct {
+	client.Client
+	dir string
+}
+
+// NewClient wraps c in a Client that renders to dir.
+func NewClient(c client.Client, dir string) *Client {
+	return &Client{Client: c, dir: dir}
+}
+
+func (c *Client) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	return c.render(obj)
+}
+
+func (c *Client) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	return c.render(obj)
+}
+
+func (c *Client) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	return c.render(obj)
+}
+
+// Status returns a SubResourceWriter that renders status writes the same
+// way Client itself renders everything else, since helpers like
+// istiooperator.UpdateOperatorStatus go through Status().Patch rather than
+// Patch directly.
+func (c *Client) Status() client.SubResourceWriter {
+	return &statusWriter{c: c}
+}
+
+type statusWriter struct {
+	c *Client
+}
+
+func (w *statusWriter) Create(_ context.Context, obj client.Object, subResource client.Object, _ ...client.SubResourceCreateOption) error {
+	return w.c.render(subResource)
+}
+
+func (w *statusWriter) Update(_ context.Context, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+	return w.c.render(obj)
+}
+
+func (w *statusWriter) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.SubResourcePatchOption) error {
+	return w.c.render(obj)
+}
+
+// render stamps obj with managedByLabel and writes it to
+// "<namespace>_<kind>_<name>.yaml" under c.dir, creating the directory if it
+// doesn't already exist. obj's Kind comes from its GroupVersionKind, which
+// every call site that server-side applies a resource already sets
+// explicitly (see e.g. istiooperator.ExternalControlPlaneReconciler); a
+// caller that Creates or Updates a typed object without TypeMeta set gets a
+// filename with an empty kind segment rather than a failure, since the
+// rendered file's content is still correct either way.
+func (c *Client) render(obj client.Object) error {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[managedByLabel] = managedByValue
+	obj.SetLabels(labels)
+
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return fmt.Errorf("creating render directory %q: %w", c.dir, err)
+	}
+
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	filename := fmt.Sprintf("%s_%s_%s.yaml", obj.GetNamespace(), kind, obj.GetName())
+	path := filepath.Join(c.dir, filename)
+
+	rendered, err := yaml.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("rendering %s: %w", filename, err)
+	}
+	if err := os.WriteFile(path, rendered, 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	log.Info("Rendered manifest", "path", path)
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renderdir
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestClientCreateRendersFileInsteadOfMutatingCluster(t *testing.T) {
+	dir := t.TempDir()
+	underlying := newFakeClient(t)
+	c := NewClient(underlying, dir)
+
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod"},
+	}
+	if err := c.Create(context.Background(), svc); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+
+	if err := underlying.Get(context.Background(), client.ObjectKeyFromObject(svc), &corev1.Service{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() after render Create = %v, want NotFound", err)
+	}
+
+	path := filepath.Join(dir, "istio-system_Service_istiod.yaml")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) = %v", path, err)
+	}
+
+	var rendered corev1.Service
+	if err := yaml.Unmarshal(data, &rendered); err != nil {
+		t.Fatalf("Unmarshal rendered file = %v", err)
+	}
+	if rendered.Name != "istiod" || rendered.Namespace != "istio-system" {
+		t.Fatalf("rendered object = %+v, want the Service just created", rendered)
+	}
+}
+
+func TestClientRenderStampsManagedByLabel(t *testing.T) {
+	dir := t.TempDir()
+	c := NewClient(newFakeClient(t), dir)
+
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod", Labels: map[string]string{"app": "istiod"}},
+	}
+	if err := c.Create(context.Background(), svc); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "istio-system_Service_istiod.yaml"))
+	if err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	}
+	var rendered corev1.Service
+	if err := yaml.Unmarshal(data, &rendered); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if rendered.Labels["app.kubernetes.io/managed-by"] != "istio-operator" {
+		t.Fatalf("Labels = %v, want app.kubernetes.io/managed-by=istio-operator", rendered.Labels)
+	}
+	if rendered.Labels["app"] != "istiod" {
+		t.Fatalf("Labels = %v, want the object's existing labels preserved", rendered.Labels)
+	}
+}
+
+func TestClientPatchRendersFile(t *testing.T) {
+	dir := t.TempDir()
+	c := NewClient(newFakeClient(t), dir)
+
+	cm := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istio"},
+		Data:       map[string]string{"mesh": "caAddress: 10.0.0.5:15012\n"},
+	}
+	if err := c.Patch(context.Background(), cm, client.Apply, client.ForceOwnership, client.FieldOwner("istio-operator")); err != nil {
+		t.Fatalf("Patch() = %v", err)
+	}
+
+	path := filepath.Join(dir, "istio-system_ConfigMap_istio.yaml")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Stat(%s) = %v, want the Patch to have rendered a file", path, err)
+	}
+}
+
+func TestClientGetPassesThroughToUnderlyingClient(t *testing.T) {
+	existing := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	underlying := newFakeClient(t, existing)
+	c := NewClient(underlying, t.TempDir())
+
+	got := &corev1.Namespace{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(existing), got); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if got.Name != "shop" {
+		t.Fatalf("Get() returned %q, want %q", got.Name, "shop")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package template implements Helm-like "${VAR}" variable substitution over
+// a raw IstioOperator manifest, so the same CR can be committed once and
+// reused across environments (dev, staging, prod) by substituting in
+// different values per environment rather than maintaining near-duplicate
+// CRs.
+package template
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// UndefinedVariablePolicy controls RenderTemplate's behavior for a "${VAR}"
+// placeholder with no corresponding entry in values (directly, or
+// transitively through another value it expands to). This tree has no
+// cmd/main.go to attach a flag selecting this to; a binary that does should
+// do roughly:
+//
+//	flag.StringVar((*string)(&template.UndefinedVariablePolicy), "values-undefined-policy", string(template.UndefinedVariableError), "how to handle an undefined ${VAR} placeholder: \"Error\" or \"Empty\"")
+var UndefinedVariablePolicy = UndefinedVariableError
+
+// UndefinedVariablePolicyValue is the type of UndefinedVariablePolicy.
+type UndefinedVariablePolicyValue string
+
+const (
+	// UndefinedVariableError makes RenderTemplate fail with an error
+	// naming the undefined variable, the default: a typo'd or forgotten
+	// variable fails the render loudly instead of silently blanking out
+	// part of the manifest.
+	UndefinedVariableError UndefinedVariablePolicyValue = "Error"
+	// UndefinedVariableEmpty substitutes the empty string for an
+	// undefined variable instead of failing, for a manifest whose
+	// optional placeholders are only sometimes provided a value.
+	UndefinedVariableEmpty UndefinedVariablePolicyValue = "Empty"
+)
+
+// placeholderRE matches a "${VAR}" placeholder, VAR restricted to the
+// characters a shell or Helm template variable name allows.
+var placeholderRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// RenderTemplate substitutes every "${VAR}" placeholder in template with
+// values[VAR], expanding recursively so a value that itself contains a
+// placeholder (e.g. values["REGION"] == "${ENV}-west") is fully resolved
+// before being substituted in. It returns an error identifying the cycle if
+// that recursive expansion loops back on a variable already being expanded,
+// and otherwise handles a placeholder missing from values per
+// UndefinedVariablePolicy.
+func RenderTemplate(tmpl []byte, values map[string]string) ([]byte, error) {
+	resolved := make(map[string]string, len(values))
+	for name := range values {
+		v, err := resolveVariable(name, values, resolved, nil)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = v
+	}
+
+	var renderErr error
+	out := placeholderRE.ReplaceAllFunc(tmpl, func(match []byte) []byte {
+		if renderErr != nil {
+			return match
+		}
+		name := placeholderRE.FindSubmatch(match)[1]
+		v, ok := resolved[string(name)]
+		if !ok {
+			if UndefinedVariablePolicy == UndefinedVariableEmpty {
+				return nil
+			}
+			renderErr = fmt.Errorf("undefined variable %q", name)
+			return match
+		}
+		return []byte(v)
+	})
+	if renderErr != nil {
+		return nil, renderErr
+	}
+	return out, nil
+}
+
+// resolveVariable returns values[name] with every placeholder it contains
+// (directly or transitively) substituted, memoizing completed expansions in
+// resolved. path tracks the chain of variables currently being expanded, so
+// a variable reached a second time while still on that chain is reported as
+// a circular reference instead of recursing forever.
+func resolveVariable(name string, values, resolved map[string]string, path []string) (string, error) {
+	if v, ok := resolved[name]; ok {
+		return v, nil
+	}
+	for _, seen := range path {
+		if seen == name {
+			return "", fmt.Errorf("circular variable reference: %s", append(path, name))
+		}
+	}
+
+	raw, ok := values[name]
+	if !ok {
+		if UndefinedVariablePolicy == UndefinedVariableEmpty {
+			return "", nil
+		}
+		return "", fmt.Errorf("undefined variable %q", name)
+	}
+
+	path = append(path, name)
+	var err error
+	expanded := placeholderRE.ReplaceAllFunc([]byte(raw), func(match []byte) []byte {
+		if err != nil {
+			return match
+		}
+		refName := string(placeholderRE.FindSubmatch(match)[1])
+		v, rerr := resolveVariable(refName, values, resolved, path)
+		if rerr != nil {
+			err = rerr
+			return match
+		}
+		return []byte(v)
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(expanded), nil
+}
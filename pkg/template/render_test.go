@@ -0,0 +1,95 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package template
+
+import (
+	"testing"
+)
+
+// withUndefinedVariablePolicy sets UndefinedVariablePolicy for the duration
+// of the calling test, restoring it afterwards, since it's a package-level
+// var every RenderTemplate call reads.
+func withUndefinedVariablePolicy(t *testing.T, policy UndefinedVariablePolicyValue) {
+	t.Helper()
+	previous := UndefinedVariablePolicy
+	UndefinedVariablePolicy = policy
+	t.Cleanup(func() { UndefinedVariablePolicy = previous })
+}
+
+func TestRenderTemplateSubstitutesPlaceholders(t *testing.T) {
+	out, err := RenderTemplate([]byte("revision: ${REVISION}\nnamespace: ${NAMESPACE}\n"), map[string]string{
+		"REVISION":  "canary",
+		"NAMESPACE": "istio-system",
+	})
+	if err != nil {
+		t.Fatalf("RenderTemplate() = %v", err)
+	}
+	want := "revision: canary\nnamespace: istio-system\n"
+	if string(out) != want {
+		t.Fatalf("RenderTemplate() = %q, want %q", out, want)
+	}
+}
+
+func TestRenderTemplateExpandsNestedPlaceholders(t *testing.T) {
+	out, err := RenderTemplate([]byte("region: ${REGION}"), map[string]string{
+		"REGION": "${ENV}-west",
+		"ENV":    "prod",
+	})
+	if err != nil {
+		t.Fatalf("RenderTemplate() = %v", err)
+	}
+	if want := "region: prod-west"; string(out) != want {
+		t.Fatalf("RenderTemplate() = %q, want %q", out, want)
+	}
+}
+
+func TestRenderTemplateDetectsCircularReference(t *testing.T) {
+	_, err := RenderTemplate([]byte("a: ${A}"), map[string]string{
+		"A": "${B}",
+		"B": "${A}",
+	})
+	if err == nil {
+		t.Fatal("RenderTemplate() = nil, want an error for a circular variable reference")
+	}
+}
+
+func TestRenderTemplateUndefinedVariableErrorsByDefault(t *testing.T) {
+	_, err := RenderTemplate([]byte("revision: ${REVISION}"), map[string]string{})
+	if err == nil {
+		t.Fatal("RenderTemplate() = nil, want an error for an undefined variable under the default UndefinedVariableError policy")
+	}
+}
+
+func TestRenderTemplateUndefinedVariableEmptyPolicySubstitutesEmptyString(t *testing.T) {
+	withUndefinedVariablePolicy(t, UndefinedVariableEmpty)
+
+	out, err := RenderTemplate([]byte("revision: ${REVISION}"), map[string]string{})
+	if err != nil {
+		t.Fatalf("RenderTemplate() = %v", err)
+	}
+	if want := "revision: "; string(out) != want {
+		t.Fatalf("RenderTemplate() = %q, want %q", out, want)
+	}
+}
+
+func TestRenderTemplateLeavesTextWithoutPlaceholdersUnchanged(t *testing.T) {
+	out, err := RenderTemplate([]byte("namespace: istio-system"), nil)
+	if err != nil {
+		t.Fatalf("RenderTemplate() = %v", err)
+	}
+	if want := "namespace: istio-system"; string(out) != want {
+		t.Fatalf("RenderTemplate() = %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// ValuesFromAnnotation names the ConfigMap LoadValuesFromAnnotation reads
+// RenderTemplate's substitution values from, set on the IstioOperator
+// manifest itself (or, since RenderTemplate runs before the manifest is
+// unmarshaled, on the same raw document's metadata.annotations) so a single
+// CR can be pointed at a different values ConfigMap per environment.
+const ValuesFromAnnotation = "operator.istio.io/values-from"
+
+// ExtractAnnotations reads metadata.annotations off a raw IstioOperator
+// manifest without fully unmarshaling it, so LoadValuesFromAnnotation's
+// ConfigMap lookup can run before RenderTemplate has substituted the
+// manifest's placeholders — and, in particular, so ValuesFromAnnotation
+// itself is never expected to be a templated value. A manifest with no
+// metadata.annotations returns a nil map and no error.
+func ExtractAnnotations(raw []byte) (map[string]string, error) {
+	var manifest struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := yaml.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest metadata: %w", err)
+	}
+	return manifest.Metadata.Annotations, nil
+}
+
+// LoadValuesFromAnnotation reads annotations[ValuesFromAnnotation], if set,
+// and returns the Data of the ConfigMap it names in namespace as a values
+// map for RenderTemplate. A missing or empty annotation returns a nil map
+// and no error: RenderTemplate over a nil values map simply leaves every
+// "${VAR}" placeholder for UndefinedVariablePolicy to handle, the same as
+// an explicitly empty values map would.
+func LoadValuesFromAnnotation(ctx context.Context, c client.Client, namespace string, annotations map[string]string) (map[string]string, error) {
+	name := annotations[ValuesFromAnnotation]
+	if name == "" {
+		return nil, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, cm); err != nil {
+		return nil, fmt.Errorf("getting ConfigMap %s/%s named by %s: %w", namespace, name, ValuesFromAnnotation, err)
+	}
+	return cm.Data, nil
+}
+
+// ValuesFile is set by the CLI binary's --values-file flag before
+// RenderTemplate is called, naming a YAML (or JSON) file of flat string
+// key/value pairs to load with LoadValuesFile. This tree has no
+// cmd/main.go to attach such a flag to; a binary that does should do
+// roughly:
+//
+//	flag.StringVar(&template.ValuesFile, "values-file", "", "YAML file of key/value pairs to substitute into \"${VAR}\" placeholders")
+//	...
+//	values, err := template.LoadValuesFile(template.ValuesFile)
+var ValuesFile string
+
+// LoadValuesFile reads and parses a flat map[string]string of substitution
+// values from a YAML (or JSON, since JSON is valid YAML) file at path, the
+// same ioutil.ReadFile-then-yaml.Unmarshal shape kubemetrics.LoadMetricsConfig
+// uses for its own config file.
+func LoadValuesFile(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values file %s: %w", path, err)
+	}
+	values := map[string]string{}
+	if err := yaml.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse values file %s: %w", path, err)
+	}
+	return values, nil
+}
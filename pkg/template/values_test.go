@@ -0,0 +1,104 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package template
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestExtractAnnotations(t *testing.T) {
+	raw := []byte(`
+apiVersion: istio.istio.io/v1alpha1
+kind: IstioOperator
+metadata:
+  name: default
+  annotations:
+    operator.istio.io/values-from: my-values
+`)
+	annotations, err := ExtractAnnotations(raw)
+	if err != nil {
+		t.Fatalf("ExtractAnnotations() = %v", err)
+	}
+	if got := annotations[ValuesFromAnnotation]; got != "my-values" {
+		t.Fatalf("annotations[%q] = %q, want %q", ValuesFromAnnotation, got, "my-values")
+	}
+}
+
+func TestExtractAnnotationsWithoutAnnotationsReturnsNil(t *testing.T) {
+	annotations, err := ExtractAnnotations([]byte("metadata:\n  name: default\n"))
+	if err != nil {
+		t.Fatalf("ExtractAnnotations() = %v", err)
+	}
+	if annotations != nil {
+		t.Fatalf("annotations = %v, want nil", annotations)
+	}
+}
+
+func TestLoadValuesFromAnnotationReadsConfigMapData(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "my-values"},
+		Data:       map[string]string{"REVISION": "canary"},
+	}
+	c := newFakeClient(t, cm)
+
+	values, err := LoadValuesFromAnnotation(context.Background(), c, "istio-system", map[string]string{
+		ValuesFromAnnotation: "my-values",
+	})
+	if err != nil {
+		t.Fatalf("LoadValuesFromAnnotation() = %v", err)
+	}
+	if got := values["REVISION"]; got != "canary" {
+		t.Fatalf("values[%q] = %q, want %q", "REVISION", got, "canary")
+	}
+}
+
+func TestLoadValuesFromAnnotationWithoutAnnotationReturnsNil(t *testing.T) {
+	c := newFakeClient(t)
+
+	values, err := LoadValuesFromAnnotation(context.Background(), c, "istio-system", nil)
+	if err != nil {
+		t.Fatalf("LoadValuesFromAnnotation() = %v", err)
+	}
+	if values != nil {
+		t.Fatalf("values = %v, want nil", values)
+	}
+}
+
+func TestLoadValuesFromAnnotationErrorsOnMissingConfigMap(t *testing.T) {
+	c := newFakeClient(t)
+
+	_, err := LoadValuesFromAnnotation(context.Background(), c, "istio-system", map[string]string{
+		ValuesFromAnnotation: "missing",
+	})
+	if err == nil {
+		t.Fatal("LoadValuesFromAnnotation() = nil, want an error for a ConfigMap that doesn't exist")
+	}
+}
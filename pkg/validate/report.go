@@ -0,0 +1,129 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package validate runs the operator's existing admission and upgrade
+// checks against an IstioOperator manifest read from disk, without opening
+// a Kubernetes client or starting a reconcile loop. It exists so a CI
+// pipeline (or a "kubectl plugin istio validate" wrapper) can catch a bad
+// manifest before it ever reaches a cluster.
+package validate
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller/istiooperator"
+	"github.com/InnocentK/istio-operator/pkg/webhook"
+)
+
+// ValidateOnlyMode is set by the operator binary's --validate-only flag
+// before its manager is built, so main can load --config-file, call
+// RunChecks against it, print the resulting Report as JSON, and exit with
+// Report.ExitCode() instead of starting the manager. This tree has no
+// cmd/main.go to attach such flags to; a binary that does should do
+// roughly:
+//
+//	flag.BoolVar(&validate.ValidateOnlyMode, "validate-only", false, "validate --config-file and exit instead of starting the operator")
+//	configFile := flag.String("config-file", "", "path to the IstioOperator manifest to validate")
+//	...
+//	if validate.ValidateOnlyMode {
+//		iop, err := validate.LoadIstioOperator(*configFile)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		report := validate.RunChecks(ctx, iop)
+//		json.NewEncoder(os.Stdout).Encode(report)
+//		os.Exit(report.ExitCode())
+//	}
+var ValidateOnlyMode bool
+
+// Report is the structured result of RunChecks against one IstioOperator
+// manifest.
+type Report struct {
+	// Valid is false if Errors is non-empty or any PreflightResults entry
+	// has Severity istiooperator.SeverityError.
+	Valid bool `json:"valid"`
+
+	// Errors holds schema and required-field problems webhook.ValidateSpec
+	// found, reported as plain strings since ValidateSpec returns a single
+	// combined error rather than one per problem.
+	Errors []string `json:"errors,omitempty"`
+
+	// PreflightResults holds the version-compatibility and deprecated-field
+	// findings istiooperator.RunUpgradePreflightChecks found. Checks that
+	// require a live cluster (proxy version skew) never run here, since
+	// RunUpgradePreflightChecks is called with a nil *rest.Config.
+	PreflightResults []istiooperator.PreflightResult `json:"preflightResults,omitempty"`
+}
+
+// ExitCode returns 0 if r.Valid, else 1, matching the convention a CLI
+// uses to signal success or failure to its caller (e.g. a CI pipeline step).
+func (r *Report) ExitCode() int {
+	if r.Valid {
+		return 0
+	}
+	return 1
+}
+
+// LoadIstioOperator reads and parses the IstioOperator manifest at path.
+func LoadIstioOperator(path string) (*v1alpha1.IstioOperator, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	iop := &v1alpha1.IstioOperator{}
+	if err := yaml.Unmarshal(raw, iop); err != nil {
+		return nil, fmt.Errorf("parsing %s as an IstioOperator manifest: %w", path, err)
+	}
+	return iop, nil
+}
+
+// RunChecks validates desired against webhook.ValidateSpec (schema and
+// required fields) and istiooperator.RunUpgradePreflightChecks (version
+// compatibility and deprecated fields), the same two checks the admission
+// webhook and an in-cluster upgrade already run, without making any
+// Kubernetes API calls: RunUpgradePreflightChecks is called with a nil
+// *rest.Config, which it already treats as "skip the checks that need a
+// live cluster" for its proxy-version-skew check, and with a zero-value
+// current IstioOperator, which it already treats as "nothing to skew
+// against" for its version-skew check — so only the checks that depend
+// solely on desired's own spec ever run. It returns an error only if a
+// check itself couldn't run (e.g. the embedded compatibility matrix failed
+// to parse), not for a manifest that's simply invalid — that's reported in
+// the returned Report.
+func RunChecks(ctx context.Context, desired *v1alpha1.IstioOperator) (*Report, error) {
+	report := &Report{Valid: true}
+
+	if err := webhook.ValidateSpec(&desired.Spec); err != nil {
+		report.Valid = false
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	results, err := istiooperator.RunUpgradePreflightChecks(ctx, nil, &v1alpha1.IstioOperator{}, desired)
+	if err != nil {
+		return nil, fmt.Errorf("running preflight checks: %w", err)
+	}
+	report.PreflightResults = results
+	for _, result := range results {
+		if result.Severity == istiooperator.SeverityError {
+			report.Valid = false
+		}
+	}
+
+	return report, nil
+}
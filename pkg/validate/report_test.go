@@ -0,0 +1,117 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestRunChecksValidManifestIsValid(t *testing.T) {
+	desired := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: "1.18", Profile: "default"}}
+
+	report, err := RunChecks(context.Background(), desired)
+	if err != nil {
+		t.Fatalf("RunChecks() = %v", err)
+	}
+	if !report.Valid {
+		t.Errorf("report.Valid = false, want true (errors: %v, preflight: %+v)", report.Errors, report.PreflightResults)
+	}
+	if report.ExitCode() != 0 {
+		t.Errorf("ExitCode() = %d, want 0 for a valid report", report.ExitCode())
+	}
+}
+
+func TestRunChecksInvalidProfileIsReportedAsAnError(t *testing.T) {
+	desired := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: "1.18", Profile: "not-a-real-profile"}}
+
+	report, err := RunChecks(context.Background(), desired)
+	if err != nil {
+		t.Fatalf("RunChecks() = %v", err)
+	}
+	if report.Valid {
+		t.Fatal("report.Valid = true, want false for an invalid profile")
+	}
+	if len(report.Errors) != 1 {
+		t.Fatalf("report.Errors = %v, want exactly one error", report.Errors)
+	}
+	if report.ExitCode() != 1 {
+		t.Errorf("ExitCode() = %d, want 1 for an invalid report", report.ExitCode())
+	}
+}
+
+func TestRunChecksUnknownVersionIsReportedAsAPreflightError(t *testing.T) {
+	desired := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: "9.9", Profile: "default"}}
+
+	report, err := RunChecks(context.Background(), desired)
+	if err != nil {
+		t.Fatalf("RunChecks() = %v", err)
+	}
+	if report.Valid {
+		t.Fatal("report.Valid = true, want false for a version missing from the compatibility matrix")
+	}
+	found := false
+	for _, r := range report.PreflightResults {
+		if r.Check == "version-known" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("report.PreflightResults = %+v, want a \"version-known\" finding", report.PreflightResults)
+	}
+}
+
+func TestRunChecksMakesNoKubernetesAPICalls(t *testing.T) {
+	// desired has no externalControlPlane configured, so even the one
+	// network call RunChecks could make (the TCP reachability check for an
+	// "external" profile) is skipped; RunChecks is passed a nil *rest.Config
+	// throughout, so there's no client to make a Kubernetes API call with
+	// even if one were attempted.
+	desired := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Version: "1.18", Profile: "default"}}
+
+	if _, err := RunChecks(context.Background(), desired); err != nil {
+		t.Fatalf("RunChecks() = %v, want no error and no attempted cluster access", err)
+	}
+}
+
+func TestLoadIstioOperatorParsesManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "iop.yaml")
+	manifest := "apiVersion: istio.io/v1alpha1\nkind: IstioOperator\nmetadata:\n  name: example\nspec:\n  version: \"1.18\"\n  profile: default\n"
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+
+	iop, err := LoadIstioOperator(path)
+	if err != nil {
+		t.Fatalf("LoadIstioOperator() = %v", err)
+	}
+	if iop.Spec.Version != "1.18" {
+		t.Errorf("Spec.Version = %q, want %q", iop.Spec.Version, "1.18")
+	}
+	if iop.Spec.Profile != "default" {
+		t.Errorf("Spec.Profile = %q, want %q", iop.Spec.Profile, "default")
+	}
+}
+
+func TestLoadIstioOperatorMissingFileReturnsError(t *testing.T) {
+	if _, err := LoadIstioOperator(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("LoadIstioOperator() = nil error, want an error for a missing file")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+var log = logf.Log.WithName("webhook")
+
+// defaultingWebhookPath is where AddDefaultingWebhookToManager registers
+// IstioOperatorDefaulter, and where RegisterMutatingWebhookConfiguration
+// points the MutatingWebhookConfiguration it creates/updates.
+const defaultingWebhookPath = "/mutate-istio-istio-io-v1alpha1-istiooperator"
+
+// webhookDefaultsInjectedTotal counts containers a
+// IstioOperatorDefaulter.Handle call patched with a missing resource
+// requests/limits default, labeled by component, so operators can see which
+// components are actually missing limits in their manifests rather than
+// relying on a single boolean.
+var webhookDefaultsInjectedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "webhook_defaults_injected_total",
+		Help: "Number of container resource requests/limits defaulted by the IstioOperator mutating webhook, labeled by component.",
+	},
+	[]string{"component"},
+)
+
+func init() {
+	prometheus.MustRegister(webhookDefaultsInjectedTotal)
+}
+
+// IstioOperatorDefaulter implements admission.Handler, patching IstioOperator
+// specs that are missing container resource requests/limits for a component
+// with operator-configurable defaults, so components deployed without limits
+// don't reach the apiserver and cause node pressure later.
+type IstioOperatorDefaulter struct {
+	// DefaultResources maps a component name (e.g. "istiod", "istio-ingressgateway")
+	// to the ResourceRequirements ApplyDefaults fills in when that component's
+	// entry in a IstioOperatorSpec is missing requests/limits. Populated from
+	// the istio-operator-defaults ConfigMap; see LoadDefaultResources.
+	DefaultResources map[string]corev1.ResourceRequirements
+}
+
+// Handle decodes req's object as an IstioOperator, applies ApplyDefaults to
+// its spec, and returns a JSON patch response if anything changed.
+func (d *IstioOperatorDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	iop := &v1alpha1.IstioOperator{}
+	if err := json.Unmarshal(req.Object.Raw, iop); err != nil {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("decoding IstioOperator: %w", err))
+	}
+
+	raw := req.Object.Raw
+	d.ApplyDefaults(iop)
+
+	marshaled, err := json.Marshal(iop)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("marshaling defaulted IstioOperator: %w", err))
+	}
+	return admission.PatchResponseFromRaw(raw, marshaled)
+}
+
+// ApplyDefaults fills every component in iop.Spec.ComponentResources that's
+// missing requests/limits with d.DefaultResources' entry for that component,
+// leaving components with no configured default or with already-set
+// requests/limits untouched. Each injected default increments
+// webhookDefaultsInjectedTotal for that component and is logged at V(2), low
+// enough volume that it's only visible when an operator is actively
+// debugging why a component got limits it didn't specify.
+func (d *IstioOperatorDefaulter) ApplyDefaults(iop *v1alpha1.IstioOperator) {
+	if iop.Spec.ComponentResources == nil {
+		iop.Spec.ComponentResources = make(map[string]corev1.ResourceRequirements, len(d.DefaultResources))
+	}
+	for component, defaults := range d.DefaultResources {
+		existing, ok := iop.Spec.ComponentResources[component]
+		if ok && (len(existing.Requests) > 0 || len(existing.Limits) > 0) {
+			continue
+		}
+		iop.Spec.ComponentResources[component] = defaults
+		webhookDefaultsInjectedTotal.WithLabelValues(component).Inc()
+		log.V(2).Info("Injected default resource requests/limits", "component", component, "resources", defaults)
+	}
+}
+
+// LoadDefaultResources parses the "resources.json" key of a ConfigMap named
+// istio-operator-defaults (the shape AddDefaultingWebhookToManager expects)
+// into a component -> ResourceRequirements map suitable for
+// IstioOperatorDefaulter.DefaultResources.
+func LoadDefaultResources(data map[string]string) (map[string]corev1.ResourceRequirements, error) {
+	raw, ok := data["resources.json"]
+	if !ok {
+		return nil, fmt.Errorf(`istio-operator-defaults ConfigMap has no "resources.json" key`)
+	}
+	defaults := map[string]corev1.ResourceRequirements{}
+	if err := json.Unmarshal([]byte(raw), &defaults); err != nil {
+		return nil, fmt.Errorf("parsing istio-operator-defaults resources.json: %w", err)
+	}
+	return defaults, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestIstioOperatorDefaulterApplyDefaults(t *testing.T) {
+	defaults := map[string]corev1.ResourceRequirements{
+		"istiod": {
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+		},
+		"istio-ingressgateway": {
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")},
+		},
+	}
+	d := &IstioOperatorDefaulter{DefaultResources: defaults}
+
+	iop := &v1alpha1.IstioOperator{
+		Spec: v1alpha1.IstioOperatorSpec{
+			ComponentResources: map[string]corev1.ResourceRequirements{
+				"istiod": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+			},
+		},
+	}
+	d.ApplyDefaults(iop)
+
+	if got := iop.Spec.ComponentResources["istiod"].Requests.Cpu().String(); got != "1" {
+		t.Errorf("ComponentResources[istiod] = %s, want untouched value 1 (already had requests set)", got)
+	}
+	if _, ok := iop.Spec.ComponentResources["istio-ingressgateway"]; !ok {
+		t.Fatal("ComponentResources[istio-ingressgateway] was not defaulted")
+	}
+	if got := iop.Spec.ComponentResources["istio-ingressgateway"].Requests.Cpu().String(); got != "50m" {
+		t.Errorf("ComponentResources[istio-ingressgateway] Requests.Cpu = %s, want 50m", got)
+	}
+}
+
+func TestIstioOperatorDefaulterHandlePatchesMissingResources(t *testing.T) {
+	d := &IstioOperatorDefaulter{
+		DefaultResources: map[string]corev1.ResourceRequirements{
+			"istiod": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}},
+		},
+	}
+	iop := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Profile: "default"}}
+	raw, err := json.Marshal(iop)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+
+	resp := d.Handle(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	})
+	if !resp.Allowed {
+		t.Fatalf("Handle() = denied, want allowed; result: %+v", resp.Result)
+	}
+	if len(resp.Patches) == 0 {
+		t.Fatal("Handle() produced no patches, want a patch injecting istiod's default resources")
+	}
+}
+
+func TestLoadDefaultResources(t *testing.T) {
+	data := map[string]string{
+		"resources.json": `{"istiod":{"requests":{"cpu":"100m"}}}`,
+	}
+	defaults, err := LoadDefaultResources(data)
+	if err != nil {
+		t.Fatalf("LoadDefaultResources() = %v", err)
+	}
+	if got := defaults["istiod"].Requests.Cpu().String(); got != "100m" {
+		t.Errorf("defaults[istiod].Requests.Cpu = %s, want 100m", got)
+	}
+
+	if _, err := LoadDefaultResources(map[string]string{}); err == nil {
+		t.Fatal("LoadDefaultResources() with no resources.json key = nil error, want an error")
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+// egressGatewayWebhookPath is where AddEgressGatewayValidatorToManager
+// registers EgressGatewayPolicyEnforcer, and where
+// RegisterEgressGatewayValidatingWebhookConfiguration points the
+// ValidatingWebhookConfiguration it creates/updates.
+const egressGatewayWebhookPath = "/validate-networking-istio-io-v1beta1-serviceentry"
+
+// egressGatewayWebhookName identifies both the EgressGatewayPolicyEnforcer
+// webhook entry and the ValidatingWebhookConfiguration
+// RegisterEgressGatewayValidatingWebhookConfiguration creates/updates.
+const egressGatewayWebhookName = "serviceentry-egressgateway.istio.istio.io"
+
+// egressGatewayName is the Gateway name EgressGatewayPolicyEnforcer looks
+// for in a VirtualService's spec.gateways, matching the default Gateway
+// name the istio-egressgateway component installs.
+const egressGatewayName = "istio-egressgateway"
+
+// meshConfigNamespace is the namespace EgressGatewayPolicyEnforcer reads
+// ConfigMap/istio's mesh config from. ServiceEntries can live in any
+// namespace, so unlike the istiooperator controller (which reads this
+// ConfigMap out of the IstioOperator's own namespace) this webhook has to
+// assume Istio's conventional install namespace rather than deriving it
+// from the admission request.
+const meshConfigNamespace = "istio-system"
+
+// meshConfigMapName is the ConfigMap every Istio component reads mesh
+// config from, the same one pkg/controller/istiooperator's
+// ExternalControlPlaneReconciler and TrustDomainMigration mutate.
+const meshConfigMapName = "istio"
+
+// egressGatewayRemediationDocsURL is linked from the warning
+// EgressGatewayPolicyEnforcer returns, pointing at Istio's own egress
+// gateway walkthrough rather than anything this operator maintains.
+const egressGatewayRemediationDocsURL = "https://istio.io/latest/docs/tasks/traffic-management/egress/egress-gateway/"
+
+var (
+	serviceEntryGVK   = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "ServiceEntry"}
+	virtualServiceGVK = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "VirtualService"}
+)
+
+// EgressGatewayPolicyEnforcer implements admission.Handler. When the mesh's
+// outboundTrafficPolicy.mode is REGISTRY_ONLY, a ServiceEntry that isn't
+// routed through the egress gateway by any VirtualService lets its hosts'
+// traffic slip past the gateway's egress controls, usually by accident
+// rather than intent. Handle can't tell which case it is, so it warns
+// instead of rejecting: the admission response carries the missing
+// VirtualService's would-be name and a remediation link, but the
+// ServiceEntry is always admitted.
+type EgressGatewayPolicyEnforcer struct {
+	Client client.Client
+}
+
+// Handle decodes req's object as a ServiceEntry, and, only when the mesh
+// requires REGISTRY_ONLY egress, warns if none of its hosts are routed
+// through egressGatewayName by an existing VirtualService.
+func (e *EgressGatewayPolicyEnforcer) Handle(ctx context.Context, req admission.Request) admission.Response {
+	se := &unstructured.Unstructured{}
+	if err := json.Unmarshal(req.Object.Raw, se); err != nil {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("decoding ServiceEntry: %w", err))
+	}
+
+	registryOnly, err := e.outboundTrafficPolicyIsRegistryOnly(ctx)
+	if err != nil {
+		log.Error(err, "Failed to read mesh config outboundTrafficPolicy; skipping egress gateway routing check")
+		return admission.Allowed("")
+	}
+	if !registryOnly {
+		return admission.Allowed("")
+	}
+
+	hosts, _, _ := unstructured.NestedStringSlice(se.Object, "spec", "hosts")
+	if len(hosts) == 0 {
+		return admission.Allowed("")
+	}
+
+	routed, err := e.hostsRoutedThroughEgressGateway(ctx, hosts)
+	if err != nil {
+		log.Error(err, "Failed to list VirtualServices for egress gateway routing check")
+		return admission.Allowed("")
+	}
+	if routed {
+		return admission.Allowed("")
+	}
+
+	return admission.Allowed("").WithWarnings(fmt.Sprintf(
+		"ServiceEntry %q hosts %v are not routed through the %s gateway, but outboundTrafficPolicy.mode is REGISTRY_ONLY: traffic to these hosts may bypass egress controls. Add a VirtualService with spec.gateways including %q routing these hosts through the gateway; see %s",
+		se.GetName(), hosts, egressGatewayName, egressGatewayName, egressGatewayRemediationDocsURL))
+}
+
+// outboundTrafficPolicyIsRegistryOnly reports whether ConfigMap/istio's mesh
+// config has outboundTrafficPolicy.mode set to REGISTRY_ONLY, treating a
+// missing ConfigMap the same as ALLOW_ANY (Istio's own default) rather than
+// an error.
+func (e *EgressGatewayPolicyEnforcer) outboundTrafficPolicyIsRegistryOnly(ctx context.Context) (bool, error) {
+	cm := &corev1.ConfigMap{}
+	err := e.Client.Get(ctx, client.ObjectKey{Namespace: meshConfigNamespace, Name: meshConfigMapName}, cm)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("getting ConfigMap %s: %w", meshConfigMapName, err)
+	}
+
+	mesh := struct {
+		OutboundTrafficPolicy struct {
+			Mode string `json:"mode,omitempty"`
+		} `json:"outboundTrafficPolicy,omitempty"`
+	}{}
+	if err := yaml.Unmarshal([]byte(cm.Data["mesh"]), &mesh); err != nil {
+		return false, fmt.Errorf("unmarshaling mesh config: %w", err)
+	}
+	return mesh.OutboundTrafficPolicy.Mode == "REGISTRY_ONLY", nil
+}
+
+// hostsRoutedThroughEgressGateway reports whether any VirtualService whose
+// spec.gateways includes egressGatewayName also lists one of hosts in its
+// own spec.hosts. This is an approximation of "routed through the gateway"
+// good enough for a warning: it doesn't follow the VirtualService's actual
+// route rules or confirm they forward to the gateway's Service, which would
+// need the same mesh config rendering istio.io/istio's own package would
+// give pkg/controller/istiooperator's mesh config helpers.
+func (e *EgressGatewayPolicyEnforcer) hostsRoutedThroughEgressGateway(ctx context.Context, hosts []string) (bool, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(virtualServiceGVK)
+	if err := e.Client.List(ctx, list); err != nil {
+		return false, fmt.Errorf("listing VirtualServices: %w", err)
+	}
+
+	for i := range list.Items {
+		gateways, _, _ := unstructured.NestedStringSlice(list.Items[i].Object, "spec", "gateways")
+		if !containsString(gateways, egressGatewayName) {
+			continue
+		}
+		vsHosts, _, _ := unstructured.NestedStringSlice(list.Items[i].Object, "spec", "hosts")
+		for _, host := range hosts {
+			if containsString(vsHosts, host) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// AddEgressGatewayValidatorToManager registers an EgressGatewayPolicyEnforcer
+// backed by mgr's own client on mgr's webhook server at
+// egressGatewayWebhookPath, the same path
+// RegisterEgressGatewayValidatingWebhookConfiguration's
+// ValidatingWebhookConfiguration routes admission requests to.
+func AddEgressGatewayValidatorToManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(egressGatewayWebhookPath, &crwebhook.Admission{
+		Handler: &EgressGatewayPolicyEnforcer{Client: mgr.GetClient()},
+	})
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newFakeEgressGatewayClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	scheme.AddKnownTypeWithName(virtualServiceGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(virtualServiceGVK.GroupVersion().WithKind("VirtualServiceList"), &unstructured.UnstructuredList{})
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func serviceEntryRequest(t *testing.T, name string, hosts []string) admission.Request {
+	t.Helper()
+	se := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": serviceEntryGVK.GroupVersion().String(),
+		"kind":       serviceEntryGVK.Kind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": "shop"},
+		"spec":       map[string]interface{}{"hosts": toInterfaceSlice(hosts)},
+	}}
+	raw, err := json.Marshal(se)
+	if err != nil {
+		t.Fatalf("Marshal() = %v", err)
+	}
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: raw}}}
+}
+
+func toInterfaceSlice(ss []string) []interface{} {
+	out := make([]interface{}, len(ss))
+	for i, s := range ss {
+		out[i] = s
+	}
+	return out
+}
+
+func meshConfigMap(outboundMode string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: meshConfigNamespace, Name: meshConfigMapName},
+		Data:       map[string]string{"mesh": "outboundTrafficPolicy:\n  mode: " + outboundMode + "\n"},
+	}
+}
+
+func TestEgressGatewayPolicyEnforcerWarnsWhenHostNotRouted(t *testing.T) {
+	c := newFakeEgressGatewayClient(t, meshConfigMap("REGISTRY_ONLY"))
+	e := &EgressGatewayPolicyEnforcer{Client: c}
+
+	resp := e.Handle(context.Background(), serviceEntryRequest(t, "payments-api", []string{"payments.example.com"}))
+
+	if !resp.Allowed {
+		t.Fatalf("Handle() Allowed = false, want a warning rather than a rejection")
+	}
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("Handle() Warnings = %v, want exactly one warning", resp.Warnings)
+	}
+}
+
+func TestEgressGatewayPolicyEnforcerAllowsWhenRoutedThroughGateway(t *testing.T) {
+	vs := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": virtualServiceGVK.GroupVersion().String(),
+		"kind":       virtualServiceGVK.Kind,
+		"metadata":   map[string]interface{}{"name": "payments-egress", "namespace": "istio-system"},
+		"spec": map[string]interface{}{
+			"hosts":    []interface{}{"payments.example.com"},
+			"gateways": []interface{}{egressGatewayName},
+		},
+	}}
+	c := newFakeEgressGatewayClient(t, meshConfigMap("REGISTRY_ONLY"), vs)
+	e := &EgressGatewayPolicyEnforcer{Client: c}
+
+	resp := e.Handle(context.Background(), serviceEntryRequest(t, "payments-api", []string{"payments.example.com"}))
+
+	if !resp.Allowed {
+		t.Fatalf("Handle() Allowed = false, want true")
+	}
+	if len(resp.Warnings) != 0 {
+		t.Errorf("Handle() Warnings = %v, want none when a VirtualService already routes the host through the egress gateway", resp.Warnings)
+	}
+}
+
+func TestEgressGatewayPolicyEnforcerSkipsWhenNotRegistryOnly(t *testing.T) {
+	c := newFakeEgressGatewayClient(t, meshConfigMap("ALLOW_ANY"))
+	e := &EgressGatewayPolicyEnforcer{Client: c}
+
+	resp := e.Handle(context.Background(), serviceEntryRequest(t, "payments-api", []string{"payments.example.com"}))
+
+	if !resp.Allowed {
+		t.Fatalf("Handle() Allowed = false, want true")
+	}
+	if len(resp.Warnings) != 0 {
+		t.Errorf("Handle() Warnings = %v, want none when outboundTrafficPolicy.mode isn't REGISTRY_ONLY", resp.Warnings)
+	}
+}
+
+func TestEgressGatewayPolicyEnforcerSkipsWithoutMeshConfigMap(t *testing.T) {
+	c := newFakeEgressGatewayClient(t)
+	e := &EgressGatewayPolicyEnforcer{Client: c}
+
+	resp := e.Handle(context.Background(), serviceEntryRequest(t, "payments-api", []string{"payments.example.com"}))
+
+	if !resp.Allowed {
+		t.Fatalf("Handle() Allowed = false, want true")
+	}
+	if len(resp.Warnings) != 0 {
+		t.Errorf("Handle() Warnings = %v, want none when ConfigMap/istio doesn't exist", resp.Warnings)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+	"github.com/InnocentK/istio-operator/pkg/controller"
+)
+
+// webhookName identifies both the IstioOperatorValidator webhook entry and
+// the ValidatingWebhookConfiguration RegisterValidatingWebhookConfiguration
+// creates/updates.
+const webhookName = "istiooperator.istio.istio.io"
+
+// inClusterCAFile is where every pod's service account mounts its
+// namespace's cluster CA bundle, used to populate CABundle so kube-apiserver
+// trusts the webhook server's certificate without an operator needing to
+// template the bundle into its manifests by hand.
+const inClusterCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+// mutatingWebhookName identifies both the IstioOperatorDefaulter webhook
+// entry and the MutatingWebhookConfiguration
+// RegisterMutatingWebhookConfiguration creates/updates.
+const mutatingWebhookName = "istiooperator-defaulting.istio.istio.io"
+
+// AddToManager registers IstioOperatorValidator on mgr's webhook server at
+// webhookPath, the same path RegisterValidatingWebhookConfiguration's
+// ValidatingWebhookConfiguration routes admission requests to.
+func AddToManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(webhookPath, &crwebhook.Admission{Handler: &IstioOperatorValidator{}})
+	return nil
+}
+
+// AddDefaultingWebhookToManager registers an IstioOperatorDefaulter backed by
+// defaultResources on mgr's webhook server at defaultingWebhookPath, the same
+// path RegisterMutatingWebhookConfiguration's MutatingWebhookConfiguration
+// routes admission requests to.
+func AddDefaultingWebhookToManager(mgr ctrl.Manager, defaultResources map[string]corev1.ResourceRequirements) error {
+	mgr.GetWebhookServer().Register(defaultingWebhookPath, &crwebhook.Admission{
+		Handler: &IstioOperatorDefaulter{DefaultResources: defaultResources},
+	})
+	return nil
+}
+
+// RegisterValidatingWebhookConfiguration server-side applies the
+// ValidatingWebhookConfiguration that routes IstioOperator Create/Update
+// admission requests to serviceName.namespace's webhookPath, so operators
+// don't need to ship the configuration as a separate manifest that can
+// drift from the binary's actual validation logic. CABundle is populated by
+// reading caFile, which defaults to inClusterCAFile — the pod's own service
+// account CA, the same CA kube-apiserver already trusts for any cert this
+// operator's own service account could request — when empty. Using
+// client.Apply under controller.FieldOwner, rather than a get-then-
+// create-or-update, means this operator only ever owns the fields it sets
+// here; client.ForceOwnership reclaims any of them still held by an older,
+// pre-SSA field manager after an upgrade.
+func RegisterValidatingWebhookConfiguration(ctx context.Context, c client.Client, namespace, serviceName, caFile string) error {
+	if caFile == "" {
+		caFile = inClusterCAFile
+	}
+	caBundle, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("reading CA bundle %s: %w", caFile, err)
+	}
+
+	failurePolicy := admissionregistrationv1.Fail
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	path := webhookPath
+	port := int32(443)
+
+	desired := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionregistrationv1.SchemeGroupVersion.String(),
+			Kind:       "ValidatingWebhookConfiguration",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: webhookName},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{
+				Name: webhookName,
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					Service: &admissionregistrationv1.ServiceReference{
+						Namespace: namespace,
+						Name:      serviceName,
+						Path:      &path,
+						Port:      &port,
+					},
+					CABundle: caBundle,
+				},
+				Rules: []admissionregistrationv1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{v1alpha1.SchemeGroupVersion.Group},
+							APIVersions: []string{v1alpha1.SchemeGroupVersion.Version},
+							Resources:   []string{"istiooperators"},
+						},
+					},
+				},
+				FailurePolicy:           &failurePolicy,
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+
+	if err := c.Patch(ctx, desired, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying ValidatingWebhookConfiguration %s: %w", webhookName, err)
+	}
+	return nil
+}
+
+// RegisterMutatingWebhookConfiguration server-side applies the
+// MutatingWebhookConfiguration that routes IstioOperator Create/Update
+// admission requests to serviceName.namespace's defaultingWebhookPath, the
+// mutating counterpart to RegisterValidatingWebhookConfiguration. CABundle is
+// populated the same way, from caFile or inClusterCAFile when caFile is
+// empty. FailurePolicy is Ignore rather than Fail: a missed resource default
+// shouldn't block every IstioOperator apply if the webhook server is
+// temporarily unreachable, unlike a missed validation. Applied the same way
+// as RegisterValidatingWebhookConfiguration, under controller.FieldOwner
+// with client.ForceOwnership.
+func RegisterMutatingWebhookConfiguration(ctx context.Context, c client.Client, namespace, serviceName, caFile string) error {
+	if caFile == "" {
+		caFile = inClusterCAFile
+	}
+	caBundle, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("reading CA bundle %s: %w", caFile, err)
+	}
+
+	failurePolicy := admissionregistrationv1.Ignore
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	path := defaultingWebhookPath
+	port := int32(443)
+
+	desired := &admissionregistrationv1.MutatingWebhookConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionregistrationv1.SchemeGroupVersion.String(),
+			Kind:       "MutatingWebhookConfiguration",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: mutatingWebhookName},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{
+				Name: mutatingWebhookName,
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					Service: &admissionregistrationv1.ServiceReference{
+						Namespace: namespace,
+						Name:      serviceName,
+						Path:      &path,
+						Port:      &port,
+					},
+					CABundle: caBundle,
+				},
+				Rules: []admissionregistrationv1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{v1alpha1.SchemeGroupVersion.Group},
+							APIVersions: []string{v1alpha1.SchemeGroupVersion.Version},
+							Resources:   []string{"istiooperators"},
+						},
+					},
+				},
+				FailurePolicy:           &failurePolicy,
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+
+	if err := c.Patch(ctx, desired, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying MutatingWebhookConfiguration %s: %w", mutatingWebhookName, err)
+	}
+	return nil
+}
+
+// RegisterEgressGatewayValidatingWebhookConfiguration server-side applies
+// the ValidatingWebhookConfiguration that routes ServiceEntry Create/Update
+// admission requests to serviceName.namespace's egressGatewayWebhookPath,
+// the ServiceEntry counterpart to RegisterValidatingWebhookConfiguration.
+// CABundle is populated the same way, from caFile or inClusterCAFile when
+// caFile is empty. FailurePolicy is Ignore, unlike
+// RegisterValidatingWebhookConfiguration's Fail: EgressGatewayPolicyEnforcer
+// only ever warns, so a webhook server that's temporarily unreachable
+// should never block a ServiceEntry apply.
+func RegisterEgressGatewayValidatingWebhookConfiguration(ctx context.Context, c client.Client, namespace, serviceName, caFile string) error {
+	if caFile == "" {
+		caFile = inClusterCAFile
+	}
+	caBundle, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("reading CA bundle %s: %w", caFile, err)
+	}
+
+	failurePolicy := admissionregistrationv1.Ignore
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	path := egressGatewayWebhookPath
+	port := int32(443)
+
+	desired := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionregistrationv1.SchemeGroupVersion.String(),
+			Kind:       "ValidatingWebhookConfiguration",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: egressGatewayWebhookName},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{
+				Name: egressGatewayWebhookName,
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					Service: &admissionregistrationv1.ServiceReference{
+						Namespace: namespace,
+						Name:      serviceName,
+						Path:      &path,
+						Port:      &port,
+					},
+					CABundle: caBundle,
+				},
+				Rules: []admissionregistrationv1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{serviceEntryGVK.Group},
+							APIVersions: []string{serviceEntryGVK.Version},
+							Resources:   []string{"serviceentries"},
+						},
+					},
+				},
+				FailurePolicy:           &failurePolicy,
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+
+	if err := c.Patch(ctx, desired, client.Apply, client.ForceOwnership, client.FieldOwner(controller.FieldOwner)); err != nil {
+		return fmt.Errorf("applying ValidatingWebhookConfiguration %s: %w", egressGatewayWebhookName, err)
+	}
+	return nil
+}
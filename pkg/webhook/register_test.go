@@ -0,0 +1,89 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := admissionregistrationv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme() = %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func writeCAFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("os.WriteFile() = %v", err)
+	}
+	return path
+}
+
+func TestRegisterValidatingWebhookConfigurationCreatesWhenMissing(t *testing.T) {
+	caFile := writeCAFile(t, "fake-ca-bundle")
+	c := newFakeClient(t)
+
+	if err := RegisterValidatingWebhookConfiguration(context.Background(), c, "istio-system", "istiooperator-webhook", caFile); err != nil {
+		t.Fatalf("RegisterValidatingWebhookConfiguration() = %v", err)
+	}
+
+	got := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: webhookName}, got); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if len(got.Webhooks) != 1 {
+		t.Fatalf("len(Webhooks) = %d, want 1", len(got.Webhooks))
+	}
+	if string(got.Webhooks[0].ClientConfig.CABundle) != "fake-ca-bundle" {
+		t.Fatalf("CABundle = %q, want %q", got.Webhooks[0].ClientConfig.CABundle, "fake-ca-bundle")
+	}
+}
+
+func TestRegisterValidatingWebhookConfigurationUpdatesExisting(t *testing.T) {
+	existing := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: webhookName},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: webhookName, ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("stale-ca-bundle")}},
+		},
+	}
+	c := newFakeClient(t, existing)
+
+	caFile := writeCAFile(t, "rotated-ca-bundle")
+	if err := RegisterValidatingWebhookConfiguration(context.Background(), c, "istio-system", "istiooperator-webhook", caFile); err != nil {
+		t.Fatalf("RegisterValidatingWebhookConfiguration() = %v", err)
+	}
+
+	got := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: webhookName}, got); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if string(got.Webhooks[0].ClientConfig.CABundle) != "rotated-ca-bundle" {
+		t.Fatalf("CABundle = %q, want %q", got.Webhooks[0].ClientConfig.CABundle, "rotated-ca-bundle")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook implements admission validation for the IstioOperator CR
+// and registration of the ValidatingWebhookConfiguration that routes to it.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+// revisionPattern matches the characters Kubernetes allows in a label value,
+// since ValidateSpec rejects a Revision that istiooperator.RevisionLabels
+// couldn't legally stamp onto a resource as istio.io/rev's value.
+var revisionPattern = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+
+// webhookPath is where AddToManager registers IstioOperatorValidator on the
+// manager's webhook server, and where RegisterValidatingWebhookConfiguration
+// points the ValidatingWebhookConfiguration it creates/updates.
+const webhookPath = "/validate-istio-istio-io-v1alpha1-istiooperator"
+
+// IstioOperatorValidator implements admission.Handler, rejecting
+// IstioOperator manifests whose spec fails ValidateSpec with a
+// human-readable message instead of letting them reach the apiserver and
+// fail later during reconciliation.
+type IstioOperatorValidator struct{}
+
+// Handle decodes req's object as an IstioOperator and runs ValidateSpec
+// against it.
+func (v *IstioOperatorValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	iop := &v1alpha1.IstioOperator{}
+	if err := json.Unmarshal(req.Object.Raw, iop); err != nil {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("decoding IstioOperator: %w", err))
+	}
+	if err := ValidateSpec(&iop.Spec); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// ValidateSpec checks spec for values IstioOperatorValidator rejects. It
+// only validates the fields IstioOperatorSpec currently declares; a full
+// pass over mesh config keys and traffic management settings belongs to
+// istio.io/istio's own config/validation package once this operator vendors
+// it, with ValidateSpec as the seam that integration would extend.
+func ValidateSpec(spec *v1alpha1.IstioOperatorSpec) error {
+	switch spec.Profile {
+	case "", "default", "minimal", "demo", "remote", "empty", "preview", "external", "ambient":
+	default:
+		return fmt.Errorf("invalid profile %q: must be one of \"default\", \"minimal\", \"demo\", \"remote\", \"empty\", \"preview\", \"external\", \"ambient\" or empty", spec.Profile)
+	}
+	if spec.Revision != "" && !revisionPattern.MatchString(spec.Revision) {
+		return fmt.Errorf("invalid revision %q: must be a valid Kubernetes label value", spec.Revision)
+	}
+	if spec.Profile == "external" && (spec.ExternalControlPlane == nil || spec.ExternalControlPlane.Address == "") {
+		return fmt.Errorf("profile %q requires externalControlPlane.address", spec.Profile)
+	}
+	if overlap := sidecarAmbientNamespaceOverlap(spec); overlap != "" {
+		return fmt.Errorf("namespace %q cannot appear in both sidecarInjection.enabledNamespaces and ambient.namespaces: a namespace runs one Istio dataplane or the other", overlap)
+	}
+	return nil
+}
+
+// sidecarAmbientNamespaceOverlap returns a namespace present in both
+// spec.SidecarInjection.EnabledNamespaces and spec.Ambient.Namespaces, or ""
+// if there is none. A nil SidecarInjection or Ambient has no namespaces to
+// overlap.
+func sidecarAmbientNamespaceOverlap(spec *v1alpha1.IstioOperatorSpec) string {
+	if spec.SidecarInjection == nil || spec.Ambient == nil {
+		return ""
+	}
+
+	sidecarNamespaces := make(map[string]bool, len(spec.SidecarInjection.EnabledNamespaces))
+	for _, name := range spec.SidecarInjection.EnabledNamespaces {
+		sidecarNamespaces[name] = true
+	}
+	for _, name := range spec.Ambient.Namespaces {
+		if sidecarNamespaces[name] {
+			return name
+		}
+	}
+	return ""
+}
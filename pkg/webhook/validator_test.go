@@ -0,0 +1,137 @@
+// Copyright 2019 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/InnocentK/istio-operator/pkg/apis/istio/v1alpha1"
+)
+
+func TestValidateSpec(t *testing.T) {
+	tests := []struct {
+		profile string
+		wantErr bool
+	}{
+		{profile: "", wantErr: false},
+		{profile: "default", wantErr: false},
+		{profile: "minimal", wantErr: false},
+		{profile: "demo", wantErr: false},
+		{profile: "remote", wantErr: false},
+		{profile: "empty", wantErr: false},
+		{profile: "preview", wantErr: false},
+		{profile: "ambient", wantErr: false},
+		{profile: "bogus", wantErr: true},
+		{profile: "external", wantErr: true}, // missing externalControlPlane.address
+	}
+	for _, tc := range tests {
+		err := ValidateSpec(&v1alpha1.IstioOperatorSpec{Profile: tc.profile})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ValidateSpec(profile=%q) error = %v, wantErr %v", tc.profile, err, tc.wantErr)
+		}
+	}
+}
+
+func TestValidateSpecExternalControlPlane(t *testing.T) {
+	spec := &v1alpha1.IstioOperatorSpec{
+		Profile:              "external",
+		ExternalControlPlane: &v1alpha1.ExternalControlPlaneSpec{Address: "istiod.external.example.com:15012"},
+	}
+	if err := ValidateSpec(spec); err != nil {
+		t.Fatalf("ValidateSpec() = %v, want nil for a fully configured external profile", err)
+	}
+}
+
+func TestValidateSpecRejectsSidecarAmbientNamespaceOverlap(t *testing.T) {
+	spec := &v1alpha1.IstioOperatorSpec{
+		Profile:          "ambient",
+		SidecarInjection: &v1alpha1.SidecarInjectionSpec{EnabledNamespaces: []string{"shop"}},
+		Ambient:          &v1alpha1.AmbientSpec{Namespaces: []string{"shop"}},
+	}
+	if err := ValidateSpec(spec); err == nil {
+		t.Fatal("ValidateSpec() = nil, want an error for a namespace listed in both sidecarInjection.enabledNamespaces and ambient.namespaces")
+	}
+}
+
+func TestValidateSpecAllowsDisjointSidecarAndAmbientNamespaces(t *testing.T) {
+	spec := &v1alpha1.IstioOperatorSpec{
+		Profile:          "ambient",
+		SidecarInjection: &v1alpha1.SidecarInjectionSpec{EnabledNamespaces: []string{"legacy"}},
+		Ambient:          &v1alpha1.AmbientSpec{Namespaces: []string{"shop"}},
+	}
+	if err := ValidateSpec(spec); err != nil {
+		t.Fatalf("ValidateSpec() = %v, want nil for disjoint sidecar and ambient namespaces", err)
+	}
+}
+
+func TestValidateSpecRevision(t *testing.T) {
+	tests := []struct {
+		revision string
+		wantErr  bool
+	}{
+		{revision: "", wantErr: false},
+		{revision: "stable", wantErr: false},
+		{revision: "canary-1-18", wantErr: false},
+		{revision: "a", wantErr: false},
+		{revision: "-leading-dash", wantErr: true},
+		{revision: "trailing-dash-", wantErr: true},
+		{revision: "has a space", wantErr: true},
+		{revision: "has/slash", wantErr: true},
+	}
+	for _, tc := range tests {
+		err := ValidateSpec(&v1alpha1.IstioOperatorSpec{Revision: tc.revision})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ValidateSpec(revision=%q) error = %v, wantErr %v", tc.revision, err, tc.wantErr)
+		}
+	}
+}
+
+func TestIstioOperatorValidatorHandle(t *testing.T) {
+	iop := &v1alpha1.IstioOperator{Spec: v1alpha1.IstioOperatorSpec{Profile: "default"}}
+	raw, err := json.Marshal(iop)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+
+	v := &IstioOperatorValidator{}
+	resp := v.Handle(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	})
+	if !resp.Allowed {
+		t.Fatalf("Handle() with a valid profile = denied, want allowed; result: %+v", resp.Result)
+	}
+
+	iop.Spec.Profile = "bogus"
+	raw, err = json.Marshal(iop)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	resp = v.Handle(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	})
+	if resp.Allowed {
+		t.Fatal("Handle() with an invalid profile = allowed, want denied")
+	}
+}